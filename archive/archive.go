@@ -2,63 +2,544 @@ package archive
 
 import (
 	"fmt"
+	createar "futile/archive/create/ar"
+	createbzip2 "futile/archive/create/bzip2"
+	createcpio "futile/archive/create/cpio"
+	createdeb "futile/archive/create/deb"
+	creategzip "futile/archive/create/gzip"
+	createiso9660 "futile/archive/create/iso9660"
+	createjar "futile/archive/create/jar"
+	createlz4 "futile/archive/create/lz4"
 	createrar "futile/archive/create/rar"
 	createsevenzip "futile/archive/create/sevenzip"
 	createTar "futile/archive/create/tar"
+	createxz "futile/archive/create/xz"
 	createzip "futile/archive/create/zip"
+	createzstd "futile/archive/create/zstd"
+	extractar "futile/archive/extract/ar"
+	extractbzip2 "futile/archive/extract/bzip2"
+	extractcab "futile/archive/extract/cab"
+	extractcpio "futile/archive/extract/cpio"
+	extractdeb "futile/archive/extract/deb"
+	extractgzip "futile/archive/extract/gzip"
+	extractiso9660 "futile/archive/extract/iso9660"
+	extractjar "futile/archive/extract/jar"
+	extractlz4 "futile/archive/extract/lz4"
+	extractoci "futile/archive/extract/oci"
 	extractrar "futile/archive/extract/rar"
+	extractrpm "futile/archive/extract/rpm"
 	extractsevenzip "futile/archive/extract/sevenzip"
+	extractsquashfs "futile/archive/extract/squashfs"
 	extractTar "futile/archive/extract/tar"
+	extractxz "futile/archive/extract/xz"
 	extractzip "futile/archive/extract/zip"
+	extractzstd "futile/archive/extract/zstd"
+	"futile/compress/lz4"
+	"futile/compress/zstd"
+	"futile/formats/ar"
+	"futile/formats/cpio"
+	"futile/formats/jar"
+	"futile/formats/zip"
 	"futile/utils"
+	"strings"
 )
 
+// DefaultLevel asks each compressor to use its own default compression level.
+const DefaultLevel = -1
+
+// DefaultDictSize is the size of dictionaries built by HandleTrain unless overridden.
+const DefaultDictSize = createzstd.DefaultDictSize
+
+// Options carries the settings shared by the extract and create operations.
+type Options struct {
+	Password string // Password for password-protected archives
+	Level    int    // Compression level, or DefaultLevel
+	Threads  int    // Worker threads for compressors that support them
+	Long     bool   // Long-distance matching for Zstandard
+	Dict     string // Zstandard dictionary file
+
+	BlockSize         int  // LZ4 block maximum size in bytes, or 0 for the default
+	BlockChecksum     bool // Add a checksum to every LZ4 block
+	NoContentChecksum bool // Omit the LZ4 content checksum
+	DependentBlocks   bool // Let LZ4 blocks reference earlier blocks
+
+	TarFormat     string // tar flavor: ustar, pax or gnu
+	CpioFormat    string // cpio header format: newc, crc or odc
+	ArFormat      string // ar long name style: gnu or bsd
+	Deterministic bool   // Zero timestamps and owners for reproducible archives
+
+	Control        string // Debian control file for building packages
+	DebCompression string // Debian package tarball compression: gz, xz, zst or none
+
+	VolumeLabel string // Volume label of ISO 9660 images
+
+	RarFormat  int   // RAR format version: 4 or 5, or 0 for the default
+	Recovery   int   // RAR recovery record size in percent, or 0 for none
+	VolumeSize int64 // Split archives into volumes of this many bytes, or 0 for one file
+	Lock       bool  // Lock RAR archives against further changes
+
+	Zip64         string // When to write Zip64 records in ZIP archives: auto, always or never
+	ZipEncryption string // Encryption of password-protected ZIP archives: aes256, aes192 or aes128
+	ZipCrypto     bool   // Encrypt ZIP archives with legacy ZipCrypto instead, for old tools
+
+	MainClass     string   // Main-Class of Java archives
+	ClassPath     string   // Class-Path of Java archives
+	ManifestAttrs []string // Further manifest attributes of Java archives, as "Name=Value"
+
+	SFX        bool   // Create a self-extracting archive
+	SFXCommand string // Command self-extracting archives run once extracted
+	SFXStub    string // Extractor of self-extracting archives, or "" for the embedded one
+
+	Platform string // Platform of the image to flatten, such as linux/arm64, or "" for this machine's
+}
+
+// splitTypes lists the archive types that can be split into volumes.
+var splitTypes = map[string]bool{
+	"zip": true, "rar": true, "7z": true,
+	"tar": true, "tar.gz": true, "tar.bz2": true, "tar.xz": true, "tar.zst": true, "tar.lz4": true,
+}
+
+// lz4Options translates the shared options into LZ4 writer settings.
+func lz4Options(opts Options) lz4.WriterOptions {
+	return lz4.WriterOptions{
+		Level:             opts.Level,
+		BlockSize:         opts.BlockSize,
+		BlockChecksum:     opts.BlockChecksum,
+		NoContentChecksum: opts.NoContentChecksum,
+		DependentBlocks:   opts.DependentBlocks,
+	}
+}
+
+// manifestAttributes parses the "Name=Value" manifest attributes of the options.
+func manifestAttributes(opts Options) ([]jar.Attribute, error) {
+	var attributes []jar.Attribute
+	for _, attr := range opts.ManifestAttrs {
+		name, value, ok := strings.Cut(attr, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid manifest attribute %q; use Name=Value", attr)
+		}
+		attributes = append(attributes, jar.Attribute{Name: name, Value: value})
+	}
+	return attributes, nil
+}
+
+// zstdDicts loads the dictionary named in the options, if any.
+func zstdDicts(opts Options) ([]*zstd.Dict, error) {
+	if opts.Dict == "" {
+		return nil, nil
+	}
+	dict, err := createzstd.LoadDict(opts.Dict)
+	if err != nil {
+		return nil, err
+	}
+	return []*zstd.Dict{dict}, nil
+}
+
+// zstdOptions translates the shared options into Zstandard writer settings.
+func zstdOptions(opts Options) (zstd.WriterOptions, error) {
+	dicts, err := zstdDicts(opts)
+	if err != nil {
+		return zstd.WriterOptions{}, err
+	}
+	zopts := zstd.WriterOptions{Level: opts.Level, Threads: opts.Threads, Long: opts.Long}
+	if len(dicts) > 0 {
+		zopts.Dict = dicts[0]
+	}
+	return zopts, nil
+}
+
 // HandleExtract determines the archive type and calls the appropriate extraction function.
-func HandleExtract(src, dest, password string) error {
+func HandleExtract(src, dest string, opts Options) error {
+	password := opts.Password
+
+	if t, err := readSFX(src); err != nil || t != nil {
+		if err != nil {
+			return err
+		}
+		return withPayload(src, t, func(payload string) error {
+			return HandleExtract(payload, dest, opts)
+		})
+	}
+
 	archiveType, err := utils.DetermineArchiveType(src)
 	if err != nil {
 		return fmt.Errorf("could not determine archive type: %w", err)
 	}
 
 	switch archiveType {
-	case "zip":
-		// If password is provided, call the password-protected ZIP extraction function
-		if password != "" {
-			return extractzip.ExtractPasswordProtected(src, dest, password)
-		}
-		return extractzip.Extract(src, dest) // Standard ZIP extraction
+	case "zip", "jar":
+		return extractzip.Extract(src, dest, password)
 	case "rar":
 		return extractrar.Extract(src, dest, password)
 	case "7z":
 		return extractsevenzip.Extract(src, dest, password)
 	case "tar":
 		return extractTar.Extract(src, dest, password)
+	case "tar.gz":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for tar.gz archives")
+		}
+		return extractTar.ExtractGzip(src, dest)
+	case "tar.bz2":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for tar.bz2 archives")
+		}
+		return extractTar.ExtractBzip2(src, dest)
+	case "tar.xz":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for tar.xz archives")
+		}
+		return extractTar.ExtractXz(src, dest)
+	case "gz":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for gz files")
+		}
+		return extractgzip.Extract(src, dest)
+	case "bz2":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for bz2 files")
+		}
+		return extractbzip2.Extract(src, dest)
+	case "xz":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for xz files")
+		}
+		return extractxz.Extract(src, dest)
+	case "tar.zst", "zst":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for %s archives", archiveType)
+		}
+		dicts, err := zstdDicts(opts)
+		if err != nil {
+			return err
+		}
+		if archiveType == "zst" {
+			return extractzstd.Extract(src, dest, dicts...)
+		}
+		return extractTar.ExtractZstd(src, dest, dicts...)
+	case "tar.lz4", "lz4":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for %s archives", archiveType)
+		}
+		if archiveType == "lz4" {
+			return extractlz4.Extract(src, dest)
+		}
+		return extractTar.ExtractLz4(src, dest)
+	case "cpio":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for cpio archives")
+		}
+		return extractcpio.Extract(src, dest)
+	case "ar":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for ar archives")
+		}
+		return extractar.Extract(src, dest)
+	case "deb":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for deb packages")
+		}
+		return extractdeb.Extract(src, dest)
+	case "rpm":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for rpm packages")
+		}
+		return extractrpm.Extract(src, dest)
+	case "iso":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for ISO images")
+		}
+		return extractiso9660.Extract(src, dest)
+	case "cab":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for cabinet files")
+		}
+		return extractcab.Extract(src, dest)
+	case "squashfs":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for SquashFS images")
+		}
+		return extractsquashfs.Extract(src, dest)
 	default:
 		return fmt.Errorf("unsupported archive type for extraction: %s", archiveType)
 	}
 }
 
 // HandleCreate determines the archive type and calls the appropriate creation function.
-func HandleCreate(sources []string, dest, password string) error {
+func HandleCreate(sources []string, dest string, opts Options) error {
+	password := opts.Password
+
+	if opts.SFX {
+		return createSFX(sources, dest, opts)
+	}
+
 	archiveType, err := utils.DetermineArchiveType(dest)
 	if err != nil {
 		return fmt.Errorf("could not determine archive type: %w", err)
 	}
+	if opts.VolumeSize > 0 && !splitTypes[archiveType] {
+		return fmt.Errorf("%s archives cannot be split into volumes; use zip, 7z, rar or tar", archiveType)
+	}
+	if archiveType != "jar" && (opts.MainClass != "" || opts.ClassPath != "" || len(opts.ManifestAttrs) > 0) {
+		return fmt.Errorf("manifest attributes only apply to Java archives (.jar, .war and .ear)")
+	}
+	if opts.TarFormat != "" && archiveType != "tar" && !strings.HasPrefix(archiveType, "tar.") {
+		return fmt.Errorf("the tar format only applies to tar archives")
+	}
+	if archiveType != "rar" && (opts.RarFormat != 0 || opts.Recovery != 0 || opts.Lock) {
+		return fmt.Errorf("the RAR format, recovery record and lock only apply to RAR archives")
+	}
+	tarFormat, err := createTar.ParseFormat(opts.TarFormat)
+	if err != nil {
+		return err
+	}
+	tarOpts := createTar.Options{Format: tarFormat, VolumeSize: opts.VolumeSize}
 
 	switch archiveType {
 	case "zip":
-		// If password is provided, call the password-protected ZIP creation function
+		zip64, err := zip.ParseZip64Mode(opts.Zip64)
+		if err != nil {
+			return err
+		}
+		encryption, err := zip.ParseEncryption(opts.ZipEncryption)
+		if err != nil {
+			return err
+		}
+		if opts.ZipCrypto {
+			encryption = zip.ZipCrypto
+		}
+		return createzip.Create(sources, dest, createzip.Options{
+			Zip64:      zip64,
+			Level:      opts.Level,
+			VolumeSize: opts.VolumeSize,
+			Password:   password,
+			Encryption: encryption,
+		})
+	case "jar":
 		if password != "" {
-			return createzip.CreatePasswordProtected(sources, dest, password)
+			return fmt.Errorf("password protection is not supported for Java archives")
+		}
+		zip64, err := zip.ParseZip64Mode(opts.Zip64)
+		if err != nil {
+			return err
 		}
-		return createzip.Create(sources, dest) // Standard ZIP creation
+		attributes, err := manifestAttributes(opts)
+		if err != nil {
+			return err
+		}
+		return createjar.Create(sources, dest, createjar.Options{
+			Zip64:      zip64,
+			Level:      opts.Level,
+			MainClass:  opts.MainClass,
+			ClassPath:  opts.ClassPath,
+			Attributes: attributes,
+		})
 	case "rar":
-		return createrar.Create(sources, dest, password)
+		return createrar.Create(sources, dest, password, createrar.Options{
+			Format:     opts.RarFormat,
+			Level:      opts.Level,
+			Recovery:   opts.Recovery,
+			VolumeSize: opts.VolumeSize,
+			Lock:       opts.Lock,
+		})
 	case "7z":
-		return createsevenzip.Create(sources, dest, password)
+		return createsevenzip.Create(sources, dest, password, opts.VolumeSize)
 	case "tar":
-		return createTar.Create(sources, dest, password)
+		return createTar.Create(sources, dest, password, tarOpts)
+	case "tar.gz":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for tar.gz archives")
+		}
+		return createTar.CreateGzip(sources, dest, opts.Level, tarOpts)
+	case "tar.bz2":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for tar.bz2 archives")
+		}
+		return createTar.CreateBzip2(sources, dest, opts.Level, tarOpts)
+	case "tar.xz":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for tar.xz archives")
+		}
+		return createTar.CreateXz(sources, dest, opts.Level, tarOpts)
+	case "gz":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for gz files")
+		}
+		return creategzip.Create(sources, dest, opts.Level)
+	case "bz2":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for bz2 files")
+		}
+		return createbzip2.Create(sources, dest, opts.Level)
+	case "xz":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for xz files")
+		}
+		return createxz.Create(sources, dest, opts.Level)
+	case "tar.zst", "zst":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for %s archives", archiveType)
+		}
+		zopts, err := zstdOptions(opts)
+		if err != nil {
+			return err
+		}
+		if archiveType == "zst" {
+			return createzstd.Create(sources, dest, zopts)
+		}
+		return createTar.CreateZstd(sources, dest, zopts, tarOpts)
+	case "tar.lz4", "lz4":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for %s archives", archiveType)
+		}
+		if archiveType == "lz4" {
+			return createlz4.Create(sources, dest, lz4Options(opts))
+		}
+		return createTar.CreateLz4(sources, dest, lz4Options(opts), tarOpts)
+	case "cpio":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for cpio archives")
+		}
+		format, err := cpio.ParseFormat(opts.CpioFormat)
+		if err != nil {
+			return err
+		}
+		return createcpio.Create(sources, dest, format)
+	case "ar":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for ar archives")
+		}
+		format, err := ar.ParseFormat(opts.ArFormat)
+		if err != nil {
+			return err
+		}
+		return createar.Create(sources, dest, format, opts.Deterministic)
+	case "deb":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for deb packages")
+		}
+		if len(sources) != 1 {
+			return fmt.Errorf("a deb package is built from a single staging directory")
+		}
+		return createdeb.Create(sources[0], dest, createdeb.Options{
+			Control:       opts.Control,
+			Compression:   opts.DebCompression,
+			Level:         opts.Level,
+			Deterministic: opts.Deterministic,
+		})
+	case "iso":
+		if password != "" {
+			return fmt.Errorf("password protection is not supported for ISO images")
+		}
+		return createiso9660.Create(sources, dest, opts.VolumeLabel)
 	default:
 		return fmt.Errorf("unsupported archive type for creation: %s", archiveType)
 	}
 }
+
+// HandleList determines the archive type and prints the archive's members.
+func HandleList(src string, opts Options) error {
+	if t, err := readSFX(src); err != nil || t != nil {
+		if err != nil {
+			return err
+		}
+		return withPayload(src, t, func(payload string) error {
+			return HandleList(payload, opts)
+		})
+	}
+
+	archiveType, err := utils.DetermineArchiveType(src)
+	if err != nil {
+		return fmt.Errorf("could not determine archive type: %w", err)
+	}
+
+	switch archiveType {
+	case "zip", "jar":
+		return extractzip.List(src)
+	case "tar":
+		return extractTar.List(src)
+	case "tar.gz":
+		return extractTar.ListGzip(src)
+	case "tar.bz2":
+		return extractTar.ListBzip2(src)
+	case "tar.xz":
+		return extractTar.ListXz(src)
+	case "tar.zst":
+		dicts, err := zstdDicts(opts)
+		if err != nil {
+			return err
+		}
+		return extractTar.ListZstd(src, dicts...)
+	case "tar.lz4":
+		return extractTar.ListLz4(src)
+	case "cpio":
+		return extractcpio.List(src)
+	case "ar":
+		return extractar.List(src)
+	case "deb":
+		return extractdeb.List(src)
+	case "rpm":
+		return extractrpm.List(src)
+	case "iso":
+		return extractiso9660.List(src)
+	case "cab":
+		return extractcab.List(src)
+	case "squashfs":
+		return extractsquashfs.List(src)
+	case "7z":
+		return extractsevenzip.List(src, opts.Password)
+	case "rar":
+		return extractrar.List(src)
+	default:
+		return fmt.Errorf("unsupported archive type for listing: %s", archiveType)
+	}
+}
+
+// HandleInfo determines the archive type and prints the package metadata it carries.
+func HandleInfo(src string, opts Options) error {
+	if t, err := readSFX(src); err != nil || t != nil {
+		if err != nil {
+			return err
+		}
+		return infoSFX(src, t, opts)
+	}
+
+	archiveType, err := utils.DetermineArchiveType(src)
+	if err != nil {
+		return fmt.Errorf("could not determine archive type: %w", err)
+	}
+
+	switch archiveType {
+	case "deb":
+		return extractdeb.Info(src)
+	case "rpm":
+		return extractrpm.Info(src)
+	case "rar":
+		return extractrar.Info(src)
+	case "jar":
+		return extractjar.Info(src)
+	case "squashfs":
+		return extractsquashfs.Info(src)
+	default:
+		return fmt.Errorf("unsupported archive type for info: %s", archiveType)
+	}
+}
+
+// HandleFlatten writes the merged root file system of a container image
+// tarball to dest, a directory or a .tar file.
+func HandleFlatten(src, dest string, opts Options) error {
+	if opts.Password != "" {
+		return fmt.Errorf("password protection is not supported for container images")
+	}
+	if !strings.HasSuffix(strings.ToLower(dest), ".tar") {
+		if _, err := utils.DetermineArchiveType(dest); err == nil {
+			return fmt.Errorf("flattened images are written to a directory or a .tar file")
+		}
+	}
+	return extractoci.Flatten(src, dest, opts.Platform)
+}
+
+// HandleTrain builds a Zstandard dictionary at dest from sample files.
+func HandleTrain(sources []string, dest string, size int) error {
+	return createzstd.Train(sources, dest, size)
+}
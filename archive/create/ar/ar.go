@@ -0,0 +1,163 @@
+package createar
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"futile/formats/ar"
+	"futile/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// member is a file queued for the archive.
+type member struct {
+	path string
+	info os.FileInfo
+}
+
+// Create writes the source files into an ar archive. The format is flat, so
+// files found in source directories are stored under their base names. In
+// deterministic mode timestamps, owners and modes are normalized so the same
+// inputs always produce the same archive.
+func Create(sources []string, dest string, format ar.Format, deterministic bool) error {
+	var members []member
+	for _, source := range sources {
+		err := filepath.Walk(source, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return fmt.Errorf("failed to add %s to ar archive: %w", source, err)
+			}
+			if fi.Mode().IsRegular() {
+				members = append(members, member{file, fi})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create ar archive %s: %w", dest, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil {
+			fmt.Printf("Error closing ar archive %s: %v\n", dest, closeErr)
+		}
+	}()
+
+	w, err := ar.NewWriter(out, format)
+	if err != nil {
+		return fmt.Errorf("failed to write ar archive %s: %w", dest, err)
+	}
+	names := make([]string, len(members))
+	index := make([]ar.IndexEntry, len(members))
+	unindexed := false
+	for i, m := range members {
+		names[i] = filepath.Base(m.path)
+		symbols, object, err := objectSymbols(m.path)
+		if err != nil {
+			return err
+		}
+		if object && (format != ar.FormatGNU || symbols == nil) {
+			unindexed = true
+		}
+		index[i] = ar.IndexEntry{Name: names[i], Size: m.info.Size(), Symbols: symbols}
+	}
+	if err := w.WriteIndex(index); err != nil {
+		return fmt.Errorf("failed to write ar archive %s: %w", dest, err)
+	}
+	if unindexed {
+		fmt.Printf("Warning: the symbol index of %s does not cover all of its object files; run ranlib on it before linking\n", dest)
+	}
+
+	for i, m := range members {
+		hdr := &ar.Header{
+			Name:    names[i],
+			ModTime: m.info.ModTime(),
+			Mode:    0100000 | int64(m.info.Mode().Perm()),
+			Size:    m.info.Size(),
+		}
+		if deterministic {
+			hdr.ModTime = time.Unix(0, 0)
+			hdr.Mode = 0100644
+		} else if ids, ok := utils.FileIDs(m.info); ok {
+			hdr.Uid, hdr.Gid = ids.Uid, ids.Gid
+		}
+		if err := addFile(w, hdr, m.path); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finish ar archive %s: %w", dest, err)
+	}
+	return nil
+}
+
+func addFile(w *ar.Writer, hdr *ar.Header, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", path, closeErr)
+		}
+	}()
+
+	if err := w.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", path, err)
+	}
+	if _, err := io.CopyN(w, file, hdr.Size); err != nil {
+		return fmt.Errorf("failed to write file %s to ar archive: %w", path, err)
+	}
+	return nil
+}
+
+// objectSymbols reports whether the file at path is an object file and lists
+// the global symbols it defines. Only ELF symbols can be listed; other object
+// files, such as Mach-O, are reported with none.
+func objectSymbols(path string) ([]string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", path, closeErr)
+		}
+	}()
+
+	var magic [4]byte
+	if _, err := io.ReadFull(file, magic[:]); err != nil {
+		return nil, false, nil
+	}
+	switch binary.BigEndian.Uint32(magic[:]) {
+	case 0x7f454c46: // ELF
+	case 0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe: // Mach-O
+		return nil, true, nil
+	default:
+		return nil, false, nil
+	}
+
+	obj, err := elf.NewFile(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read ELF file %s: %w", path, err)
+	}
+	syms, err := obj.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, false, fmt.Errorf("failed to read symbols of %s: %w", path, err)
+	}
+	var names []string
+	for _, sym := range syms {
+		if sym.Name == "" || sym.Section == elf.SHN_UNDEF || elf.ST_BIND(sym.Info) == elf.STB_LOCAL {
+			continue
+		}
+		names = append(names, sym.Name)
+	}
+	return names, true, nil
+}
@@ -0,0 +1,55 @@
+package createbzip2
+
+import (
+	"fmt"
+	"futile/compress/bzip2"
+	"futile/utils"
+	"io"
+	"os"
+)
+
+// Create compresses a single source file into a standalone .bz2 file.
+// The level selects the block size: -1 selects the default, 1 (100k) through 9 (900k).
+func Create(sources []string, dest string, level int) error {
+	if len(sources) != 1 {
+		return fmt.Errorf("bzip2 compresses a single file; use a .tar.bz2 archive for %d inputs", len(sources))
+	}
+	src := sources[0]
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", src, closeErr)
+		}
+	}()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create bzip2 file %s: %w", dest, err)
+	}
+
+	writer, err := bzip2.NewWriterLevel(out, level)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err := io.Copy(writer, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := writer.Close(); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to finish bzip2 stream %s: %w", dest, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close bzip2 file %s: %w", dest, err)
+	}
+	if err := utils.CopyModTime(src, dest); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", dest, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package createcpio
+
+import (
+	"fmt"
+	"futile/formats/cpio"
+	"futile/utils"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// entry is a file queued for the archive.
+type entry struct {
+	path string
+	name string
+	info os.FileInfo
+	key  linkKey
+}
+
+// linkKey identifies the files that are hard links of each other.
+type linkKey struct {
+	dev, ino uint64
+}
+
+// Create writes the sources into a cpio archive of the given format.
+// Directories are stored with their contents relative to the directory and
+// files under their base name. Hard links, symbolic links, FIFOs and device
+// nodes are preserved.
+func Create(sources []string, dest string, format cpio.Format) error {
+	var entries []entry
+	for _, source := range sources {
+		found, err := collect(source)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to cpio archive: %w", source, err)
+		}
+		entries = append(entries, found...)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create cpio archive %s: %w", dest, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil {
+			fmt.Printf("Error closing cpio archive %s: %v\n", dest, closeErr)
+		}
+	}()
+
+	w := cpio.NewWriter(out, format)
+	if err := writeEntries(w, entries, format); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finish cpio archive %s: %w", dest, err)
+	}
+	return nil
+}
+
+// collect lists a source file, or a directory and everything below it.
+func collect(source string) ([]entry, error) {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat source %s: %w", source, err)
+	}
+	if !info.IsDir() {
+		return []entry{newEntry(source, filepath.Base(source), info)}, nil
+	}
+
+	var entries []entry
+	err = filepath.Walk(source, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking through directory %s: %w", source, err)
+		}
+		if file == source {
+			return nil
+		}
+		rel, err := filepath.Rel(source, file)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, newEntry(file, filepath.ToSlash(rel), fi))
+		return nil
+	})
+	return entries, err
+}
+
+func newEntry(path, name string, info os.FileInfo) entry {
+	e := entry{path: path, name: name, info: info}
+	if ids, ok := utils.FileIDs(info); ok && info.Mode().IsRegular() && ids.Nlink > 1 {
+		e.key = linkKey{ids.Dev, ids.Ino}
+	}
+	return e
+}
+
+// writeEntries writes the headers and data of all entries. Hard links share
+// an inode number and only one of them carries the data: the last one in the
+// SVR4 formats and the first one in odc, which is where extractors expect it.
+func writeEntries(w *cpio.Writer, entries []entry, format cpio.Format) error {
+	links := make(map[linkKey]int)
+	for _, e := range entries {
+		if e.key != (linkKey{}) {
+			links[e.key]++
+		}
+	}
+	inodes := make(map[linkKey]int64)
+	seen := make(map[linkKey]int)
+	var nextIno int64 = 1
+
+	for _, e := range entries {
+		hdr := &cpio.Header{
+			Name:    e.name,
+			Mode:    cpio.ModeFromFileMode(e.info.Mode()),
+			Nlink:   1,
+			ModTime: e.info.ModTime(),
+		}
+		if ids, ok := utils.FileIDs(e.info); ok {
+			hdr.Uid = ids.Uid
+			hdr.Gid = ids.Gid
+			if e.info.Mode()&os.ModeDevice != 0 {
+				hdr.RDevMajor = int64(utils.Major(ids.Rdev))
+				hdr.RDevMinor = int64(utils.Minor(ids.Rdev))
+			}
+		}
+		if e.info.IsDir() {
+			hdr.Nlink = 2
+		}
+
+		withData := e.info.Mode().IsRegular()
+		if e.key != (linkKey{}) && links[e.key] > 1 {
+			ino, ok := inodes[e.key]
+			if !ok {
+				ino = nextIno
+				nextIno++
+				inodes[e.key] = ino
+			}
+			hdr.Ino = ino
+			hdr.Nlink = links[e.key]
+			seen[e.key]++
+			if format == cpio.FormatODC {
+				withData = seen[e.key] == 1
+			} else {
+				withData = seen[e.key] == links[e.key]
+			}
+		} else {
+			hdr.Ino = nextIno
+			nextIno++
+		}
+
+		if e.info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(e.path)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", e.path, err)
+			}
+			hdr.Linkname = target
+		}
+		if withData {
+			hdr.Size = e.info.Size()
+		}
+		if err := writeEntry(w, hdr, e.path, withData, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntry writes one member, reading the file twice for the crc format
+// since its header holds the checksum of the data.
+func writeEntry(w *cpio.Writer, hdr *cpio.Header, path string, withData bool, format cpio.Format) error {
+	if !withData {
+		if err := w.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write header for %s: %w", path, err)
+		}
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", path, closeErr)
+		}
+	}()
+
+	if format == cpio.FormatCRC {
+		sum, err := checksum(file)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+		hdr.Check = sum
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+	}
+	if err := w.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", path, err)
+	}
+	if _, err := io.CopyN(w, file, hdr.Size); err != nil {
+		return fmt.Errorf("failed to write file %s to cpio archive: %w", path, err)
+	}
+	return nil
+}
+
+// checksum adds up the bytes of r as the crc format expects.
+func checksum(r io.Reader) (uint32, error) {
+	var sum uint32
+	buf := make([]byte, 1<<16)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			sum += uint32(b)
+		}
+		if err == io.EOF {
+			return sum, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package createdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"futile/formats/deb"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configures how a package is built.
+type Options struct {
+	Control       string // Control file; defaults to DEBIAN/control in the staging directory
+	Compression   string // Tarball compression: gz, xz, zst or none
+	Level         int    // Compression level, or -1 for the default
+	Deterministic bool   // Zero all timestamps
+}
+
+// Create builds a Debian package from a staging directory laid out like the
+// installed system. Files in its DEBIAN directory, such as maintainer
+// scripts, go into the control tarball. Installed-Size and md5sums are
+// generated, and all files are owned by root.
+func Create(staging, dest string, opts Options) (err error) {
+	info, err := os.Stat(staging)
+	if err != nil {
+		return fmt.Errorf("failed to stat staging directory %s: %w", staging, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory; a package is built from a staging directory", staging)
+	}
+
+	controlPath := opts.Control
+	if controlPath == "" {
+		controlPath = filepath.Join(staging, "DEBIAN", "control")
+	}
+	controlData, err := os.ReadFile(controlPath)
+	if err != nil {
+		return fmt.Errorf("failed to read control file: %w", err)
+	}
+	control, err := deb.ParseControl(controlData)
+	if err != nil {
+		return fmt.Errorf("invalid control file %s: %w", controlPath, err)
+	}
+	if err := control.Validate(); err != nil {
+		return fmt.Errorf("invalid control file %s: %w", controlPath, err)
+	}
+
+	mtime := time.Now()
+	if opts.Deterministic {
+		mtime = time.Unix(0, 0)
+	}
+
+	// The data tarball is staged in a temporary file since the ar header
+	// needs its size before the content
+	data, err := os.CreateTemp(filepath.Dir(dest), ".futile-data-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = data.Close()
+		_ = os.Remove(data.Name())
+	}()
+	suffix, compressor, err := deb.Compressor(opts.Compression, data, opts.Level)
+	if err != nil {
+		return err
+	}
+	sums, installedSize, err := writeData(compressor, staging, opts.Deterministic)
+	if err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to compress package data: %w", err)
+	}
+	if control.Get("Installed-Size") == "" {
+		control.Set("Installed-Size", strconv.FormatInt(installedSize, 10))
+	}
+
+	var controlTar bytes.Buffer
+	_, compressor, err = deb.Compressor(opts.Compression, &controlTar, opts.Level)
+	if err != nil {
+		return err
+	}
+	if err := writeControl(compressor, staging, control, sums, mtime); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to compress control files: %w", err)
+	}
+
+	size, err := data.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to read temporary file: %w", err)
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read temporary file: %w", err)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create package %s: %w", dest, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close package %s: %w", dest, closeErr)
+		}
+	}()
+	if err := deb.WritePackage(out, mtime, "control.tar"+suffix, controlTar.Bytes(), "data.tar"+suffix, data, size); err != nil {
+		return fmt.Errorf("failed to write package %s: %w", dest, err)
+	}
+	return nil
+}
+
+// md5sum is one line of the md5sums control file.
+type md5sum struct {
+	name, sum string
+}
+
+// writeData writes the staging directory, minus DEBIAN, as the data tarball.
+// It returns the checksums of the regular files and the installed size in KiB.
+func writeData(w io.Writer, staging string, deterministic bool) ([]md5sum, int64, error) {
+	tw := tar.NewWriter(w)
+	var sums []md5sum
+	var size int64
+
+	err := filepath.Walk(staging, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking through directory %s: %w", staging, err)
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "DEBIAN" && fi.IsDir() {
+			return filepath.SkipDir
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return fmt.Errorf("failed to create header for %s: %w", path, err)
+		}
+		hdr.Name = "./" + rel
+		if rel == "." {
+			hdr.Name = "./"
+		} else if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "root", "root"
+		hdr.Format = tar.FormatGNU
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		if deterministic {
+			hdr.ModTime = time.Unix(0, 0)
+		}
+
+		var file *os.File
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			if hdr.Linkname, err = os.Readlink(path); err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", path, err)
+			}
+			size++
+		case fi.Mode().IsRegular():
+			if file, err = os.Open(path); err != nil {
+				return fmt.Errorf("failed to open file %s: %w", path, err)
+			}
+			defer file.Close()
+			size += (fi.Size() + 1023) / 1024
+		case fi.IsDir():
+			size++
+		default:
+			return fmt.Errorf("%s: special files cannot be packaged", path)
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write header for %s: %w", path, err)
+		}
+		if file != nil {
+			h := md5.New()
+			if _, err := io.Copy(io.MultiWriter(tw, h), file); err != nil {
+				return fmt.Errorf("failed to write file %s to package: %w", path, err)
+			}
+			sums = append(sums, md5sum{rel, hex.EncodeToString(h.Sum(nil))})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, 0, fmt.Errorf("failed to finish package data: %w", err)
+	}
+	return sums, size, nil
+}
+
+// writeControl writes the control tarball: the control file, md5sums and the
+// other files in the staging directory's DEBIAN directory.
+func writeControl(w io.Writer, staging string, control deb.Control, sums []md5sum, mtime time.Time) error {
+	tw := tar.NewWriter(w)
+	add := func(name string, mode int64, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    mode,
+			Size:    int64(len(data)),
+			ModTime: mtime,
+			Uname:   "root",
+			Gname:   "root",
+			Format:  tar.FormatGNU,
+		}
+		if name == "./" {
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write control file %s: %w", name, err)
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := add("./", 0755, nil); err != nil {
+		return err
+	}
+	if err := add("./control", 0644, []byte(control.String())); err != nil {
+		return err
+	}
+	if len(sums) > 0 {
+		var b strings.Builder
+		for _, s := range sums {
+			fmt.Fprintf(&b, "%s  %s\n", s.sum, s.name)
+		}
+		if err := add("./md5sums", 0644, []byte(b.String())); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(staging, "DEBIAN"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read control directory: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, e := range entries {
+		if e.Name() == "control" || e.Name() == "md5sums" || !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(staging, "DEBIAN", e.Name())
+		info, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if err := add("./"+e.Name(), int64(info.Mode().Perm()), content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,64 @@
+package creategzip
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Create compresses a single source file into a standalone .gz file. The
+// original file name and modification time are recorded in the gzip header.
+func Create(sources []string, dest string, level int) error {
+	if len(sources) != 1 {
+		return fmt.Errorf("gzip compresses a single file; use a .tar.gz archive for %d inputs", len(sources))
+	}
+	if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return fmt.Errorf("invalid gzip compression level %d (expected 1-9)", level)
+	}
+	src := sources[0]
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", src, closeErr)
+		}
+	}()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", src, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip file %s: %w", dest, err)
+	}
+
+	writer, err := gzip.NewWriterLevel(out, level)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	writer.Name = filepath.Base(src)
+	writer.ModTime = info.ModTime()
+	if _, err := io.Copy(writer, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := writer.Close(); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to finish gzip stream %s: %w", dest, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip file %s: %w", dest, err)
+	}
+
+	return nil
+}
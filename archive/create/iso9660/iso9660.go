@@ -0,0 +1,133 @@
+package createiso9660
+
+import (
+	"fmt"
+	"futile/formats/iso9660"
+	"futile/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Create writes the sources into an ISO 9660 image with Joliet and Rock
+// Ridge extensions. Directories contribute their contents to the root of the
+// image and files are stored under their base name.
+func Create(sources []string, dest, label string) error {
+	root := &iso9660.Node{Mode: os.ModeDir | 0755}
+	for i, source := range sources {
+		info, err := os.Lstat(source)
+		if err != nil {
+			return fmt.Errorf("failed to stat source %s: %w", source, err)
+		}
+		if i == 0 && info.IsDir() {
+			root.ModTime = info.ModTime()
+			root.Mode = info.Mode()
+		}
+		if info.IsDir() {
+			children, err := readDir(source)
+			if err != nil {
+				return fmt.Errorf("failed to add %s to ISO image: %w", source, err)
+			}
+			root.Children = append(root.Children, children...)
+			continue
+		}
+		node, err := newNode(source, info)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to ISO image: %w", source, err)
+		}
+		root.Children = append(root.Children, node)
+	}
+	if err := checkDuplicates(root); err != nil {
+		return err
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create ISO image %s: %w", dest, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil {
+			fmt.Printf("Error closing ISO image %s: %v\n", dest, closeErr)
+		}
+	}()
+
+	if err := iso9660.Write(out, root, iso9660.WriterOptions{VolumeID: label}); err != nil {
+		return fmt.Errorf("failed to write ISO image %s: %w", dest, err)
+	}
+	return nil
+}
+
+// readDir builds the nodes for the contents of a directory.
+func readDir(dir string) ([]*iso9660.Node, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*iso9660.Node
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+		node, err := newNode(path, info)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// newNode describes a file for the image, reading directories recursively.
+func newNode(path string, info os.FileInfo) (*iso9660.Node, error) {
+	node := &iso9660.Node{
+		Name:    info.Name(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}
+	if ids, ok := utils.FileIDs(info); ok {
+		node.Uid = ids.Uid
+		node.Gid = ids.Gid
+		if info.Mode()&os.ModeDevice != 0 {
+			node.RDevMajor = utils.Major(ids.Rdev)
+			node.RDevMinor = utils.Minor(ids.Rdev)
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		children, err := readDir(path)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = children
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		node.Linkname = target
+	case info.Mode().IsRegular():
+		node.Size = info.Size()
+		node.Open = func() (io.ReadCloser, error) { return os.Open(path) }
+	}
+	return node, nil
+}
+
+// checkDuplicates rejects sources that would place two files at the root
+// under the same name.
+func checkDuplicates(root *iso9660.Node) error {
+	names := make([]string, 0, len(root.Children))
+	for _, c := range root.Children {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	for i := 1; i < len(names); i++ {
+		if names[i] == names[i-1] {
+			return fmt.Errorf("more than one source provides %s", names[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package createjar
+
+import (
+	"archive/zip"
+	"fmt"
+	createzip "futile/archive/create/zip"
+	"futile/formats/jar"
+	zipformat "futile/formats/zip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options configures how a Java archive is built.
+type Options struct {
+	Zip64 zipformat.Zip64Mode
+	Level int // deflate level, or -1 for the default
+
+	MainClass  string          // Main-Class attribute, such as com.example.Main
+	ClassPath  string          // Class-Path attribute: space-separated relative URLs
+	Attributes []jar.Attribute // further main attributes
+}
+
+// Create builds a JAR, WAR or EAR file from the sources, with the META-INF/
+// directory and the manifest first as Java tools expect. The manifest is
+// META-INF/MANIFEST.MF from the sources, or a new one, with the attributes
+// of opts added. The archive is checked once written, and removed when it
+// is not a valid Java archive.
+func Create(sources []string, dest string, opts Options) error {
+	manifest, err := loadManifest(sources)
+	if err != nil {
+		return err
+	}
+	if opts.MainClass != "" {
+		manifest.Main.Set("Main-Class", opts.MainClass)
+	}
+	if opts.ClassPath != "" {
+		manifest.Main.Set("Class-Path", opts.ClassPath)
+	}
+	for _, a := range opts.Attributes {
+		manifest.Main.Set(a.Name, a.Value)
+	}
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+
+	if err := createzip.Create(sources, dest, createzip.Options{
+		Zip64:    opts.Zip64,
+		Level:    opts.Level,
+		Manifest: manifest.Bytes(),
+	}); err != nil {
+		return err
+	}
+	if err := check(dest); err != nil {
+		if removeErr := os.Remove(dest); removeErr != nil {
+			fmt.Printf("Error removing invalid archive %s: %v\n", dest, removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// check validates the archive written to dest, printing its warnings.
+func check(dest string) error {
+	zr, err := zip.OpenReader(dest)
+	if err != nil {
+		return fmt.Errorf("failed to reopen %s: %w", dest, err)
+	}
+	defer func() {
+		if closeErr := zr.Close(); closeErr != nil {
+			fmt.Printf("Error closing archive %s: %v\n", dest, closeErr)
+		}
+	}()
+	warnings, err := jar.Check(&zr.Reader, jar.KindOf(dest))
+	if err != nil {
+		return fmt.Errorf("invalid Java archive %s: %w", dest, err)
+	}
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s: %s\n", dest, w)
+	}
+	return nil
+}
+
+// loadManifest reads the manifest found among the sources: inside a source
+// directory, or given as a file named META-INF/MANIFEST.MF. Without one, a
+// manifest with the attributes the jar tool writes is returned.
+func loadManifest(sources []string) (*jar.Manifest, error) {
+	for _, source := range sources {
+		path := source
+		if info, err := os.Stat(source); err == nil && info.IsDir() {
+			path = filepath.Join(source, filepath.FromSlash(jar.ManifestName))
+		} else if !strings.EqualFold(strings.TrimLeft(filepath.ToSlash(source), "/"), jar.ManifestName) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
+		}
+		manifest, err := jar.ParseManifest(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+		}
+		if manifest.Main.Get("Manifest-Version") == "" {
+			manifest.Main = append(jar.Section{{Name: "Manifest-Version", Value: "1.0"}}, manifest.Main...)
+		}
+		return manifest, nil
+	}
+	return &jar.Manifest{Main: jar.Section{
+		{Name: "Manifest-Version", Value: "1.0"},
+		{Name: "Created-By", Value: "futile"},
+	}}, nil
+}
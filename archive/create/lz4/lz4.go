@@ -0,0 +1,54 @@
+package createlz4
+
+import (
+	"fmt"
+	"futile/compress/lz4"
+	"futile/utils"
+	"io"
+	"os"
+)
+
+// Create compresses a single source file into a standalone .lz4 file.
+func Create(sources []string, dest string, opts lz4.WriterOptions) error {
+	if len(sources) != 1 {
+		return fmt.Errorf("LZ4 compresses a single file; use a .tar.lz4 archive for %d inputs", len(sources))
+	}
+	src := sources[0]
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", src, closeErr)
+		}
+	}()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create LZ4 file %s: %w", dest, err)
+	}
+
+	writer, err := lz4.NewWriterOptions(out, opts)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err := io.Copy(writer, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := writer.Close(); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to finish LZ4 stream %s: %w", dest, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close LZ4 file %s: %w", dest, err)
+	}
+	if err := utils.CopyModTime(src, dest); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", dest, err)
+	}
+
+	return nil
+}
@@ -1,30 +1,118 @@
 package createrar
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
+// Options are the settings specific to RAR archives.
+type Options struct {
+	Format     int   // archive format version: 4 or 5
+	Level      int   // compression level from 0 (store) to 5 (best), or -1 for the default
+	Recovery   int   // recovery record size in percent of the archive, or 0 for none
+	VolumeSize int64 // split into volumes of this many bytes, or 0 for a single file
+	Lock       bool  // lock the archive against further changes
+}
+
+// errNoRar explains why RAR archives cannot be created without rar.
+var errNoRar = errors.New("creating RAR archives requires the rar binary, which was not found in PATH; " +
+	"RAR is a proprietary format that only rar can write (unrar and 7z can only read it). " +
+	"Install rar from https://www.rarlab.com, or create a .7z, .zip or .tar.xz archive instead")
+
 // Create creates a RAR archive from the input files and saves it to the destination.
-func Create(sources []string, dest, password string) error {
-	// Prepare the 7zip command arguments for creating a RAR archive
-	cmdArgs := []string{"a", dest}
+// Directories are added recursively under their own name.
+func Create(sources []string, dest, password string, opts Options) error {
+	if _, err := exec.LookPath("rar"); err != nil {
+		return errNoRar
+	}
+
+	// "-ep1" names entries relative to each source's parent, and "-idq"
+	// keeps rar quiet
+	cmdArgs := []string{"a", "-r", "-ep1", "-idq", "-y"}
+	switch opts.Format {
+	case 4:
+		cmdArgs = append(cmdArgs, "-ma4")
+	case 0, 5:
+		cmdArgs = append(cmdArgs, "-ma5")
+	default:
+		return fmt.Errorf("unsupported RAR format version %d; use 4 or 5", opts.Format)
+	}
+	if opts.Level >= 0 {
+		if opts.Level > 5 {
+			return fmt.Errorf("invalid RAR compression level %d; use 0 to 5", opts.Level)
+		}
+		cmdArgs = append(cmdArgs, "-m"+strconv.Itoa(opts.Level))
+	}
+	if opts.Recovery != 0 {
+		if opts.Recovery < 0 || opts.Recovery > 100 {
+			return fmt.Errorf("invalid recovery record size %d%%; use 1 to 100", opts.Recovery)
+		}
+		cmdArgs = append(cmdArgs, "-rr"+strconv.Itoa(opts.Recovery)+"%")
+	}
+	if opts.VolumeSize > 0 {
+		cmdArgs = append(cmdArgs, "-v"+strconv.FormatInt(opts.VolumeSize, 10)+"b")
+	}
+	if opts.Lock {
+		cmdArgs = append(cmdArgs, "-k")
+	}
 
 	// If password is provided, add the -p flag with the password
 	if password != "" {
 		cmdArgs = append(cmdArgs, "-p"+password)
 	}
 
-	// Add the source files to the command arguments
+	// rar adds to existing archives, while futile always writes a new one
+	if err := removeOld(dest, opts.VolumeSize > 0); err != nil {
+		return err
+	}
+
+	// "--" ends the switches, so sources starting with a dash are files
+	cmdArgs = append(cmdArgs, "--", dest)
 	cmdArgs = append(cmdArgs, sources...)
 
-	// Create the 7zip command
-	cmd := exec.Command("7z", cmdArgs...)
-	// Run the command
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to create RAR archive with 7zip: %w", err)
+	cmd := exec.Command("rar", cmdArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to create RAR archive with rar: %w", err)
 	}
 
 	return nil
 }
+
+// removeOld deletes dest and, when a new volume set is written, the
+// volumes of an earlier set split under the same name, which would
+// otherwise look like part of the new one.
+func removeOld(dest string, split bool) error {
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s: %w", dest, err)
+	}
+	if !split {
+		return nil
+	}
+	dir, name := filepath.Split(dest)
+	volume := regexp.MustCompile(`(?i)^` + regexp.QuoteMeta(strings.TrimSuffix(name, filepath.Ext(name))) + `\.part\d+\.rar$`)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read directory of %s: %w", dest, err)
+	}
+	for _, entry := range entries {
+		if !volume.MatchString(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove old volume %s: %w", path, err)
+		}
+	}
+	return nil
+}
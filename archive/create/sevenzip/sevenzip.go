@@ -4,16 +4,21 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 )
 
 // Create creates a 7z archive from the provided source files and directories.
-// Supports password protection and split archives.
-func Create(sources []string, dest, password string) error {
+// Supports password protection and split archives: a volumeSize other than 0
+// has 7z write volumes of that many bytes, named dest.001, dest.002 and so on.
+func Create(sources []string, dest, password string, volumeSize int64) error {
 	// Build the command arguments for 7z
 	args := append([]string{"a", dest}, sources...)
 	if password != "" {
 		args = append(args, "-p"+password) // Add password flag if provided
 	}
+	if volumeSize > 0 {
+		args = append(args, "-v"+strconv.FormatInt(volumeSize, 10)+"b")
+	}
 
 	// Run the 7z command to create the archive
 	cmd := exec.Command("7z", args...)
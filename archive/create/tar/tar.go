@@ -2,13 +2,64 @@ package createTar
 
 import (
 	"archive/tar"
+	"compress/gzip"
 	"fmt"
+	"futile/compress/bzip2"
+	"futile/compress/lz4"
+	"futile/compress/xz"
+	"futile/compress/zstd"
+	"futile/utils"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
+// minVolumeSize is the smallest volume size of split archives.
+const minVolumeSize = 64 << 10
+
+// xattrPrefix starts the PAX records of extended attributes, as GNU tar and
+// bsdtar write them.
+const xattrPrefix = "SCHILY.xattr."
+
+// Options configures how tar archives are written.
+type Options struct {
+	// Format is the tar flavor: FormatPAX, which can represent every entry,
+	// FormatUSTAR or FormatGNU.
+	Format tar.Format
+
+	// VolumeSize splits archives into volumes of this many bytes, named
+	// like dest with ".001", ".002" and so on appended, unless it is 0.
+	VolumeSize int64
+}
+
+// ParseFormat maps a tar flavor name to its format.
+func ParseFormat(name string) (tar.Format, error) {
+	switch name {
+	case "", "pax":
+		return tar.FormatPAX, nil
+	case "ustar":
+		return tar.FormatUSTAR, nil
+	case "gnu":
+		return tar.FormatGNU, nil
+	}
+	return tar.FormatUnknown, fmt.Errorf("unknown tar format %q (expected ustar, pax or gnu)", name)
+}
+
+// entry is a file queued for the archive.
+type entry struct {
+	path string
+	name string
+	info os.FileInfo
+}
+
+// linkKey identifies the files that are hard links of each other.
+type linkKey struct {
+	dev, ino uint64
+}
+
 // closeFile is a helper function to close files and handle errors.
 func closeFile(f io.Closer) error {
 	if f != nil {
@@ -22,87 +73,303 @@ func closeFile(f io.Closer) error {
 
 // Create creates a tar archive from the input files and saves it to the destination.
 // If a password is provided, it uses 7zip for password protection.
-func Create(sources []string, dest, password string) error {
+//
+// Directories are stored with their contents relative to the directory and
+// files under their base name, with their owners, links and device numbers.
+// Entries that the chosen format cannot represent are skipped with a
+// warning, and details it cannot store are dropped with one.
+func Create(sources []string, dest, password string, opts Options) error {
 	if password != "" {
+		if opts.VolumeSize > 0 {
+			return fmt.Errorf("password-protected tar archives cannot be split into volumes")
+		}
 		// Use 7zip to create a password-protected tar archive
-		return createPasswordProtectedTar(sources, dest, password)
+		return createPasswordProtectedTar(sources, dest, password, opts.Format)
 	}
 
 	// Standard tar archive creation
-	return createStandardTar(sources, dest)
+	return createStandardTar(sources, dest, opts)
+}
+
+// CreateGzip creates a gzip-compressed tar archive (.tar.gz / .tgz).
+// The level follows compress/gzip: -1 selects the default, 1 (fastest) through 9 (best).
+func CreateGzip(sources []string, dest string, level int, opts Options) error {
+	if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return fmt.Errorf("invalid gzip compression level %d (expected 1-9)", level)
+	}
+
+	return createCompressedTar(sources, dest, opts, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	})
+}
+
+// CreateBzip2 creates a bzip2-compressed tar archive (.tar.bz2 / .tbz2).
+// The level selects the block size: -1 selects the default, 1 (100k) through 9 (900k).
+func CreateBzip2(sources []string, dest string, level int, opts Options) error {
+	return createCompressedTar(sources, dest, opts, func(w io.Writer) (io.WriteCloser, error) {
+		return bzip2.NewWriterLevel(w, level)
+	})
+}
+
+// CreateXz creates an xz-compressed tar archive (.tar.xz / .txz).
+// The level is an xz preset: -1 selects the default (6), 0 (fastest) through 9 (best).
+func CreateXz(sources []string, dest string, level int, opts Options) error {
+	return createCompressedTar(sources, dest, opts, func(w io.Writer) (io.WriteCloser, error) {
+		return xz.NewWriterLevel(w, level)
+	})
+}
+
+// CreateZstd creates a Zstandard-compressed tar archive (.tar.zst / .tzst).
+func CreateZstd(sources []string, dest string, zopts zstd.WriterOptions, opts Options) error {
+	return createCompressedTar(sources, dest, opts, func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriterOptions(w, zopts)
+	})
+}
+
+// CreateLz4 creates an LZ4-compressed tar archive (.tar.lz4).
+func CreateLz4(sources []string, dest string, lopts lz4.WriterOptions, opts Options) error {
+	return createCompressedTar(sources, dest, opts, func(w io.Writer) (io.WriteCloser, error) {
+		return lz4.NewWriterOptions(w, lopts)
+	})
 }
 
 // createStandardTar creates a standard (non-password protected) tar archive.
-func createStandardTar(sources []string, dest string) error {
+func createStandardTar(sources []string, dest string, opts Options) error {
+	return createCompressedTar(sources, dest, opts, nil)
+}
+
+// createCompressedTar creates a tar archive at dest, passing the output through the
+// compressor returned by wrap. A nil wrap writes an uncompressed tar archive.
+func createCompressedTar(sources []string, dest string, opts Options,
+	wrap func(io.Writer) (io.WriteCloser, error)) (err error) {
+	var entries []entry
+	for _, source := range sources {
+		found, err := collect(source)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to tar archive: %w", source, err)
+		}
+		entries = append(entries, found...)
+	}
+
 	// Open the tar file for writing
-	tarFile, err := os.Create(dest)
+	tarFile, err := createOutput(dest, opts.VolumeSize)
 	if err != nil {
 		return fmt.Errorf("could not create tar file: %w", err)
 	}
 	defer func() {
 		// Ensure the tarFile is closed and handle any closing error
-		if closeErr := closeFile(tarFile); closeErr != nil {
-			fmt.Printf("Warning: failed to close TAR file: %v\n", closeErr)
+		if closeErr := closeFile(tarFile); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close TAR file: %w", closeErr)
 		}
 	}()
 
+	var out io.Writer = tarFile
+	if wrap != nil {
+		compressor, err := wrap(tarFile)
+		if err != nil {
+			return fmt.Errorf("could not create compressor: %w", err)
+		}
+		defer func() {
+			// The compressor must be closed after the tar writer so its trailer is written last
+			if closeErr := closeFile(compressor); closeErr != nil && err == nil {
+				err = fmt.Errorf("failed to close compressor: %w", closeErr)
+			}
+		}()
+		out = compressor
+	}
+
 	// Create a new tar writer
-	tarWriter := tar.NewWriter(tarFile)
+	tarWriter := tar.NewWriter(out)
 	defer func() {
 		// Ensure the tarWriter is closed and handle any closing error
-		if closeErr := closeFile(tarWriter); closeErr != nil {
-			fmt.Printf("Warning: failed to close TAR writer: %v\n", closeErr)
+		if closeErr := closeFile(tarWriter); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close TAR writer: %w", closeErr)
 		}
 	}()
 
-	// Loop over the input files and add them to the tar archive
-	for _, filePath := range sources {
-		// Open the file to be archived
-		file, err := os.Open(filePath)
+	return writeTarEntries(tarWriter, entries, opts.Format)
+}
+
+// createOutput creates the file dest, or the first of its volumes when
+// volumeSize is not 0.
+func createOutput(dest string, volumeSize int64) (io.WriteCloser, error) {
+	if volumeSize == 0 {
+		return os.Create(dest)
+	}
+	if volumeSize < minVolumeSize {
+		return nil, fmt.Errorf("volume size %d is too small; use at least 64K", volumeSize)
+	}
+	return utils.NewVolumeWriter(volumeSize, func(n int) string {
+		return utils.NumberedVolume(dest, n)
+	}), nil
+}
+
+// collect lists a source file, or a directory and everything below it.
+func collect(source string) ([]entry, error) {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat source %s: %w", source, err)
+	}
+	if !info.IsDir() {
+		return []entry{{path: source, name: filepath.Base(source), info: info}}, nil
+	}
+
+	var entries []entry
+	err = filepath.Walk(source, func(file string, fi os.FileInfo, err error) error {
 		if err != nil {
-			return fmt.Errorf("could not open file %s: %w", filePath, err)
+			return fmt.Errorf("error walking through directory %s: %w", source, err)
+		}
+		if file == source {
+			return nil
 		}
+		rel, err := filepath.Rel(source, file)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path: file, name: filepath.ToSlash(rel), info: fi})
+		return nil
+	})
+	return entries, err
+}
 
-		// Get the file info
-		fileInfo, err := file.Stat()
+// writeTarEntries adds each entry to the tar writer in the given format.
+// Files with several hard links are stored once, and as links after that.
+// Entries that cannot be stored are left out, and listed in the error
+// returned once the rest of the archive is written.
+func writeTarEntries(tarWriter *tar.Writer, entries []entry, format tar.Format) error {
+	links := make(map[linkKey]string)
+	rounded := false
+	var skipped []string
+	for _, e := range entries {
+		header, err := newHeader(e, format)
 		if err != nil {
-			return fmt.Errorf("could not stat file %s: %w", filePath, err)
+			skipped = append(skipped, fmt.Sprintf("%s: %v", e.path, err))
+			continue
+		}
+		if ids, ok := utils.FileIDs(e.info); ok && e.info.Mode().IsRegular() && ids.Nlink > 1 {
+			key := linkKey{ids.Dev, ids.Ino}
+			if first, ok := links[key]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+				header.PAXRecords = nil
+			} else {
+				links[key] = header.Name
+			}
 		}
 
-		// Create a tar header for this file
-		header := &tar.Header{
-			Name:    filepath.Base(filePath),
-			Size:    fileInfo.Size(),
-			Mode:    int64(fileInfo.Mode()),
-			ModTime: fileInfo.ModTime(),
+		droppedXattrs, roundedTime := fit(header)
+		// Check the header on its own, so a skipped entry leaves nothing behind
+		if err := tar.NewWriter(io.Discard).WriteHeader(header); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", e.path, err))
+			continue
+		}
+		if droppedXattrs {
+			fmt.Printf("Warning: %s format cannot store the extended attributes of %s, which are dropped\n", format, e.path)
+		}
+		rounded = rounded || roundedTime
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("could not write header for file %s: %w", e.path, err)
 		}
+		if header.Typeflag == tar.TypeReg {
+			if err := copyFile(tarWriter, e.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if rounded {
+		fmt.Printf("Warning: %s format stores modification times in whole seconds\n", format)
+	}
+	if len(skipped) > 0 {
+		return fmt.Errorf("%d of %d entries cannot be stored in %s format: %s", len(skipped), len(entries), format, strings.Join(skipped, "; "))
+	}
+	return nil
+}
 
-		// Write the header to the tar archive
-		err = tarWriter.WriteHeader(header)
+// newHeader describes an entry in full: times to the nanosecond, owners,
+// link targets, device numbers and extended attributes as PAX records.
+func newHeader(e entry, format tar.Format) (*tar.Header, error) {
+	var link string
+	if e.info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(e.path)
 		if err != nil {
-			return fmt.Errorf("could not write header for file %s: %w", filePath, err)
+			return nil, fmt.Errorf("could not read symlink: %w", err)
 		}
+		link = target
+	}
+	header, err := tar.FileInfoHeader(e.info, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = e.name
+	if e.info.IsDir() {
+		header.Name += "/"
+	}
+	header.Format = format
+	// Access and change times differ on every run, so they are not kept
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
 
-		// Write the file contents to the tar archive
-		_, err = io.Copy(tarWriter, file)
+	if e.info.Mode()&os.ModeSymlink == 0 {
+		xattrs, err := utils.Xattrs(e.path)
 		if err != nil {
-			return fmt.Errorf("could not write contents of file %s: %w", filePath, err)
+			fmt.Printf("Warning: could not read extended attributes of %s: %v\n", e.path, err)
 		}
-
-		// Ensure the file is closed after processing
-		if err := closeFile(file); err != nil {
-			fmt.Printf("Warning: failed to close file %s: %v\n", filePath, err)
+		for name, value := range xattrs {
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords[xattrPrefix+name] = string(value)
 		}
 	}
+	return header, nil
+}
 
+// fit drops what a USTAR or GNU header cannot store: extended attributes
+// and sub-second modification times, reporting which it dropped. Anything
+// else these formats cannot represent fails when the header is written.
+func fit(header *tar.Header) (droppedXattrs, roundedTime bool) {
+	if header.Format == tar.FormatPAX {
+		return false, false
+	}
+	if header.ModTime.Nanosecond() != 0 {
+		header.ModTime = header.ModTime.Truncate(time.Second)
+		roundedTime = true
+	}
+	if len(header.PAXRecords) > 0 {
+		header.PAXRecords = nil
+		droppedXattrs = true
+	}
+	return droppedXattrs, roundedTime
+}
+
+// copyFile writes the contents of the file at path to the tar writer.
+func copyFile(tarWriter *tar.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open file %s: %w", path, err)
+	}
+
+	// Write the file contents to the tar archive
+	_, err = io.Copy(tarWriter, file)
+	if err != nil {
+		_ = closeFile(file)
+		return fmt.Errorf("could not write contents of file %s: %w", path, err)
+	}
+
+	// Ensure the file is closed after processing
+	if err := closeFile(file); err != nil {
+		fmt.Printf("Warning: failed to close file %s: %v\n", path, err)
+	}
 	return nil
 }
 
 // createPasswordProtectedTar uses 7zip to create a password-protected tar archive.
-func createPasswordProtectedTar(sources []string, dest, password string) error {
+func createPasswordProtectedTar(sources []string, dest, password string, format tar.Format) error {
 	// Create a temporary tar file without password protection first
 	tempTar := dest + ".temp"
-	err := createStandardTar(sources, tempTar)
+	err := createStandardTar(sources, tempTar, Options{Format: format})
 	if err != nil {
 		return fmt.Errorf("failed to create temporary tar file: %w", err)
 	}
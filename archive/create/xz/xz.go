@@ -0,0 +1,55 @@
+package createxz
+
+import (
+	"fmt"
+	"futile/compress/xz"
+	"futile/utils"
+	"io"
+	"os"
+)
+
+// Create compresses a single source file into a standalone .xz file.
+// The level is an xz preset: -1 selects the default (6), 0 (fastest) through 9 (best).
+func Create(sources []string, dest string, level int) error {
+	if len(sources) != 1 {
+		return fmt.Errorf("XZ compresses a single file; use a .tar.xz archive for %d inputs", len(sources))
+	}
+	src := sources[0]
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", src, closeErr)
+		}
+	}()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create XZ file %s: %w", dest, err)
+	}
+
+	writer, err := xz.NewWriterLevel(out, level)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err := io.Copy(writer, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := writer.Close(); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to finish XZ stream %s: %w", dest, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close XZ file %s: %w", dest, err)
+	}
+	if err := utils.CopyModTime(src, dest); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", dest, err)
+	}
+
+	return nil
+}
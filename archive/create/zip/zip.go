@@ -1,18 +1,79 @@
 package zip
 
 import (
-	"archive/zip"
 	"fmt"
+	"futile/formats/jar"
+	"futile/formats/zip"
+	"futile/utils"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
+// Options are the settings of ZIP archive creation.
+type Options struct {
+	Zip64      zip.Zip64Mode
+	Level      int   // deflate level, or -1 for the default
+	VolumeSize int64 // split into volumes of this many bytes, or 0 for a single file
+
+	Password   string         // encrypts entries when set
+	Encryption zip.Encryption // AES key size of encrypted entries, or ZipCrypto
+
+	// Manifest is written first, after a META-INF/ directory entry, as the
+	// manifest of a Java archive. The entries it replaces are skipped when
+	// found among the sources.
+	Manifest []byte
+}
+
+// minVolumeSize is the smallest volume size of split archives, as with Info-ZIP.
+const minVolumeSize = 64 << 10
+
 // Create creates a standard ZIP archive from the provided source files and directories.
-func Create(sources []string, dest string) error {
-	// Create the ZIP file
-	zipFile, err := os.Create(dest)
+// The archive may be written to a pipe, in which case entries carry data descriptors.
+// A regular file is written under a temporary name and renamed once complete, so a
+// failed creation leaves any existing dest untouched.
+func Create(sources []string, dest string, opts Options) error {
+	if opts.VolumeSize > 0 {
+		return createSplit(sources, dest, opts)
+	}
+
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(dest); err == nil {
+		if !info.Mode().IsRegular() {
+			return createDirect(sources, dest, opts)
+		}
+		perm = info.Mode().Perm()
+	}
+
+	zipFile, err := os.CreateTemp(filepath.Dir(dest), ".futile-zip-*")
+	if err != nil {
+		return fmt.Errorf("failed to create ZIP file %s: %w", dest, err)
+	}
+	err = writeZip(sources, dest, zipFile, opts)
+	if closeErr := zipFile.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close ZIP file %s: %w", dest, closeErr)
+	}
+	if err == nil {
+		if err = os.Chmod(zipFile.Name(), perm); err == nil {
+			err = os.Rename(zipFile.Name(), dest)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to write ZIP file %s: %w", dest, err)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(zipFile.Name())
+		return err
+	}
+	return nil
+}
+
+// createDirect writes the archive straight to dest, such as a named pipe or
+// a device, which cannot be replaced by renaming.
+func createDirect(sources []string, dest string, opts Options) error {
+	zipFile, err := os.OpenFile(dest, os.O_WRONLY, 0)
 	if err != nil {
 		return fmt.Errorf("failed to create ZIP file %s: %w", dest, err)
 	}
@@ -23,77 +84,196 @@ func Create(sources []string, dest string) error {
 		}
 	}()
 
-	// Create a new zip.Writer
-	zipWriter := zip.NewWriter(zipFile)
-	// Ensure the zipWriter is closed
-	defer func() {
-		if closeErr := zipWriter.Close(); closeErr != nil {
-			fmt.Printf("Error closing ZIP writer for %s: %v\n", dest, closeErr)
+	return writeZip(sources, dest, zipFile, opts)
+}
+
+// writeZip writes the archive named dest to out.
+func writeZip(sources []string, dest string, out io.Writer, opts Options) error {
+	wopts := zip.WriterOptions{Zip64: opts.Zip64, Level: opts.Level}
+	if opts.Password != "" {
+		wopts.Encryption, wopts.Password = opts.Encryption, opts.Password
+		if opts.Encryption == zip.ZipCrypto {
+			fmt.Printf("Warning: %s uses ZipCrypto encryption, which is easily broken\n", dest)
 		}
-	}()
+	}
+	zipWriter := zip.NewWriter(out, wopts)
+
+	var skip map[string]bool
+	if opts.Manifest != nil {
+		if err := addManifest(opts.Manifest, zipWriter); err != nil {
+			return fmt.Errorf("failed to add manifest to ZIP: %w", err)
+		}
+		skip = map[string]bool{"META-INF/": true, jar.ManifestName: true}
+	}
 
 	// Iterate over each source file or directory
 	for _, source := range sources {
-		err := addToZip(source, zipWriter)
+		err := addToZip(source, zipWriter, skip)
 		if err != nil {
 			return fmt.Errorf("failed to add %s to ZIP: %w", source, err)
 		}
 	}
 
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to write ZIP file %s: %w", dest, err)
+	}
 	return nil
 }
 
-// addToZip adds a file or directory to the ZIP archive.
-func addToZip(source string, zipWriter *zip.Writer) error {
+// createSplit creates a split archive in the layout of Info-ZIP and
+// WinZip: volumes named like dest with the extensions .z01, .z02 and so
+// on, followed by dest itself, which holds the central directory.
+func createSplit(sources []string, dest string, opts Options) error {
+	if opts.VolumeSize < minVolumeSize {
+		return fmt.Errorf("volume size %d is too small for split ZIP archives; use at least 64K", opts.VolumeSize)
+	}
+	base := strings.TrimSuffix(dest, filepath.Ext(dest))
+	volumes := utils.NewVolumeWriter(opts.VolumeSize, func(n int) string {
+		return fmt.Sprintf("%s.z%02d", base, n)
+	})
+	err := writeZip(sources, dest, volumes, opts)
+	if closeErr := volumes.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to write ZIP volume: %w", closeErr)
+	}
+	if err != nil {
+		// Partial volumes would be mistaken for a complete set
+		for _, path := range volumes.Paths() {
+			_ = os.Remove(path)
+		}
+		return err
+	}
+
+	paths := volumes.Paths()
+	if err := os.Rename(paths[len(paths)-1], dest); err != nil {
+		return fmt.Errorf("failed to name the last volume %s: %w", dest, err)
+	}
+	if len(paths) == 1 {
+		// An archive that fits one volume is marked as not split after all
+		if err := markUnsplit(dest); err != nil {
+			return fmt.Errorf("failed to write ZIP file %s: %w", dest, err)
+		}
+	}
+	return nil
+}
+
+// markUnsplit replaces the signature a split archive begins with by the
+// one of archives that were to be split but fit a single volume.
+func markUnsplit(dest string) error {
+	f, err := os.OpenFile(dest, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt([]byte("PK00"), 0); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// addManifest writes the META-INF/ directory and the manifest in it.
+func addManifest(manifest []byte, zipWriter *zip.Writer) error {
+	now := time.Now()
+	if _, err := zipWriter.Create(&zip.FileHeader{
+		Name:     "META-INF/",
+		Modified: now,
+		Mode:     os.ModeDir | 0755,
+	}); err != nil {
+		return err
+	}
+	writer, err := zipWriter.Create(&zip.FileHeader{
+		Name:     jar.ManifestName,
+		Method:   zip.Deflate,
+		Modified: now,
+		Mode:     0644,
+		Size:     int64(len(manifest)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(manifest)
+	return err
+}
+
+// addToZip adds a file or directory to the ZIP archive. The contents of a
+// directory are named relative to it. Entries named in skip, in upper case,
+// are left out.
+func addToZip(source string, zipWriter *zip.Writer, skip map[string]bool) error {
 	info, err := os.Stat(source)
 	if err != nil {
 		return fmt.Errorf("failed to stat source %s: %w", source, err)
 	}
 
 	if info.IsDir() {
-		// Use manual file opening and closing inside loop to avoid `defer` in loops
-		dirWalkErr := filepath.Walk(source, func(file string, fi os.FileInfo, err error) error {
+		return filepath.Walk(source, func(file string, fi os.FileInfo, err error) error {
 			if err != nil {
 				return fmt.Errorf("error walking through directory %s: %w", source, err)
 			}
 			if file == source {
 				return nil
 			}
-			return addFileToZip(file, source, zipWriter)
+			relativePath, err := filepath.Rel(source, file)
+			if err != nil {
+				return err
+			}
+			return addFileToZip(file, relativePath, fi, zipWriter, skip)
 		})
-		if dirWalkErr != nil {
-			return dirWalkErr
-		}
-	} else {
-		// For files, add them directly
-		return addFileToZip(source, "", zipWriter)
 	}
-
-	return nil
+	// For files, add them directly
+	return addFileToZip(source, source, info, zipWriter, skip)
 }
 
-// addFileToZip adds a single file to the ZIP archive.
-func addFileToZip(file, baseDir string, zipWriter *zip.Writer) error {
-	fileToZip, err := os.Open(file)
-	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", file, err)
+// addFileToZip adds a single file, directory or symlink to the ZIP archive.
+func addFileToZip(file, name string, info os.FileInfo, zipWriter *zip.Writer, skip map[string]bool) error {
+	fileHeader := &zip.FileHeader{
+		Name:     strings.TrimLeft(filepath.ToSlash(name), "/"),
+		Method:   zip.Deflate,
+		Modified: info.ModTime(),
+		Mode:     info.Mode(),
+		Size:     info.Size(),
+	}
+
+	if info.IsDir() {
+		fileHeader.Name += "/"
+		fileHeader.Size = 0
 	}
-	// Close the file explicitly
-	closeErr := fileToZip.Close()
-	if closeErr != nil {
-		fmt.Printf("Error closing file %s: %v\n", file, closeErr)
+	if skip[strings.ToUpper(fileHeader.Name)] {
+		return nil
 	}
 
-	relativePath := file
-	if baseDir != "" {
-		relativePath, _ = filepath.Rel(baseDir, file)
+	switch {
+	case info.IsDir():
+		_, err := zipWriter.Create(fileHeader)
+		return err
+	case info.Mode()&os.ModeSymlink != 0:
+		// Symlinks store their target as content
+		target, err := os.Readlink(file)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", file, err)
+		}
+		fileHeader.Method = zip.Store
+		fileHeader.Size = int64(len(target))
+		writer, err := zipWriter.Create(fileHeader)
+		if err != nil {
+			return fmt.Errorf("failed to create header for file %s: %w", file, err)
+		}
+		_, err = io.WriteString(writer, target)
+		return err
+	case !info.Mode().IsRegular():
+		fmt.Printf("Warning: skipping special file %s\n", file)
+		return nil
 	}
-	fileHeader := &zip.FileHeader{
-		Name:   relativePath,
-		Method: zip.Deflate,
+
+	fileToZip, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", file, err)
 	}
+	defer func() {
+		if closeErr := fileToZip.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", file, closeErr)
+		}
+	}()
 
-	writer, err := zipWriter.CreateHeader(fileHeader)
+	writer, err := zipWriter.Create(fileHeader)
 	if err != nil {
 		return fmt.Errorf("failed to create header for file %s: %w", file, err)
 	}
@@ -105,17 +285,3 @@ func addFileToZip(file, baseDir string, zipWriter *zip.Writer) error {
 
 	return nil
 }
-
-// CreatePasswordProtected creates a password-protected ZIP archive using 7z.
-func CreatePasswordProtected(sources []string, dest, password string) error {
-	cmd := exec.Command("7z", "a", "-p"+password, dest)
-	cmd.Args = append(cmd.Args, sources...)
-
-	// Execute the 7z command
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to create password-protected ZIP file %s: %w", dest, err)
-	}
-
-	return nil
-}
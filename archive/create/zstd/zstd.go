@@ -0,0 +1,107 @@
+package createzstd
+
+import (
+	"fmt"
+	"futile/compress/zstd"
+	"futile/utils"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DefaultDictSize matches the dictionary size chosen by the zstd tool.
+const DefaultDictSize = 112640
+
+// Create compresses a single source file into a standalone .zst file.
+func Create(sources []string, dest string, opts zstd.WriterOptions) error {
+	if len(sources) != 1 {
+		return fmt.Errorf("Zstandard compresses a single file; use a .tar.zst archive for %d inputs", len(sources))
+	}
+	src := sources[0]
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing file %s: %v\n", src, closeErr)
+		}
+	}()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create Zstandard file %s: %w", dest, err)
+	}
+
+	writer, err := zstd.NewWriterOptions(out, opts)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err := io.Copy(writer, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := writer.Close(); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to finish Zstandard stream %s: %w", dest, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close Zstandard file %s: %w", dest, err)
+	}
+	if err := utils.CopyModTime(src, dest); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", dest, err)
+	}
+
+	return nil
+}
+
+// Train builds a dictionary from the given files, or every file below the
+// given directories, and writes it to dest.
+func Train(sources []string, dest string, size int) error {
+	var samples [][]byte
+	for _, source := range sources {
+		err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read sample %s: %w", path, err)
+			}
+			samples = append(samples, data)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to collect samples from %s: %w", source, err)
+		}
+	}
+
+	dict, err := zstd.TrainDict(samples, size)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dest, dict, 0644); err != nil {
+		return fmt.Errorf("failed to write dictionary %s: %w", dest, err)
+	}
+	fmt.Printf("Trained a %d byte dictionary from %d samples\n", len(dict), len(samples))
+	return nil
+}
+
+// LoadDict reads a dictionary file for use with the compressor or decompressor.
+func LoadDict(path string) (*zstd.Dict, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dictionary %s: %w", path, err)
+	}
+	dict, err := zstd.ParseDict(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dictionary %s: %w", path, err)
+	}
+	return dict, nil
+}
@@ -0,0 +1,106 @@
+package extractar
+
+import (
+	"fmt"
+	"futile/formats/ar"
+	"futile/utils"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Extract unpacks the members of an ar archive into dest, restoring their
+// modes and, unless zeroed by a deterministic archive, their timestamps.
+func Extract(src, dest string) error {
+	in, r, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closeArchive(in, src)
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ar archive %s: %w", src, err)
+		}
+		// Members are flat, so only the base name is used
+		target, err := utils.SafeJoin(dest, filepath.Base(filepath.FromSlash(hdr.Name)))
+		if err != nil || target == dest {
+			return fmt.Errorf("illegal path in archive: %s", hdr.Name)
+		}
+		if err := writeMember(r, hdr, target); err != nil {
+			return err
+		}
+	}
+}
+
+// List prints the members of an ar archive in the style of "ar tv".
+func List(src string) error {
+	in, r, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closeArchive(in, src)
+
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ar archive %s: %w", src, err)
+		}
+		fmt.Printf("%s %d/%d %10d %s %s\n", os.FileMode(hdr.Mode&0777), hdr.Uid, hdr.Gid, hdr.Size,
+			hdr.ModTime.UTC().Format("Jan _2 15:04 2006"), hdr.Name)
+	}
+}
+
+func open(src string) (*os.File, *ar.Reader, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open ar archive %s: %w", src, err)
+	}
+	r, err := ar.NewReader(in)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, fmt.Errorf("failed to read ar archive %s: %w", src, err)
+	}
+	return in, r, nil
+}
+
+func closeArchive(in *os.File, src string) {
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing ar archive %s: %v\n", src, closeErr)
+	}
+}
+
+func writeMember(r io.Reader, hdr *ar.Header, target string) error {
+	perm := os.FileMode(hdr.Mode & 0777)
+	if perm == 0 {
+		perm = 0644
+	}
+	_ = os.Remove(target)
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", target, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", target, err)
+	}
+	if hdr.ModTime.Unix() != 0 {
+		if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+			return fmt.Errorf("failed to set modification time of %s: %w", target, err)
+		}
+	}
+	return nil
+}
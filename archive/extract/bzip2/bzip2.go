@@ -0,0 +1,47 @@
+package extractbzip2
+
+import (
+	"compress/bzip2"
+	"fmt"
+	"futile/utils"
+	"io"
+	"os"
+)
+
+// Extract decompresses a standalone .bz2 file to dest, or into dest when it is
+// a directory, named after the input without its .bz2 extension.
+func Extract(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open bzip2 file %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing bzip2 file %s: %v\n", src, closeErr)
+		}
+	}()
+
+	reader := bzip2.NewReader(in)
+
+	destPath, err := utils.DecompressedPath(dest, utils.DecompressedName(src, ".bz2"))
+	if err != nil {
+		return err
+	}
+	out, err := utils.CreateDecompressed(src, destPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, reader); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to decompress %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", destPath, err)
+	}
+	if err := utils.CopyModTime(src, destPath); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", destPath, err)
+	}
+
+	return nil
+}
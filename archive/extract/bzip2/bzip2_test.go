@@ -0,0 +1,31 @@
+package extractbzip2
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/multistream.bz2 holds two streams, the second of two blocks:
+//
+//	(printf 'first stream\n' | bzip2 -9; seq 1 20000 | bzip2 -1) > multistream.bz2
+func TestExtractMultipleStreams(t *testing.T) {
+	dest := t.TempDir()
+	if err := Extract(filepath.Join("testdata", "multistream.bz2"), dest+string(filepath.Separator)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "multistream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := bytes.NewBufferString("first stream\n")
+	for i := 1; i <= 20000; i++ {
+		fmt.Fprintf(want, "%d\n", i)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("got %d bytes, want %d", len(got), want.Len())
+	}
+}
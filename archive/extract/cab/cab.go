@@ -0,0 +1,160 @@
+package extractcab
+
+import (
+	"fmt"
+	"futile/formats/cab"
+	"futile/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxCabinets bounds the length of a cabinet set, guarding against cycles.
+const maxCabinets = 1000
+
+// Extract writes the files of a cabinet, or of the whole set it belongs to,
+// into dest. The other cabinets of a set are looked up next to src.
+func Extract(src, dest string) error {
+	set, files, err := openSet(src)
+	if err != nil {
+		return err
+	}
+	defer closeAll(files)
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	err = set.Walk(func(f *cab.File, r io.Reader) error {
+		target, err := utils.SafeJoin(dest, f.Name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", target, err)
+		}
+		_ = os.Remove(target)
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode())
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %w", target, err)
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			_ = out.Close()
+			return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+		}
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("failed to close file %s: %w", target, err)
+		}
+		if !f.ModTime.IsZero() {
+			if err := os.Chtimes(target, f.ModTime, f.ModTime); err != nil {
+				return fmt.Errorf("failed to set modification time of %s: %w", target, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extract cabinet %s: %w", src, err)
+	}
+	return nil
+}
+
+// List prints the files of a cabinet set with their compression.
+func List(src string) error {
+	set, files, err := openSet(src)
+	if err != nil {
+		return err
+	}
+	defer closeAll(files)
+
+	for _, f := range set.Files {
+		fmt.Printf("%s %10d %-8s %s %s\n", f.Mode(), f.Size, cab.CompressionName(f.Compression()),
+			f.ModTime.Format("2006-01-02 15:04"), f.Name)
+	}
+	return nil
+}
+
+// openSet reads the cabinet at src together with the cabinets before and
+// after it in its set.
+func openSet(src string) (*cab.Set, []*os.File, error) {
+	dir := filepath.Dir(src)
+	files := []*os.File{}
+	open := func(path string) (*cab.Cabinet, error) {
+		in, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, in)
+		c, err := cab.Read(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cabinet %s: %w", path, err)
+		}
+		return c, nil
+	}
+	fail := func(err error) (*cab.Set, []*os.File, error) {
+		closeAll(files)
+		return nil, nil, err
+	}
+
+	c, err := open(src)
+	if err != nil {
+		return fail(fmt.Errorf("failed to open cabinet %s: %w", src, err))
+	}
+
+	// Walk back to the first cabinet, then forward through the set
+	cabs := []*cab.Cabinet{c}
+	for len(cabs) < maxCabinets && cabs[0].PrevCabinet != "" {
+		path, err := findCabinet(dir, cabs[0].PrevCabinet)
+		if err != nil {
+			return fail(err)
+		}
+		prev, err := open(path)
+		if err != nil {
+			return fail(err)
+		}
+		cabs = append([]*cab.Cabinet{prev}, cabs...)
+	}
+	for len(cabs) < maxCabinets && cabs[len(cabs)-1].NextCabinet != "" {
+		path, err := findCabinet(dir, cabs[len(cabs)-1].NextCabinet)
+		if err != nil {
+			return fail(err)
+		}
+		next, err := open(path)
+		if err != nil {
+			return fail(err)
+		}
+		cabs = append(cabs, next)
+	}
+
+	set, err := cab.NewSet(cabs)
+	if err != nil {
+		return fail(fmt.Errorf("failed to read cabinet %s: %w", src, err))
+	}
+	return set, files, nil
+}
+
+// findCabinet locates another cabinet of a set in dir. Cabinets made on
+// Windows often name their neighbours in a different case.
+func findCabinet(dir, name string) (string, error) {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err == nil {
+		for _, e := range entries {
+			if strings.EqualFold(e.Name(), name) {
+				return filepath.Join(dir, e.Name()), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cabinet set is incomplete: missing %s", path)
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		if closeErr := f.Close(); closeErr != nil {
+			fmt.Printf("Error closing cabinet %s: %v\n", f.Name(), closeErr)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package extractcpio
+
+import (
+	"fmt"
+	"futile/formats/cpio"
+	"futile/utils"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// linkKey identifies the members that are hard links of each other.
+type linkKey struct {
+	devMajor, devMinor, ino int64
+}
+
+// linkGroup tracks a set of hard links: the path holding the data once it has
+// been written, and the members seen before it.
+type linkGroup struct {
+	target  string
+	pending []string
+}
+
+// Extract unpacks a cpio archive in the newc, crc or odc format into dest.
+func Extract(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open cpio archive %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing cpio archive %s: %v\n", src, closeErr)
+		}
+	}()
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	return ExtractReader(in, dest)
+}
+
+// List prints the members of a cpio archive in the style of "tar tv".
+func List(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open cpio archive %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing cpio archive %s: %v\n", src, closeErr)
+		}
+	}()
+
+	cr := cpio.NewReader(in)
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read cpio archive %s: %w", src, err)
+		}
+		entry := hdr.Name
+		if hdr.Mode&cpio.TypeMask == cpio.TypeDir {
+			entry += "/"
+		}
+		if hdr.Linkname != "" {
+			entry += " -> " + hdr.Linkname
+		}
+		fmt.Printf("%s %d/%d %10d %s %s\n", hdr.FileMode(), hdr.Uid, hdr.Gid, hdr.Size,
+			hdr.ModTime.UTC().Format("2006-01-02 15:04"), entry)
+	}
+}
+
+// ExtractReader unpacks a cpio stream into dest.
+func ExtractReader(r io.Reader, dest string) error {
+	cr := cpio.NewReader(r)
+	links := make(map[linkKey]*linkGroup)
+	var dirs utils.DirMetadata
+
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read cpio archive: %w", err)
+		}
+		target, err := utils.SafeJoin(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", target, err)
+		}
+
+		switch hdr.Mode & cpio.TypeMask {
+		case cpio.TypeDir:
+			if err := utils.MakeDir(target); err != nil {
+				return err
+			}
+			dirs.Add(target, hdr.FileMode(), hdr.ModTime)
+		case cpio.TypeReg:
+			if hdr.Nlink > 1 {
+				if err := extractLink(cr, hdr, target, links); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := writeFile(cr, hdr, target); err != nil {
+				return err
+			}
+		case cpio.TypeSymlink:
+			_ = os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", target, err)
+			}
+		default:
+			_ = os.Remove(target)
+			err := utils.Mknod(target, uint32(hdr.Mode), uint32(hdr.RDevMajor), uint32(hdr.RDevMinor))
+			if err != nil {
+				// Device nodes usually need root, so this is not fatal
+				fmt.Printf("Warning: could not create special file %s: %v\n", hdr.Name, err)
+				continue
+			}
+			_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+	}
+
+	// Links whose data never appeared are left as empty files
+	for _, group := range links {
+		if group.target != "" {
+			continue
+		}
+		for _, path := range group.pending {
+			if err := os.WriteFile(path, nil, 0644); err != nil {
+				return fmt.Errorf("failed to create file %s: %w", path, err)
+			}
+		}
+	}
+
+	return dirs.Apply()
+}
+
+// extractLink handles a member that shares its inode with others. The member
+// carrying data is written out and every other one becomes a hard link to it.
+func extractLink(cr *cpio.Reader, hdr *cpio.Header, target string, links map[linkKey]*linkGroup) error {
+	key := linkKey{hdr.DevMajor, hdr.DevMinor, hdr.Ino}
+	group := links[key]
+	if group == nil {
+		group = &linkGroup{}
+		links[key] = group
+	}
+
+	if group.target != "" {
+		return link(group.target, target)
+	}
+	if hdr.Size == 0 {
+		group.pending = append(group.pending, target)
+		return nil
+	}
+	if err := writeFile(cr, hdr, target); err != nil {
+		return err
+	}
+	group.target = target
+	for _, path := range group.pending {
+		if err := link(target, path); err != nil {
+			return err
+		}
+	}
+	group.pending = nil
+	return nil
+}
+
+func link(oldname, newname string) error {
+	_ = os.Remove(newname)
+	if err := os.Link(oldname, newname); err != nil {
+		return fmt.Errorf("failed to create hard link %s: %w", newname, err)
+	}
+	return nil
+}
+
+// writeFile writes the data of the current member to target with its mode
+// and modification time.
+func writeFile(cr *cpio.Reader, hdr *cpio.Header, target string) error {
+	out, err := utils.CreateFile(target, hdr.FileMode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", target, err)
+	}
+	if _, err := io.Copy(out, cr); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", target, err)
+	}
+	return utils.SetModeAndTime(target, hdr.FileMode(), hdr.ModTime)
+}
@@ -0,0 +1,106 @@
+package extractdeb
+
+import (
+	"archive/tar"
+	"fmt"
+	extractTar "futile/archive/extract/tar"
+	"futile/formats/deb"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Extract unpacks the files a Debian package installs into dest, like
+// "dpkg-deb -x". The control files are not extracted.
+func Extract(src, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	return walk(src, func(member deb.Member, name string, r io.Reader) error {
+		if member != deb.MemberData {
+			return nil
+		}
+		if err := extractTar.ExtractReader(r, dest); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// List prints the files a Debian package installs in the style of "tar tv".
+func List(src string) error {
+	return walk(src, func(member deb.Member, name string, r io.Reader) error {
+		if member != deb.MemberData {
+			return nil
+		}
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			entry := hdr.Name
+			switch hdr.Typeflag {
+			case tar.TypeSymlink:
+				entry += " -> " + hdr.Linkname
+			case tar.TypeLink:
+				entry += " link to " + hdr.Linkname
+			}
+			fmt.Printf("%s %s/%s %10d %s %s\n", hdr.FileInfo().Mode(), owner(hdr.Uname, hdr.Uid), owner(hdr.Gname, hdr.Gid),
+				hdr.Size, hdr.ModTime.UTC().Format("2006-01-02 15:04"), entry)
+		}
+	})
+}
+
+// Info prints the names of the control files and the control fields.
+func Info(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open package %s: %w", src, err)
+	}
+	defer closePackage(in, src)
+
+	control, files, err := deb.ReadControl(in)
+	if err != nil {
+		return fmt.Errorf("failed to read package %s: %w", src, err)
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Control files: %s\n\n", strings.Join(names, ", "))
+	fmt.Print(control.String())
+	return nil
+}
+
+func walk(src string, fn func(deb.Member, string, io.Reader) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open package %s: %w", src, err)
+	}
+	defer closePackage(in, src)
+
+	if err := deb.Walk(in, fn); err != nil {
+		return fmt.Errorf("failed to read package %s: %w", src, err)
+	}
+	return nil
+}
+
+func closePackage(in *os.File, src string) {
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing package %s: %v\n", src, closeErr)
+	}
+}
+
+func owner(name string, id int) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprint(id)
+}
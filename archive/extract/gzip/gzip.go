@@ -0,0 +1,64 @@
+package extractgzip
+
+import (
+	"compress/gzip"
+	"fmt"
+	"futile/utils"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Extract decompresses a standalone .gz file to dest, or into dest when it is
+// a directory. Concatenated gzip members are decompressed as one stream. The
+// output takes the modification time from the gzip header when present, and
+// inside a directory also the original name, falling back to the input name
+// without its .gz extension.
+func Extract(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open gzip file %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing gzip file %s: %v\n", src, closeErr)
+		}
+	}()
+
+	reader, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("failed to read gzip stream %s: %w", src, err)
+	}
+	defer reader.Close()
+
+	name := utils.DecompressedName(src, ".gz")
+	// Only the base of the stored name is used, so a crafted header cannot
+	// place the output outside the destination directory
+	if stored := filepath.Base(filepath.FromSlash(reader.Name)); reader.Name != "" && stored != "." && stored != ".." && stored != string(filepath.Separator) {
+		name = stored
+	}
+	destPath, err := utils.DecompressedPath(dest, name)
+	if err != nil {
+		return err
+	}
+	out, err := utils.CreateDecompressed(src, destPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, reader); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to decompress %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", destPath, err)
+	}
+
+	if !reader.ModTime.IsZero() {
+		if err := os.Chtimes(destPath, reader.ModTime, reader.ModTime); err != nil {
+			return fmt.Errorf("failed to set modification time of %s: %w", destPath, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package extractiso9660
+
+import (
+	"fmt"
+	"futile/formats/iso9660"
+	"futile/utils"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Extract copies the files of an ISO 9660 image into dest without mounting
+// it. Rock Ridge permissions, symlinks and names are used when present,
+// otherwise Joliet names.
+func Extract(src, dest string) error {
+	in, img, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closeImage(in, src)
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	var dirs utils.DirMetadata
+	err = img.Walk(func(f *iso9660.File) error {
+		target, err := utils.SafeJoin(dest, f.Path)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			return nil
+		}
+
+		switch {
+		case f.Mode.IsDir():
+			if err := utils.MakeDir(target); err != nil {
+				return err
+			}
+			dirs.Add(target, f.Mode, f.ModTime)
+		case f.Mode&fs.ModeSymlink != 0:
+			_ = os.Remove(target)
+			if err := os.Symlink(f.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", target, err)
+			}
+		case f.Mode.IsRegular():
+			if err := writeFile(f, target); err != nil {
+				return err
+			}
+		default:
+			_ = os.Remove(target)
+			if err := utils.Mknod(target, utils.UnixMode(f.Mode), f.RDevMajor, f.RDevMinor); err != nil {
+				// Device nodes usually need root, so this is not fatal
+				fmt.Printf("Warning: could not create special file %s: %v\n", f.Path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extract image %s: %w", src, err)
+	}
+
+	return dirs.Apply()
+}
+
+// List prints the files of an ISO 9660 image in the style of "tar tv".
+func List(src string) error {
+	in, img, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closeImage(in, src)
+
+	return img.Walk(func(f *iso9660.File) error {
+		entry := f.Path
+		if f.Mode.IsDir() {
+			entry += "/"
+		}
+		if f.Linkname != "" {
+			entry += " -> " + f.Linkname
+		}
+		fmt.Printf("%s %d/%d %10d %s %s\n", f.Mode, f.Uid, f.Gid, f.Size,
+			f.ModTime.UTC().Format("2006-01-02 15:04"), entry)
+		return nil
+	})
+}
+
+func open(src string) (*os.File, *iso9660.Image, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open image %s: %w", src, err)
+	}
+	img, err := iso9660.Open(in)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, fmt.Errorf("failed to read image %s: %w", src, err)
+	}
+	return in, img, nil
+}
+
+func closeImage(in *os.File, src string) {
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing image %s: %v\n", src, closeErr)
+	}
+}
+
+func writeFile(f *iso9660.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+	out, err := utils.CreateFile(target, f.Mode.Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", target, err)
+	}
+	if _, err := io.Copy(out, f.Open()); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to extract %s: %w", f.Path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", target, err)
+	}
+	return utils.SetModeAndTime(target, f.Mode, f.ModTime)
+}
@@ -0,0 +1,39 @@
+package extractjar
+
+import (
+	"archive/zip"
+	"fmt"
+	"futile/formats/jar"
+	"strings"
+)
+
+// Info prints the kind of a Java archive and its manifest attributes.
+// Sections of the manifest about single entries are counted, not printed.
+func Info(src string) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to open Java archive %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := zr.Close(); closeErr != nil {
+			fmt.Printf("Error closing Java archive %s: %v\n", src, closeErr)
+		}
+	}()
+
+	manifest, err := jar.ReadManifest(&zr.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to read Java archive %s: %w", src, err)
+	}
+	field := func(name, value string) {
+		fmt.Printf("%-15s: %s\n", name, value)
+	}
+	field("Kind", strings.ToUpper(jar.KindOf(src)))
+	field("Entries", fmt.Sprintf("%d", len(zr.File)))
+	field("Entry sections", fmt.Sprintf("%d", len(manifest.Entries)))
+
+	fmt.Println()
+	for _, a := range manifest.Main {
+		fmt.Printf("%s: %s\n", a.Name, a.Value)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package extractoci
+
+import (
+	"archive/tar"
+	"fmt"
+	extractTar "futile/archive/extract/tar"
+	"futile/formats/oci"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Flatten writes the root file system of a container image tarball, as
+// "docker save" writes or in the OCI image layout, to dest: a directory, or
+// a single tar archive when dest ends in .tar. The platform, such as
+// linux/arm64, chooses among the images of a multi-platform index and
+// defaults to Linux on this machine's architecture.
+func Flatten(src, dest, platform string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open image %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing image %s: %v\n", src, closeErr)
+		}
+	}()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat image %s: %w", src, err)
+	}
+
+	archive, err := oci.Open(in, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %w", src, err)
+	}
+	images, err := archive.Images()
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %w", src, err)
+	}
+	want := oci.Platform{OS: "linux", Architecture: runtime.GOARCH}
+	if platform != "" {
+		if want, err = oci.ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
+	img, warnings, err := oci.Select(images, want, platform != "")
+	if err != nil {
+		return err
+	}
+	printWarnings(warnings)
+
+	if strings.HasSuffix(strings.ToLower(dest), ".tar") {
+		return flattenToTar(archive, img, dest)
+	}
+	return flattenToDir(archive, img, dest)
+}
+
+// flattenToTar writes the merged file system into a tar archive at dest.
+func flattenToTar(archive *oci.Archive, img oci.Image, dest string) (err error) {
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close %s: %w", dest, closeErr)
+		}
+	}()
+
+	tw := tar.NewWriter(out)
+	warnings, err := archive.Flatten(img, tw)
+	printWarnings(warnings)
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish %s: %w", dest, err)
+	}
+	return nil
+}
+
+// flattenToDir extracts the merged file system into dest, streaming it
+// through the tar extractor.
+func flattenToDir(archive *oci.Archive, img oci.Image, dest string) error {
+	pr, pw := io.Pipe()
+	type result struct {
+		warnings []string
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tw := tar.NewWriter(pw)
+		warnings, err := archive.Flatten(img, tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		_ = pw.CloseWithError(err)
+		done <- result{warnings, err}
+	}()
+
+	err := extractTar.ExtractReader(pr, dest)
+	// Stop the writer if extraction ended early
+	_ = pr.CloseWithError(fmt.Errorf("extraction stopped"))
+	res := <-done
+	printWarnings(res.warnings)
+	if res.err != nil && err != nil {
+		// The extractor only saw the pipe fail; the image is to blame
+		return res.err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract image: %w", err)
+	}
+	return nil
+}
+
+func printWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+}
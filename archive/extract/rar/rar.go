@@ -1,33 +1,424 @@
 package extractrar
 
 import (
+	"errors"
 	"fmt"
+	"futile/formats/rar"
+	"futile/utils"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
-// Extract extracts the contents of a RAR archive.
+// Extract extracts the contents of a RAR archive. Archives whose entries are
+// all stored are extracted natively; compressed or encrypted entries need the
+// unrar or 7z binary. Multi-volume archives are extracted from any of their
+// volumes, named either "name.part1.rar", "name.part2.rar" and so on, or
+// "name.rar", "name.r00", "name.r01" and so on.
 func Extract(src, dest, password string) error {
+	volumes, err := openVolumes(src)
+	if err != nil {
+		return err
+	}
+	defer volumes.close()
+	first := volumes[0]
+
+	entries := volumes.entries()
+	external := needsExternal(entries)
+	if len(external) == 0 && !first.a.EncryptedHeaders {
+		return extractNative(entries, src, dest, nil)
+	}
+	if backend := externalBackend(); backend != "" {
+		return extractExternal(backend, first.path, dest, password)
+	}
+	if first.a.EncryptedHeaders {
+		return fmt.Errorf("RAR archive %s has encrypted headers; install unrar or 7z to extract it", src)
+	}
+
+	// Extract what we can, then name what was left behind
+	if err := extractNative(entries, src, dest, external); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(external))
+	for _, e := range entries {
+		if external[e] {
+			names = append(names, e.Name)
+		}
+	}
+	return fmt.Errorf("extracted the stored entries of %s; install unrar or 7z for the %d compressed "+
+		"or encrypted ones: %s", src, len(names), strings.Join(names, ", "))
+}
+
+// volume is an opened volume of a RAR archive.
+type volume struct {
+	path string
+	in   *os.File
+	a    *rar.Archive
+}
+
+// more reports whether another volume follows. Old archives without an
+// end of archive block only say so by splitting their last entry.
+func (v *volume) more() bool {
+	if v.a.EncryptedHeaders {
+		return false
+	}
+	if v.a.MoreVolumes {
+		return true
+	}
+	return len(v.a.Files) > 0 && v.a.Files[len(v.a.Files)-1].SplitAfter
+}
+
+// volumeSet holds the volumes of a multi-volume archive in order, or the
+// single volume of any other.
+type volumeSet []*volume
+
+var (
+	partVolume = regexp.MustCompile(`(?i)^(.*\.part)(\d+)(\.rar)$`)
+	oldVolume  = regexp.MustCompile(`(?i)^(.*)\.(rar|r\d{2})$`)
+)
+
+// volumeNamer returns a function naming volume n, from zero, of the set
+// src belongs to, or nil when the name follows neither naming scheme.
+func volumeNamer(src string) func(n int) string {
+	if m := partVolume.FindStringSubmatch(src); m != nil {
+		width := len(m[2])
+		return func(n int) string {
+			return fmt.Sprintf("%s%0*d%s", m[1], width, n+1, m[3])
+		}
+	}
+	if m := oldVolume.FindStringSubmatch(src); m != nil {
+		return func(n int) string {
+			if n == 0 {
+				return m[1] + ".rar"
+			}
+			return fmt.Sprintf("%s.r%02d", m[1], n-1)
+		}
+	}
+	return nil
+}
+
+// openVolumes opens src and, when it is a volume, every volume of its
+// set from the first. Volumes are followed for as long as the last one
+// says another follows, and those missing are reported by name.
+func openVolumes(src string) (volumeSet, error) {
+	in, a, err := open(src)
+	if err != nil {
+		return nil, err
+	}
+	given := &volume{src, in, a}
+	name := volumeNamer(src)
+	if !a.Volume || a.EncryptedHeaders || name == nil {
+		return volumeSet{given}, nil
+	}
+
+	var volumes volumeSet
+	if name(0) == src {
+		volumes = append(volumes, given)
+	} else {
+		closeArchive(in, src)
+	}
+	for n := len(volumes); n == 0 || volumes[n-1].more(); n++ {
+		path := name(n)
+		in, a, err := open(path)
+		if err != nil {
+			volumes.close()
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("failed to open RAR archive %s: volume %s is missing", src, path)
+			}
+			return nil, err
+		}
+		volumes = append(volumes, &volume{path, in, a})
+	}
+	return volumes, nil
+}
+
+func (s volumeSet) close() {
+	for _, v := range s {
+		closeArchive(v.in, v.path)
+	}
+}
+
+// entry is a file of an archive, with its parts in each volume it spans.
+type entry struct {
+	*rar.File // of the first part
+	parts     []rar.Part
+}
+
+// entries joins the parts of files split across volumes.
+func (s volumeSet) entries() []*entry {
+	var entries []*entry
+	var split *entry // continued in the next volume
+	for _, v := range s {
+		for _, f := range v.a.Files {
+			part := rar.Part{Archive: v.a, File: f}
+			e := split
+			if !f.SplitBefore || e == nil || e.Name != f.Name {
+				e = &entry{File: f}
+				entries = append(entries, e)
+			}
+			e.parts = append(e.parts, part)
+			split = nil
+			if f.SplitAfter {
+				split = e
+			}
+		}
+	}
+	return entries
+}
+
+// needsExternal returns the entries that cannot be extracted natively.
+func needsExternal(entries []*entry) map[*entry]bool {
+	external := make(map[*entry]bool)
+	for _, e := range entries {
+		if e.Mode.IsDir() {
+			continue
+		}
+		if e.SplitBefore || e.parts[len(e.parts)-1].File.SplitAfter {
+			external[e] = true
+		}
+		for _, p := range e.parts {
+			if !p.File.Stored() || p.File.Encrypted {
+				external[e] = true
+			}
+		}
+	}
+	return external
+}
+
+// externalBackend returns the first RAR capable binary found on the PATH.
+func externalBackend() string {
+	for _, name := range []string{"unrar", "7z"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func extractExternal(backend, src, dest, password string) error {
 	// Ensure the destination directory exists
 	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
-	// Prepare the 7zip command arguments for extracting a RAR archive
-	cmdArgs := []string{"x", src, "-o" + dest}
+	var cmdArgs []string
+	if backend == "unrar" {
+		// "-o+" overwrites, and "-p-" keeps unrar from prompting
+		cmdArgs = []string{"x", "-o+", "-p-"}
+		if password != "" {
+			cmdArgs[2] = "-p" + password
+		}
+		cmdArgs = append(cmdArgs, src, dest+string(filepath.Separator))
+	} else {
+		cmdArgs = []string{"x", src, "-o" + dest, "-y"}
+		if password != "" {
+			cmdArgs = append(cmdArgs, "-p"+password)
+		}
+	}
+
+	cmd := exec.Command(backend, cmdArgs...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to extract RAR archive with %s: %w", backend, err)
+	}
+	return nil
+}
+
+// extractNative writes the entries, except those in skip.
+func extractNative(entries []*entry, src, dest string, skip map[*entry]bool) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	var dirs utils.DirMetadata
+	for _, f := range entries {
+		if skip[f] {
+			continue
+		}
+		target, err := utils.SafeJoin(dest, f.Name)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			continue
+		}
+
+		switch {
+		case f.Mode.IsDir():
+			if err := utils.MakeDir(target); err != nil {
+				return err
+			}
+			dirs.Add(target, f.Mode, f.ModTime)
+		case f.Mode&fs.ModeSymlink != 0:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", target, err)
+			}
+			_ = os.Remove(target)
+			if err := os.Symlink(f.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", target, err)
+			}
+		case f.Mode.IsRegular():
+			if err := writeFile(f, target); err != nil {
+				return fmt.Errorf("failed to extract RAR archive %s: %w", src, err)
+			}
+		default:
+			fmt.Printf("Warning: skipping special file %s\n", f.Name)
+		}
+	}
+
+	return dirs.Apply()
+}
+
+func writeFile(f *entry, target string) error {
+	r, err := rar.OpenParts(f.parts)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+	out, err := utils.CreateFile(target, f.Mode.Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", target, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", target, err)
+	}
+	return utils.SetModeAndTime(target, f.Mode, f.ModTime)
+}
+
+// List prints the entries of a RAR archive in the style of "tar tv", with
+// the packed size, the compression method and these flags: E encrypted,
+// S solid, < continued from the previous volume, > continued in the next.
+func List(src string) error {
+	in, a, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closeArchive(in, src)
 
-	// If password is provided, add the -p flag with the password
-	if password != "" {
-		cmdArgs = append(cmdArgs, "-p"+password)
+	if a.EncryptedHeaders {
+		return fmt.Errorf("RAR archive %s has encrypted headers; its entries cannot be listed without decrypting it", src)
+	}
+	if a.Volume {
+		fmt.Printf("Volume %s\n", volumeNumber(a))
+	}
+	for _, f := range a.Files {
+		flags := ""
+		if f.Encrypted {
+			flags += "E"
+		}
+		if f.Solid {
+			flags += "S"
+		}
+		if f.SplitBefore {
+			flags += "<"
+		}
+		if f.SplitAfter {
+			flags += ">"
+		}
+		if flags == "" {
+			flags = "-"
+		}
+		entry := f.Name
+		if f.Mode.IsDir() {
+			entry += "/"
+		}
+		if f.Linkname != "" {
+			entry += " -> " + f.Linkname
+		}
+		modTime := "                "
+		if !f.ModTime.IsZero() {
+			modTime = f.ModTime.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%s %10d %10d %-7s %-4s %s %s\n", f.Mode, f.Size, f.PackedSize,
+			rar.MethodName(f.Method), flags, modTime, entry)
 	}
+	return nil
+}
 
-	// Create the 7zip command
-	cmd := exec.Command("7z", cmdArgs...)
-	// Run the command
-	err := cmd.Run()
+// Info prints the properties of a RAR archive.
+func Info(src string) error {
+	in, a, err := open(src)
 	if err != nil {
-		return fmt.Errorf("failed to extract RAR archive with 7zip: %w", err)
+		return err
+	}
+	defer closeArchive(in, src)
+
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	field := func(name, value string) {
+		fmt.Printf("%-17s: %s\n", name, value)
+	}
+	field("Format", fmt.Sprintf("RAR %d", a.Version))
+	if a.Volume {
+		field("Volume", volumeNumber(a))
+		field("More volumes", yesNo(a.MoreVolumes))
+	}
+	field("Solid", yesNo(a.Solid))
+	field("Locked", yesNo(a.Locked))
+	field("Recovery record", yesNo(a.Recovery))
+	field("Comment", yesNo(a.Comment))
+	field("Encrypted headers", yesNo(a.EncryptedHeaders))
+	if a.EncryptedHeaders {
+		return nil
 	}
 
+	var size, packed int64
+	files, encrypted := 0, 0
+	for _, f := range a.Files {
+		if !f.Mode.IsDir() {
+			files++
+		}
+		if f.Encrypted {
+			encrypted++
+		}
+		size += f.Size
+		packed += f.PackedSize
+	}
+	field("Entries", fmt.Sprintf("%d (%d files)", len(a.Files), files))
+	field("Encrypted entries", fmt.Sprintf("%d", encrypted))
+	field("Size", fmt.Sprintf("%d", size))
+	field("Packed size", fmt.Sprintf("%d", packed))
 	return nil
 }
+
+func volumeNumber(a *rar.Archive) string {
+	if a.VolumeNumber < 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d", a.VolumeNumber+1)
+}
+
+func open(src string) (*os.File, *rar.Archive, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open RAR archive %s: %w", src, err)
+	}
+	a, err := rar.Read(in)
+	if err != nil {
+		_ = in.Close()
+		if errors.Is(err, rar.ErrNotRAR) {
+			return nil, nil, fmt.Errorf("%s: %w", src, err)
+		}
+		return nil, nil, fmt.Errorf("failed to read RAR archive %s: %w", src, err)
+	}
+	return in, a, nil
+}
+
+func closeArchive(in *os.File, src string) {
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing RAR archive %s: %v\n", src, closeErr)
+	}
+}
@@ -0,0 +1,157 @@
+package extractrpm
+
+import (
+	"fmt"
+	extractcpio "futile/archive/extract/cpio"
+	"futile/formats/rpm"
+	"os"
+	"strings"
+	"time"
+)
+
+// Extract unpacks the payload of an RPM package into dest, like
+// "rpm2cpio | cpio -id" but without either tool.
+func Extract(src, dest string) error {
+	in, pkg, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closePackage(in, src)
+
+	payload, err := pkg.Payload(in)
+	if err != nil {
+		return fmt.Errorf("failed to read payload of %s: %w", src, err)
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	if err := extractcpio.ExtractReader(payload, dest); err != nil {
+		return fmt.Errorf("failed to extract payload of %s: %w", src, err)
+	}
+	return nil
+}
+
+// List prints the file list recorded in the package header.
+func List(src string) error {
+	in, pkg, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closePackage(in, src)
+
+	for _, f := range pkg.Files() {
+		entry := f.Name
+		if f.Linkname != "" {
+			entry += " -> " + f.Linkname
+		}
+		fmt.Printf("%s %s/%s %10d %s %s\n", f.Mode, f.Owner, f.Group, f.Size,
+			f.ModTime.UTC().Format("2006-01-02 15:04"), entry)
+	}
+	return nil
+}
+
+// Info prints the main tags of an RPM package and checks its header digest.
+func Info(src string) error {
+	in, pkg, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closePackage(in, src)
+
+	h := pkg.Header
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Printf("%-13s: %s\n", name, value)
+		}
+	}
+	field("Name", h.String(rpm.TagName))
+	field("Epoch", h.Format(rpm.TagEpoch))
+	field("Version", h.String(rpm.TagVersion))
+	field("Release", h.String(rpm.TagRelease))
+	field("Architecture", h.String(rpm.TagArch))
+	field("Group", h.String(rpm.TagGroup))
+	size := h.Format(rpm.TagLongSize)
+	if size == "" {
+		size = h.Format(rpm.TagSize)
+	}
+	field("Size", size)
+	field("License", h.String(rpm.TagLicense))
+	field("Source RPM", h.String(rpm.TagSourceRPM))
+	if t, ok := h.Int(rpm.TagBuildTime); ok {
+		field("Build Date", time.Unix(t, 0).UTC().Format(time.RFC1123))
+	}
+	field("Build Host", h.String(rpm.TagBuildHost))
+	field("Packager", h.String(rpm.TagPackager))
+	field("Vendor", h.String(rpm.TagVendor))
+	field("URL", h.String(rpm.TagURL))
+	field("Payload", strings.TrimSpace(h.String(rpm.TagPayloadFormat)+" "+h.String(rpm.TagPayloadCompressor)))
+	field("Summary", h.String(rpm.TagSummary))
+
+	switch algo, err := pkg.VerifyDigest(); {
+	case err != nil:
+		field("Digest", err.Error())
+	case algo != "":
+		field("Digest", algo+" OK")
+	}
+
+	if requires := requirements(h); len(requires) > 0 {
+		fmt.Println("Requires     :")
+		for _, r := range requires {
+			fmt.Println("  " + r)
+		}
+	}
+	if desc := h.String(rpm.TagDescription); desc != "" {
+		fmt.Println("Description  :")
+		fmt.Println(desc)
+	}
+	fmt.Println("Files        :")
+	for _, f := range pkg.Files() {
+		fmt.Println("  " + f.Name)
+	}
+	return nil
+}
+
+// requirements formats the dependencies with their version constraints.
+func requirements(h *rpm.Header) []string {
+	names := h.Strings(rpm.TagRequireName)
+	versions := h.Strings(rpm.TagRequireVersion)
+	flags := h.Ints(rpm.TagRequireFlags)
+	var out []string
+	for i, name := range names {
+		s := name
+		if i < len(versions) && versions[i] != "" && i < len(flags) {
+			op := ""
+			if flags[i]&0x02 != 0 {
+				op += "<"
+			}
+			if flags[i]&0x04 != 0 {
+				op += ">"
+			}
+			if flags[i]&0x08 != 0 {
+				op += "="
+			}
+			s += " " + op + " " + versions[i]
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func open(src string) (*os.File, *rpm.Package, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open package %s: %w", src, err)
+	}
+	pkg, err := rpm.Read(in)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, fmt.Errorf("failed to read package %s: %w", src, err)
+	}
+	return in, pkg, nil
+}
+
+func closePackage(in *os.File, src string) {
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing package %s: %v\n", src, closeErr)
+	}
+}
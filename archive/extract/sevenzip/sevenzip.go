@@ -1,21 +1,38 @@
 package sevenzip
 
 import (
+	"errors"
 	"fmt"
+	"futile/formats/sevenzip"
+	"futile/utils"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 )
 
 // Extract extracts the contents of a 7z archive to the specified destination directory.
-// Supports password-protected and split archives.
-func Extract(src, dest, password string) error {
+// Supports password-protected and split archives. The 7z binary is used when
+// installed; otherwise the archive is read natively.
+func Extract(src, dest string, password string) error {
+	if _, err := exec.LookPath("7z"); err != nil {
+		return extractNative(src, dest, password)
+	}
+
+	// 7z finds the other volumes of split archives from the first
+	paths, err := utils.Volumes(src)
+	if err != nil {
+		return fmt.Errorf("failed to open 7z archive %s: %w", src, err)
+	}
+
 	// Ensure the destination directory exists
 	if err := os.MkdirAll(dest, 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
 	// Build the command for 7z extraction
-	args := []string{"x", src, "-o" + dest, "-y"} // "-y" auto answers "yes" to all prompts
+	args := []string{"x", paths[0], "-o" + dest, "-y"} // "-y" auto answers "yes" to all prompts
 	if password != "" {
 		args = append(args, "-p"+password) // Add password flag if provided
 	}
@@ -31,3 +48,138 @@ func Extract(src, dest, password string) error {
 
 	return nil
 }
+
+// extractNative extracts a 7z archive with the built-in reader.
+func extractNative(src, dest, password string) error {
+	in, a, err := open(src, password)
+	if err != nil {
+		return err
+	}
+	defer closeArchive(in, src)
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	var dirs utils.DirMetadata
+	err = a.Walk(func(f *sevenzip.File, r io.Reader) error {
+		if f.Anti {
+			return nil
+		}
+		target, err := utils.SafeJoin(dest, f.Name)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			return nil
+		}
+
+		switch {
+		case f.Mode.IsDir():
+			if err := utils.MakeDir(target); err != nil {
+				return err
+			}
+			dirs.Add(target, f.Mode, f.ModTime)
+		case f.Mode&fs.ModeSymlink != 0:
+			linkname, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", f.Name, err)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", target, err)
+			}
+			_ = os.Remove(target)
+			if err := os.Symlink(string(linkname), target); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", target, err)
+			}
+		default:
+			if err := writeFile(f, r, target); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extract 7z archive %s: %w", src, err)
+	}
+
+	return dirs.Apply()
+}
+
+// List prints the files of a 7z archive in the style of "tar tv". Only the
+// archive header is read, so no password is needed unless it is encrypted.
+func List(src, password string) error {
+	in, a, err := open(src, password)
+	if err != nil {
+		return err
+	}
+	defer closeArchive(in, src)
+
+	for _, f := range a.Files {
+		if f.Anti {
+			continue
+		}
+		entry := f.Name
+		if f.Mode.IsDir() {
+			entry += "/"
+		}
+		modTime := "                "
+		if !f.ModTime.IsZero() {
+			modTime = f.ModTime.UTC().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%s %10d %s %s\n", f.Mode, f.Size, modTime, entry)
+	}
+	return nil
+}
+
+// open reads the archive src, or every volume of the split archive that
+// src belongs to.
+func open(src, password string) (*utils.VolumeReader, *sevenzip.Archive, error) {
+	paths, err := utils.Volumes(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open 7z archive %s: %w", src, err)
+	}
+	in, err := utils.OpenVolumes(paths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open 7z archive %s: %w", src, err)
+	}
+	if base, _, ok := utils.SplitVolumeName(paths[0]); ok {
+		if size, err := sevenzip.Size(in); err == nil && size > in.Size() {
+			_ = in.Close()
+			return nil, nil, fmt.Errorf("failed to open 7z archive %s: volume %s is missing",
+				src, utils.NumberedVolume(base, len(paths)+1))
+		}
+	}
+	a, err := sevenzip.Open(in, password)
+	if err != nil {
+		_ = in.Close()
+		if errors.Is(err, sevenzip.ErrPasswordRequired) {
+			return nil, nil, fmt.Errorf("7z archive %s has encrypted headers: %w", src, err)
+		}
+		return nil, nil, fmt.Errorf("failed to read 7z archive %s: %w", src, err)
+	}
+	return in, a, nil
+}
+
+func closeArchive(in io.Closer, src string) {
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing 7z archive %s: %v\n", src, closeErr)
+	}
+}
+
+func writeFile(f *sevenzip.File, r io.Reader, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+	out, err := utils.CreateFile(target, f.Mode.Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", target, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", target, err)
+	}
+	return utils.SetModeAndTime(target, f.Mode, f.ModTime)
+}
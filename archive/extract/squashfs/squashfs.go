@@ -0,0 +1,183 @@
+package extractsquashfs
+
+import (
+	"fmt"
+	"futile/formats/squashfs"
+	"futile/utils"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Extract copies the files of a SquashFS image into dest without mounting
+// it. Hard links are recreated, and device nodes and extended attributes
+// where permissions allow.
+func Extract(src, dest string) error {
+	in, img, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closeImage(in, src)
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	var dirs utils.DirMetadata
+	var xattrErrs utils.XattrErrors
+	links := make(map[uint32]string)
+	err = img.Walk(func(f *squashfs.File) error {
+		target, err := utils.SafeJoin(dest, f.Path)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			return nil
+		}
+
+		switch {
+		case f.Mode.IsDir():
+			if err := utils.MakeDir(target); err != nil {
+				return err
+			}
+			dirs.Add(target, f.Mode, f.ModTime)
+		case f.Mode&fs.ModeSymlink != 0:
+			_ = os.Remove(target)
+			if err := os.Symlink(f.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", target, err)
+			}
+			// Extended attributes of the link itself cannot be set portably
+			return nil
+		case f.Nlink > 1 && links[f.Inode] != "":
+			_ = os.Remove(target)
+			if err := os.Link(links[f.Inode], target); err != nil {
+				return fmt.Errorf("failed to create hard link %s: %w", target, err)
+			}
+			return nil
+		case f.Mode.IsRegular():
+			if err := writeFile(f, target, &xattrErrs); err != nil {
+				return err
+			}
+		default:
+			_ = os.Remove(target)
+			if err := utils.Mknod(target, utils.UnixMode(f.Mode), f.RDevMajor, f.RDevMinor); err != nil {
+				// Device nodes usually need root, so this is not fatal
+				fmt.Printf("Warning: could not create special file %s: %v\n", f.Path, err)
+				return nil
+			}
+		}
+		if f.Nlink > 1 && !f.Mode.IsDir() {
+			links[f.Inode] = target
+		}
+		if !f.Mode.IsRegular() {
+			setXattrs(&xattrErrs, f, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extract image %s: %w", src, err)
+	}
+	xattrErrs.Report()
+
+	return dirs.Apply()
+}
+
+// List prints the files of a SquashFS image in the style of "tar tv".
+func List(src string) error {
+	in, img, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closeImage(in, src)
+
+	return img.Walk(func(f *squashfs.File) error {
+		if f.Path == "" {
+			return nil
+		}
+		entry := f.Path
+		if f.Mode.IsDir() {
+			entry += "/"
+		}
+		if f.Linkname != "" {
+			entry += " -> " + f.Linkname
+		}
+		size := fmt.Sprintf("%d", f.Size)
+		if f.Mode&fs.ModeDevice != 0 {
+			size = fmt.Sprintf("%d,%d", f.RDevMajor, f.RDevMinor)
+		}
+		fmt.Printf("%s %d/%d %10s %s %s\n", f.Mode, f.Uid, f.Gid, size,
+			f.ModTime.UTC().Format("2006-01-02 15:04"), entry)
+		return nil
+	})
+}
+
+// Info prints the properties of a SquashFS image.
+func Info(src string) error {
+	in, img, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer closeImage(in, src)
+
+	field := func(name, value string) {
+		fmt.Printf("%-15s: %s\n", name, value)
+	}
+	field("Compression", img.Compression)
+	field("Block size", fmt.Sprintf("%d", img.BlockSize))
+	field("Inodes", fmt.Sprintf("%d", img.Inodes))
+	field("Fragments", fmt.Sprintf("%d", img.Fragments))
+	field("Size", fmt.Sprintf("%d", img.Size))
+	field("Modified", img.ModTime.UTC().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+func open(src string) (*os.File, *squashfs.Image, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open image %s: %w", src, err)
+	}
+	img, err := squashfs.Open(in)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, fmt.Errorf("failed to read image %s: %w", src, err)
+	}
+	return in, img, nil
+}
+
+func closeImage(in *os.File, src string) {
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing image %s: %v\n", src, closeErr)
+	}
+}
+
+// writeFile writes a regular file, setting its extended attributes while it
+// is still writable.
+// setXattrs sets the extended attributes of f on target.
+func setXattrs(xattrErrs *utils.XattrErrors, f *squashfs.File, target string) {
+	for _, x := range f.Xattrs {
+		xattrErrs.Set(target, f.Path, x.Name, x.Value)
+	}
+}
+
+func writeFile(f *squashfs.File, target string, xattrErrs *utils.XattrErrors) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	out, err := utils.CreateFile(target, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", target, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to extract %s: %w", f.Path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", target, err)
+	}
+	setXattrs(xattrErrs, f, target)
+	return utils.SetModeAndTime(target, f.Mode, f.ModTime)
+}
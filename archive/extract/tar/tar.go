@@ -2,10 +2,21 @@ package tar
 
 import (
 	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"futile/compress/lz4"
+	"futile/compress/xz"
+	"futile/compress/zstd"
+	"futile/utils"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // closeFile is a helper function to close files and handle errors.
@@ -31,25 +42,181 @@ func Extract(src, dest, password string) error {
 	return extractStandardTar(src, dest)
 }
 
+// ExtractGzip extracts the contents of a gzip-compressed tar archive (.tar.gz / .tgz).
+func ExtractGzip(src, dest string) error {
+	return extractCompressedTar(src, dest, gzipReader)
+}
+
+// ExtractBzip2 extracts the contents of a bzip2-compressed tar archive (.tar.bz2 / .tbz2).
+func ExtractBzip2(src, dest string) error {
+	return extractCompressedTar(src, dest, bzip2Reader)
+}
+
+// ExtractXz extracts the contents of an xz-compressed tar archive (.tar.xz / .txz).
+func ExtractXz(src, dest string) error {
+	return extractCompressedTar(src, dest, xzReader)
+}
+
+// ExtractZstd extracts the contents of a Zstandard-compressed tar archive (.tar.zst / .tzst).
+func ExtractZstd(src, dest string, dicts ...*zstd.Dict) error {
+	return extractCompressedTar(src, dest, zstdReader(dicts))
+}
+
+// ExtractLz4 extracts the contents of an LZ4-compressed tar archive (.tar.lz4).
+func ExtractLz4(src, dest string) error {
+	return extractCompressedTar(src, dest, lz4Reader)
+}
+
+// List prints the entries of a tar archive in the style of "tar tv".
+func List(src string) error {
+	return listCompressedTar(src, nil)
+}
+
+// ListGzip lists a gzip-compressed tar archive.
+func ListGzip(src string) error {
+	return listCompressedTar(src, gzipReader)
+}
+
+// ListBzip2 lists a bzip2-compressed tar archive.
+func ListBzip2(src string) error {
+	return listCompressedTar(src, bzip2Reader)
+}
+
+// ListXz lists an xz-compressed tar archive.
+func ListXz(src string) error {
+	return listCompressedTar(src, xzReader)
+}
+
+// ListZstd lists a Zstandard-compressed tar archive.
+func ListZstd(src string, dicts ...*zstd.Dict) error {
+	return listCompressedTar(src, zstdReader(dicts))
+}
+
+// ListLz4 lists an LZ4-compressed tar archive.
+func ListLz4(src string) error {
+	return listCompressedTar(src, lz4Reader)
+}
+
+func gzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func bzip2Reader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(r)), nil
+}
+
+func xzReader(r io.Reader) (io.ReadCloser, error) {
+	reader, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(reader), nil
+}
+
+func zstdReader(dicts []*zstd.Dict) func(io.Reader) (io.ReadCloser, error) {
+	return func(r io.Reader) (io.ReadCloser, error) {
+		reader, err := zstd.NewReader(r, dicts...)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(reader), nil
+	}
+}
+
+func lz4Reader(r io.Reader) (io.ReadCloser, error) {
+	reader, err := lz4.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(reader), nil
+}
+
 // extractStandardTar extracts a non-password-protected tar archive.
 func extractStandardTar(src, dest string) error {
-	// Open the TAR archive
-	tarFile, err := os.Open(src)
+	return extractCompressedTar(src, dest, nil)
+}
+
+// extractCompressedTar extracts a tar archive whose bytes are first passed through the
+// decompressor returned by wrap. A nil wrap reads an uncompressed tar archive.
+func extractCompressedTar(src, dest string, wrap func(io.Reader) (io.ReadCloser, error)) error {
+	return readCompressedTar(src, wrap, func(tarReader *tar.Reader) error {
+		return extractTarContents(tarReader, dest)
+	})
+}
+
+// listCompressedTar prints the entries of a tar archive read through wrap.
+func listCompressedTar(src string, wrap func(io.Reader) (io.ReadCloser, error)) error {
+	return readCompressedTar(src, wrap, func(tarReader *tar.Reader) error {
+		global := make(globalRecords)
+		for {
+			header, err := global.next(tarReader)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read TAR header: %w", err)
+			}
+			entry := header.Name
+			switch header.Typeflag {
+			case tar.TypeSymlink:
+				entry += " -> " + header.Linkname
+			case tar.TypeLink:
+				entry += " link to " + header.Linkname
+			}
+			fmt.Printf("%s %s/%s %10d %s %s\n", header.FileInfo().Mode(), owner(header.Uname, header.Uid),
+				owner(header.Gname, header.Gid), header.Size, header.ModTime.UTC().Format("2006-01-02 15:04"), entry)
+		}
+	})
+}
+
+// owner returns an owner name, or the numeric ID when the name is not recorded.
+func owner(name string, id int) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprint(id)
+}
+
+// readCompressedTar opens a tar archive through wrap and passes its reader to fn.
+func readCompressedTar(src string, wrap func(io.Reader) (io.ReadCloser, error), fn func(*tar.Reader) error) error {
+	// Open the TAR archive, or every volume of a split one
+	paths, err := utils.Volumes(src)
+	if err != nil {
+		return fmt.Errorf("failed to open TAR file: %w", err)
+	}
+	volumes, err := utils.OpenVolumes(paths)
 	if err != nil {
 		return fmt.Errorf("failed to open TAR file: %w", err)
 	}
+	tarFile := io.NewSectionReader(volumes, 0, volumes.Size())
 	defer func() {
 		// Ensure the tarFile is closed and handle any closing error
-		if closeErr := closeFile(tarFile); closeErr != nil {
+		if closeErr := closeFile(volumes); closeErr != nil {
 			fmt.Printf("Warning: failed to close TAR file: %v\n", closeErr)
 		}
 	}()
 
-	// Create a new tar.Reader to read the TAR archive
-	tarReader := tar.NewReader(tarFile)
+	var in io.Reader = tarFile
+	if wrap != nil {
+		decompressor, err := wrap(tarFile)
+		if err != nil {
+			return fmt.Errorf("failed to open decompressor: %w", err)
+		}
+		defer func() {
+			if closeErr := closeFile(decompressor); closeErr != nil {
+				fmt.Printf("Warning: failed to close decompressor: %v\n", closeErr)
+			}
+		}()
+		in = decompressor
+	}
 
-	// Extract the contents
-	return extractTarContents(tarReader, dest)
+	// Create a new tar.Reader to read the TAR archive
+	err = fn(tar.NewReader(in))
+	if base, _, ok := utils.SplitVolumeName(paths[0]); ok && errors.Is(err, io.ErrUnexpectedEOF) {
+		// Only the archive can tell that its last volume is missing
+		return fmt.Errorf("%w; is volume %s missing?", err, utils.NumberedVolume(base, len(paths)+1))
+	}
+	return err
 }
 
 // extractPasswordProtectedTar uses 7zip to extract a password-protected tar archive.
@@ -64,12 +231,21 @@ func extractPasswordProtectedTar(src, dest, password string) error {
 	return nil
 }
 
+// ExtractReader extracts an uncompressed tar stream into dest.
+func ExtractReader(r io.Reader, dest string) error {
+	return extractTarContents(tar.NewReader(r), dest)
+}
+
 // extractTarContents extracts the content of the TAR archive using the tar.Reader.
 func extractTarContents(tarReader *tar.Reader, dest string) error {
+	global := make(globalRecords)
+	var xattrErrs utils.XattrErrors
+	defer xattrErrs.Report()
+
 	// Loop through the TAR file
 	for {
 		// Get the next file in the TAR archive
-		header, err := tarReader.Next()
+		header, err := global.next(tarReader)
 		if err == io.EOF {
 			// End of the TAR archive
 			break
@@ -78,26 +254,67 @@ func extractTarContents(tarReader *tar.Reader, dest string) error {
 			return fmt.Errorf("failed to read TAR header: %w", err)
 		}
 
-		// Build the destination file path
-		destPath := fmt.Sprintf("%s/%s", dest, header.Name)
+		// Build the destination file path, refusing names that leave dest as
+		// written or through a symlink extracted earlier
+		destPath, err := utils.SafeJoin(dest, header.Name)
+		if err != nil {
+			return err
+		}
+		if destPath == dest {
+			continue
+		}
 
 		// Handle directories
 		if header.Typeflag == tar.TypeDir {
-			if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", destPath, err)
+			if err := utils.MakeDir(destPath); err != nil {
+				return err
 			}
+			setXattrs(&xattrErrs, header, destPath)
 			continue
 		}
 
-		// Handle regular files
-		file, err := os.Create(destPath)
-		if err != nil {
-			return fmt.Errorf("failed to create file %s: %w", destPath, err)
+		// Make sure the parent directory exists even if the archive has no entry for it
+		if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", destPath, err)
 		}
 
-		// Ensure the file is closed after processing
-		if err := closeFile(file); err != nil {
-			return fmt.Errorf("failed to close file %s: %w", destPath, err)
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			_ = os.Remove(destPath)
+			if err := os.Symlink(header.Linkname, destPath); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", destPath, err)
+			}
+			continue
+		case tar.TypeLink:
+			target, err := utils.SafeLinkTarget(dest, header.Linkname)
+			if err != nil {
+				return err
+			}
+			_ = os.Remove(destPath)
+			if err := os.Link(target, destPath); err != nil {
+				return fmt.Errorf("failed to create hard link %s: %w", destPath, err)
+			}
+			continue
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			_ = os.Remove(destPath)
+			mode := utils.UnixMode(header.FileInfo().Mode())
+			if err := utils.Mknod(destPath, mode, uint32(header.Devmajor), uint32(header.Devminor)); err != nil {
+				// Device nodes usually need root, so this is not fatal
+				fmt.Printf("Warning: could not create special file %s: %v\n", header.Name, err)
+				continue
+			}
+			_ = os.Chtimes(destPath, header.ModTime, header.ModTime)
+			continue
+		case tar.TypeReg:
+		default:
+			// Other entry types carry nothing to extract
+			continue
+		}
+
+		// Handle regular files, replacing rather than writing through an existing link
+		file, err := utils.CreateFile(destPath, fileMode(header))
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %w", destPath, err)
 		}
 
 		// Copy the file contents
@@ -106,7 +323,120 @@ func extractTarContents(tarReader *tar.Reader, dest string) error {
 			_ = closeFile(file)
 			return fmt.Errorf("failed to write file %s: %w", destPath, err)
 		}
+
+		// Ensure the file is closed after processing
+		if err := closeFile(file); err != nil {
+			return fmt.Errorf("failed to close file %s: %w", destPath, err)
+		}
+		setXattrs(&xattrErrs, header, destPath)
+		if !header.ModTime.IsZero() {
+			if err := os.Chtimes(destPath, header.ModTime, header.ModTime); err != nil {
+				return fmt.Errorf("failed to set modification time of %s: %w", destPath, err)
+			}
+		}
 	}
 
 	return nil
 }
+
+// fileMode returns the permissions recorded for a file, defaulting to 0644.
+func fileMode(header *tar.Header) os.FileMode {
+	if perm := os.FileMode(header.Mode).Perm(); perm != 0 {
+		return perm
+	}
+	return 0644
+}
+
+// globalRecords holds the records of the global PAX headers read so far,
+// which apply to every later entry that does not override them.
+type globalRecords map[string]string
+
+// next returns the next entry of the archive, with the global records
+// applied. Global headers themselves are consumed rather than returned.
+func (g globalRecords) next(tarReader *tar.Reader) (*tar.Header, error) {
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeXGlobalHeader {
+			g.apply(header)
+			return header, nil
+		}
+		for key, value := range header.PAXRecords {
+			// An empty value removes a record
+			if value == "" {
+				delete(g, key)
+			} else {
+				g[key] = value
+			}
+		}
+	}
+}
+
+// apply sets the fields of header that global records describe and its own
+// records leave out. Names and sizes differ by entry, so their global
+// records are ignored.
+func (g globalRecords) apply(header *tar.Header) {
+	for key, value := range g {
+		if _, ok := header.PAXRecords[key]; ok {
+			continue
+		}
+		switch {
+		case key == "mtime":
+			if t, err := parsePAXTime(value); err == nil {
+				header.ModTime = t
+			}
+		case key == "uid":
+			if id, err := strconv.Atoi(value); err == nil {
+				header.Uid = id
+			}
+		case key == "gid":
+			if id, err := strconv.Atoi(value); err == nil {
+				header.Gid = id
+			}
+		case key == "uname":
+			header.Uname = value
+		case key == "gname":
+			header.Gname = value
+		case strings.HasPrefix(key, xattrPrefix):
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords[key] = value
+		}
+	}
+}
+
+// parsePAXTime parses a PAX time: seconds since the epoch with an optional
+// decimal fraction.
+func parsePAXTime(s string) (time.Time, error) {
+	secs, frac, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+		if strings.HasPrefix(secs, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// xattrPrefix starts the PAX records of extended attributes.
+const xattrPrefix = "SCHILY.xattr."
+
+// setXattrs sets the extended attributes recorded for header on path.
+func setXattrs(xattrErrs *utils.XattrErrors, header *tar.Header, path string) {
+	for key, value := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(key, xattrPrefix); ok {
+			xattrErrs.Set(path, header.Name, name, []byte(value))
+		}
+	}
+}
@@ -2,78 +2,271 @@ package zip
 
 import (
 	"archive/zip"
+	"errors"
 	"fmt"
+	zipformat "futile/formats/zip"
+	"futile/utils"
 	"io"
+	"io/fs"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 // Extract extracts the contents of a standard ZIP archive to the destination.
-func Extract(src, dest string) error {
-	archive, err := zip.OpenReader(src)
+// Zip64 archives are supported, including those written by other tools, as
+// are split archives given any of their volumes. Entries encrypted with
+// WinZip AES or ZipCrypto are decrypted with password.
+func Extract(src, dest, password string) error {
+	volumes, archive, err := open(src)
 	if err != nil {
-		return fmt.Errorf("failed to open ZIP file %s: %w", src, err)
-	}
-	// Close the archive explicitly
-	closeErr := archive.Close()
-	if closeErr != nil {
-		fmt.Printf("Error closing ZIP archive %s: %v\n", src, closeErr)
+		return err
 	}
+	defer closeVolumes(volumes, src)
 
 	for _, file := range archive.File {
-		if strings.HasPrefix(file.Name, "__MACOSX") || strings.HasPrefix(file.Name, "._") {
-			continue
+		if usesZipCrypto(file) {
+			fmt.Printf("Warning: %s uses ZipCrypto encryption, which is easily broken; prefer AES\n", src)
+			break
 		}
+	}
 
-		destFilePath := filepath.Join(dest, file.Name)
-
-		destDir := filepath.Dir(destFilePath)
-		if err := os.MkdirAll(destDir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", destDir, err)
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	var dirs utils.DirMetadata
+	for _, file := range archive.File {
+		if strings.HasPrefix(file.Name, "__MACOSX") || strings.HasPrefix(path.Base(file.Name), "._") {
+			continue
 		}
 
-		inFile, err := file.Open()
+		destFilePath, err := utils.SafeJoin(dest, file.Name)
 		if err != nil {
-			return fmt.Errorf("failed to open file %s in archive: %w", file.Name, err)
+			return err
 		}
-
-		// Explicitly close input file
-		closeErr = inFile.Close()
-		if closeErr != nil {
-			fmt.Printf("Error closing input file %s: %v\n", file.Name, closeErr)
+		if destFilePath == dest {
+			continue
 		}
-
-		outFile, err := os.Create(destFilePath)
-		if err != nil {
-			return fmt.Errorf("failed to create file %s: %w", destFilePath, err)
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			if err := utils.MakeDir(destFilePath); err != nil {
+				return err
+			}
+			// Keep directories writable, as permissions from other systems may not fit
+			dirs.Add(destFilePath, mode|0700, file.Modified)
+		case mode&fs.ModeSymlink != 0:
+			if err := extractSymlink(file, destFilePath, password); err != nil {
+				return err
+			}
+		default:
+			if err := extractFile(file, destFilePath, password); err != nil {
+				return err
+			}
 		}
+	}
+
+	return dirs.Apply()
+}
 
-		// Explicitly close output file
-		closeErr = outFile.Close()
-		if closeErr != nil {
-			fmt.Printf("Error closing output file %s: %v\n", destFilePath, closeErr)
+func extractFile(file *zip.File, destFilePath, password string) error {
+	destDir := filepath.Dir(destFilePath)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", destDir, err)
+	}
+
+	inFile, err := openFile(file, password)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s in archive: %w", file.Name, err)
+	}
+	defer func() {
+		if closeErr := inFile.Close(); closeErr != nil {
+			fmt.Printf("Error closing input file %s: %v\n", file.Name, closeErr)
 		}
+	}()
 
-		_, err = io.Copy(outFile, inFile)
-		if err != nil {
-			return fmt.Errorf("failed to extract file %s to %s: %w", file.Name, destFilePath, err)
+	perm := file.Mode().Perm()
+	if perm == 0 {
+		// Archives from MS-DOS tools carry no permissions
+		perm = 0644
+	}
+	outFile, err := utils.CreateFile(destFilePath, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", destFilePath, err)
+	}
+	if _, err := io.Copy(outFile, inFile); err != nil {
+		_ = outFile.Close()
+		return fmt.Errorf("failed to extract file %s to %s: %w", file.Name, destFilePath, err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to close output file %s: %w", destFilePath, err)
+	}
+	if !file.Modified.IsZero() {
+		if err := os.Chtimes(destFilePath, file.Modified, file.Modified); err != nil {
+			return fmt.Errorf("failed to set modification time of %s: %w", destFilePath, err)
 		}
 	}
+	return nil
+}
 
+func extractSymlink(file *zip.File, destFilePath, password string) error {
+	inFile, err := openFile(file, password)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s in archive: %w", file.Name, err)
+	}
+	target, err := io.ReadAll(io.LimitReader(inFile, 4096))
+	if closeErr := inFile.Close(); closeErr != nil {
+		fmt.Printf("Error closing input file %s: %v\n", file.Name, closeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read symlink %s: %w", file.Name, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(destFilePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", destFilePath, err)
+	}
+	_ = os.Remove(destFilePath)
+	if err := os.Symlink(string(target), destFilePath); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", destFilePath, err)
+	}
 	return nil
 }
 
-// ExtractPasswordProtected extracts a password-protected ZIP archive using 7z.
-func ExtractPasswordProtected(src, dest, password string) error {
-	cmd := exec.Command("7z", "x", "-p"+password, src, "-o"+dest)
+// openFile returns the content of an entry, decrypting WinZip AES and
+// ZipCrypto entries.
+func openFile(file *zip.File, password string) (io.ReadCloser, error) {
+	if file.Flags&0x1 == 0 {
+		return file.Open()
+	}
+	if zipformat.IsStrongEncryption(file.Flags) {
+		return nil, fmt.Errorf("%s uses PKWARE strong encryption, which is not supported", file.Name)
+	}
+	if password == "" {
+		return nil, fmt.Errorf("%s is encrypted; give the password with -p", file.Name)
+	}
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	if x, ok := zipformat.ParseAESExtra(file.Extra); ok && zipformat.IsAES(file.Method) {
+		return zipformat.NewAESReader(raw, int64(file.CompressedSize64), x, file.CRC32, password)
+	}
+
+	// ZipCrypto checks the password against a byte of the CRC, or of the
+	// modification time when the CRC follows the data
+	check := byte(file.CRC32 >> 24)
+	if file.Flags&0x8 != 0 {
+		check = byte(file.ModifiedTime >> 8)
+	}
+	return zipformat.NewZipCryptoReader(raw, file.Method, file.CRC32, check, password)
+}
+
+// usesZipCrypto reports whether an entry is encrypted with ZipCrypto.
+func usesZipCrypto(file *zip.File) bool {
+	return file.Flags&0x1 != 0 && !zipformat.IsAES(file.Method) && !zipformat.IsStrongEncryption(file.Flags)
+}
 
-	// Execute the 7z command
-	err := cmd.Run()
+// List prints the entries of a ZIP archive with their sizes and modification times.
+func List(src string) error {
+	volumes, archive, err := open(src)
 	if err != nil {
-		return fmt.Errorf("failed to extract password-protected ZIP file %s: %w", src, err)
+		return err
 	}
+	defer closeVolumes(volumes, src)
 
+	for _, file := range archive.File {
+		fmt.Printf("%s %10d %10d %s %s\n", file.Mode(), file.UncompressedSize64, file.CompressedSize64,
+			file.Modified.Format("2006-01-02 15:04"), file.Name)
+	}
 	return nil
 }
+
+// splitExtension matches the volumes before the last of split archives.
+var splitExtension = regexp.MustCompile(`(?i)\.z\d{2,}$`)
+
+// open reads the archive src. Split archives (".z01", ".z02" and so on,
+// then ".zip") are found from any volume, and their central directory
+// rebased so that archive/zip can read them; archives cut into numbered
+// pieces (".zip.001") are read as the pieces put together.
+func open(src string) (*utils.VolumeReader, *zip.Reader, error) {
+	paths, err := volumePaths(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open ZIP file %s: %w", src, err)
+	}
+	volumes, err := utils.OpenVolumes(paths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open ZIP file %s: %w", src, err)
+	}
+
+	var r io.ReaderAt = volumes
+	size := volumes.Size()
+	if len(paths) > 1 && splitExtension.MatchString(paths[0]) {
+		r, size, err = zipformat.JoinSplit(volumes, volumes.Starts(), size)
+		if err != nil {
+			closeVolumes(volumes, src)
+			return nil, nil, fmt.Errorf("failed to read split ZIP archive %s: %w", src, err)
+		}
+	}
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		closeVolumes(volumes, src)
+		if base, _, ok := utils.SplitVolumeName(paths[0]); ok && errors.Is(err, zip.ErrFormat) {
+			return nil, nil, fmt.Errorf("failed to open ZIP file %s: %w; is volume %s missing?",
+				src, err, utils.NumberedVolume(base, len(paths)+1))
+		}
+		return nil, nil, fmt.Errorf("failed to open ZIP file %s: %w", src, err)
+	}
+	return volumes, archive, nil
+}
+
+// volumePaths returns the volumes of the archive src belongs to, in order.
+func volumePaths(src string) ([]string, error) {
+	if _, _, ok := utils.SplitVolumeName(src); ok {
+		return utils.Volumes(src)
+	}
+	last := src
+	if splitExtension.MatchString(src) {
+		last = strings.TrimSuffix(src, filepath.Ext(src)) + ".zip"
+	}
+	f, err := os.Open(last)
+	if err != nil {
+		if os.IsNotExist(err) && last != src {
+			return nil, fmt.Errorf("volume %s is missing", last)
+		}
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	disks, err := zipformat.Disks(f, info.Size())
+	if closeErr := f.Close(); closeErr != nil {
+		fmt.Printf("Error closing ZIP file %s: %v\n", last, closeErr)
+	}
+	if err != nil {
+		// Left for archive/zip to report
+		return []string{last}, nil
+	}
+
+	base := strings.TrimSuffix(last, filepath.Ext(last))
+	paths := make([]string, 0, disks)
+	for n := 1; n < disks; n++ {
+		volume := fmt.Sprintf("%s.z%02d", base, n)
+		if _, err := os.Stat(volume); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("volume %s is missing", volume)
+			}
+			return nil, err
+		}
+		paths = append(paths, volume)
+	}
+	return append(paths, last), nil
+}
+
+func closeVolumes(volumes *utils.VolumeReader, src string) {
+	if closeErr := volumes.Close(); closeErr != nil {
+		fmt.Printf("Error closing ZIP archive %s: %v\n", src, closeErr)
+	}
+}
@@ -0,0 +1,50 @@
+package extractzstd
+
+import (
+	"fmt"
+	"futile/compress/zstd"
+	"futile/utils"
+	"io"
+	"os"
+)
+
+// Extract decompresses a standalone .zst file to dest, or into dest when it is
+// a directory, named after the input without its .zst extension.
+func Extract(src, dest string, dicts ...*zstd.Dict) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open Zstandard file %s: %w", src, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing Zstandard file %s: %v\n", src, closeErr)
+		}
+	}()
+
+	reader, err := zstd.NewReader(in, dicts...)
+	if err != nil {
+		return fmt.Errorf("failed to read Zstandard stream %s: %w", src, err)
+	}
+
+	destPath, err := utils.DecompressedPath(dest, utils.DecompressedName(src, ".zst"))
+	if err != nil {
+		return err
+	}
+	out, err := utils.CreateDecompressed(src, destPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, reader); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to decompress %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", destPath, err)
+	}
+	if err := utils.CopyModTime(src, destPath); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", destPath, err)
+	}
+
+	return nil
+}
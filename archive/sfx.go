@@ -0,0 +1,221 @@
+package archive
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"futile/formats/sfx"
+	"futile/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sfxStub is the extractor that self-extracting archives start with: a
+// Linux build of cmd/sfx, which reads only ZIP and tar archives.
+//
+//go:generate env CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -trimpath "-ldflags=-s -w" -o sfx-linux-amd64 ../cmd/sfx
+//go:embed sfx-linux-amd64
+var sfxStub []byte
+
+// createSFX creates a self-extracting archive: an extractor stub followed
+// by an archive of the sources and a trailer locating it. The archive has
+// the type of dest when dest names a ZIP or tar one, such as
+// "tools.tar.xz", and is a ZIP archive otherwise.
+func createSFX(sources []string, dest string, opts Options) error {
+	if opts.VolumeSize > 0 {
+		return fmt.Errorf("self-extracting archives cannot be split into volumes")
+	}
+	archiveType, err := utils.DetermineArchiveType(dest)
+	if err != nil {
+		archiveType = "zip"
+	}
+	if !sfx.Types[archiveType] {
+		return fmt.Errorf("self-extracting archives hold ZIP or tar archives, not %s", archiveType)
+	}
+	if opts.Password != "" && archiveType != "zip" {
+		return fmt.Errorf("password-protected self-extracting archives must hold ZIP archives")
+	}
+
+	stub, err := loadStub(opts.SFXStub)
+	if err != nil {
+		return err
+	}
+
+	// Build the archive on its own first, with the same options
+	dir, err := os.MkdirTemp("", "futile-sfx-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	payload := filepath.Join(dir, "archive."+archiveType)
+	inner := opts
+	inner.SFX = false
+	if err := HandleCreate(sources, payload, inner); err != nil {
+		return err
+	}
+
+	in, err := os.Open(payload)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", payload, err)
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			fmt.Printf("Error closing archive %s: %v\n", payload, closeErr)
+		}
+	}()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create self-extracting archive %s: %w", dest, err)
+	}
+	if _, err := out.Write(stub); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to write extractor stub to %s: %w", dest, err)
+	}
+	size, err := io.Copy(out, in)
+	if err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to write archive to %s: %w", dest, err)
+	}
+	trailer := &sfx.Trailer{
+		Offset: int64(len(stub)),
+		Size:   size,
+		Settings: sfx.Settings{
+			Type:      archiveType,
+			Command:   opts.SFXCommand,
+			Encrypted: opts.Password != "",
+		},
+	}
+	b, err := trailer.Encode()
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err := out.Write(b); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to write trailer to %s: %w", dest, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close self-extracting archive %s: %w", dest, err)
+	}
+	// OpenFile is subject to the umask, and leaves existing files' modes alone
+	return os.Chmod(dest, 0755)
+}
+
+// loadStub returns the extractor stub: the given file, such as a build of
+// cmd/sfx for another architecture, or the embedded one. The stub of a
+// self-extracting archive is used without its archive.
+func loadStub(path string) ([]byte, error) {
+	if path == "" {
+		return sfxStub, nil
+	}
+	stub, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extractor stub: %w", err)
+	}
+	if t, err := sfx.Read(bytes.NewReader(stub), int64(len(stub))); err == nil {
+		stub = stub[:t.Offset]
+	}
+	if !bytes.HasPrefix(stub, []byte("\x7fELF")) {
+		return nil, fmt.Errorf("extractor stub %s is not a Linux executable", path)
+	}
+	return stub, nil
+}
+
+// readSFX returns the trailer of a self-extracting archive, or nil for
+// other files, including those that cannot be opened.
+func readSFX(src string) (*sfx.Trailer, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, nil
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			fmt.Printf("Error closing %s: %v\n", src, closeErr)
+		}
+	}()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	t, err := sfx.Read(f, info.Size())
+	if errors.Is(err, sfx.ErrNotSFX) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read self-extracting archive %s: %w", src, err)
+	}
+	return t, nil
+}
+
+// withPayload copies the archive of a self-extracting file to a temporary
+// file named after its type, and passes its name to fn.
+func withPayload(src string, t *sfx.Trailer, fn func(payload string) error) error {
+	// The trailer is untrusted and its type names the file, so it must be
+	// one of the type names futile itself uses, not merely end in one
+	archiveType, err := utils.DetermineArchiveType("payload." + t.Type)
+	if err != nil || archiveType != t.Type || strings.ContainsAny(t.Type, `/\`) || strings.Contains(t.Type, "..") {
+		return fmt.Errorf("self-extracting archive %s holds an unknown archive type %q", src, t.Type)
+	}
+	dir, err := os.MkdirTemp("", "futile-sfx-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	payload := filepath.Join(dir, "payload."+archiveType)
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open self-extracting archive %s: %w", src, err)
+	}
+	out, err := os.Create(payload)
+	if err != nil {
+		_ = in.Close()
+		return fmt.Errorf("failed to create temporary archive: %w", err)
+	}
+	_, err = io.Copy(out, io.NewSectionReader(in, t.Offset, t.Size))
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing self-extracting archive %s: %v\n", src, closeErr)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy the archive out of %s: %w", src, err)
+	}
+	return fn(payload)
+}
+
+// infoSFX prints where the archive of a self-extracting file is and how it
+// is extracted, followed by the archive's own information where there is any.
+func infoSFX(src string, t *sfx.Trailer, opts Options) error {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	field := func(name, value string) {
+		fmt.Printf("%-15s: %s\n", name, value)
+	}
+	field("Self-extracting", "yes")
+	field("Archive type", t.Type)
+	field("Archive offset", fmt.Sprintf("%d", t.Offset))
+	field("Archive size", fmt.Sprintf("%d", t.Size))
+	field("Encrypted", yesNo(t.Encrypted))
+	if t.Command != "" {
+		field("Command", t.Command)
+	}
+
+	switch t.Type {
+	case "deb", "rpm", "rar", "jar":
+		fmt.Println()
+		return withPayload(src, t, func(payload string) error {
+			return HandleInfo(payload, opts)
+		})
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Command sfx is the extractor stub of self-extracting archives. futile
+// writes a Linux build of it ahead of the archive, so it reads only the ZIP
+// and tar archives futile puts there and stays small. The build futile
+// embeds is made by go generate in the archive package.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	extractTar "futile/archive/extract/tar"
+	extractzip "futile/archive/extract/zip"
+	"futile/formats/sfx"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Error: failed to find the self-extracting archive: %v", err)
+	}
+	if err := run(exe, os.Args[1:]); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// run extracts the archive carried by exe, asking for the password when it
+// is encrypted, then runs the command it was created with in the target
+// directory.
+func run(exe string, args []string) error {
+	t, err := readTrailer(exe)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(exe)
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	dest := flags.String("d", ".", "Directory to extract to")
+	password := flags.String("p", "", "Password of the archive, asked for when needed")
+	list := flags.Bool("l", false, "List the contents instead of extracting them")
+	noRun := flags.Bool("no-run", false, "Do not run the command after extracting")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [-d directory] [-p password] [-l] [-no-run]\n\n", name)
+		fmt.Fprintf(flags.Output(), "Self-extracting %s archive.", t.Type)
+		if t.Command != "" {
+			fmt.Fprintf(flags.Output(), " Runs %q in the directory once extracted.", t.Command)
+		}
+		fmt.Fprintf(flags.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	return withPayload(exe, t, func(payload string) error {
+		if *list {
+			return listArchive(payload, t.Type)
+		}
+		if t.Encrypted && *password == "" {
+			if *password, err = askPassword(); err != nil {
+				return err
+			}
+		}
+		if err := extractArchive(payload, t.Type, *dest, *password); err != nil {
+			return err
+		}
+		if t.Command == "" || *noRun {
+			return nil
+		}
+		cmd := exec.Command("/bin/sh", "-c", t.Command)
+		cmd.Dir = *dest
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("command %q failed: %w", t.Command, err)
+		}
+		return nil
+	})
+}
+
+// readTrailer returns the trailer of the self-extracting archive exe.
+func readTrailer(exe string) (*sfx.Trailer, error) {
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open self-extracting archive %s: %w", exe, err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			fmt.Printf("Error closing %s: %v\n", exe, closeErr)
+		}
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open self-extracting archive %s: %w", exe, err)
+	}
+	t, err := sfx.Read(f, info.Size())
+	if errors.Is(err, sfx.ErrNotSFX) {
+		return nil, fmt.Errorf("%s carries no archive; futile writes this extractor ahead of one with --sfx", exe)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read self-extracting archive %s: %w", exe, err)
+	}
+	// The type names the temporary file, so it must be one of the exact
+	// type names the extractor reads
+	if !sfx.Types[t.Type] {
+		return nil, fmt.Errorf("self-extracting archive %s holds an unsupported archive type %q", exe, t.Type)
+	}
+	return t, nil
+}
+
+// withPayload copies the archive of a self-extracting file to a temporary
+// file named after its type, and passes its name to fn.
+func withPayload(exe string, t *sfx.Trailer, fn func(payload string) error) error {
+	dir, err := os.MkdirTemp("", "futile-sfx-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	payload := filepath.Join(dir, "payload."+t.Type)
+
+	in, err := os.Open(exe)
+	if err != nil {
+		return fmt.Errorf("failed to open self-extracting archive %s: %w", exe, err)
+	}
+	out, err := os.Create(payload)
+	if err != nil {
+		_ = in.Close()
+		return fmt.Errorf("failed to create temporary archive: %w", err)
+	}
+	_, err = io.Copy(out, io.NewSectionReader(in, t.Offset, t.Size))
+	if closeErr := in.Close(); closeErr != nil {
+		fmt.Printf("Error closing self-extracting archive %s: %v\n", exe, closeErr)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy the archive out of %s: %w", exe, err)
+	}
+	return fn(payload)
+}
+
+// extractArchive extracts the payload, of the given type, into dest.
+func extractArchive(payload, archiveType, dest, password string) error {
+	if password != "" && archiveType != "zip" {
+		return fmt.Errorf("password protection is not supported for %s archives", archiveType)
+	}
+	switch archiveType {
+	case "zip":
+		return extractzip.Extract(payload, dest, password)
+	case "tar":
+		return extractTar.Extract(payload, dest, "")
+	case "tar.gz":
+		return extractTar.ExtractGzip(payload, dest)
+	case "tar.bz2":
+		return extractTar.ExtractBzip2(payload, dest)
+	case "tar.xz":
+		return extractTar.ExtractXz(payload, dest)
+	case "tar.zst":
+		return extractTar.ExtractZstd(payload, dest)
+	case "tar.lz4":
+		return extractTar.ExtractLz4(payload, dest)
+	}
+	return fmt.Errorf("unsupported archive type %q", archiveType)
+}
+
+// listArchive prints the entries of the payload, of the given type.
+func listArchive(payload, archiveType string) error {
+	switch archiveType {
+	case "zip":
+		return extractzip.List(payload)
+	case "tar":
+		return extractTar.List(payload)
+	case "tar.gz":
+		return extractTar.ListGzip(payload)
+	case "tar.bz2":
+		return extractTar.ListBzip2(payload)
+	case "tar.xz":
+		return extractTar.ListXz(payload)
+	case "tar.zst":
+		return extractTar.ListZstd(payload)
+	case "tar.lz4":
+		return extractTar.ListLz4(payload)
+	}
+	return fmt.Errorf("unsupported archive type %q", archiveType)
+}
+
+// askPassword reads a password from the terminal without echoing it.
+func askPassword() (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("the archive is encrypted; give its password with -p")
+	}
+	defer func() {
+		if closeErr := tty.Close(); closeErr != nil {
+			fmt.Printf("Error closing terminal: %v\n", closeErr)
+		}
+	}()
+
+	stty := func(arg string) {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = tty
+		_ = cmd.Run()
+	}
+	fmt.Fprint(tty, "Password: ")
+	stty("-echo")
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	stty("echo")
+	fmt.Fprintln(tty)
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,98 @@
+// Package bcj reverses the branch converters of xz and 7-Zip. These filters
+// turn the relative targets of branch instructions into absolute ones so that
+// repeated calls to the same function compress better.
+package bcj
+
+import "io"
+
+// Filter identifies the instruction set a branch converter was made for.
+type Filter int
+
+const (
+	X86 Filter = iota
+	PowerPC
+	IA64
+	ARM
+	ARMThumb
+	SPARC
+	ARM64
+	RISCV
+)
+
+// converter decodes the instructions of buf, which starts at stream position
+// pos, and returns how many bytes are final.
+type converter interface {
+	convert(buf []byte, pos uint32) int
+}
+
+// reader applies a converter to buffered input, holding back the bytes of
+// instructions that may continue in the next read.
+type reader struct {
+	r     io.Reader
+	conv  converter
+	buf   []byte
+	start int // decoded bytes waiting to be read
+	end   int // converted bytes end here; the rest await more input
+	fill  int
+	pos   uint32 // stream position of buf[0]
+	eof   bool
+}
+
+// NewReader returns a reader that reverses filter on r. Start is the stream
+// position the encoder began converting at, which is 0 unless it was set
+// explicitly.
+func NewReader(r io.Reader, filter Filter, start uint32) io.Reader {
+	var conv converter
+	switch filter {
+	case X86:
+		conv = &x86{prevPos: ^uint32(0) - 4}
+	case PowerPC:
+		conv = powerPC{}
+	case IA64:
+		conv = ia64{}
+	case ARM:
+		conv = arm{}
+	case ARMThumb:
+		conv = armThumb{}
+	case SPARC:
+		conv = sparc{}
+	case ARM64:
+		conv = arm64{}
+	case RISCV:
+		conv = riscv{}
+	default:
+		panic("bcj: unknown filter")
+	}
+	return &reader{r: r, conv: conv, buf: make([]byte, 1<<16), pos: start}
+}
+
+func (b *reader) Read(p []byte) (int, error) {
+	for b.start == b.end {
+		if b.eof {
+			if b.end < b.fill {
+				// The final bytes are too short to hold an instruction
+				b.end = b.fill
+				continue
+			}
+			return 0, io.EOF
+		}
+
+		// Keep the unconverted tail and refill
+		n := copy(b.buf, b.buf[b.end:b.fill])
+		b.pos += uint32(b.end)
+		b.start, b.end, b.fill = 0, 0, n
+		for b.fill < len(b.buf) && !b.eof {
+			m, err := b.r.Read(b.buf[b.fill:])
+			b.fill += m
+			if err == io.EOF {
+				b.eof = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		b.end = b.conv.convert(b.buf[:b.fill], b.pos)
+	}
+	n := copy(p, b.buf[b.start:b.end])
+	b.start += n
+	return n, nil
+}
@@ -0,0 +1,285 @@
+package bcj
+
+import "encoding/binary"
+
+// x86 converts the targets of CALL and JMP instructions. Since x86
+// instructions vary in length, it tracks recently seen opcode bytes to skip
+// those that are likely operands. It follows the x86 filter of xz and 7-Zip.
+type x86 struct {
+	prevMask uint32
+	prevPos  uint32
+}
+
+func (b *x86) convert(buf []byte, pos uint32) int {
+	maskToAllowed := [8]bool{true, true, true, false, true, false, false, false}
+	maskToBitNumber := [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+	test86MSByte := func(v byte) bool { return v == 0 || v == 0xFF }
+
+	if len(buf) < 5 {
+		return 0
+	}
+	if pos-b.prevPos > 5 {
+		b.prevPos = pos - 5
+	}
+	limit := len(buf) - 5
+	i := 0
+	for i <= limit {
+		op := buf[i]
+		if op != 0xE8 && op != 0xE9 {
+			i++
+			continue
+		}
+		offset := pos + uint32(i) - b.prevPos
+		b.prevPos = pos + uint32(i)
+		if offset > 5 {
+			b.prevMask = 0
+		} else {
+			for k := uint32(0); k < offset; k++ {
+				b.prevMask &= 0x77
+				b.prevMask <<= 1
+			}
+		}
+
+		v := buf[i+4]
+		if test86MSByte(v) && maskToAllowed[(b.prevMask>>1)&7] && b.prevMask>>1 < 0x10 {
+			src := binary.LittleEndian.Uint32(buf[i+1:])
+			var dest uint32
+			for {
+				dest = src - (pos + uint32(i) + 5)
+				if b.prevMask == 0 {
+					break
+				}
+				bit := maskToBitNumber[b.prevMask>>1]
+				if !test86MSByte(byte(dest >> (24 - bit*8))) {
+					break
+				}
+				src = dest ^ (1<<(32-bit*8) - 1)
+			}
+			dest &= 0x01FFFFFF
+			if dest&0x01000000 != 0 {
+				dest |= 0xFF000000
+			}
+			binary.LittleEndian.PutUint32(buf[i+1:], dest)
+			i += 5
+			b.prevMask = 0
+		} else {
+			i++
+			b.prevMask |= 1
+			if test86MSByte(v) {
+				b.prevMask |= 0x10
+			}
+		}
+	}
+	return i
+}
+
+// powerPC converts the targets of B instructions with the link bit set.
+type powerPC struct{}
+
+func (powerPC) convert(buf []byte, pos uint32) int {
+	i := 0
+	for ; i+4 <= len(buf); i += 4 {
+		if buf[i]>>2 != 0x12 || buf[i+3]&3 != 1 {
+			continue
+		}
+		src := binary.BigEndian.Uint32(buf[i:]) & 0x03FFFFFC
+		dest := src - (pos + uint32(i))
+		binary.BigEndian.PutUint32(buf[i:], 0x48000000|dest&0x03FFFFFF|uint32(buf[i+3]&3))
+	}
+	return i
+}
+
+// ia64BranchTable gives the instruction slots of a bundle template that may
+// hold a branch.
+var ia64BranchTable = [32]uint32{
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	4, 4, 6, 6, 0, 0, 7, 7,
+	4, 4, 0, 0, 4, 4, 0, 0,
+}
+
+// ia64 converts the targets of IP-relative branches in 16-byte bundles.
+type ia64 struct{}
+
+func (ia64) convert(buf []byte, pos uint32) int {
+	i := 0
+	for ; i+16 <= len(buf); i += 16 {
+		mask := ia64BranchTable[buf[i]&0x1F]
+		for slot, bitPos := 0, 5; slot < 3; slot, bitPos = slot+1, bitPos+41 {
+			if (mask>>slot)&1 == 0 {
+				continue
+			}
+			bytePos := bitPos >> 3
+			bitRes := bitPos & 7
+			var instruction uint64
+			for j := 0; j < 6; j++ {
+				instruction |= uint64(buf[i+j+bytePos]) << (8 * j)
+			}
+			norm := instruction >> bitRes
+			if (norm>>37)&0xF != 0x5 || (norm>>9)&0x7 != 0 {
+				continue
+			}
+			src := uint32((norm >> 13) & 0xFFFFF)
+			src |= uint32((norm>>36)&1) << 20
+			src <<= 4
+			dest := src - (pos + uint32(i))
+			dest >>= 4
+			norm &^= uint64(0x8FFFFF) << 13
+			norm |= uint64(dest&0xFFFFF) << 13
+			norm |= uint64(dest&0x100000) << (36 - 20)
+			instruction &= 1<<bitRes - 1
+			instruction |= norm << bitRes
+			for j := 0; j < 6; j++ {
+				buf[i+j+bytePos] = byte(instruction >> (8 * j))
+			}
+		}
+	}
+	return i
+}
+
+// arm converts the targets of BL instructions.
+type arm struct{}
+
+func (arm) convert(buf []byte, pos uint32) int {
+	i := 0
+	for ; i+4 <= len(buf); i += 4 {
+		if buf[i+3] != 0xEB {
+			continue
+		}
+		src := uint32(buf[i+2])<<16 | uint32(buf[i+1])<<8 | uint32(buf[i])
+		dest := (src<<2 - (pos + uint32(i) + 8)) >> 2
+		buf[i+2], buf[i+1], buf[i] = byte(dest>>16), byte(dest>>8), byte(dest)
+	}
+	return i
+}
+
+// armThumb converts the targets of the BL instruction pairs of Thumb code.
+type armThumb struct{}
+
+func (armThumb) convert(buf []byte, pos uint32) int {
+	i := 0
+	for ; i+4 <= len(buf); i += 2 {
+		if buf[i+1]&0xF8 != 0xF0 || buf[i+3]&0xF8 != 0xF8 {
+			continue
+		}
+		src := uint32(buf[i+1]&7)<<19 | uint32(buf[i])<<11 | uint32(buf[i+3]&7)<<8 | uint32(buf[i+2])
+		dest := (src<<1 - (pos + uint32(i) + 4)) >> 1
+		buf[i+1] = 0xF0 | byte(dest>>19)&7
+		buf[i] = byte(dest >> 11)
+		buf[i+3] = 0xF8 | byte(dest>>8)&7
+		buf[i+2] = byte(dest)
+		i += 2
+	}
+	return i
+}
+
+// sparc converts the targets of CALL instructions.
+type sparc struct{}
+
+func (sparc) convert(buf []byte, pos uint32) int {
+	i := 0
+	for ; i+4 <= len(buf); i += 4 {
+		if !(buf[i] == 0x40 && buf[i+1]&0xC0 == 0) && !(buf[i] == 0x7F && buf[i+1]&0xC0 == 0xC0) {
+			continue
+		}
+		src := binary.BigEndian.Uint32(buf[i:])
+		dest := (src<<2 - (pos + uint32(i))) >> 2
+		dest = (0-(dest>>22)&1)<<22&0x3FFFFFFF | dest&0x3FFFFF | 0x40000000
+		binary.BigEndian.PutUint32(buf[i:], dest)
+	}
+	return i
+}
+
+// arm64 converts the targets of BL instructions and, within a range of
+// 512 MiB, the pages ADRP instructions compute.
+type arm64 struct{}
+
+func (arm64) convert(buf []byte, pos uint32) int {
+	i := 0
+	for ; i+4 <= len(buf); i += 4 {
+		pc := pos + uint32(i)
+		instr := binary.LittleEndian.Uint32(buf[i:])
+		switch {
+		case instr>>26 == 0x25:
+			instr = 0x94000000 | (instr-pc>>2)&0x03FFFFFF
+		case instr&0x9F000000 == 0x90000000:
+			src := (instr>>29)&3 | (instr>>3)&0x001FFFFC
+			if (src+0x00020000)&0x001C0000 != 0 {
+				continue
+			}
+			dest := src - pc>>12
+			instr &= 0x9000001F
+			instr |= (dest & 3) << 29
+			instr |= (dest & 0x0003FFFC) << 3
+			instr |= (0 - dest&0x00020000) & 0x00E00000
+		default:
+			continue
+		}
+		binary.LittleEndian.PutUint32(buf[i:], instr)
+	}
+	return i
+}
+
+// riscv converts the targets of JAL instructions and of AUIPC instructions
+// paired with the instruction that uses their result. Paired instructions are
+// stored rearranged, with the absolute address in big-endian order.
+type riscv struct{}
+
+func (riscv) convert(buf []byte, pos uint32) int {
+	if len(buf) < 8 {
+		return 0
+	}
+	i := 0
+	for ; i <= len(buf)-8; i += 2 {
+		inst := uint32(buf[i])
+		if inst == 0xEF {
+			// JAL with rd x1 or x5
+			b1, b2, b3 := uint32(buf[i+1]), uint32(buf[i+2]), uint32(buf[i+3])
+			if b1&0x0D != 0 {
+				continue
+			}
+			addr := (b1&0xF0)<<13 | b2<<9 | b3<<1
+			addr -= pos + uint32(i)
+			buf[i+1] = byte(b1&0x0F | (addr>>8)&0xF0)
+			buf[i+2] = byte((addr>>16)&0x0F | (addr>>7)&0x10 | (addr<<4)&0xE0)
+			buf[i+3] = byte((addr>>4)&0x7F | (addr>>13)&0x80)
+			i += 4 - 2
+			continue
+		}
+		if inst&0x7F != 0x17 {
+			continue
+		}
+
+		// AUIPC
+		inst = binary.LittleEndian.Uint32(buf[i:])
+		var inst2 uint32
+		if inst&0xE80 != 0 {
+			// With rd other than x0 and x2, only a pair the encoder
+			// rearranged to keep it apart from converted ones is changed
+			inst2 = binary.LittleEndian.Uint32(buf[i+4:])
+			if ((inst<<8)^(inst2-3))&0xF8003 != 0 {
+				i += 6 - 2
+				continue
+			}
+			addr := inst&0xFFFFF000 | inst2>>20
+			inst = 0x117 | inst2<<12
+			inst2 = addr
+		} else {
+			// A converted pair is marked by rd x2 and the register of
+			// the second instruction in the top bits
+			rs1 := inst >> 27
+			if (inst-0x3117)<<18 >= rs1&0x1D {
+				i += 4 - 2
+				continue
+			}
+			addr := binary.BigEndian.Uint32(buf[i+4:])
+			addr -= pos + uint32(i)
+			inst2 = inst>>12 | addr<<20
+			inst = 0x17 | rs1<<7 | (addr+0x800)&0xFFFFF000
+		}
+		binary.LittleEndian.PutUint32(buf[i:], inst)
+		binary.LittleEndian.PutUint32(buf[i+4:], inst2)
+		i += 8 - 2
+	}
+	return i
+}
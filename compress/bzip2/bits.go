@@ -0,0 +1,49 @@
+package bzip2
+
+import "io"
+
+// bitWriter writes bits most-significant first, as bzip2 requires.
+type bitWriter struct {
+	w     io.Writer
+	acc   uint64
+	nbits uint
+	buf   [4096]byte
+	n     int
+	err   error
+}
+
+func (b *bitWriter) writeBits(v uint32, n uint) {
+	b.acc = b.acc<<n | uint64(v)&(1<<n-1)
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.nbits -= 8
+		b.buf[b.n] = byte(b.acc >> b.nbits)
+		b.n++
+		if b.n == len(b.buf) {
+			b.flushBuffer()
+		}
+	}
+}
+
+func (b *bitWriter) writeBits64(v uint64, n uint) {
+	if n > 32 {
+		b.writeBits(uint32(v>>32), n-32)
+		n = 32
+	}
+	b.writeBits(uint32(v), n)
+}
+
+// flush pads the final byte with zero bits and writes out everything buffered.
+func (b *bitWriter) flush() {
+	if b.nbits > 0 {
+		b.writeBits(0, 8-b.nbits)
+	}
+	b.flushBuffer()
+}
+
+func (b *bitWriter) flushBuffer() {
+	if b.err == nil && b.n > 0 {
+		_, b.err = b.w.Write(b.buf[:b.n])
+	}
+	b.n = 0
+}
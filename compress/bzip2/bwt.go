@@ -0,0 +1,88 @@
+package bzip2
+
+// burrowsWheeler returns the last column of the sorted rotations of block and the
+// row at which the original block appears.
+func burrowsWheeler(block []byte) ([]byte, int) {
+	n := len(block)
+	sa := sortRotations(block)
+	out := make([]byte, n)
+	origPtr := 0
+	for i, p := range sa {
+		if p == 0 {
+			origPtr = i
+			out[i] = block[n-1]
+		} else {
+			out[i] = block[p-1]
+		}
+	}
+	return out, origPtr
+}
+
+// sortRotations sorts the cyclic rotations of block by prefix doubling, using
+// counting sorts so each round is linear in the block size.
+func sortRotations(block []byte) []int32 {
+	n := len(block)
+	sa := make([]int32, n)
+	rank := make([]int32, n)
+	next := make([]int32, n)
+	tmp := make([]int32, n)
+
+	// Round zero: order by the first byte
+	var count [257]int32
+	for _, b := range block {
+		count[int(b)+1]++
+	}
+	for i := 1; i < 257; i++ {
+		count[i] += count[i-1]
+	}
+	for i, b := range block {
+		sa[count[b]] = int32(i)
+		count[b]++
+	}
+	classes := int32(0)
+	for i := 0; i < n; i++ {
+		if i > 0 && block[sa[i]] != block[sa[i-1]] {
+			classes++
+		}
+		rank[sa[i]] = classes
+	}
+
+	buckets := make([]int32, n+1)
+	for k := 1; k < n && int(classes) < n-1; k <<= 1 {
+		// Rotations ordered by their second half are the current order shifted back by k
+		for i, p := range sa {
+			q := int(p) - k
+			if q < 0 {
+				q += n
+			}
+			tmp[i] = int32(q)
+		}
+
+		// Stable counting sort by the first half
+		for i := range buckets {
+			buckets[i] = 0
+		}
+		for _, p := range tmp {
+			buckets[rank[p]+1]++
+		}
+		for i := 1; i <= int(classes)+1; i++ {
+			buckets[i] += buckets[i-1]
+		}
+		for _, p := range tmp {
+			sa[buckets[rank[p]]] = p
+			buckets[rank[p]]++
+		}
+
+		classes = 0
+		next[sa[0]] = 0
+		for i := 1; i < n; i++ {
+			cur, prev := int(sa[i]), int(sa[i-1])
+			if rank[cur] != rank[prev] || rank[(cur+k)%n] != rank[(prev+k)%n] {
+				classes++
+			}
+			next[cur] = classes
+		}
+		rank, next = next, rank
+	}
+	return sa
+}
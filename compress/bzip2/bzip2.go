@@ -0,0 +1,400 @@
+// Package bzip2 implements a bzip2 compressor. The standard library only ships a
+// decompressor (compress/bzip2), which is used for reading.
+package bzip2
+
+import (
+	"fmt"
+	"io"
+)
+
+// Compression levels select the block size in units of 100k.
+const (
+	BestSpeed          = 1
+	BestCompression    = 9
+	DefaultCompression = -1
+)
+
+const (
+	blockMagic  = 0x314159265359
+	streamMagic = 0x177245385090
+
+	maxCodeLen   = 17
+	groupSize    = 50
+	runA         = 0
+	runB         = 1
+	refineRounds = 4
+)
+
+// Writer compresses data written to it and writes the bzip2 stream to the underlying writer.
+type Writer struct {
+	bw       *bitWriter
+	level    int
+	maxBlock int
+
+	block   []byte
+	inUse   [256]bool
+	runByte byte
+	runLen  int
+
+	blockCRC    uint32
+	combinedCRC uint32
+	wroteHeader bool
+	closed      bool
+}
+
+// NewWriter returns a Writer compressing at the default level.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel returns a Writer compressing at the given level, 1 (100k blocks)
+// through 9 (900k blocks), or DefaultCompression.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level == DefaultCompression {
+		level = BestCompression
+	}
+	if level < BestSpeed || level > BestCompression {
+		return nil, fmt.Errorf("bzip2: invalid compression level %d (expected 1-9)", level)
+	}
+
+	// Leave headroom so that a pending run (at most 5 bytes) always fits in the block
+	maxBlock := level*100000 - 19
+	return &Writer{
+		bw:       &bitWriter{w: w},
+		level:    level,
+		maxBlock: maxBlock,
+		block:    make([]byte, 0, maxBlock+5),
+		blockCRC: 0xffffffff,
+	}, nil
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, fmt.Errorf("bzip2: write to closed writer")
+	}
+	for _, b := range p {
+		if z.runLen > 0 && b == z.runByte && z.runLen < 255 {
+			z.runLen++
+			continue
+		}
+		if z.runLen > 0 {
+			z.addRun()
+			if len(z.block) >= z.maxBlock {
+				if err := z.writeBlock(); err != nil {
+					return 0, err
+				}
+			}
+		}
+		z.runByte = b
+		z.runLen = 1
+	}
+	return len(p), z.bw.err
+}
+
+// Close flushes the remaining data and writes the end-of-stream marker.
+// It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.bw.err
+	}
+	z.closed = true
+
+	if z.runLen > 0 {
+		z.addRun()
+	}
+	if len(z.block) > 0 {
+		if err := z.writeBlock(); err != nil {
+			return err
+		}
+	}
+	z.writeStreamHeader()
+	z.bw.writeBits64(streamMagic, 48)
+	z.bw.writeBits(z.combinedCRC, 32)
+	z.bw.flush()
+	return z.bw.err
+}
+
+// addRun appends the pending run to the block using the initial run-length encoding:
+// runs of 4 to 255 bytes are stored as four bytes followed by a repeat count.
+func (z *Writer) addRun() {
+	for i := 0; i < z.runLen; i++ {
+		z.blockCRC = updateCRC(z.blockCRC, z.runByte)
+	}
+	z.inUse[z.runByte] = true
+
+	if z.runLen < 4 {
+		for i := 0; i < z.runLen; i++ {
+			z.block = append(z.block, z.runByte)
+		}
+	} else {
+		z.block = append(z.block, z.runByte, z.runByte, z.runByte, z.runByte, byte(z.runLen-4))
+		z.inUse[byte(z.runLen-4)] = true
+	}
+	z.runLen = 0
+}
+
+func (z *Writer) writeStreamHeader() {
+	if z.wroteHeader {
+		return
+	}
+	z.wroteHeader = true
+	z.bw.writeBits('B', 8)
+	z.bw.writeBits('Z', 8)
+	z.bw.writeBits('h', 8)
+	z.bw.writeBits(uint32('0'+z.level), 8)
+}
+
+// writeBlock compresses the buffered block and resets the block state.
+func (z *Writer) writeBlock() error {
+	z.writeStreamHeader()
+
+	crc := ^z.blockCRC
+	z.combinedCRC = (z.combinedCRC<<1 | z.combinedCRC>>31) ^ crc
+
+	bwt, origPtr := burrowsWheeler(z.block)
+	symbols, freqs, alphaSize := z.mtfEncode(bwt)
+
+	z.bw.writeBits64(blockMagic, 48)
+	z.bw.writeBits(crc, 32)
+	z.bw.writeBits(0, 1) // not randomised
+	z.bw.writeBits(uint32(origPtr), 24)
+
+	// Symbol map: a 16-bit summary of used 16-byte ranges, then each used range
+	var ranges uint32
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if z.inUse[i*16+j] {
+				ranges |= 1 << uint(15-i)
+				break
+			}
+		}
+	}
+	z.bw.writeBits(ranges, 16)
+	for i := 0; i < 16; i++ {
+		if ranges&(1<<uint(15-i)) == 0 {
+			continue
+		}
+		var bits uint32
+		for j := 0; j < 16; j++ {
+			if z.inUse[i*16+j] {
+				bits |= 1 << uint(15-j)
+			}
+		}
+		z.bw.writeBits(bits, 16)
+	}
+	z.writeHuffman(symbols, freqs, alphaSize)
+
+	z.block = z.block[:0]
+	z.inUse = [256]bool{}
+	z.blockCRC = 0xffffffff
+	return z.bw.err
+}
+
+// mtfEncode applies the move-to-front transform and the zero run-length encoding
+// (RUNA/RUNB) to the BWT output, returning the symbol stream ending with EOB.
+func (z *Writer) mtfEncode(bwt []byte) ([]uint16, []int32, int) {
+	var unseqToSeq [256]byte
+	var seqToUnseq []byte
+	for i := 0; i < 256; i++ {
+		if z.inUse[i] {
+			unseqToSeq[i] = byte(len(seqToUnseq))
+			seqToUnseq = append(seqToUnseq, byte(i))
+		}
+	}
+	nInUse := len(seqToUnseq)
+	alphaSize := nInUse + 2
+	eob := uint16(nInUse + 1)
+
+	order := make([]byte, nInUse)
+	for i := range order {
+		order[i] = byte(i)
+	}
+
+	symbols := make([]uint16, 0, len(bwt)+1)
+	freqs := make([]int32, alphaSize)
+	zeroRun := 0
+	flushZeros := func() {
+		if zeroRun == 0 {
+			return
+		}
+		zeroRun--
+		for {
+			sym := uint16(runA)
+			if zeroRun&1 != 0 {
+				sym = runB
+			}
+			symbols = append(symbols, sym)
+			freqs[sym]++
+			if zeroRun < 2 {
+				break
+			}
+			zeroRun = (zeroRun - 2) / 2
+		}
+		zeroRun = 0
+	}
+
+	for _, b := range bwt {
+		c := unseqToSeq[b]
+		if order[0] == c {
+			zeroRun++
+			continue
+		}
+		flushZeros()
+		j := 1
+		for order[j] != c {
+			j++
+		}
+		copy(order[1:j+1], order[:j])
+		order[0] = c
+		sym := uint16(j + 1)
+		symbols = append(symbols, sym)
+		freqs[sym]++
+	}
+	flushZeros()
+	symbols = append(symbols, eob)
+	freqs[eob]++
+
+	return symbols, freqs, alphaSize
+}
+
+// writeHuffman chooses the coding tables and selectors, then writes them and the symbols.
+func (z *Writer) writeHuffman(symbols []uint16, freqs []int32, alphaSize int) {
+	nGroups := 6
+	switch n := len(symbols); {
+	case n < 200:
+		nGroups = 2
+	case n < 600:
+		nGroups = 3
+	case n < 1200:
+		nGroups = 4
+	case n < 2400:
+		nGroups = 5
+	}
+
+	lengths := make([][]uint8, nGroups)
+	for t := range lengths {
+		lengths[t] = make([]uint8, alphaSize)
+	}
+
+	// Initial tables: split the alphabet into ranges of roughly equal frequency
+	remaining := int32(len(symbols))
+	start := 0
+	for part := nGroups; part > 0; part-- {
+		target := remaining / int32(part)
+		end := start - 1
+		var acc int32
+		for acc < target && end < alphaSize-1 {
+			end++
+			acc += freqs[end]
+		}
+		if end > start && part != nGroups && part != 1 && (nGroups-part)%2 == 1 {
+			acc -= freqs[end]
+			end--
+		}
+		for v := 0; v < alphaSize; v++ {
+			if v >= start && v <= end {
+				lengths[part-1][v] = 0
+			} else {
+				lengths[part-1][v] = 15
+			}
+		}
+		start = end + 1
+		remaining -= acc
+	}
+
+	nSelectors := (len(symbols) + groupSize - 1) / groupSize
+	selectors := make([]byte, nSelectors)
+	for round := 0; round < refineRounds; round++ {
+		groupFreqs := make([][]int32, nGroups)
+		for t := range groupFreqs {
+			groupFreqs[t] = make([]int32, alphaSize)
+		}
+		for s := 0; s < nSelectors; s++ {
+			group := symbols[s*groupSize : min((s+1)*groupSize, len(symbols))]
+			best, bestCost := 0, -1
+			for t := 0; t < nGroups; t++ {
+				cost := 0
+				for _, sym := range group {
+					cost += int(lengths[t][sym])
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+			selectors[s] = byte(best)
+			for _, sym := range group {
+				groupFreqs[best][sym]++
+			}
+		}
+		for t := 0; t < nGroups; t++ {
+			lengths[t] = huffmanLengths(groupFreqs[t], maxCodeLen)
+		}
+	}
+
+	z.bw.writeBits(uint32(nGroups), 3)
+	z.bw.writeBits(uint32(nSelectors), 15)
+
+	// Selectors are move-to-front coded and written in unary
+	mtf := make([]byte, nGroups)
+	for i := range mtf {
+		mtf[i] = byte(i)
+	}
+	for _, sel := range selectors {
+		j := 0
+		for mtf[j] != sel {
+			j++
+		}
+		copy(mtf[1:j+1], mtf[:j])
+		mtf[0] = sel
+		for ; j > 0; j-- {
+			z.bw.writeBits(1, 1)
+		}
+		z.bw.writeBits(0, 1)
+	}
+
+	// Code lengths are delta coded against the previous symbol
+	for t := 0; t < nGroups; t++ {
+		cur := int(lengths[t][0])
+		z.bw.writeBits(uint32(cur), 5)
+		for _, l := range lengths[t] {
+			for cur < int(l) {
+				z.bw.writeBits(2, 2)
+				cur++
+			}
+			for cur > int(l) {
+				z.bw.writeBits(3, 2)
+				cur--
+			}
+			z.bw.writeBits(0, 1)
+		}
+	}
+
+	codes := make([][]uint32, nGroups)
+	for t := range codes {
+		codes[t] = canonicalCodes(lengths[t])
+	}
+	for s := 0; s < nSelectors; s++ {
+		t := selectors[s]
+		for _, sym := range symbols[s*groupSize : min((s+1)*groupSize, len(symbols))] {
+			z.bw.writeBits(codes[t][sym], uint(lengths[t][sym]))
+		}
+	}
+}
+
+// canonicalCodes assigns codes in order of length, then symbol, as the decoder expects.
+func canonicalCodes(lengths []uint8) []uint32 {
+	codes := make([]uint32, len(lengths))
+	var code uint32
+	for n := uint8(1); n <= maxCodeLen; n++ {
+		for sym, l := range lengths {
+			if l == n {
+				codes[sym] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
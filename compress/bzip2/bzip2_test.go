@@ -0,0 +1,116 @@
+package bzip2
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+type testInput struct {
+	name string
+	data []byte
+}
+
+// testInputs returns data that exercises the run-length, sorting and block
+// handling of the encoder.
+func testInputs() []testInput {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 300<<10)
+	rng.Read(random)
+
+	var runs []byte
+	for i := 0; i < 2000; i++ {
+		runs = append(runs, bytes.Repeat([]byte{byte(i % 7)}, i%300+1)...)
+	}
+	var all []byte
+	for i := 0; i < 64; i++ {
+		for b := 0; b < 256; b++ {
+			all = append(all, byte(b))
+		}
+	}
+	var text bytes.Buffer
+	for i := 1; text.Len() < 1200<<10; i++ {
+		fmt.Fprintf(&text, "line %d of the text, which repeats with small changes\n", i)
+	}
+
+	return []testInput{
+		{"empty", nil},
+		{"one byte", []byte{'x'}},
+		{"long run", bytes.Repeat([]byte{'a'}, 1<<20)},
+		{"runs of every length", runs},
+		{"all byte values", all},
+		{"incompressible", random},
+		{"several blocks", text.Bytes()},
+	}
+}
+
+func compress(t *testing.T, data []byte, level int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriterLevel(&buf, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd write sizes split runs and blocks at arbitrary points
+	for len(data) > 0 {
+		n := min(len(data), 7777)
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, in := range testInputs() {
+		for _, level := range []int{BestSpeed, BestCompression} {
+			t.Run(fmt.Sprintf("%s/level %d", in.name, level), func(t *testing.T) {
+				compressed := compress(t, in.data, level)
+				got, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(compressed)))
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				if !bytes.Equal(got, in.data) {
+					t.Fatalf("round trip of %d bytes returned %d different bytes", len(in.data), len(got))
+				}
+			})
+		}
+	}
+}
+
+func TestConcatenatedStreams(t *testing.T) {
+	first, second := []byte("first stream\n"), bytes.Repeat([]byte("second stream\n"), 1000)
+	stream := append(compress(t, first, BestSpeed), compress(t, second, BestSpeed)...)
+	got, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(first, second...); !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestInvalidLevel(t *testing.T) {
+	for _, level := range []int{0, 10} {
+		if _, err := NewWriterLevel(io.Discard, level); err == nil {
+			t.Errorf("level %d was accepted", level)
+		}
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	w := NewWriter(io.Discard)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("late")); err == nil {
+		t.Error("write after close succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+package bzip2
+
+import "sort"
+
+// huffmanLengths computes code lengths for the given frequencies, no longer than
+// maxLen. Unused symbols still get a code since the table must cover the alphabet.
+func huffmanLengths(freqs []int32, maxLen int) []uint8 {
+	weights := make([]int64, len(freqs))
+	for i, f := range freqs {
+		weights[i] = int64(max(f, 1))
+	}
+
+	for {
+		lengths := buildLengths(weights)
+		longest := uint8(0)
+		for _, l := range lengths {
+			longest = max(longest, l)
+		}
+		if int(longest) <= maxLen {
+			return lengths
+		}
+		// Flatten the distribution and try again
+		for i := range weights {
+			weights[i] = 1 + weights[i]/2
+		}
+	}
+}
+
+// buildLengths runs the classic two-queue Huffman construction over sorted leaves.
+func buildLengths(weights []int64) []uint8 {
+	n := len(weights)
+	lengths := make([]uint8, n)
+	if n == 1 {
+		lengths[0] = 1
+		return lengths
+	}
+
+	leaves := make([]int, n)
+	for i := range leaves {
+		leaves[i] = i
+	}
+	sort.SliceStable(leaves, func(a, b int) bool { return weights[leaves[a]] < weights[leaves[b]] })
+
+	// Nodes 0..n-1 are leaves, n.. are internal
+	weight := make([]int64, 2*n-1)
+	parent := make([]int, 2*n-1)
+	copy(weight, weights)
+	internal := make([]int, 0, n-1)
+	li, ii := 0, 0
+	pick := func() int {
+		if li < n && (ii >= len(internal) || weight[leaves[li]] <= weight[internal[ii]]) {
+			li++
+			return leaves[li-1]
+		}
+		ii++
+		return internal[ii-1]
+	}
+	for next := n; next < 2*n-1; next++ {
+		a, b := pick(), pick()
+		weight[next] = weight[a] + weight[b]
+		parent[a], parent[b] = next, next
+		internal = append(internal, next)
+	}
+
+	depth := make([]uint8, 2*n-1)
+	for node := 2*n - 3; node >= 0; node-- {
+		depth[node] = depth[parent[node]] + 1
+	}
+	copy(lengths, depth[:n])
+	return lengths
+}
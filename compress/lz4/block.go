@@ -0,0 +1,262 @@
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+var errCorrupt = errors.New("lz4: corrupt compressed data")
+
+const (
+	minMatch     = 4
+	maxDistance  = 65535
+	lastLiterals = 5  // the last bytes of a block are always literals
+	mfLimit      = 12 // no match may start within this many bytes of the end
+	hashLog      = 16
+)
+
+// DecodeBlock decompresses a raw LZ4 block of at most maxSize bytes, as
+// formats such as SquashFS store them without frames.
+func DecodeBlock(src []byte, maxSize int) ([]byte, error) {
+	return decodeBlock(make([]byte, 0, maxSize), src, maxSize)
+}
+
+// decodeBlock decompresses one block, appending to dst. Matches may reach back
+// into data already in dst, which holds the preceding history.
+func decodeBlock(dst, src []byte, maxSize int) ([]byte, error) {
+	start := len(dst)
+	i := 0
+	for {
+		if i >= len(src) {
+			return nil, errCorrupt
+		}
+		token := src[i]
+		i++
+
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			for {
+				if i >= len(src) {
+					return nil, errCorrupt
+				}
+				b := src[i]
+				i++
+				litLen += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if litLen > len(src)-i || len(dst)-start+litLen > maxSize {
+			return nil, errCorrupt
+		}
+		dst = append(dst, src[i:i+litLen]...)
+		i += litLen
+		if i == len(src) {
+			// The last sequence has no match
+			return dst, nil
+		}
+
+		if i+2 > len(src) {
+			return nil, errCorrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		matchLen := int(token & 15)
+		if matchLen == 15 {
+			for {
+				if i >= len(src) {
+					return nil, errCorrupt
+				}
+				b := src[i]
+				i++
+				matchLen += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		matchLen += minMatch
+		if offset == 0 || offset > len(dst) || len(dst)-start+matchLen > maxSize {
+			return nil, errCorrupt
+		}
+		from := len(dst) - offset
+		if matchLen <= offset {
+			dst = append(dst, dst[from:from+matchLen]...)
+		} else {
+			for k := 0; k < matchLen; k++ {
+				dst = append(dst, dst[from+k])
+			}
+		}
+	}
+}
+
+// compressor finds matches for one block, optionally preceded by history.
+// Level 1 probes a single hash slot at accelerating steps; higher levels
+// index every position and follow hash chains.
+type compressor struct {
+	level     int
+	depth     int
+	hashTable []int32
+	chain     []uint16 // distance to the previous position with the same hash
+	next      int      // next position to index
+}
+
+func newCompressor(level int) *compressor {
+	c := &compressor{level: level, hashTable: make([]int32, 1<<hashLog), depth: 1}
+	if level >= 2 {
+		c.depth = 1 << min(level-1, 12)
+		c.chain = make([]uint16, 1<<16)
+	}
+	return c
+}
+
+func hash4(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - hashLog)
+}
+
+func (c *compressor) insert(buf []byte, pos int) {
+	h := hash4(binary.LittleEndian.Uint32(buf[pos:]))
+	if c.chain != nil {
+		prev := int(c.hashTable[h]) - 1
+		d := pos - prev
+		if prev < 0 || d > maxDistance {
+			d = 0
+		}
+		c.chain[pos&0xffff] = uint16(d)
+	}
+	c.hashTable[h] = int32(pos + 1)
+}
+
+// insertUpTo indexes every position before end.
+func (c *compressor) insertUpTo(buf []byte, end int) {
+	end = min(end, len(buf)-minMatch+1)
+	for ; c.next < end; c.next++ {
+		c.insert(buf, c.next)
+	}
+}
+
+// find returns the longest match for pos, searching no further back than low.
+func (c *compressor) find(buf []byte, pos, low, limit int) (int, int) {
+	if c.chain != nil {
+		c.insertUpTo(buf, pos)
+	}
+	v := binary.LittleEndian.Uint32(buf[pos:])
+	cand := int(c.hashTable[hash4(v)]) - 1
+	if c.chain == nil {
+		c.insert(buf, pos)
+	}
+	bestLen, bestPos := 0, 0
+	for depth := c.depth; depth > 0 && cand >= low && pos-cand <= maxDistance; depth-- {
+		if binary.LittleEndian.Uint32(buf[cand:]) == v {
+			if n := minMatch + matchLen(buf, cand+minMatch, pos+minMatch, limit); n > bestLen {
+				bestLen, bestPos = n, cand
+			}
+		}
+		if c.chain == nil {
+			break
+		}
+		d := int(c.chain[cand&0xffff])
+		if d == 0 {
+			break
+		}
+		cand -= d
+	}
+	return bestLen, bestPos
+}
+
+func matchLen(buf []byte, a, b, limit int) int {
+	n := 0
+	for b+n+8 <= limit {
+		x := binary.LittleEndian.Uint64(buf[a+n:]) ^ binary.LittleEndian.Uint64(buf[b+n:])
+		if x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for b+n < limit && buf[a+n] == buf[b+n] {
+		n++
+	}
+	return n
+}
+
+// compress encodes buf[start:] as one block, using buf[:start] as history,
+// and appends the result to dst.
+func (c *compressor) compress(dst, buf []byte, start int) []byte {
+	clear(c.hashTable)
+	end := len(buf)
+	low := max(0, start-maxDistance)
+	c.next = low
+	if c.chain == nil {
+		for p := low; p < start && p+minMatch <= end; p++ {
+			c.insert(buf, p)
+		}
+	}
+
+	anchor := start
+	if end-start >= mfLimit+1 {
+		matchLimit := end - lastLiterals
+		pos := start
+		for pos <= end-mfLimit {
+			ml, cand := c.find(buf, pos, low, matchLimit)
+			if c.level >= 9 && ml >= minMatch && ml < 64 && pos+1 <= end-mfLimit {
+				// One step of lazy evaluation at the highest levels
+				if ml2, cand2 := c.find(buf, pos+1, low, matchLimit); ml2 > ml+1 {
+					ml, cand = ml2, cand2
+					pos++
+				}
+			}
+			if ml < minMatch {
+				step := 1
+				if c.level <= 1 {
+					step += (pos - anchor) >> 6
+				}
+				pos += step
+				continue
+			}
+			for pos > anchor && cand > low && buf[pos-1] == buf[cand-1] {
+				pos--
+				cand--
+				ml++
+			}
+			dst = appendSequence(dst, buf[anchor:pos], pos-cand, ml)
+			pos += ml
+			anchor = pos
+			if c.chain == nil && pos-2 > anchor-ml {
+				c.insert(buf, pos-2)
+			}
+		}
+	}
+	return appendLastLiterals(dst, buf[anchor:])
+}
+
+func appendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+func appendSequence(dst, lits []byte, offset, matchLen int) []byte {
+	token := byte(min(len(lits), 15))<<4 | byte(min(matchLen-minMatch, 15))
+	dst = append(dst, token)
+	if len(lits) >= 15 {
+		dst = appendLength(dst, len(lits)-15)
+	}
+	dst = append(dst, lits...)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if matchLen-minMatch >= 15 {
+		dst = appendLength(dst, matchLen-minMatch-15)
+	}
+	return dst
+}
+
+func appendLastLiterals(dst, lits []byte) []byte {
+	dst = append(dst, byte(min(len(lits), 15))<<4)
+	if len(lits) >= 15 {
+		dst = appendLength(dst, len(lits)-15)
+	}
+	return append(dst, lits...)
+}
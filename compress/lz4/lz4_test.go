@@ -0,0 +1,198 @@
+package lz4
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testInput struct {
+	name string
+	data []byte
+}
+
+// testInputs returns data that exercises literal runs, overlapping matches
+// and uncompressed blocks.
+func testInputs() []testInput {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 200<<10)
+	rng.Read(random)
+
+	var all []byte
+	for i := 0; i < 64; i++ {
+		for b := 0; b < 256; b++ {
+			all = append(all, byte(b))
+		}
+	}
+
+	return []testInput{
+		{"empty", nil},
+		{"one byte", []byte{'x'}},
+		{"long run", bytes.Repeat([]byte{'a'}, 1<<20)},
+		{"all byte values", all},
+		{"incompressible", random},
+		// Several 64 KiB blocks
+		{"text", lines(12000)},
+	}
+}
+
+// lines returns the output of
+// "seq -f 'line %g of the text, which repeats with small changes' 1 n".
+func lines(n int) []byte {
+	var b bytes.Buffer
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "line %d of the text, which repeats with small changes\n", i)
+	}
+	return b.Bytes()
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	options := map[string]WriterOptions{
+		"defaults":                 {},
+		"level 9":                  {Level: 9},
+		"64K blocks":               {BlockSize: Block64KB},
+		"dependent blocks":         {BlockSize: Block64KB, DependentBlocks: true, Level: 12},
+		"block checksums":          {BlockSize: Block256KB, BlockChecksum: true},
+		"without content checksum": {BlockSize: Block64KB, NoContentChecksum: true},
+	}
+	for _, in := range testInputs() {
+		for name, opts := range options {
+			t.Run(in.name+"/"+name, func(t *testing.T) {
+				var buf bytes.Buffer
+				w, err := NewWriterOptions(&buf, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(in.data); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := decompress(buf.Bytes())
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				if !bytes.Equal(got, in.data) {
+					t.Fatalf("round trip of %d bytes returned %d different bytes", len(in.data), len(got))
+				}
+			})
+		}
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opts := range []WriterOptions{{Level: 13}, {BlockSize: 1000}} {
+		if _, err := NewWriterOptions(io.Discard, opts); err == nil {
+			t.Errorf("options %+v were accepted", opts)
+		}
+	}
+}
+
+// The fixtures were made by lz4 1.9.4 from text.txt, the output of
+// "seq -f 'line %g of the text, which repeats with small changes' 1 3000":
+//
+//	lz4 text.txt > text.lz4
+//	lz4 -B4 -BD text.txt > dependent.lz4
+//	lz4 -B4 -BX text.txt > blockchecksum.lz4
+//	lz4 -B4 -BD -BX --no-frame-crc text.txt > nocontentchecksum.lz4
+//	lz4 --content-size text.txt > contentsize.lz4
+//	lz4 -l text.txt > legacy.lz4
+//	(printf 'first frame\n' | lz4; printf '\x5a\x2a\x4d\x18\x05\x00\x00\x00skip!'; lz4 -9 text.txt) > multiframe.lz4
+//	lz4 random.bin > random.lz4
+//
+// All but contentsize.lz4 were compressed from a pipe, so they leave the
+// content size out.
+func TestDecodeFiles(t *testing.T) {
+	text := lines(3000)
+	tests := map[string][]byte{
+		"text.lz4":              text,
+		"dependent.lz4":         text,
+		"blockchecksum.lz4":     text,
+		"nocontentchecksum.lz4": text,
+		"contentsize.lz4":       text,
+		"legacy.lz4":            text,
+		"multiframe.lz4":        append([]byte("first frame\n"), text...),
+		"random.lz4":            readFixture(t, "random.bin"),
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := decompress(readFixture(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestDecodeBlock(t *testing.T) {
+	// Literals "abc", then a match of length 9 at offset 3 that overlaps
+	// itself, then the final literals "xyzzy"
+	block := []byte{0x35, 'a', 'b', 'c', 3, 0, 0x50, 'x', 'y', 'z', 'z', 'y'}
+	got, err := DecodeBlock(block, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "abcabcabcabcxyzzy"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if _, err := DecodeBlock(block, 10); err == nil {
+		t.Error("block larger than maxSize was accepted")
+	}
+	// Cuts inside a sequence; ending after the literals of one is valid
+	for _, n := range []int{0, 2, 5, 8} {
+		if _, err := DecodeBlock(block[:n], 64); err == nil {
+			t.Errorf("block truncated to %d bytes was accepted", n)
+		}
+	}
+}
+
+// TestCorruptInput checks that damaged files fail with an error rather than
+// a panic. Only frames with block checksums catch every change to the data.
+func TestCorruptInput(t *testing.T) {
+	for _, name := range []string{"blockchecksum.lz4", "dependent.lz4", "legacy.lz4"} {
+		t.Run(name, func(t *testing.T) {
+			data := readFixture(t, name)
+			step := len(data)/50 + 1
+			for n := 0; n < len(data); n += step {
+				if _, err := decompress(data[:n]); err == nil {
+					t.Errorf("file truncated to %d bytes was accepted", n)
+				}
+			}
+			for i := 0; i < len(data); i += step/2 + 1 {
+				damaged := bytes.Clone(data)
+				damaged[i] ^= 0x55
+				_, err := decompress(damaged)
+				if err == nil && name == "blockchecksum.lz4" {
+					t.Errorf("file with byte %d changed was accepted", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package lz4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	frameMagic     = 0x184D2204
+	legacyMagic    = 0x184C2102
+	skippableMagic = 0x184D2A50
+	skippableMask  = 0xFFFFFFF0
+	legacyMaxBlock = 8 << 20
+	historySize    = 64 << 10
+)
+
+// blockSizes maps the block maximum size field of the frame descriptor.
+var blockSizes = map[byte]int{4: 64 << 10, 5: 256 << 10, 6: 1 << 20, 7: 4 << 20}
+
+// Reader decompresses a sequence of LZ4 frames, including legacy frames.
+type Reader struct {
+	r *bufio.Reader
+
+	inFrame       bool
+	legacy        bool
+	independent   bool
+	blockChecksum bool
+	contentSum    bool
+	contentSize   int64
+	hasSize       bool
+	blockMax      int
+	hash          *xxhash32
+	produced      int64
+
+	hist  []byte // recent output, for dependent blocks
+	out   int    // hist[out:] has not been returned yet
+	block []byte
+	err   error
+}
+
+// NewReader returns a Reader for r.
+func NewReader(r io.Reader) (*Reader, error) {
+	z := &Reader{r: bufio.NewReaderSize(r, 1<<16), hash: newXXHash32()}
+	if err := z.nextFrame(true); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("lz4: empty input")
+		}
+		return nil, err
+	}
+	return z, nil
+}
+
+// nextFrame skips skippable frames and parses the next frame header.
+func (z *Reader) nextFrame(first bool) error {
+	for {
+		var magic [4]byte
+		if _, err := io.ReadFull(z.r, magic[:]); err != nil {
+			if err == io.EOF {
+				return io.EOF
+			}
+			return fmt.Errorf("lz4: truncated frame header")
+		}
+		m := binary.LittleEndian.Uint32(magic[:])
+		switch {
+		case m&skippableMask == skippableMagic:
+			var size [4]byte
+			if _, err := io.ReadFull(z.r, size[:]); err != nil {
+				return fmt.Errorf("lz4: truncated skippable frame")
+			}
+			if _, err := z.r.Discard(int(binary.LittleEndian.Uint32(size[:]))); err != nil {
+				return fmt.Errorf("lz4: truncated skippable frame")
+			}
+			continue
+		case m == legacyMagic:
+			z.startFrame(true)
+			z.legacy = true
+			z.independent = true
+			z.blockMax = legacyMaxBlock
+			return nil
+		case m == frameMagic:
+			return z.readFrameHeader()
+		case first:
+			return fmt.Errorf("lz4: not an LZ4 stream")
+		default:
+			return fmt.Errorf("lz4: invalid frame magic %#08x", m)
+		}
+	}
+}
+
+func (z *Reader) startFrame(legacy bool) {
+	z.inFrame = true
+	z.legacy = legacy
+	z.hist = z.hist[:0]
+	z.out = 0
+	z.produced = 0
+	z.hash.reset()
+}
+
+func (z *Reader) readFrameHeader() error {
+	var desc [2]byte
+	if _, err := io.ReadFull(z.r, desc[:]); err != nil {
+		return fmt.Errorf("lz4: truncated frame header")
+	}
+	flg, bd := desc[0], desc[1]
+	if flg>>6 != 1 {
+		return fmt.Errorf("lz4: unsupported frame version %d", flg>>6)
+	}
+	if flg&0x02 != 0 || bd&0x8F != 0 {
+		return fmt.Errorf("lz4: reserved frame descriptor bits set")
+	}
+	blockMax, ok := blockSizes[(bd>>4)&7]
+	if !ok {
+		return fmt.Errorf("lz4: invalid block maximum size")
+	}
+
+	extra := 0
+	if flg&0x08 != 0 {
+		extra += 8
+	}
+	if flg&0x01 != 0 {
+		extra += 4
+	}
+	rest := make([]byte, extra+1)
+	if _, err := io.ReadFull(z.r, rest); err != nil {
+		return fmt.Errorf("lz4: truncated frame header")
+	}
+	descriptor := append(desc[:], rest[:extra]...)
+	if byte(checksum32(descriptor)>>8) != rest[extra] {
+		return fmt.Errorf("lz4: frame header checksum mismatch")
+	}
+	if flg&0x01 != 0 {
+		return fmt.Errorf("lz4: frames compressed with a dictionary are not supported")
+	}
+
+	z.startFrame(false)
+	z.independent = flg&0x20 != 0
+	z.blockChecksum = flg&0x10 != 0
+	z.contentSum = flg&0x04 != 0
+	z.hasSize = flg&0x08 != 0
+	if z.hasSize {
+		z.contentSize = int64(binary.LittleEndian.Uint64(rest))
+	}
+	z.blockMax = blockMax
+	return nil
+}
+
+// readBlock decodes the next block of the current frame.
+func (z *Reader) readBlock() error {
+	var h [4]byte
+	n, err := io.ReadFull(z.r, h[:])
+	if z.legacy && (err == io.EOF || (err == nil && binary.LittleEndian.Uint32(h[:]) == legacyMagic)) {
+		// Legacy frames have no end mark: they stop at EOF or the next frame
+		z.inFrame = false
+		if err == nil {
+			z.startFrame(true)
+			z.independent = true
+		}
+		return nil
+	}
+	if err != nil || n != 4 {
+		return fmt.Errorf("lz4: truncated block header")
+	}
+	v := binary.LittleEndian.Uint32(h[:])
+	if z.legacy {
+		return z.decodeBlock(int(v), false)
+	}
+
+	if v == 0 {
+		z.inFrame = false
+		if z.hasSize && z.produced != z.contentSize {
+			return fmt.Errorf("lz4: content size mismatch")
+		}
+		if z.contentSum {
+			var sum [4]byte
+			if _, err := io.ReadFull(z.r, sum[:]); err != nil {
+				return fmt.Errorf("lz4: truncated content checksum")
+			}
+			if binary.LittleEndian.Uint32(sum[:]) != z.hash.Sum32() {
+				return fmt.Errorf("lz4: content checksum mismatch")
+			}
+		}
+		return nil
+	}
+	return z.decodeBlock(int(v&0x7FFFFFFF), v&0x80000000 != 0)
+}
+
+func (z *Reader) decodeBlock(size int, stored bool) error {
+	if size > z.blockMax+16 && !z.legacy || size > legacyMaxBlock*2 {
+		return errCorrupt
+	}
+	if cap(z.block) < size {
+		z.block = make([]byte, size)
+	}
+	z.block = z.block[:size]
+	if _, err := io.ReadFull(z.r, z.block); err != nil {
+		return fmt.Errorf("lz4: truncated block")
+	}
+	if z.blockChecksum {
+		var sum [4]byte
+		if _, err := io.ReadFull(z.r, sum[:]); err != nil {
+			return fmt.Errorf("lz4: truncated block checksum")
+		}
+		if binary.LittleEndian.Uint32(sum[:]) != checksum32(z.block) {
+			return fmt.Errorf("lz4: block checksum mismatch")
+		}
+	}
+
+	// Keep only the history a dependent block may reference
+	if z.independent {
+		z.hist = z.hist[:0]
+	} else if len(z.hist) > historySize {
+		z.hist = append(z.hist[:0], z.hist[len(z.hist)-historySize:]...)
+	}
+	start := len(z.hist)
+	if stored {
+		if size > z.blockMax {
+			return errCorrupt
+		}
+		z.hist = append(z.hist, z.block...)
+	} else {
+		hist, err := decodeBlock(z.hist, z.block, z.blockMax)
+		if err != nil {
+			return err
+		}
+		z.hist = hist
+	}
+	z.out = start
+	z.produced += int64(len(z.hist) - start)
+	z.hash.Write(z.hist[start:])
+	return nil
+}
+
+// Read decompresses into p.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.out == len(z.hist) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		if z.inFrame {
+			z.err = z.readBlock()
+		} else {
+			z.err = z.nextFrame(false)
+		}
+	}
+	n := copy(p, z.hist[z.out:])
+	z.out += n
+	return n, nil
+}
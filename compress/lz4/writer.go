@@ -0,0 +1,183 @@
+package lz4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Levels range from 1 (fastest) to 12; levels from 2 up search hash chains.
+const (
+	BestSpeed          = 1
+	BestCompression    = 12
+	DefaultCompression = -1
+)
+
+// Block maximum sizes accepted by WriterOptions.BlockSize.
+const (
+	Block64KB  = 64 << 10
+	Block256KB = 256 << 10
+	Block1MB   = 1 << 20
+	Block4MB   = 4 << 20
+)
+
+// WriterOptions configures a Writer. The zero value selects the defaults of
+// the lz4 tool: level 1, 4 MiB independent blocks and a content checksum.
+type WriterOptions struct {
+	Level int
+	// BlockSize is one of the Block* sizes, or 0 for 4 MiB.
+	BlockSize int
+	// BlockChecksum adds a checksum after every block.
+	BlockChecksum bool
+	// NoContentChecksum omits the checksum of the whole content.
+	NoContentChecksum bool
+	// DependentBlocks lets blocks reference the previous 64 KiB of data,
+	// improving compression of small blocks at the cost of random access.
+	DependentBlocks bool
+}
+
+// Writer compresses data into a single LZ4 frame.
+type Writer struct {
+	w          io.Writer
+	opts       WriterOptions
+	c          *compressor
+	hash       *xxhash32
+	buf        []byte // history followed by the pending block
+	histLen    int
+	headerDone bool
+	closed     bool
+	err        error
+}
+
+// NewWriter returns a Writer with the default options.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterOptions(w, WriterOptions{})
+	return z
+}
+
+// NewWriterOptions returns a Writer with explicit settings.
+func NewWriterOptions(w io.Writer, opts WriterOptions) (*Writer, error) {
+	if opts.Level == 0 || opts.Level == DefaultCompression {
+		opts.Level = BestSpeed
+	}
+	if opts.Level < BestSpeed || opts.Level > BestCompression {
+		return nil, fmt.Errorf("lz4: invalid compression level %d (expected %d-%d)", opts.Level, BestSpeed, BestCompression)
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = Block4MB
+	}
+	if blockSizeCode(opts.BlockSize) == 0 {
+		return nil, fmt.Errorf("lz4: invalid block size %d (expected 64K, 256K, 1M or 4M)", opts.BlockSize)
+	}
+	return &Writer{w: w, opts: opts, c: newCompressor(opts.Level), hash: newXXHash32()}, nil
+}
+
+func blockSizeCode(size int) byte {
+	for code, s := range blockSizes {
+		if s == size {
+			return code
+		}
+	}
+	return 0
+}
+
+func (z *Writer) writeHeader() error {
+	flg := byte(0x40)
+	if !z.opts.DependentBlocks {
+		flg |= 0x20
+	}
+	if z.opts.BlockChecksum {
+		flg |= 0x10
+	}
+	if !z.opts.NoContentChecksum {
+		flg |= 0x04
+	}
+	desc := []byte{flg, blockSizeCode(z.opts.BlockSize) << 4}
+	hdr := binary.LittleEndian.AppendUint32(nil, frameMagic)
+	hdr = append(hdr, desc...)
+	hdr = append(hdr, byte(checksum32(desc)>>8))
+	z.headerDone = true
+	_, err := z.w.Write(hdr)
+	return err
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, fmt.Errorf("lz4: write to closed writer")
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	z.hash.Write(p)
+	total := len(p)
+	for len(p) > 0 {
+		n := min(len(p), z.opts.BlockSize-(len(z.buf)-z.histLen))
+		z.buf = append(z.buf, p[:n]...)
+		p = p[n:]
+		if len(z.buf)-z.histLen == z.opts.BlockSize {
+			if z.err = z.flushBlock(); z.err != nil {
+				return total - len(p), z.err
+			}
+		}
+	}
+	return total, nil
+}
+
+// flushBlock compresses the pending data, storing it when that is smaller.
+func (z *Writer) flushBlock() error {
+	if !z.headerDone {
+		if err := z.writeHeader(); err != nil {
+			return err
+		}
+	}
+	data := z.buf[z.histLen:]
+	if len(data) == 0 {
+		return nil
+	}
+	compressed := z.c.compress(make([]byte, 4, 4+len(data)), z.buf, z.histLen)
+	payload := compressed[4:]
+	size := uint32(len(payload))
+	if len(payload) >= len(data) {
+		payload = data
+		size = uint32(len(data)) | 0x80000000
+	}
+	out := binary.LittleEndian.AppendUint32(nil, size)
+	out = append(out, payload...)
+	if z.opts.BlockChecksum {
+		out = binary.LittleEndian.AppendUint32(out, checksum32(payload))
+	}
+	if _, err := z.w.Write(out); err != nil {
+		return err
+	}
+
+	if z.opts.DependentBlocks {
+		keep := min(len(z.buf), historySize)
+		z.buf = append(z.buf[:0], z.buf[len(z.buf)-keep:]...)
+		z.histLen = keep
+	} else {
+		z.buf = z.buf[:0]
+	}
+	return nil
+}
+
+// Close flushes pending data and writes the end mark and content checksum.
+// It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	if z.err = z.flushBlock(); z.err != nil {
+		return z.err
+	}
+	tail := binary.LittleEndian.AppendUint32(nil, 0)
+	if !z.opts.NoContentChecksum {
+		tail = binary.LittleEndian.AppendUint32(tail, z.hash.Sum32())
+	}
+	_, z.err = z.w.Write(tail)
+	return z.err
+}
@@ -0,0 +1,106 @@
+package lz4
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxHash32 protects frame descriptors, blocks and content.
+const (
+	prime32v1 uint32 = 2654435761
+	prime32v2 uint32 = 2246822519
+	prime32v3 uint32 = 3266489917
+	prime32v4 uint32 = 668265263
+	prime32v5 uint32 = 374761393
+)
+
+type xxhash32 struct {
+	v1, v2, v3, v4 uint32
+	total          uint64
+	mem            [16]byte
+	n              int
+}
+
+func newXXHash32() *xxhash32 {
+	h := &xxhash32{}
+	h.reset()
+	return h
+}
+
+func (h *xxhash32) reset() {
+	h.v1 = prime32v1
+	h.v1 += prime32v2
+	h.v2 = prime32v2
+	h.v3 = 0
+	h.v4 = 0
+	h.v4 -= prime32v1
+	h.total = 0
+	h.n = 0
+}
+
+func xxRound32(acc, input uint32) uint32 {
+	acc += input * prime32v2
+	return bits.RotateLeft32(acc, 13) * prime32v1
+}
+
+func (h *xxhash32) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n+len(p) < 16 {
+		h.n += copy(h.mem[h.n:], p)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], p)
+		p = p[c:]
+		h.v1 = xxRound32(h.v1, binary.LittleEndian.Uint32(h.mem[0:]))
+		h.v2 = xxRound32(h.v2, binary.LittleEndian.Uint32(h.mem[4:]))
+		h.v3 = xxRound32(h.v3, binary.LittleEndian.Uint32(h.mem[8:]))
+		h.v4 = xxRound32(h.v4, binary.LittleEndian.Uint32(h.mem[12:]))
+		h.n = 0
+	}
+	for len(p) >= 16 {
+		h.v1 = xxRound32(h.v1, binary.LittleEndian.Uint32(p[0:]))
+		h.v2 = xxRound32(h.v2, binary.LittleEndian.Uint32(p[4:]))
+		h.v3 = xxRound32(h.v3, binary.LittleEndian.Uint32(p[8:]))
+		h.v4 = xxRound32(h.v4, binary.LittleEndian.Uint32(p[12:]))
+		p = p[16:]
+	}
+	h.n = copy(h.mem[:], p)
+	return n, nil
+}
+
+func (h *xxhash32) Sum32() uint32 {
+	var acc uint32
+	if h.total >= 16 {
+		acc = bits.RotateLeft32(h.v1, 1) + bits.RotateLeft32(h.v2, 7) + bits.RotateLeft32(h.v3, 12) + bits.RotateLeft32(h.v4, 18)
+	} else {
+		acc = prime32v5
+	}
+	acc += uint32(h.total)
+
+	p := h.mem[:h.n]
+	for len(p) >= 4 {
+		acc += binary.LittleEndian.Uint32(p) * prime32v3
+		acc = bits.RotateLeft32(acc, 17) * prime32v4
+		p = p[4:]
+	}
+	for _, b := range p {
+		acc += uint32(b) * prime32v5
+		acc = bits.RotateLeft32(acc, 11) * prime32v1
+	}
+
+	acc ^= acc >> 15
+	acc *= prime32v2
+	acc ^= acc >> 13
+	acc *= prime32v3
+	acc ^= acc >> 16
+	return acc
+}
+
+// checksum32 hashes a single buffer.
+func checksum32(b []byte) uint32 {
+	h := newXXHash32()
+	h.Write(b)
+	return h.Sum32()
+}
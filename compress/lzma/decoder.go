@@ -0,0 +1,321 @@
+package lzma
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// window is the decoder's dictionary: a circular buffer of recent output. Bytes
+// written but not yet handed to the caller are "pending" and must not be overwritten.
+type window struct {
+	buf     []byte
+	pos     int
+	full    bool
+	total   int64
+	pending int
+}
+
+func newWindow(size int) *window {
+	return &window{buf: make([]byte, size)}
+}
+
+func (w *window) reset() {
+	w.pos = 0
+	w.full = false
+	w.pending = 0
+}
+
+// available is how many bytes may be written before unread data would be overwritten.
+func (w *window) available() int {
+	return len(w.buf) - w.pending
+}
+
+func (w *window) putByte(b byte) {
+	w.buf[w.pos] = b
+	w.pos++
+	if w.pos == len(w.buf) {
+		w.pos = 0
+		w.full = true
+	}
+	w.total++
+	w.pending++
+}
+
+// getByte returns the byte dist positions back (1 is the most recent byte).
+func (w *window) getByte(dist uint32) byte {
+	i := w.pos - int(dist)
+	if i < 0 {
+		i += len(w.buf)
+	}
+	return w.buf[i]
+}
+
+func (w *window) hasDistance(dist uint32) bool {
+	return int64(dist) <= int64(w.pos) || (w.full && int(dist) <= len(w.buf))
+}
+
+func (w *window) isEmpty() bool {
+	return w.pos == 0 && !w.full
+}
+
+// copyMatch repeats n bytes starting dist bytes back.
+func (w *window) copyMatch(dist uint32, n int) {
+	src := w.pos - int(dist)
+	if src < 0 {
+		src += len(w.buf)
+	}
+	for ; n > 0; n-- {
+		w.buf[w.pos] = w.buf[src]
+		w.pos++
+		src++
+		if w.pos == len(w.buf) {
+			w.pos = 0
+			w.full = true
+		}
+		if src == len(w.buf) {
+			src = 0
+		}
+		w.total++
+		w.pending++
+	}
+}
+
+// write copies uncompressed data into the window.
+func (w *window) write(p []byte) {
+	for _, b := range p {
+		w.putByte(b)
+	}
+}
+
+// read moves pending bytes into p.
+func (w *window) read(p []byte) int {
+	n := 0
+	for n < len(p) && w.pending > 0 {
+		start := w.pos - w.pending
+		if start < 0 {
+			start += len(w.buf)
+		}
+		end := start + w.pending
+		if end > len(w.buf) {
+			end = len(w.buf)
+		}
+		c := copy(p[n:], w.buf[start:end])
+		n += c
+		w.pending -= c
+	}
+	return n
+}
+
+// errEndMarker signals that the stream contained an explicit end-of-payload marker.
+var errEndMarker = errors.New("lzma: end marker")
+
+// decoder decodes LZMA symbols into a window.
+type decoder struct {
+	model
+	rc  rangeDecoder
+	win *window
+
+	// remLen bytes of a match are still to be copied at distance rep[0]+1.
+	remLen int
+	// unpacked is the number of bytes still expected, or -1 if unknown.
+	unpacked int64
+}
+
+// decode produces up to limit bytes into the window. It returns errEndMarker when
+// an end marker is found and io.EOF when the expected size has been reached.
+func (d *decoder) decode(limit int) error {
+	n := 0
+	for n < limit {
+		if d.remLen > 0 {
+			c := min(d.remLen, limit-n)
+			if d.unpacked >= 0 && int64(c) > d.unpacked {
+				return errCorrupt
+			}
+			d.win.copyMatch(d.rep[0]+1, c)
+			d.remLen -= c
+			n += c
+			if d.unpacked >= 0 {
+				d.unpacked -= int64(c)
+			}
+			continue
+		}
+		if d.unpacked == 0 {
+			return io.EOF
+		}
+		if d.rc.err != nil {
+			return d.rc.err
+		}
+
+		posState := d.posState(d.win.total)
+		if d.rc.decodeBit(&d.isMatch[d.state<<numPosBitsMax+posState]) == 0 {
+			d.decodeLiteral()
+			n++
+			if d.unpacked > 0 {
+				d.unpacked--
+			}
+			continue
+		}
+
+		var l uint32
+		if d.rc.decodeBit(&d.isRep[d.state]) != 0 {
+			if d.win.isEmpty() {
+				return errCorrupt
+			}
+			if d.rc.decodeBit(&d.isRepG0[d.state]) == 0 {
+				if d.rc.decodeBit(&d.isRep0Long[d.state<<numPosBitsMax+posState]) == 0 {
+					d.updateShortRep()
+					d.win.putByte(d.win.getByte(d.rep[0] + 1))
+					n++
+					if d.unpacked > 0 {
+						d.unpacked--
+					}
+					continue
+				}
+			} else {
+				var dist uint32
+				if d.rc.decodeBit(&d.isRepG1[d.state]) == 0 {
+					dist = d.rep[1]
+				} else {
+					if d.rc.decodeBit(&d.isRepG2[d.state]) == 0 {
+						dist = d.rep[2]
+					} else {
+						dist = d.rep[3]
+						d.rep[3] = d.rep[2]
+					}
+					d.rep[2] = d.rep[1]
+				}
+				d.rep[1] = d.rep[0]
+				d.rep[0] = dist
+			}
+			l = d.repLen.decode(&d.rc, posState)
+			d.updateRep()
+		} else {
+			d.rep[3], d.rep[2], d.rep[1] = d.rep[2], d.rep[1], d.rep[0]
+			l = d.matchLen.decode(&d.rc, posState)
+			d.updateMatch()
+			d.rep[0] = d.decodeDistance(l)
+			if d.rep[0] == 0xffffffff {
+				if d.rc.err == nil && d.rc.finishedOK() {
+					return errEndMarker
+				}
+				return errCorrupt
+			}
+			if !d.win.hasDistance(d.rep[0] + 1) {
+				return errCorrupt
+			}
+		}
+		d.remLen = int(l + matchMinLen)
+	}
+	return d.rc.err
+}
+
+func (d *decoder) decodeLiteral() {
+	var prev byte
+	if !d.win.isEmpty() {
+		prev = d.win.getByte(1)
+	}
+	probs := d.literalProbs(d.win.total, prev)
+
+	symbol := uint32(1)
+	if d.state >= 7 {
+		matchByte := uint32(d.win.getByte(d.rep[0] + 1))
+		for symbol < 0x100 {
+			matchBit := (matchByte >> 7) & 1
+			matchByte <<= 1
+			bit := d.rc.decodeBit(&probs[0x100+matchBit<<8+symbol])
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		symbol = symbol<<1 | d.rc.decodeBit(&probs[symbol])
+	}
+	d.win.putByte(byte(symbol))
+	d.updateLiteral()
+}
+
+func (d *decoder) decodeDistance(l uint32) uint32 {
+	posSlot := d.rc.decodeTree(d.posSlot[lenToPosState(l)][:], numPosSlotBits)
+	if posSlot < startPosModelIndex {
+		return posSlot
+	}
+	numDirectBits := uint(posSlot>>1) - 1
+	dist := (2 | posSlot&1) << numDirectBits
+	if posSlot < endPosModelIndex {
+		return dist + d.rc.decodeReverseTree(d.posSpecial[dist-posSlot:], numDirectBits)
+	}
+	dist += d.rc.decodeDirectBits(numDirectBits-numAlignBits) << numAlignBits
+	return dist + d.rc.decodeReverseTree(d.align[:], numAlignBits)
+}
+
+// Reader decompresses a raw LZMA stream, as stored in 7z archives.
+type Reader struct {
+	dec decoder
+	err error
+}
+
+// NewReader returns a Reader for a raw LZMA stream with the given properties and
+// dictionary size. If size is negative the stream must end with an end marker.
+func NewReader(r io.Reader, props Properties, dictSize uint32, size int64) (*Reader, error) {
+	if props.LC > 8 || props.LP > 4 || props.PB > 4 {
+		return nil, fmt.Errorf("lzma: invalid properties %+v", props)
+	}
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	// There is no point holding more history than the whole output
+	winSize := int64(max(dictSize, 4096))
+	if size >= 0 && size < winSize {
+		winSize = max(size, 1)
+	}
+
+	z := &Reader{}
+	z.dec.win = newWindow(int(winSize))
+	z.dec.unpacked = size
+	z.dec.reset(props)
+	if err := z.dec.rc.init(br); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// NewReaderFromHeader returns a Reader for the 5-byte header form used by 7z:
+// one properties byte followed by a little-endian dictionary size.
+func NewReaderFromHeader(r io.Reader, header []byte, size int64) (*Reader, error) {
+	if len(header) < 5 {
+		return nil, fmt.Errorf("lzma: properties header too short")
+	}
+	props, err := DecodeProperties(header[0])
+	if err != nil {
+		return nil, err
+	}
+	dictSize := uint32(header[1]) | uint32(header[2])<<8 | uint32(header[3])<<16 | uint32(header[4])<<24
+	return NewReader(r, props, dictSize, size)
+}
+
+// Read decompresses into p.
+func (z *Reader) Read(p []byte) (int, error) {
+	for {
+		if n := z.dec.win.read(p); n > 0 || len(p) == 0 {
+			return n, nil
+		}
+		if z.err != nil {
+			return 0, z.err
+		}
+		err := z.dec.decode(min(len(p), z.dec.win.available()))
+		if err == errEndMarker {
+			// The end marker is only valid once any declared size has been produced
+			err = io.EOF
+			if z.dec.unpacked > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		z.err = err
+	}
+}
@@ -0,0 +1,113 @@
+package lzma
+
+// encoder writes LZMA symbols through the range coder.
+type encoder struct {
+	model
+	rc rangeEncoder
+}
+
+func (e *encoder) encodeLiteral(pos int64, cur, prev, matchByte byte) {
+	posState := e.posState(pos)
+	e.rc.encodeBit(&e.isMatch[e.state<<numPosBitsMax+posState], 0)
+	probs := e.literalProbs(pos, prev)
+
+	symbol := uint32(1)
+	i := 8
+	if e.state >= 7 {
+		// Matched literal: code against the byte at the last match distance while they agree
+		mb := uint32(matchByte)
+		for i > 0 {
+			i--
+			matchBit := (mb >> 7) & 1
+			mb <<= 1
+			bit := uint32(cur>>uint(i)) & 1
+			e.rc.encodeBit(&probs[0x100+matchBit<<8+symbol], bit)
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for i > 0 {
+		i--
+		bit := uint32(cur>>uint(i)) & 1
+		e.rc.encodeBit(&probs[symbol], bit)
+		symbol = symbol<<1 | bit
+	}
+	e.updateLiteral()
+}
+
+// encodeMatch writes a match of length l at zero-based distance dist.
+func (e *encoder) encodeMatch(pos int64, dist uint32, l int) {
+	posState := e.posState(pos)
+	e.rc.encodeBit(&e.isMatch[e.state<<numPosBitsMax+posState], 1)
+	e.rc.encodeBit(&e.isRep[e.state], 0)
+	lenCode := uint32(l - matchMinLen)
+	e.matchLen.encode(&e.rc, lenCode, posState)
+	e.updateMatch()
+	e.encodeDistance(dist, lenCode)
+	e.rep[3], e.rep[2], e.rep[1], e.rep[0] = e.rep[2], e.rep[1], e.rep[0], dist
+}
+
+func (e *encoder) encodeDistance(dist, lenCode uint32) {
+	lenState := lenToPosState(lenCode)
+	if dist < startPosModelIndex {
+		e.rc.encodeTree(e.posSlot[lenState][:], numPosSlotBits, dist)
+		return
+	}
+
+	n := uint32(31)
+	for dist>>n == 0 {
+		n--
+	}
+	posSlot := n<<1 | (dist>>(n-1))&1
+	e.rc.encodeTree(e.posSlot[lenState][:], numPosSlotBits, posSlot)
+
+	footerBits := uint(posSlot>>1) - 1
+	base := (2 | posSlot&1) << footerBits
+	reduced := dist - base
+	if posSlot < endPosModelIndex {
+		e.rc.encodeReverseTree(e.posSpecial[base-posSlot:], footerBits, reduced)
+		return
+	}
+	e.rc.encodeDirectBits(reduced>>numAlignBits, footerBits-numAlignBits)
+	e.rc.encodeReverseTree(e.align[:], numAlignBits, reduced&(1<<numAlignBits-1))
+}
+
+// encodeRepMatch writes a match of length l reusing the repIndex-th recent distance.
+func (e *encoder) encodeRepMatch(pos int64, repIndex int, l int) {
+	posState := e.posState(pos)
+	e.rc.encodeBit(&e.isMatch[e.state<<numPosBitsMax+posState], 1)
+	e.rc.encodeBit(&e.isRep[e.state], 1)
+	if repIndex == 0 {
+		e.rc.encodeBit(&e.isRepG0[e.state], 0)
+		e.rc.encodeBit(&e.isRep0Long[e.state<<numPosBitsMax+posState], 1)
+	} else {
+		dist := e.rep[repIndex]
+		e.rc.encodeBit(&e.isRepG0[e.state], 1)
+		if repIndex == 1 {
+			e.rc.encodeBit(&e.isRepG1[e.state], 0)
+		} else {
+			e.rc.encodeBit(&e.isRepG1[e.state], 1)
+			e.rc.encodeBit(&e.isRepG2[e.state], uint32(repIndex-2))
+			if repIndex == 3 {
+				e.rep[3] = e.rep[2]
+			}
+			e.rep[2] = e.rep[1]
+		}
+		e.rep[1] = e.rep[0]
+		e.rep[0] = dist
+	}
+	e.repLen.encode(&e.rc, uint32(l-matchMinLen), posState)
+	e.updateRep()
+}
+
+// encodeShortRep writes a single byte copied from the last match distance.
+func (e *encoder) encodeShortRep(pos int64) {
+	posState := e.posState(pos)
+	e.rc.encodeBit(&e.isMatch[e.state<<numPosBitsMax+posState], 1)
+	e.rc.encodeBit(&e.isRep[e.state], 1)
+	e.rc.encodeBit(&e.isRepG0[e.state], 0)
+	e.rc.encodeBit(&e.isRep0Long[e.state<<numPosBitsMax+posState], 0)
+	e.updateShortRep()
+}
@@ -0,0 +1,219 @@
+package lzma
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DictSizeFromByte decodes the one-byte LZMA2 dictionary size property.
+func DictSizeFromByte(b byte) (uint32, error) {
+	if b > 40 {
+		return 0, fmt.Errorf("lzma2: invalid dictionary size property %d", b)
+	}
+	if b == 40 {
+		return 0xffffffff, nil
+	}
+	return (2 | uint32(b)&1) << (b/2 + 11), nil
+}
+
+// DictSizeByte returns the smallest LZMA2 dictionary size property covering size.
+func DictSizeByte(size uint32) byte {
+	for b := byte(0); b < 40; b++ {
+		if s, _ := DictSizeFromByte(b); s >= size {
+			return b
+		}
+	}
+	return 40
+}
+
+// maxWindow caps the decoder's buffer for streams that declare huge dictionaries.
+const maxWindow = 1 << 30
+
+// Reader2 decompresses an LZMA2 stream: a sequence of LZMA and stored chunks.
+type Reader2 struct {
+	br  io.ByteReader
+	dec decoder
+
+	chunk      limitedByteReader
+	inChunk    bool
+	compressed bool
+	needProps  bool
+	needDict   bool
+	err        error
+}
+
+// limitedByteReader counts down the packed bytes of the current chunk.
+type limitedByteReader struct {
+	br io.ByteReader
+	n  int64
+}
+
+func (l *limitedByteReader) ReadByte() (byte, error) {
+	if l.n <= 0 {
+		return 0, io.EOF
+	}
+	b, err := l.br.ReadByte()
+	if err == nil {
+		l.n--
+	}
+	return b, err
+}
+
+var errLZMA2Corrupt = errors.New("lzma2: corrupt compressed data")
+
+// NewReader2 returns a Reader2 using the given dictionary size.
+func NewReader2(r io.Reader, dictSize uint32) *Reader2 {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	winSize := max(min(int64(dictSize), maxWindow), 4096)
+	z := &Reader2{br: br, needProps: true, needDict: true}
+	z.dec.win = newWindow(int(winSize))
+	return z
+}
+
+// Read decompresses into p.
+func (z *Reader2) Read(p []byte) (int, error) {
+	for {
+		if n := z.dec.win.read(p); n > 0 || len(p) == 0 {
+			return n, nil
+		}
+		if z.err != nil {
+			return 0, z.err
+		}
+		if !z.inChunk {
+			z.err = z.startChunk()
+			continue
+		}
+
+		limit := min(len(p), z.dec.win.available())
+		if !z.compressed {
+			// Stored chunk: copy straight into the dictionary
+			n := int(min(int64(limit), z.dec.unpacked))
+			buf := make([]byte, n)
+			for i := range buf {
+				b, err := z.chunk.ReadByte()
+				if err != nil {
+					z.err = io.ErrUnexpectedEOF
+					break
+				}
+				buf[i] = b
+			}
+			z.dec.win.write(buf)
+			z.dec.unpacked -= int64(n)
+			if z.dec.unpacked == 0 {
+				z.inChunk = false
+			}
+			continue
+		}
+
+		err := z.dec.decode(limit)
+		switch {
+		case err == io.EOF:
+			// Chunk complete: the range coder must have consumed exactly its packed size
+			if z.dec.remLen > 0 || z.chunk.n != 0 || !z.dec.rc.finishedOK() {
+				z.err = errLZMA2Corrupt
+			}
+			z.inChunk = false
+		case err != nil:
+			if err == errEndMarker {
+				err = errLZMA2Corrupt
+			}
+			z.err = err
+		}
+	}
+}
+
+// startChunk parses the next chunk header.
+func (z *Reader2) startChunk() error {
+	control, err := z.br.ReadByte()
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if control == 0x00 {
+		return io.EOF
+	}
+
+	if control == 0x01 || control == 0x02 {
+		if control == 0x01 {
+			z.dec.win.reset()
+			z.needDict = false
+		} else if z.needDict {
+			return errLZMA2Corrupt
+		}
+		size, err := z.readUint16()
+		if err != nil {
+			return err
+		}
+		z.chunk = limitedByteReader{br: z.br, n: int64(size) + 1}
+		z.dec.unpacked = int64(size) + 1
+		z.compressed = false
+		z.inChunk = true
+		return nil
+	}
+	if control < 0x80 {
+		return errLZMA2Corrupt
+	}
+
+	low, err := z.readUint16()
+	if err != nil {
+		return err
+	}
+	unpacked := int64(control&0x1f)<<16 + int64(low) + 1
+	packed, err := z.readUint16()
+	if err != nil {
+		return err
+	}
+
+	reset := (control >> 5) & 3
+	if reset == 3 {
+		z.dec.win.reset()
+		z.needDict = false
+	} else if z.needDict {
+		return errLZMA2Corrupt
+	}
+	if reset >= 2 {
+		b, err := z.br.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		props, err := DecodeProperties(b)
+		if err != nil {
+			return err
+		}
+		if props.LC+props.LP > 4 {
+			return fmt.Errorf("lzma2: lc + lp exceeds 4")
+		}
+		z.dec.reset(props)
+		z.needProps = false
+	} else if z.needProps {
+		return errLZMA2Corrupt
+	} else if reset == 1 {
+		z.dec.reset(z.dec.props)
+	}
+
+	z.chunk = limitedByteReader{br: z.br, n: int64(packed) + 1}
+	if err := z.dec.rc.init(&z.chunk); err != nil {
+		return errLZMA2Corrupt
+	}
+	z.dec.unpacked = unpacked
+	z.dec.remLen = 0
+	z.compressed = true
+	z.inChunk = true
+	return nil
+}
+
+func (z *Reader2) readUint16() (uint16, error) {
+	hi, err := z.br.ReadByte()
+	if err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	lo, err := z.br.ReadByte()
+	if err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return uint16(hi)<<8 | uint16(lo), nil
+}
@@ -0,0 +1,179 @@
+package lzma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testInput struct {
+	name string
+	data []byte
+}
+
+// testInputs returns data that exercises literals, short and long matches,
+// and the stored chunks of LZMA2.
+func testInputs() []testInput {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 200<<10)
+	rng.Read(random)
+
+	var all []byte
+	for i := 0; i < 64; i++ {
+		for b := 0; b < 256; b++ {
+			all = append(all, byte(b))
+		}
+	}
+	var text bytes.Buffer
+	for i := 1; text.Len() < 400<<10; i++ {
+		fmt.Fprintf(&text, "line %d of the text, which repeats with small changes\n", i)
+	}
+
+	return []testInput{
+		{"empty", nil},
+		{"one byte", []byte{'x'}},
+		// More than one LZMA2 chunk of uncompressed data
+		{"long run", bytes.Repeat([]byte{'a'}, 3<<20)},
+		{"all byte values", all},
+		{"incompressible", random},
+		{"text", text.Bytes()},
+	}
+}
+
+// seq returns the output of "seq 1 n".
+func seq(n int) []byte {
+	var b bytes.Buffer
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.Bytes()
+}
+
+func TestRoundTrip2(t *testing.T) {
+	configs := map[string]func() WriterConfig{
+		"preset 0": func() WriterConfig { cfg, _ := PresetConfig(0); return cfg },
+		"preset 6": func() WriterConfig { cfg, _ := PresetConfig(DefaultPreset); return cfg },
+		"lc0 lp2 pb0": func() WriterConfig {
+			cfg, _ := PresetConfig(1)
+			cfg.Props = Properties{LC: 0, LP: 2, PB: 0}
+			return cfg
+		},
+	}
+	for _, in := range testInputs() {
+		for name, config := range configs {
+			t.Run(in.name+"/"+name, func(t *testing.T) {
+				cfg := config()
+				var buf bytes.Buffer
+				w, err := NewWriter2(&buf, cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(in.data); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := io.ReadAll(NewReader2(&buf, cfg.DictSize))
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				if !bytes.Equal(got, in.data) {
+					t.Fatalf("round trip of %d bytes returned %d different bytes", len(in.data), len(got))
+				}
+			})
+		}
+	}
+}
+
+func TestInvalidProperties(t *testing.T) {
+	cfg, _ := PresetConfig(DefaultPreset)
+	cfg.Props = Properties{LC: 4, LP: 1}
+	if _, err := NewWriter2(io.Discard, cfg); err == nil {
+		t.Error("lc+lp above 4 was accepted")
+	}
+	if _, err := PresetConfig(10); err == nil {
+		t.Error("preset 10 was accepted")
+	}
+}
+
+// The fixtures were made by xz 5.6:
+//
+//	seq 1 20000 | xz --format=lzma -6 > text.lzma
+//	seq 1 20000 | xz --format=lzma --lzma1=preset=1,lc=0,lp=2,pb=0 > lclppb.lzma
+//	seq 1 20000 | xz --format=raw --lzma2=preset=6,dict=1MiB > text.lzma2
+//	head -c 10000 /dev/urandom > random.bin
+//	xz --format=raw --lzma2=dict=1MiB -c random.bin > random.lzma2
+func TestDecodeLZMAFiles(t *testing.T) {
+	for _, name := range []string{"text.lzma", "lclppb.lzma"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A .lzma file starts with the 7z style header and the size,
+			// all ones when the stream ends with an end marker instead
+			size := int64(binary.LittleEndian.Uint64(data[5:13]))
+			r, err := NewReaderFromHeader(bytes.NewReader(data[13:]), data[:5], size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := seq(20000); !bytes.Equal(got, want) {
+				t.Fatalf("got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestDecodeLZMA2Files(t *testing.T) {
+	random, err := os.ReadFile(filepath.Join("testdata", "random.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"text.lzma2": seq(20000), "random.lzma2": random} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(NewReader2(bytes.NewReader(data), 1<<20))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+// TestCorruptInput checks that damaged streams fail with an error rather
+// than a panic or a hang.
+func TestCorruptInput(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "text.lzma2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(data); n += 97 {
+		if _, err := io.ReadAll(NewReader2(bytes.NewReader(data[:n]), 1<<20)); err == nil {
+			t.Errorf("stream truncated to %d bytes was accepted", n)
+		}
+	}
+	for i := 0; i < len(data); i += 61 {
+		damaged := bytes.Clone(data)
+		damaged[i] ^= 0x55
+		// Damage may go unnoticed in LZMA2, which has no checksum; it must
+		// just not crash
+		_, _ = io.ReadAll(NewReader2(bytes.NewReader(damaged), 1<<20))
+	}
+}
@@ -0,0 +1,150 @@
+package lzma
+
+const (
+	hashBits = 18
+	hashSize = 1 << hashBits
+)
+
+// matchFinder keeps a sliding window of input and a hash chain over 3-byte
+// prefixes. Positions are absolute offsets in the uncompressed stream.
+type matchFinder struct {
+	buf  []byte
+	base int64 // absolute position of buf[0]
+	pos  int64 // next position to encode
+	end  int64 // absolute position after the last buffered byte
+
+	head     []int64  // latest position+1 for each hash, 0 if none
+	chain    []uint32 // distance to the previous position with the same hash
+	mask     int64
+	window   int64
+	maxDist  int64
+	inserted int64
+
+	depth   int
+	niceLen int
+}
+
+func newMatchFinder(window uint32, depth, niceLen int) *matchFinder {
+	size := int64(1)
+	for size < int64(window) {
+		size <<= 1
+	}
+	return &matchFinder{
+		buf:     make([]byte, 0, size+1<<22),
+		head:    make([]int64, hashSize),
+		chain:   make([]uint32, size),
+		mask:    size - 1,
+		window:  size,
+		maxDist: int64(window),
+		depth:   depth,
+		niceLen: niceLen,
+	}
+}
+
+// append adds input, discarding history older than both the window and keep.
+func (mf *matchFinder) append(p []byte, keep int64) {
+	if len(mf.buf)+len(p) > cap(mf.buf) {
+		from := min(mf.pos-mf.window, keep)
+		if from > mf.base {
+			n := copy(mf.buf, mf.buf[from-mf.base:])
+			mf.buf = mf.buf[:n]
+			mf.base = from
+		}
+	}
+	mf.buf = append(mf.buf, p...)
+	mf.end += int64(len(p))
+}
+
+func (mf *matchFinder) at(pos int64) byte {
+	return mf.buf[pos-mf.base]
+}
+
+// bytes returns the buffered input in [from, to).
+func (mf *matchFinder) bytes(from, to int64) []byte {
+	return mf.buf[from-mf.base : to-mf.base]
+}
+
+func (mf *matchFinder) hash(pos int64) uint32 {
+	i := pos - mf.base
+	v := uint32(mf.buf[i]) | uint32(mf.buf[i+1])<<8 | uint32(mf.buf[i+2])<<16
+	return (v * 2654435761) >> (32 - hashBits)
+}
+
+// insertUpTo adds every position before limit to the hash chains.
+func (mf *matchFinder) insertUpTo(limit int64) {
+	for ; mf.inserted < limit; mf.inserted++ {
+		if mf.inserted+3 > mf.end {
+			continue
+		}
+		h := mf.hash(mf.inserted)
+		var d uint32
+		if prev := mf.head[h]; prev != 0 {
+			if delta := mf.inserted + 1 - prev; delta < mf.window {
+				d = uint32(delta)
+			}
+		}
+		mf.chain[mf.inserted&mf.mask] = d
+		mf.head[h] = mf.inserted + 1
+	}
+}
+
+// matchLen counts equal bytes at pos and pos-dist, up to limit.
+func (mf *matchFinder) matchLen(pos, dist int64, limit int) int {
+	a := mf.buf[pos-mf.base:]
+	b := mf.buf[pos-dist-mf.base:]
+	n := 0
+	for n < limit && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// match is a candidate match: its length and one-based distance.
+type match struct {
+	len  int
+	dist int64
+}
+
+// findMatches appends to out the matches at pos that are longer than every
+// closer one, so lengths and distances both increase. It also inserts pos
+// into the hash chains.
+func (mf *matchFinder) findMatches(pos int64, out []match) []match {
+	mf.insertUpTo(pos)
+	limit := int(min(mf.end-pos, matchMaxLen))
+	if limit < 3 {
+		return out
+	}
+
+	bestLen := 0
+	cand := mf.head[mf.hash(pos)] - 1
+	// Positions ahead of pos are already inserted when a plan is dropped
+	for cand >= pos {
+		d := mf.chain[cand&mf.mask]
+		if d == 0 {
+			return out
+		}
+		cand -= int64(d)
+	}
+	for depth := mf.depth; depth > 0 && cand >= 0; depth-- {
+		dist := pos - cand
+		if dist > mf.maxDist || dist >= mf.window || cand < mf.base {
+			break
+		}
+		if mf.at(cand+int64(bestLen)) == mf.at(pos+int64(bestLen)) || bestLen == 0 {
+			if l := mf.matchLen(pos, dist, limit); l > bestLen {
+				bestLen = l
+				out = append(out, match{l, dist})
+				if l >= mf.niceLen || l == limit {
+					break
+				}
+			}
+		}
+		d := mf.chain[cand&mf.mask]
+		if d == 0 {
+			break
+		}
+		cand -= int64(d)
+	}
+	mf.insertUpTo(pos + 1)
+	return out
+}
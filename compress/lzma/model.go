@@ -0,0 +1,195 @@
+// Package lzma implements the LZMA and LZMA2 compression formats used by xz, 7z
+// and SquashFS.
+package lzma
+
+import "fmt"
+
+const (
+	numStates          = 12
+	numPosBitsMax      = 4
+	numLenToPosStates  = 4
+	numAlignBits       = 4
+	startPosModelIndex = 4
+	endPosModelIndex   = 14
+	numFullDistances   = 1 << (endPosModelIndex >> 1)
+	numPosSlotBits     = 6
+	matchMinLen        = 2
+	matchMaxLen        = 273
+
+	numLowLenBits  = 3
+	numMidLenBits  = 3
+	numHighLenBits = 8
+	numLowLen      = 1 << numLowLenBits
+	numMidLen      = 1 << numMidLenBits
+)
+
+// Properties are the literal context bits, literal position bits and position bits.
+type Properties struct {
+	LC, LP, PB int
+}
+
+// DefaultProperties are the lc=3, lp=0, pb=2 defaults used by xz and 7-Zip.
+var DefaultProperties = Properties{LC: 3, LP: 0, PB: 2}
+
+// DecodeProperties unpacks the properties byte (pb * 5 + lp) * 9 + lc.
+func DecodeProperties(b byte) (Properties, error) {
+	if b >= 9*5*5 {
+		return Properties{}, fmt.Errorf("lzma: invalid properties byte %#x", b)
+	}
+	return Properties{LC: int(b % 9), LP: int(b / 9 % 5), PB: int(b / 45)}, nil
+}
+
+// Byte packs the properties into their single-byte form.
+func (p Properties) Byte() byte {
+	return byte((p.PB*5+p.LP)*9 + p.LC)
+}
+
+// lenModel holds the probabilities of the match length coder.
+type lenModel struct {
+	choice  prob
+	choice2 prob
+	low     [1 << numPosBitsMax][1 << numLowLenBits]prob
+	mid     [1 << numPosBitsMax][1 << numMidLenBits]prob
+	high    [1 << numHighLenBits]prob
+}
+
+func (m *lenModel) reset() {
+	m.choice = probInit
+	m.choice2 = probInit
+	for i := range m.low {
+		initProbs(m.low[i][:])
+		initProbs(m.mid[i][:])
+	}
+	initProbs(m.high[:])
+}
+
+func (m *lenModel) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.decodeBit(&m.choice) == 0 {
+		return rc.decodeTree(m.low[posState][:], numLowLenBits)
+	}
+	if rc.decodeBit(&m.choice2) == 0 {
+		return numLowLen + rc.decodeTree(m.mid[posState][:], numMidLenBits)
+	}
+	return numLowLen + numMidLen + rc.decodeTree(m.high[:], numHighLenBits)
+}
+
+func (m *lenModel) encode(rc *rangeEncoder, l, posState uint32) {
+	if l < numLowLen {
+		rc.encodeBit(&m.choice, 0)
+		rc.encodeTree(m.low[posState][:], numLowLenBits, l)
+		return
+	}
+	rc.encodeBit(&m.choice, 1)
+	l -= numLowLen
+	if l < numMidLen {
+		rc.encodeBit(&m.choice2, 0)
+		rc.encodeTree(m.mid[posState][:], numMidLenBits, l)
+		return
+	}
+	rc.encodeBit(&m.choice2, 1)
+	rc.encodeTree(m.high[:], numHighLenBits, l-numMidLen)
+}
+
+// model is the adaptive state shared by the encoder and decoder.
+type model struct {
+	props Properties
+
+	literal    []prob
+	isMatch    [numStates << numPosBitsMax]prob
+	isRep      [numStates]prob
+	isRepG0    [numStates]prob
+	isRepG1    [numStates]prob
+	isRepG2    [numStates]prob
+	isRep0Long [numStates << numPosBitsMax]prob
+	posSlot    [numLenToPosStates][1 << numPosSlotBits]prob
+	posSpecial [1 + numFullDistances - endPosModelIndex]prob
+	align      [1 << numAlignBits]prob
+	matchLen   lenModel
+	repLen     lenModel
+
+	state uint32
+	rep   [4]uint32
+}
+
+// reset sets the probabilities and coder state back to their initial values,
+// switching to new properties.
+func (m *model) reset(props Properties) {
+	m.props = props
+	n := 0x300 << uint(props.LC+props.LP)
+	if cap(m.literal) >= n {
+		m.literal = m.literal[:n]
+	} else {
+		m.literal = make([]prob, n)
+	}
+	initProbs(m.literal)
+	initProbs(m.isMatch[:])
+	initProbs(m.isRep[:])
+	initProbs(m.isRepG0[:])
+	initProbs(m.isRepG1[:])
+	initProbs(m.isRepG2[:])
+	initProbs(m.isRep0Long[:])
+	for i := range m.posSlot {
+		initProbs(m.posSlot[i][:])
+	}
+	initProbs(m.posSpecial[:])
+	initProbs(m.align[:])
+	m.matchLen.reset()
+	m.repLen.reset()
+	m.state = 0
+	m.rep = [4]uint32{}
+}
+
+// literalProbs returns the coder for a literal at position pos following prev.
+func (m *model) literalProbs(pos int64, prev byte) []prob {
+	lc, lp := uint(m.props.LC), uint(m.props.LP)
+	litState := (uint32(pos)&(1<<lp-1))<<lc + uint32(prev)>>(8-lc)
+	return m.literal[0x300*litState : 0x300*(litState+1)]
+}
+
+func (m *model) posState(pos int64) uint32 {
+	return uint32(pos) & (1<<uint(m.props.PB) - 1)
+}
+
+func (m *model) updateLiteral()  { m.state = stateAfterLiteral(m.state) }
+func (m *model) updateMatch()    { m.state = stateAfterMatch(m.state) }
+func (m *model) updateRep()      { m.state = stateAfterRep(m.state) }
+func (m *model) updateShortRep() { m.state = stateAfterShortRep(m.state) }
+
+func stateAfterLiteral(state uint32) uint32 {
+	switch {
+	case state < 4:
+		return 0
+	case state < 10:
+		return state - 3
+	default:
+		return state - 6
+	}
+}
+
+func stateAfterMatch(state uint32) uint32 {
+	if state < 7 {
+		return 7
+	}
+	return 10
+}
+
+func stateAfterRep(state uint32) uint32 {
+	if state < 7 {
+		return 8
+	}
+	return 11
+}
+
+func stateAfterShortRep(state uint32) uint32 {
+	if state < 7 {
+		return 9
+	}
+	return 11
+}
+
+func lenToPosState(l uint32) uint32 {
+	if l < numLenToPosStates {
+		return l
+	}
+	return numLenToPosStates - 1
+}
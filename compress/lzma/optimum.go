@@ -0,0 +1,381 @@
+package lzma
+
+import "math/bits"
+
+const (
+	// optWindow bounds how far ahead the parser plans before it commits.
+	optWindow = 1 << 11
+	// priceInterval is how many bytes are coded between updates of the
+	// length and distance price tables.
+	priceInterval = 1 << 10
+	// backLiteral marks a literal in step.back; 0-3 are repeated distances and
+	// larger values a zero-based distance plus numReps. A repeated distance of
+	// length 1 is a short rep.
+	backLiteral = -1
+	numReps     = 4
+)
+
+// step is one planned symbol.
+type step struct {
+	len  int
+	back int
+}
+
+// optNode is the cheapest known way to code the input up to one position of
+// the parse. It is reached from prev by one symbol, or by an optional match, a
+// literal and a match at the first repeated distance, which pays off where
+// the data repeats with single bytes changed.
+type optNode struct {
+	price uint32
+	prev  int
+	first step // the match before the literal, if len is not zero
+	lit   bool
+	last  step
+
+	// The coder state once the symbols are coded
+	state uint32
+	reps  [numReps]uint32
+}
+
+// optimum finds the cheapest sequence of symbols for the input ahead, pricing
+// each choice with the current probabilities like the LZMA SDK's normal mode.
+type optimum struct {
+	nodes []optNode
+	plan  []step
+	next  int
+
+	matches  []match
+	cached   []match
+	cachedAt int64
+
+	matchLenPrices [1 << numPosBitsMax][matchMaxLen + 1]uint32
+	repLenPrices   [1 << numPosBitsMax][matchMaxLen + 1]uint32
+	slotPrices     [numLenToPosStates][1 << numPosSlotBits]uint32
+	distPrices     [numLenToPosStates][numFullDistances]uint32
+	alignPrices    [1 << numAlignBits]uint32
+	pricesAt       int64
+}
+
+func newOptimum() *optimum {
+	return &optimum{
+		nodes:    make([]optNode, optWindow+matchMaxLen+1),
+		cachedAt: -1,
+	}
+}
+
+// clear drops the plan, which holds repeated distances the encoder no longer
+// has after a reset.
+func (o *optimum) clear() {
+	o.plan = o.plan[:0]
+	o.next = 0
+	o.cachedAt = -1
+}
+
+// updatePrices refreshes the price tables of match lengths and distances.
+func (o *optimum) updatePrices(e *encoder) {
+	e.matchLen.prices(&o.matchLenPrices, 1<<uint(e.props.PB))
+	e.repLen.prices(&o.repLenPrices, 1<<uint(e.props.PB))
+
+	var footers [numFullDistances]uint32
+	for dist := uint32(startPosModelIndex); dist < numFullDistances; dist++ {
+		slot := distSlot(dist)
+		footerBits := uint(slot>>1) - 1
+		base := (2 | slot&1) << footerBits
+		footers[dist] = reverseTreePrice(e.posSpecial[base-slot:], footerBits, dist-base)
+	}
+	for lenState := range o.slotPrices {
+		for slot := uint32(0); slot < 1<<numPosSlotBits; slot++ {
+			price := treePrice(e.posSlot[lenState][:], numPosSlotBits, slot)
+			if slot >= endPosModelIndex {
+				price += (slot>>1 - 1 - numAlignBits) << priceShiftBits
+			}
+			o.slotPrices[lenState][slot] = price
+		}
+		for dist := uint32(0); dist < numFullDistances; dist++ {
+			o.distPrices[lenState][dist] = o.slotPrices[lenState][distSlot(dist)] + footers[dist]
+		}
+	}
+	for i := range o.alignPrices {
+		o.alignPrices[i] = reverseTreePrice(e.align[:], numAlignBits, uint32(i))
+	}
+}
+
+// distSlot returns the slot of a zero-based distance: its two highest bits.
+func distSlot(dist uint32) uint32 {
+	if dist < startPosModelIndex {
+		return dist
+	}
+	n := uint32(bits.Len32(dist)) - 1
+	return n<<1 | (dist>>(n-1))&1
+}
+
+// distancePrice is the price of coding zero-based distance dist after a
+// match length in lenState, from the tables.
+func (o *optimum) distancePrice(dist, lenState uint32) uint32 {
+	if dist < numFullDistances {
+		return o.distPrices[lenState][dist]
+	}
+	return o.slotPrices[lenState][distSlot(dist)] + o.alignPrices[dist&(1<<numAlignBits-1)]
+}
+
+// update records a cheaper way to reach node i.
+func (o *optimum) update(i int, price uint32, prev int, last step) {
+	if price < o.nodes[i].price {
+		o.nodes[i] = optNode{price: price, prev: prev, last: last}
+	}
+}
+
+// updateSeq records a cheaper way to reach node i through first, a literal
+// and a match of length l at the first repeated distance.
+func (o *optimum) updateSeq(i int, price uint32, prev int, first step, l int) {
+	if price < o.nodes[i].price {
+		o.nodes[i] = optNode{price: price, prev: prev, first: first, lit: true, last: step{l, 0}}
+	}
+}
+
+// nextStep returns the next planned symbol, planning ahead when the previous
+// plan is used up.
+func (z *Writer2) nextStep() step {
+	o := z.opt
+	if o.next == len(o.plan) {
+		z.parse()
+	}
+	s := o.plan[o.next]
+	o.next++
+	return s
+}
+
+// findMatches returns the matches at pos, reusing those found by the previous parse.
+func (z *Writer2) findMatches(pos int64) []match {
+	o := z.opt
+	if o.cachedAt == pos {
+		o.cachedAt = -1
+		o.matches, o.cached = o.cached, o.matches
+		return o.matches
+	}
+	o.matches = z.mf.findMatches(pos, o.matches[:0])
+	return o.matches
+}
+
+// parse plans the symbols from the current position until every path through
+// the input ahead meets again, or a match of at least NiceLen turns up.
+func (z *Writer2) parse() {
+	o, mf, e := z.opt, z.mf, &z.enc
+	pos := mf.pos
+	o.plan, o.next = o.plan[:0], 0
+	if pos >= o.pricesAt {
+		o.updatePrices(e)
+		o.pricesAt = pos + priceInterval
+	}
+
+	avail := int(min(mf.end-pos, matchMaxLen))
+	matches := z.findMatches(pos)
+	repLen, repIndex := z.longestRep(pos, e.rep, avail)
+	if repLen >= z.cfg.NiceLen {
+		o.plan = append(o.plan, step{repLen, repIndex})
+		return
+	}
+	if n := len(matches); n > 0 && matches[n-1].len >= z.cfg.NiceLen {
+		o.plan = append(o.plan, step{matches[n-1].len, int(matches[n-1].dist-1) + numReps})
+		return
+	}
+
+	nodes := o.nodes
+	nodes[0] = optNode{state: e.state, reps: e.rep}
+	// Nodes up to lenEnd have been reached, and those up to priced have a price
+	lenEnd, priced := 0, 0
+	cur := 0
+	for {
+		p := pos + int64(cur)
+		if cur > 0 {
+			o.applyNode(cur)
+			matches = z.findMatches(p)
+			if n := len(matches); n > 0 && matches[n-1].len >= z.cfg.NiceLen {
+				// Stop here and start the next plan with the long match
+				o.cached, o.matches = o.matches, o.cached
+				o.cachedAt = p
+				break
+			}
+		}
+		limit := int(min(mf.end-p, matchMaxLen))
+		for ; priced < cur+limit; priced++ {
+			nodes[priced+1].price = infinityPrice
+		}
+		lenEnd = max(lenEnd, z.relax(cur, p, matches, limit))
+		cur++
+		if cur == lenEnd || cur == optWindow {
+			break
+		}
+	}
+
+	// Follow the cheapest path back from the end
+	n := 0
+	for i := cur; i > 0; i = nodes[i].prev {
+		n += nodes[i].symbols()
+	}
+	if cap(o.plan) < n {
+		o.plan = make([]step, n)
+	}
+	o.plan = o.plan[:n]
+	for i := cur; i > 0; i = nodes[i].prev {
+		node := &nodes[i]
+		n--
+		o.plan[n] = node.last
+		if node.lit {
+			n--
+			o.plan[n] = step{1, backLiteral}
+		}
+		if node.first.len > 0 {
+			n--
+			o.plan[n] = node.first
+		}
+	}
+	// A plan that ran out of room likely cuts its last match short, which the
+	// next plan can find in full
+	if cur == optWindow && len(o.plan) > 1 {
+		o.plan = o.plan[:len(o.plan)-1]
+	}
+}
+
+// symbols counts the symbols that lead to the node.
+func (node *optNode) symbols() int {
+	n := 1
+	if node.lit {
+		n++
+	}
+	if node.first.len > 0 {
+		n++
+	}
+	return n
+}
+
+// applyNode works out the coder state after the cheapest symbols ending at cur.
+func (o *optimum) applyNode(cur int) {
+	node := &o.nodes[cur]
+	prev := &o.nodes[node.prev]
+	node.state, node.reps = prev.state, prev.reps
+	if node.first.len > 0 {
+		node.state, node.reps = applyStep(node.state, node.reps, node.first)
+	}
+	if node.lit {
+		node.state = stateAfterLiteral(node.state)
+	}
+	node.state, node.reps = applyStep(node.state, node.reps, node.last)
+}
+
+// applyStep returns the coder state and repeated distances after s.
+func applyStep(state uint32, reps [numReps]uint32, s step) (uint32, [numReps]uint32) {
+	switch {
+	case s.back == backLiteral:
+		return stateAfterLiteral(state), reps
+	case s.back == 0 && s.len == 1:
+		return stateAfterShortRep(state), reps
+	case s.back < numReps:
+		dist := reps[s.back]
+		copy(reps[1:s.back+1], reps[:s.back])
+		reps[0] = dist
+		return stateAfterRep(state), reps
+	default:
+		copy(reps[1:], reps[:numReps-1])
+		reps[0] = uint32(s.back - numReps)
+		return stateAfterMatch(state), reps
+	}
+}
+
+// relax prices every symbol that can start at cur, keeping those that reach a
+// node more cheaply than before. It returns the furthest node reached.
+func (z *Writer2) relax(cur int, p int64, matches []match, limit int) int {
+	o, mf, e := z.opt, z.mf, &z.enc
+	node := o.nodes[cur]
+	posState := e.posState(p)
+	reached := cur + 1
+
+	b := mf.at(p)
+	var prevByte, matchByte byte
+	if p > 0 {
+		prevByte = mf.at(p - 1)
+	}
+	rep0 := int64(node.reps[0]) + 1
+	rep0Valid := rep0 <= p-z.historyStart()
+	if rep0Valid {
+		matchByte = mf.at(p - rep0)
+	}
+	litPrice := node.price + e.literalPrice(p, node.state, b, prevByte, matchByte)
+	o.update(cur+1, litPrice, cur, step{1, backLiteral})
+	if rep0Valid && matchByte == b {
+		o.update(cur+1, node.price+e.shortRepPrice(node.state, posState), cur, step{1, 0})
+	}
+	if limit < matchMinLen {
+		return reached
+	}
+
+	// A literal and then the first repeated distance again
+	if rep0Valid && matchByte != b {
+		if l := mf.matchLen(p+1, rep0, min(limit-1, z.cfg.NiceLen)); l >= matchMinLen {
+			ps := e.posState(p + 1)
+			price := litPrice + e.repMatchPrice(0, stateAfterLiteral(node.state), ps) + o.repLenPrices[ps][l]
+			o.updateSeq(cur+1+l, price, cur, step{}, l)
+			reached = max(reached, cur+1+l)
+		}
+	}
+
+	for i, rep := range node.reps {
+		dist := int64(rep) + 1
+		if dist > p-z.historyStart() {
+			continue
+		}
+		l := mf.matchLen(p, dist, limit)
+		if l < matchMinLen {
+			continue
+		}
+		price := node.price + e.repMatchPrice(i, node.state, posState)
+		for n := matchMinLen; n <= l; n++ {
+			o.update(cur+n, price+o.repLenPrices[posState][n], cur, step{n, i})
+		}
+		price += o.repLenPrices[posState][l]
+		reached = max(reached, cur+l, z.relaxSeq(cur, p, stateAfterRep(node.state), price, step{l, i}, dist, limit))
+	}
+
+	// Each length is priced with the closest match that covers it
+	price := node.price + e.matchPrice(node.state, posState)
+	n := matchMinLen
+	for _, m := range matches {
+		if m.len < matchMinLen {
+			continue
+		}
+		dist := uint32(m.dist - 1)
+		longDist := o.distancePrice(dist, numLenToPosStates-1)
+		distPrice := longDist
+		for ; n <= m.len; n++ {
+			distPrice = longDist
+			if lenState := lenToPosState(uint32(n - matchMinLen)); lenState < numLenToPosStates-1 {
+				distPrice = o.distancePrice(dist, lenState)
+			}
+			o.update(cur+n, price+o.matchLenPrices[posState][n]+distPrice, cur, step{n, int(dist) + numReps})
+		}
+		total := price + o.matchLenPrices[posState][m.len] + distPrice
+		s := step{m.len, int(dist) + numReps}
+		reached = max(reached, cur+m.len, z.relaxSeq(cur, p, stateAfterMatch(node.state), total, s, m.dist, limit))
+	}
+	return reached
+}
+
+// relaxSeq prices a literal and then a match at the same distance again after
+// first, a match of that distance which ends where a byte differs. The state
+// and price are those after first. It returns the furthest node reached.
+func (z *Writer2) relaxSeq(cur int, p int64, state uint32, price uint32, first step, dist int64, limit int) int {
+	o, mf, e := z.opt, z.mf, &z.enc
+	if limit-first.len-1 < matchMinLen {
+		return 0
+	}
+	q := p + int64(first.len)
+	l := mf.matchLen(q+1, dist, min(limit-first.len-1, z.cfg.NiceLen))
+	if l < matchMinLen {
+		return 0
+	}
+	price += e.literalPrice(q, state, mf.at(q), mf.at(q-1), mf.at(q-dist))
+	ps := e.posState(q + 1)
+	price += e.repMatchPrice(0, stateAfterLiteral(state), ps) + o.repLenPrices[ps][l]
+	o.updateSeq(cur+first.len+1+l, price, cur, first, l)
+	return cur + first.len + 1 + l
+}
@@ -0,0 +1,155 @@
+package lzma
+
+import "math"
+
+const (
+	// Prices are in 1/256 bits, fine enough that the many nearly certain bits
+	// of well predicted data do not round to nothing.
+	priceShiftBits = 8
+	infinityPrice  = 1 << 30
+)
+
+// probPrices holds the cost of coding a zero bit for each probability.
+var probPrices [bitModelTotal]uint32
+
+func init() {
+	for i := 1; i < bitModelTotal; i++ {
+		probPrices[i] = uint32(-math.Log2(float64(i)/bitModelTotal)*(1<<priceShiftBits) + 0.5)
+	}
+}
+
+func bitPrice(p prob, bit uint32) uint32 {
+	if bit == 0 {
+		return probPrices[p]
+	}
+	return probPrices[bitModelTotal-p]
+}
+
+func treePrice(probs []prob, numBits uint, symbol uint32) uint32 {
+	price := uint32(0)
+	m := uint32(1)
+	for i := numBits; i > 0; i-- {
+		bit := (symbol >> (i - 1)) & 1
+		price += bitPrice(probs[m], bit)
+		m = m<<1 | bit
+	}
+	return price
+}
+
+func reverseTreePrice(probs []prob, numBits uint, symbol uint32) uint32 {
+	price := uint32(0)
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		bit := symbol & 1
+		symbol >>= 1
+		price += bitPrice(probs[m], bit)
+		m = m<<1 | bit
+	}
+	return price
+}
+
+func (m *lenModel) price(l, posState uint32) uint32 {
+	if l < numLowLen {
+		return bitPrice(m.choice, 0) + treePrice(m.low[posState][:], numLowLenBits, l)
+	}
+	l -= numLowLen
+	if l < numMidLen {
+		return bitPrice(m.choice, 1) + bitPrice(m.choice2, 0) + treePrice(m.mid[posState][:], numMidLenBits, l)
+	}
+	return bitPrice(m.choice, 1) + bitPrice(m.choice2, 1) + treePrice(m.high[:], numHighLenBits, l-numMidLen)
+}
+
+// prices fills out with the price of every match length for each position
+// state up to numPosStates, working out the shared high lengths once.
+func (m *lenModel) prices(out *[1 << numPosBitsMax][matchMaxLen + 1]uint32, numPosStates int) {
+	low := bitPrice(m.choice, 0)
+	mid := bitPrice(m.choice, 1) + bitPrice(m.choice2, 0)
+	high := bitPrice(m.choice, 1) + bitPrice(m.choice2, 1)
+	for l := numLowLen + numMidLen; l <= matchMaxLen-matchMinLen; l++ {
+		out[0][l+matchMinLen] = high + treePrice(m.high[:], numHighLenBits, uint32(l-numLowLen-numMidLen))
+	}
+	for ps := 0; ps < numPosStates; ps++ {
+		if ps > 0 {
+			copy(out[ps][matchMinLen+numLowLen+numMidLen:], out[0][matchMinLen+numLowLen+numMidLen:])
+		}
+		for l := 0; l < numLowLen; l++ {
+			out[ps][l+matchMinLen] = low + treePrice(m.low[ps][:], numLowLenBits, uint32(l))
+			out[ps][l+matchMinLen+numLowLen] = mid + treePrice(m.mid[ps][:], numMidLenBits, uint32(l))
+		}
+	}
+}
+
+// The prices below mirror the encoder methods of the same names, for a
+// symbol coded in the given state rather than the encoder's own.
+
+func (e *encoder) literalPrice(pos int64, state uint32, cur, prev, matchByte byte) uint32 {
+	price := bitPrice(e.isMatch[state<<numPosBitsMax+e.posState(pos)], 0)
+	probs := e.literalProbs(pos, prev)
+	symbol := uint32(1)
+	i := 8
+	if state >= 7 {
+		mb := uint32(matchByte)
+		for i > 0 {
+			i--
+			matchBit := (mb >> 7) & 1
+			mb <<= 1
+			bit := uint32(cur>>uint(i)) & 1
+			price += bitPrice(probs[0x100+matchBit<<8+symbol], bit)
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for i > 0 {
+		i--
+		bit := uint32(cur>>uint(i)) & 1
+		price += bitPrice(probs[symbol], bit)
+		symbol = symbol<<1 | bit
+	}
+	return price
+}
+
+// matchPrice is the price of a new distance match, without its length and distance.
+func (e *encoder) matchPrice(state, posState uint32) uint32 {
+	return bitPrice(e.isMatch[state<<numPosBitsMax+posState], 1) + bitPrice(e.isRep[state], 0)
+}
+
+func (e *encoder) distancePrice(dist, lenState uint32) uint32 {
+	if dist < startPosModelIndex {
+		return treePrice(e.posSlot[lenState][:], numPosSlotBits, dist)
+	}
+	n := uint32(31)
+	for dist>>n == 0 {
+		n--
+	}
+	posSlot := n<<1 | (dist>>(n-1))&1
+	price := treePrice(e.posSlot[lenState][:], numPosSlotBits, posSlot)
+	footerBits := uint(posSlot>>1) - 1
+	base := (2 | posSlot&1) << footerBits
+	reduced := dist - base
+	if posSlot < endPosModelIndex {
+		return price + reverseTreePrice(e.posSpecial[base-posSlot:], footerBits, reduced)
+	}
+	return price + uint32(footerBits-numAlignBits)<<priceShiftBits +
+		reverseTreePrice(e.align[:], numAlignBits, reduced&(1<<numAlignBits-1))
+}
+
+// repMatchPrice is the price of a match at the repIndex-th recent distance,
+// without its length.
+func (e *encoder) repMatchPrice(repIndex int, state, posState uint32) uint32 {
+	price := bitPrice(e.isMatch[state<<numPosBitsMax+posState], 1) + bitPrice(e.isRep[state], 1)
+	if repIndex == 0 {
+		return price + bitPrice(e.isRepG0[state], 0) + bitPrice(e.isRep0Long[state<<numPosBitsMax+posState], 1)
+	}
+	price += bitPrice(e.isRepG0[state], 1)
+	if repIndex == 1 {
+		return price + bitPrice(e.isRepG1[state], 0)
+	}
+	return price + bitPrice(e.isRepG1[state], 1) + bitPrice(e.isRepG2[state], uint32(repIndex-2))
+}
+
+func (e *encoder) shortRepPrice(state, posState uint32) uint32 {
+	return bitPrice(e.isMatch[state<<numPosBitsMax+posState], 1) + bitPrice(e.isRep[state], 1) +
+		bitPrice(e.isRepG0[state], 0) + bitPrice(e.isRep0Long[state<<numPosBitsMax+posState], 0)
+}
@@ -0,0 +1,227 @@
+package lzma
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	numBitModelTotalBits = 11
+	bitModelTotal        = 1 << numBitModelTotalBits
+	numMoveBits          = 5
+	topValue             = 1 << 24
+)
+
+// prob is an adaptive probability that the next bit is zero.
+type prob uint16
+
+const probInit = prob(bitModelTotal / 2)
+
+func initProbs(p []prob) {
+	for i := range p {
+		p[i] = probInit
+	}
+}
+
+// rangeDecoder is the arithmetic decoder shared by LZMA and LZMA2.
+type rangeDecoder struct {
+	br    io.ByteReader
+	rng   uint32
+	code  uint32
+	err   error
+	bytes int64 // bytes consumed, including the five initialisation bytes
+}
+
+var errCorrupt = errors.New("lzma: corrupt compressed data")
+
+func (rc *rangeDecoder) init(br io.ByteReader) error {
+	rc.br = br
+	rc.rng = 0xffffffff
+	rc.code = 0
+	rc.err = nil
+	rc.bytes = 0
+	first := rc.readByte()
+	for i := 0; i < 4; i++ {
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+	if rc.err != nil {
+		return rc.err
+	}
+	if first != 0 || rc.code == rc.rng {
+		return errCorrupt
+	}
+	return nil
+}
+
+func (rc *rangeDecoder) readByte() byte {
+	b, err := rc.br.ReadByte()
+	if err != nil {
+		if rc.err == nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			rc.err = err
+		}
+		return 0
+	}
+	rc.bytes++
+	return b
+}
+
+// finishedOK reports whether the stream ended cleanly (the final code value is zero).
+func (rc *rangeDecoder) finishedOK() bool {
+	return rc.code == 0
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < topValue {
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+}
+
+func (rc *rangeDecoder) decodeBit(p *prob) uint32 {
+	bound := (rc.rng >> numBitModelTotalBits) * uint32(*p)
+	var bit uint32
+	if rc.code < bound {
+		rc.rng = bound
+		*p += (bitModelTotal - *p) >> numMoveBits
+	} else {
+		rc.rng -= bound
+		rc.code -= bound
+		*p -= *p >> numMoveBits
+		bit = 1
+	}
+	rc.normalize()
+	return bit
+}
+
+func (rc *rangeDecoder) decodeDirectBits(n uint) uint32 {
+	var res uint32
+	for ; n > 0; n-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		if rc.code == rc.rng {
+			rc.err = errCorrupt
+		}
+		rc.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func (rc *rangeDecoder) decodeTree(probs []prob, numBits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		m = m<<1 + rc.decodeBit(&probs[m])
+	}
+	return m - 1<<numBits
+}
+
+func (rc *rangeDecoder) decodeReverseTree(probs []prob, numBits uint) uint32 {
+	m := uint32(1)
+	var symbol uint32
+	for i := uint(0); i < numBits; i++ {
+		bit := rc.decodeBit(&probs[m])
+		m = m<<1 + bit
+		symbol |= bit << i
+	}
+	return symbol
+}
+
+// rangeEncoder is the arithmetic encoder; output is collected in memory so LZMA2
+// can size its chunks before writing them.
+type rangeEncoder struct {
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int64
+	out       []byte
+}
+
+func (rc *rangeEncoder) init() {
+	rc.low = 0
+	rc.rng = 0xffffffff
+	rc.cache = 0
+	rc.cacheSize = 1
+	rc.out = rc.out[:0]
+}
+
+// pending returns an upper bound on the bytes the encoder will have written once flushed.
+func (rc *rangeEncoder) pending() int {
+	return len(rc.out) + int(rc.cacheSize) + 5
+}
+
+func (rc *rangeEncoder) shiftLow() {
+	if uint32(rc.low) < 0xff000000 || rc.low>>32 != 0 {
+		temp := rc.cache
+		for {
+			rc.out = append(rc.out, temp+byte(rc.low>>32))
+			temp = 0xff
+			rc.cacheSize--
+			if rc.cacheSize == 0 {
+				break
+			}
+		}
+		rc.cache = byte(rc.low >> 24)
+	}
+	rc.cacheSize++
+	rc.low = (rc.low & 0x00ffffff) << 8
+}
+
+func (rc *rangeEncoder) flush() {
+	for i := 0; i < 5; i++ {
+		rc.shiftLow()
+	}
+}
+
+func (rc *rangeEncoder) encodeBit(p *prob, bit uint32) {
+	bound := (rc.rng >> numBitModelTotalBits) * uint32(*p)
+	if bit == 0 {
+		rc.rng = bound
+		*p += (bitModelTotal - *p) >> numMoveBits
+	} else {
+		rc.low += uint64(bound)
+		rc.rng -= bound
+		*p -= *p >> numMoveBits
+	}
+	for rc.rng < topValue {
+		rc.rng <<= 8
+		rc.shiftLow()
+	}
+}
+
+func (rc *rangeEncoder) encodeDirectBits(v uint32, n uint) {
+	for n > 0 {
+		n--
+		rc.rng >>= 1
+		if (v>>n)&1 != 0 {
+			rc.low += uint64(rc.rng)
+		}
+		for rc.rng < topValue {
+			rc.rng <<= 8
+			rc.shiftLow()
+		}
+	}
+}
+
+func (rc *rangeEncoder) encodeTree(probs []prob, numBits uint, symbol uint32) {
+	m := uint32(1)
+	for i := numBits; i > 0; i-- {
+		bit := (symbol >> (i - 1)) & 1
+		rc.encodeBit(&probs[m], bit)
+		m = m<<1 | bit
+	}
+}
+
+func (rc *rangeEncoder) encodeReverseTree(probs []prob, numBits uint, symbol uint32) {
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		bit := symbol & 1
+		symbol >>= 1
+		rc.encodeBit(&probs[m], bit)
+		m = m<<1 | bit
+	}
+}
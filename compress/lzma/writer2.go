@@ -0,0 +1,255 @@
+package lzma
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	maxChunkUnpacked = 1 << 21
+	maxChunkPacked   = 1 << 16
+	maxStoredChunk   = 1 << 16
+	// chunkMargin leaves room for the largest single symbol before a chunk is closed.
+	chunkMargin = 64
+	// writeSlice bounds how much input is buffered before encoding.
+	writeSlice = 1 << 20
+)
+
+// WriterConfig tunes the LZMA2 encoder.
+type WriterConfig struct {
+	DictSize uint32
+	Props    Properties
+	// Depth is how many hash chain candidates are compared per position.
+	Depth int
+	// NiceLen stops the search early once a match this long is found.
+	NiceLen int
+}
+
+var presets = [10]WriterConfig{
+	{DictSize: 1 << 18, Depth: 4, NiceLen: 64},
+	{DictSize: 1 << 20, Depth: 12, NiceLen: 64},
+	{DictSize: 1 << 21, Depth: 16, NiceLen: 64},
+	{DictSize: 1 << 22, Depth: 24, NiceLen: 64},
+	{DictSize: 1 << 22, Depth: 32, NiceLen: 64},
+	{DictSize: 1 << 23, Depth: 48, NiceLen: 64},
+	{DictSize: 1 << 23, Depth: 64, NiceLen: 64},
+	{DictSize: 1 << 24, Depth: 96, NiceLen: 64},
+	{DictSize: 1 << 25, Depth: 128, NiceLen: 64},
+	{DictSize: 1 << 26, Depth: 192, NiceLen: 64},
+}
+
+// DefaultPreset matches the xz command line default.
+const DefaultPreset = 6
+
+// PresetConfig returns the encoder settings for an xz-style preset from 0 to 9.
+func PresetConfig(preset int) (WriterConfig, error) {
+	if preset < 0 || preset > 9 {
+		return WriterConfig{}, fmt.Errorf("lzma: invalid preset %d (expected 0-9)", preset)
+	}
+	cfg := presets[preset]
+	cfg.Props = DefaultProperties
+	return cfg, nil
+}
+
+// Writer2 compresses data into an LZMA2 stream.
+type Writer2 struct {
+	w   io.Writer
+	cfg WriterConfig
+	mf  *matchFinder
+	enc encoder
+
+	chunkStart    int64
+	needDictReset bool
+	needProps     bool
+	needReset     bool
+
+	opt *optimum
+
+	err    error
+	closed bool
+}
+
+// NewWriter2 returns a Writer2 using cfg.
+func NewWriter2(w io.Writer, cfg WriterConfig) (*Writer2, error) {
+	if cfg.Props.LC+cfg.Props.LP > 4 || cfg.Props.PB > 4 {
+		return nil, fmt.Errorf("lzma2: invalid properties %+v", cfg.Props)
+	}
+	if cfg.DictSize < 4096 {
+		cfg.DictSize = 4096
+	}
+	cfg.NiceLen = min(max(cfg.NiceLen, 8), matchMaxLen)
+	cfg.Depth = max(cfg.Depth, 1)
+
+	z := &Writer2{
+		w:             w,
+		cfg:           cfg,
+		mf:            newMatchFinder(cfg.DictSize, cfg.Depth, cfg.NiceLen),
+		needDictReset: true,
+		needProps:     true,
+		opt:           newOptimum(),
+	}
+	z.enc.reset(cfg.Props)
+	z.enc.rc.init()
+	return z, nil
+}
+
+// DictSizeByte returns the dictionary size property to store in container headers.
+func (z *Writer2) DictSizeByte() byte {
+	return DictSizeByte(z.cfg.DictSize)
+}
+
+// Write compresses p.
+func (z *Writer2) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, fmt.Errorf("lzma2: write to closed writer")
+	}
+	n := 0
+	for len(p) > 0 && z.err == nil {
+		c := min(len(p), writeSlice)
+		z.mf.append(p[:c], z.chunkStart)
+		z.encode(false)
+		p = p[c:]
+		n += c
+	}
+	return n, z.err
+}
+
+// Close flushes pending data and writes the end-of-stream marker. It does not
+// close the underlying writer.
+func (z *Writer2) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	z.encode(true)
+	if z.err == nil && z.mf.pos > z.chunkStart {
+		z.flushChunk()
+	}
+	if z.err == nil {
+		_, z.err = z.w.Write([]byte{0x00})
+	}
+	return z.err
+}
+
+// encode consumes buffered input. Unless final, it keeps a full match length of
+// lookahead so matches are not cut short at the buffer boundary.
+func (z *Writer2) encode(final bool) {
+	mf := z.mf
+	for z.err == nil {
+		avail := mf.end - mf.pos
+		if avail == 0 || (!final && avail <= matchMaxLen+1) {
+			return
+		}
+		if mf.pos-z.chunkStart+matchMaxLen > maxChunkUnpacked || z.enc.rc.pending()+chunkMargin > maxChunkPacked {
+			z.flushChunk()
+			continue
+		}
+		z.encodeSymbol()
+	}
+}
+
+// encodeSymbol writes the next planned literal or match at the current position.
+func (z *Writer2) encodeSymbol() {
+	mf := z.mf
+	pos := mf.pos
+	s := z.nextStep()
+	switch {
+	case s.back == backLiteral:
+		cur := mf.at(pos)
+		var prev, matchByte byte
+		if pos > 0 {
+			prev = mf.at(pos - 1)
+		}
+		if rep0 := int64(z.enc.rep[0]) + 1; rep0 <= pos-z.historyStart() {
+			matchByte = mf.at(pos - rep0)
+		}
+		z.enc.encodeLiteral(pos, cur, prev, matchByte)
+	case s.back == 0 && s.len == 1:
+		z.enc.encodeShortRep(pos)
+	case s.back < numReps:
+		z.enc.encodeRepMatch(pos, s.back, s.len)
+	default:
+		z.enc.encodeMatch(pos, uint32(s.back-numReps), s.len)
+	}
+	z.advance(s.len)
+}
+
+// longestRep finds the longest match at one of the repeated distances reps.
+func (z *Writer2) longestRep(pos int64, reps [numReps]uint32, limit int) (int, int) {
+	repLen, repIndex := 0, 0
+	for i, rep := range reps {
+		dist := int64(rep) + 1
+		if dist > pos-z.historyStart() {
+			continue
+		}
+		if l := z.mf.matchLen(pos, dist, limit); l > repLen {
+			repLen, repIndex = l, i
+		}
+	}
+	return repLen, repIndex
+}
+
+// historyStart is the earliest position a match may refer to.
+func (z *Writer2) historyStart() int64 {
+	return max(z.mf.base, 0)
+}
+
+func (z *Writer2) advance(n int) {
+	z.mf.pos += int64(n)
+	z.mf.insertUpTo(z.mf.pos)
+}
+
+// flushChunk writes the current chunk, falling back to stored chunks when the
+// data did not compress.
+func (z *Writer2) flushChunk() {
+	unpacked := z.mf.pos - z.chunkStart
+	if unpacked == 0 {
+		return
+	}
+	z.enc.rc.flush()
+	packed := z.enc.rc.out
+
+	if int64(len(packed)) >= unpacked {
+		data := z.mf.bytes(z.chunkStart, z.mf.pos)
+		for len(data) > 0 && z.err == nil {
+			n := min(len(data), maxStoredChunk)
+			control := byte(0x02)
+			if z.needDictReset {
+				control = 0x01
+				z.needDictReset = false
+			}
+			hdr := []byte{control, byte((n - 1) >> 8), byte(n - 1)}
+			if _, z.err = z.w.Write(hdr); z.err == nil {
+				_, z.err = z.w.Write(data[:n])
+			}
+			data = data[n:]
+		}
+		// The decoder never saw this chunk's symbols, so both sides start afresh
+		z.needReset = true
+	} else {
+		control := byte(0x80) | byte((unpacked-1)>>16)
+		switch {
+		case z.needDictReset:
+			control |= 0x60
+		case z.needProps:
+			control |= 0x40
+		case z.needReset:
+			control |= 0x20
+		}
+		hdr := []byte{control, byte((unpacked - 1) >> 8), byte(unpacked - 1), byte((len(packed) - 1) >> 8), byte(len(packed) - 1)}
+		if z.needDictReset || z.needProps {
+			hdr = append(hdr, z.cfg.Props.Byte())
+		}
+		if _, z.err = z.w.Write(hdr); z.err == nil {
+			_, z.err = z.w.Write(packed)
+		}
+		z.needDictReset, z.needProps, z.needReset = false, false, false
+	}
+
+	z.chunkStart = z.mf.pos
+	if z.needReset {
+		z.enc.reset(z.cfg.Props)
+		z.opt.clear()
+	}
+	z.enc.rc.init()
+}
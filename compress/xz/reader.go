@@ -0,0 +1,457 @@
+// Package xz reads and writes the .xz container format around LZMA2 data.
+package xz
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"futile/compress/bcj"
+	"futile/compress/lzma"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+var (
+	headerMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	footerMagic = []byte{'Y', 'Z'}
+)
+
+// Check types stored in the stream flags.
+const (
+	CheckNone   = 0x00
+	CheckCRC32  = 0x01
+	CheckCRC64  = 0x04
+	CheckSHA256 = 0x0a
+)
+
+const (
+	filterDelta = 0x03
+	filterLZMA2 = 0x21
+)
+
+// bcjFilters maps the IDs of branch converter filters to their instruction sets.
+var bcjFilters = map[uint64]bcj.Filter{
+	0x04: bcj.X86,
+	0x05: bcj.PowerPC,
+	0x06: bcj.IA64,
+	0x07: bcj.ARM,
+	0x08: bcj.ARMThumb,
+	0x09: bcj.SPARC,
+	0x0a: bcj.ARM64,
+	0x0b: bcj.RISCV,
+}
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// ErrFormat is returned for data that is not a valid xz stream.
+var ErrFormat = errors.New("xz: invalid format")
+
+// newCheck returns the integrity hash for a check type, nil for CheckNone, and
+// the size of the stored check.
+func newCheck(checkType byte) (hash.Hash, int, error) {
+	switch checkType {
+	case CheckNone:
+		return nil, 0, nil
+	case CheckCRC32:
+		return crc32.NewIEEE(), 4, nil
+	case CheckCRC64:
+		return crc64.New(crc64Table), 8, nil
+	case CheckSHA256:
+		return sha256.New(), 32, nil
+	}
+	// Unknown checks still have a size defined by their type so they can be skipped
+	sizes := [16]int{0, 4, 4, 4, 8, 8, 8, 16, 16, 16, 32, 32, 32, 64, 64, 64}
+	if checkType < 16 {
+		return nil, sizes[checkType], nil
+	}
+	return nil, 0, ErrFormat
+}
+
+// Reader decompresses an xz file, including files made of several concatenated
+// streams separated by stream padding.
+type Reader struct {
+	br *countingReader
+
+	checkType byte
+	check     hash.Hash
+	checkSize int
+
+	block      io.Reader
+	blockStart int64
+	blockSize  int64
+	records    []indexRecord
+
+	err error
+}
+
+type indexRecord struct {
+	unpadded     int64
+	uncompressed int64
+}
+
+// countingReader tracks the input offset, which block padding and the index depend on.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// NewReader reads the first stream header and returns a Reader.
+func NewReader(r io.Reader) (*Reader, error) {
+	z := &Reader{br: &countingReader{r: bufio.NewReader(r)}}
+	if err := z.readStreamHeader(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Read decompresses into p.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.err == nil {
+		if z.block != nil {
+			n, err := z.block.Read(p)
+			z.blockSize += int64(n)
+			if z.check != nil {
+				z.check.Write(p[:n])
+			}
+			if err == io.EOF {
+				z.err = z.finishBlock()
+				z.block = nil
+			} else if err != nil {
+				z.err = err
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		z.err = z.nextBlock()
+	}
+	return 0, z.err
+}
+
+func (z *Reader) readStreamHeader() error {
+	var hdr [12]byte
+	if _, err := io.ReadFull(z.br, hdr[:]); err != nil {
+		return ErrFormat
+	}
+	if !bytes.Equal(hdr[:6], headerMagic) {
+		return ErrFormat
+	}
+	if crc32.ChecksumIEEE(hdr[6:8]) != binary.LittleEndian.Uint32(hdr[8:]) {
+		return fmt.Errorf("xz: stream header checksum mismatch")
+	}
+	if hdr[6] != 0 || hdr[7] > 0x0f {
+		return fmt.Errorf("xz: unsupported stream flags")
+	}
+	z.checkType = hdr[7]
+	_, size, err := newCheck(z.checkType)
+	if err != nil {
+		return err
+	}
+	z.checkSize = size
+	z.records = z.records[:0]
+	return nil
+}
+
+// nextBlock starts the next block, or reads the index and footer when the stream ends.
+func (z *Reader) nextBlock() error {
+	start := z.br.n
+	sizeByte, err := z.br.ReadByte()
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if sizeByte == 0 {
+		if err := z.readIndexAndFooter(start); err != nil {
+			return err
+		}
+		return z.nextStream()
+	}
+
+	hdr := make([]byte, int(sizeByte)*4+4)
+	hdr[0] = sizeByte
+	if _, err := io.ReadFull(z.br, hdr[1:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	body := hdr[:len(hdr)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(hdr[len(hdr)-4:]) {
+		return fmt.Errorf("xz: block header checksum mismatch")
+	}
+
+	flags := body[1]
+	if flags&0x3c != 0 {
+		return fmt.Errorf("xz: unsupported block flags")
+	}
+	rd := bytes.NewReader(body[2:])
+	if flags&0x40 != 0 {
+		if _, err := readVLI(rd); err != nil {
+			return err
+		}
+	}
+	if flags&0x80 != 0 {
+		if _, err := readVLI(rd); err != nil {
+			return err
+		}
+	}
+
+	type filter struct {
+		id    uint64
+		props []byte
+	}
+	filters := make([]filter, int(flags&0x03)+1)
+	for i := range filters {
+		id, err := readVLI(rd)
+		if err != nil {
+			return err
+		}
+		n, err := readVLI(rd)
+		if err != nil || n > uint64(rd.Len()) {
+			return ErrFormat
+		}
+		props := make([]byte, n)
+		_, _ = rd.Read(props)
+		filters[i] = filter{id, props}
+	}
+	for rd.Len() > 0 {
+		if b, _ := rd.ReadByte(); b != 0 {
+			return ErrFormat
+		}
+	}
+
+	// The last filter decodes the compressed data; earlier ones post-process it
+	last := filters[len(filters)-1]
+	if last.id != filterLZMA2 || len(last.props) != 1 {
+		return fmt.Errorf("xz: unsupported filter %#x", last.id)
+	}
+	dictSize, err := lzma.DictSizeFromByte(last.props[0])
+	if err != nil {
+		return err
+	}
+	var block io.Reader = lzma.NewReader2(z.br, dictSize)
+	for i := len(filters) - 2; i >= 0; i-- {
+		f := filters[i]
+		switch f.id {
+		case filterDelta:
+			if len(f.props) != 1 {
+				return ErrFormat
+			}
+			block = newDeltaReader(block, int(f.props[0])+1)
+		default:
+			arch, ok := bcjFilters[f.id]
+			if !ok {
+				return fmt.Errorf("xz: unsupported filter %#x", f.id)
+			}
+			// The properties optionally hold the position conversion started at
+			var start uint32
+			switch len(f.props) {
+			case 0:
+			case 4:
+				start = binary.LittleEndian.Uint32(f.props)
+			default:
+				return ErrFormat
+			}
+			block = bcj.NewReader(block, arch, start)
+		}
+	}
+
+	z.block = block
+	z.blockStart = start
+	z.blockSize = 0
+	z.check, _, _ = newCheck(z.checkType)
+	return nil
+}
+
+// finishBlock skips block padding and verifies the check.
+func (z *Reader) finishBlock() error {
+	unpadded := z.br.n - z.blockStart
+	for (z.br.n-z.blockStart)%4 != 0 {
+		b, err := z.br.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if b != 0 {
+			return ErrFormat
+		}
+	}
+	stored := make([]byte, z.checkSize)
+	if _, err := io.ReadFull(z.br, stored); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if z.check != nil {
+		sum := z.check.Sum(nil)
+		if z.checkType == CheckCRC32 || z.checkType == CheckCRC64 {
+			// CRCs are stored little-endian; hash.Hash sums are big-endian
+			for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+				sum[i], sum[j] = sum[j], sum[i]
+			}
+		}
+		if !bytes.Equal(sum, stored) {
+			return fmt.Errorf("xz: block check mismatch")
+		}
+	}
+	z.records = append(z.records, indexRecord{unpadded + int64(z.checkSize), z.blockSize})
+	return nil
+}
+
+// readIndexAndFooter verifies the index against the blocks that were read, then the footer.
+func (z *Reader) readIndexAndFooter(start int64) error {
+	crc := crc32.NewIEEE()
+	crc.Write([]byte{0})
+	tee := &vliReader{r: z.br, h: crc}
+
+	count, err := readVLI(tee)
+	if err != nil {
+		return err
+	}
+	if count != uint64(len(z.records)) {
+		return fmt.Errorf("xz: index does not match the blocks read")
+	}
+	for _, rec := range z.records {
+		unpadded, err := readVLI(tee)
+		if err != nil {
+			return err
+		}
+		uncompressed, err := readVLI(tee)
+		if err != nil {
+			return err
+		}
+		if int64(unpadded) != rec.unpadded || int64(uncompressed) != rec.uncompressed {
+			return fmt.Errorf("xz: index does not match the blocks read")
+		}
+	}
+	for (z.br.n-start)%4 != 0 {
+		b, err := tee.ReadByte()
+		if err != nil || b != 0 {
+			return ErrFormat
+		}
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(z.br, sum[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != crc.Sum32() {
+		return fmt.Errorf("xz: index checksum mismatch")
+	}
+	indexSize := z.br.n - start
+
+	var footer [12]byte
+	if _, err := io.ReadFull(z.br, footer[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if !bytes.Equal(footer[10:], footerMagic) {
+		return ErrFormat
+	}
+	if crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer[:4]) {
+		return fmt.Errorf("xz: stream footer checksum mismatch")
+	}
+	backward := (int64(binary.LittleEndian.Uint32(footer[4:8])) + 1) * 4
+	if backward != indexSize || footer[8] != 0 || footer[9] != z.checkType {
+		return fmt.Errorf("xz: stream footer does not match the header")
+	}
+	return nil
+}
+
+// nextStream skips stream padding and starts the next concatenated stream, if any.
+func (z *Reader) nextStream() error {
+	padding := 0
+	for {
+		b, err := z.br.r.Peek(1)
+		if err != nil {
+			if padding%4 != 0 {
+				return ErrFormat
+			}
+			return io.EOF
+		}
+		if b[0] != 0 {
+			break
+		}
+		_, _ = z.br.ReadByte()
+		padding++
+	}
+	if padding%4 != 0 {
+		return ErrFormat
+	}
+	return z.readStreamHeader()
+}
+
+// vliReader feeds every byte read into a hash, for the index CRC.
+type vliReader struct {
+	r io.ByteReader
+	h hash.Hash32
+}
+
+func (v *vliReader) ReadByte() (byte, error) {
+	b, err := v.r.ReadByte()
+	if err == nil {
+		v.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+// readVLI reads the variable-length integers used throughout the format.
+func readVLI(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		v |= uint64(b&0x7f) << (7 * uint(i))
+		if b&0x80 == 0 {
+			if b == 0 && i > 0 {
+				return 0, ErrFormat
+			}
+			return v, nil
+		}
+	}
+	return 0, ErrFormat
+}
+
+func appendVLI(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// deltaReader undoes the delta filter: each byte was stored as its difference
+// from the byte distance positions earlier.
+type deltaReader struct {
+	r        io.Reader
+	distance int
+	history  [256]byte
+	pos      byte
+}
+
+func newDeltaReader(r io.Reader, distance int) *deltaReader {
+	return &deltaReader{r: r, distance: distance}
+}
+
+func (d *deltaReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] += d.history[byte(d.distance+int(d.pos))]
+		d.history[d.pos] = p[i]
+		d.pos--
+	}
+	return n, err
+}
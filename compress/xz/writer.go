@@ -0,0 +1,154 @@
+package xz
+
+import (
+	"encoding/binary"
+	"fmt"
+	"futile/compress/lzma"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Presets range from 0 (fastest) to 9 (smallest output), as with the xz tool.
+const (
+	BestSpeed          = 0
+	BestCompression    = 9
+	DefaultCompression = -1
+)
+
+// Writer compresses data into a single-block xz stream.
+type Writer struct {
+	w         *countingWriter
+	lz        *lzma.Writer2
+	check     hash.Hash
+	checkType byte
+
+	headerSize   int64
+	dataStart    int64
+	uncompressed int64
+	closed       bool
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewWriter returns a Writer using the default preset and a CRC64 check.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return NewWriterLevel(w, DefaultCompression)
+}
+
+// NewWriterLevel returns a Writer for the given preset, 0-9 or DefaultCompression.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level == DefaultCompression {
+		level = lzma.DefaultPreset
+	}
+	cfg, err := lzma.PresetConfig(level)
+	if err != nil {
+		return nil, fmt.Errorf("xz: invalid preset %d (expected 0-9)", level)
+	}
+	return NewWriterConfig(w, cfg, CheckCRC64)
+}
+
+// NewWriterConfig returns a Writer with explicit encoder settings and check type.
+func NewWriterConfig(w io.Writer, cfg lzma.WriterConfig, checkType byte) (*Writer, error) {
+	check, _, err := newCheck(checkType)
+	if err != nil || (check == nil && checkType != CheckNone) {
+		return nil, fmt.Errorf("xz: unsupported check type %#x", checkType)
+	}
+
+	z := &Writer{w: &countingWriter{w: w}, check: check, checkType: checkType}
+	z.lz, err = lzma.NewWriter2(z.w, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream header
+	hdr := append([]byte{}, headerMagic...)
+	hdr = append(hdr, 0, checkType)
+	hdr = binary.LittleEndian.AppendUint32(hdr, crc32.ChecksumIEEE(hdr[6:8]))
+
+	// Block header: one LZMA2 filter, sizes left to the index
+	block := []byte{0, 0x00, filterLZMA2, 1, z.lz.DictSizeByte()}
+	for (len(block)+4)%4 != 0 {
+		block = append(block, 0)
+	}
+	block[0] = byte((len(block)+4)/4 - 1)
+	block = binary.LittleEndian.AppendUint32(block, crc32.ChecksumIEEE(block))
+	z.headerSize = int64(len(block))
+
+	if _, err := z.w.Write(append(hdr, block...)); err != nil {
+		return nil, err
+	}
+	z.dataStart = z.w.n
+	return z, nil
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, fmt.Errorf("xz: write to closed writer")
+	}
+	n, err := z.lz.Write(p)
+	z.uncompressed += int64(n)
+	if z.check != nil {
+		z.check.Write(p[:n])
+	}
+	return n, err
+}
+
+// Close finishes the block and writes the index and stream footer. It does not
+// close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+
+	if err := z.lz.Close(); err != nil {
+		return err
+	}
+	compressed := z.w.n - z.dataStart
+
+	var tail []byte
+	for (compressed+int64(len(tail)))%4 != 0 {
+		tail = append(tail, 0)
+	}
+	var sum []byte
+	if z.check != nil {
+		sum = z.check.Sum(nil)
+		if z.checkType == CheckCRC32 || z.checkType == CheckCRC64 {
+			for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+				sum[i], sum[j] = sum[j], sum[i]
+			}
+		}
+	}
+	tail = append(tail, sum...)
+
+	// Index with a single record
+	index := []byte{0}
+	index = appendVLI(index, 1)
+	index = appendVLI(index, uint64(z.headerSize+compressed+int64(len(sum))))
+	index = appendVLI(index, uint64(z.uncompressed))
+	for len(index)%4 != 0 {
+		index = append(index, 0)
+	}
+	index = binary.LittleEndian.AppendUint32(index, crc32.ChecksumIEEE(index))
+
+	footer := binary.LittleEndian.AppendUint32(nil, uint32(len(index)/4-1))
+	footer = append(footer, 0, z.checkType)
+	footer = append(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(footer)), footer...)
+	footer = append(footer, footerMagic...)
+
+	tail = append(tail, index...)
+	tail = append(tail, footer...)
+	_, err := z.w.Write(tail)
+	return err
+}
@@ -0,0 +1,171 @@
+package xz
+
+import (
+	"bytes"
+	"fmt"
+	"futile/compress/lzma"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testInput struct {
+	name string
+	data []byte
+}
+
+// testInputs returns data of every size class the container handles.
+func testInputs() []testInput {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 200<<10)
+	rng.Read(random)
+
+	var all []byte
+	for i := 0; i < 64; i++ {
+		for b := 0; b < 256; b++ {
+			all = append(all, byte(b))
+		}
+	}
+	var text bytes.Buffer
+	for i := 1; text.Len() < 400<<10; i++ {
+		fmt.Fprintf(&text, "line %d of the text, which repeats with small changes\n", i)
+	}
+
+	return []testInput{
+		{"empty", nil},
+		{"one byte", []byte{'x'}},
+		// Longer than an LZMA2 chunk
+		{"long run", bytes.Repeat([]byte{'a'}, 3<<20)},
+		{"all byte values", all},
+		{"incompressible", random},
+		{"text", text.Bytes()},
+	}
+}
+
+// seq returns the output of "seq 1 n".
+func seq(n int) []byte {
+	var b bytes.Buffer
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.Bytes()
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	checks := map[string]byte{"none": CheckNone, "crc32": CheckCRC32, "crc64": CheckCRC64, "sha256": CheckSHA256}
+	for _, in := range testInputs() {
+		for name, check := range checks {
+			t.Run(in.name+"/"+name, func(t *testing.T) {
+				cfg, err := lzma.PresetConfig(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				w, err := NewWriterConfig(&buf, cfg, check)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(in.data); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := decompress(buf.Bytes())
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				if !bytes.Equal(got, in.data) {
+					t.Fatalf("round trip of %d bytes returned %d different bytes", len(in.data), len(got))
+				}
+			})
+		}
+	}
+}
+
+func TestInvalidLevel(t *testing.T) {
+	if _, err := NewWriterLevel(io.Discard, 10); err == nil {
+		t.Error("preset 10 was accepted")
+	}
+}
+
+// The fixtures were made by xz 5.6:
+//
+//	(printf 'first stream\n' | xz; head -c 4 /dev/zero; seq 1 20000 | xz -C sha256; head -c 8 /dev/zero) > multistream.xz
+//	seq 1 1000 | xz -C <check> > check-<check>.xz
+//	seq 1 60000 | xz -1 --block-size=100000 > blocks.xz
+//	xz -c --<filter> --lzma2 branches.bin > branches-<filter>.xz
+//	xz -c --arm64=start=4096 --lzma2 branches.bin > branches-arm64-start.xz
+//	xz -c --delta=dist=4 --lzma2 branches.bin > branches-delta.xz
+//
+// branches.bin is random data in which half the bytes are opcodes of branch
+// instructions, so that every branch converter changes some of it.
+func TestDecodeFiles(t *testing.T) {
+	branches := readFixture(t, "branches.bin")
+	tests := map[string][]byte{
+		"multistream.xz":  append([]byte("first stream\n"), seq(20000)...),
+		"check-none.xz":   seq(1000),
+		"check-crc32.xz":  seq(1000),
+		"check-crc64.xz":  seq(1000),
+		"check-sha256.xz": seq(1000),
+		"blocks.xz":       seq(60000),
+	}
+	for _, filter := range []string{"x86", "powerpc", "ia64", "arm", "armthumb", "sparc", "arm64", "arm64-start", "riscv", "delta"} {
+		tests["branches-"+filter+".xz"] = branches
+	}
+
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := decompress(readFixture(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+// TestCorruptInput checks that damaged files fail with an error rather than
+// a panic. The checks of xz catch any change to the data.
+func TestCorruptInput(t *testing.T) {
+	for _, name := range []string{"check-sha256.xz", "blocks.xz", "branches-x86.xz"} {
+		t.Run(name, func(t *testing.T) {
+			data := readFixture(t, name)
+			step := len(data)/50 + 1
+			for n := 0; n < len(data); n += step {
+				if _, err := decompress(data[:n]); err == nil {
+					t.Errorf("file truncated to %d bytes was accepted", n)
+				}
+			}
+			for i := 0; i < len(data); i += step/2 + 1 {
+				damaged := bytes.Clone(data)
+				damaged[i] ^= 0x55
+				if _, err := decompress(damaged); err == nil {
+					t.Errorf("file with byte %d changed was accepted", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package zstd
+
+import (
+	"errors"
+	"math/bits"
+)
+
+var errCorrupt = errors.New("zstd: corrupt compressed data")
+
+// bitWriter appends bits least-significant first. Entropy-coded streams are
+// written forwards and read backwards, so they end with a single marker bit.
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (b *bitWriter) addBits(v uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	b.acc |= (v & (1<<n - 1)) << b.nbits
+	b.nbits += n
+	if b.nbits >= 32 {
+		b.flush32()
+	}
+}
+
+func (b *bitWriter) flush32() {
+	for b.nbits >= 8 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc >>= 8
+		b.nbits -= 8
+	}
+}
+
+// close writes the end marker and any partial byte.
+func (b *bitWriter) close() []byte {
+	b.addBits(1, 1)
+	b.flush32()
+	if b.nbits > 0 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc = 0
+		b.nbits = 0
+	}
+	return b.out
+}
+
+// flushBytes pads to a byte boundary without a marker, for forward-read headers.
+func (b *bitWriter) flushBytes() []byte {
+	b.flush32()
+	if b.nbits > 0 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc = 0
+		b.nbits = 0
+	}
+	return b.out
+}
+
+// reverseBitReader reads a bitstream from its end towards its start.
+type reverseBitReader struct {
+	in    []byte
+	off   int    // bytes in[:off] have not been loaded yet
+	value uint64 // loaded bits, the next to be read are the highest
+	bits  uint   // number of valid bits in value
+	over  uint   // bits read past the start of the stream
+}
+
+func (r *reverseBitReader) init(in []byte) error {
+	if len(in) == 0 {
+		return errCorrupt
+	}
+	last := in[len(in)-1]
+	if last == 0 {
+		return errCorrupt
+	}
+	r.in = in
+	r.off = len(in) - 1
+	r.bits = uint(bits.Len8(last)) - 1
+	r.value = uint64(last) & (1<<r.bits - 1)
+	r.over = 0
+	r.refill()
+	return nil
+}
+
+func (r *reverseBitReader) refill() {
+	for r.bits <= 56 && r.off > 0 {
+		r.off--
+		r.value = r.value<<8 | uint64(r.in[r.off])
+		r.bits += 8
+	}
+}
+
+func (r *reverseBitReader) readBits(n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+	if r.bits < n {
+		r.refill()
+		if r.bits < n {
+			// Past the start of the stream: missing bits read as zero
+			missing := n - r.bits
+			v := (r.value & (1<<r.bits - 1)) << missing
+			r.over += missing
+			r.bits = 0
+			r.value = 0
+			return v
+		}
+	}
+	r.bits -= n
+	v := (r.value >> r.bits) & (1<<n - 1)
+	return v
+}
+
+// peekBits returns the next n bits without consuming them, zero padded at the start.
+func (r *reverseBitReader) peekBits(n uint) uint64 {
+	if r.bits < n {
+		r.refill()
+		if r.bits < n {
+			return (r.value & (1<<r.bits - 1)) << (n - r.bits)
+		}
+	}
+	return (r.value >> (r.bits - n)) & (1<<n - 1)
+}
+
+func (r *reverseBitReader) skipBits(n uint) {
+	if r.bits < n {
+		r.over += n - r.bits
+		r.bits = 0
+		return
+	}
+	r.bits -= n
+	if r.bits < 32 {
+		r.refill()
+	}
+}
+
+// finished reports whether exactly every bit was consumed.
+func (r *reverseBitReader) finished() bool {
+	return r.off == 0 && r.bits == 0 && r.over == 0
+}
+
+// overflowed reports whether more bits were read than the stream holds.
+func (r *reverseBitReader) overflowed() bool {
+	return r.over > 0
+}
+
+// forwardBitReader reads bits least-significant first from the start of a buffer.
+type forwardBitReader struct {
+	in  []byte
+	pos uint // bit position
+}
+
+func (r *forwardBitReader) readBits(n uint) (uint32, error) {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		byteIdx := r.pos >> 3
+		if int(byteIdx) >= len(r.in) {
+			return 0, errCorrupt
+		}
+		v |= uint32(r.in[byteIdx]>>(r.pos&7)&1) << i
+		r.pos++
+	}
+	return v, nil
+}
+
+func (r *forwardBitReader) peekBits(n uint) uint32 {
+	save := r.pos
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		byteIdx := r.pos >> 3
+		if int(byteIdx) < len(r.in) {
+			v |= uint32(r.in[byteIdx]>>(r.pos&7)&1) << i
+		}
+		r.pos++
+	}
+	r.pos = save
+	return v
+}
+
+// bytesRead is the number of whole or partial bytes consumed.
+func (r *forwardBitReader) bytesRead() int {
+	return int((r.pos + 7) >> 3)
+}
+
+func highBit(v uint32) uint {
+	return uint(bits.Len32(v)) - 1
+}
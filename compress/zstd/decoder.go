@@ -0,0 +1,596 @@
+package zstd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	frameMagic        = 0xFD2FB528
+	skippableMagic    = 0x184D2A50
+	skippableMask     = 0xFFFFFFF0
+	maxBlockSize      = 128 << 10
+	minWindowLog      = 10
+	maxWindowLog      = 31
+	blockTypeRaw      = 0
+	blockTypeRLE      = 1
+	blockTypeCompress = 2
+)
+
+// blockDecoder holds the state carried between the blocks of a frame.
+type blockDecoder struct {
+	hist    []byte // dictionary content followed by decoded output
+	reps    [3]uint32
+	huff    *huffDecodeTable
+	llTable *fseDecodeTable
+	ofTable *fseDecodeTable
+	mlTable *fseDecodeTable
+
+	literals []byte
+	seqs     []sequence
+}
+
+// reset prepares the decoder for a new frame, optionally primed with a dictionary.
+func (d *blockDecoder) reset(dict *Dict) {
+	d.hist = d.hist[:0]
+	d.reps = [3]uint32{1, 4, 8}
+	d.huff, d.llTable, d.ofTable, d.mlTable = nil, nil, nil, nil
+	if dict != nil {
+		d.hist = append(d.hist, dict.content...)
+		d.reps = dict.reps
+		d.huff = dict.huff
+		d.llTable, d.ofTable, d.mlTable = dict.llTable, dict.ofTable, dict.mlTable
+	}
+}
+
+// decodeBlock decodes one block body and appends the result to the history.
+func (d *blockDecoder) decodeBlock(blockType int, data []byte, size int, blockMax int) error {
+	switch blockType {
+	case blockTypeRaw:
+		d.hist = append(d.hist, data...)
+		return nil
+	case blockTypeRLE:
+		if size > blockMax {
+			return errCorrupt
+		}
+		for i := 0; i < size; i++ {
+			d.hist = append(d.hist, data[0])
+		}
+		return nil
+	case blockTypeCompress:
+		start := len(d.hist)
+		n, err := d.decodeLiterals(data, blockMax)
+		if err != nil {
+			return err
+		}
+		if err := d.decodeSequences(data[n:]); err != nil {
+			return err
+		}
+		if len(d.hist)-start > blockMax {
+			return errCorrupt
+		}
+		return nil
+	}
+	return errCorrupt
+}
+
+// decodeLiterals parses the literals section and returns its size.
+func (d *blockDecoder) decodeLiterals(in []byte, blockMax int) (int, error) {
+	if len(in) == 0 {
+		return 0, errCorrupt
+	}
+	litType := in[0] & 3
+	sizeFormat := (in[0] >> 2) & 3
+
+	if litType == 0 || litType == 1 {
+		var size, hdr int
+		switch sizeFormat {
+		case 0, 2:
+			size, hdr = int(in[0]>>3), 1
+		case 1:
+			if len(in) < 2 {
+				return 0, errCorrupt
+			}
+			size, hdr = int(in[0]>>4)+int(in[1])<<4, 2
+		case 3:
+			if len(in) < 3 {
+				return 0, errCorrupt
+			}
+			size, hdr = int(in[0]>>4)+int(in[1])<<4+int(in[2])<<12, 3
+		}
+		if size > blockMax {
+			return 0, errCorrupt
+		}
+		if litType == 0 {
+			if len(in) < hdr+size {
+				return 0, errCorrupt
+			}
+			d.literals = append(d.literals[:0], in[hdr:hdr+size]...)
+			return hdr + size, nil
+		}
+		if len(in) < hdr+1 {
+			return 0, errCorrupt
+		}
+		d.literals = d.literals[:0]
+		for i := 0; i < size; i++ {
+			d.literals = append(d.literals, in[hdr])
+		}
+		return hdr + 1, nil
+	}
+
+	// Huffman-coded literals, with a new table (2) or the previous one (3)
+	var hdr int
+	var sizeBits uint
+	streams := 4
+	switch sizeFormat {
+	case 0:
+		hdr, sizeBits, streams = 3, 10, 1
+	case 1:
+		hdr, sizeBits = 3, 10
+	case 2:
+		hdr, sizeBits = 4, 14
+	case 3:
+		hdr, sizeBits = 5, 18
+	}
+	if len(in) < hdr {
+		return 0, errCorrupt
+	}
+	var v uint64
+	for i := hdr - 1; i >= 0; i-- {
+		v = v<<8 | uint64(in[i])
+	}
+	mask := uint64(1)<<sizeBits - 1
+	regenerated := int((v >> 4) & mask)
+	compressed := int((v >> (4 + sizeBits)) & mask)
+	if regenerated > blockMax || len(in) < hdr+compressed {
+		return 0, errCorrupt
+	}
+	data := in[hdr : hdr+compressed]
+
+	if litType == 2 {
+		table, n, err := readHuffmanTable(data)
+		if err != nil {
+			return 0, err
+		}
+		d.huff = table
+		data = data[n:]
+	} else if d.huff == nil {
+		return 0, fmt.Errorf("zstd: treeless literals without a previous Huffman table")
+	}
+
+	if cap(d.literals) < regenerated {
+		d.literals = make([]byte, regenerated)
+	}
+	d.literals = d.literals[:regenerated]
+	if streams == 1 {
+		if err := d.huff.decodeStream(data, d.literals); err != nil {
+			return 0, err
+		}
+		return hdr + compressed, nil
+	}
+
+	if len(data) < 6 {
+		return 0, errCorrupt
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(data[0:])),
+		int(binary.LittleEndian.Uint16(data[2:])),
+		int(binary.LittleEndian.Uint16(data[4:])),
+	}
+	data = data[6:]
+	sizes[3] = len(data) - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return 0, errCorrupt
+	}
+	segment := (regenerated + 3) / 4
+	if 3*segment > regenerated {
+		return 0, errCorrupt
+	}
+	out := d.literals
+	for i := 0; i < 4; i++ {
+		n := segment
+		if i == 3 {
+			n = len(out)
+		}
+		if err := d.huff.decodeStream(data[:sizes[i]], out[:n]); err != nil {
+			return 0, err
+		}
+		data = data[sizes[i]:]
+		out = out[n:]
+	}
+	return hdr + compressed, nil
+}
+
+// readSequenceTable selects the decoding table for one symbol type.
+func readSequenceTable(mode byte, in []byte, prev, predefined *fseDecodeTable, maxSymbol int, maxLog uint) (*fseDecodeTable, int, error) {
+	switch mode {
+	case modePredefined:
+		return predefined, 0, nil
+	case modeRLE:
+		if len(in) < 1 || int(in[0]) > maxSymbol {
+			return nil, 0, errCorrupt
+		}
+		return rleDecodeTable(in[0]), 1, nil
+	case modeCompressed:
+		norm, log, n, err := readNCount(in, maxSymbol, maxLog)
+		if err != nil {
+			return nil, 0, err
+		}
+		t, err := buildFSEDecodeTable(norm, log)
+		if err != nil {
+			return nil, 0, err
+		}
+		return t, n, nil
+	default:
+		if prev == nil {
+			return nil, 0, fmt.Errorf("zstd: repeat table mode without a previous table")
+		}
+		return prev, 0, nil
+	}
+}
+
+// decodeSequences parses the sequences section and executes it against the history.
+func (d *blockDecoder) decodeSequences(in []byte) error {
+	if len(in) == 0 {
+		return errCorrupt
+	}
+	var nbSeq, pos int
+	switch b := int(in[0]); {
+	case b == 0:
+		if len(in) != 1 {
+			return errCorrupt
+		}
+		d.hist = append(d.hist, d.literals...)
+		return nil
+	case b < 128:
+		nbSeq, pos = b, 1
+	case b < 255:
+		if len(in) < 2 {
+			return errCorrupt
+		}
+		nbSeq, pos = (b-128)<<8+int(in[1]), 2
+	default:
+		if len(in) < 3 {
+			return errCorrupt
+		}
+		nbSeq, pos = int(in[1])+int(in[2])<<8+0x7F00, 3
+	}
+	if len(in) <= pos {
+		return errCorrupt
+	}
+	modes := in[pos]
+	pos++
+	if modes&3 != 0 {
+		return errCorrupt
+	}
+
+	var err error
+	var n int
+	if d.llTable, n, err = readSequenceTable(modes>>6, in[pos:], d.llTable, llDefaultTable, maxLLCode, maxLLLog); err != nil {
+		return err
+	}
+	pos += n
+	if d.ofTable, n, err = readSequenceTable((modes>>4)&3, in[pos:], d.ofTable, ofDefaultTable, maxOFCode, maxOFLog); err != nil {
+		return err
+	}
+	pos += n
+	if d.mlTable, n, err = readSequenceTable((modes>>2)&3, in[pos:], d.mlTable, mlDefaultTable, maxMLCode, maxMLLog); err != nil {
+		return err
+	}
+	pos += n
+
+	var br reverseBitReader
+	if err := br.init(in[pos:]); err != nil {
+		return err
+	}
+	var ll, of, ml fseState
+	ll.init(&br, d.llTable)
+	of.init(&br, d.ofTable)
+	ml.init(&br, d.mlTable)
+
+	d.seqs = d.seqs[:0]
+	for i := 0; i < nbSeq; i++ {
+		ofCode := of.symbol()
+		mlc := ml.symbol()
+		llc := ll.symbol()
+		if ofCode > maxOFCode || mlc > maxMLCode || llc > maxLLCode {
+			return errCorrupt
+		}
+		var s sequence
+		s.offset = uint32(1)<<ofCode + uint32(br.readBits(uint(ofCode)))
+		s.matchLen = mlBase[mlc] + uint32(br.readBits(uint(mlBits[mlc])))
+		s.litLen = llBase[llc] + uint32(br.readBits(uint(llBits[llc])))
+		d.seqs = append(d.seqs, s)
+		if i < nbSeq-1 {
+			ll.update(&br)
+			ml.update(&br)
+			of.update(&br)
+		}
+		if br.overflowed() {
+			return errCorrupt
+		}
+	}
+	if !br.finished() {
+		return errCorrupt
+	}
+	return d.execute()
+}
+
+// execute copies literals and matches for the decoded sequences.
+func (d *blockDecoder) execute() error {
+	lits := d.literals
+	for _, s := range d.seqs {
+		if int(s.litLen) > len(lits) {
+			return errCorrupt
+		}
+		d.hist = append(d.hist, lits[:s.litLen]...)
+		lits = lits[s.litLen:]
+
+		offset := s.offset
+		if offset > 3 {
+			offset -= 3
+			d.reps[2], d.reps[1], d.reps[0] = d.reps[1], d.reps[0], offset
+		} else {
+			idx := offset
+			if s.litLen == 0 {
+				idx++
+			}
+			switch idx {
+			case 1:
+				offset = d.reps[0]
+			case 2:
+				offset = d.reps[1]
+				d.reps[1], d.reps[0] = d.reps[0], offset
+			case 3:
+				offset = d.reps[2]
+				d.reps[2], d.reps[1], d.reps[0] = d.reps[1], d.reps[0], offset
+			default:
+				offset = d.reps[0] - 1
+				if offset == 0 {
+					return errCorrupt
+				}
+				d.reps[2], d.reps[1], d.reps[0] = d.reps[1], d.reps[0], offset
+			}
+		}
+
+		if offset == 0 || int(offset) > len(d.hist) {
+			return fmt.Errorf("zstd: match offset %d beyond window", offset)
+		}
+		from := len(d.hist) - int(offset)
+		if int(s.matchLen) <= int(offset) {
+			d.hist = append(d.hist, d.hist[from:from+int(s.matchLen)]...)
+		} else {
+			for i := 0; i < int(s.matchLen); i++ {
+				d.hist = append(d.hist, d.hist[from+i])
+			}
+		}
+	}
+	d.hist = append(d.hist, lits...)
+	return nil
+}
+
+// Reader decompresses a stream of zstd frames.
+type Reader struct {
+	r     *bufio.Reader
+	dicts []*Dict
+
+	dec        blockDecoder
+	inFrame    bool
+	checksum   bool
+	hash       *xxhash64
+	windowSize int
+	dictSize   int
+	block      []byte
+
+	out int // hist[out:] has not been returned yet
+	err error
+}
+
+// NewReader returns a Reader for r. Dictionaries are matched against the
+// dictionary ID of each frame; a frame without an ID uses the first one given.
+func NewReader(r io.Reader, dicts ...*Dict) (*Reader, error) {
+	z := &Reader{r: bufio.NewReaderSize(r, 1<<16), dicts: dicts, hash: newXXHash64()}
+	if err := z.nextFrame(true); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("zstd: empty input")
+		}
+		return nil, err
+	}
+	return z, nil
+}
+
+// nextFrame skips skippable frames and parses the next frame header.
+func (z *Reader) nextFrame(first bool) error {
+	for {
+		var magic [4]byte
+		if _, err := io.ReadFull(z.r, magic[:]); err != nil {
+			if err == io.EOF {
+				return io.EOF
+			}
+			return fmt.Errorf("zstd: truncated frame header")
+		}
+		m := binary.LittleEndian.Uint32(magic[:])
+		if m&skippableMask == skippableMagic {
+			var size [4]byte
+			if _, err := io.ReadFull(z.r, size[:]); err != nil {
+				return fmt.Errorf("zstd: truncated skippable frame")
+			}
+			if _, err := z.r.Discard(int(binary.LittleEndian.Uint32(size[:]))); err != nil {
+				return fmt.Errorf("zstd: truncated skippable frame")
+			}
+			continue
+		}
+		if m != frameMagic {
+			if first {
+				return fmt.Errorf("zstd: not a zstd stream")
+			}
+			return fmt.Errorf("zstd: invalid frame magic %#08x", m)
+		}
+		return z.readFrameHeader()
+	}
+}
+
+func (z *Reader) readFrameHeader() error {
+	fhd, err := z.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("zstd: truncated frame header")
+	}
+	if fhd&0x08 != 0 {
+		return fmt.Errorf("zstd: reserved frame header bit set")
+	}
+	single := fhd&0x20 != 0
+	fcsSize := [4]int{0, 2, 4, 8}[fhd>>6]
+	if fhd>>6 == 0 && single {
+		fcsSize = 1
+	}
+	dictIDSize := [4]int{0, 1, 2, 4}[fhd&3]
+	n := dictIDSize + fcsSize
+	if !single {
+		n++
+	}
+	hdr := make([]byte, n)
+	if _, err := io.ReadFull(z.r, hdr); err != nil {
+		return fmt.Errorf("zstd: truncated frame header")
+	}
+
+	windowSize := uint64(0)
+	if !single {
+		exp := uint(hdr[0] >> 3)
+		if minWindowLog+exp > maxWindowLog {
+			return fmt.Errorf("zstd: window size too large")
+		}
+		base := uint64(1) << (minWindowLog + exp)
+		windowSize = base + base/8*uint64(hdr[0]&7)
+		hdr = hdr[1:]
+	}
+	var dictID uint32
+	for i := dictIDSize - 1; i >= 0; i-- {
+		dictID = dictID<<8 | uint32(hdr[i])
+	}
+	hdr = hdr[dictIDSize:]
+	var fcs uint64
+	for i := fcsSize - 1; i >= 0; i-- {
+		fcs = fcs<<8 | uint64(hdr[i])
+	}
+	if fcsSize == 2 {
+		fcs += 256
+	}
+	if single {
+		windowSize = fcs
+	}
+	if windowSize > 1<<maxWindowLog {
+		return fmt.Errorf("zstd: window size too large")
+	}
+
+	var dict *Dict
+	if dictID != 0 {
+		for _, d := range z.dicts {
+			if d.ID == dictID {
+				dict = d
+			}
+		}
+		if dict == nil {
+			return fmt.Errorf("zstd: frame requires dictionary %d", dictID)
+		}
+	} else if len(z.dicts) > 0 {
+		dict = z.dicts[0]
+	}
+
+	z.dec.reset(dict)
+	z.dictSize = len(z.dec.hist)
+	z.out = len(z.dec.hist)
+	z.windowSize = int(windowSize)
+	z.checksum = fhd&0x04 != 0
+	z.hash.reset()
+	z.inFrame = true
+	return nil
+}
+
+// readBlock decodes the next block of the current frame.
+func (z *Reader) readBlock() error {
+	var h [3]byte
+	if _, err := io.ReadFull(z.r, h[:]); err != nil {
+		return fmt.Errorf("zstd: truncated block header")
+	}
+	v := uint32(h[0]) | uint32(h[1])<<8 | uint32(h[2])<<16
+	last := v&1 != 0
+	blockType := int(v>>1) & 3
+	size := int(v >> 3)
+
+	blockMax := min(maxBlockSize, max(z.windowSize, 1))
+	if blockType == 3 {
+		return fmt.Errorf("zstd: reserved block type")
+	}
+	readSize := size
+	if blockType == blockTypeRLE {
+		readSize = 1
+	}
+	if readSize > blockMax {
+		return errCorrupt
+	}
+	if cap(z.block) < readSize {
+		z.block = make([]byte, readSize)
+	}
+	z.block = z.block[:readSize]
+	if _, err := io.ReadFull(z.r, z.block); err != nil {
+		return fmt.Errorf("zstd: truncated block")
+	}
+	start := len(z.dec.hist)
+	if err := z.dec.decodeBlock(blockType, z.block, size, blockMax); err != nil {
+		return err
+	}
+	z.hash.Write(z.dec.hist[start:])
+
+	if last {
+		z.inFrame = false
+		if z.checksum {
+			var sum [4]byte
+			if _, err := io.ReadFull(z.r, sum[:]); err != nil {
+				return fmt.Errorf("zstd: truncated checksum")
+			}
+			if binary.LittleEndian.Uint32(sum[:]) != uint32(z.hash.Sum64()) {
+				return fmt.Errorf("zstd: checksum mismatch")
+			}
+		}
+	}
+	return nil
+}
+
+// compact drops history that can no longer be referenced.
+func (z *Reader) compact() {
+	keep := z.windowSize + z.dictSize
+	if z.out < len(z.dec.hist) || len(z.dec.hist) < 2*keep+maxBlockSize {
+		return
+	}
+	drop := len(z.dec.hist) - keep
+	n := copy(z.dec.hist, z.dec.hist[drop:])
+	z.dec.hist = z.dec.hist[:n]
+	z.out -= drop
+}
+
+// Read decompresses into p.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.out == len(z.dec.hist) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.compact()
+		if z.inFrame {
+			z.err = z.readBlock()
+		} else {
+			z.err = z.nextFrame(false)
+			if errors.Is(z.err, io.EOF) {
+				z.err = io.EOF
+			}
+		}
+		if z.err != nil && z.err != io.EOF && z.out == len(z.dec.hist) {
+			return 0, z.err
+		}
+	}
+	n := copy(p, z.dec.hist[z.out:])
+	z.out += n
+	return n, nil
+}
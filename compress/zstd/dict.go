@@ -0,0 +1,260 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const dictMagic = 0xEC30A437
+
+// Dict is a dictionary shared by the compressor and decompressor. It carries
+// content that precedes every frame and, for formatted dictionaries, initial
+// entropy tables and repeat offsets.
+type Dict struct {
+	ID      uint32
+	content []byte
+	reps    [3]uint32
+
+	huff    *huffDecodeTable
+	llTable *fseDecodeTable
+	ofTable *fseDecodeTable
+	mlTable *fseDecodeTable
+}
+
+// ParseDict reads a dictionary in the zstd format. Data without the
+// dictionary magic number is used as raw content with ID 0.
+func ParseDict(b []byte) (*Dict, error) {
+	if len(b) < 8 || binary.LittleEndian.Uint32(b) != dictMagic {
+		if len(b) == 0 {
+			return nil, fmt.Errorf("zstd: empty dictionary")
+		}
+		return &Dict{content: b, reps: [3]uint32{1, 4, 8}}, nil
+	}
+
+	d := &Dict{ID: binary.LittleEndian.Uint32(b[4:])}
+	in := b[8:]
+	huff, n, err := readHuffmanTable(in)
+	if err != nil {
+		return nil, fmt.Errorf("zstd: dictionary Huffman table: %w", err)
+	}
+	d.huff = huff
+	in = in[n:]
+
+	readTable := func(maxSymbol int, maxLog uint) (*fseDecodeTable, error) {
+		norm, log, n, err := readNCount(in, maxSymbol, maxLog)
+		if err != nil {
+			return nil, err
+		}
+		in = in[n:]
+		return buildFSEDecodeTable(norm, log)
+	}
+	if d.ofTable, err = readTable(maxOFCode, maxOFLog); err != nil {
+		return nil, fmt.Errorf("zstd: dictionary offset table: %w", err)
+	}
+	if d.mlTable, err = readTable(maxMLCode, maxMLLog); err != nil {
+		return nil, fmt.Errorf("zstd: dictionary match length table: %w", err)
+	}
+	if d.llTable, err = readTable(maxLLCode, maxLLLog); err != nil {
+		return nil, fmt.Errorf("zstd: dictionary literal length table: %w", err)
+	}
+
+	if len(in) < 12 {
+		return nil, fmt.Errorf("zstd: truncated dictionary")
+	}
+	d.content = in[12:]
+	for i := range d.reps {
+		d.reps[i] = binary.LittleEndian.Uint32(in[4*i:])
+		if d.reps[i] == 0 || int(d.reps[i]) > len(d.content) {
+			return nil, fmt.Errorf("zstd: invalid dictionary repeat offset %d", d.reps[i])
+		}
+	}
+	return d, nil
+}
+
+// Dictionary training parameters: segments of segmentSize bytes are scored
+// by the d-mers (runs of dmerSize bytes) they share with many samples.
+const (
+	dmerSize         = 8
+	segmentSize      = 1024
+	maxTrainingInput = 128 << 20
+	maxEntropyInput  = 8 << 20
+	minDictSize      = 256
+)
+
+// TrainDict builds a dictionary of at most size bytes from sample data. The
+// most useful content is placed last, where it is cheapest to reference.
+func TrainDict(samples [][]byte, size int) ([]byte, error) {
+	if size < minDictSize {
+		return nil, fmt.Errorf("zstd: dictionary size must be at least %d bytes", minDictSize)
+	}
+	var data []byte
+	var bounds []int
+	for _, s := range samples {
+		if len(data)+len(s) > maxTrainingInput {
+			break
+		}
+		data = append(data, s...)
+		bounds = append(bounds, len(data))
+	}
+	if len(bounds) < 5 || len(data) < 2*dmerSize {
+		return nil, fmt.Errorf("zstd: not enough samples to train a dictionary (need at least 5)")
+	}
+
+	// Count in how many samples each d-mer appears
+	freq := make(map[uint64]int32)
+	seen := make(map[uint64]int)
+	start := 0
+	for i, end := range bounds {
+		for p := start; p+dmerSize <= end; p++ {
+			k := binary.LittleEndian.Uint64(data[p:])
+			if seen[k] != i+1 {
+				seen[k] = i + 1
+				freq[k]++
+			}
+		}
+		start = end
+	}
+	seen = nil
+	for k, f := range freq {
+		if f < 2 {
+			delete(freq, k)
+		}
+	}
+
+	// Reserve room for the header and entropy tables
+	budget := size - 1024
+	if budget < segmentSize {
+		budget = size / 2
+	}
+	segment := min(segmentSize, budget)
+	type pick struct {
+		start, score int
+	}
+	var picks []pick
+	nSegments := max(1, budget/segment)
+	epoch := max(len(data)/nSegments, segment)
+
+	for e := 0; e+segment <= len(data) && len(picks) < nSegments; e += epoch {
+		end := min(e+epoch, len(data))
+		best, bestScore := -1, 0
+		active := make(map[uint64]int)
+		score := 0
+		for p := e; p+dmerSize <= end; p++ {
+			k := binary.LittleEndian.Uint64(data[p:])
+			if active[k] == 0 {
+				score += int(freq[k])
+			}
+			active[k]++
+			if out := p - (segment - dmerSize) - 1; out >= e {
+				ko := binary.LittleEndian.Uint64(data[out:])
+				active[ko]--
+				if active[ko] == 0 {
+					score -= int(freq[ko])
+					delete(active, ko)
+				}
+			}
+			if first := p - (segment - dmerSize); first >= e && score > bestScore {
+				best, bestScore = first, score
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		for p := best; p+dmerSize <= best+segment; p++ {
+			delete(freq, binary.LittleEndian.Uint64(data[p:]))
+		}
+		picks = append(picks, pick{best, bestScore})
+	}
+	if len(picks) == 0 {
+		return nil, fmt.Errorf("zstd: samples have no content in common to build a dictionary")
+	}
+
+	sort.SliceStable(picks, func(a, b int) bool { return picks[a].score < picks[b].score })
+	var content []byte
+	for _, p := range picks {
+		content = append(content, data[p.start:p.start+segment]...)
+	}
+
+	tables, err := buildDictTables(content, samples)
+	if err != nil {
+		return nil, err
+	}
+	if over := len(tables) + 8 + len(content) - size; over > 0 {
+		content = content[min(over, len(content)):]
+	}
+
+	h := newXXHash64()
+	h.Write(content)
+	id := uint32(32768 + h.Sum64()%(1<<31-32768))
+
+	out := binary.LittleEndian.AppendUint32(nil, dictMagic)
+	out = binary.LittleEndian.AppendUint32(out, id)
+	out = append(out, tables...)
+	return append(out, content...), nil
+}
+
+// buildDictTables compresses the samples against the content and returns the
+// serialized entropy tables and repeat offsets derived from the statistics.
+func buildDictTables(content []byte, samples [][]byte) ([]byte, error) {
+	var litCounts [huffMaxSymbols]int
+	llCounts := make([]int, maxLLCode+1)
+	ofCounts := make([]int, maxOFCode+1)
+	mlCounts := make([]int, maxMLCode+1)
+	// Every symbol stays representable so the tables suit any input
+	for i := range litCounts {
+		litCounts[i] = 1
+	}
+	for _, c := range [][]int{llCounts, ofCounts, mlCounts} {
+		for i := range c {
+			c[i] = 1
+		}
+	}
+
+	total := 0
+	for _, s := range samples {
+		if total > maxEntropyInput {
+			break
+		}
+		total += len(s)
+		e := newEncoder(levels[3], false)
+		e.prime(content)
+		for len(s) > 0 {
+			n := min(len(s), maxBlockSize)
+			e.slide(n)
+			start := len(e.hist)
+			e.hist = append(e.hist, s[:n]...)
+			e.findSequences(start)
+			for _, c := range e.lits {
+				litCounts[c]++
+			}
+			for _, q := range e.seqs {
+				llCounts[llCode(q.litLen)]++
+				mlCounts[mlCode(q.matchLen)]++
+				ofCounts[highBit(q.offset)]++
+			}
+			s = s[n:]
+		}
+	}
+
+	huff := buildHuffEncodeTable(&litCounts)
+	desc, err := huff.writeDescription()
+	if err != nil {
+		return nil, err
+	}
+	out := desc
+	for _, t := range []struct {
+		counts []int
+		log    uint
+	}{{ofCounts, maxOFLog}, {mlCounts, maxMLLog}, {llCounts, maxLLLog}} {
+		sum := 0
+		for _, c := range t.counts {
+			sum += c
+		}
+		out = append(out, writeNCount(normalizeCounts(t.counts, sum, t.log), t.log)...)
+	}
+	for _, r := range []uint32{1, 4, 8} {
+		out = binary.LittleEndian.AppendUint32(out, r)
+	}
+	return out, nil
+}
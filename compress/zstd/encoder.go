@@ -0,0 +1,577 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// levelParams tune the match finder for one compression level.
+type levelParams struct {
+	windowLog uint
+	hashLog   uint
+	chainLog  uint
+	depth     int  // chain entries examined per position
+	lazy      int  // 0 greedy, 1 or 2 positions of lookahead
+	niceLen   int  // stop searching once a match this long is found
+	optimal   bool // choose matches by price rather than lazily
+}
+
+// Levels up to 9 match lazily; from 10 the optimal parser prices each choice,
+// searching deeper and planning further at each level.
+var levels = [23]levelParams{
+	1:  {19, 16, 16, 1, 0, 32, false},
+	2:  {20, 17, 17, 2, 1, 32, false},
+	3:  {21, 17, 18, 4, 1, 48, false},
+	4:  {21, 18, 18, 8, 1, 64, false},
+	5:  {21, 18, 19, 16, 2, 64, false},
+	6:  {21, 19, 19, 24, 2, 96, false},
+	7:  {22, 19, 20, 32, 2, 128, false},
+	8:  {22, 20, 20, 48, 2, 128, false},
+	9:  {22, 20, 21, 64, 2, 160, false},
+	10: {22, 20, 21, 16, 2, 64, true},
+	11: {22, 20, 21, 16, 2, 128, true},
+	12: {23, 21, 22, 24, 2, 128, true},
+	13: {23, 22, 23, 32, 2, 192, true},
+	14: {23, 22, 23, 48, 2, 256, true},
+	15: {23, 22, 23, 64, 2, 384, true},
+	16: {23, 22, 24, 96, 2, 512, true},
+	17: {23, 22, 24, 128, 2, 768, true},
+	18: {24, 22, 24, 192, 2, 1024, true},
+	19: {24, 22, 24, 256, 2, 2048, true},
+	20: {25, 22, 24, 384, 2, 2048, true},
+	21: {26, 23, 24, 512, 2, 4096, true},
+	22: {27, 23, 24, 1024, 2, 4096, true},
+}
+
+const (
+	minMatch      = 4
+	longWindowLog = 27
+	ldmHashLog    = 22
+	ldmStep       = 8
+)
+
+// encoder finds matches over a sliding history and emits blocks. It keeps
+// the repeat offsets the decoder will have, of which the first known are
+// valid; a job that starts mid-stream does not know its predecessor's.
+type encoder struct {
+	p      levelParams
+	long   bool
+	window int
+
+	hist       []byte
+	hashTable  []int32 // position+1 of the latest occurrence of each hash
+	chain      []int32 // previous position with the same hash
+	ldmTable   []int32
+	nextInsert int
+
+	reps  [3]uint32
+	known int
+
+	lits []byte
+	seqs []sequence
+	opt  *optimal
+}
+
+func newEncoder(p levelParams, long bool) *encoder {
+	e := &encoder{p: p, long: long}
+	if long && e.p.windowLog < longWindowLog {
+		e.p.windowLog = longWindowLog
+	}
+	e.window = 1 << e.p.windowLog
+	e.p.chainLog = min(e.p.chainLog, e.p.windowLog)
+	e.hashTable = make([]int32, 1<<e.p.hashLog)
+	e.chain = make([]int32, 1<<e.p.chainLog)
+	if long {
+		e.ldmTable = make([]int32, 1<<ldmHashLog)
+	}
+	if p.optimal {
+		e.opt = newOptimal()
+	}
+	e.reps = [3]uint32{1, 4, 8}
+	e.known = 3
+	return e
+}
+
+// prime loads data as history preceding the next block.
+func (e *encoder) prime(data []byte) {
+	e.slide(len(data))
+	e.hist = append(e.hist, data...)
+	e.insertUpTo(len(e.hist) - minMatch)
+}
+
+// slide drops history beyond the window, keeping chain indexes stable by
+// moving in multiples of the chain size.
+func (e *encoder) slide(incoming int) {
+	if len(e.hist)+incoming <= 2*e.window+maxBlockSize {
+		return
+	}
+	chainSize := len(e.chain)
+	drop := (len(e.hist) - e.window) / chainSize * chainSize
+	if drop <= 0 {
+		return
+	}
+	n := copy(e.hist, e.hist[drop:])
+	e.hist = e.hist[:n]
+	e.nextInsert -= drop
+	rebase := func(table []int32) {
+		for i, v := range table {
+			if int(v) > drop {
+				table[i] = v - int32(drop)
+			} else {
+				table[i] = 0
+			}
+		}
+	}
+	rebase(e.hashTable)
+	rebase(e.chain)
+	if e.ldmTable != nil {
+		rebase(e.ldmTable)
+	}
+}
+
+func (e *encoder) hash(pos int) uint32 {
+	return (binary.LittleEndian.Uint32(e.hist[pos:]) * 2654435761) >> (32 - e.p.hashLog)
+}
+
+func (e *encoder) ldmHash(pos int) uint64 {
+	return (binary.LittleEndian.Uint64(e.hist[pos:]) * 0x9E3779B185EBCA87) >> (64 - ldmHashLog)
+}
+
+// insertUpTo adds every position before end to the hash chains.
+func (e *encoder) insertUpTo(end int) {
+	end = min(end, len(e.hist)-minMatch+1)
+	mask := len(e.chain) - 1
+	for pos := e.nextInsert; pos < end; pos++ {
+		h := e.hash(pos)
+		e.chain[pos&mask] = e.hashTable[h]
+		e.hashTable[h] = int32(pos + 1)
+		if e.ldmTable != nil && pos%ldmStep == 0 && pos+8 <= len(e.hist) {
+			e.ldmTable[e.ldmHash(pos)] = int32(pos + 1)
+		}
+	}
+	e.nextInsert = max(e.nextInsert, end)
+}
+
+func matchLen(b []byte, a, c int) int {
+	n := 0
+	for c+n+8 <= len(b) {
+		x := binary.LittleEndian.Uint64(b[a+n:]) ^ binary.LittleEndian.Uint64(b[c+n:])
+		if x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for c+n < len(b) && b[a+n] == b[c+n] {
+		n++
+	}
+	return n
+}
+
+type match struct {
+	length int
+	dist   int
+	rep    bool
+}
+
+// gain weighs a match length against the cost of coding its offset.
+func (m match) gain() int {
+	if m.length == 0 {
+		return 0
+	}
+	if m.rep {
+		return m.length*4 - 1
+	}
+	return m.length*4 - bits.Len32(uint32(m.dist+3))
+}
+
+// search finds the best match starting at pos.
+func (e *encoder) search(pos int) match {
+	e.insertUpTo(pos)
+	var best match
+	if pos+minMatch > len(e.hist) {
+		return best
+	}
+	consider := func(m match) {
+		if m.length >= minMatch && (m.length > 4 || m.dist < 1<<16 || m.rep) && m.gain() > best.gain() {
+			best = m
+		}
+	}
+	for i := 0; i < e.known; i++ {
+		d := int(e.reps[i])
+		if d <= pos && d <= e.window {
+			consider(match{length: matchLen(e.hist, pos-d, pos), dist: d, rep: true})
+		}
+	}
+	if best.length >= e.p.niceLen {
+		return best
+	}
+
+	mask := len(e.chain) - 1
+	cand := int(e.hashTable[e.hash(pos)]) - 1
+	for depth := e.p.depth; depth > 0 && cand >= 0; depth-- {
+		d := pos - cand
+		if d > e.window || d > len(e.chain) {
+			break
+		}
+		if best.length == 0 || (pos+best.length < len(e.hist) && e.hist[cand+best.length] == e.hist[pos+best.length]) {
+			consider(match{length: matchLen(e.hist, cand, pos), dist: d})
+			if best.length >= e.p.niceLen {
+				return best
+			}
+		}
+		cand = int(e.chain[cand&mask]) - 1
+	}
+
+	if e.ldmTable != nil && pos+8 <= len(e.hist) {
+		if c := int(e.ldmTable[e.ldmHash(pos)]) - 1; c >= 0 && pos-c <= e.window {
+			consider(match{length: matchLen(e.hist, c, pos), dist: pos - c})
+		}
+	}
+	return best
+}
+
+// findSequences parses hist[start:] into literals and sequences.
+func (e *encoder) findSequences(start int) {
+	e.lits = e.lits[:0]
+	e.seqs = e.seqs[:0]
+	litStart := start
+	end := len(e.hist)
+	for pos := start; pos+minMatch <= end; {
+		m := e.search(pos)
+		if m.length == 0 {
+			pos++
+			continue
+		}
+		for e.p.lazy > 0 && m.length < e.p.niceLen {
+			if next := e.search(pos + 1); next.gain() > m.gain()+4 {
+				m = next
+				pos++
+				continue
+			}
+			if e.p.lazy > 1 {
+				if next := e.search(pos + 2); next.gain() > m.gain()+7 {
+					m = next
+					pos += 2
+					continue
+				}
+			}
+			break
+		}
+
+		litLen := uint32(pos - litStart)
+		e.lits = append(e.lits, e.hist[litStart:pos]...)
+		e.seqs = append(e.seqs, sequence{litLen: litLen, matchLen: uint32(m.length), offset: e.offsetValue(uint32(m.dist), litLen)})
+		pos += m.length
+		litStart = pos
+	}
+	e.lits = append(e.lits, e.hist[litStart:end]...)
+	e.insertUpTo(end - minMatch + 1)
+}
+
+// offsetValue codes a match distance, using a repeat code where possible,
+// and updates the repeat offsets the way the decoder will.
+func (e *encoder) offsetValue(dist, litLen uint32) uint32 {
+	code, reps, known := nextOffset(e.reps, e.known, dist, litLen)
+	e.reps, e.known = reps, known
+	return code
+}
+
+// nextOffset returns the offset value for dist after litLen literals, given
+// the repeat offsets the decoder holds, and those it holds afterwards.
+func nextOffset(reps [3]uint32, known int, dist, litLen uint32) (uint32, [3]uint32, int) {
+	code := dist + 3
+	if litLen > 0 {
+		for i := 0; i < known; i++ {
+			if reps[i] == dist {
+				code = uint32(i) + 1
+				break
+			}
+		}
+	} else {
+		switch {
+		case known >= 2 && reps[1] == dist:
+			code = 1
+		case known >= 3 && reps[2] == dist:
+			code = 2
+		case known >= 1 && reps[0] > 1 && reps[0]-1 == dist:
+			code = 3
+		}
+	}
+
+	if code > 3 {
+		reps[2], reps[1], reps[0] = reps[1], reps[0], dist
+		return code, reps, min(known+1, 3)
+	}
+	idx := code
+	if litLen == 0 {
+		idx++
+	}
+	switch idx {
+	case 2:
+		reps[1], reps[0] = reps[0], dist
+	case 3:
+		reps[2], reps[1], reps[0] = reps[1], reps[0], dist
+	case 4:
+		reps[2], reps[1], reps[0] = reps[1], reps[0], dist
+		known = min(known+1, 3)
+	}
+	return code, reps, known
+}
+
+// compressBlock appends src to the history and writes it as one block.
+func (e *encoder) compressBlock(out []byte, src []byte, last bool) []byte {
+	e.slide(len(src))
+	start := len(e.hist)
+	e.hist = append(e.hist, src...)
+
+	header := func(blockType int, size int) []byte {
+		v := uint32(size)<<3 | uint32(blockType)<<1
+		if last {
+			v |= 1
+		}
+		return append(out, byte(v), byte(v>>8), byte(v>>16))
+	}
+
+	if len(src) > 1 && isRun(src) {
+		e.insertUpTo(len(e.hist) - minMatch + 1)
+		return append(header(blockTypeRLE, len(src)), src[0])
+	}
+
+	reps, known := e.reps, e.known
+	var body []byte
+	if len(src) >= 16 {
+		if e.p.optimal {
+			e.optimalSequences(start)
+		} else {
+			e.findSequences(start)
+		}
+		body = encodeLiterals(nil, e.lits)
+		body = encodeSequences(body, e.seqs)
+	} else {
+		e.insertUpTo(len(e.hist) - minMatch + 1)
+	}
+	if body == nil || len(body) >= len(src) {
+		// Stored blocks leave the decoder's repeat offsets untouched
+		e.reps, e.known = reps, known
+		return append(header(blockTypeRaw, len(src)), src...)
+	}
+	return append(header(blockTypeCompress, len(body)), body...)
+}
+
+func isRun(b []byte) bool {
+	for _, c := range b[1:] {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// appendLiteralsHeader writes a stored (type 0) or run (type 1) literals header.
+func appendLiteralsHeader(out []byte, litType byte, size int) []byte {
+	switch {
+	case size < 32:
+		return append(out, byte(size<<3)|litType)
+	case size < 4096:
+		return append(out, litType|1<<2|byte(size&15)<<4, byte(size>>4))
+	default:
+		return append(out, litType|3<<2|byte(size&15)<<4, byte(size>>4), byte(size>>12))
+	}
+}
+
+// encodeLiterals writes the literals section, Huffman coding when it pays.
+func encodeLiterals(out []byte, lits []byte) []byte {
+	raw := func() []byte {
+		return append(appendLiteralsHeader(out, 0, len(lits)), lits...)
+	}
+	if len(lits) == 0 {
+		return raw()
+	}
+	if len(lits) > 1 && isRun(lits) {
+		return append(appendLiteralsHeader(out, 1, len(lits)), lits[0])
+	}
+	if len(lits) < 64 {
+		return raw()
+	}
+
+	var counts [huffMaxSymbols]int
+	for _, c := range lits {
+		counts[c]++
+	}
+	table := buildHuffEncodeTable(&counts)
+	if table == nil {
+		return raw()
+	}
+	desc, err := table.writeDescription()
+	if err != nil || len(desc)+table.estimateSize(&counts)+16 >= len(lits)-len(lits)/32 {
+		return raw()
+	}
+
+	body := desc
+	streams := 1
+	if len(lits) < 256 {
+		body = append(body, table.encodeStream(lits)...)
+	} else {
+		streams = 4
+		segment := (len(lits) + 3) / 4
+		var parts [4][]byte
+		rest := lits
+		for i := range parts {
+			n := min(segment, len(rest))
+			if i == 3 {
+				n = len(rest)
+			}
+			parts[i] = table.encodeStream(rest[:n])
+			rest = rest[n:]
+		}
+		for i := 0; i < 3; i++ {
+			if len(parts[i]) > math.MaxUint16 {
+				return raw()
+			}
+			body = binary.LittleEndian.AppendUint16(body, uint16(len(parts[i])))
+		}
+		for _, p := range parts {
+			body = append(body, p...)
+		}
+	}
+	if len(body) >= len(lits) {
+		return raw()
+	}
+
+	regen, comp := uint64(len(lits)), uint64(len(body))
+	var hdr []byte
+	switch {
+	case streams == 1:
+		v := 2 | regen<<4 | comp<<14
+		hdr = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case regen < 1024 && comp < 1024:
+		v := 2 | 1<<2 | regen<<4 | comp<<14
+		hdr = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case regen < 16384 && comp < 16384:
+		v := 2 | 2<<2 | regen<<4 | comp<<18
+		hdr = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	default:
+		v := 2 | 3<<2 | regen<<4 | comp<<22
+		hdr = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32)}
+	}
+	out = append(out, hdr...)
+	return append(out, body...)
+}
+
+// symbolCost is the approximate number of bits to code a symbol of probability norm/2^log.
+func symbolCost(norm int16, log uint) float64 {
+	p := float64(max(norm, 1)) / float64(uint(1)<<log)
+	return -math.Log2(p)
+}
+
+// chooseTable picks the cheapest table mode for one kind of sequence code. A
+// nil table means the RLE mode, which codes nothing per symbol.
+func chooseTable(codes []uint8, maxSymbol int, maxLog uint, defNorm []int16, defLog uint, defTable *fseEncodeTable) (byte, []byte, *fseEncodeTable) {
+	counts := make([]int, maxSymbol+1)
+	used := 0
+	top := 0
+	for _, c := range codes {
+		if counts[c] == 0 {
+			used++
+		}
+		counts[c]++
+		top = max(top, int(c))
+	}
+	if used == 1 {
+		return modeRLE, []byte{codes[0]}, nil
+	}
+
+	predefined := math.Inf(1)
+	if top < len(defNorm) {
+		predefined = 0
+		for s, c := range counts[:top+1] {
+			if c > 0 {
+				predefined += float64(c) * symbolCost(defNorm[s], defLog)
+			}
+		}
+	}
+
+	log := optimalTableLog(maxLog, len(codes), top)
+	norm := normalizeCounts(counts[:top+1], len(codes), log)
+	header := writeNCount(norm, log)
+	custom := float64(len(header) * 8)
+	for s, c := range counts[:top+1] {
+		if c > 0 {
+			custom += float64(c) * symbolCost(norm[s], log)
+		}
+	}
+	if predefined <= custom {
+		return modePredefined, nil, defTable
+	}
+	return modeCompressed, header, buildFSEEncodeTable(norm, log)
+}
+
+// encodeSequences writes the sequences section.
+func encodeSequences(out []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7F00:
+		out = append(out, byte(n>>8)+128, byte(n))
+	default:
+		out = append(out, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return out
+	}
+
+	llCodes := make([]uint8, n)
+	mlCodes := make([]uint8, n)
+	ofCodes := make([]uint8, n)
+	for i, s := range seqs {
+		llCodes[i] = llCode(s.litLen)
+		mlCodes[i] = mlCode(s.matchLen)
+		ofCodes[i] = uint8(highBit(s.offset))
+	}
+	llMode, llHdr, llTable := chooseTable(llCodes, maxLLCode, maxLLLog, llDefaultNorm, llDefaultLog, llDefaultEncTable)
+	ofMode, ofHdr, ofTable := chooseTable(ofCodes, maxOFCode, maxOFLog, ofDefaultNorm, ofDefaultLog, ofDefaultEncTable)
+	mlMode, mlHdr, mlTable := chooseTable(mlCodes, maxMLCode, maxMLLog, mlDefaultNorm, mlDefaultLog, mlDefaultEncTable)
+	out = append(out, llMode<<6|ofMode<<4|mlMode<<2)
+	out = append(out, llHdr...)
+	out = append(out, ofHdr...)
+	out = append(out, mlHdr...)
+
+	var bw bitWriter
+	var ll, of, ml fseEncState
+	initState := func(s *fseEncState, t *fseEncodeTable, sym uint8) {
+		if t != nil {
+			s.init(t, sym)
+		}
+	}
+	encode := func(s *fseEncState, sym uint8) {
+		if s.table != nil {
+			s.encode(&bw, sym)
+		}
+	}
+	extra := func(i int) {
+		s := seqs[i]
+		bw.addBits(uint64(s.litLen-llBase[llCodes[i]]), uint(llBits[llCodes[i]]))
+		bw.addBits(uint64(s.matchLen-mlBase[mlCodes[i]]), uint(mlBits[mlCodes[i]]))
+		bw.addBits(uint64(s.offset-uint32(1)<<ofCodes[i]), uint(ofCodes[i]))
+	}
+
+	last := n - 1
+	initState(&ml, mlTable, mlCodes[last])
+	initState(&of, ofTable, ofCodes[last])
+	initState(&ll, llTable, llCodes[last])
+	extra(last)
+	for i := n - 2; i >= 0; i-- {
+		encode(&of, ofCodes[i])
+		encode(&ml, mlCodes[i])
+		encode(&ll, llCodes[i])
+		extra(i)
+	}
+	for _, s := range []*fseEncState{&ml, &of, &ll} {
+		if s.table != nil {
+			s.flush(&bw)
+		}
+	}
+	return append(out, bw.close()...)
+}
@@ -0,0 +1,380 @@
+package zstd
+
+import "fmt"
+
+// fseDecodeEntry is one state of a finite state entropy decoding table.
+type fseDecodeEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	newState uint16
+}
+
+// fseDecodeTable decodes symbols coded with a given normalized distribution.
+type fseDecodeTable struct {
+	accuracyLog uint
+	entries     []fseDecodeEntry
+}
+
+// fseSpreadStep is the stride used to scatter symbols over the table.
+func fseSpreadStep(tableSize int) int {
+	return tableSize>>1 + tableSize>>3 + 3
+}
+
+// buildFSEDecodeTable builds a decoding table from normalized counts, where -1
+// marks a "less than one" probability.
+func buildFSEDecodeTable(norm []int16, accuracyLog uint) (*fseDecodeTable, error) {
+	tableSize := 1 << accuracyLog
+	t := &fseDecodeTable{accuracyLog: accuracyLog, entries: make([]fseDecodeEntry, tableSize)}
+	symbolNext := make([]uint32, len(norm))
+
+	highThreshold := tableSize - 1
+	for s, p := range norm {
+		if p == -1 {
+			t.entries[highThreshold].symbol = uint8(s)
+			highThreshold--
+			symbolNext[s] = 1
+		} else {
+			symbolNext[s] = uint32(p)
+		}
+	}
+
+	mask := tableSize - 1
+	step := fseSpreadStep(tableSize)
+	pos := 0
+	for s, p := range norm {
+		for i := 0; i < int(p); i++ {
+			t.entries[pos].symbol = uint8(s)
+			pos = (pos + step) & mask
+			for pos > highThreshold {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errCorrupt
+	}
+
+	for u := range t.entries {
+		s := t.entries[u].symbol
+		next := symbolNext[s]
+		symbolNext[s]++
+		nbBits := accuracyLog - highBit(next)
+		t.entries[u].nbBits = uint8(nbBits)
+		t.entries[u].newState = uint16((next << nbBits) - uint32(tableSize))
+	}
+	return t, nil
+}
+
+// rleDecodeTable is a table that always yields the same symbol without reading bits.
+func rleDecodeTable(symbol uint8) *fseDecodeTable {
+	return &fseDecodeTable{entries: []fseDecodeEntry{{symbol: symbol}}}
+}
+
+// fseState is a decoder position within a table.
+type fseState struct {
+	table *fseDecodeTable
+	state uint16
+}
+
+func (s *fseState) init(br *reverseBitReader, t *fseDecodeTable) {
+	s.table = t
+	s.state = uint16(br.readBits(t.accuracyLog))
+}
+
+func (s *fseState) symbol() uint8 {
+	return s.table.entries[s.state].symbol
+}
+
+func (s *fseState) update(br *reverseBitReader) {
+	e := s.table.entries[s.state]
+	s.state = e.newState + uint16(br.readBits(uint(e.nbBits)))
+}
+
+// readNCount parses a normalized count header, returning the counts, the
+// accuracy log and the number of bytes consumed.
+func readNCount(in []byte, maxSymbol int, maxLog uint) ([]int16, uint, int, error) {
+	br := forwardBitReader{in: in}
+	low, err := br.readBits(4)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	accuracyLog := uint(low) + 5
+	if accuracyLog > maxLog {
+		return nil, 0, 0, fmt.Errorf("zstd: FSE accuracy log %d too large", accuracyLog)
+	}
+
+	norm := make([]int16, 0, maxSymbol+1)
+	remaining := int32(1<<accuracyLog) + 1
+	threshold := int32(1 << accuracyLog)
+	nbBits := accuracyLog + 1
+	for remaining > 1 {
+		if len(norm) > maxSymbol {
+			return nil, 0, 0, errCorrupt
+		}
+		maxValue := 2*threshold - 1 - remaining
+		var count int32
+		v := int32(br.peekBits(nbBits))
+		if v&(threshold-1) < maxValue {
+			count = v & (threshold - 1)
+			br.pos += nbBits - 1
+		} else {
+			count = v & (2*threshold - 1)
+			if count >= threshold {
+				count -= maxValue
+			}
+			br.pos += nbBits
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+
+		if count == 0 {
+			// A zero probability is followed by 2-bit repeat flags for further zeros
+			for {
+				repeat, err := br.readBits(2)
+				if err != nil {
+					return nil, 0, 0, err
+				}
+				for i := uint32(0); i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+			if len(norm) > maxSymbol+1 {
+				return nil, 0, 0, errCorrupt
+			}
+		}
+		for remaining < threshold && nbBits > 1 {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || br.bytesRead() > len(in) {
+		return nil, 0, 0, errCorrupt
+	}
+	return norm, accuracyLog, br.bytesRead(), nil
+}
+
+// fseEncodeTable is the compression counterpart of fseDecodeTable.
+type fseEncodeTable struct {
+	accuracyLog uint
+	stateTable  []uint16
+	symbolTT    []fseSymbolTransform
+}
+
+type fseSymbolTransform struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+func buildFSEEncodeTable(norm []int16, accuracyLog uint) *fseEncodeTable {
+	tableSize := 1 << accuracyLog
+	mask := tableSize - 1
+	step := fseSpreadStep(tableSize)
+	highThreshold := tableSize - 1
+
+	tableSymbol := make([]uint8, tableSize)
+	cumul := make([]int, len(norm)+1)
+	for s, p := range norm {
+		if p == -1 {
+			cumul[s+1] = cumul[s] + 1
+			tableSymbol[highThreshold] = uint8(s)
+			highThreshold--
+		} else {
+			cumul[s+1] = cumul[s] + int(p)
+		}
+	}
+
+	pos := 0
+	for s, p := range norm {
+		for i := 0; i < int(p); i++ {
+			tableSymbol[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > highThreshold {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	t := &fseEncodeTable{
+		accuracyLog: accuracyLog,
+		stateTable:  make([]uint16, tableSize),
+		symbolTT:    make([]fseSymbolTransform, len(norm)),
+	}
+	for u := 0; u < tableSize; u++ {
+		s := tableSymbol[u]
+		t.stateTable[cumul[s]] = uint16(tableSize + u)
+		cumul[s]++
+	}
+
+	total := int32(0)
+	for s, p := range norm {
+		switch {
+		case p == 0:
+			t.symbolTT[s].deltaNbBits = uint32((accuracyLog+1)<<16) - uint32(tableSize)
+		case p == -1 || p == 1:
+			t.symbolTT[s].deltaNbBits = uint32(accuracyLog<<16) - uint32(tableSize)
+			t.symbolTT[s].deltaFindState = total - 1
+			total++
+		default:
+			maxBitsOut := accuracyLog - highBit(uint32(p-1))
+			minStatePlus := uint32(p) << maxBitsOut
+			t.symbolTT[s].deltaNbBits = uint32(maxBitsOut<<16) - minStatePlus
+			t.symbolTT[s].deltaFindState = total - int32(p)
+			total += int32(p)
+		}
+	}
+	return t
+}
+
+// fseEncState is an encoder position within a table.
+type fseEncState struct {
+	table *fseEncodeTable
+	value uint32
+}
+
+func (s *fseEncState) init(t *fseEncodeTable, symbol uint8) {
+	s.table = t
+	tt := t.symbolTT[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - tt.deltaNbBits
+	s.value = uint32(t.stateTable[int32(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (s *fseEncState) encode(bw *bitWriter, symbol uint8) {
+	tt := s.table.symbolTT[symbol]
+	nbBitsOut := (s.value + tt.deltaNbBits) >> 16
+	bw.addBits(uint64(s.value), uint(nbBitsOut))
+	s.value = uint32(s.table.stateTable[int32(s.value>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (s *fseEncState) flush(bw *bitWriter) {
+	bw.addBits(uint64(s.value), s.table.accuracyLog)
+}
+
+// optimalTableLog picks an accuracy log suited to the amount of data.
+func optimalTableLog(maxLog uint, total int, maxSymbol int) uint {
+	log := maxLog
+	if total > 1 {
+		if bitsSrc := highBit(uint32(total-1)) - 2; int(bitsSrc) > 0 && bitsSrc < log {
+			log = bitsSrc
+		}
+	}
+	minBits := min(highBit(uint32(max(total, 1)))+1, highBit(uint32(max(maxSymbol, 1)))+2)
+	if log < minBits {
+		log = minBits
+	}
+	return min(max(log, 5), maxLog)
+}
+
+// normalizeCounts scales counts to sum to 1<<log, keeping every used symbol at
+// least 1 so it remains encodable.
+func normalizeCounts(counts []int, total int, log uint) []int16 {
+	tableSize := 1 << log
+	norm := make([]int16, len(counts))
+	sum := 0
+	largest := 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		n := int((int64(c)*int64(tableSize) + int64(total)/2) / int64(total))
+		if n < 1 {
+			n = 1
+		}
+		norm[s] = int16(n)
+		sum += n
+		if c > counts[largest] {
+			largest = s
+		}
+	}
+
+	for sum != tableSize {
+		if sum < tableSize {
+			norm[largest] += int16(tableSize - sum)
+			sum = tableSize
+			continue
+		}
+		// Take from the symbol whose share is most overstated relative to its count
+		best, bestScore := -1, 0.0
+		for s, n := range norm {
+			if n <= 1 {
+				continue
+			}
+			score := float64(n) / float64(counts[s])
+			if best < 0 || score > bestScore {
+				best, bestScore = s, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		take := min(int(norm[best])-1, sum-tableSize)
+		if take > 1 {
+			take = max(1, take/2)
+		}
+		norm[best] -= int16(take)
+		sum -= take
+	}
+	return norm
+}
+
+// writeNCount serializes normalized counts in the header format read by readNCount.
+func writeNCount(norm []int16, log uint) []byte {
+	maxSymbol := len(norm) - 1
+	for maxSymbol > 0 && norm[maxSymbol] == 0 {
+		maxSymbol--
+	}
+
+	var bw bitWriter
+	tableSize := int32(1 << log)
+	remaining := tableSize + 1
+	threshold := tableSize
+	nbBits := log + 1
+	bw.addBits(uint64(log-5), 4)
+
+	symbol := 0
+	previousIs0 := false
+	for symbol <= maxSymbol && remaining > 1 {
+		if previousIs0 {
+			start := symbol
+			for symbol <= maxSymbol && norm[symbol] == 0 {
+				symbol++
+			}
+			for symbol >= start+3 {
+				start += 3
+				bw.addBits(3, 2)
+			}
+			bw.addBits(uint64(symbol-start), 2)
+		}
+		count := int32(norm[symbol])
+		symbol++
+		maxValue := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += maxValue
+		}
+		n := nbBits
+		if count < maxValue {
+			n--
+		}
+		bw.addBits(uint64(count), n)
+		previousIs0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	return bw.flushBytes()
+}
@@ -0,0 +1,354 @@
+package zstd
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	huffMaxBits       = 11
+	huffMaxSymbols    = 256
+	huffWeightsMaxLog = 6
+)
+
+// huffDecodeEntry maps the next maxBits bits of a stream to a symbol.
+type huffDecodeEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+type huffDecodeTable struct {
+	maxBits uint
+	entries []huffDecodeEntry
+}
+
+// readHuffmanTable parses a Huffman tree description and returns the table and
+// the number of bytes consumed.
+func readHuffmanTable(in []byte) (*huffDecodeTable, int, error) {
+	if len(in) == 0 {
+		return nil, 0, errCorrupt
+	}
+	header := int(in[0])
+	var weights []uint8
+	consumed := 1
+
+	if header >= 128 {
+		// Direct representation: 4 bits per weight
+		n := header - 127
+		size := (n + 1) / 2
+		if len(in) < 1+size {
+			return nil, 0, errCorrupt
+		}
+		weights = make([]uint8, n)
+		for i := 0; i < n; i++ {
+			b := in[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 0x0f
+			}
+		}
+		consumed += size
+	} else {
+		// FSE-compressed weights, decoded with two interleaved states
+		if len(in) < 1+header {
+			return nil, 0, errCorrupt
+		}
+		data := in[1 : 1+header]
+		norm, log, n, err := readNCount(data, 255, huffWeightsMaxLog)
+		if err != nil {
+			return nil, 0, err
+		}
+		table, err := buildFSEDecodeTable(norm, log)
+		if err != nil {
+			return nil, 0, err
+		}
+		var br reverseBitReader
+		if err := br.init(data[n:]); err != nil {
+			return nil, 0, err
+		}
+		var s1, s2 fseState
+		s1.init(&br, table)
+		s2.init(&br, table)
+		for {
+			weights = append(weights, s1.symbol())
+			s1.update(&br)
+			if br.overflowed() {
+				weights = append(weights, s2.symbol())
+				break
+			}
+			weights = append(weights, s2.symbol())
+			s2.update(&br)
+			if br.overflowed() {
+				weights = append(weights, s1.symbol())
+				break
+			}
+			if len(weights) > huffMaxSymbols {
+				return nil, 0, errCorrupt
+			}
+		}
+		consumed += header
+	}
+
+	t, err := buildHuffDecodeTable(weights)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, consumed, nil
+}
+
+// buildHuffDecodeTable completes the weights with the implied last weight and
+// lays out the prefix codes.
+func buildHuffDecodeTable(weights []uint8) (*huffDecodeTable, error) {
+	if len(weights) >= huffMaxSymbols {
+		return nil, errCorrupt
+	}
+	var total uint32
+	for _, w := range weights {
+		if w > huffMaxBits {
+			return nil, errCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, errCorrupt
+	}
+	maxBits := highBit(total) + 1
+	rest := uint32(1)<<maxBits - total
+	if rest&(rest-1) != 0 || maxBits > huffMaxBits {
+		return nil, errCorrupt
+	}
+	weights = append(weights, uint8(highBit(rest)+1))
+
+	t := &huffDecodeTable{maxBits: maxBits, entries: make([]huffDecodeEntry, 1<<maxBits)}
+	pos := 0
+	for w := uint8(1); w <= uint8(maxBits); w++ {
+		for s, sw := range weights {
+			if sw != w {
+				continue
+			}
+			n := 1 << (w - 1)
+			e := huffDecodeEntry{symbol: uint8(s), nbBits: uint8(maxBits + 1 - uint(w))}
+			for i := 0; i < n; i++ {
+				t.entries[pos+i] = e
+			}
+			pos += n
+		}
+	}
+	return t, nil
+}
+
+// decodeStream decodes n literals from a single Huffman stream.
+func (t *huffDecodeTable) decodeStream(in []byte, out []byte) error {
+	var br reverseBitReader
+	if err := br.init(in); err != nil {
+		return err
+	}
+	for i := range out {
+		e := t.entries[br.peekBits(t.maxBits)]
+		out[i] = e.symbol
+		br.skipBits(uint(e.nbBits))
+	}
+	if !br.finished() {
+		return errCorrupt
+	}
+	return nil
+}
+
+// huffEncodeTable holds the code and length of every symbol.
+type huffEncodeTable struct {
+	codes   [huffMaxSymbols]uint16
+	nbBits  [huffMaxSymbols]uint8
+	maxBits uint
+	weights []uint8 // weights for symbols 0..maxSymbol
+}
+
+// buildHuffEncodeTable derives length-limited codes from literal counts. It
+// returns nil when fewer than two symbols are used.
+func buildHuffEncodeTable(counts *[huffMaxSymbols]int) *huffEncodeTable {
+	var symbols []int
+	for s, c := range counts {
+		if c > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) < 2 {
+		return nil
+	}
+
+	freqs := make([]int64, len(symbols))
+	for i, s := range symbols {
+		freqs[i] = int64(counts[s])
+	}
+	var lengths []uint8
+	for {
+		lengths = huffmanCodeLengths(freqs)
+		longest := uint8(0)
+		for _, l := range lengths {
+			longest = max(longest, l)
+		}
+		if longest <= huffMaxBits {
+			break
+		}
+		for i := range freqs {
+			freqs[i] = 1 + freqs[i]/2
+		}
+	}
+
+	t := &huffEncodeTable{}
+	for i, s := range symbols {
+		t.nbBits[s] = lengths[i]
+		t.maxBits = max(t.maxBits, uint(lengths[i]))
+	}
+	maxSymbol := symbols[len(symbols)-1]
+	t.weights = make([]uint8, maxSymbol+1)
+	for s := 0; s <= maxSymbol; s++ {
+		if t.nbBits[s] > 0 {
+			t.weights[s] = uint8(t.maxBits + 1 - uint(t.nbBits[s]))
+		}
+	}
+
+	// Assign codes in the same order the decoder lays out its table
+	pos := 0
+	for w := uint8(1); w <= uint8(t.maxBits); w++ {
+		for s, sw := range t.weights {
+			if sw != w {
+				continue
+			}
+			t.codes[s] = uint16(pos >> (w - 1))
+			pos += 1 << (w - 1)
+		}
+	}
+	return t
+}
+
+// huffmanCodeLengths runs a standard Huffman construction.
+func huffmanCodeLengths(freqs []int64) []uint8 {
+	n := len(freqs)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return freqs[order[a]] < freqs[order[b]] })
+
+	weight := make([]int64, 2*n-1)
+	parent := make([]int, 2*n-1)
+	copy(weight, freqs)
+	internal := make([]int, 0, n-1)
+	li, ii := 0, 0
+	pick := func() int {
+		if li < n && (ii >= len(internal) || weight[order[li]] <= weight[internal[ii]]) {
+			li++
+			return order[li-1]
+		}
+		ii++
+		return internal[ii-1]
+	}
+	for next := n; next < 2*n-1; next++ {
+		a, b := pick(), pick()
+		weight[next] = weight[a] + weight[b]
+		parent[a], parent[b] = next, next
+		internal = append(internal, next)
+	}
+	depth := make([]uint8, 2*n-1)
+	for node := 2*n - 3; node >= 0; node-- {
+		depth[node] = depth[parent[node]] + 1
+	}
+	return depth[:n]
+}
+
+// writeDescription serializes the table as a Huffman tree description.
+func (t *huffEncodeTable) writeDescription() ([]byte, error) {
+	// The last weight is implied by the others
+	weights := t.weights[:len(t.weights)-1]
+
+	var best []byte
+	if len(weights) <= 128 {
+		best = make([]byte, 1+(len(weights)+1)/2)
+		best[0] = byte(127 + len(weights))
+		for i, w := range weights {
+			if i%2 == 0 {
+				best[1+i/2] |= w << 4
+			} else {
+				best[1+i/2] |= w
+			}
+		}
+	}
+
+	if compressed := compressWeights(weights); compressed != nil && len(compressed) < 128 && (best == nil || len(compressed)+1 < len(best)) {
+		best = append([]byte{byte(len(compressed))}, compressed...)
+	}
+	if best == nil {
+		return nil, fmt.Errorf("zstd: cannot describe Huffman table")
+	}
+	return best, nil
+}
+
+// compressWeights FSE-codes the weights with two interleaved states.
+func compressWeights(weights []uint8) []byte {
+	if len(weights) < 2 {
+		return nil
+	}
+	var counts [huffMaxBits + 1]int
+	maxSymbol := 0
+	for _, w := range weights {
+		counts[w]++
+		maxSymbol = max(maxSymbol, int(w))
+	}
+	for _, c := range counts {
+		if c == len(weights) {
+			// A single repeated weight cannot be FSE coded
+			return nil
+		}
+	}
+
+	log := optimalTableLog(huffWeightsMaxLog, len(weights), maxSymbol)
+	norm := normalizeCounts(counts[:maxSymbol+1], len(weights), log)
+	table := buildFSEEncodeTable(norm, log)
+
+	var bw bitWriter
+	var s1, s2 fseEncState
+	i := len(weights)
+	if len(weights)&1 != 0 {
+		s1.init(table, weights[i-1])
+		s2.init(table, weights[i-2])
+		s1.encode(&bw, weights[i-3])
+		i -= 3
+	} else {
+		s2.init(table, weights[i-1])
+		s1.init(table, weights[i-2])
+		i -= 2
+	}
+	for i > 0 {
+		s2.encode(&bw, weights[i-1])
+		s1.encode(&bw, weights[i-2])
+		i -= 2
+	}
+	s2.flush(&bw)
+	s1.flush(&bw)
+
+	header := writeNCount(norm, log)
+	return append(header, bw.close()...)
+}
+
+// encodeStream Huffman codes src into a single backwards-read stream.
+func (t *huffEncodeTable) encodeStream(src []byte) []byte {
+	var bw bitWriter
+	bw.out = make([]byte, 0, len(src))
+	for i := len(src) - 1; i >= 0; i-- {
+		s := src[i]
+		bw.addBits(uint64(t.codes[s]), uint(t.nbBits[s]))
+	}
+	return bw.close()
+}
+
+// estimateSize returns the coded size in bytes of literals with these counts.
+func (t *huffEncodeTable) estimateSize(counts *[huffMaxSymbols]int) int {
+	bits := 0
+	for s, c := range counts {
+		bits += c * int(t.nbBits[s])
+	}
+	return bits / 8
+}
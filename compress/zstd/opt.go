@@ -0,0 +1,341 @@
+package zstd
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// Prices are in 1/256 bits.
+	priceShift = 8
+	// optNum bounds how far ahead one optimal parse plans, and optMaxLen the
+	// match lengths it considers; longer matches are cut into pieces.
+	optNum    = 1 << 12
+	optMaxLen = 1 << 12
+)
+
+// optStats holds symbol frequencies seen in earlier sequences and the prices
+// derived from them, in the style of the reference encoder's optimal parser.
+type optStats struct {
+	lit [256]int
+	ll  [maxLLCode + 1]int
+	ml  [maxMLCode + 1]int
+	of  [maxOFCode + 1]int
+	// ready is set once the statistics describe real sequences
+	ready bool
+
+	litPrice [256]int
+	llPrice  [maxLLCode + 1]int
+	mlPrice  [maxMLCode + 1]int
+	ofPrice  [maxOFCode + 1]int
+}
+
+// optNode is one position of the parse: the cheapest way found to reach it.
+type optNode struct {
+	price  int
+	len    int // length of the match ending here, 0 for a literal
+	dist   uint32
+	litLen uint32 // literals since the last match
+	reps   [3]uint32
+	known  int
+}
+
+type optimal struct {
+	stats   optStats
+	nodes   []optNode
+	matches []match
+	path    []optNode
+}
+
+func newOptimal() *optimal {
+	return &optimal{nodes: make([]optNode, optNum+optMaxLen+1)}
+}
+
+// log2Fractions holds log2(1+i/256) in 1/256 bits.
+var log2Fractions [256]int
+
+func init() {
+	for i := range log2Fractions {
+		log2Fractions[i] = int(math.Log2(1+float64(i)/256)*(1<<priceShift) + 0.5)
+	}
+}
+
+// log2Price approximates log2(x) in 1/256 bits for x > 0.
+func log2Price(x int) int {
+	n := bits.Len(uint(x)) - 1
+	var frac int
+	if n >= 8 {
+		frac = x >> (n - 8) & 255
+	} else {
+		frac = x << (8 - n) & 255
+	}
+	return n<<priceShift + log2Fractions[frac]
+}
+
+// symbolPrices fills prices with the cost of each symbol given its frequency,
+// at most maxBits since the entropy coder cannot spend more on a symbol.
+func symbolPrices(prices []int, freqs []int, maxBits uint) {
+	sum := 0
+	for _, f := range freqs {
+		sum += f
+	}
+	total := log2Price(sum)
+	for i, f := range freqs {
+		prices[i] = min(total-log2Price(max(f, 1)), int(maxBits)<<priceShift)
+	}
+}
+
+func (s *optStats) updatePrices() {
+	symbolPrices(s.litPrice[:], s.lit[:], huffMaxBits)
+	symbolPrices(s.llPrice[:], s.ll[:], maxLLLog)
+	symbolPrices(s.mlPrice[:], s.ml[:], maxMLLog)
+	symbolPrices(s.ofPrice[:], s.of[:], maxOFLog)
+}
+
+// seed starts the statistics afresh from the literals and sequences of a
+// quicker parse.
+func (s *optStats) seed(lits []byte, seqs []sequence) {
+	for _, freqs := range [][]int{s.lit[:], s.ll[:], s.ml[:], s.of[:]} {
+		for i := range freqs {
+			freqs[i] = 1
+		}
+	}
+	s.recordLiterals(lits)
+	for _, seq := range seqs {
+		s.record(seq)
+	}
+	s.updatePrices()
+}
+
+// decay halves the weight of what the statistics have seen so far, so later
+// blocks are priced mostly by their own recent sequences.
+func (s *optStats) decay() {
+	for _, freqs := range [][]int{s.lit[:], s.ll[:], s.ml[:], s.of[:]} {
+		for i := range freqs {
+			freqs[i] = freqs[i]>>1 + 1
+		}
+	}
+	s.updatePrices()
+}
+
+func (s *optStats) recordLiterals(lits []byte) {
+	for _, c := range lits {
+		s.lit[c]++
+	}
+}
+
+// record counts the codes of a chosen sequence.
+func (s *optStats) record(seq sequence) {
+	s.ll[llCode(seq.litLen)]++
+	s.ml[mlCode(seq.matchLen)]++
+	s.of[highBit(seq.offset)]++
+}
+
+func (s *optStats) litLenPrice(litLen uint32) int {
+	code := llCode(litLen)
+	return s.llPrice[code] + int(llBits[code])<<priceShift
+}
+
+// matchPrice is the price of a match's offset and length, not its literals.
+func (s *optStats) matchPrice(offset, matchLen uint32) int {
+	ofCode := highBit(offset)
+	mlc := mlCode(matchLen)
+	return s.ofPrice[ofCode] + int(ofCode)<<priceShift + s.mlPrice[mlc] + int(mlBits[mlc])<<priceShift
+}
+
+// findMatches appends the matches at pos that are longer than every one
+// before them, up to optMaxLen: first at the repeat offsets the decoder will
+// hold after n, then from the hash chains and the long distance table.
+func (e *encoder) findMatches(pos int, n *optNode, out []match) []match {
+	e.insertUpTo(pos)
+	if pos+minMatch > len(e.hist) {
+		return out
+	}
+	limit := min(optMaxLen, len(e.hist)-pos)
+	best := minMatch - 1
+	consider := func(cand int) bool {
+		l := min(matchLen(e.hist, cand, pos), limit)
+		if l > best {
+			best = l
+			out = append(out, match{length: l, dist: pos - cand})
+		}
+		return best >= e.p.niceLen || best == limit
+	}
+
+	for _, dist := range repeatCandidates(n.reps, n.known, n.litLen) {
+		if dist != 0 && int(dist) <= pos && int(dist) <= e.window && consider(pos-int(dist)) {
+			return out
+		}
+	}
+
+	mask := len(e.chain) - 1
+	cand := int(e.hashTable[e.hash(pos)]) - 1
+	for depth := e.p.depth; depth > 0 && cand >= 0; depth-- {
+		d := pos - cand
+		if d > e.window || d > len(e.chain) {
+			break
+		}
+		if e.hist[cand+best] == e.hist[pos+best] && consider(cand) {
+			return out
+		}
+		cand = int(e.chain[cand&mask]) - 1
+	}
+
+	if e.ldmTable != nil && pos+8 <= len(e.hist) {
+		if c := int(e.ldmTable[e.ldmHash(pos)]) - 1; c >= 0 && pos-c <= e.window {
+			consider(c)
+		}
+	}
+	return out
+}
+
+// optimalSequences parses hist[start:] into literals and sequences, choosing
+// the cheapest path by the prices of earlier blocks.
+func (e *encoder) optimalSequences(start int) {
+	if !e.opt.stats.ready {
+		e.seedStats(e.hist[start:])
+	}
+	e.opt.stats.decay()
+	e.parseOptimal(start)
+}
+
+// seedStats prices the first block, which has no earlier blocks, from a lazy
+// parse of it alone.
+func (e *encoder) seedStats(src []byte) {
+	p := e.p
+	p.windowLog, p.hashLog, p.chainLog, p.optimal = 17, 17, 17, false
+	s := newEncoder(p, false)
+	s.hist = append(s.hist, src...)
+	s.findSequences(0)
+	e.opt.stats.seed(s.lits, s.seqs)
+	e.opt.stats.ready = true
+}
+
+func (e *encoder) parseOptimal(start int) {
+	o := e.opt
+	st := &o.stats
+	e.lits = e.lits[:0]
+	e.seqs = e.seqs[:0]
+	end := len(e.hist)
+	litStart := start
+	for anchor := start; anchor+minMatch <= end; {
+		lenEnd := e.planOptimal(anchor, uint32(anchor-litStart))
+
+		// Walk back from the end of the plan, then emit its matches forwards
+		o.path = o.path[:0]
+		for i := lenEnd; i > 0; {
+			n := o.nodes[i]
+			if n.len == 0 {
+				break
+			}
+			i -= int(n.litLen) + n.len
+			o.path = append(o.path, optNode{len: n.len, dist: n.dist, litLen: uint32(i)})
+		}
+		for i := len(o.path) - 1; i >= 0; i-- {
+			m := o.path[i]
+			pos := anchor + int(m.litLen)
+			litLen := uint32(pos - litStart)
+			seq := sequence{litLen: litLen, matchLen: uint32(m.len), offset: e.offsetValue(m.dist, litLen)}
+			e.lits = append(e.lits, e.hist[litStart:pos]...)
+			e.seqs = append(e.seqs, seq)
+			st.recordLiterals(e.hist[litStart:pos])
+			st.record(seq)
+			litStart = pos + m.len
+		}
+		anchor += lenEnd
+		st.updatePrices()
+	}
+	e.lits = append(e.lits, e.hist[litStart:end]...)
+	e.insertUpTo(end - minMatch + 1)
+}
+
+// planOptimal finds the cheapest way to code the input from anchor, where
+// litLen literals are already pending, and returns how far the plan reaches.
+// Each node records the last match before it and the literals since, so the
+// plan is read back from its end.
+func (e *encoder) planOptimal(anchor int, litLen uint32) int {
+	o := e.opt
+	st := &o.stats
+	nodes := o.nodes
+	end := len(e.hist)
+	nodes[0] = optNode{price: st.litLenPrice(litLen), litLen: litLen, reps: e.reps, known: e.known}
+	lenEnd := 0
+	extend := func(to int) {
+		for ; lenEnd < to; lenEnd++ {
+			nodes[lenEnd+1] = optNode{price: math.MaxInt}
+		}
+	}
+
+	for cur := 0; cur == 0 || cur < min(lenEnd, optNum); cur++ {
+		pos := anchor + cur
+		n := nodes[cur]
+
+		// A literal
+		next := cur + 1
+		extend(next)
+		lit := n.litLen + 1
+		price := n.price + st.litPrice[e.hist[pos]] + st.litLenPrice(lit) - st.litLenPrice(n.litLen)
+		if price <= nodes[next].price {
+			prev := nodes[next]
+			nodes[next] = n
+			nodes[next].litLen, nodes[next].price = lit, price
+			// The match the literal displaces may still be the better start
+			// for the literal after it, since it pays for its literal length
+			// up front.
+			if prev.len > 0 && prev.litLen == 0 && pos+1 < end {
+				with1 := prev.price + st.litPrice[e.hist[pos+1]] + st.litLenPrice(1) - st.litLenPrice(0)
+				withMore := price + st.litPrice[e.hist[pos+1]] + st.litLenPrice(lit+1) - st.litLenPrice(lit)
+				extend(next + 1)
+				if with1 < withMore && with1 < nodes[next+1].price {
+					prev.litLen, prev.price = 1, with1
+					nodes[next+1] = prev
+				}
+			}
+		}
+		if pos+minMatch > end {
+			continue
+		}
+
+		// The node's price already covers this sequence's literal length, so
+		// each match length is priced once, with the closest match reaching it
+		base := n.price + st.litLenPrice(0)
+		o.matches = e.findMatches(pos, &n, o.matches[:0])
+		l := minMatch
+		for _, m := range o.matches {
+			dist := uint32(m.dist)
+			offset, reps, known := nextOffset(n.reps, n.known, dist, n.litLen)
+			extend(cur + m.length)
+			for ; l <= m.length; l++ {
+				price := base + st.matchPrice(offset, uint32(l))
+				if price < nodes[cur+l].price {
+					nodes[cur+l] = optNode{price: price, len: l, dist: dist, reps: reps, known: known}
+				}
+			}
+		}
+		if longest := l - 1; longest >= e.p.niceLen {
+			// Take a long match as it is rather than plan past it
+			return cur + longest
+		}
+	}
+	return lenEnd
+}
+
+// repeatCandidates lists the distances a repeat code can refer to after
+// litLen literals, given the repeat offsets the decoder holds.
+func repeatCandidates(reps [3]uint32, known int, litLen uint32) [3]uint32 {
+	var out [3]uint32
+	if litLen > 0 {
+		copy(out[:known], reps[:known])
+		return out
+	}
+	if known >= 2 {
+		out[0] = reps[1]
+	}
+	if known >= 3 {
+		out[1] = reps[2]
+	}
+	if known >= 1 && reps[0] > 1 {
+		out[2] = reps[0] - 1
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package zstd
+
+// Literal length, match length and offset codes, with their baselines and extra bits.
+var (
+	llBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	llBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	mlBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	mlBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+const (
+	maxLLCode = 35
+	maxMLCode = 52
+	maxOFCode = 31
+	maxLLLog  = 9
+	maxMLLog  = 9
+	maxOFLog  = 8
+)
+
+// Default distributions used by the "predefined" table mode.
+var (
+	llDefaultNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	mlDefaultNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	ofDefaultNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+
+	llDefaultLog uint = 6
+	mlDefaultLog uint = 6
+	ofDefaultLog uint = 5
+
+	llDefaultTable = mustDecodeTable(llDefaultNorm, llDefaultLog)
+	mlDefaultTable = mustDecodeTable(mlDefaultNorm, mlDefaultLog)
+	ofDefaultTable = mustDecodeTable(ofDefaultNorm, ofDefaultLog)
+
+	llDefaultEncTable = buildFSEEncodeTable(llDefaultNorm, llDefaultLog)
+	mlDefaultEncTable = buildFSEEncodeTable(mlDefaultNorm, mlDefaultLog)
+	ofDefaultEncTable = buildFSEEncodeTable(ofDefaultNorm, ofDefaultLog)
+)
+
+func mustDecodeTable(norm []int16, log uint) *fseDecodeTable {
+	t, err := buildFSEDecodeTable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Table compression modes in the sequences section header.
+const (
+	modePredefined = 0
+	modeRLE        = 1
+	modeCompressed = 2
+	modeRepeat     = 3
+)
+
+// sequence is one literal run followed by a match. offset holds the raw offset
+// value: 1-3 are repeat codes, larger values are the distance plus 3.
+type sequence struct {
+	litLen   uint32
+	matchLen uint32
+	offset   uint32
+}
+
+func llCode(litLen uint32) uint8 {
+	if litLen < 16 {
+		return uint8(litLen)
+	}
+	if litLen >= 65536 {
+		return 35
+	}
+	code := uint8(16)
+	for code < 35 && llBase[code+1] <= litLen {
+		code++
+	}
+	return code
+}
+
+func mlCode(matchLen uint32) uint8 {
+	base := matchLen - 3
+	if base < 32 {
+		return uint8(base)
+	}
+	if matchLen >= 65539 {
+		return 52
+	}
+	code := uint8(32)
+	for code < 52 && mlBase[code+1] <= matchLen {
+		code++
+	}
+	return code
+}
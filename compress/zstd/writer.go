@@ -0,0 +1,262 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Levels range from 1 (fastest) to 22; levels above 19 use very large windows.
+const (
+	BestSpeed          = 1
+	BestCompression    = 22
+	DefaultCompression = -1
+	defaultLevel       = 3
+)
+
+// WriterOptions configures a Writer.
+type WriterOptions struct {
+	// Level is 1-22, or 0 or DefaultCompression for the default.
+	Level int
+	// Threads compresses independent jobs in parallel when greater than one.
+	Threads int
+	// Long enables a 128 MiB window with long distance matching.
+	Long bool
+	// Dict primes the compressor; the same dictionary is needed to decompress.
+	Dict *Dict
+}
+
+// Writer compresses data into a single zstd frame with a content checksum.
+type Writer struct {
+	w    io.Writer
+	opts WriterOptions
+	p    levelParams
+	hash *xxhash64
+
+	enc       *encoder // single-threaded state
+	pending   []byte
+	chunk     int
+	headerOut bool
+	closed    bool
+	err       error
+
+	// Multithreaded state
+	jobSize int
+	overlap int
+	tail    []byte
+	jobs    []chan []byte
+	started int
+}
+
+// NewWriter returns a Writer using the default level.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return NewWriterOptions(w, WriterOptions{})
+}
+
+// NewWriterLevel returns a Writer for the given level.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	return NewWriterOptions(w, WriterOptions{Level: level})
+}
+
+// NewWriterOptions returns a Writer with explicit settings.
+func NewWriterOptions(w io.Writer, opts WriterOptions) (*Writer, error) {
+	if opts.Level == 0 || opts.Level == DefaultCompression {
+		opts.Level = defaultLevel
+	}
+	if opts.Level < BestSpeed || opts.Level > BestCompression {
+		return nil, fmt.Errorf("zstd: invalid compression level %d (expected %d-%d)", opts.Level, BestSpeed, BestCompression)
+	}
+	if opts.Threads < 0 {
+		return nil, fmt.Errorf("zstd: invalid thread count %d", opts.Threads)
+	}
+
+	z := &Writer{w: w, opts: opts, p: levels[opts.Level], hash: newXXHash64()}
+	if opts.Long && z.p.windowLog < longWindowLog {
+		z.p.windowLog = longWindowLog
+	}
+	if opts.Threads > 1 {
+		window := 1 << z.p.windowLog
+		z.jobSize = max(1<<20, 2*window)
+		z.overlap = window >> 3
+		z.chunk = z.jobSize
+	} else {
+		z.chunk = maxBlockSize
+	}
+	return z, nil
+}
+
+func (z *Writer) newEncoder(first bool) *encoder {
+	e := newEncoder(z.p, z.opts.Long)
+	if first && z.opts.Dict != nil {
+		e.prime(z.opts.Dict.content)
+		e.reps = z.opts.Dict.reps
+	} else if !first {
+		e.known = 0
+	}
+	return e
+}
+
+// writeHeader writes the frame header. A frame whose whole content is known
+// is written as a single segment with its size.
+func (z *Writer) writeHeader(single bool, size int) error {
+	hdr := binary.LittleEndian.AppendUint32(nil, frameMagic)
+	fhd := byte(0x04) // content checksum
+	var dictID uint32
+	if z.opts.Dict != nil {
+		dictID = z.opts.Dict.ID
+	}
+	switch {
+	case dictID == 0:
+	case dictID < 1<<8:
+		fhd |= 1
+	case dictID < 1<<16:
+		fhd |= 2
+	default:
+		fhd |= 3
+	}
+
+	var tail []byte
+	if single {
+		fhd |= 0x20
+		switch {
+		case size < 256:
+			tail = append(tail, byte(size))
+		case size < 65536+256:
+			fhd |= 1 << 6
+			tail = binary.LittleEndian.AppendUint16(tail, uint16(size-256))
+		case int64(size) < 1<<32:
+			fhd |= 2 << 6
+			tail = binary.LittleEndian.AppendUint32(tail, uint32(size))
+		default:
+			fhd |= 3 << 6
+			tail = binary.LittleEndian.AppendUint64(tail, uint64(size))
+		}
+	}
+
+	hdr = append(hdr, fhd)
+	if !single {
+		hdr = append(hdr, byte(z.p.windowLog-minWindowLog)<<3)
+	}
+	for i := 0; i < [4]int{0, 1, 2, 4}[fhd&3]; i++ {
+		hdr = append(hdr, byte(dictID>>(8*i)))
+	}
+	hdr = append(hdr, tail...)
+	z.headerOut = true
+	_, err := z.w.Write(hdr)
+	return err
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, fmt.Errorf("zstd: write to closed writer")
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	z.hash.Write(p)
+	total := len(p)
+	for len(p) > 0 {
+		n := min(len(p), z.chunk+1-len(z.pending))
+		z.pending = append(z.pending, p[:n]...)
+		p = p[n:]
+		// Hold one byte back so the final block is only written by Close
+		if len(z.pending) > z.chunk {
+			if z.err = z.flushChunk(false); z.err != nil {
+				return total - len(p), z.err
+			}
+		}
+	}
+	return total, nil
+}
+
+// flushChunk compresses the pending chunk, or all of it when last.
+func (z *Writer) flushChunk(last bool) error {
+	if !z.headerOut {
+		if err := z.writeHeader(false, 0); err != nil {
+			return err
+		}
+	}
+	n := len(z.pending)
+	if !last {
+		n = z.chunk
+	}
+	data := z.pending[:n]
+
+	if z.opts.Threads > 1 {
+		z.startJob(data, last)
+	} else {
+		if z.enc == nil {
+			z.enc = z.newEncoder(true)
+		}
+		if _, err := z.w.Write(z.enc.compressBlock(nil, data, last)); err != nil {
+			return err
+		}
+	}
+	z.pending = append(z.pending[:0], z.pending[n:]...)
+	if last {
+		return z.drainJobs(0)
+	}
+	return z.drainJobs(z.opts.Threads)
+}
+
+// startJob compresses data in the background, primed with the end of the
+// previous job's input. The dictionary only applies to the first job, since it
+// precedes the start of the frame.
+func (z *Writer) startJob(data []byte, last bool) {
+	prefix := z.tail
+	input := append([]byte(nil), data...)
+	joined := append(append([]byte(nil), prefix...), input...)
+	z.tail = joined[max(0, len(joined)-z.overlap):]
+
+	result := make(chan []byte, 1)
+	z.jobs = append(z.jobs, result)
+	enc := z.newEncoder(z.started == 0)
+	z.started++
+	go func() {
+		if len(prefix) > 0 {
+			enc.prime(prefix)
+		}
+		var out []byte
+		for len(input) > 0 || out == nil {
+			n := min(len(input), maxBlockSize)
+			out = enc.compressBlock(out, input[:n], last && n == len(input))
+			input = input[n:]
+		}
+		result <- out
+	}()
+}
+
+// drainJobs writes finished jobs in order until at most limit are running.
+func (z *Writer) drainJobs(limit int) error {
+	for len(z.jobs) > limit {
+		out := <-z.jobs[0]
+		z.jobs = z.jobs[1:]
+		if _, err := z.w.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the remaining data and the checksum. It does not close the
+// underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	if !z.headerOut {
+		if z.err = z.writeHeader(true, len(z.pending)); z.err != nil {
+			return z.err
+		}
+	}
+	if z.err = z.flushChunk(true); z.err != nil {
+		return z.err
+	}
+	_, z.err = z.w.Write(binary.LittleEndian.AppendUint32(nil, uint32(z.hash.Sum64())))
+	return z.err
+}
@@ -0,0 +1,116 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxHash64 is used for the frame content checksum and dictionary IDs.
+const (
+	prime64v1 uint64 = 11400714785074694791
+	prime64v2 uint64 = 14029467366897019727
+	prime64v3 uint64 = 1609587929392839161
+	prime64v4 uint64 = 9650029242287828579
+	prime64v5 uint64 = 2870177450012600261
+)
+
+type xxhash64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int
+}
+
+func newXXHash64() *xxhash64 {
+	h := &xxhash64{}
+	h.reset()
+	return h
+}
+
+func (h *xxhash64) reset() {
+	h.v1 = prime64v1
+	h.v1 += prime64v2
+	h.v2 = prime64v2
+	h.v3 = 0
+	h.v4 = 0
+	h.v4 -= prime64v1
+	h.total = 0
+	h.n = 0
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * prime64v2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64v1
+}
+
+func xxMerge(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*prime64v1 + prime64v4
+}
+
+func (h *xxhash64) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n+len(p) < 32 {
+		h.n += copy(h.mem[h.n:], p)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], p)
+		p = p[c:]
+		h.v1 = xxRound(h.v1, binary.LittleEndian.Uint64(h.mem[0:]))
+		h.v2 = xxRound(h.v2, binary.LittleEndian.Uint64(h.mem[8:]))
+		h.v3 = xxRound(h.v3, binary.LittleEndian.Uint64(h.mem[16:]))
+		h.v4 = xxRound(h.v4, binary.LittleEndian.Uint64(h.mem[24:]))
+		h.n = 0
+	}
+	for len(p) >= 32 {
+		h.v1 = xxRound(h.v1, binary.LittleEndian.Uint64(p[0:]))
+		h.v2 = xxRound(h.v2, binary.LittleEndian.Uint64(p[8:]))
+		h.v3 = xxRound(h.v3, binary.LittleEndian.Uint64(p[16:]))
+		h.v4 = xxRound(h.v4, binary.LittleEndian.Uint64(p[24:]))
+		p = p[32:]
+	}
+	h.n = copy(h.mem[:], p)
+	return n, nil
+}
+
+func (h *xxhash64) Sum64() uint64 {
+	var acc uint64
+	if h.total >= 32 {
+		acc = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) + bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		acc = xxMerge(acc, h.v1)
+		acc = xxMerge(acc, h.v2)
+		acc = xxMerge(acc, h.v3)
+		acc = xxMerge(acc, h.v4)
+	} else {
+		acc = prime64v5
+	}
+	acc += h.total
+
+	p := h.mem[:h.n]
+	for len(p) >= 8 {
+		k := xxRound(0, binary.LittleEndian.Uint64(p))
+		acc ^= k
+		acc = bits.RotateLeft64(acc, 27)*prime64v1 + prime64v4
+		p = p[8:]
+	}
+	if len(p) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(p)) * prime64v1
+		acc = bits.RotateLeft64(acc, 23)*prime64v2 + prime64v3
+		p = p[4:]
+	}
+	for _, b := range p {
+		acc ^= uint64(b) * prime64v5
+		acc = bits.RotateLeft64(acc, 11) * prime64v1
+	}
+
+	acc ^= acc >> 33
+	acc *= prime64v2
+	acc ^= acc >> 29
+	acc *= prime64v3
+	acc ^= acc >> 32
+	return acc
+}
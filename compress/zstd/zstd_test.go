@@ -0,0 +1,231 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testInput struct {
+	name string
+	data []byte
+}
+
+// testInputs returns data that exercises raw, RLE and compressed blocks,
+// with and without usable Huffman tables.
+func testInputs() []testInput {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 200<<10)
+	rng.Read(random)
+
+	var all []byte
+	for i := 0; i < 64; i++ {
+		for b := 0; b < 256; b++ {
+			all = append(all, byte(b))
+		}
+	}
+	// Several 128 KiB blocks
+	var text bytes.Buffer
+	for i := 1; text.Len() < 600<<10; i++ {
+		fmt.Fprintf(&text, "line %d of the text, which repeats with small changes\n", i)
+	}
+
+	return []testInput{
+		{"empty", nil},
+		{"one byte", []byte{'x'}},
+		{"long run", bytes.Repeat([]byte{'a'}, 1<<20)},
+		{"all byte values", all},
+		{"incompressible", random},
+		{"text", text.Bytes()},
+	}
+}
+
+// seq returns the output of "seq 1 n".
+func seq(n int) []byte {
+	var b bytes.Buffer
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.Bytes()
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func decompress(data []byte, dicts ...*Dict) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), dicts...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	dict, err := ParseDict(readFixture(t, "dict"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := map[string]WriterOptions{
+		"level 1":    {Level: 1},
+		"level 3":    {Level: 3},
+		"level 19":   {Level: 19},
+		"threads":    {Level: 3, Threads: 4},
+		"long":       {Level: 3, Long: true},
+		"dictionary": {Level: 3, Dict: dict},
+	}
+	for _, in := range testInputs() {
+		for name, opts := range options {
+			t.Run(in.name+"/"+name, func(t *testing.T) {
+				var buf bytes.Buffer
+				w, err := NewWriterOptions(&buf, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(in.data); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				var dicts []*Dict
+				if opts.Dict != nil {
+					dicts = append(dicts, opts.Dict)
+				}
+				got, err := decompress(buf.Bytes(), dicts...)
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				if !bytes.Equal(got, in.data) {
+					t.Fatalf("round trip of %d bytes returned %d different bytes", len(in.data), len(got))
+				}
+			})
+		}
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opts := range []WriterOptions{{Level: 23}, {Level: -2}, {Threads: -1}} {
+		if _, err := NewWriterOptions(io.Discard, opts); err == nil {
+			t.Errorf("options %+v were accepted", opts)
+		}
+	}
+}
+
+// The fixtures were made by zstd 1.5.6, with dict trained on 500 records
+// like record.json:
+//
+//	zstd --train samples/* --maxdict=2048 -o dict
+//	zstd -19 -D dict record.json -o record.json.zst
+//	(printf 'first frame\n' | zstd; printf '\x50\x2a\x4d\x18\x05\x00\x00\x00skip!'; seq 1 20000 | zstd -19 --no-check) > multiframe.zst
+//	seq 1 30000 | zstd -19 > blocks.zst
+//	zstd -c random.bin > random.zst
+//	head -c 300000 /dev/zero | zstd > zeros.zst
+func TestDecodeFiles(t *testing.T) {
+	tests := map[string][]byte{
+		"multiframe.zst": append([]byte("first frame\n"), seq(20000)...),
+		"blocks.zst":     seq(30000),
+		"random.zst":     readFixture(t, "random.bin"),
+		"zeros.zst":      make([]byte, 300000),
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := decompress(readFixture(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestDecodeWithDictionary(t *testing.T) {
+	dict, err := ParseDict(readFixture(t, "dict"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := readFixture(t, "record.json.zst")
+	got, err := decompress(compressed, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := readFixture(t, "record.json"); !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if _, err := decompress(compressed); err == nil {
+		t.Error("frame was decoded without its dictionary")
+	}
+}
+
+func TestTrainDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 500; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"id": %d, "name": "user%d", "active": %t, "tags": ["alpha", "beta"]}`, i, i, i%2 == 1)))
+	}
+	raw, err := TrainDict(samples, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) > 1024 {
+		t.Fatalf("dictionary of %d bytes exceeds the requested size", len(raw))
+	}
+	dict, err := ParseDict(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriterOptions(&buf, WriterOptions{Dict: dict})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(samples[42]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decompress(buf.Bytes(), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, samples[42]) {
+		t.Fatalf("got %q, want %q", got, samples[42])
+	}
+}
+
+// TestCorruptInput checks that damaged files fail with an error rather than
+// a panic. Frames with a checksum catch any change to the data.
+func TestCorruptInput(t *testing.T) {
+	for _, name := range []string{"blocks.zst", "random.zst", "zeros.zst"} {
+		t.Run(name, func(t *testing.T) {
+			data := readFixture(t, name)
+			step := len(data)/50 + 1
+			for n := 0; n < len(data); n += step {
+				if _, err := decompress(data[:n]); err == nil {
+					t.Errorf("file truncated to %d bytes was accepted", n)
+				}
+			}
+			for i := 0; i < len(data); i += step/2 + 1 {
+				damaged := bytes.Clone(data)
+				damaged[i] ^= 0x55
+				if _, err := decompress(damaged); err == nil {
+					t.Errorf("file with byte %d changed was accepted", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Package ar reads and writes Unix ar archives, as used for static libraries
+// and as the outer layer of Debian packages. Both the GNU and the BSD long
+// name extensions are supported.
+package ar
+
+import (
+	"fmt"
+	"time"
+)
+
+// Format selects how names longer than the 16 byte header field are stored.
+type Format int
+
+const (
+	// FormatGNU keeps long names in a "//" table at the start of the archive.
+	FormatGNU Format = iota
+	// FormatBSD stores long names as "#1/<len>" followed by the name in the data.
+	FormatBSD
+)
+
+// ParseFormat maps a format name to a Format.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "", "gnu":
+		return FormatGNU, nil
+	case "bsd":
+		return FormatBSD, nil
+	}
+	return 0, fmt.Errorf("unknown ar format %q (expected gnu or bsd)", name)
+}
+
+const (
+	magic      = "!<arch>\n"
+	headerSize = 60
+	fileMagic  = "`\n"
+)
+
+// Header describes one archive member.
+type Header struct {
+	Name    string
+	ModTime time.Time
+	Uid     int
+	Gid     int
+	Mode    int64 // Unix mode bits, usually 0100644
+	Size    int64
+}
@@ -0,0 +1,189 @@
+package ar
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type member struct {
+	Name string
+	Data string
+}
+
+func readArchive(data []byte) ([]member, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var members []member
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return members, err
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return members, err
+		}
+		if int64(len(content)) != h.Size {
+			return members, errors.New("size mismatch")
+		}
+		members = append(members, member{h.Name, string(content)})
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The fixtures were made with GNU ar 2.40 and bsdtar 3.7, where answer.o
+// is compiled from a function returning 42:
+//
+//	ar rcsU gnu.a short.txt odd.txt a-very-long-member-name.txt answer.o
+//	bsdtar -cf bsd.a --format=arbsd short.txt odd.txt a-very-long-member-name.txt
+var fixtureMembers = []member{
+	{"short.txt", "hello\n"},
+	{"odd.txt", "odd"},
+	{"a-very-long-member-name.txt", "a member whose name does not fit the header\n"},
+}
+
+func TestReadGNU(t *testing.T) {
+	members, err := readArchive(readFixture(t, "gnu.a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The symbol index and long name table are not members
+	if len(members) != 4 {
+		t.Fatalf("got %d members, want 4", len(members))
+	}
+	if !reflect.DeepEqual(members[:3], fixtureMembers) {
+		t.Errorf("got %q, want %q", members[:3], fixtureMembers)
+	}
+	if members[3].Name != "answer.o" || !bytes.HasPrefix([]byte(members[3].Data), []byte("\x7fELF")) {
+		t.Errorf("got member %q, want the object file answer.o", members[3].Name)
+	}
+}
+
+func TestReadBSD(t *testing.T) {
+	members, err := readArchive(readFixture(t, "bsd.a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, fixtureMembers) {
+		t.Errorf("got %q, want %q", members, fixtureMembers)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	members := append([]member{{"empty", ""}, {"with space", "x"}}, fixtureMembers...)
+	for _, format := range []Format{FormatGNU, FormatBSD} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := make([]IndexEntry, len(members))
+		for i, m := range members {
+			entries[i] = IndexEntry{Name: m.Name, Size: int64(len(m.Data))}
+		}
+		entries[1].Symbols = []string{"main", "helper"}
+		if err := w.WriteIndex(entries); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range members {
+			h := &Header{Name: m.Name, ModTime: time.Unix(1700000000, 0), Mode: 0100644, Size: int64(len(m.Data))}
+			if err := w.WriteHeader(h); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, m.Data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readArchive(buf.Bytes())
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if !reflect.DeepEqual(got, members) {
+			t.Errorf("format %d: got %q, want %q", format, got, members)
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w, err := NewWriter(io.Discard, FormatGNU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteHeader(&Header{Name: "a-name-longer-than-fifteen", Size: 1}); err == nil {
+		t.Error("long name missing from the name table was accepted")
+	}
+	if err := w.WriteHeader(&Header{Name: "short", Size: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("too long")); err == nil {
+		t.Error("write beyond the member size was accepted")
+	}
+	if err := w.Close(); err == nil {
+		t.Error("member with missing data was accepted")
+	}
+}
+
+func TestInvalidArchives(t *testing.T) {
+	tests := map[string]string{
+		"bad signature":       "!<arch>\r",
+		"bad header magic":    "!<arch>\nshort.txt/      0           0     0     100644  6         X\nhello\n",
+		"bad size":            "!<arch>\nshort.txt/      0           0     0     100644  -6        `\nhello\n",
+		"name out of table":   "!<arch>\n/99             0           0     0     100644  6         `\nhello\n",
+		"bsd name too long":   "!<arch>\n#1/20           0           0     0     100644  6         `\nhello\n",
+		"data cut short":      "!<arch>\nshort.txt/      0           0     0     100644  60        `\nhello\n",
+		"header cut short":    "!<arch>\nshort.txt/      0     ",
+		"name table too long": "!<arch>\n//              0           0     0     0       99        `\nname/\n",
+	}
+	for name, data := range tests {
+		if _, err := readArchive([]byte(data)); err == nil {
+			t.Errorf("%s: archive was accepted", name)
+		}
+	}
+}
+
+// TestDamagedArchives checks that truncated and corrupt archives fail with
+// an error rather than a panic. A cut between members leaves a valid archive,
+// which must then hold the members before the cut.
+func TestDamagedArchives(t *testing.T) {
+	for _, name := range []string{"gnu.a", "bsd.a"} {
+		data := readFixture(t, name)
+		want, err := readArchive(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			got, err := readArchive(data[:n])
+			if err == nil && len(got) > 0 && !reflect.DeepEqual(got, want[:len(got)]) {
+				t.Errorf("%s truncated to %d bytes: got %q", name, n, got)
+			}
+		}
+		for i := 0; i < len(data); i++ {
+			damaged := bytes.Clone(data)
+			damaged[i] ^= 0xff
+			_, _ = readArchive(damaged)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package ar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader reads the members of an ar archive. Symbol tables are skipped.
+type Reader struct {
+	r         *bufio.Reader
+	remaining int64
+	pad       bool
+	longNames []byte
+	err       error
+}
+
+// NewReader checks the archive signature and returns a Reader for r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	var sig [len(magic)]byte
+	if _, err := io.ReadFull(br, sig[:]); err != nil || string(sig[:]) != magic {
+		return nil, fmt.Errorf("ar: not an ar archive")
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next advances to the next member, returning io.EOF at the end of the archive.
+func (r *Reader) Next() (*Header, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for {
+		h, err := r.next()
+		if err != nil {
+			r.err = err
+			return nil, err
+		}
+		if h != nil {
+			return h, nil
+		}
+	}
+}
+
+// next reads one header, returning nil for members that are not files.
+func (r *Reader) next() (*Header, error) {
+	if err := r.skipRest(); err != nil {
+		return nil, err
+	}
+	var buf [headerSize]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("ar: truncated header")
+	}
+	if string(buf[58:60]) != fileMagic {
+		return nil, fmt.Errorf("ar: invalid member header")
+	}
+
+	field := func(start, end int) string {
+		return strings.TrimRight(string(buf[start:end]), " ")
+	}
+	number := func(start, end, base int) (int64, error) {
+		s := field(start, end)
+		if s == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseInt(s, base, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("ar: invalid header field %q", s)
+		}
+		return v, nil
+	}
+
+	h := &Header{Name: field(0, 16)}
+	var vals [5]int64
+	for i, f := range [][3]int{{16, 28, 10}, {28, 34, 10}, {34, 40, 10}, {40, 48, 8}, {48, 58, 10}} {
+		v, err := number(f[0], f[1], f[2])
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	h.ModTime = time.Unix(vals[0], 0)
+	h.Uid, h.Gid = int(vals[1]), int(vals[2])
+	h.Mode = vals[3]
+	h.Size = vals[4]
+	r.remaining = h.Size
+	r.pad = h.Size%2 == 1
+
+	switch {
+	case h.Name == "//":
+		// GNU long name table
+		if h.Size > 1<<24 {
+			return nil, fmt.Errorf("ar: long name table too large")
+		}
+		r.longNames = make([]byte, h.Size)
+		if _, err := io.ReadFull(r, r.longNames); err != nil {
+			return nil, fmt.Errorf("ar: truncated long name table")
+		}
+		return nil, nil
+	case h.Name == "/" || h.Name == "/SYM64/" || strings.HasPrefix(h.Name, "__.SYMDEF"):
+		// Symbol tables are regenerated by ranlib and not extracted
+		return nil, nil
+	case strings.HasPrefix(h.Name, "#1/"):
+		n, err := strconv.Atoi(h.Name[3:])
+		if err != nil || n < 0 || int64(n) > h.Size {
+			return nil, fmt.Errorf("ar: invalid BSD long name %q", h.Name)
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, fmt.Errorf("ar: truncated name")
+		}
+		h.Name = strings.TrimRight(string(name), "\x00")
+		h.Size -= int64(n)
+		if h.Name == "__.SYMDEF" || h.Name == "__.SYMDEF SORTED" {
+			return nil, nil
+		}
+	case len(h.Name) > 1 && h.Name[0] == '/':
+		off, err := strconv.Atoi(h.Name[1:])
+		if err != nil || off < 0 || off >= len(r.longNames) {
+			return nil, fmt.Errorf("ar: invalid long name reference %q", h.Name)
+		}
+		name := r.longNames[off:]
+		if end := strings.Index(string(name), "/\n"); end >= 0 {
+			name = name[:end]
+		} else if end := strings.IndexByte(string(name), '\n'); end >= 0 {
+			name = name[:end]
+		}
+		h.Name = string(name)
+	default:
+		// GNU terminates short names with a slash
+		h.Name = strings.TrimSuffix(h.Name, "/")
+	}
+	return h, nil
+}
+
+func (r *Reader) skipRest() error {
+	if r.remaining > 0 {
+		if _, err := r.r.Discard(int(min(r.remaining, 1<<62))); err != nil {
+			return fmt.Errorf("ar: truncated archive")
+		}
+		r.remaining = 0
+	}
+	if r.pad {
+		r.pad = false
+		// Some writers omit the final padding byte
+		if _, err := r.r.Discard(1); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read reads the data of the current member.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,244 @@
+package ar
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Writer writes an ar archive. With FormatGNU, names that do not fit the
+// header must be announced with WriteLongNames before the first member.
+type Writer struct {
+	w         *bufio.Writer
+	format    Format
+	longNames map[string]int
+	started   bool
+	remaining int64
+	pad       bool
+	name      string
+}
+
+// NewWriter writes the archive signature and returns a Writer.
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(magic); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw, format: format}, nil
+}
+
+// NeedsLongName reports whether name has to be stored outside the header.
+func (w *Writer) NeedsLongName(name string) bool {
+	if w.format == FormatGNU {
+		// The trailing slash leaves room for 15 characters
+		return len(name) > 15 || strings.ContainsAny(name, "/ ")
+	}
+	return len(name) > 16 || strings.ContainsAny(name, " ") || strings.HasPrefix(name, "#1/")
+}
+
+// WriteLongNames writes the GNU long name table for the given member names.
+// Names that fit the header are ignored. It must precede the first member.
+func (w *Writer) WriteLongNames(names []string) error {
+	if w.format != FormatGNU {
+		return nil
+	}
+	if w.started {
+		return fmt.Errorf("ar: long name table must precede the members")
+	}
+	w.started = true
+	table, offsets, err := w.longNameTable(names)
+	if err != nil {
+		return err
+	}
+	w.longNames = offsets
+	if len(table) == 0 {
+		return nil
+	}
+	return w.writeSpecial("long name table", "//", []byte(table))
+}
+
+// longNameTable builds the GNU long name table and each long name's offset in it.
+func (w *Writer) longNameTable(names []string) (string, map[string]int, error) {
+	var table strings.Builder
+	offsets := make(map[string]int)
+	for _, name := range names {
+		if _, ok := offsets[name]; ok || !w.NeedsLongName(name) {
+			continue
+		}
+		if strings.ContainsAny(name, "\n") {
+			return "", nil, fmt.Errorf("ar: invalid member name %q", name)
+		}
+		offsets[name] = table.Len()
+		table.WriteString(name + "/\n")
+	}
+	return table.String(), offsets, nil
+}
+
+// IndexEntry describes a member for the symbol index: its name, its size and
+// the symbols it defines.
+type IndexEntry struct {
+	Name    string
+	Size    int64
+	Symbols []string
+}
+
+// WriteIndex writes a GNU symbol index for members that will be written in
+// the given order, followed by their long name table, so WriteLongNames is
+// not needed. The index is left out if no member defines a symbol. Linkers
+// need it to search a static library; BSD archives get none and have to go
+// through ranlib.
+func (w *Writer) WriteIndex(members []IndexEntry) error {
+	if w.format != FormatGNU {
+		return nil
+	}
+	if w.started {
+		return fmt.Errorf("ar: symbol index must precede the members")
+	}
+	names := make([]string, len(members))
+	count, nameBytes := 0, 0
+	for i, m := range members {
+		names[i] = m.Name
+		count += len(m.Symbols)
+		for _, sym := range m.Symbols {
+			nameBytes += len(sym) + 1
+		}
+	}
+	if count == 0 {
+		return w.WriteLongNames(names)
+	}
+	table, _, err := w.longNameTable(names)
+	if err != nil {
+		return err
+	}
+
+	// Offsets point at member headers, which follow the index and the name table
+	indexSize := 4 + 4*count + nameBytes
+	pos := int64(len(magic)) + headerSize + int64(indexSize+indexSize%2)
+	if len(table) > 0 {
+		pos += headerSize + int64(len(table)+len(table)%2)
+	}
+	index := binary.BigEndian.AppendUint32(make([]byte, 0, indexSize), uint32(count))
+	for _, m := range members {
+		if pos > math.MaxUint32 {
+			return fmt.Errorf("ar: archive too large for a symbol index")
+		}
+		for range m.Symbols {
+			index = binary.BigEndian.AppendUint32(index, uint32(pos))
+		}
+		pos += headerSize + m.Size + m.Size%2
+	}
+	for _, m := range members {
+		for _, sym := range m.Symbols {
+			if sym == "" || strings.ContainsRune(sym, 0) {
+				return fmt.Errorf("ar: invalid symbol name %q in %s", sym, m.Name)
+			}
+			index = append(append(index, sym...), 0)
+		}
+	}
+	if err := w.writeSpecial("symbol index", "/", index); err != nil {
+		return err
+	}
+	return w.WriteLongNames(names)
+}
+
+// writeSpecial writes a member that holds archive metadata rather than a file.
+func (w *Writer) writeSpecial(label, name string, data []byte) error {
+	if err := w.writeRaw(label, name, 0, 0, 0, 0, int64(len(data))); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	if len(data)%2 == 1 {
+		return w.w.WriteByte('\n')
+	}
+	return nil
+}
+
+// WriteHeader starts a new member of h.Size bytes.
+func (w *Writer) WriteHeader(h *Header) error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+	w.started = true
+	if h.Name == "" || strings.ContainsAny(h.Name, "\n") {
+		return fmt.Errorf("ar: invalid member name %q", h.Name)
+	}
+	mtime := int64(0)
+	if !h.ModTime.IsZero() {
+		mtime = h.ModTime.Unix()
+	}
+
+	name := h.Name
+	size := h.Size
+	var bsdName string
+	switch {
+	case !w.NeedsLongName(h.Name):
+		if w.format == FormatGNU {
+			name += "/"
+		}
+	case w.format == FormatGNU:
+		off, ok := w.longNames[h.Name]
+		if !ok {
+			return fmt.Errorf("ar: long name %q missing from the name table", h.Name)
+		}
+		name = fmt.Sprintf("/%d", off)
+	default:
+		bsdName = h.Name
+		name = fmt.Sprintf("#1/%d", len(bsdName))
+		size += int64(len(bsdName))
+	}
+
+	if err := w.writeRaw(h.Name, name, mtime, h.Uid, h.Gid, h.Mode, size); err != nil {
+		return err
+	}
+	if _, err := w.w.WriteString(bsdName); err != nil {
+		return err
+	}
+	w.remaining = h.Size
+	w.pad = size%2 == 1
+	w.name = h.Name
+	return nil
+}
+
+func (w *Writer) writeRaw(label, name string, mtime int64, uid, gid int, mode, size int64) error {
+	hdr := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d%s", name, mtime, uid, gid, mode, size, fileMagic)
+	if len(hdr) != headerSize {
+		return fmt.Errorf("ar: header field of %s out of range", label)
+	}
+	_, err := w.w.WriteString(hdr)
+	return err
+}
+
+// Write writes data for the current member.
+func (w *Writer) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		return 0, fmt.Errorf("ar: write too long for %s", w.name)
+	}
+	n, err := w.w.Write(p)
+	w.remaining -= int64(n)
+	return n, err
+}
+
+func (w *Writer) finish() error {
+	if w.remaining > 0 {
+		return fmt.Errorf("ar: missing %d bytes of data for %s", w.remaining, w.name)
+	}
+	if w.pad {
+		w.pad = false
+		return w.w.WriteByte('\n')
+	}
+	return nil
+}
+
+// Close completes the last member and flushes the archive. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
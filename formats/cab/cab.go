@@ -0,0 +1,537 @@
+// Package cab reads Microsoft Cabinet files and cabinet sets.
+package cab
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Header flags.
+const (
+	flagPrevCabinet    = 0x0001
+	flagNextCabinet    = 0x0002
+	flagReservePresent = 0x0004
+)
+
+// Compression types, in the low bits of a folder's typeCompress.
+const (
+	CompressNone    = 0
+	CompressMSZIP   = 1
+	CompressQuantum = 2
+	CompressLZX     = 3
+)
+
+// Special folder indexes for files that span cabinets.
+const (
+	continuedFromPrev    = 0xFFFD
+	continuedToNext      = 0xFFFE
+	continuedPrevAndNext = 0xFFFF
+)
+
+// File attributes.
+const (
+	AttrReadOnly = 0x01
+	AttrHidden   = 0x02
+	AttrSystem   = 0x04
+	AttrArchive  = 0x20
+	AttrExec     = 0x40
+	AttrNameUTF8 = 0x80
+)
+
+// maxBlock is the most data a single CFDATA block expands to, which is also
+// the MSZIP history window.
+const maxBlock = 32768
+
+var signature = []byte("MSCF")
+
+// ErrNotCabinet is returned for files without the cabinet signature.
+var ErrNotCabinet = errors.New("not a cabinet file")
+
+// Cabinet is one file of a cabinet set.
+type Cabinet struct {
+	SetID       uint16
+	Index       uint16 // position in the set, from zero
+	PrevCabinet string // file name of the previous cabinet, if any
+	NextCabinet string // file name of the next cabinet, if any
+
+	r        io.ReaderAt
+	folders  []cabFolder
+	files    []cabFile
+	dataResv int
+	hasPrev  bool
+	hasNext  bool
+}
+
+type cabFolder struct {
+	dataOffset uint32
+	blocks     uint16
+	compress   uint16
+}
+
+type cabFile struct {
+	size    uint32
+	offset  uint32
+	folder  uint16
+	date    uint16
+	time    uint16
+	attribs uint16
+	name    string
+}
+
+// Read parses the header, folder and file tables of a cabinet.
+func Read(r io.ReaderAt) (*Cabinet, error) {
+	sr := io.NewSectionReader(r, 0, 1<<62)
+	var hdr struct {
+		Signature [4]byte
+		_         uint32
+		Size      uint32
+		_         uint32
+		FilesOff  uint32
+		_         uint32
+		Minor     uint8
+		Major     uint8
+		Folders   uint16
+		Files     uint16
+		Flags     uint16
+		SetID     uint16
+		Index     uint16
+	}
+	if err := binary.Read(sr, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("failed to read cabinet header: %w", err)
+	}
+	if !bytes.Equal(hdr.Signature[:], signature) {
+		return nil, ErrNotCabinet
+	}
+	if hdr.Major != 1 {
+		return nil, fmt.Errorf("unsupported cabinet version %d.%d", hdr.Major, hdr.Minor)
+	}
+
+	c := &Cabinet{
+		SetID:   hdr.SetID,
+		Index:   hdr.Index,
+		r:       r,
+		hasPrev: hdr.Flags&flagPrevCabinet != 0,
+		hasNext: hdr.Flags&flagNextCabinet != 0,
+	}
+	folderResv := 0
+	if hdr.Flags&flagReservePresent != 0 {
+		var resv struct {
+			Header uint16
+			Folder uint8
+			Data   uint8
+		}
+		if err := binary.Read(sr, binary.LittleEndian, &resv); err != nil {
+			return nil, fmt.Errorf("failed to read cabinet header: %w", err)
+		}
+		if _, err := sr.Seek(int64(resv.Header), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		folderResv, c.dataResv = int(resv.Folder), int(resv.Data)
+	}
+	if c.hasPrev {
+		var err error
+		if c.PrevCabinet, err = readString(sr); err != nil {
+			return nil, err
+		}
+		if _, err = readString(sr); err != nil {
+			return nil, err
+		}
+	}
+	if c.hasNext {
+		var err error
+		if c.NextCabinet, err = readString(sr); err != nil {
+			return nil, err
+		}
+		if _, err = readString(sr); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < int(hdr.Folders); i++ {
+		var f struct {
+			DataOffset uint32
+			Blocks     uint16
+			Compress   uint16
+		}
+		if err := binary.Read(sr, binary.LittleEndian, &f); err != nil {
+			return nil, fmt.Errorf("failed to read folder table: %w", err)
+		}
+		if _, err := sr.Seek(int64(folderResv), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		c.folders = append(c.folders, cabFolder{f.DataOffset, f.Blocks, f.Compress})
+	}
+
+	if _, err := sr.Seek(int64(hdr.FilesOff), io.SeekStart); err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(hdr.Files); i++ {
+		var f struct {
+			Size    uint32
+			Offset  uint32
+			Folder  uint16
+			Date    uint16
+			Time    uint16
+			Attribs uint16
+		}
+		if err := binary.Read(sr, binary.LittleEndian, &f); err != nil {
+			return nil, fmt.Errorf("failed to read file table: %w", err)
+		}
+		name, err := readString(sr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file table: %w", err)
+		}
+		if f.Folder < continuedFromPrev && int(f.Folder) >= len(c.folders) {
+			return nil, fmt.Errorf("file %s refers to missing folder %d", name, f.Folder)
+		}
+		c.files = append(c.files, cabFile{f.Size, f.Offset, f.Folder, f.Date, f.Time, f.Attribs, name})
+	}
+	return c, nil
+}
+
+// readString reads a NUL terminated string of at most 256 bytes.
+func readString(r io.Reader) (string, error) {
+	var b []byte
+	var c [1]byte
+	for len(b) <= 256 {
+		if _, err := io.ReadFull(r, c[:]); err != nil {
+			return "", fmt.Errorf("failed to read cabinet string: %w", err)
+		}
+		if c[0] == 0 {
+			return string(b), nil
+		}
+		b = append(b, c[0])
+	}
+	return "", fmt.Errorf("cabinet string is too long")
+}
+
+// File is a file stored in a cabinet set.
+type File struct {
+	Name    string // slash separated
+	Size    int64
+	ModTime time.Time
+	Attribs uint16
+
+	folder *folder
+	offset int64
+}
+
+// Mode returns permissions derived from the DOS attributes.
+func (f *File) Mode() fs.FileMode {
+	mode := fs.FileMode(0644)
+	if f.Attribs&AttrExec != 0 {
+		mode = 0755
+	}
+	if f.Attribs&AttrReadOnly != 0 {
+		mode &^= 0222
+	}
+	return mode
+}
+
+// folder is a compressed stream, which may continue across cabinets.
+type folder struct {
+	compress uint16
+	segments []segment
+	files    []*File
+}
+
+// segment is the run of a folder's data blocks stored in one cabinet.
+type segment struct {
+	cab    *Cabinet
+	offset int64
+	blocks int
+}
+
+// Set is a sequence of cabinets read as one archive.
+type Set struct {
+	Files []*File
+
+	folders []*folder
+}
+
+// NewSet joins the cabinets of a set, which must be given in order starting
+// with the first.
+func NewSet(cabs []*Cabinet) (*Set, error) {
+	if len(cabs) == 0 {
+		return nil, fmt.Errorf("empty cabinet set")
+	}
+	if cabs[0].hasPrev {
+		return nil, fmt.Errorf("cabinet set starts with a continuation cabinet; open %s first", cabs[0].PrevCabinet)
+	}
+
+	s := &Set{}
+	var carried *folder // folder continued from the previous cabinet
+	for i, c := range cabs {
+		if i > 0 && (c.SetID != cabs[0].SetID || int(c.Index) != i) {
+			return nil, fmt.Errorf("cabinet %d does not belong to the set", i+1)
+		}
+		local := make([]*folder, len(c.folders))
+		for j, cf := range c.folders {
+			seg := segment{cab: c, offset: int64(cf.dataOffset), blocks: int(cf.blocks)}
+			if j == 0 && carried != nil {
+				carried.segments = append(carried.segments, seg)
+				local[j] = carried
+				continue
+			}
+			local[j] = &folder{compress: cf.compress, segments: []segment{seg}}
+			s.folders = append(s.folders, local[j])
+		}
+		if carried != nil && len(local) == 0 {
+			return nil, fmt.Errorf("cabinet %d does not continue the previous folder", i+1)
+		}
+
+		// The last folder continues into the next cabinet when files say so
+		carried = nil
+		for _, cf := range c.files {
+			if cf.folder == continuedToNext || cf.folder == continuedPrevAndNext {
+				if len(local) == 0 {
+					return nil, fmt.Errorf("file %s continues from a cabinet without folders", cf.name)
+				}
+				carried = local[len(local)-1]
+			}
+		}
+
+		for _, cf := range c.files {
+			var fo *folder
+			switch cf.folder {
+			case continuedFromPrev, continuedPrevAndNext:
+				// Already listed by the cabinet the file starts in
+				continue
+			case continuedToNext:
+				fo = local[len(local)-1]
+			default:
+				fo = local[cf.folder]
+			}
+			f := &File{
+				Name:    strings.ReplaceAll(cf.name, "\\", "/"),
+				Size:    int64(cf.size),
+				ModTime: dosTime(cf.date, cf.time),
+				Attribs: cf.attribs,
+				folder:  fo,
+				offset:  int64(cf.offset),
+			}
+			fo.files = append(fo.files, f)
+			s.Files = append(s.Files, f)
+		}
+	}
+	if carried != nil {
+		return nil, fmt.Errorf("cabinet set is incomplete: missing %s", cabs[len(cabs)-1].NextCabinet)
+	}
+	return s, nil
+}
+
+// dosTime converts an MS-DOS date and time, which are in local time.
+func dosTime(date, tm uint16) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(int(date>>9)+1980, time.Month(date>>5&0x0F), int(date&0x1F),
+		int(tm>>11), int(tm>>5&0x3F), int(tm&0x1F)*2, 0, time.Local)
+}
+
+// Walk calls fn for every file in folder order, with a reader of its
+// contents. Each folder is decompressed once from start to end.
+func (s *Set) Walk(fn func(f *File, r io.Reader) error) error {
+	for _, fo := range s.folders {
+		files := append([]*File(nil), fo.files...)
+		sort.SliceStable(files, func(i, j int) bool { return files[i].offset < files[j].offset })
+
+		r, err := fo.reader()
+		if err != nil {
+			return err
+		}
+		var pos int64
+		for _, f := range files {
+			if f.offset < pos {
+				// Overlapping files are unusual; restart the folder
+				if r, err = fo.reader(); err != nil {
+					return err
+				}
+				pos = 0
+			}
+			if _, err := io.CopyN(io.Discard, r, f.offset-pos); err != nil {
+				return fmt.Errorf("failed to read %s: %w", f.Name, err)
+			}
+			lr := &io.LimitedReader{R: r, N: f.Size}
+			if err := fn(f, lr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.Discard, lr); err != nil {
+				return fmt.Errorf("failed to read %s: %w", f.Name, err)
+			}
+			if lr.N > 0 {
+				return fmt.Errorf("failed to read %s: %w", f.Name, io.ErrUnexpectedEOF)
+			}
+			pos = f.offset + f.Size
+		}
+	}
+	return nil
+}
+
+// CompressionName describes a folder compression type.
+func CompressionName(compress uint16) string {
+	switch compress & 0x0F {
+	case CompressNone:
+		return "stored"
+	case CompressMSZIP:
+		return "MSZIP"
+	case CompressQuantum:
+		return "Quantum"
+	case CompressLZX:
+		return fmt.Sprintf("LZX:%d", compress>>8&0x1F)
+	}
+	return fmt.Sprintf("unknown (%d)", compress&0x0F)
+}
+
+// Compression returns the compression type of the folder holding a file.
+func (f *File) Compression() uint16 {
+	return f.folder.compress
+}
+
+func (fo *folder) reader() (io.Reader, error) {
+	switch fo.compress & 0x0F {
+	case CompressNone, CompressMSZIP:
+		return &folderReader{fo: fo}, nil
+	}
+	return nil, fmt.Errorf("%s compression is not supported", CompressionName(fo.compress))
+}
+
+// folderReader decompresses the data blocks of a folder in sequence.
+type folderReader struct {
+	fo      *folder
+	seg     int
+	block   int
+	pos     int64
+	buf     []byte // decompressed block not yet read
+	history []byte // MSZIP window carried between blocks
+}
+
+func (r *folderReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.nextBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// readBlock reads the next CFDATA block of the folder. A block split across
+// two cabinets has no uncompressed size in its first part.
+func (r *folderReader) readBlock() ([]byte, int, error) {
+	var data []byte
+	for {
+		for r.seg < len(r.fo.segments) && r.block == r.fo.segments[r.seg].blocks {
+			r.seg++
+			r.block = 0
+			if r.seg < len(r.fo.segments) {
+				r.pos = r.fo.segments[r.seg].offset
+			}
+		}
+		if r.seg == len(r.fo.segments) {
+			if data != nil {
+				return nil, 0, fmt.Errorf("data block continues past the last cabinet")
+			}
+			return nil, 0, io.EOF
+		}
+		seg := r.fo.segments[r.seg]
+		if r.block == 0 {
+			r.pos = seg.offset
+		}
+
+		hdr := make([]byte, 8+seg.cab.dataResv)
+		if _, err := seg.cab.r.ReadAt(hdr, r.pos); err != nil {
+			return nil, 0, fmt.Errorf("failed to read data block: %w", err)
+		}
+		sum := binary.LittleEndian.Uint32(hdr)
+		compressed := int(binary.LittleEndian.Uint16(hdr[4:]))
+		uncompressed := int(binary.LittleEndian.Uint16(hdr[6:]))
+		chunk := make([]byte, compressed)
+		if _, err := seg.cab.r.ReadAt(chunk, r.pos+int64(len(hdr))); err != nil {
+			return nil, 0, fmt.Errorf("failed to read data block: %w", err)
+		}
+		if sum != 0 && !checksumMatches(sum, chunk, hdr) {
+			return nil, 0, fmt.Errorf("data block checksum mismatch")
+		}
+		r.pos += int64(len(hdr) + compressed)
+		r.block++
+
+		data = append(data, chunk...)
+		if uncompressed != 0 {
+			if uncompressed > maxBlock {
+				return nil, 0, fmt.Errorf("data block expands to %d bytes", uncompressed)
+			}
+			return data, uncompressed, nil
+		}
+	}
+}
+
+func (r *folderReader) nextBlock() error {
+	data, size, err := r.readBlock()
+	if err != nil {
+		return err
+	}
+	if r.fo.compress&0x0F == CompressNone {
+		if len(data) != size {
+			return fmt.Errorf("stored data block has %d bytes, expected %d", len(data), size)
+		}
+		r.buf = data
+		return nil
+	}
+
+	// Each MSZIP block is a deflate stream primed with the previous output
+	if len(data) < 2 || data[0] != 'C' || data[1] != 'K' {
+		return fmt.Errorf("invalid MSZIP block signature")
+	}
+	zr := flate.NewReaderDict(bytes.NewReader(data[2:]), r.history)
+	out := make([]byte, size)
+	if _, err := io.ReadFull(zr, out); err != nil {
+		return fmt.Errorf("failed to decompress MSZIP block: %w", err)
+	}
+	r.history = append(r.history, out...)
+	if len(r.history) > maxBlock {
+		r.history = r.history[len(r.history)-maxBlock:]
+	}
+	r.buf = out
+	return nil
+}
+
+// checksumMatches verifies a data block. The format covers the size fields
+// after the data; writers disagree on whether the reserved area is included,
+// so both are accepted.
+func checksumMatches(sum uint32, data, hdr []byte) bool {
+	seed := checksum(data, 0)
+	if checksum(hdr[4:8], seed) == sum {
+		return true
+	}
+	return len(hdr) > 8 && checksum(hdr[4:], seed) == sum
+}
+
+// checksum is the cabinet checksum: an XOR of little endian words, with
+// the trailing bytes taken most significant first.
+func checksum(b []byte, seed uint32) uint32 {
+	sum := seed
+	for len(b) >= 4 {
+		sum ^= binary.LittleEndian.Uint32(b)
+		b = b[4:]
+	}
+	var last uint32
+	switch len(b) {
+	case 3:
+		last = uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 2:
+		last = uint32(b[0])<<8 | uint32(b[1])
+	case 1:
+		last = uint32(b[0])
+	}
+	return sum ^ last
+}
@@ -0,0 +1,241 @@
+package cab
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type member struct {
+	Name string
+	Mode fs.FileMode
+	Data string
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readSet reads a cabinet set and the files in it, in folder order.
+func readSet(cabinets ...[]byte) ([]member, error) {
+	var cabs []*Cabinet
+	for _, data := range cabinets {
+		c, err := Read(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		cabs = append(cabs, c)
+	}
+	s, err := NewSet(cabs)
+	if err != nil {
+		return nil, err
+	}
+	var members []member
+	err = s.Walk(func(f *File, r io.Reader) error {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		members = append(members, member{f.Name, f.Mode(), string(content)})
+		return nil
+	})
+	return members, err
+}
+
+func bigText() string {
+	var b strings.Builder
+	for i := 0; b.Len() < 40000; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+// The fixtures were written by testdata/gen.go, as there is no makecab to
+// make them, and checked with bsdtar, which reads single cabinets on its
+// own. Both hold a file that spans two data blocks; stored.cab reserves
+// space in each structure, and mszip.cab has a stored folder as well.
+func TestReadFixtures(t *testing.T) {
+	want := []member{
+		{"hello.txt", 0644, "hello\n"},
+		{"big.txt", 0644, bigText()},
+		{"héllo.txt", 0644, "unicode\n"},
+		{"dir/readme.txt", 0444, "read me\n"},
+		{"run.sh", 0755, "#!/bin/sh\necho hi\n"},
+		{"empty", 0644, ""},
+	}
+	mtime := time.Date(2023, 11, 14, 22, 13, 20, 0, time.Local)
+	compression := map[string][]uint16{
+		"stored.cab": {CompressNone, CompressNone, CompressNone, CompressNone, CompressNone, CompressNone},
+		"mszip.cab":  {CompressMSZIP, CompressMSZIP, CompressMSZIP, CompressNone, CompressNone, CompressNone},
+	}
+	for name, compress := range compression {
+		t.Run(name, func(t *testing.T) {
+			data := readFixture(t, name)
+			c, err := Read(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := NewSet([]*Cabinet{c})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, f := range s.Files {
+				if !f.ModTime.Equal(mtime) || f.Compression() != compress[i] {
+					t.Errorf("%s: got time %v, compression %s", f.Name, f.ModTime, CompressionName(f.Compression()))
+				}
+			}
+			got, err := readSet(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	set1, set2 := readFixture(t, "set1.cab"), readFixture(t, "set2.cab")
+	c, err := Read(bytes.NewReader(set1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SetID != 0x1234 || c.Index != 0 || c.PrevCabinet != "" || c.NextCabinet != "set2.cab" {
+		t.Errorf("got cabinet %+v", c)
+	}
+	got, err := readSet(set1, set2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []member{
+		{"a.txt", 0644, "alpha\n"},
+		{"split.txt", 0644, "this file is split between two cabinets\n"},
+		{"b.txt", 0644, "beta\n"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	other := bytes.Clone(set2)
+	other[32] = 0x99 // the set ID
+	for name, cabs := range map[string][][]byte{
+		"empty":        nil,
+		"second first": {set2},
+		"first only":   {set1},
+		"out of order": {set2, set1},
+		"other set":    {set1, other},
+		"first twice":  {set1, set1},
+	} {
+		if _, err := readSet(cabs...); err == nil {
+			t.Errorf("%s: set was accepted", name)
+		}
+	}
+}
+
+// cabinet builds a cabinet with a single folder of the given data blocks,
+// holding one file of all of it. The blocks have no checksums.
+func cabinet(compress uint16, blocks ...[]byte) []byte {
+	le := binary.LittleEndian
+	const name = "file.txt\x00"
+	var data []byte
+	size := 0
+	for _, b := range blocks {
+		data = append(data, 0, 0, 0, 0)
+		data = le.AppendUint16(data, uint16(len(b)))
+		data = le.AppendUint16(data, uint16(len(b)))
+		data = append(data, b...)
+		size += len(b)
+	}
+	out := []byte("MSCF\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	out = le.AppendUint32(out, 36+8) // the file table
+	out = append(out, "\x00\x00\x00\x00\x03\x01\x01\x00\x01\x00\x00\x00\x00\x00\x00\x00"...)
+	out = le.AppendUint32(out, uint32(36+8+16+len(name)))
+	out = le.AppendUint16(out, uint16(len(blocks)))
+	out = le.AppendUint16(out, compress)
+	out = le.AppendUint32(out, uint32(size))
+	out = append(out, make([]byte, 12)...)
+	out = append(out, name...)
+	return append(out, data...)
+}
+
+func TestInvalidCabinets(t *testing.T) {
+	valid := cabinet(CompressNone, []byte("hello\n"))
+	if _, err := readSet(valid); err != nil {
+		t.Fatal(err)
+	}
+	change := func(data []byte, i int, b ...byte) []byte {
+		data = bytes.Clone(data)
+		copy(data[i:], b)
+		return data
+	}
+	stored := readFixture(t, "stored.cab")
+	// The first MSZIP block, without its checksum
+	mszip := readFixture(t, "mszip.cab")
+	block := int(binary.LittleEndian.Uint32(mszip[36:]))
+	mszip = change(mszip, block, 0, 0, 0, 0)
+
+	tests := map[string][]byte{
+		"empty":             nil,
+		"bad signature":     change(valid, 0, 'M', 'S', 'C', 'G'),
+		"version 2":         change(valid, 25, 2),
+		"folders cut short": valid[:40],
+		"files cut short":   valid[:50],
+		"missing folder":    change(valid, 44+8, 1),
+		"long name":         append(valid[:44+16], strings.Repeat("a", 300)...),
+		"unsupported":       cabinet(CompressLZX, []byte("hello\n")),
+		"checksum":          change(valid, 69, 1),
+		"stored size":       change(valid, 69+6, 5),
+		"large block":       cabinet(CompressNone, make([]byte, 40000)),
+		"MSZIP signature":   change(mszip, block+8, 'X'),
+		"MSZIP stream":      change(mszip, block+10, 0xff, 0xff, 0xff, 0xff),
+		"file beyond data":  change(stored, int(binary.LittleEndian.Uint32(stored[16:]))+4, 0xff, 0xff),
+		"data cut short":    stored[:len(stored)-1],
+	}
+	for name, data := range tests {
+		if _, err := readSet(data); err == nil {
+			t.Errorf("%s: cabinet was accepted", name)
+		}
+	}
+}
+
+// TestDamagedCabinets checks that truncated and corrupt cabinets fail with
+// an error rather than a panic. Data blocks end the cabinets, so every cut
+// loses data; only the blocks have checksums, so changes elsewhere may go
+// unnoticed.
+func TestDamagedCabinets(t *testing.T) {
+	for _, names := range [][]string{{"stored.cab"}, {"mszip.cab"}, {"set1.cab", "set2.cab"}} {
+		var cabs [][]byte
+		for _, name := range names {
+			cabs = append(cabs, readFixture(t, name))
+		}
+		for i, data := range cabs {
+			// A stride keeps the large stored cabinet quick
+			for n := 0; n < len(data); n += 1 + n/1000 {
+				cabs[i] = data[:n]
+				if _, err := readSet(cabs...); err == nil {
+					t.Errorf("%s truncated to %d bytes was accepted", names[i], n)
+				}
+			}
+			cabs[i] = data
+			for j := 0; j < len(data); j += 1 + j/1000 {
+				data[j] ^= 0xff
+				_, _ = readSet(cabs...)
+				data[j] ^= 0xff
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+//go:build ignore
+
+// This program writes the cabinet fixtures of the cab package tests. There
+// is no makecab or gcab in the test environment, so the cabinets are laid
+// out by hand; bsdtar lists and extracts the single ones. Run it from the
+// package directory:
+//
+//	go run testdata/gen.go
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	date = (2023-1980)<<9 | 11<<5 | 14
+	tm   = 22<<11 | 13<<5 | 20/2
+)
+
+type file struct {
+	name    string
+	attribs uint16
+	data    string
+}
+
+type block struct {
+	data []byte
+	size int // uncompressed, zero for the first part of a split block
+}
+
+type folder struct {
+	compress uint16
+	blocks   []block
+}
+
+type fileEntry struct {
+	name    string
+	folder  uint16
+	offset  int
+	size    int
+	attribs uint16
+}
+
+type cabinet struct {
+	index      uint16
+	prev, next string
+	reserve    bool // reserve 4 header, 2 folder and 3 data bytes
+	folders    []folder
+	files      []fileEntry
+}
+
+// entries places files one after another in a folder.
+func entries(index uint16, files []file) ([]fileEntry, string) {
+	var list []fileEntry
+	var content strings.Builder
+	for _, f := range files {
+		list = append(list, fileEntry{f.name, index, content.Len(), len(f.data), f.attribs})
+		content.WriteString(f.data)
+	}
+	return list, content.String()
+}
+
+func stored(content string) folder {
+	fo := folder{compress: 0}
+	for len(content) > 0 {
+		n := min(len(content), 32768)
+		fo.blocks = append(fo.blocks, block{[]byte(content[:n]), n})
+		content = content[n:]
+	}
+	return fo
+}
+
+// mszip compresses each block as a deflate stream primed with the output
+// of the block before it.
+func mszip(content string) folder {
+	fo := folder{compress: 1}
+	var history []byte
+	for len(content) > 0 {
+		n := min(len(content), 32768)
+		var buf bytes.Buffer
+		buf.WriteString("CK")
+		w, err := flate.NewWriterDict(&buf, flate.BestCompression, history)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w.Write([]byte(content[:n]))
+		w.Close()
+		fo.blocks = append(fo.blocks, block{buf.Bytes(), n})
+		history = []byte(content[:n])
+		content = content[n:]
+	}
+	return fo
+}
+
+// checksum is the cabinet checksum: an XOR of little endian words, with
+// the trailing bytes taken most significant first.
+func checksum(b []byte, seed uint32) uint32 {
+	sum := seed
+	for ; len(b) >= 4; b = b[4:] {
+		sum ^= binary.LittleEndian.Uint32(b)
+	}
+	var last uint32
+	for _, c := range b {
+		last = last<<8 | uint32(c)
+	}
+	return sum ^ last
+}
+
+func (c cabinet) encode() []byte {
+	le := binary.LittleEndian
+	var flags uint16
+	if c.prev != "" {
+		flags |= 0x1
+	}
+	if c.next != "" {
+		flags |= 0x2
+	}
+	folderResv, dataResv := 0, 0
+	if c.reserve {
+		flags |= 0x4
+		folderResv, dataResv = 2, 3
+	}
+
+	var head []byte
+	if c.reserve {
+		head = append(head, 4, 0, byte(folderResv), byte(dataResv), 'r', 'e', 's', 'v')
+	}
+	if c.prev != "" {
+		head = append(head, c.prev+"\x00disk\x00"...)
+	}
+	if c.next != "" {
+		head = append(head, c.next+"\x00disk\x00"...)
+	}
+	foldersOff := 36 + len(head)
+	filesOff := foldersOff + len(c.folders)*(8+folderResv)
+	var table []byte
+	for _, f := range c.files {
+		table = le.AppendUint32(table, uint32(f.size))
+		table = le.AppendUint32(table, uint32(f.offset))
+		table = le.AppendUint16(table, f.folder)
+		table = le.AppendUint16(table, date)
+		table = le.AppendUint16(table, tm)
+		table = le.AppendUint16(table, f.attribs)
+		table = append(table, f.name+"\x00"...)
+	}
+
+	var folders, data []byte
+	dataOff := filesOff + len(table)
+	for _, fo := range c.folders {
+		folders = le.AppendUint32(folders, uint32(dataOff+len(data)))
+		folders = le.AppendUint16(folders, uint16(len(fo.blocks)))
+		folders = le.AppendUint16(folders, fo.compress)
+		folders = append(folders, make([]byte, folderResv)...)
+		for _, b := range fo.blocks {
+			sizes := le.AppendUint16(nil, uint16(len(b.data)))
+			sizes = le.AppendUint16(sizes, uint16(b.size))
+			data = le.AppendUint32(data, checksum(sizes, checksum(b.data, 0)))
+			data = append(data, sizes...)
+			data = append(data, make([]byte, dataResv)...)
+			data = append(data, b.data...)
+		}
+	}
+
+	var out []byte
+	out = append(out, "MSCF"...)
+	out = le.AppendUint32(out, 0)
+	out = le.AppendUint32(out, uint32(dataOff+len(data)))
+	out = le.AppendUint32(out, 0)
+	out = le.AppendUint32(out, uint32(filesOff))
+	out = le.AppendUint32(out, 0)
+	out = append(out, 3, 1)
+	out = le.AppendUint16(out, uint16(len(c.folders)))
+	out = le.AppendUint16(out, uint16(len(c.files)))
+	out = le.AppendUint16(out, flags)
+	out = le.AppendUint16(out, 0x1234)
+	out = le.AppendUint16(out, c.index)
+	out = append(out, head...)
+	out = append(out, folders...)
+	out = append(out, table...)
+	return append(out, data...)
+}
+
+func main() {
+	var big strings.Builder
+	for i := 0; big.Len() < 40000; i++ {
+		fmt.Fprintf(&big, "line %d\n", i)
+	}
+	// Folder 0 spans two blocks
+	files0, content0 := entries(0, []file{
+		{"hello.txt", 0x20, "hello\n"},
+		{"big.txt", 0x20, big.String()},
+		{"héllo.txt", 0x80, "unicode\n"},
+	})
+	files1, content1 := entries(1, []file{
+		{`dir\readme.txt`, 0x01, "read me\n"},
+		{"run.sh", 0x40, "#!/bin/sh\necho hi\n"},
+		{"empty", 0, ""},
+	})
+	files := append(files0, files1...)
+
+	// A file split across two cabinets, with a data block split as well
+	split := "this file is split between two cabinets\n"
+	first := "alpha\n" + split[:10]
+	set1 := cabinet{next: "set2.cab",
+		folders: []folder{{0, []block{{[]byte(first), 0}}}},
+		files:   []fileEntry{{"a.txt", 0, 0, 6, 0}, {"split.txt", 0xfffe, 6, len(split), 0}},
+	}
+	set2 := cabinet{index: 1, prev: "set1.cab",
+		folders: []folder{{0, []block{{[]byte(split[10:]), 6 + len(split)}}}, stored("beta\n")},
+		files:   []fileEntry{{"split.txt", 0xfffd, 6, len(split), 0}, {"b.txt", 1, 0, 5, 0}},
+	}
+
+	for name, c := range map[string]cabinet{
+		"stored.cab": {reserve: true, folders: []folder{stored(content0 + content1)}, files: append(files0,
+			fileEntry{`dir\readme.txt`, 0, len(content0), 8, 0x01},
+			fileEntry{"run.sh", 0, len(content0) + 8, 18, 0x40},
+			fileEntry{"empty", 0, len(content0) + 26, 0, 0})},
+		"mszip.cab": {folders: []folder{mszip(content0), stored(content1)}, files: files},
+		"set1.cab":  set1,
+		"set2.cab":  set2,
+	} {
+		if err := os.WriteFile("testdata/"+name, c.encode(), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package cpio reads and writes cpio archives in the portable ASCII formats:
+// "newc" (SVR4 without checksums), "crc" (SVR4 with checksums) and "odc"
+// (POSIX.1 octal).
+package cpio
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// Format selects the header layout.
+type Format int
+
+const (
+	FormatNewc Format = iota
+	FormatCRC
+	FormatODC
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatNewc:
+		return "newc"
+	case FormatCRC:
+		return "crc"
+	case FormatODC:
+		return "odc"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat maps a format name to a Format.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "", "newc":
+		return FormatNewc, nil
+	case "crc":
+		return FormatCRC, nil
+	case "odc":
+		return FormatODC, nil
+	}
+	return 0, fmt.Errorf("unknown cpio format %q (expected newc, crc or odc)", name)
+}
+
+// File type bits of Header.Mode, as in the Unix st_mode field.
+const (
+	TypeMask    = 0170000
+	TypeSocket  = 0140000
+	TypeSymlink = 0120000
+	TypeReg     = 0100000
+	TypeBlock   = 060000
+	TypeDir     = 040000
+	TypeChar    = 020000
+	TypeFifo    = 010000
+)
+
+const (
+	magicNewc = "070701"
+	magicCRC  = "070702"
+	magicODC  = "070707"
+	trailer   = "TRAILER!!!"
+)
+
+// Header describes one archive member. For symbolic links the data holds the
+// link target, which the Reader and Writer expose as Linkname instead.
+type Header struct {
+	Name     string
+	Mode     int64 // permission and file type bits
+	Uid      int
+	Gid      int
+	Nlink    int
+	ModTime  time.Time
+	Size     int64
+	Linkname string
+
+	Ino       int64
+	DevMajor  int64 // device holding the file, for hard link detection
+	DevMinor  int64
+	RDevMajor int64 // device number of character and block special files
+	RDevMinor int64
+
+	// Check is the sum of all data bytes in FormatCRC archives.
+	Check uint32
+}
+
+// FileMode converts Mode to an fs.FileMode.
+func (h *Header) FileMode() fs.FileMode {
+	mode := fs.FileMode(h.Mode & 0777)
+	if h.Mode&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if h.Mode&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if h.Mode&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	switch h.Mode & TypeMask {
+	case TypeDir:
+		mode |= fs.ModeDir
+	case TypeSymlink:
+		mode |= fs.ModeSymlink
+	case TypeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case TypeBlock:
+		mode |= fs.ModeDevice
+	case TypeFifo:
+		mode |= fs.ModeNamedPipe
+	case TypeSocket:
+		mode |= fs.ModeSocket
+	}
+	return mode
+}
+
+// ModeFromFileMode converts an fs.FileMode to Unix mode bits.
+func ModeFromFileMode(m fs.FileMode) int64 {
+	mode := int64(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 01000
+	}
+	switch {
+	case m.IsDir():
+		mode |= TypeDir
+	case m&fs.ModeSymlink != 0:
+		mode |= TypeSymlink
+	case m&fs.ModeCharDevice != 0:
+		mode |= TypeChar
+	case m&fs.ModeDevice != 0:
+		mode |= TypeBlock
+	case m&fs.ModeNamedPipe != 0:
+		mode |= TypeFifo
+	case m&fs.ModeSocket != 0:
+		mode |= TypeSocket
+	default:
+		mode |= TypeReg
+	}
+	return mode
+}
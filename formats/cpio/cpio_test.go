@@ -0,0 +1,178 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type member struct {
+	Name     string
+	Type     int64
+	Linkname string
+	Data     string
+}
+
+func readArchive(data []byte) ([]member, error) {
+	r := NewReader(bytes.NewReader(data))
+	var members []member
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return members, err
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return members, err
+		}
+		if h.Linkname == "" && int64(len(content)) != h.Size {
+			return members, errors.New("size mismatch")
+		}
+		members = append(members, member{h.Name, h.Mode & TypeMask, h.Linkname, string(content)})
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The fixtures were made with bsdtar 3.7 from a tree holding a directory, a
+// file with a hard link, an empty file, a FIFO, a symlink and a file of odd
+// size:
+//
+//	bsdtar -cnf newc.cpio --format=newc --uid 0 --gid 0 dir dir/hello.txt empty fifo hard.txt link odd.txt
+//	bsdtar -cnf odc.cpio --format=odc --uid 0 --gid 0 dir dir/hello.txt empty fifo hard.txt link odd.txt
+func TestReadFixtures(t *testing.T) {
+	tests := map[string][]member{
+		// newc stores the data of hard links with the last link only
+		"newc.cpio": {
+			{"dir", TypeDir, "", ""},
+			{"empty", TypeReg, "", ""},
+			{"fifo", TypeFifo, "", ""},
+			{"dir/hello.txt", TypeReg, "", ""},
+			{"hard.txt", TypeReg, "", "hello\n"},
+			{"link", TypeSymlink, "dir/hello.txt", ""},
+			{"odd.txt", TypeReg, "", "odd"},
+		},
+		"odc.cpio": {
+			{"dir", TypeDir, "", ""},
+			{"dir/hello.txt", TypeReg, "", "hello\n"},
+			{"empty", TypeReg, "", ""},
+			{"fifo", TypeFifo, "", ""},
+			{"hard.txt", TypeReg, "", "hello\n"},
+			{"link", TypeSymlink, "dir/hello.txt", ""},
+			{"odd.txt", TypeReg, "", "odd"},
+		},
+	}
+	for name, want := range tests {
+		got, err := readArchive(readFixture(t, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	members := []member{
+		{"dir", TypeDir, "", ""},
+		{"dir/file", TypeReg, "", "some data"},
+		{"dir/odd", TypeReg, "", "odd"},
+		{"empty", TypeReg, "", ""},
+		{"link", TypeSymlink, "dir/file", ""},
+		{"fifo", TypeFifo, "", ""},
+	}
+	for _, format := range []Format{FormatNewc, FormatCRC, FormatODC} {
+		t.Run(format.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, format)
+			for i, m := range members {
+				h := &Header{Name: m.Name, Mode: m.Type | 0644, Linkname: m.Linkname, Size: int64(len(m.Data)),
+					Ino: int64(i + 1), Nlink: 1, ModTime: time.Unix(1700000000, 0)}
+				for _, b := range []byte(m.Data) {
+					h.Check += uint32(b)
+				}
+				if err := w.WriteHeader(h); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.WriteString(w, m.Data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readArchive(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, members) {
+				t.Errorf("got %q, want %q", got, members)
+			}
+		})
+	}
+}
+
+// newcHeader returns a newc header with the given mode, size and name.
+func newcHeader(magic string, mode, size int64, check uint32, name string) string {
+	return fmt.Sprintf("%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%s\x00",
+		magic, 1, mode, 0, 0, 1, 0, size, 0, 0, 0, 0, len(name)+1, check, name)
+}
+
+func TestInvalidArchives(t *testing.T) {
+	trailer := newcHeader(magicNewc, 0, 0, 0, "TRAILER!!!")
+	tests := map[string]string{
+		"empty":            "",
+		"bad magic":        "070799",
+		"no trailer":       newcHeader(magicNewc, TypeReg|0644, 0, 0, "a"),
+		"bad hex field":    "070701zzzzzzzz" + trailer[14:],
+		"zero name size":   magicNewc + strings.Repeat("0", 104),
+		"data cut short":   newcHeader(magicNewc, TypeReg|0644, 100, 0, "a") + "abc",
+		"checksum":         newcHeader(magicCRC, TypeReg|0644, 3, 1, "a") + "abc\x00" + trailer,
+		"huge symlink":     newcHeader(magicNewc, TypeSymlink|0777, 0xfffffff0, 0, "a") + "target",
+		"bad octal header": "070707" + "99999999999999999999999999999999999999999999999999999999999999999999999",
+	}
+	for name, data := range tests {
+		if _, err := readArchive([]byte(data)); err == nil {
+			t.Errorf("%s: archive was accepted", name)
+		}
+	}
+}
+
+// TestDamagedArchives checks that truncated and corrupt archives fail with
+// an error rather than a panic. A cpio archive without its trailer is
+// incomplete, so every cut must be reported.
+func TestDamagedArchives(t *testing.T) {
+	for _, name := range []string{"newc.cpio", "odc.cpio"} {
+		data := readFixture(t, name)
+		for n := 0; n < len(data); n++ {
+			// bsdtar pads the archive to a whole block after the trailer
+			if _, err := readArchive(data[:n]); err == nil && !bytes.Contains(data[:n], []byte(trailer)) {
+				t.Errorf("%s truncated to %d bytes was accepted", name, n)
+			}
+		}
+		for i := 0; i < len(data); i++ {
+			damaged := bytes.Clone(data)
+			damaged[i] ^= 0xff
+			_, _ = readArchive(damaged)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package cpio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// maxLinkSize bounds symbolic link targets, as PATH_MAX does on Linux.
+const maxLinkSize = 4096
+
+// Reader reads the members of a cpio archive in any of the ASCII formats.
+type Reader struct {
+	r      *bufio.Reader
+	format Format
+
+	remaining int64 // unread data of the current member
+	pad       int   // padding after the current member's data
+	sum       uint32
+	check     uint32
+	verify    bool
+	name      string
+	err       error
+}
+
+// NewReader returns a Reader for r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 1<<16)}
+}
+
+// Format reports the format of the last header read.
+func (r *Reader) Format() Format {
+	return r.format
+}
+
+// Next advances to the next member. It returns io.EOF at the trailer.
+func (r *Reader) Next() (*Header, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err := r.skipRest(); err != nil {
+		r.err = err
+		return nil, err
+	}
+	h, err := r.readHeader()
+	if err != nil {
+		r.err = err
+		return nil, err
+	}
+	return h, nil
+}
+
+// skipRest discards what is left of the current member and verifies its checksum.
+func (r *Reader) skipRest() error {
+	if r.remaining > 0 {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+	}
+	if r.verify {
+		r.verify = false
+		if r.sum != r.check {
+			return fmt.Errorf("cpio: checksum mismatch for %s", r.name)
+		}
+	}
+	if r.pad > 0 {
+		if _, err := r.r.Discard(r.pad); err != nil {
+			return fmt.Errorf("cpio: truncated archive")
+		}
+		r.pad = 0
+	}
+	return nil
+}
+
+func (r *Reader) readHeader() (*Header, error) {
+	var magic [6]byte
+	if _, err := io.ReadFull(r.r, magic[:]); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("cpio: archive ends without a trailer")
+		}
+		return nil, fmt.Errorf("cpio: truncated header")
+	}
+
+	var h *Header
+	var nameSize int64
+	var err error
+	switch string(magic[:]) {
+	case magicNewc, magicCRC:
+		r.format = FormatNewc
+		if string(magic[:]) == magicCRC {
+			r.format = FormatCRC
+		}
+		h, nameSize, err = r.readNewc()
+	case magicODC:
+		r.format = FormatODC
+		h, nameSize, err = r.readODC()
+	default:
+		return nil, fmt.Errorf("cpio: unsupported or invalid header magic %q", magic[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if nameSize < 1 || nameSize > 1<<16 {
+		return nil, fmt.Errorf("cpio: invalid name size %d", nameSize)
+	}
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(r.r, name); err != nil {
+		return nil, fmt.Errorf("cpio: truncated header")
+	}
+	h.Name = string(name[:nameSize-1])
+	if r.format != FormatODC {
+		// Name and data are aligned to 4 bytes, counting the 110 byte header
+		if _, err := r.r.Discard(int(pad4(110 + nameSize))); err != nil {
+			return nil, fmt.Errorf("cpio: truncated header")
+		}
+		r.pad = int(pad4(h.Size))
+	}
+	if h.Name == trailer {
+		return nil, io.EOF
+	}
+
+	r.remaining = h.Size
+	r.name = h.Name
+	r.sum = 0
+	r.check = h.Check
+	r.verify = r.format == FormatCRC && h.Mode&TypeMask == TypeReg
+	if h.Mode&TypeMask == TypeSymlink {
+		if h.Size > maxLinkSize {
+			return nil, fmt.Errorf("cpio: symlink target of %s is too long", h.Name)
+		}
+		target := make([]byte, h.Size)
+		if _, err := io.ReadFull(r, target); err != nil {
+			return nil, fmt.Errorf("cpio: truncated symlink %s", h.Name)
+		}
+		h.Linkname = string(target)
+	}
+	return h, nil
+}
+
+func pad4(n int64) int64 {
+	return (4 - n%4) % 4
+}
+
+func (r *Reader) readNewc() (*Header, int64, error) {
+	var buf [104]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return nil, 0, fmt.Errorf("cpio: truncated header")
+	}
+	var f [13]int64
+	for i := range f {
+		v, err := strconv.ParseUint(string(buf[i*8:i*8+8]), 16, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cpio: invalid header field %q", buf[i*8:i*8+8])
+		}
+		f[i] = int64(v)
+	}
+	h := &Header{
+		Ino:       f[0],
+		Mode:      f[1],
+		Uid:       int(f[2]),
+		Gid:       int(f[3]),
+		Nlink:     int(f[4]),
+		ModTime:   time.Unix(f[5], 0),
+		Size:      f[6],
+		DevMajor:  f[7],
+		DevMinor:  f[8],
+		RDevMajor: f[9],
+		RDevMinor: f[10],
+		Check:     uint32(f[12]),
+	}
+	return h, f[11], nil
+}
+
+func (r *Reader) readODC() (*Header, int64, error) {
+	var buf [70]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return nil, 0, fmt.Errorf("cpio: truncated header")
+	}
+	widths := []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+	f := make([]int64, len(widths))
+	pos := 0
+	for i, w := range widths {
+		v, err := strconv.ParseUint(string(buf[pos:pos+w]), 8, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cpio: invalid header field %q", buf[pos:pos+w])
+		}
+		f[i] = int64(v)
+		pos += w
+	}
+	// odc packs device numbers into a single 18-bit field
+	h := &Header{
+		DevMajor:  f[0] >> 8,
+		DevMinor:  f[0] & 0xff,
+		Ino:       f[1],
+		Mode:      f[2],
+		Uid:       int(f[3]),
+		Gid:       int(f[4]),
+		Nlink:     int(f[5]),
+		RDevMajor: f[6] >> 8,
+		RDevMinor: f[6] & 0xff,
+		ModTime:   time.Unix(f[7], 0),
+		Size:      f[9],
+	}
+	return h, f[8], nil
+}
+
+// Read reads the data of the current member.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if r.verify {
+		for _, b := range p[:n] {
+			r.sum += uint32(b)
+		}
+	}
+	if err == io.EOF && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if r.remaining == 0 && r.verify && r.sum != r.check {
+		r.verify = false
+		return n, fmt.Errorf("cpio: checksum mismatch for %s", r.name)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,163 @@
+package cpio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Writer writes a cpio archive. Members must be written in full before the
+// next header; Close writes the trailer.
+type Writer struct {
+	w      *bufio.Writer
+	format Format
+
+	written   int64
+	remaining int64
+	pad       int64
+	sum       uint32
+	check     uint32
+	verify    bool
+	name      string
+	closed    bool
+}
+
+// NewWriter returns a Writer producing the given format.
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{w: bufio.NewWriterSize(w, 1<<16), format: format}
+}
+
+// WriteHeader starts a new member. For symbolic links Linkname is written as
+// the data and Size is ignored.
+func (w *Writer) WriteHeader(h *Header) error {
+	if w.closed {
+		return fmt.Errorf("cpio: write after close")
+	}
+	if err := w.finish(); err != nil {
+		return err
+	}
+	hdr := *h
+	if hdr.Mode&TypeMask == TypeSymlink {
+		hdr.Size = int64(len(hdr.Linkname))
+	}
+	if err := w.writeHeader(&hdr); err != nil {
+		return err
+	}
+	w.remaining = hdr.Size
+	w.sum = 0
+	w.check = hdr.Check
+	w.name = hdr.Name
+	w.verify = w.format == FormatCRC && hdr.Mode&TypeMask == TypeReg
+	if w.format != FormatODC {
+		w.pad = pad4(hdr.Size)
+	}
+	if hdr.Mode&TypeMask == TypeSymlink {
+		if _, err := io.WriteString(w, hdr.Linkname); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeHeader(h *Header) error {
+	nameSize := int64(len(h.Name) + 1)
+	var hdr string
+	switch w.format {
+	case FormatNewc, FormatCRC:
+		magic := magicNewc
+		check := uint32(0)
+		if w.format == FormatCRC {
+			magic = magicCRC
+			check = h.Check
+		}
+		fields := []int64{h.Ino, h.Mode, int64(h.Uid), int64(h.Gid), int64(h.Nlink), h.ModTime.Unix(), h.Size,
+			h.DevMajor, h.DevMinor, h.RDevMajor, h.RDevMinor, nameSize, int64(check)}
+		hdr = magic
+		for _, f := range fields {
+			if f < 0 || f > 0xFFFFFFFF {
+				return fmt.Errorf("cpio: %s: value %d does not fit the %s format", h.Name, f, w.format)
+			}
+			hdr += fmt.Sprintf("%08X", f)
+		}
+		hdr += h.Name + "\x00"
+		hdr += string(make([]byte, pad4(110+nameSize)))
+	case FormatODC:
+		fields := []struct {
+			v     int64
+			width int
+		}{
+			{h.DevMajor<<8 | h.DevMinor, 6}, {h.Ino, 6}, {h.Mode, 6}, {int64(h.Uid), 6}, {int64(h.Gid), 6},
+			{int64(h.Nlink), 6}, {h.RDevMajor<<8 | h.RDevMinor, 6}, {h.ModTime.Unix(), 11}, {nameSize, 6}, {h.Size, 11},
+		}
+		hdr = magicODC
+		for _, f := range fields {
+			s := fmt.Sprintf("%0*o", f.width, f.v)
+			if f.v < 0 || len(s) > f.width {
+				return fmt.Errorf("cpio: %s: value %d does not fit the odc format", h.Name, f.v)
+			}
+			hdr += s
+		}
+		hdr += h.Name + "\x00"
+	default:
+		return fmt.Errorf("cpio: unsupported format %s", w.format)
+	}
+	n, err := io.WriteString(w.w, hdr)
+	w.written += int64(n)
+	return err
+}
+
+// Write writes data for the current member.
+func (w *Writer) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		return 0, fmt.Errorf("cpio: write too long for %s", w.name)
+	}
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	w.remaining -= int64(n)
+	if w.verify {
+		for _, b := range p[:n] {
+			w.sum += uint32(b)
+		}
+	}
+	return n, err
+}
+
+// finish checks the current member is complete and writes its padding.
+func (w *Writer) finish() error {
+	if w.remaining > 0 {
+		return fmt.Errorf("cpio: missing %d bytes of data for %s", w.remaining, w.name)
+	}
+	if w.verify && w.sum != w.check {
+		return fmt.Errorf("cpio: checksum in header of %s does not match its data", w.name)
+	}
+	w.verify = false
+	if w.pad > 0 {
+		n, err := w.w.Write(make([]byte, w.pad))
+		w.written += int64(n)
+		w.pad = 0
+		return err
+	}
+	return nil
+}
+
+// Close writes the trailer and pads the archive to a 512 byte boundary. It
+// does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.finish(); err != nil {
+		return err
+	}
+	if err := w.writeHeader(&Header{Name: trailer, Nlink: 1, ModTime: time.Unix(0, 0)}); err != nil {
+		return err
+	}
+	w.closed = true
+	if rem := w.written % 512; rem != 0 {
+		if _, err := w.w.Write(make([]byte, 512-rem)); err != nil {
+			return err
+		}
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,110 @@
+// Package deb reads and writes Debian binary packages: an ar archive holding
+// a format version, a control tarball and a data tarball.
+package deb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Field is one field of a control paragraph. Continuation lines of multi-line
+// values are kept in Value, separated by newlines.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Control is the paragraph of fields in a package's control file, in order.
+type Control []Field
+
+// requiredFields must be present in a binary package's control file.
+var requiredFields = []string{"Package", "Version", "Architecture", "Maintainer", "Description"}
+
+var (
+	packageName = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+	versionText = regexp.MustCompile(`^([0-9]+:)?[0-9][A-Za-z0-9.+~:-]*$`)
+)
+
+// ParseControl parses a control file with a single paragraph.
+func ParseControl(data []byte) (Control, error) {
+	var c Control
+	for i, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(c) > 0 {
+				// Anything after the first paragraph is ignored
+				return c, nil
+			}
+		case strings.HasPrefix(line, "#"):
+		case line[0] == ' ' || line[0] == '\t':
+			if len(c) == 0 {
+				return nil, fmt.Errorf("control file line %d: continuation line before any field", i+1)
+			}
+			c[len(c)-1].Value += "\n" + line
+		default:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok || name == "" || strings.ContainsAny(name, " \t") {
+				return nil, fmt.Errorf("control file line %d: expected \"Field: value\"", i+1)
+			}
+			if c.Get(name) != "" {
+				return nil, fmt.Errorf("control file line %d: duplicate field %s", i+1, name)
+			}
+			c = append(c, Field{Name: name, Value: strings.TrimSpace(value)})
+		}
+	}
+	if len(c) == 0 {
+		return nil, fmt.Errorf("control file is empty")
+	}
+	return c, nil
+}
+
+// Get returns the value of a field, matching its name case-insensitively.
+func (c Control) Get(name string) string {
+	for _, f := range c {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of a field or appends it.
+func (c *Control) Set(name, value string) {
+	for i, f := range *c {
+		if strings.EqualFold(f.Name, name) {
+			(*c)[i].Value = value
+			return
+		}
+	}
+	*c = append(*c, Field{Name: name, Value: value})
+}
+
+// Validate checks that the fields dpkg requires are present and well formed.
+func (c Control) Validate() error {
+	for _, name := range requiredFields {
+		if c.Get(name) == "" {
+			return fmt.Errorf("control file lacks the required %s field", name)
+		}
+	}
+	if name := c.Get("Package"); !packageName.MatchString(name) {
+		return fmt.Errorf("invalid package name %q", name)
+	}
+	if version := c.Get("Version"); !versionText.MatchString(version) {
+		return fmt.Errorf("invalid package version %q", version)
+	}
+	return nil
+}
+
+// String formats the paragraph as a control file.
+func (c Control) String() string {
+	var b strings.Builder
+	for _, f := range c {
+		b.WriteString(f.Name + ":")
+		if f.Value != "" && f.Value[0] != '\n' {
+			b.WriteString(" ")
+		}
+		b.WriteString(f.Value + "\n")
+	}
+	return b.String()
+}
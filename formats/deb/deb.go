@@ -0,0 +1,189 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"futile/compress/xz"
+	"futile/compress/zstd"
+	"futile/formats/ar"
+	"io"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the content of the debian-binary member.
+const FormatVersion = "2.0\n"
+
+// Compressions lists the tarball compressions accepted by Compressor.
+var Compressions = []string{"gz", "xz", "zst", "none"}
+
+// Member names the parts of a package as Walk reports them.
+type Member string
+
+const (
+	MemberControl Member = "control"
+	MemberData    Member = "data"
+)
+
+// Walk reads a package and calls fn with the decompressed streams of the
+// control and data tarballs in the order they are stored. The name passed to fn is the
+// member's name in the ar archive, such as "data.tar.xz".
+func Walk(r io.Reader, fn func(member Member, name string, r io.Reader) error) error {
+	members, err := ar.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not a Debian package: %w", err)
+	}
+	hdr, err := members.Next()
+	if err != nil || hdr.Name != "debian-binary" {
+		return fmt.Errorf("not a Debian package: debian-binary must come first")
+	}
+	version, err := io.ReadAll(io.LimitReader(members, 64))
+	if err != nil {
+		return fmt.Errorf("failed to read package format: %w", err)
+	}
+	if !strings.HasPrefix(string(version), "2.") {
+		return fmt.Errorf("unsupported package format %q", strings.TrimSpace(string(version)))
+	}
+
+	for {
+		hdr, err := members.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read package: %w", err)
+		}
+		var member Member
+		switch {
+		case strings.HasPrefix(hdr.Name, "control.tar"):
+			member = MemberControl
+		case strings.HasPrefix(hdr.Name, "data.tar"):
+			member = MemberData
+		default:
+			// Members starting with an underscore are optional extensions
+			continue
+		}
+		dec, err := Decompressor(hdr.Name, members)
+		if err != nil {
+			return err
+		}
+		if err := fn(member, hdr.Name, dec); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadControl returns the control paragraph and every file of the control
+// tarball, keyed by name without the leading "./".
+func ReadControl(r io.Reader) (Control, map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := Walk(r, func(member Member, name string, r io.Reader) error {
+		if member != MemberControl {
+			return nil
+		}
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			data, err := io.ReadAll(io.LimitReader(tr, 16<<20))
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			files[strings.TrimPrefix(hdr.Name, "./")] = data
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	data, ok := files["control"]
+	if !ok {
+		return nil, nil, fmt.Errorf("package has no control file")
+	}
+	control, err := ParseControl(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return control, files, nil
+}
+
+// Decompressor wraps r according to the extension of a tarball member name.
+func Decompressor(name string, r io.Reader) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return r, nil
+	case strings.HasSuffix(name, ".gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(name, ".xz"):
+		return xz.NewReader(r)
+	case strings.HasSuffix(name, ".zst"):
+		return zstd.NewReader(r)
+	case strings.HasSuffix(name, ".bz2"):
+		return bzip2.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("unsupported compression for %s", name)
+}
+
+// Compressor returns the member name suffix and a compressing writer for one
+// of the Compressions.
+func Compressor(compression string, w io.Writer, level int) (string, io.WriteCloser, error) {
+	switch compression {
+	case "gz":
+		zw, err := gzip.NewWriterLevel(w, level)
+		return ".gz", zw, err
+	case "", "xz":
+		zw, err := xz.NewWriterLevel(w, level)
+		return ".xz", zw, err
+	case "zst":
+		zw, err := zstd.NewWriterOptions(w, zstd.WriterOptions{Level: level})
+		return ".zst", zw, err
+	case "none":
+		return "", nopWriteCloser{w}, nil
+	}
+	return "", nil, fmt.Errorf("unknown package compression %q (expected %s)", compression, strings.Join(Compressions, ", "))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// WritePackage writes the ar container of a package from its compressed
+// control and data tarballs. size is the length of data.
+func WritePackage(w io.Writer, mtime time.Time, controlName string, control []byte, dataName string, data io.Reader, size int64) error {
+	aw, err := ar.NewWriter(w, ar.FormatGNU)
+	if err != nil {
+		return err
+	}
+	members := []struct {
+		name string
+		r    io.Reader
+		size int64
+	}{
+		{"debian-binary", strings.NewReader(FormatVersion), int64(len(FormatVersion))},
+		{controlName, bytes.NewReader(control), int64(len(control))},
+		{dataName, data, size},
+	}
+	for _, m := range members {
+		hdr := &ar.Header{Name: m.name, ModTime: mtime, Mode: 0100644, Size: m.size}
+		if err := aw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(aw, m.r, m.size); err != nil {
+			return fmt.Errorf("failed to write %s: %w", m.name, err)
+		}
+	}
+	return aw.Close()
+}
@@ -0,0 +1,67 @@
+// Package iso9660 reads and writes ISO 9660 images with the Joliet and Rock
+// Ridge extensions.
+package iso9660
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// SectorSize is the logical block size of the images this package handles.
+const SectorSize = 2048
+
+// Volume descriptor types.
+const (
+	descBoot          = 0
+	descPrimary       = 1
+	descSupplementary = 2
+	descTerminator    = 255
+)
+
+// Directory record flags.
+const (
+	flagHidden      = 0x01
+	flagDir         = 0x02
+	flagMultiExtent = 0x80
+)
+
+const standardID = "CD001"
+
+// jolietEscapes identify a supplementary descriptor as Joliet, for UCS-2
+// levels 1 to 3.
+var jolietEscapes = []string{"%/@", "%/C", "%/E"}
+
+// bothEndian32 reads a 32-bit field recorded in both byte orders.
+func bothEndian32(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b)
+}
+
+func putBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func putBothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+// recordingTime decodes the 7 byte date of a directory record.
+func recordingTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 && b[2] == 0 {
+		return time.Time{}
+	}
+	loc := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, loc)
+}
+
+func putRecordingTime(b []byte, t time.Time) {
+	t = t.UTC()
+	b[0] = byte(t.Year() - 1900)
+	b[1] = byte(t.Month())
+	b[2] = byte(t.Day())
+	b[3] = byte(t.Hour())
+	b[4] = byte(t.Minute())
+	b[5] = byte(t.Second())
+	b[6] = 0
+}
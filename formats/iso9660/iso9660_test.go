@@ -0,0 +1,299 @@
+package iso9660
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type member struct {
+	Path     string
+	Mode     fs.FileMode
+	Linkname string
+	Data     string
+}
+
+func readImage(data []byte) ([]member, error) {
+	img, err := Open(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var members []member
+	err = img.Walk(func(f *File) error {
+		m := member{Path: f.Path, Mode: f.Mode, Linkname: f.Linkname}
+		if f.Mode.IsRegular() {
+			content, err := io.ReadAll(f.Open())
+			if err != nil {
+				return err
+			}
+			if int64(len(content)) != f.Size {
+				return errors.New("size mismatch")
+			}
+			m.Data = string(content)
+		}
+		members = append(members, m)
+		return nil
+	})
+	return members, err
+}
+
+// readFixture returns a fixture image. Images are mostly empty sectors, so
+// they are stored compressed.
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name+".gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The fixtures were made with bsdtar 3.7 from a tree holding a directory
+// nested nine deep, which Rock Ridge relocates, and a symlink, which only
+// Rock Ridge can hold; the other images leave both out:
+//
+//	bsdtar -cf rr.iso --format=iso9660 --options '!pad' -C src .
+//	bsdtar -cf joliet.iso --format=iso9660 --options '!rockridge,!pad' -C flat .
+//	bsdtar -cf plain.iso --format=iso9660 --options '!rockridge,!joliet,!pad' -C flat .
+func TestReadFixtures(t *testing.T) {
+	long := "A Mixed-Case name that is long.text"
+	deep := "dir/a/b/c/d/e/f/g/h"
+	tests := []struct {
+		name      string
+		rockRidge bool
+		joliet    bool
+		want      map[string]member
+	}{
+		{"rr.iso", true, true, map[string]member{
+			long:                  {long, 0444, "", "x\n"},
+			"dir":                 {"dir", fs.ModeDir | 0555, "", ""},
+			"dir/hello.txt":       {"dir/hello.txt", 0444, "", "hello\n"},
+			deep + "/deep.txt":    {deep + "/deep.txt", 0444, "", "deep\n"},
+			"link":                {"link", fs.ModeSymlink | 0555, "dir/hello.txt", ""},
+			"odd":                 {"odd", 0444, "", "odd"},
+			"dir/a/b/c/d/e/f/g/h": {deep, fs.ModeDir | 0555, "", ""},
+			"dir/a/b/c/d/e/f/g":   {"dir/a/b/c/d/e/f/g", fs.ModeDir | 0555, "", ""},
+			"dir/a/b/c/d/e/f":     {"dir/a/b/c/d/e/f", fs.ModeDir | 0555, "", ""},
+			"dir/a/b/c/d/e":       {"dir/a/b/c/d/e", fs.ModeDir | 0555, "", ""},
+			"dir/a/b/c/d":         {"dir/a/b/c/d", fs.ModeDir | 0555, "", ""},
+			"dir/a/b/c":           {"dir/a/b/c", fs.ModeDir | 0555, "", ""},
+			"dir/a/b":             {"dir/a/b", fs.ModeDir | 0555, "", ""},
+			"dir/a":               {"dir/a", fs.ModeDir | 0555, "", ""},
+		}},
+		{"joliet.iso", false, true, map[string]member{
+			long:            {long, 0644, "", "x\n"},
+			"dir":           {"dir", fs.ModeDir | 0755, "", ""},
+			"dir/hello.txt": {"dir/hello.txt", 0644, "", "hello\n"},
+			"odd":           {"odd", 0644, "", "odd"},
+		}},
+		{"plain.iso", false, false, map[string]member{
+			"A_MIXED_.TEX":  {"A_MIXED_.TEX", 0644, "", "x\n"},
+			"DIR":           {"DIR", fs.ModeDir | 0755, "", ""},
+			"DIR/HELLO.TXT": {"DIR/HELLO.TXT", 0644, "", "hello\n"},
+			"ODD":           {"ODD", 0644, "", "odd"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := readFixture(t, tt.name)
+			img, err := Open(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.RockRidge != tt.rockRidge || img.Joliet != tt.joliet {
+				t.Errorf("got Rock Ridge %v, Joliet %v", img.RockRidge, img.Joliet)
+			}
+			members, err := readImage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]member)
+			seen := make(map[string]bool)
+			for _, m := range members {
+				if dir := path.Dir(m.Path); dir != "." && !seen[dir] {
+					t.Errorf("%s visited before its directory", m.Path)
+				}
+				seen[m.Path] = true
+				got[m.Path] = m
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	mtime := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	file := func(name, data string) *Node {
+		return &Node{Name: name, Mode: 0640, ModTime: mtime, Size: int64(len(data)),
+			Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(data)), nil }}
+	}
+	big := strings.Repeat("sector ", 1000)
+	deep := &Node{Name: "9", Mode: fs.ModeDir | 0700, ModTime: mtime, Children: []*Node{file("deep.txt", "deep\n")}}
+	for i := 8; i >= 1; i-- {
+		deep = &Node{Name: string(rune('0' + i)), Mode: fs.ModeDir | 0700, ModTime: mtime, Children: []*Node{deep}}
+	}
+	root := &Node{Mode: fs.ModeDir | 0755, ModTime: mtime, Children: []*Node{
+		deep,
+		file("empty", ""),
+		file("big.txt", big),
+		file("a name with spaces and a very long extension.text", "x"),
+		{Name: "link", Mode: fs.ModeSymlink | 0777, ModTime: mtime, Linkname: "../up/./big.txt"},
+		{Name: "null", Mode: fs.ModeDevice | fs.ModeCharDevice | 0666, ModTime: mtime, RDevMajor: 1, RDevMinor: 3},
+		{Name: "fifo", Mode: fs.ModeNamedPipe | 0600, ModTime: mtime},
+	}}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, root, WriterOptions{VolumeID: "TEST"}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.VolumeID != "TEST" || !img.RockRidge || !img.Joliet {
+		t.Errorf("got volume %q, Rock Ridge %v, Joliet %v", img.VolumeID, img.RockRidge, img.Joliet)
+	}
+	err = img.Walk(func(f *File) error {
+		if !f.ModTime.Equal(mtime) {
+			t.Errorf("%s: got time %v", f.Path, f.ModTime)
+		}
+		if f.Path == "null" && (f.RDevMajor != 1 || f.RDevMinor != 3) {
+			t.Errorf("got device %d,%d, want 1,3", f.RDevMajor, f.RDevMinor)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := readImage(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]member)
+	for _, m := range members {
+		got[m.Path] = m
+	}
+	want := map[string]member{
+		"empty":   {"empty", 0640, "", ""},
+		"big.txt": {"big.txt", 0640, "", big},
+		"a name with spaces and a very long extension.text": {"a name with spaces and a very long extension.text", 0640, "", "x"},
+		"link": {"link", fs.ModeSymlink | 0777, "../up/./big.txt", ""},
+		"null": {"null", fs.ModeDevice | fs.ModeCharDevice | 0666, "", ""},
+		"fifo": {"fifo", fs.ModeNamedPipe | 0600, "", ""},
+	}
+	for p := "1"; len(p) < 18; p += "/" + string(rune('0'+len(p)/2+2)) {
+		want[p] = member{p, fs.ModeDir | 0700, "", ""}
+	}
+	want["1/2/3/4/5/6/7/8/9/deep.txt"] = member{"1/2/3/4/5/6/7/8/9/deep.txt", 0640, "", "deep\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// setRoot points the root record of the primary volume descriptor at lba.
+func setRoot(data []byte, lba uint32) {
+	putBothEndian32(data[16*SectorSize+156+2:], lba)
+}
+
+func rootLBA(data []byte) uint32 {
+	return bothEndian32(data[16*SectorSize+156+2:])
+}
+
+func TestInvalidImages(t *testing.T) {
+	plain := func(t *testing.T) []byte { return readFixture(t, "plain.iso") }
+	tests := map[string]func(t *testing.T) []byte{
+		"empty":         func(*testing.T) []byte { return nil },
+		"no descriptor": func(*testing.T) []byte { return make([]byte, 20*SectorSize) },
+		"block size": func(t *testing.T) []byte {
+			data := plain(t)
+			data[16*SectorSize+129] = 0x10
+			return data
+		},
+		"root beyond the image": func(t *testing.T) []byte {
+			data := plain(t)
+			setRoot(data, 1<<20)
+			return data
+		},
+		"short record": func(t *testing.T) []byte {
+			data := plain(t)
+			data[int(rootLBA(data))*SectorSize] = 20
+			return data
+		},
+		"name beyond record": func(t *testing.T) []byte {
+			data := plain(t)
+			data[int(rootLBA(data))*SectorSize+32] = 200
+			return data
+		},
+		"file cut short": func(t *testing.T) []byte {
+			data := plain(t)
+			return data[:bytes.LastIndex(data, []byte("hello\n"))+3]
+		},
+	}
+	for name, damage := range tests {
+		if _, err := readImage(damage(t)); err == nil {
+			t.Errorf("%s: image was accepted", name)
+		}
+	}
+}
+
+func TestDirectoryLoop(t *testing.T) {
+	// DIR's record in the root points back at the root directory
+	data := readFixture(t, "plain.iso")
+	root := int(rootLBA(data)) * SectorSize
+	i := root + bytes.Index(data[root:root+SectorSize], []byte("DIR")) - 33
+	putBothEndian32(data[i+2:], rootLBA(data))
+	members, err := readImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 {
+		t.Errorf("got %d members, want 3", len(members))
+	}
+}
+
+// TestDamagedImages checks that truncated and corrupt images fail with an
+// error rather than a panic. File data ends the images, so a cut that is
+// accepted must leave every file whole.
+func TestDamagedImages(t *testing.T) {
+	for _, name := range []string{"rr.iso", "joliet.iso", "plain.iso"} {
+		data := readFixture(t, name)
+		want, err := readImage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Cuts within a sector fail alike, so a stride finds every kind
+		for n := 0; n < len(data); n += 7 {
+			got, err := readImage(data[:n])
+			if err == nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("%s truncated to %d bytes: got %q", name, n, got)
+			}
+		}
+		// The system area before the descriptors is unused
+		for i := 16 * SectorSize; i < len(data); i += 3 {
+			data[i] ^= 0xff
+			_, _ = readImage(data)
+			data[i] ^= 0xff
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// File is a file, directory or special file in an image.
+type File struct {
+	Path     string // slash separated, relative to the root
+	Mode     fs.FileMode
+	Size     int64
+	ModTime  time.Time
+	Uid      int
+	Gid      int
+	Linkname string // symbolic link target, from Rock Ridge
+
+	// Device number of character and block special files, from Rock Ridge
+	RDevMajor uint32
+	RDevMinor uint32
+
+	img     *Image
+	extents []extent
+}
+
+type extent struct {
+	lba  uint32
+	size uint32
+}
+
+// Open returns the content of a regular file. Reading fails with
+// io.ErrUnexpectedEOF when the image ends before the file does.
+func (f *File) Open() io.Reader {
+	readers := make([]io.Reader, len(f.extents))
+	for i, e := range f.extents {
+		readers[i] = io.NewSectionReader(f.img.r, int64(e.lba)*SectorSize, int64(e.size))
+	}
+	return &fileReader{r: io.MultiReader(readers...), left: f.Size}
+}
+
+// fileReader reports a file cut short by the end of the image.
+type fileReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (fr *fileReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	fr.left -= int64(n)
+	if err == io.EOF && fr.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Image is an ISO 9660 image opened for reading.
+type Image struct {
+	VolumeID  string
+	Joliet    bool // the image has a Joliet tree
+	RockRidge bool // the image has Rock Ridge entries, which take precedence
+
+	r         io.ReaderAt
+	root      extent
+	useJoliet bool
+	suspSkip  int
+}
+
+// maxDepth bounds directory nesting, guarding against malformed images.
+const maxDepth = 256
+
+// Open reads the volume descriptors of an image.
+func Open(r io.ReaderAt) (*Image, error) {
+	img := &Image{r: r}
+	var primary, joliet []byte
+	for sector := int64(16); ; sector++ {
+		desc := make([]byte, SectorSize)
+		if _, err := r.ReadAt(desc, sector*SectorSize); err != nil {
+			if primary != nil {
+				break
+			}
+			return nil, fmt.Errorf("not an ISO 9660 image")
+		}
+		if string(desc[1:6]) != standardID {
+			if primary != nil {
+				break
+			}
+			return nil, fmt.Errorf("not an ISO 9660 image")
+		}
+		switch desc[0] {
+		case descPrimary:
+			if primary == nil {
+				primary = desc
+			}
+		case descSupplementary:
+			for _, esc := range jolietEscapes {
+				if string(desc[88:91]) == esc && joliet == nil {
+					joliet = desc
+				}
+			}
+		}
+		if desc[0] == descTerminator || sector > 16+64 {
+			break
+		}
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("ISO 9660 image has no primary volume descriptor")
+	}
+	if size := binary.LittleEndian.Uint16(primary[128:]); size != SectorSize {
+		return nil, fmt.Errorf("unsupported logical block size %d", size)
+	}
+	img.VolumeID = strings.TrimRight(string(primary[40:72]), " ")
+	img.root = rootExtent(primary)
+	img.Joliet = joliet != nil
+
+	if err := img.detectRockRidge(); err != nil {
+		return nil, err
+	}
+	if !img.RockRidge && joliet != nil {
+		img.useJoliet = true
+		img.root = rootExtent(joliet)
+		if id := decodeUCS2(bytes.TrimRight(joliet[40:72], "\x00")); strings.TrimSpace(id) != "" {
+			img.VolumeID = strings.TrimRight(id, " ")
+		}
+	}
+	return img, nil
+}
+
+func rootExtent(desc []byte) extent {
+	rec := desc[156:190]
+	return extent{lba: bothEndian32(rec[2:]), size: bothEndian32(rec[10:])}
+}
+
+// detectRockRidge looks for the SUSP indicator in the root directory's "."
+// record and a Rock Ridge extension reference or entries after it.
+func (img *Image) detectRockRidge() error {
+	data := make([]byte, SectorSize)
+	if _, err := img.r.ReadAt(data, int64(img.root.lba)*SectorSize); err != nil {
+		return fmt.Errorf("failed to read root directory: %w", err)
+	}
+	rec, ok := record(data)
+	if !ok {
+		return fmt.Errorf("invalid root directory")
+	}
+	su := systemUse(rec)
+	if len(su) < 7 || string(su[:2]) != "SP" || su[4] != 0xBE || su[5] != 0xEF {
+		return nil
+	}
+	img.suspSkip = int(su[6])
+	for _, e := range img.suspEntries(su) {
+		switch e.sig {
+		case "ER":
+			if len(e.data) >= 4 {
+				id := string(e.data[4:min(len(e.data), 4+int(e.data[0]))])
+				if strings.HasPrefix(id, "RRIP") || strings.HasPrefix(id, "IEEE_P1282") || strings.HasPrefix(id, "IEEE_1282") {
+					img.RockRidge = true
+				}
+			}
+		case "RR", "PX", "NM":
+			img.RockRidge = true
+		}
+	}
+	return nil
+}
+
+// record returns the directory record at the start of b.
+func record(b []byte) ([]byte, bool) {
+	if len(b) < 34 || int(b[0]) < 34 || int(b[0]) > len(b) || 33+int(b[32]) > int(b[0]) {
+		return nil, false
+	}
+	return b[:b[0]], true
+}
+
+// systemUse returns the system use area of a directory record.
+func systemUse(rec []byte) []byte {
+	start := 33 + int(rec[32])
+	if start%2 == 1 {
+		start++
+	}
+	if start >= len(rec) {
+		return nil
+	}
+	return rec[start:]
+}
+
+type suspEntry struct {
+	sig  string
+	data []byte
+}
+
+// suspEntries parses System Use Sharing Protocol entries, following
+// continuation areas.
+func (img *Image) suspEntries(su []byte) []suspEntry {
+	var entries []suspEntry
+	for hops := 0; hops < 32; hops++ {
+		var next []byte
+		for len(su) >= 4 {
+			n := int(su[2])
+			if n < 4 || n > len(su) {
+				break
+			}
+			e := suspEntry{sig: string(su[:2]), data: su[4:n]}
+			su = su[n:]
+			switch e.sig {
+			case "ST":
+				su = nil
+			case "CE":
+				if len(e.data) >= 24 {
+					block, off, size := bothEndian32(e.data), bothEndian32(e.data[8:]), bothEndian32(e.data[16:])
+					if size <= SectorSize && off+size <= SectorSize {
+						buf := make([]byte, size)
+						if _, err := img.r.ReadAt(buf, int64(block)*SectorSize+int64(off)); err == nil {
+							next = buf
+						}
+					}
+				}
+			default:
+				entries = append(entries, e)
+			}
+		}
+		if next == nil {
+			break
+		}
+		su = next
+	}
+	return entries
+}
+
+// Walk calls fn for every file in the image, parents before children.
+func (img *Image) Walk(fn func(f *File) error) error {
+	visited := map[uint32]bool{img.root.lba: true}
+	return img.walkDir(img.root, "", 0, visited, fn)
+}
+
+func (img *Image) walkDir(dir extent, prefix string, depth int, visited map[uint32]bool, fn func(*File) error) error {
+	if depth > maxDepth {
+		return fmt.Errorf("directory nesting too deep at %s", prefix)
+	}
+	if dir.size > 64<<20 {
+		return fmt.Errorf("directory %s too large", prefix)
+	}
+	data := make([]byte, dir.size)
+	if _, err := img.r.ReadAt(data, int64(dir.lba)*SectorSize); err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", prefix, err)
+	}
+
+	var pending *File
+	for pos := 0; pos < len(data); {
+		if data[pos] == 0 {
+			// Records do not cross sector boundaries
+			pos = (pos/SectorSize + 1) * SectorSize
+			continue
+		}
+		rec, ok := record(data[pos:])
+		if !ok {
+			return fmt.Errorf("invalid directory record in %s", prefix)
+		}
+		pos += len(rec)
+		rawName := rec[33 : 33+rec[32]]
+		if len(rawName) == 1 && rawName[0] <= 1 {
+			continue // "." and ".."
+		}
+
+		ext := extent{lba: bothEndian32(rec[2:]), size: bothEndian32(rec[10:])}
+		flags := rec[25]
+		if pending != nil {
+			// Continuation of a multi-extent file
+			pending.extents = append(pending.extents, ext)
+			pending.Size += int64(ext.size)
+			if flags&flagMultiExtent == 0 {
+				if err := fn(pending); err != nil {
+					return err
+				}
+				pending = nil
+			}
+			continue
+		}
+
+		f, relocated := img.newFile(rec, rawName, prefix, ext)
+		if relocated {
+			continue
+		}
+		if f.Mode.IsDir() {
+			if depth == 0 && img.RockRidge && img.onlyRelocated(f) {
+				// The holding directory of deep directory relocation
+				continue
+			}
+			if err := fn(f); err != nil {
+				return err
+			}
+			child := f.extents[0]
+			if visited[child.lba] {
+				continue
+			}
+			visited[child.lba] = true
+			if err := img.walkDir(child, f.Path, depth+1, visited, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if flags&flagMultiExtent != 0 {
+			pending = f
+			continue
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	if pending != nil {
+		return fn(pending)
+	}
+	return nil
+}
+
+// newFile builds a File from a directory record. It reports directories
+// moved by Rock Ridge deep directory relocation, which are visited through
+// their child link instead.
+func (img *Image) newFile(rec, rawName []byte, prefix string, ext extent) (*File, bool) {
+	f := &File{
+		img:     img,
+		extents: []extent{ext},
+		Size:    int64(ext.size),
+		ModTime: recordingTime(rec[18:25]),
+		Mode:    0644,
+	}
+	if rec[25]&flagDir != 0 {
+		f.Mode = fs.ModeDir | 0755
+		f.Size = 0
+	}
+
+	var name string
+	switch {
+	case img.useJoliet:
+		name = stripVersion(decodeUCS2(rawName))
+	default:
+		// Names without an extension are recorded with a trailing dot
+		name = strings.TrimSuffix(stripVersion(string(rawName)), ".")
+	}
+
+	if img.RockRidge {
+		su := systemUse(rec)
+		if len(su) > img.suspSkip {
+			su = su[img.suspSkip:]
+		}
+		var rrName string
+		var child *extent
+		hasName, linkContinued := false, false
+		for _, e := range img.suspEntries(su) {
+			switch e.sig {
+			case "PX":
+				if len(e.data) >= 32 {
+					f.Mode = unixMode(bothEndian32(e.data))
+					f.Uid = int(bothEndian32(e.data[16:]))
+					f.Gid = int(bothEndian32(e.data[24:]))
+				}
+			case "NM":
+				if len(e.data) >= 1 && e.data[0]&0x06 == 0 {
+					rrName += string(e.data[1:])
+					hasName = true
+				}
+			case "SL":
+				if len(e.data) >= 1 {
+					f.Linkname, linkContinued = appendSymlink(f.Linkname, e.data[1:], linkContinued)
+				}
+			case "TF":
+				if t, ok := modifyTime(e.data); ok {
+					f.ModTime = t
+				}
+			case "PN":
+				if len(e.data) >= 16 {
+					f.RDevMajor = bothEndian32(e.data)
+					f.RDevMinor = bothEndian32(e.data[8:])
+					// A zero high word holds a packed 16-bit device number,
+					// as Linux reads it
+					if f.RDevMajor == 0 && f.RDevMinor > 0xff {
+						f.RDevMajor, f.RDevMinor = f.RDevMinor>>8, f.RDevMinor&0xff
+					}
+				}
+			case "CL":
+				if len(e.data) >= 8 {
+					ext := img.dirExtent(bothEndian32(e.data))
+					child = &ext
+				}
+			case "RE":
+				return nil, true
+			}
+		}
+		if hasName {
+			name = rrName
+		}
+		if child != nil {
+			// A placeholder file standing in for a relocated directory
+			f.Mode = fs.ModeDir | f.Mode.Perm()
+			f.extents = []extent{*child}
+		}
+		if !f.Mode.IsRegular() {
+			f.Size = 0
+		}
+	}
+
+	f.Path = name
+	if prefix != "" {
+		f.Path = prefix + "/" + name
+	}
+	return f, false
+}
+
+// onlyRelocated reports whether every entry of a directory was moved there
+// by deep directory relocation, as in the "rr_moved" directory.
+func (img *Image) onlyRelocated(dir *File) bool {
+	ext := dir.extents[0]
+	if ext.size > 1<<20 {
+		return false
+	}
+	data := make([]byte, ext.size)
+	if _, err := img.r.ReadAt(data, int64(ext.lba)*SectorSize); err != nil {
+		return false
+	}
+	found := false
+	for pos := 0; pos < len(data); {
+		if data[pos] == 0 {
+			pos = (pos/SectorSize + 1) * SectorSize
+			continue
+		}
+		rec, ok := record(data[pos:])
+		if !ok {
+			return false
+		}
+		pos += len(rec)
+		if name := rec[33 : 33+rec[32]]; len(name) == 1 && name[0] <= 1 {
+			continue
+		}
+		if _, relocated := img.newFile(rec, rec[33:33+rec[32]], "", extent{}); !relocated {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// dirExtent reads the size of a directory from its own "." record.
+func (img *Image) dirExtent(lba uint32) extent {
+	ext := extent{lba: lba}
+	buf := make([]byte, 255)
+	if _, err := img.r.ReadAt(buf, int64(lba)*SectorSize); err == nil {
+		if rec, ok := record(buf); ok {
+			ext.size = bothEndian32(rec[10:])
+		}
+	}
+	return ext
+}
+
+// appendSymlink adds the components of an SL entry to a link target. The
+// last component may continue in the next entry, which continued reports.
+func appendSymlink(target string, data []byte, continued bool) (string, bool) {
+	for len(data) >= 2 {
+		flags, n := data[0], int(data[1])
+		if 2+n > len(data) {
+			break
+		}
+		var part string
+		switch {
+		case flags&0x02 != 0:
+			part = "."
+		case flags&0x04 != 0:
+			part = ".."
+		case flags&0x08 != 0:
+			part = "/"
+		default:
+			part = string(data[2 : 2+n])
+		}
+		if continued || target == "" || strings.HasSuffix(target, "/") {
+			target += part
+		} else {
+			target += "/" + part
+		}
+		continued = flags&0x01 != 0
+		data = data[2+n:]
+	}
+	return target, continued
+}
+
+// modifyTime returns the modification time from a TF entry.
+func modifyTime(data []byte) (time.Time, bool) {
+	if len(data) < 1 {
+		return time.Time{}, false
+	}
+	flags := data[0]
+	width := 7
+	if flags&0x80 != 0 {
+		width = 17
+	}
+	pos := 1
+	if flags&0x01 != 0 {
+		pos += width // creation time comes first
+	}
+	if flags&0x02 == 0 || pos+width > len(data) {
+		return time.Time{}, false
+	}
+	b := data[pos : pos+width]
+	if width == 7 {
+		return recordingTime(b), true
+	}
+	return volumeTime(b), true
+}
+
+// volumeTime decodes the 17 byte ASCII date format.
+func volumeTime(b []byte) time.Time {
+	t, err := time.Parse("20060102150405", string(b[:14]))
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Add(-time.Duration(int8(b[16])) * 15 * time.Minute)
+}
+
+// unixMode converts a POSIX st_mode to an fs.FileMode.
+func unixMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0777)
+	switch m & 0170000 {
+	case 0040000:
+		mode |= fs.ModeDir
+	case 0120000:
+		mode |= fs.ModeSymlink
+	case 0020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0060000:
+		mode |= fs.ModeDevice
+	case 0010000:
+		mode |= fs.ModeNamedPipe
+	case 0140000:
+		mode |= fs.ModeSocket
+	}
+	if m&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// stripVersion removes the ";1" file version suffix.
+func stripVersion(name string) string {
+	if i := strings.LastIndexByte(name, ';'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// decodeUCS2 decodes big-endian UCS-2 text as used by Joliet.
+func decodeUCS2(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
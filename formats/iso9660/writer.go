@@ -0,0 +1,794 @@
+package iso9660
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Node is a file, directory or special file to place in an image.
+type Node struct {
+	Name      string
+	Mode      fs.FileMode
+	ModTime   time.Time
+	Uid       int
+	Gid       int
+	Size      int64
+	Linkname  string
+	RDevMajor uint32
+	RDevMinor uint32
+
+	// Open returns the content of a regular file.
+	Open     func() (io.ReadCloser, error)
+	Children []*Node
+}
+
+// WriterOptions configures an image.
+type WriterOptions struct {
+	VolumeID string // volume label, at most 32 characters
+}
+
+// DefaultVolumeID labels images when no volume ID is given.
+const DefaultVolumeID = "CDROM"
+
+// maxExtent is the largest extent a directory record can describe; larger
+// files are split over several records.
+const maxExtent = 0xFFFFF800
+
+// ceSize is the length of a SUSP continuation entry.
+const ceSize = 28
+
+const (
+	rripID     = "RRIP_1991A"
+	rripDesc   = "THE ROCK RIDGE INTERCHANGE PROTOCOL PROVIDES SUPPORT FOR POSIX FILE SYSTEM SEMANTICS"
+	rripSource = "PLEASE CONTACT DISC PUBLISHER FOR SPECIFICATION SOURCE.  SEE PUBLISHER IDENTIFIER IN PRIMARY VOLUME DESCRIPTOR FOR CONTACT INFORMATION."
+)
+
+// wnode is a Node with its names and placement in the image.
+type wnode struct {
+	*Node
+	parent   *wnode
+	children []*wnode // primary tree order
+	jolChild []*wnode // Joliet tree order
+
+	isoName []byte
+	jolName []byte
+
+	dirNum, jolNum   int
+	dirLBA, jolLBA   uint32
+	dirSize, jolSize uint32
+	contSize         uint32 // continuation areas stored after the directory
+	extents          []extent
+}
+
+// layout tracks sector allocation while an image is planned.
+type layout struct {
+	next uint32 // next free sector
+}
+
+func (l *layout) alloc(size int64) uint32 {
+	lba := l.next
+	l.next += uint32((size + SectorSize - 1) / SectorSize)
+	return lba
+}
+
+// continuations collects the Rock Ridge entries of a directory that do not
+// fit in its records. They are stored in the sectors that follow the
+// directory, where sequential readers such as libarchive look for them.
+type continuations struct {
+	base uint32
+	data []byte
+}
+
+// reserve allocates size bytes, which may not cross a sector boundary, and
+// returns their position in data.
+func (c *continuations) reserve(size int) int {
+	if used := len(c.data) % SectorSize; used+size > SectorSize {
+		c.data = append(c.data, make([]byte, SectorSize-used)...)
+	}
+	pos := len(c.data)
+	c.data = append(c.data, make([]byte, size)...)
+	return pos
+}
+
+// Write writes an ISO 9660 image of the tree under root, with a Joliet tree
+// for Windows and Rock Ridge entries for POSIX names, permissions, symlinks
+// and device nodes.
+func Write(w io.Writer, root *Node, opts WriterOptions) error {
+	if opts.VolumeID == "" {
+		opts.VolumeID = DefaultVolumeID
+	}
+	if len(opts.VolumeID) > 32 {
+		return fmt.Errorf("volume label %q is longer than 32 characters", opts.VolumeID)
+	}
+
+	top := &wnode{Node: root}
+	top.parent = top
+	if err := build(top); err != nil {
+		return err
+	}
+	dirs := breadthFirst(top, func(n *wnode) []*wnode { return n.children })
+	jolDirs := breadthFirst(top, func(n *wnode) []*wnode { return n.jolChild })
+	for i, d := range dirs {
+		d.dirNum = i + 1
+	}
+	for i, d := range jolDirs {
+		d.jolNum = i + 1
+	}
+
+	// Directory sizes, including their continuation areas, do not depend
+	// on where anything is placed, so plan them first
+	for _, d := range dirs {
+		recs, cont := dirBytes(d, false)
+		d.dirSize, d.contSize = uint32(len(recs)), uint32(len(cont))
+	}
+	for _, d := range jolDirs {
+		recs, _ := dirBytes(d, true)
+		d.jolSize = uint32(len(recs))
+	}
+	pathTable := pathTableSize(dirs, false)
+	jolPathTable := pathTableSize(jolDirs, true)
+
+	l := layout{next: 19} // system area, primary, Joliet and terminator descriptors
+	lPath, mPath := l.alloc(pathTable), l.alloc(pathTable)
+	jlPath, jmPath := l.alloc(jolPathTable), l.alloc(jolPathTable)
+	for _, d := range dirs {
+		d.dirLBA = l.alloc(int64(d.dirSize + d.contSize))
+	}
+	for _, d := range jolDirs {
+		d.jolLBA = l.alloc(int64(d.jolSize))
+	}
+	var files []*wnode
+	walkFiles(top, func(n *wnode) {
+		files = append(files, n)
+		n.extents = splitExtents(l.alloc(n.Size), n.Size)
+	})
+	total := l.next
+
+	now := time.Now()
+	bw := bufio.NewWriterSize(w, 1<<16)
+	out := &sectorWriter{w: bw}
+
+	if err := out.write(make([]byte, 16*SectorSize)); err != nil {
+		return err
+	}
+	pvd := volumeDescriptor(descPrimary, opts.VolumeID, false, total, pathTable, lPath, mPath, rootRecord(top, false), now)
+	svd := volumeDescriptor(descSupplementary, opts.VolumeID, true, total, jolPathTable, jlPath, jmPath, rootRecord(top, true), now)
+	term := make([]byte, SectorSize)
+	term[0] = descTerminator
+	copy(term[1:], standardID)
+	term[6] = 1
+	for _, d := range [][]byte{pvd, svd, term} {
+		if err := out.write(d); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range []struct {
+		dirs   []*wnode
+		joliet bool
+	}{{dirs, false}, {jolDirs, true}} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			if err := out.writeSectors(pathTableBytes(t.dirs, t.joliet, order)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, d := range dirs {
+		recs, cont := dirBytes(d, false)
+		if err := out.write(append(recs, cont...)); err != nil {
+			return err
+		}
+	}
+	for _, d := range jolDirs {
+		recs, _ := dirBytes(d, true)
+		if err := out.write(recs); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range files {
+		if err := writeFileData(out, f); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// sectorWriter writes whole sectors.
+type sectorWriter struct {
+	w *bufio.Writer
+}
+
+func (s *sectorWriter) write(b []byte) error {
+	_, err := s.w.Write(b)
+	return err
+}
+
+// writeSectors writes b padded to a sector boundary.
+func (s *sectorWriter) writeSectors(b []byte) error {
+	return s.write(padSector(b))
+}
+
+func writeFileData(out *sectorWriter, f *wnode) error {
+	if f.Size == 0 {
+		return nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := r.Close(); closeErr != nil {
+			fmt.Printf("Error closing %s: %v\n", f.Name, closeErr)
+		}
+	}()
+	if _, err := io.CopyN(out.w, r, f.Size); err != nil {
+		return fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+	if pad := (SectorSize - f.Size%SectorSize) % SectorSize; pad > 0 {
+		return out.write(make([]byte, pad))
+	}
+	return nil
+}
+
+// build assigns names to the children of a directory and sorts them for
+// both trees.
+func build(dir *wnode) error {
+	isoSeen := make(map[string]bool)
+	jolSeen := make(map[string]bool)
+	for _, c := range dir.Node.Children {
+		if c.Mode&(fs.ModeDir|fs.ModeSymlink|fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket) == 0 && c.Open == nil {
+			return fmt.Errorf("%s: regular file without content", c.Name)
+		}
+		n := &wnode{Node: c, parent: dir}
+		n.isoName = uniqueName(isoName(c.Name, c.Mode.IsDir()), isoSeen, !c.Mode.IsDir())
+		if c.Mode.IsRegular() || c.Mode.IsDir() {
+			n.jolName = encodeUCS2(uniqueJoliet(c.Name, jolSeen))
+			dir.jolChild = append(dir.jolChild, n)
+		}
+		dir.children = append(dir.children, n)
+		if c.Mode.IsRegular() {
+			// Placeholder extents so directory sizes can be planned
+			n.extents = splitExtents(0, c.Size)
+		}
+		if c.Mode.IsDir() {
+			if err := build(n); err != nil {
+				return err
+			}
+		}
+	}
+	sort.Slice(dir.children, func(i, j int) bool { return bytes.Compare(dir.children[i].isoName, dir.children[j].isoName) < 0 })
+	sort.Slice(dir.jolChild, func(i, j int) bool { return bytes.Compare(dir.jolChild[i].jolName, dir.jolChild[j].jolName) < 0 })
+	return nil
+}
+
+// splitExtents divides a file stored contiguously from lba into the
+// extents its directory records describe.
+func splitExtents(lba uint32, size int64) []extent {
+	var extents []extent
+	for {
+		n := uint32(min(size, maxExtent))
+		extents = append(extents, extent{lba: lba, size: n})
+		lba += n / SectorSize
+		size -= int64(n)
+		if size == 0 {
+			return extents
+		}
+	}
+}
+
+func breadthFirst(root *wnode, children func(*wnode) []*wnode) []*wnode {
+	dirs := []*wnode{root}
+	for i := 0; i < len(dirs); i++ {
+		for _, c := range children(dirs[i]) {
+			if c.Mode.IsDir() {
+				dirs = append(dirs, c)
+			}
+		}
+	}
+	return dirs
+}
+
+func walkFiles(dir *wnode, fn func(*wnode)) {
+	for _, c := range dir.children {
+		if c.Mode.IsDir() {
+			walkFiles(c, fn)
+		} else if c.Mode.IsRegular() {
+			fn(c)
+		}
+	}
+}
+
+// isoName maps a name to ISO 9660 level 2 d-characters.
+func isoName(name string, dir bool) string {
+	clean := func(s string) string {
+		var b strings.Builder
+		for _, r := range strings.ToUpper(s) {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+		return b.String()
+	}
+	if dir {
+		return truncate(clean(name), 31)
+	}
+	base, ext := name, ""
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	ext = truncate(clean(ext), 10)
+	return truncate(clean(base), 29-len(ext)) + "." + ext
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// uniqueName makes an ISO name unique within its directory by replacing the
+// end of its base with a counter.
+func uniqueName(name string, seen map[string]bool, file bool) []byte {
+	candidate := name
+	for i := 1; seen[candidate]; i++ {
+		suffix := fmt.Sprintf("_%d", i)
+		base, ext := name, ""
+		if j := strings.LastIndexByte(name, '.'); file && j >= 0 {
+			base, ext = name[:j], name[j:]
+		}
+		candidate = truncate(base, max(0, len(base)-len(suffix))) + suffix + ext
+		if len(candidate) > 31 {
+			candidate = candidate[len(candidate)-31:]
+		}
+	}
+	seen[candidate] = true
+	if file {
+		candidate += ";1"
+	}
+	return []byte(candidate)
+}
+
+// uniqueJoliet limits a name to the 64 UCS-2 characters Joliet allows and
+// makes it unique within its directory.
+func uniqueJoliet(name string, seen map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune("*/:;?\\", r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+	limit := func(s string) string {
+		for len(utf16.Encode([]rune(s))) > 64 {
+			r := []rune(s)
+			s = string(r[:len(r)-1])
+		}
+		return s
+	}
+	candidate := limit(name)
+	for i := 1; seen[candidate]; i++ {
+		suffix := fmt.Sprintf("_%d", i)
+		r := []rune(limit(name))
+		candidate = limit(string(r[:max(0, len(r)-len(suffix))])) + suffix
+	}
+	seen[candidate] = true
+	return candidate
+}
+
+func encodeUCS2(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, v := range u {
+		binary.BigEndian.PutUint16(b[2*i:], v)
+	}
+	return b
+}
+
+// dirRecord is a directory record before serialization.
+type dirRecord struct {
+	name  []byte
+	ext   extent
+	flags byte
+	mtime time.Time
+	susp  [][]byte
+}
+
+// records lists the directory records of a directory in order.
+func records(d *wnode, joliet bool) []dirRecord {
+	self := dirRecord{name: []byte{0}, ext: extent{d.dirLBA, d.dirSize}, flags: flagDir, mtime: d.ModTime}
+	parent := dirRecord{name: []byte{1}, ext: extent{d.parent.dirLBA, d.parent.dirSize}, flags: flagDir, mtime: d.parent.ModTime}
+	children := d.children
+	if joliet {
+		self.ext = extent{d.jolLBA, d.jolSize}
+		parent.ext = extent{d.parent.jolLBA, d.parent.jolSize}
+		children = d.jolChild
+	} else {
+		self.susp = rockRidge(d, nil, d == d.parent)
+	}
+	recs := []dirRecord{self, parent}
+
+	for _, c := range children {
+		name := c.isoName
+		if joliet {
+			name = c.jolName
+		}
+		var su [][]byte
+		if !joliet {
+			su = rockRidge(c, []byte(c.Name), false)
+		}
+		switch {
+		case c.Mode.IsDir():
+			ext := extent{c.dirLBA, c.dirSize}
+			if joliet {
+				ext = extent{c.jolLBA, c.jolSize}
+			}
+			recs = append(recs, dirRecord{name: name, ext: ext, flags: flagDir, mtime: c.ModTime, susp: su})
+		case c.Mode.IsRegular():
+			for i, e := range c.extents {
+				var flags byte
+				if i < len(c.extents)-1 {
+					flags = flagMultiExtent
+				}
+				recs = append(recs, dirRecord{name: name, ext: e, flags: flags, mtime: c.ModTime, susp: su})
+			}
+		default:
+			recs = append(recs, dirRecord{name: name, mtime: c.ModTime, susp: su})
+		}
+	}
+	return recs
+}
+
+// encodeRecord serializes a directory record, moving Rock Ridge entries that
+// do not fit into continuation areas.
+func encodeRecord(r dirRecord, ce *continuations) []byte {
+	base := 33 + len(r.name)
+	if base%2 == 1 {
+		base++
+	}
+	su := packSUSP(r.susp, 255-base, ce)
+	rec := make([]byte, base+len(su))
+	if len(rec)%2 == 1 {
+		rec = append(rec, 0)
+	}
+	rec[0] = byte(len(rec))
+	putBothEndian32(rec[2:], r.ext.lba)
+	putBothEndian32(rec[10:], r.ext.size)
+	putRecordingTime(rec[18:], r.mtime)
+	rec[25] = r.flags
+	putBothEndian16(rec[28:], 1)
+	rec[32] = byte(len(r.name))
+	copy(rec[33:], r.name)
+	copy(rec[base:], su)
+	return rec
+}
+
+// packSUSP places as many entries as fit in avail bytes, and the rest in a
+// continuation area.
+func packSUSP(entries [][]byte, avail int, ce *continuations) []byte {
+	total := 0
+	for _, e := range entries {
+		total += len(e)
+	}
+	if total <= avail {
+		return bytes.Join(entries, nil)
+	}
+	var inline []byte
+	i := 0
+	for ; i < len(entries) && len(inline)+len(entries[i]) <= avail-ceSize; i++ {
+		inline = append(inline, entries[i]...)
+	}
+	// Reserve this area before packing it, so that chained areas follow it
+	// as sequential readers require
+	size := len(packSUSP(entries[i:], SectorSize, &continuations{}))
+	pos := ce.reserve(size)
+	area := packSUSP(entries[i:], SectorSize, ce)
+	copy(ce.data[pos:], area)
+	entry := make([]byte, ceSize)
+	copy(entry, "CE")
+	entry[2], entry[3] = ceSize, 1
+	putBothEndian32(entry[4:], ce.base+uint32(pos/SectorSize))
+	putBothEndian32(entry[12:], uint32(pos%SectorSize))
+	putBothEndian32(entry[20:], uint32(size))
+	return append(inline, entry...)
+}
+
+// dirBytes serializes the records of a directory, which never cross a
+// sector boundary, and the continuation areas stored after them.
+func dirBytes(d *wnode, joliet bool) ([]byte, []byte) {
+	var out []byte
+	var recs [][]byte
+	size := 0
+	for _, r := range records(d, joliet) {
+		// Records are measured first: the continuation areas follow the
+		// directory, so their location depends on its length
+		rec := encodeRecord(r, &continuations{})
+		if used := size % SectorSize; used+len(rec) > SectorSize {
+			size += SectorSize - used
+		}
+		size += len(rec)
+		recs = append(recs, rec)
+	}
+	sectors := uint32((size + SectorSize - 1) / SectorSize)
+
+	lba := d.dirLBA
+	if joliet {
+		lba = d.jolLBA
+	}
+	ce := &continuations{base: lba + sectors}
+	for _, r := range records(d, joliet) {
+		rec := encodeRecord(r, ce)
+		if used := len(out) % SectorSize; used+len(rec) > SectorSize {
+			out = append(out, make([]byte, SectorSize-used)...)
+		}
+		out = append(out, rec...)
+	}
+	return padSector(out), padSector(ce.data)
+}
+
+func padSector(b []byte) []byte {
+	if pad := (SectorSize - len(b)%SectorSize) % SectorSize; pad > 0 {
+		b = append(b, make([]byte, pad)...)
+	}
+	return b
+}
+
+// rockRidge returns the Rock Ridge entries for a record. The root's "."
+// record also announces the extension.
+func rockRidge(n *wnode, name []byte, root bool) [][]byte {
+	var entries [][]byte
+	entry := func(sig string, data ...[]byte) []byte {
+		body := bytes.Join(data, nil)
+		return append([]byte{sig[0], sig[1], byte(4 + len(body)), 1}, body...)
+	}
+	both32 := func(v uint32) []byte {
+		b := make([]byte, 8)
+		putBothEndian32(b, v)
+		return b
+	}
+	if root {
+		entries = append(entries, []byte{'S', 'P', 7, 1, 0xBE, 0xEF, 0})
+	}
+
+	flags := byte(0x01 | 0x80) // PX and TF
+	if len(name) > 0 {
+		flags |= 0x08
+	}
+	if n.Mode&fs.ModeSymlink != 0 {
+		flags |= 0x04
+	}
+	if n.Mode&fs.ModeDevice != 0 {
+		flags |= 0x02
+	}
+	entries = append(entries, entry("RR", []byte{flags}))
+
+	nlink := uint32(1)
+	if n.Mode.IsDir() {
+		nlink = 2
+		for _, c := range n.children {
+			if c.Mode.IsDir() {
+				nlink++
+			}
+		}
+	}
+	entries = append(entries, entry("PX", both32(posixMode(n.Mode)), both32(nlink), both32(uint32(n.Uid)), both32(uint32(n.Gid))))
+	var tf [7]byte
+	putRecordingTime(tf[:], n.ModTime)
+	entries = append(entries, entry("TF", []byte{0x06}, tf[:], tf[:]))
+
+	for len(name) > 0 {
+		chunk := name[:min(len(name), 150)]
+		name = name[len(chunk):]
+		var nmFlags byte
+		if len(name) > 0 {
+			nmFlags = 0x01
+		}
+		entries = append(entries, entry("NM", []byte{nmFlags}, chunk))
+	}
+	if n.Mode&fs.ModeSymlink != 0 {
+		entries = append(entries, symlinkEntries(n.Linkname)...)
+	}
+	if n.Mode&fs.ModeDevice != 0 {
+		// Small device numbers are packed into the low word, which Linux and
+		// libarchive both read; others are stored as major and minor
+		high, low := n.RDevMajor, n.RDevMinor
+		if high < 0x100 && low < 0x100 {
+			high, low = 0, n.RDevMajor<<8|n.RDevMinor
+		}
+		entries = append(entries, entry("PN", both32(high), both32(low)))
+	}
+	if root {
+		entries = append(entries, append([]byte{'E', 'R', byte(8 + len(rripID) + len(rripDesc) + len(rripSource)), 1,
+			byte(len(rripID)), byte(len(rripDesc)), byte(len(rripSource)), 1}, rripID+rripDesc+rripSource...))
+	}
+	return entries
+}
+
+// symlinkEntries encodes a link target as SL entries of path components.
+// Readers disagree on whether a separator falls between two SL entries, so
+// entries are only ever split inside a component, which they all join the
+// same way.
+func symlinkEntries(target string) [][]byte {
+	const maxBody = 145
+
+	type component struct {
+		flags byte
+		name  string
+	}
+	var comps []component
+	if strings.HasPrefix(target, "/") {
+		comps = append(comps, component{flags: 0x08})
+	}
+	for _, part := range strings.Split(strings.Trim(target, "/"), "/") {
+		switch part {
+		case "":
+		case ".":
+			comps = append(comps, component{flags: 0x02})
+		case "..":
+			comps = append(comps, component{flags: 0x04})
+		default:
+			comps = append(comps, component{name: part})
+		}
+	}
+
+	var entries [][]byte
+	var cur []byte
+	flush := func(more bool) {
+		var f byte
+		if more {
+			f = 0x01
+		}
+		entries = append(entries, append([]byte{'S', 'L', byte(5 + len(cur)), 1, f}, cur...))
+		cur = nil
+	}
+	for _, c := range comps {
+		for {
+			room := maxBody - len(cur)
+			if 2+len(c.name) <= room {
+				cur = append(append(cur, c.flags, byte(len(c.name))), c.name...)
+				break
+			}
+			if c.name != "" && room > 2 {
+				part := c.name[:room-2]
+				cur = append(append(cur, c.flags|0x01, byte(len(part))), part...)
+				c.name = c.name[len(part):]
+			}
+			flush(true)
+		}
+	}
+	flush(false)
+	return entries
+}
+
+// posixMode converts an fs.FileMode to st_mode bits.
+func posixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m.IsDir():
+		mode |= 0040000
+	case m&fs.ModeSymlink != 0:
+		mode |= 0120000
+	case m&fs.ModeCharDevice != 0:
+		mode |= 0020000
+	case m&fs.ModeDevice != 0:
+		mode |= 0060000
+	case m&fs.ModeNamedPipe != 0:
+		mode |= 0010000
+	case m&fs.ModeSocket != 0:
+		mode |= 0140000
+	default:
+		mode |= 0100000
+	}
+	if m&fs.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// rootRecord returns the 34 byte root directory record of a descriptor.
+func rootRecord(root *wnode, joliet bool) []byte {
+	rec := make([]byte, 34)
+	rec[0] = 34
+	ext := extent{root.dirLBA, root.dirSize}
+	if joliet {
+		ext = extent{root.jolLBA, root.jolSize}
+	}
+	putBothEndian32(rec[2:], ext.lba)
+	putBothEndian32(rec[10:], ext.size)
+	putRecordingTime(rec[18:], root.ModTime)
+	rec[25] = flagDir
+	putBothEndian16(rec[28:], 1)
+	rec[32] = 1
+	return rec
+}
+
+func pathTableSize(dirs []*wnode, joliet bool) int64 {
+	return int64(len(pathTableBytes(dirs, joliet, binary.LittleEndian)))
+}
+
+// pathTableBytes serializes a path table in the given byte order.
+func pathTableBytes(dirs []*wnode, joliet bool, order binary.ByteOrder) []byte {
+	var out []byte
+	for i, d := range dirs {
+		name, lba, parent := d.isoName, d.dirLBA, d.parent.dirNum
+		if joliet {
+			name, lba, parent = d.jolName, d.jolLBA, d.parent.jolNum
+		}
+		if i == 0 {
+			name = []byte{0}
+		}
+		rec := make([]byte, 8+len(name)+len(name)%2)
+		rec[0] = byte(len(name))
+		order.PutUint32(rec[2:], lba)
+		order.PutUint16(rec[6:], uint16(parent))
+		copy(rec[8:], name)
+		out = append(out, rec...)
+	}
+	return out
+}
+
+// volumeDescriptor builds a primary or Joliet supplementary descriptor.
+func volumeDescriptor(kind byte, volumeID string, joliet bool, total uint32, pathTable int64, lPath, mPath uint32, root []byte, now time.Time) []byte {
+	d := make([]byte, SectorSize)
+	d[0] = kind
+	copy(d[1:], standardID)
+	d[6] = 1
+	text := func(off, n int, s string) {
+		if joliet {
+			b := encodeUCS2(s)
+			for i := 0; i < n; i += 2 {
+				d[off+i], d[off+i+1] = 0, ' '
+			}
+			copy(d[off:off+n], b[:min(len(b), n&^1)])
+			return
+		}
+		for i := 0; i < n; i++ {
+			d[off+i] = ' '
+		}
+		copy(d[off:off+n], s)
+	}
+	text(8, 32, "")
+	text(40, 32, volumeID)
+	putBothEndian32(d[80:], total)
+	if joliet {
+		copy(d[88:], "%/E")
+	}
+	putBothEndian16(d[120:], 1)
+	putBothEndian16(d[124:], 1)
+	putBothEndian16(d[128:], SectorSize)
+	putBothEndian32(d[132:], uint32(pathTable))
+	binary.LittleEndian.PutUint32(d[140:], lPath)
+	binary.BigEndian.PutUint32(d[148:], mPath)
+	copy(d[156:], root)
+	text(190, 128, "")
+	text(318, 128, "")
+	text(446, 128, "")
+	text(574, 128, "FUTILE")
+	text(702, 37, "")
+	text(739, 37, "")
+	text(776, 37, "")
+	stamp := []byte(now.UTC().Format("20060102150405") + "00\x00")
+	copy(d[813:], stamp)
+	copy(d[830:], stamp)
+	copy(d[847:], "0000000000000000\x00")
+	copy(d[864:], "0000000000000000\x00")
+	d[881] = 1
+	return d
+}
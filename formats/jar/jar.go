@@ -0,0 +1,125 @@
+package jar
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// maxManifestSize bounds the manifests read from archives.
+const maxManifestSize = 8 << 20
+
+// ErrNoManifest is returned for archives without a manifest.
+var ErrNoManifest = errors.New("jar: archive has no manifest")
+
+// Kinds of Java archive, named after their extensions.
+const (
+	KindJAR = "jar" // libraries and applications
+	KindWAR = "war" // web applications
+	KindEAR = "ear" // enterprise applications bundling modules
+)
+
+// KindOf returns the kind of Java archive a file name has, from its extension.
+func KindOf(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".war":
+		return KindWAR
+	case ".ear":
+		return KindEAR
+	}
+	return KindJAR
+}
+
+// ReadManifest returns the manifest of an archive.
+func ReadManifest(zr *zip.Reader) (*Manifest, error) {
+	for _, f := range zr.File {
+		if !strings.EqualFold(f.Name, ManifestName) {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open manifest: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(r, maxManifestSize))
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
+		}
+		return ParseManifest(data)
+	}
+	return nil, ErrNoManifest
+}
+
+// Check verifies that an archive is a valid Java archive of the given kind:
+// that the manifest comes first, where streaming readers look for it, and
+// is well formed, that its main class is present, and that entry names are
+// unique relative paths. Missing deployment descriptors, which recent Java
+// versions do without, are returned as warnings instead.
+func Check(zr *zip.Reader, kind string) (warnings []string, err error) {
+	files := zr.File
+	if len(files) > 0 && files[0].Name == "META-INF/" {
+		files = files[1:]
+	}
+	if len(files) == 0 || files[0].Name != ManifestName {
+		return nil, fmt.Errorf("jar: %s is not the first entry", ManifestName)
+	}
+	manifest, err := ReadManifest(zr)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(zr.File))
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "/") || strings.Contains(f.Name, "\\") ||
+			strings.HasPrefix(f.Name, "../") || strings.Contains(f.Name, "/../") {
+			return nil, fmt.Errorf("jar: entry %s is not a relative path", f.Name)
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("jar: duplicate entry %s", f.Name)
+		}
+		names[f.Name] = true
+	}
+	if class := manifest.MainClassEntry(); class != "" && !names[class] {
+		return nil, fmt.Errorf("jar: Main-Class %s has no entry %s", manifest.Main.Get("Main-Class"), class)
+	}
+
+	switch kind {
+	case KindWAR:
+		if !hasPrefix(names, "WEB-INF/") {
+			warnings = append(warnings, "web application has no WEB-INF/ directory")
+		}
+	case KindEAR:
+		if !names["META-INF/application.xml"] && !hasSuffix(names, ".jar", ".war") {
+			warnings = append(warnings, "enterprise application has neither META-INF/application.xml nor modules")
+		}
+	}
+	return warnings, nil
+}
+
+func hasPrefix(names map[string]bool, prefix string) bool {
+	for name := range names {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSuffix(names map[string]bool, suffixes ...string) bool {
+	for name := range names {
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(strings.ToLower(name), suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
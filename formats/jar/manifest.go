@@ -0,0 +1,175 @@
+// Package jar reads and writes the manifests of Java archives (JAR, WAR and
+// EAR files), which are ZIP archives with a META-INF/MANIFEST.MF entry.
+package jar
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ManifestName is the name of the manifest entry, which follows the
+// META-INF/ directory entry at the start of the archive.
+const ManifestName = "META-INF/MANIFEST.MF"
+
+// maxLineSize is the longest line of a manifest in bytes, without its
+// line break.
+const maxLineSize = 72
+
+// Attribute is one "Name: value" line of a manifest section.
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// Section is a group of attributes, in order.
+type Section []Attribute
+
+// Manifest holds the main attributes, which describe the archive, and the
+// sections that describe single entries, each starting with a Name attribute.
+type Manifest struct {
+	Main    Section
+	Entries []Section
+}
+
+var attributeName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,69}$`)
+
+// ParseManifest parses a manifest. Lines end with CR LF, LF or CR, and long
+// values continue on lines starting with a space.
+func ParseManifest(data []byte) (*Manifest, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	if !utf8.ValidString(text) {
+		return nil, fmt.Errorf("manifest is not valid UTF-8")
+	}
+
+	m := &Manifest{}
+	var section Section
+	main := true
+	endSection := func() {
+		if main {
+			m.Main, main = section, false
+		} else if len(section) > 0 {
+			m.Entries = append(m.Entries, section)
+		}
+		section = nil
+	}
+	for i, line := range strings.Split(text, "\n") {
+		switch {
+		case line == "":
+			if main || len(section) > 0 {
+				endSection()
+			}
+		case line[0] == ' ':
+			if len(section) == 0 {
+				return nil, fmt.Errorf("manifest line %d: continuation line before any attribute", i+1)
+			}
+			section[len(section)-1].Value += line[1:]
+		default:
+			name, value, ok := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			if !ok || !attributeName.MatchString(name) {
+				return nil, fmt.Errorf("manifest line %d: expected \"Name: value\"", i+1)
+			}
+			if section.Get(name) != "" {
+				return nil, fmt.Errorf("manifest line %d: duplicate attribute %s", i+1, name)
+			}
+			if !main && len(section) == 0 && !strings.EqualFold(name, "Name") {
+				return nil, fmt.Errorf("manifest line %d: entry section starts with %s instead of Name", i+1, name)
+			}
+			section = append(section, Attribute{Name: name, Value: value})
+		}
+	}
+	if main || len(section) > 0 {
+		endSection()
+	}
+	return m, nil
+}
+
+// Get returns the value of an attribute, matching its name case-insensitively.
+func (s Section) Get(name string) string {
+	for _, a := range s {
+		if strings.EqualFold(a.Name, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of an attribute or appends it.
+func (s *Section) Set(name, value string) {
+	for i, a := range *s {
+		if strings.EqualFold(a.Name, name) {
+			(*s)[i].Value = value
+			return
+		}
+	}
+	*s = append(*s, Attribute{Name: name, Value: value})
+}
+
+// Validate checks the attribute names and the attributes the Java runtime
+// interprets.
+func (m *Manifest) Validate() error {
+	for _, s := range append([]Section{m.Main}, m.Entries...) {
+		for _, a := range s {
+			if !attributeName.MatchString(a.Name) {
+				return fmt.Errorf("invalid manifest attribute name %q", a.Name)
+			}
+			if strings.ContainsAny(a.Value, "\r\n\x00") {
+				return fmt.Errorf("manifest attribute %s has a line break", a.Name)
+			}
+		}
+	}
+	if m.Main.Get("Manifest-Version") == "" {
+		return fmt.Errorf("manifest lacks the required Manifest-Version attribute")
+	}
+	if class := m.Main.Get("Main-Class"); class != "" && strings.ContainsAny(class, "/ ") {
+		return fmt.Errorf("Main-Class %q must be a class name such as com.example.Main", class)
+	}
+	for _, path := range strings.Fields(m.Main.Get("Class-Path")) {
+		if strings.HasPrefix(path, "/") || strings.Contains(path, "\\") {
+			return fmt.Errorf("Class-Path entry %q must be a relative URL", path)
+		}
+	}
+	return nil
+}
+
+// MainClassEntry returns the archive entry of the Main-Class attribute, or
+// "" when there is none.
+func (m *Manifest) MainClassEntry() string {
+	class := m.Main.Get("Main-Class")
+	if class == "" {
+		return ""
+	}
+	return strings.ReplaceAll(class, ".", "/") + ".class"
+}
+
+// Bytes formats the manifest with CR LF line breaks, as the jar tool does,
+// wrapping lines longer than 72 bytes.
+func (m *Manifest) Bytes() []byte {
+	var b strings.Builder
+	for _, s := range append([]Section{m.Main}, m.Entries...) {
+		for _, a := range s {
+			writeLine(&b, a.Name+": "+a.Value)
+		}
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String())
+}
+
+// writeLine writes a line, continuing it on further lines that start with
+// a space, without splitting UTF-8 sequences.
+func writeLine(b *strings.Builder, line string) {
+	limit := maxLineSize
+	for len(line) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		b.WriteString(line[:n] + "\r\n ")
+		line = line[n:]
+		limit = maxLineSize - 1
+	}
+	b.WriteString(line + "\r\n")
+}
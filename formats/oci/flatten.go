@@ -0,0 +1,322 @@
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"futile/compress/zstd"
+	"hash"
+	"io"
+	"path"
+	"strings"
+)
+
+// Whiteouts are the entries of a layer that delete from the layers below.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq" // empties its directory
+	whiteoutMeta   = ".wh..wh."     // other files of the AUFS driver, ignored
+)
+
+// position identifies an entry by layer and order within the layer.
+type position struct {
+	layer, index int
+}
+
+// node is a file of the merged file system.
+type node struct {
+	hdr      *tar.Header
+	pos      position // entry written for the file; index -1 for implicit directories
+	implicit bool     // a directory only named as the parent of entries
+	children map[string]*node
+	target   *node // file a hard link shares its data with
+}
+
+// emission is an entry of the merged file system, written in place of the
+// layer entry at its position.
+type emission struct {
+	hdr  *tar.Header
+	data bool // copy the data of the layer entry
+}
+
+// Flatten writes the merged file system of an image to tw: the entries of
+// every layer in order, less those that later layers replace or delete with
+// whiteouts. Directories keep the position of their first entry with the
+// metadata of their last. Warnings describe entries that were left out.
+func (a *Archive) Flatten(img Image, tw *tar.Writer) (warnings []string, err error) {
+	root := &node{hdr: &tar.Header{Typeflag: tar.TypeDir}, children: make(map[string]*node)}
+
+	// Work out which entries survive, checking each layer's digest on the way
+	for i, layer := range img.Layers {
+		index := 0
+		err := a.eachEntry(layer, true, func(hdr *tar.Header, _ io.Reader) error {
+			pos := position{i, index}
+			index++
+			if w := apply(root, hdr, pos); w != "" {
+				warnings = append(warnings, w)
+			}
+			return nil
+		})
+		if err != nil {
+			return warnings, err
+		}
+	}
+
+	emit := plan(root)
+
+	for i, layer := range img.Layers {
+		index := 0
+		err := a.eachEntry(layer, false, func(_ *tar.Header, r io.Reader) error {
+			e, ok := emit[position{i, index}]
+			index++
+			if !ok {
+				return nil
+			}
+			if err := tw.WriteHeader(e.hdr); err != nil {
+				return fmt.Errorf("oci: failed to write %s: %w", e.hdr.Name, err)
+			}
+			if e.data {
+				if _, err := io.Copy(tw, r); err != nil {
+					return fmt.Errorf("oci: failed to write %s: %w", e.hdr.Name, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+// apply adds an entry of a layer to the merged tree, returning a warning
+// when it cannot be applied.
+func apply(root *node, hdr *tar.Header, pos position) string {
+	if hdr.Typeflag == tar.TypeXGlobalHeader {
+		return ""
+	}
+	name := cleanName(hdr.Name)
+	if name == "" {
+		return ""
+	}
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+
+	switch {
+	case base == whiteoutOpaque:
+		// Only the layers below are hidden, not earlier entries of this one
+		if parent := lookup(root, dir); parent != nil && parent.children != nil {
+			for child, n := range parent.children {
+				if n.pos.layer < pos.layer {
+					delete(parent.children, child)
+				}
+			}
+		}
+		return ""
+	case strings.HasPrefix(base, whiteoutMeta):
+		return ""
+	case strings.HasPrefix(base, whiteoutPrefix):
+		if parent := lookup(root, dir); parent != nil && parent.children != nil {
+			delete(parent.children, strings.TrimPrefix(base, whiteoutPrefix))
+		}
+		return ""
+	}
+
+	parent, err := mkdirs(root, dir, pos.layer)
+	if err != nil {
+		return fmt.Sprintf("%s is skipped: %v", name, err)
+	}
+	existing := parent.children[base]
+	if hdr.Typeflag == tar.TypeDir && existing != nil && existing.children != nil {
+		// Directories merge, taking the metadata of the upper layer
+		existing.hdr = hdr
+		if existing.implicit {
+			existing.implicit = false
+			existing.pos = pos
+		}
+		return ""
+	}
+
+	n := &node{hdr: hdr, pos: pos}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		n.children = make(map[string]*node)
+	case tar.TypeLink:
+		target := lookup(root, cleanName(hdr.Linkname))
+		if target == nil || target.children != nil {
+			return fmt.Sprintf("hard link %s is skipped: %s is not a file", name, hdr.Linkname)
+		}
+		if target.target != nil {
+			target = target.target
+		}
+		n.target = target
+	}
+	parent.children[base] = n
+	return ""
+}
+
+// lookup returns the node at a path, or nil.
+func lookup(root *node, name string) *node {
+	n := root
+	if name == "" {
+		return n
+	}
+	for _, part := range strings.Split(name, "/") {
+		if n.children == nil {
+			return nil
+		}
+		if n = n.children[part]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// mkdirs returns the directory at a path, creating implicit directories for
+// missing parts in the given layer.
+func mkdirs(root *node, name string, layer int) (*node, error) {
+	n := root
+	if name == "" {
+		return n, nil
+	}
+	for i, part := range strings.Split(name, "/") {
+		child := n.children[part]
+		if child == nil {
+			child = &node{
+				hdr:      &tar.Header{Typeflag: tar.TypeDir, Mode: 0755},
+				pos:      position{layer, -1},
+				implicit: true,
+				children: make(map[string]*node),
+			}
+			n.children[part] = child
+		} else if child.children == nil {
+			return nil, fmt.Errorf("%s is not a directory", strings.Join(strings.Split(name, "/")[:i+1], "/"))
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// plan decides what to write at the position of each surviving entry. A
+// hard link whose target was deleted takes over its data, at the target's
+// position, and the other links to it link to that one.
+func plan(root *node) map[position]emission {
+	alive := make(map[*node]string)
+	var walk func(n *node, name string)
+	walk = func(n *node, name string) {
+		alive[n] = name
+		for child, c := range n.children {
+			walk(c, path.Join(name, child))
+		}
+	}
+	walk(root, "")
+	delete(alive, root)
+
+	emit := make(map[position]emission)
+	carriers := make(map[*node]*node)
+	for n := range alive {
+		if n.target != nil {
+			if _, ok := alive[n.target]; !ok {
+				if c := carriers[n.target]; c == nil || before(n.pos, c.pos) {
+					carriers[n.target] = n
+				}
+			}
+		}
+	}
+
+	for n, name := range alive {
+		if n.implicit {
+			continue
+		}
+		hdr := *n.hdr
+		hdr.Name = name
+		// PAX keeps times to the nanosecond and extended attributes
+		hdr.Format = tar.FormatPAX
+		if n.children != nil {
+			hdr.Name += "/"
+		}
+		switch {
+		case n.target == nil:
+			emit[n.pos] = emission{hdr: &hdr, data: hdr.Typeflag == tar.TypeReg}
+		case carriers[n.target] == nil:
+			hdr.Linkname = alive[n.target]
+			emit[n.pos] = emission{hdr: &hdr}
+		case carriers[n.target] == n:
+			data := *n.target.hdr
+			data.Name = name
+			data.Format = tar.FormatPAX
+			emit[n.target.pos] = emission{hdr: &data, data: data.Typeflag == tar.TypeReg}
+		default:
+			hdr.Linkname = alive[carriers[n.target]]
+			emit[n.pos] = emission{hdr: &hdr}
+		}
+	}
+	return emit
+}
+
+func before(a, b position) bool {
+	return a.layer < b.layer || a.layer == b.layer && a.index < b.index
+}
+
+// eachEntry calls fn for every entry of a layer, decompressing it as its
+// first bytes tell. With check, the blob is checked against its digest.
+func (a *Archive) eachEntry(layer Layer, check bool, fn func(hdr *tar.Header, r io.Reader) error) error {
+	blob, err := a.open(layer.Path)
+	if err != nil {
+		return err
+	}
+	var in io.Reader = blob
+	var h hash.Hash
+	if check && strings.HasPrefix(layer.Digest, "sha256:") {
+		h = sha256.New()
+		in = io.TeeReader(blob, h)
+	}
+
+	br := bufio.NewReader(in)
+	r, err := decompress(br)
+	if err != nil {
+		return fmt.Errorf("oci: layer %s: %w", layer.Path, err)
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("oci: layer %s: %w", layer.Path, err)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+
+	if h != nil {
+		// Padding after the end of the tar archive counts too
+		if _, err := io.Copy(io.Discard, br); err != nil {
+			return fmt.Errorf("oci: layer %s: %w", layer.Path, err)
+		}
+		var sum [sha256.Size]byte
+		h.Sum(sum[:0])
+		if err := verify(layer.Digest, sum); err != nil {
+			return fmt.Errorf("oci: layer %s: %w", layer.Path, err)
+		}
+	}
+	return nil
+}
+
+// decompress returns the tar stream of a layer: gzip or Zstandard
+// compressed, or as it is.
+func decompress(br *bufio.Reader) (io.Reader, error) {
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return zstd.NewReader(br)
+	}
+	return br, nil
+}
@@ -0,0 +1,312 @@
+// Package oci reads container images saved as tarballs, in the OCI image
+// layout or the format of "docker save", and flattens their layers into a
+// single file system.
+package oci
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Media types of manifests and indexes.
+const (
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+const (
+	// maxJSONSize bounds the manifests, indexes and configs read.
+	maxJSONSize = 16 << 20
+	// maxIndexDepth bounds indexes nested in indexes.
+	maxIndexDepth = 4
+	// maxLinkDepth bounds symbolic links followed between archive members.
+	maxLinkDepth = 8
+)
+
+// ErrNotImage is returned for tarballs with neither index.json nor manifest.json.
+var ErrNotImage = errors.New("not an OCI or Docker image archive")
+
+// Descriptor points to a blob by digest.
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Image is an image manifest found in an archive: its platform and layers,
+// lowest first.
+type Image struct {
+	Platform Platform
+	Name     string // a tag or digest, to tell images apart
+	Layers   []Layer
+}
+
+// Layer is a layer blob, a tar archive that may be compressed.
+type Layer struct {
+	Path   string // member of the image archive
+	Digest string // sha256 digest of the blob, when known
+}
+
+// Archive is an image tarball, indexed by member name.
+type Archive struct {
+	r       io.ReaderAt
+	members map[string]member
+}
+
+type member struct {
+	offset, size int64
+	link         string // target of a symbolic link, relative to the archive root
+}
+
+// Open indexes the members of an uncompressed image tarball.
+func Open(r io.ReaderAt, size int64) (*Archive, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(sr)
+	a := &Archive{r: r, members: make(map[string]member)}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("oci: failed to read image archive: %w", err)
+		}
+		name := cleanName(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			// The reader stops at the start of the data
+			offset, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			a.members[name] = member{offset: offset, size: hdr.Size}
+		case tar.TypeSymlink:
+			// docker save links layers shared between images
+			a.members[name] = member{link: cleanName(path.Join(path.Dir(name), hdr.Linkname))}
+		case tar.TypeLink:
+			a.members[name] = member{link: cleanName(hdr.Linkname)}
+		}
+	}
+	if _, ok := a.members["index.json"]; ok {
+		return a, nil
+	}
+	if _, ok := a.members["manifest.json"]; ok {
+		return a, nil
+	}
+	return nil, ErrNotImage
+}
+
+// cleanName turns a member or entry name into a clean relative path, or ""
+// for the root.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// open returns a reader of a member, following links.
+func (a *Archive) open(name string) (*io.SectionReader, error) {
+	for i := 0; i < maxLinkDepth; i++ {
+		m, ok := a.members[name]
+		if !ok {
+			return nil, fmt.Errorf("oci: %s is not in the image archive", name)
+		}
+		if m.link == "" {
+			return io.NewSectionReader(a.r, m.offset, m.size), nil
+		}
+		name = m.link
+	}
+	return nil, fmt.Errorf("oci: too many links to %s", name)
+}
+
+// readJSON decodes a member, checking it against digest unless it is empty.
+func (a *Archive) readJSON(name, digest string, v any) error {
+	r, err := a.open(name)
+	if err != nil {
+		return err
+	}
+	if r.Size() > maxJSONSize {
+		return fmt.Errorf("oci: %s is too large", name)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("oci: failed to read %s: %w", name, err)
+	}
+	if digest != "" {
+		if err := verify(digest, sha256.Sum256(data)); err != nil {
+			return fmt.Errorf("oci: %s: %w", name, err)
+		}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("oci: invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+// blobPath returns the member holding a blob of the OCI layout.
+func blobPath(digest string) (string, error) {
+	algorithm, hash, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hash == "" || strings.ContainsAny(digest, "/\\") {
+		return "", fmt.Errorf("oci: invalid digest %q", digest)
+	}
+	return "blobs/" + algorithm + "/" + hash, nil
+}
+
+// verify compares a sha256 sum with a digest; digests of other algorithms
+// are not checked.
+func verify(digest string, sum [sha256.Size]byte) error {
+	hash, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok {
+		return nil
+	}
+	if hash != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("digest mismatch: expected %s", digest)
+	}
+	return nil
+}
+
+// Images returns the image manifests of the archive with their platforms.
+// Attestations and other manifests for no platform are left out.
+func (a *Archive) Images() ([]Image, error) {
+	var images []Image
+	var err error
+	if _, ok := a.members["index.json"]; ok {
+		var index struct {
+			Manifests []Descriptor `json:"manifests"`
+		}
+		if err := a.readJSON("index.json", "", &index); err != nil {
+			return nil, err
+		}
+		images, err = a.resolve(index.Manifests, 0)
+	} else {
+		images, err = a.dockerImages()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var known []Image
+	for _, img := range images {
+		if img.Platform.OS != "unknown" && img.Platform.Architecture != "unknown" {
+			known = append(known, img)
+		}
+	}
+	if len(known) == 0 {
+		return nil, fmt.Errorf("oci: the archive holds no images")
+	}
+	return known, nil
+}
+
+// resolve follows descriptors to image manifests, through nested indexes.
+func (a *Archive) resolve(descs []Descriptor, depth int) ([]Image, error) {
+	if depth > maxIndexDepth {
+		return nil, fmt.Errorf("oci: indexes nested too deeply")
+	}
+	var images []Image
+	for _, desc := range descs {
+		name, err := blobPath(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		var doc struct {
+			MediaType string       `json:"mediaType"`
+			Manifests []Descriptor `json:"manifests"`
+			Config    Descriptor   `json:"config"`
+			Layers    []Descriptor `json:"layers"`
+		}
+		if err := a.readJSON(name, desc.Digest, &doc); err != nil {
+			return nil, err
+		}
+		mediaType := desc.MediaType
+		if mediaType == "" {
+			mediaType = doc.MediaType
+		}
+
+		switch {
+		case mediaType == mediaTypeOCIIndex || mediaType == mediaTypeDockerList ||
+			(mediaType == "" && doc.Manifests != nil):
+			nested, err := a.resolve(doc.Manifests, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, nested...)
+		case mediaType == mediaTypeOCIManifest || mediaType == mediaTypeDockerManifest ||
+			(mediaType == "" && doc.Layers != nil):
+			img := Image{Name: desc.Digest}
+			if desc.Platform != nil {
+				img.Platform = *desc.Platform
+			} else if img.Platform, err = a.configPlatform(doc.Config.Digest); err != nil {
+				return nil, err
+			}
+			for _, layer := range doc.Layers {
+				p, err := blobPath(layer.Digest)
+				if err != nil {
+					return nil, err
+				}
+				img.Layers = append(img.Layers, Layer{Path: p, Digest: layer.Digest})
+			}
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+// configPlatform reads the platform of an image from its config blob.
+func (a *Archive) configPlatform(digest string) (Platform, error) {
+	name, err := blobPath(digest)
+	if err != nil {
+		return Platform{}, err
+	}
+	var p Platform
+	if err := a.readJSON(name, digest, &p); err != nil {
+		return Platform{}, err
+	}
+	return p, nil
+}
+
+// dockerImages reads the manifest.json of "docker save", whose layers are
+// archive members rather than digests.
+func (a *Archive) dockerImages() ([]Image, error) {
+	var manifest []struct {
+		Config   string   `json:"Config"`
+		RepoTags []string `json:"RepoTags"`
+		Layers   []string `json:"Layers"`
+	}
+	if err := a.readJSON("manifest.json", "", &manifest); err != nil {
+		return nil, err
+	}
+	var images []Image
+	for _, m := range manifest {
+		config := cleanName(m.Config)
+		img := Image{Name: config}
+		if len(m.RepoTags) > 0 {
+			img.Name = m.RepoTags[0]
+		}
+		if err := a.readJSON(config, digestOf(config), &img.Platform); err != nil {
+			return nil, err
+		}
+		for _, layer := range m.Layers {
+			p := cleanName(layer)
+			img.Layers = append(img.Layers, Layer{Path: p, Digest: digestOf(p)})
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// digestOf returns the digest that a blob path of the OCI layout names, or
+// "" for other members.
+func digestOf(name string) string {
+	if hash, ok := strings.CutPrefix(name, "blobs/sha256/"); ok && !strings.Contains(hash, "/") {
+		return "sha256:" + hash
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package oci
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Platform is the operating system and CPU an image runs on.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// architectures maps common alternative names to those images use.
+var architectures = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"aarch64": "arm64",
+	"armhf":   "arm",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// ParsePlatform parses a platform written as os/architecture[/variant],
+// such as linux/arm64 or linux/arm/v7.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q (expected os/architecture[/variant])", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if arch, ok := architectures[p.Architecture]; ok {
+		p.Architecture = arch
+	}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// variant returns the variant of the platform, with the one that 64-bit ARM
+// images leave out filled in.
+func (p Platform) variant() string {
+	if p.Variant == "" && p.Architecture == "arm64" {
+		return "v8"
+	}
+	return p.Variant
+}
+
+// Matches reports whether an image for p runs on want. A want without a
+// variant matches every variant.
+func (p Platform) Matches(want Platform) bool {
+	return p.OS == want.OS && p.Architecture == want.Architecture &&
+		(want.Variant == "" || p.variant() == want.variant())
+}
+
+// Select chooses the image for a platform. An archive of a single image
+// needs no platform, unless explicit asks for that one to be checked.
+// Images that match equally well are resolved in favour of the first, with
+// a warning.
+func Select(images []Image, want Platform, explicit bool) (img Image, warnings []string, err error) {
+	if len(images) == 1 && !explicit {
+		return images[0], nil, nil
+	}
+	var matches []Image
+	for _, img := range images {
+		if img.Platform.Matches(want) {
+			matches = append(matches, img)
+		}
+	}
+	if len(matches) == 0 {
+		var platforms []string
+		for _, img := range images {
+			platforms = append(platforms, img.Platform.String())
+		}
+		return Image{}, nil, fmt.Errorf("oci: no image for platform %s; the archive has %s",
+			want, strings.Join(platforms, ", "))
+	}
+	if len(matches) > 1 {
+		warnings = append(warnings, fmt.Sprintf("%d images match platform %s; using %s",
+			len(matches), want, matches[0].Name))
+	}
+	return matches[0], warnings, nil
+}
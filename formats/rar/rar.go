@@ -0,0 +1,292 @@
+// Package rar reads the headers of RAR 4 and RAR 5 archives, and the
+// content of entries stored without compression.
+package rar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"time"
+)
+
+var (
+	signature4 = []byte("Rar!\x1a\x07\x00")
+	signature5 = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+// maxSFXSize bounds the search for an archive behind a self-extractor stub.
+const maxSFXSize = 1 << 20
+
+// maxLinkSize bounds the symbolic link targets read from entry data.
+const maxLinkSize = 4096
+
+// Compression methods, from fastest to best. RAR 4 stores them offset by 0x30.
+const (
+	MethodStored  = 0
+	MethodFastest = 1
+	MethodFast    = 2
+	MethodNormal  = 3
+	MethodGood    = 4
+	MethodBest    = 5
+)
+
+// Host operating systems, as numbered by RAR 5.
+const (
+	HostWindows = 0
+	HostUnix    = 1
+)
+
+var (
+	// ErrNotRAR is returned for files without a RAR signature.
+	ErrNotRAR = errors.New("not a RAR archive")
+	// ErrCompressed is returned when the content of a compressed entry is
+	// requested.
+	ErrCompressed = errors.New("rar: compressed entries cannot be decoded natively")
+	// ErrEncrypted is returned when the content of an encrypted entry is
+	// requested.
+	ErrEncrypted = errors.New("rar: encrypted entries cannot be decoded natively")
+	// ErrSplit is returned by Open for entries continued from or in another
+	// volume, which OpenParts reads.
+	ErrSplit = errors.New("rar: entry spans volumes")
+
+	errCorruptHeader = errors.New("rar: corrupt header")
+)
+
+// Archive holds the headers of a RAR archive, or of one volume of it.
+type Archive struct {
+	Version int // 4 or 5
+
+	Solid            bool
+	Volume           bool // part of a multi-volume set
+	VolumeNumber     int  // from zero; -1 when the volume does not say
+	MoreVolumes      bool // another volume follows
+	Locked           bool // may no longer be modified
+	Recovery         bool // carries a recovery record
+	EncryptedHeaders bool // file headers are encrypted and were not read
+	Comment          bool
+
+	Files []*File
+
+	r io.ReaderAt
+}
+
+// File is an entry of a RAR archive.
+type File struct {
+	Name       string // slash separated
+	Size       int64
+	PackedSize int64
+	ModTime    time.Time
+	Mode       fs.FileMode
+	Linkname   string // symbolic link target, when known without decoding
+	HostOS     int
+	Method     int
+
+	Encrypted   bool
+	Solid       bool // compressed with the state of previous entries
+	SplitBefore bool // continued from the previous volume
+	SplitAfter  bool // continued in the next volume
+
+	hasCRC bool
+	crc    uint32
+	offset int64 // of the packed data
+}
+
+// Stored reports whether the content is kept without compression.
+func (f *File) Stored() bool {
+	return f.Method == MethodStored
+}
+
+// MethodName describes a compression method.
+func MethodName(method int) string {
+	switch method {
+	case MethodStored:
+		return "stored"
+	case MethodFastest:
+		return "fastest"
+	case MethodFast:
+		return "fast"
+	case MethodNormal:
+		return "normal"
+	case MethodGood:
+		return "good"
+	case MethodBest:
+		return "best"
+	}
+	return fmt.Sprintf("method %d", method)
+}
+
+// Read parses the headers of a RAR archive. Archives behind a
+// self-extractor stub are found as well.
+func Read(r io.ReaderAt) (*Archive, error) {
+	head := make([]byte, maxSFXSize)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	a := &Archive{r: r, VolumeNumber: -1}
+	for start := 0; start < len(head); {
+		i := bytes.Index(head[start:], signature4[:6])
+		if i < 0 {
+			break
+		}
+		start += i
+		switch {
+		case bytes.HasPrefix(head[start:], signature4):
+			a.Version = 4
+			return a, a.read4(int64(start + len(signature4)))
+		case bytes.HasPrefix(head[start:], signature5):
+			a.Version = 5
+			return a, a.read5(int64(start + len(signature5)))
+		}
+		start++
+	}
+	return nil, ErrNotRAR
+}
+
+// Open returns the content of a stored entry, checked against its CRC
+// when fully read. Symbolic links return their target.
+func (a *Archive) Open(f *File) (io.Reader, error) {
+	switch {
+	case f.Encrypted:
+		return nil, ErrEncrypted
+	case f.SplitBefore || f.SplitAfter:
+		return nil, ErrSplit
+	case !f.Stored():
+		return nil, ErrCompressed
+	case f.Mode.IsDir():
+		return bytes.NewReader(nil), nil
+	case f.PackedSize != f.Size:
+		return nil, errCorruptHeader
+	}
+	return &crcReader{
+		r:    io.NewSectionReader(a.r, f.offset, f.Size),
+		name: f.Name,
+		n:    f.Size,
+		want: f.crc,
+		skip: !f.hasCRC,
+	}, nil
+}
+
+// Part is the piece of a split entry that one volume holds.
+type Part struct {
+	Archive *Archive // the volume
+	File    *File
+}
+
+// OpenParts returns the content of a stored entry split across volumes,
+// from its parts in order. The whole is checked against the CRC of the
+// last part.
+func OpenParts(parts []Part) (io.Reader, error) {
+	if len(parts) == 0 {
+		return nil, errors.New("rar: no parts to open")
+	}
+	first, last := parts[0].File, parts[len(parts)-1].File
+	if first.SplitBefore || last.SplitAfter {
+		return nil, fmt.Errorf("rar: %s is missing parts from other volumes", first.Name)
+	}
+	readers := make([]io.Reader, 0, len(parts))
+	var packed int64
+	for _, p := range parts {
+		switch {
+		case p.File.Encrypted:
+			return nil, ErrEncrypted
+		case !p.File.Stored():
+			return nil, ErrCompressed
+		}
+		readers = append(readers, io.NewSectionReader(p.Archive.r, p.File.offset, p.File.PackedSize))
+		packed += p.File.PackedSize
+	}
+	if packed != last.Size {
+		return nil, errCorruptHeader
+	}
+	return &crcReader{
+		r:    io.MultiReader(readers...),
+		name: last.Name,
+		n:    last.Size,
+		want: last.crc,
+		skip: !last.hasCRC,
+	}, nil
+}
+
+// crcReader checks the CRC32 of content once it has all been read.
+type crcReader struct {
+	r    io.Reader
+	name string
+	n    int64
+	crc  uint32
+	want uint32
+	skip bool
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc = crc32.Update(c.crc, crc32.IEEETable, p[:n])
+	c.n -= int64(n)
+	if err == io.EOF {
+		if c.n > 0 {
+			return n, fmt.Errorf("rar: %s is truncated", c.name)
+		}
+		if !c.skip && c.crc != c.want {
+			return n, fmt.Errorf("rar: checksum mismatch in %s", c.name)
+		}
+	}
+	return n, err
+}
+
+// readLinkname reads the target of a stored symbolic link.
+func (a *Archive) readLinkname(f *File) string {
+	if f.Encrypted || !f.Stored() || f.SplitBefore || f.SplitAfter || f.Size > maxLinkSize {
+		return ""
+	}
+	buf := make([]byte, f.Size)
+	if _, err := a.r.ReadAt(buf, f.offset); err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+// unixMode converts a Unix st_mode.
+func unixMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0777)
+	switch m & 0170000 {
+	case 0040000:
+		mode |= fs.ModeDir
+	case 0120000:
+		mode |= fs.ModeSymlink
+	case 0020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0060000:
+		mode |= fs.ModeDevice
+	case 0010000:
+		mode |= fs.ModeNamedPipe
+	case 0140000:
+		mode |= fs.ModeSocket
+	}
+	if m&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// windowsMode derives permissions from Windows attributes.
+func windowsMode(attr uint32, isDir bool) fs.FileMode {
+	mode := fs.FileMode(0644)
+	if isDir || attr&0x10 != 0 {
+		mode = fs.ModeDir | 0755
+	}
+	if attr&0x01 != 0 {
+		mode &^= 0222
+	}
+	return mode
+}
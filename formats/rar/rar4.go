@@ -0,0 +1,313 @@
+package rar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// RAR 4 block types.
+const (
+	block4Main    = 0x73
+	block4File    = 0x74
+	block4Comment = 0x75
+	block4Protect = 0x78
+	block4Service = 0x7a
+	block4End     = 0x7b
+)
+
+// RAR 4 archive flags.
+const (
+	main4Volume      = 0x0001
+	main4Comment     = 0x0002
+	main4Lock        = 0x0004
+	main4Solid       = 0x0008
+	main4Protect     = 0x0040
+	main4Password    = 0x0080
+	main4FirstVolume = 0x0100
+)
+
+// RAR 4 file flags.
+const (
+	file4SplitBefore = 0x0001
+	file4SplitAfter  = 0x0002
+	file4Password    = 0x0004
+	file4Solid       = 0x0010
+	file4Directory   = 0x00E0
+	file4Large       = 0x0100
+	file4Unicode     = 0x0200
+	file4Salt        = 0x0400
+	file4ExtTime     = 0x1000
+	block4LongBlock  = 0x8000
+)
+
+// RAR 4 end of archive flags.
+const (
+	end4NextVolume = 0x0001
+	end4DataCRC    = 0x0002
+	end4VolNumber  = 0x0008
+)
+
+// read4 walks the blocks of a RAR 4 archive.
+func (a *Archive) read4(offset int64) error {
+	var base [7]byte
+	for {
+		if n, err := a.r.ReadAt(base[:], offset); err != nil {
+			if err == io.EOF && n == 0 {
+				// Archives from before RAR 3 have no end block
+				return nil
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("rar: failed to read header: %w", err)
+		}
+		kind := base[2]
+		flags := binary.LittleEndian.Uint16(base[3:])
+		size := int64(binary.LittleEndian.Uint16(base[5:]))
+		if size < 7 {
+			return errCorruptHeader
+		}
+		h := make([]byte, size)
+		if _, err := a.r.ReadAt(h, offset); err != nil {
+			return fmt.Errorf("rar: failed to read header: %w", err)
+		}
+		if uint16(crc32.ChecksumIEEE(h[2:])) != binary.LittleEndian.Uint16(h) {
+			return fmt.Errorf("rar: header checksum mismatch at offset %d", offset)
+		}
+
+		var dataSize int64
+		if flags&block4LongBlock != 0 {
+			if size < 11 {
+				return errCorruptHeader
+			}
+			dataSize = int64(binary.LittleEndian.Uint32(h[7:]))
+		}
+
+		switch kind {
+		case block4Main:
+			a.Volume = flags&main4Volume != 0
+			a.Comment = a.Comment || flags&main4Comment != 0
+			a.Locked = flags&main4Lock != 0
+			a.Solid = flags&main4Solid != 0
+			a.Recovery = flags&main4Protect != 0
+			if flags&main4FirstVolume != 0 {
+				a.VolumeNumber = 0
+			}
+			if flags&main4Password != 0 {
+				// The rest is encrypted
+				a.EncryptedHeaders = true
+				return nil
+			}
+		case block4File, block4Service:
+			f, err := parseFile4(h, flags)
+			if err != nil {
+				return err
+			}
+			f.offset = offset + size
+			dataSize = f.PackedSize
+			if kind == block4File {
+				if f.Mode&fs.ModeSymlink != 0 {
+					f.Linkname = a.readLinkname(f)
+				}
+				a.Files = append(a.Files, f)
+				break
+			}
+			switch f.Name {
+			case "CMT":
+				a.Comment = true
+			case "RR":
+				a.Recovery = true
+			}
+		case block4Comment:
+			a.Comment = true
+		case block4Protect:
+			a.Recovery = true
+		case block4End:
+			a.MoreVolumes = flags&end4NextVolume != 0
+			pos := 7
+			if flags&end4DataCRC != 0 {
+				pos += 4
+			}
+			if flags&end4VolNumber != 0 && int(size) >= pos+2 {
+				a.VolumeNumber = int(binary.LittleEndian.Uint16(h[pos:]))
+			}
+			return nil
+		}
+		offset += size + dataSize
+	}
+}
+
+// parseFile4 decodes a file or service header.
+func parseFile4(h []byte, flags uint16) (*File, error) {
+	const fixed = 32
+	if len(h) < fixed {
+		return nil, errCorruptHeader
+	}
+	f := &File{
+		PackedSize:  int64(binary.LittleEndian.Uint32(h[7:])),
+		Size:        int64(binary.LittleEndian.Uint32(h[11:])),
+		crc:         binary.LittleEndian.Uint32(h[16:]),
+		hasCRC:      true,
+		ModTime:     dosTime(binary.LittleEndian.Uint32(h[20:])),
+		Method:      int(h[25]) - 0x30,
+		Encrypted:   flags&file4Password != 0,
+		Solid:       flags&file4Solid != 0,
+		SplitBefore: flags&file4SplitBefore != 0,
+		SplitAfter:  flags&file4SplitAfter != 0,
+	}
+	host := h[15]
+	nameSize := int(binary.LittleEndian.Uint16(h[26:]))
+	attr := binary.LittleEndian.Uint32(h[28:])
+	pos := fixed
+	if flags&file4Large != 0 {
+		if len(h) < pos+8 {
+			return nil, errCorruptHeader
+		}
+		f.PackedSize |= int64(binary.LittleEndian.Uint32(h[pos:])) << 32
+		f.Size |= int64(binary.LittleEndian.Uint32(h[pos+4:])) << 32
+		pos += 8
+	}
+	if len(h) < pos+nameSize {
+		return nil, errCorruptHeader
+	}
+	name := h[pos : pos+nameSize]
+	pos += nameSize
+	if flags&file4Unicode != 0 {
+		f.Name = decodeName4(name)
+	} else {
+		f.Name = string(name)
+	}
+	f.Name = strings.ReplaceAll(f.Name, "\\", "/")
+	if flags&file4Salt != 0 {
+		pos += 8
+	}
+	if flags&file4ExtTime != 0 && len(h) >= pos+2 {
+		f.ModTime = extTime4(h[pos:], f.ModTime)
+	}
+
+	isDir := flags&file4Directory == file4Directory
+	// Multi-volume pieces of a file do not carry its checksum
+	f.hasCRC = !f.SplitAfter && !isDir
+	if host == 3 {
+		f.HostOS = HostUnix
+		f.Mode = unixMode(attr)
+		if isDir {
+			f.Mode |= fs.ModeDir
+		}
+	} else {
+		f.HostOS = HostWindows
+		f.Mode = windowsMode(attr, isDir)
+	}
+	if f.PackedSize < 0 || f.Size < 0 {
+		return nil, errCorruptHeader
+	}
+	return f, nil
+}
+
+// decodeName4 decodes a RAR 4 Unicode name: an OEM name, a zero byte, and
+// the UTF-16 name compressed against the OEM one. Names without the zero
+// byte are UTF-8.
+func decodeName4(buf []byte) string {
+	zero := -1
+	for i, c := range buf {
+		if c == 0 {
+			zero = i
+			break
+		}
+	}
+	if zero < 0 {
+		return string(buf)
+	}
+	oem, enc := buf[:zero], buf[zero+1:]
+	if len(enc) == 0 {
+		return string(oem)
+	}
+
+	high := uint16(enc[0])
+	pos := 1
+	var flags byte
+	flagBits := 0
+	var out []uint16
+	for pos < len(enc) {
+		if flagBits == 0 {
+			flags = enc[pos]
+			pos++
+			flagBits = 8
+			if pos >= len(enc) {
+				break
+			}
+		}
+		switch flags >> 6 {
+		case 0:
+			out = append(out, uint16(enc[pos]))
+			pos++
+		case 1:
+			out = append(out, uint16(enc[pos])|high<<8)
+			pos++
+		case 2:
+			if pos+1 >= len(enc) {
+				pos = len(enc)
+				break
+			}
+			out = append(out, binary.LittleEndian.Uint16(enc[pos:]))
+			pos += 2
+		case 3:
+			length := int(enc[pos])
+			pos++
+			if length&0x80 != 0 {
+				if pos >= len(enc) {
+					break
+				}
+				correction := enc[pos]
+				pos++
+				for n := length&0x7F + 2; n > 0 && len(out) < len(oem); n-- {
+					out = append(out, uint16(oem[len(out)]+correction)|high<<8)
+				}
+			} else {
+				for n := length + 2; n > 0 && len(out) < len(oem); n-- {
+					out = append(out, uint16(oem[len(out)]))
+				}
+			}
+		}
+		flags <<= 2
+		flagBits -= 2
+	}
+	return string(utf16.Decode(out))
+}
+
+// dosTime converts an MS-DOS date and time in local time.
+func dosTime(t uint32) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Date(int(t>>25)+1980, time.Month(t>>21&0x0F), int(t>>16&0x1F),
+		int(t>>11&0x1F), int(t>>5&0x3F), int(t&0x1F)*2, 0, time.Local)
+}
+
+// extTime4 applies the precise modification time of an extended time
+// field, which refines the DOS time with a remainder in 100ns units.
+func extTime4(b []byte, mtime time.Time) time.Time {
+	flags := binary.LittleEndian.Uint16(b)
+	mode := flags >> 12
+	if mode&8 == 0 || mtime.IsZero() {
+		return mtime
+	}
+	count := int(mode & 3)
+	if len(b) < 2+count {
+		return mtime
+	}
+	var rem uint32
+	for i := 0; i < count; i++ {
+		rem |= uint32(b[2+i]) << (8 * (3 - count + i))
+	}
+	if mode&4 != 0 {
+		mtime = mtime.Add(time.Second)
+	}
+	return mtime.Add(time.Duration(rem) * 100)
+}
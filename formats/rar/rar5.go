@@ -0,0 +1,286 @@
+package rar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// RAR 5 header types.
+const (
+	header5Main       = 1
+	header5File       = 2
+	header5Service    = 3
+	header5Encryption = 4
+	header5End        = 5
+)
+
+// RAR 5 common header flags.
+const (
+	flag5Extra       = 0x0001
+	flag5Data        = 0x0002
+	flag5SplitBefore = 0x0008
+	flag5SplitAfter  = 0x0010
+)
+
+// RAR 5 archive flags.
+const (
+	archive5Volume       = 0x0001
+	archive5VolumeNumber = 0x0002
+	archive5Solid        = 0x0004
+	archive5Recovery     = 0x0008
+	archive5Locked       = 0x0010
+)
+
+// RAR 5 file flags.
+const (
+	file5Directory = 0x0001
+	file5Time      = 0x0002
+	file5CRC       = 0x0004
+)
+
+// RAR 5 extra record types of file headers.
+const (
+	extra5Encryption  = 1
+	extra5Time        = 3
+	extra5Redirection = 5
+)
+
+// maxHeader5Size is the largest header RAR 5 writes.
+const maxHeader5Size = 2 << 20
+
+// read5 walks the headers of a RAR 5 archive.
+func (a *Archive) read5(offset int64) error {
+	var start [4 + 3]byte
+	for {
+		// Every archive and volume closes with an end header
+		if n, err := a.r.ReadAt(start[:], offset); n < len(start) {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("rar: failed to read header: %w", err)
+		}
+		sum := binary.LittleEndian.Uint32(start[:])
+		size, sizeLen := binary.Uvarint(start[4:])
+		if sizeLen <= 0 || size == 0 || size > maxHeader5Size {
+			return errCorruptHeader
+		}
+		h := make([]byte, sizeLen+int(size))
+		if _, err := a.r.ReadAt(h, offset+4); err != nil {
+			return fmt.Errorf("rar: failed to read header: %w", err)
+		}
+		if crc32.ChecksumIEEE(h) != sum {
+			return fmt.Errorf("rar: header checksum mismatch at offset %d", offset)
+		}
+
+		hr := &header5{b: h[sizeLen:]}
+		kind := hr.vint()
+		flags := hr.vint()
+		var extraSize, dataSize uint64
+		if flags&flag5Extra != 0 {
+			extraSize = hr.vint()
+		}
+		if flags&flag5Data != 0 {
+			dataSize = hr.vint()
+		}
+		if hr.err != nil || extraSize > uint64(len(hr.b)) || dataSize > 1<<62 {
+			return errCorruptHeader
+		}
+		extra := hr.b[len(hr.b)-int(extraSize):]
+		hr.b = hr.b[:len(hr.b)-int(extraSize)]
+		dataOffset := offset + 4 + int64(len(h))
+
+		switch kind {
+		case header5Main:
+			archiveFlags := hr.vint()
+			a.Volume = archiveFlags&archive5Volume != 0
+			a.Solid = archiveFlags&archive5Solid != 0
+			a.Recovery = archiveFlags&archive5Recovery != 0
+			a.Locked = archiveFlags&archive5Locked != 0
+			if a.Volume {
+				a.VolumeNumber = 0
+			}
+			if archiveFlags&archive5VolumeNumber != 0 {
+				a.VolumeNumber = int(hr.vint())
+			}
+		case header5File, header5Service:
+			f, err := parseFile5(hr, extra, flags)
+			if err != nil {
+				return err
+			}
+			f.PackedSize = int64(dataSize)
+			f.offset = dataOffset
+			if kind == header5File {
+				if f.Mode&fs.ModeSymlink != 0 && f.Linkname == "" {
+					f.Linkname = a.readLinkname(f)
+				}
+				a.Files = append(a.Files, f)
+				break
+			}
+			switch f.Name {
+			case "CMT":
+				a.Comment = true
+			case "RR":
+				a.Recovery = true
+			}
+		case header5Encryption:
+			// The rest is encrypted
+			a.EncryptedHeaders = true
+			return nil
+		case header5End:
+			a.MoreVolumes = hr.vint()&0x0001 != 0
+			return nil
+		}
+		if hr.err != nil {
+			return errCorruptHeader
+		}
+		offset = dataOffset + int64(dataSize)
+	}
+}
+
+// parseFile5 decodes a file or service header and its extra records.
+func parseFile5(hr *header5, extra []byte, flags uint64) (*File, error) {
+	f := &File{
+		SplitBefore: flags&flag5SplitBefore != 0,
+		SplitAfter:  flags&flag5SplitAfter != 0,
+	}
+	fileFlags := hr.vint()
+	f.Size = int64(hr.vint())
+	attr := hr.vint()
+	if fileFlags&file5Time != 0 {
+		f.ModTime = time.Unix(int64(hr.uint32()), 0)
+	}
+	if fileFlags&file5CRC != 0 {
+		f.crc = hr.uint32()
+		f.hasCRC = true
+	}
+	info := hr.vint()
+	f.Method = int(info >> 7 & 7)
+	f.Solid = info&0x40 != 0
+	f.HostOS = int(hr.vint())
+	name := hr.bytes(int(hr.vint()))
+	if hr.err != nil || f.Size < 0 {
+		return nil, errCorruptHeader
+	}
+	f.Name = string(name)
+
+	isDir := fileFlags&file5Directory != 0
+	if f.HostOS == HostUnix {
+		f.Mode = unixMode(uint32(attr))
+		if isDir {
+			f.Mode |= fs.ModeDir
+		}
+	} else {
+		f.Name = strings.ReplaceAll(f.Name, "\\", "/")
+		f.Mode = windowsMode(uint32(attr), isDir)
+	}
+
+	// Extra records: size, type and type specific data
+	for len(extra) > 0 {
+		er := &header5{b: extra}
+		size := er.vint()
+		if er.err != nil || size == 0 || size > uint64(len(er.b)) {
+			return nil, errCorruptHeader
+		}
+		rec := &header5{b: er.b[:size]}
+		extra = er.b[size:]
+		switch rec.vint() {
+		case extra5Encryption:
+			f.Encrypted = true
+		case extra5Time:
+			if t, ok := rec.mtime(); ok {
+				f.ModTime = t
+			}
+		case extra5Redirection:
+			kind := rec.vint()
+			rec.vint() // flags
+			target := rec.bytes(int(rec.vint()))
+			if rec.err == nil && (kind == 1 || kind == 2) {
+				f.Linkname = strings.ReplaceAll(string(target), "\\", "/")
+				f.Mode = f.Mode&^fs.ModeType | fs.ModeSymlink
+			}
+		}
+	}
+	return f, nil
+}
+
+// header5 decodes the fields of a RAR 5 header, remembering the first
+// failure instead of returning it from every call.
+type header5 struct {
+	b   []byte
+	err error
+}
+
+func (h *header5) vint() uint64 {
+	if h.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(h.b)
+	if n <= 0 {
+		h.err = errCorruptHeader
+		return 0
+	}
+	h.b = h.b[n:]
+	return v
+}
+
+func (h *header5) uint32() uint32 {
+	b := h.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (h *header5) bytes(n int) []byte {
+	if h.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(h.b) {
+		h.err = errCorruptHeader
+		return nil
+	}
+	b := h.b[:n]
+	h.b = h.b[n:]
+	return b
+}
+
+// mtime decodes the modification time of a time record, which holds
+// either Unix times or Windows FILETIMEs.
+func (h *header5) mtime() (time.Time, bool) {
+	flags := h.vint()
+	if flags&0x2 == 0 {
+		return time.Time{}, false
+	}
+	if flags&0x1 == 0 {
+		b := h.bytes(8)
+		if b == nil {
+			return time.Time{}, false
+		}
+		const epochDelta = 116444736000000000
+		t := int64(binary.LittleEndian.Uint64(b)) - epochDelta
+		return time.Unix(t/1e7, t%1e7*100), true
+	}
+	sec := h.uint32()
+	if h.err != nil {
+		return time.Time{}, false
+	}
+	var nsec uint32
+	if flags&0x10 != 0 {
+		// Nanoseconds follow all the times that are present
+		for _, bit := range []uint64{0x4, 0x8} {
+			if flags&bit != 0 {
+				h.uint32()
+			}
+		}
+		nsec = h.uint32()
+		if h.err != nil || nsec >= 1e9 {
+			nsec = 0
+		}
+	}
+	return time.Unix(int64(sec), int64(nsec)), true
+}
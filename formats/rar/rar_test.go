@@ -0,0 +1,281 @@
+package rar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type member struct {
+	Name     string
+	Mode     fs.FileMode
+	Linkname string
+	Data     string
+	Err      error // why the content cannot be read natively
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readArchive reads the headers of an archive and the content of its
+// stored entries.
+func readArchive(data []byte) ([]member, error) {
+	a, err := Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var members []member
+	for _, f := range a.Files {
+		m := member{Name: f.Name, Mode: f.Mode, Linkname: f.Linkname}
+		r, err := a.Open(f)
+		switch {
+		case errors.Is(err, ErrCompressed) || errors.Is(err, ErrEncrypted) || errors.Is(err, ErrSplit):
+			m.Err = err
+		case err != nil:
+			return members, err
+		default:
+			content, err := io.ReadAll(r)
+			if err != nil {
+				return members, err
+			}
+			m.Data = string(content)
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// The fixtures were written by testdata/gen.go, as there is no rar to make
+// them, and checked with bsdtar, which reads RAR archives on its own.
+func TestReadFixtures(t *testing.T) {
+	link := member{"link", fs.ModeSymlink | 0777, "dir/hello.txt", "dir/hello.txt", nil}
+	want := []member{
+		{"dir", fs.ModeDir | 0755, "", "", nil},
+		{"dir/hello.txt", 0644, "", "hello\n", nil},
+		{"dir/win.txt", 0444, "", "windows\r\n", nil},
+		{"héllo.txt", 0600, "", "unicode\n", nil},
+		link,
+		{"packed.txt", 0644, "", "", ErrCompressed},
+	}
+	tests := []struct {
+		name    string
+		version int
+		want    []member
+		mtime   time.Time // of dir/hello.txt, which has a precise time
+	}{
+		{"v4.rar", 4, want, time.Date(2023, 11, 14, 22, 13, 20, 123456700, time.Local)},
+		{"v5.rar", 5, nil, time.Unix(1700000000, 123456789)},
+	}
+	// RAR 5 links keep their target in the header and have no data
+	link.Data = ""
+	tests[1].want = append(append(want[:4:4], link, want[5]), member{"secret.txt", 0644, "", "", ErrEncrypted})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := readFixture(t, tt.name)
+			a, err := Read(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Version != tt.version || a.Volume || a.Solid || a.EncryptedHeaders {
+				t.Errorf("got archive %+v", a)
+			}
+			dosTime := time.Date(2023, 11, 14, 22, 13, 20, 0, time.Local)
+			if tt.version == 5 {
+				dosTime = time.Unix(1700000000, 0)
+			}
+			for _, f := range a.Files {
+				mtime := dosTime
+				if f.Name == "dir/hello.txt" {
+					mtime = tt.mtime
+				}
+				if !f.ModTime.Equal(mtime) {
+					t.Errorf("%s: got time %v, want %v", f.Name, f.ModTime, mtime)
+				}
+			}
+			if f := a.Files[2]; f.HostOS != HostWindows {
+				t.Errorf("%s: got host %d", f.Name, f.HostOS)
+			}
+			if f := a.Files[5]; f.Method != MethodNormal || f.Size != 100 || f.PackedSize != 8 {
+				t.Errorf("%s: got method %d, size %d, packed %d", f.Name, f.Method, f.Size, f.PackedSize)
+			}
+
+			got, err := readArchive(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenParts(t *testing.T) {
+	var parts []Part
+	for i, name := range []string{"split.part1.rar", "split.part2.rar"} {
+		a, err := Read(bytes.NewReader(readFixture(t, name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.Volume || a.VolumeNumber != i || a.MoreVolumes != (i == 0) {
+			t.Errorf("%s: got volume %v, number %d, more volumes %v", name, a.Volume, a.VolumeNumber, a.MoreVolumes)
+		}
+		if _, err := a.Open(a.Files[0]); err != ErrSplit {
+			t.Errorf("%s: Open returned %v, want ErrSplit", name, err)
+		}
+		parts = append(parts, Part{a, a.Files[0]})
+	}
+
+	r, err := OpenParts(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil || string(content) != "first half, second half\n" {
+		t.Errorf("got %q, %v", content, err)
+	}
+
+	for name, parts := range map[string][]Part{
+		"none":        nil,
+		"first only":  parts[:1],
+		"second only": parts[1:],
+	} {
+		if _, err := OpenParts(parts); err == nil {
+			t.Errorf("%s: parts were accepted", name)
+		}
+	}
+
+	// A changed byte in either part fails the checksum of the whole
+	data := readFixture(t, "split.part1.rar")
+	data[bytes.Index(data, []byte("first"))] = 'F'
+	a, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = OpenParts([]Part{{a, a.Files[0]}, parts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("got %v, want a checksum mismatch", err)
+	}
+}
+
+// encodeBlock4 encodes a RAR 4 block.
+func encodeBlock4(kind byte, flags uint16, body []byte) []byte {
+	h := []byte{0, 0, kind, byte(flags), byte(flags >> 8), byte(7 + len(body)), 0}
+	h = append(h, body...)
+	binary.LittleEndian.PutUint16(h, uint16(crc32.ChecksumIEEE(h[2:])))
+	return h
+}
+
+// encodeHeader5 encodes a RAR 5 header from its fields, each a vint or
+// raw bytes.
+func encodeHeader5(fields ...any) []byte {
+	var body []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case int:
+			body = binary.AppendUvarint(body, uint64(v))
+		case string:
+			body = append(body, v...)
+		}
+	}
+	h := append(binary.AppendUvarint(nil, uint64(len(body))), body...)
+	return append(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(h)), h...)
+}
+
+func TestInvalidArchives(t *testing.T) {
+	sig4, sig5 := string(signature4), string(signature5)
+	main4 := string(encodeBlock4(block4Main, 0, make([]byte, 6)))
+	main5 := string(encodeHeader5(header5Main, 0, 0))
+	fileBody := func(nameSize int, name string) []byte {
+		body := make([]byte, 25)
+		body[18] = 0x30
+		binary.LittleEndian.PutUint16(body[19:], uint16(nameSize))
+		return append(body, name...)
+	}
+	large := fileBody(1, "a")
+	large = append(large[:25], "\x00\x00\x00\x80\x00\x00\x00\x00a"...)
+	v4, v5 := readFixture(t, "v4.rar"), readFixture(t, "v5.rar")
+
+	tests := map[string]string{
+		"empty":               "",
+		"no signature":        "Rar!\x1a\x07\x02\x00",
+		"short block":         sig4 + "\x00\x00\x73\x00\x00\x05\x00",
+		"block cut short":     sig4 + main4[:5],
+		"block checksum":      sig4 + "\x00\x00" + main4[2:],
+		"short file header":   sig4 + main4 + string(encodeBlock4(block4File, 0x8000, make([]byte, 20))),
+		"name beyond block":   sig4 + main4 + string(encodeBlock4(block4File, 0x8000, fileBody(100, "a"))),
+		"negative file size":  sig4 + main4 + string(encodeBlock4(block4File, 0x8100, large)),
+		"RAR 4 cut in block":  string(v4[:len(v4)-3]),
+		"zero header size":    sig5 + "\x00\x00\x00\x00\x00\x00\x00",
+		"unterminated size":   sig5 + "\x00\x00\x00\x00\xff\xff\xff\xff",
+		"header checksum":     sig5 + "\x00" + main5[1:],
+		"name beyond header":  sig5 + main5 + string(encodeHeader5(header5File, flag5Data, 0, 0, 0, 0, 0, HostUnix, 50, "abc")),
+		"extra beyond header": sig5 + main5 + string(encodeHeader5(header5File, flag5Extra, 10, 0, 0, 0, 0, HostUnix, 1, "a")),
+		"extra record size":   sig5 + main5 + string(encodeHeader5(header5File, flag5Extra, 3, 0, 0, 0, 0, HostUnix, 1, "a", 16, 5, 0)),
+		"no end header":       string(v5[:len(v5)-8]),
+		"RAR 5 cut in header": string(v5[:len(v5)-3]),
+	}
+	for name, data := range tests {
+		if _, err := readArchive([]byte(data)); err == nil {
+			t.Errorf("%s: archive was accepted", name)
+		}
+	}
+
+	// A file size beyond int64
+	huge := string(binary.AppendUvarint(nil, 1<<63))
+	data := sig5 + main5 + string(encodeHeader5(header5File, 0, 0, huge, 0, 0, HostUnix, 1, "a")) + string(encodeHeader5(header5End, 0, 0))
+	if _, err := readArchive([]byte(data)); err == nil {
+		t.Error("negative size was accepted")
+	}
+}
+
+// TestDamagedArchives checks that truncated and corrupt archives fail with
+// an error rather than a panic. Archives from before RAR 3 have no end
+// block, so RAR 4 ones cut between blocks may give the entries before the
+// cut; every header and stored entry has a checksum, so a changed byte
+// that is accepted must be in data that is not read.
+func TestDamagedArchives(t *testing.T) {
+	for _, name := range []string{"v4.rar", "v5.rar", "split.part1.rar", "split.part2.rar"} {
+		data := readFixture(t, name)
+		want, err := readArchive(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			got, err := readArchive(data[:n])
+			if err != nil {
+				continue
+			}
+			if name != "v4.rar" || len(got) > len(want) || len(got) > 0 && !reflect.DeepEqual(got, want[:len(got)]) {
+				t.Errorf("%s truncated to %d bytes: got %+v", name, n, got)
+			}
+		}
+		for i := range data {
+			data[i] ^= 0xff
+			got, err := readArchive(data)
+			if err == nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("%s with byte %d changed: got %+v", name, i, got)
+			}
+			data[i] ^= 0xff
+		}
+	}
+}
@@ -0,0 +1,225 @@
+//go:build ignore
+
+// This program writes the RAR fixtures of the rar package tests. There is no
+// rar in the test environment, so the archives are laid out by hand; bsdtar
+// lists and extracts them. Run it from the package directory:
+//
+//	go run testdata/gen.go
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"log"
+	"os"
+	"time"
+	"unicode/utf16"
+)
+
+var mtime = time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+const (
+	hello  = "hello\n"
+	target = "dir/hello.txt"
+)
+
+// block4 encodes a RAR 4 block, whose checksum is the low half of the
+// CRC32 of the rest of the header.
+func block4(kind byte, flags uint16, body []byte) []byte {
+	h := make([]byte, 7, 7+len(body))
+	h[2] = kind
+	binary.LittleEndian.PutUint16(h[3:], flags)
+	binary.LittleEndian.PutUint16(h[5:], uint16(7+len(body)))
+	h = append(h, body...)
+	binary.LittleEndian.PutUint16(h, uint16(crc32.ChecksumIEEE(h[2:])))
+	return h
+}
+
+type file4 struct {
+	name   []byte
+	flags  uint16
+	host   byte
+	method byte
+	attr   uint32
+	size   int // of the unpacked content, when data is packed
+	data   string
+	extra  []byte // after the name
+}
+
+func (f file4) encode() []byte {
+	body := make([]byte, 25)
+	size := f.size
+	if f.method == 0x30 {
+		size = len(f.data)
+	}
+	binary.LittleEndian.PutUint32(body[0:], uint32(len(f.data)))
+	binary.LittleEndian.PutUint32(body[4:], uint32(size))
+	body[8] = f.host
+	binary.LittleEndian.PutUint32(body[9:], crc32.ChecksumIEEE([]byte(f.data)))
+	binary.LittleEndian.PutUint32(body[13:], dosTime(mtime))
+	body[17] = 29
+	body[18] = f.method
+	binary.LittleEndian.PutUint16(body[19:], uint16(len(f.name)))
+	binary.LittleEndian.PutUint32(body[21:], f.attr)
+	body = append(body, f.name...)
+	body = append(body, f.extra...)
+	return append(block4(0x74, 0x8000|f.flags, body), f.data...)
+}
+
+func dosTime(t time.Time) uint32 {
+	return uint32(t.Year()-1980)<<25 | uint32(t.Month())<<21 | uint32(t.Day())<<16 |
+		uint32(t.Hour())<<11 | uint32(t.Minute())<<5 | uint32(t.Second()/2)
+}
+
+// unicodeName4 encodes a name as RAR 4 does for names outside the OEM code
+// page: a fallback name, a zero byte, then each UTF-16 unit in full.
+func unicodeName4(name string) []byte {
+	b := []byte("h?llo.txt\x00\x00")
+	units := utf16.Encode([]rune(name))
+	for i, u := range units {
+		if i%4 == 0 {
+			b = append(b, 0xaa)
+		}
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func rar4() []byte {
+	// The modification time is refined by 1234567 units of 100ns
+	extTime := []byte{0x00, 0xb0, 0x87, 0xd6, 0x12}
+	var b []byte
+	b = append(b, "Rar!\x1a\x07\x00"...)
+	b = append(b, block4(0x73, 0, make([]byte, 6))...)
+	for _, f := range []file4{
+		{name: []byte("dir"), flags: 0x00e0, host: 3, method: 0x30, attr: 040755},
+		{name: []byte("dir/hello.txt"), flags: 0x1000, host: 3, method: 0x30, attr: 0100644, data: hello, extra: extTime},
+		{name: []byte(`dir\win.txt`), host: 2, method: 0x30, attr: 0x21, data: "windows\r\n"},
+		{name: unicodeName4("héllo.txt"), flags: 0x0200, host: 3, method: 0x30, attr: 0100600, data: "unicode\n"},
+		{name: []byte("link"), host: 3, method: 0x30, attr: 0120777, data: target},
+		{name: []byte("packed.txt"), host: 3, method: 0x33, attr: 0100644, size: 100, data: "\x0c\x00packed"},
+	} {
+		b = append(b, f.encode()...)
+	}
+	return append(b, block4(0x7b, 0x4000, nil)...)
+}
+
+// header5 encodes a RAR 5 header from its fields, each a vint or raw bytes.
+func header5(fields ...any) []byte {
+	var body []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case int:
+			body = binary.AppendUvarint(body, uint64(v))
+		case []byte:
+			body = append(body, v...)
+		case string:
+			body = append(body, v...)
+		}
+	}
+	h := binary.AppendUvarint(nil, uint64(len(body)))
+	h = append(h, body...)
+	return append(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(h)), h...)
+}
+
+// record5 encodes an extra record, which is a header without the checksum.
+func record5(fields ...any) []byte {
+	return header5(fields...)[4:]
+}
+
+type file5 struct {
+	name    string
+	flags   int // of the header, besides those for extra and data
+	dir     bool
+	host    int
+	method  int
+	attr    int
+	size    int // of the unpacked content, when data is packed or split
+	crc     uint32
+	data    string
+	records [][]byte
+}
+
+func (f file5) encode() []byte {
+	var extra []byte
+	for _, r := range f.records {
+		extra = append(extra, r...)
+	}
+	flags := f.flags | 0x0002
+	if extra != nil {
+		flags |= 0x0001
+	}
+	fileFlags := 0x0002 | 0x0004
+	if f.dir {
+		fileFlags = 0x0003
+	}
+	size := f.size
+	if size == 0 {
+		size = len(f.data)
+	}
+	crc := f.crc
+	if crc == 0 {
+		crc = crc32.ChecksumIEEE([]byte(f.data))
+	}
+	fields := []any{2, flags}
+	if extra != nil {
+		fields = append(fields, len(extra))
+	}
+	fields = append(fields, len(f.data), fileFlags, size, f.attr,
+		binary.LittleEndian.AppendUint32(nil, uint32(mtime.Unix())))
+	if !f.dir {
+		fields = append(fields, binary.LittleEndian.AppendUint32(nil, crc))
+	}
+	fields = append(fields, f.method<<7, f.host, len(f.name), f.name, extra)
+	return append(header5(fields...), f.data...)
+}
+
+func rar5(files []file5, archiveFlags []any, endFlags int) []byte {
+	var b []byte
+	b = append(b, "Rar!\x1a\x07\x01\x00"...)
+	b = append(b, header5(append([]any{1, 0}, archiveFlags...)...)...)
+	for _, f := range files {
+		b = append(b, f.encode()...)
+	}
+	return append(b, header5(5, 0, endFlags)...)
+}
+
+func main() {
+	// A Unix time with nanoseconds
+	timeRecord := record5(3, 0x1|0x2|0x10, binary.LittleEndian.AppendUint32(nil, uint32(mtime.Unix())),
+		binary.LittleEndian.AppendUint32(nil, 123456789))
+	symlink := record5(5, 1, 0, len(target), target)
+	encryption := record5(1, 0, 0, 15, make([]byte, 32))
+
+	v5 := rar5([]file5{
+		{name: "dir", dir: true, host: 1, attr: 040755},
+		{name: "dir/hello.txt", host: 1, attr: 0100644, data: hello, records: [][]byte{timeRecord}},
+		{name: "dir/win.txt", host: 0, attr: 0x21, data: "windows\r\n"},
+		{name: "héllo.txt", host: 1, attr: 0100600, data: "unicode\n"},
+		{name: "link", host: 1, attr: 0120777, records: [][]byte{symlink}},
+		{name: "packed.txt", host: 1, method: 3, attr: 0100644, size: 100, data: "\x0c\x00packed"},
+		{name: "secret.txt", host: 1, attr: 0100644, data: "0123456789abcdef", records: [][]byte{encryption}},
+	}, []any{0}, 0)
+
+	// A file split across two volumes, whose last part has the checksum of
+	// the whole
+	whole := "first half, second half\n"
+	part1 := rar5([]file5{
+		{name: "split.txt", flags: 0x0010, host: 1, attr: 0100644, size: len(whole), data: whole[:12]},
+	}, []any{0x1}, 0x1)
+	part2 := rar5([]file5{
+		{name: "split.txt", flags: 0x0008, host: 1, attr: 0100644, size: len(whole), data: whole[12:],
+			crc: crc32.ChecksumIEEE([]byte(whole))},
+	}, []any{0x1 | 0x2, 1}, 0)
+
+	for name, data := range map[string][]byte{
+		"v4.rar":          rar4(),
+		"v5.rar":          v5,
+		"split.part1.rar": part1,
+		"split.part2.rar": part2,
+	} {
+		if err := os.WriteFile("testdata/"+name, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
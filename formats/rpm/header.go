@@ -0,0 +1,229 @@
+package rpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Tag value types.
+const (
+	TypeNull        = 0
+	TypeChar        = 1
+	TypeInt8        = 2
+	TypeInt16       = 3
+	TypeInt32       = 4
+	TypeInt64       = 5
+	TypeString      = 6
+	TypeBin         = 7
+	TypeStringArray = 8
+	TypeI18NString  = 9
+)
+
+// Header tags used by this package. The signature header shares the
+// numbering of its SIG* tags with some of these.
+const (
+	TagName              = 1000
+	TagVersion           = 1001
+	TagRelease           = 1002
+	TagEpoch             = 1003
+	TagSummary           = 1004
+	TagDescription       = 1005
+	TagBuildTime         = 1006
+	TagBuildHost         = 1007
+	TagSize              = 1009
+	TagVendor            = 1011
+	TagLicense           = 1014
+	TagPackager          = 1015
+	TagGroup             = 1016
+	TagURL               = 1020
+	TagOS                = 1021
+	TagArch              = 1022
+	TagOldFilenames      = 1027
+	TagFileSizes         = 1028
+	TagFileModes         = 1030
+	TagFileRdevs         = 1033
+	TagFileMtimes        = 1034
+	TagFileLinkTos       = 1036
+	TagFileUsername      = 1039
+	TagFileGroupname     = 1040
+	TagSourceRPM         = 1044
+	TagProvideName       = 1047
+	TagRequireFlags      = 1048
+	TagRequireName       = 1049
+	TagRequireVersion    = 1050
+	TagDirIndexes        = 1116
+	TagBasenames         = 1117
+	TagDirnames          = 1118
+	TagPayloadFormat     = 1124
+	TagPayloadCompressor = 1125
+	TagLongFileSizes     = 5008
+	TagLongSize          = 5009
+
+	SigTagSHA1   = 269
+	SigTagSHA256 = 273
+)
+
+var headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+
+// Entry is one tag of a header with its raw value.
+type Entry struct {
+	Tag   int
+	Type  int
+	Count int
+	data  []byte // value bytes, starting at the entry's offset
+}
+
+// Header is a signature or main header.
+type Header struct {
+	Entries map[int]*Entry
+	Raw     []byte // the header as stored, for digest checks
+}
+
+// readHeader reads a header structure. The signature header is followed by
+// padding to an 8 byte boundary, which pad requests be skipped.
+func readHeader(r io.Reader, pad bool) (*Header, error) {
+	var intro [16]byte
+	if _, err := io.ReadFull(r, intro[:]); err != nil {
+		return nil, fmt.Errorf("truncated header")
+	}
+	if string(intro[:4]) != string(headerMagic) {
+		return nil, fmt.Errorf("invalid header magic")
+	}
+	count := binary.BigEndian.Uint32(intro[8:])
+	size := binary.BigEndian.Uint32(intro[12:])
+	if count > 1<<16 || size > 256<<20 {
+		return nil, fmt.Errorf("header too large (%d entries, %d bytes)", count, size)
+	}
+	body := make([]byte, int(count)*16+int(size))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("truncated header")
+	}
+	if pad {
+		if n := (8 - len(body)%8) % 8; n > 0 {
+			if _, err := io.ReadFull(r, make([]byte, n)); err != nil {
+				return nil, fmt.Errorf("truncated header")
+			}
+		}
+	}
+
+	store := body[count*16:]
+	h := &Header{Entries: make(map[int]*Entry), Raw: append(intro[:], body...)}
+	for i := 0; i < int(count); i++ {
+		idx := body[i*16:]
+		e := &Entry{
+			Tag:   int(binary.BigEndian.Uint32(idx)),
+			Type:  int(binary.BigEndian.Uint32(idx[4:])),
+			Count: int(binary.BigEndian.Uint32(idx[12:])),
+		}
+		off := binary.BigEndian.Uint32(idx[8:])
+		if off > size {
+			return nil, fmt.Errorf("tag %d: offset out of range", e.Tag)
+		}
+		e.data = store[off:]
+		h.Entries[e.Tag] = e
+	}
+	return h, nil
+}
+
+// String returns the first string value of a tag, or "" when absent.
+func (h *Header) String(tag int) string {
+	if s := h.Strings(tag); len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+// Strings returns the values of a string, string array or I18N string tag.
+func (h *Header) Strings(tag int) []string {
+	e := h.Entries[tag]
+	if e == nil {
+		return nil
+	}
+	switch e.Type {
+	case TypeString, TypeStringArray, TypeI18NString:
+	default:
+		return nil
+	}
+	n := e.Count
+	if e.Type == TypeString {
+		n = 1
+	}
+	var out []string
+	data := e.data
+	for i := 0; i < n; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			break
+		}
+		out = append(out, string(data[:end]))
+		data = data[end+1:]
+	}
+	return out
+}
+
+// Ints returns the values of an integer tag.
+func (h *Header) Ints(tag int) []int64 {
+	e := h.Entries[tag]
+	if e == nil {
+		return nil
+	}
+	width := map[int]int{TypeChar: 1, TypeInt8: 1, TypeInt16: 2, TypeInt32: 4, TypeInt64: 8}[e.Type]
+	if width == 0 || len(e.data) < width*e.Count {
+		return nil
+	}
+	out := make([]int64, e.Count)
+	for i := range out {
+		b := e.data[i*width:]
+		switch width {
+		case 1:
+			out[i] = int64(b[0])
+		case 2:
+			out[i] = int64(binary.BigEndian.Uint16(b))
+		case 4:
+			out[i] = int64(binary.BigEndian.Uint32(b))
+		case 8:
+			out[i] = int64(binary.BigEndian.Uint64(b))
+		}
+	}
+	return out
+}
+
+// Int returns the first value of an integer tag.
+func (h *Header) Int(tag int) (int64, bool) {
+	if v := h.Ints(tag); len(v) > 0 {
+		return v[0], true
+	}
+	return 0, false
+}
+
+// Bytes returns the value of a binary tag.
+func (h *Header) Bytes(tag int) []byte {
+	e := h.Entries[tag]
+	if e == nil || e.Type != TypeBin || len(e.data) < e.Count {
+		return nil
+	}
+	return e.data[:e.Count]
+}
+
+// Format renders a tag's value as text, joining arrays with ", ".
+func (h *Header) Format(tag int) string {
+	e := h.Entries[tag]
+	if e == nil {
+		return ""
+	}
+	switch e.Type {
+	case TypeString, TypeStringArray, TypeI18NString:
+		return strings.Join(h.Strings(tag), ", ")
+	case TypeBin:
+		return fmt.Sprintf("%x", h.Bytes(tag))
+	}
+	var parts []string
+	for _, v := range h.Ints(tag) {
+		parts = append(parts, strconv.FormatInt(v, 10))
+	}
+	return strings.Join(parts, ", ")
+}
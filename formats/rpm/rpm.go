@@ -0,0 +1,217 @@
+// Package rpm reads RPM packages: the lead, the signature and main headers,
+// and the compressed cpio payload that follows them.
+package rpm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"futile/compress/lzma"
+	"futile/compress/xz"
+	"futile/compress/zstd"
+	"io"
+	"io/fs"
+	"time"
+)
+
+var leadMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+
+// Package holds the metadata of an RPM package.
+type Package struct {
+	Major, Minor int  // lead format version
+	Source       bool // source rather than binary package
+	Signature    *Header
+	Header       *Header
+}
+
+// File is one entry of a package's file list.
+type File struct {
+	Name     string
+	Size     int64
+	Mode     fs.FileMode
+	ModTime  time.Time
+	Owner    string
+	Group    string
+	Linkname string
+}
+
+// Read parses the lead and headers of a package, leaving r positioned at the
+// start of the payload.
+func Read(r io.Reader) (*Package, error) {
+	var lead [96]byte
+	if _, err := io.ReadFull(r, lead[:]); err != nil || !bytes.Equal(lead[:4], leadMagic) {
+		return nil, fmt.Errorf("not an RPM package")
+	}
+	p := &Package{
+		Major:  int(lead[4]),
+		Minor:  int(lead[5]),
+		Source: binary.BigEndian.Uint16(lead[6:]) == 1,
+	}
+	if p.Major < 3 {
+		return nil, fmt.Errorf("unsupported RPM format version %d.%d", p.Major, p.Minor)
+	}
+	var err error
+	if p.Signature, err = readHeader(r, true); err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+	if p.Header, err = readHeader(r, false); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return p, nil
+}
+
+// VerifyDigest checks the header against the SHA256 or SHA1 digest stored in
+// the signature. It returns the algorithm used, or "" when neither is present.
+func (p *Package) VerifyDigest() (string, error) {
+	if want := p.Signature.String(SigTagSHA256); want != "" {
+		sum := sha256.Sum256(p.Header.Raw)
+		if hex.EncodeToString(sum[:]) != want {
+			return "SHA256", fmt.Errorf("header SHA256 digest mismatch")
+		}
+		return "SHA256", nil
+	}
+	if want := p.Signature.String(SigTagSHA1); want != "" {
+		sum := sha1.Sum(p.Header.Raw)
+		if hex.EncodeToString(sum[:]) != want {
+			return "SHA1", fmt.Errorf("header SHA1 digest mismatch")
+		}
+		return "SHA1", nil
+	}
+	return "", nil
+}
+
+// Files returns the file list recorded in the header.
+func (p *Package) Files() []File {
+	h := p.Header
+	names := h.Strings(TagOldFilenames)
+	if names == nil {
+		dirs := h.Strings(TagDirnames)
+		for i, base := range h.Strings(TagBasenames) {
+			dir := ""
+			if idx := h.Ints(TagDirIndexes); i < len(idx) && idx[i] >= 0 && idx[i] < int64(len(dirs)) {
+				dir = dirs[idx[i]]
+			}
+			names = append(names, dir+base)
+		}
+	}
+
+	sizes := h.Ints(TagLongFileSizes)
+	if sizes == nil {
+		sizes = h.Ints(TagFileSizes)
+	}
+	modes := h.Ints(TagFileModes)
+	mtimes := h.Ints(TagFileMtimes)
+	owners := h.Strings(TagFileUsername)
+	groups := h.Strings(TagFileGroupname)
+	links := h.Strings(TagFileLinkTos)
+
+	files := make([]File, len(names))
+	for i, name := range names {
+		f := File{Name: name}
+		if i < len(sizes) {
+			f.Size = sizes[i]
+		}
+		if i < len(modes) {
+			f.Mode = unixMode(uint16(modes[i]))
+		}
+		if i < len(mtimes) {
+			f.ModTime = time.Unix(mtimes[i], 0)
+		}
+		if i < len(owners) {
+			f.Owner = owners[i]
+		}
+		if i < len(groups) {
+			f.Group = groups[i]
+		}
+		if i < len(links) {
+			f.Linkname = links[i]
+		}
+		files[i] = f
+	}
+	return files
+}
+
+// unixMode converts st_mode bits to an fs.FileMode.
+func unixMode(m uint16) fs.FileMode {
+	mode := fs.FileMode(m & 0777)
+	switch m & 0170000 {
+	case 0040000:
+		mode |= fs.ModeDir
+	case 0120000:
+		mode |= fs.ModeSymlink
+	case 0020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0060000:
+		mode |= fs.ModeDevice
+	case 0010000:
+		mode |= fs.ModeNamedPipe
+	case 0140000:
+		mode |= fs.ModeSocket
+	}
+	if m&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// Payload returns the decompressed payload of a package read by Read. The
+// compressor is taken from the header and confirmed by the data's magic.
+func (p *Package) Payload(r io.Reader) (io.Reader, error) {
+	if format := p.Header.String(TagPayloadFormat); format != "" && format != "cpio" {
+		return nil, fmt.Errorf("unsupported payload format %q", format)
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+	compressor := p.Header.String(TagPayloadCompressor)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		compressor = "gzip"
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		compressor = "bzip2"
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0}):
+		compressor = "xz"
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		compressor = "zstd"
+	case bytes.HasPrefix(magic, []byte("0707")):
+		compressor = ""
+	}
+
+	switch compressor {
+	case "", "none":
+		return br, nil
+	case "gzip":
+		return gzip.NewReader(br)
+	case "bzip2":
+		return bzip2.NewReader(br), nil
+	case "xz":
+		return xz.NewReader(br)
+	case "zstd":
+		return zstd.NewReader(br)
+	case "lzma":
+		return lzmaReader(br)
+	}
+	return nil, fmt.Errorf("unsupported payload compressor %q", compressor)
+}
+
+// lzmaReader reads the legacy .lzma format: properties, dictionary size and
+// a 64-bit uncompressed size, which is all ones when unknown.
+func lzmaReader(r io.Reader) (io.Reader, error) {
+	var hdr [13]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("truncated lzma payload")
+	}
+	size := int64(binary.LittleEndian.Uint64(hdr[5:]))
+	return lzma.NewReaderFromHeader(r, hdr[:5], size)
+}
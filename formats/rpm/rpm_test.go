@@ -0,0 +1,184 @@
+package rpm
+
+import (
+	"bytes"
+	"futile/formats/cpio"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readPackage reads a package and the names and contents of its payload.
+func readPackage(data []byte) (*Package, map[string]string, error) {
+	r := bytes.NewReader(data)
+	p, err := Read(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload, err := p.Payload(r)
+	if err != nil {
+		return p, nil, err
+	}
+	members := make(map[string]string)
+	cr := cpio.NewReader(payload)
+	for {
+		h, err := cr.Next()
+		if err == io.EOF {
+			return p, members, nil
+		}
+		if err != nil {
+			return p, members, err
+		}
+		content, err := io.ReadAll(cr)
+		if err != nil {
+			return p, members, err
+		}
+		members[h.Name] = h.Linkname + string(content)
+	}
+}
+
+// The fixtures were written by testdata/gen.go, as there is no rpmbuild to
+// make them, and checked with bsdtar, which reads packages on its own. Each
+// uses a different payload compressor and header digest.
+func TestReadFixtures(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	wantFiles := []File{
+		{"/usr/share/hello", 0, fs.ModeDir | 0755, mtime, "root", "root", ""},
+		{"/usr/share/hello/greeting.txt", 6, 0644, mtime, "root", "root", ""},
+		{"/usr/share/hello/link", 12, fs.ModeSymlink | 0777, mtime, "root", "root", "greeting.txt"},
+	}
+	wantMembers := map[string]string{
+		"./usr/share/hello":              "",
+		"./usr/share/hello/greeting.txt": "hello\n",
+		"./usr/share/hello/link":         "greeting.txt",
+	}
+	digests := map[string]string{"gzip.rpm": "SHA256", "xz.rpm": "SHA1", "zstd.rpm": "", "none.rpm": "SHA256"}
+
+	for name, digest := range digests {
+		t.Run(name, func(t *testing.T) {
+			p, members, err := readPackage(readFixture(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Major != 3 || p.Source {
+				t.Errorf("got lead version %d.%d, source %v", p.Major, p.Minor, p.Source)
+			}
+			if got := p.Header.String(TagName) + "-" + p.Header.String(TagVersion) + "-" + p.Header.String(TagRelease); got != "hello-1.0-1" {
+				t.Errorf("got package %q", got)
+			}
+			if got, err := p.VerifyDigest(); err != nil || got != digest {
+				t.Errorf("VerifyDigest returned %q, %v, want %q", got, err, digest)
+			}
+			if got := p.Files(); !reflect.DeepEqual(got, wantFiles) {
+				t.Errorf("got files %+v, want %+v", got, wantFiles)
+			}
+			if !reflect.DeepEqual(members, wantMembers) {
+				t.Errorf("got payload %q, want %q", members, wantMembers)
+			}
+		})
+	}
+}
+
+func TestDigestMismatch(t *testing.T) {
+	for _, name := range []string{"gzip.rpm", "xz.rpm"} {
+		data := readFixture(t, name)
+		// The package name in the header's store
+		i := bytes.Index(data, []byte("hello\x001.0"))
+		data[i] = 'j'
+		p, err := Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.VerifyDigest(); err == nil {
+			t.Errorf("%s: changed header passed the digest check", name)
+		}
+	}
+}
+
+func TestFilesOutOfRange(t *testing.T) {
+	array := func(s string, n int) *Entry { return &Entry{Type: TypeStringArray, Count: n, data: []byte(s)} }
+	for _, index := range []string{"\xff\xff\xff\xff\xff\xff\xff\xff", "\x00\x00\x00\x00\x00\x00\x00\x01"} {
+		p := &Package{Header: &Header{Entries: map[int]*Entry{
+			TagBasenames:  array("a\x00", 1),
+			TagDirnames:   array("/dir/\x00", 1),
+			TagDirIndexes: {Type: TypeInt64, Count: 1, data: []byte(index)},
+		}}}
+		if got := p.Files(); len(got) != 1 || got[0].Name != "a" {
+			t.Errorf("directory index %x: got files %+v", index, got)
+		}
+	}
+}
+
+func TestInvalidPackages(t *testing.T) {
+	lead := string([]byte{0xed, 0xab, 0xee, 0xdb, 3, 0}) + string(make([]byte, 90))
+	magic := "\x8e\xad\xe8\x01\x00\x00\x00\x00"
+	tests := map[string]string{
+		"empty":              "",
+		"bad lead magic":     "\xed\xab\xee\xdc" + lead[4:],
+		"short lead":         lead[:50],
+		"old version":        lead[:4] + "\x02" + lead[5:],
+		"no signature":       lead,
+		"bad header magic":   lead + "\x8e\xad\xe8\x02" + magic[4:] + "\x00\x00\x00\x00\x00\x00\x00\x00",
+		"too many entries":   lead + magic + "\x00\x02\x00\x00\x00\x00\x00\x00",
+		"store too large":    lead + magic + "\x00\x00\x00\x00\x20\x00\x00\x00",
+		"entries cut short":  lead + magic + "\x00\x00\x00\x01\x00\x00\x00\x00",
+		"offset beyond data": lead + magic + "\x00\x00\x00\x01\x00\x00\x00\x00" + "\x00\x00\x03\xe8\x00\x00\x00\x06\x00\x00\x00\x10\x00\x00\x00\x01",
+		"no main header":     lead + magic + "\x00\x00\x00\x00\x00\x00\x00\x00",
+	}
+	for name, data := range tests {
+		if _, err := Read(bytes.NewReader([]byte(data))); err == nil {
+			t.Errorf("%s: package was accepted", name)
+		}
+	}
+
+	data := readFixture(t, "gzip.rpm")
+	i := bytes.Index(data, []byte("cpio\x00gzip"))
+	copy(data[i:], "tar\x00")
+	if _, _, err := readPackage(data); err == nil {
+		t.Error("tar payload was accepted")
+	}
+}
+
+// TestDamagedPackages checks that truncated and corrupt packages fail with
+// an error rather than a panic. Reading stops at the cpio trailer, so a cut
+// after it may still give the whole payload, but never part of it.
+func TestDamagedPackages(t *testing.T) {
+	for _, name := range []string{"gzip.rpm", "xz.rpm", "none.rpm"} {
+		data := readFixture(t, name)
+		_, want, err := readPackage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			_, got, err := readPackage(data[:n])
+			if err == nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("%s truncated to %d bytes: got %q", name, n, got)
+			}
+		}
+		for i := 0; i < len(data); i++ {
+			damaged := bytes.Clone(data)
+			damaged[i] ^= 0xff
+			p, _, _ := readPackage(damaged)
+			if p != nil {
+				p.Files()
+				_, _ = p.VerifyDigest()
+				for tag := range p.Header.Entries {
+					p.Header.Format(tag)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+//go:build ignore
+
+// This program writes the RPM fixtures of the rpm package tests. There is no
+// rpmbuild in the test environment, so the packages are laid out by hand:
+// a lead, a signature header holding the header digest, the main header and
+// a cpio payload. Run it from the package directory:
+//
+//	go run testdata/gen.go
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"futile/compress/xz"
+	"futile/compress/zstd"
+	"futile/formats/cpio"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	typeInt16       = 3
+	typeInt32       = 4
+	typeString      = 6
+	typeStringArray = 8
+)
+
+type entry struct {
+	tag, typ int
+	value    any // string, []string, []uint16 or []int32
+}
+
+// header encodes a header structure, aligning integer values in the store
+// as rpm does.
+func header(entries []entry) []byte {
+	var index, store bytes.Buffer
+	for _, e := range entries {
+		align := map[int]int{typeInt16: 2, typeInt32: 4}[e.typ]
+		for align > 0 && store.Len()%align != 0 {
+			store.WriteByte(0)
+		}
+		off, count := store.Len(), 1
+		switch v := e.value.(type) {
+		case string:
+			store.WriteString(v + "\x00")
+		case []string:
+			count = len(v)
+			for _, s := range v {
+				store.WriteString(s + "\x00")
+			}
+		case []uint16:
+			count = len(v)
+			binary.Write(&store, binary.BigEndian, v)
+		case []int32:
+			count = len(v)
+			binary.Write(&store, binary.BigEndian, v)
+		}
+		binary.Write(&index, binary.BigEndian, [4]uint32{uint32(e.tag), uint32(e.typ), uint32(off), uint32(count)})
+	}
+	var out bytes.Buffer
+	out.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&out, binary.BigEndian, [2]uint32{uint32(len(entries)), uint32(store.Len())})
+	out.Write(index.Bytes())
+	out.Write(store.Bytes())
+	return out.Bytes()
+}
+
+// payload returns the cpio archive of the package's files, as rpmbuild
+// stores it with "./" prefixed names.
+func payload() []byte {
+	var buf bytes.Buffer
+	w := cpio.NewWriter(&buf, cpio.FormatNewc)
+	mtime := time.Unix(1700000000, 0)
+	files := []*cpio.Header{
+		{Name: "./usr/share/hello", Mode: cpio.TypeDir | 0755},
+		{Name: "./usr/share/hello/greeting.txt", Mode: cpio.TypeReg | 0644, Size: 6},
+		{Name: "./usr/share/hello/link", Mode: cpio.TypeSymlink | 0777, Linkname: "greeting.txt"},
+	}
+	for i, h := range files {
+		h.Ino, h.Nlink, h.ModTime = int64(i+1), 1, mtime
+		if err := w.WriteHeader(h); err != nil {
+			log.Fatal(err)
+		}
+		if h.Size > 0 {
+			io.WriteString(w, "hello\n")
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func compress(name string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch name {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "xz":
+		w, err = xz.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	case "none":
+		return data
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// write stores a package whose payload uses compressor and whose signature
+// holds the given digest of the header.
+func write(name, compressor, digest string) {
+	hdr := header([]entry{
+		{1000, typeString, "hello"},
+		{1001, typeString, "1.0"},
+		{1002, typeString, "1"},
+		{1021, typeString, "linux"},
+		{1022, typeString, "noarch"},
+		{1028, typeInt32, []int32{0, 6, 12}},
+		{1030, typeInt16, []uint16{040755, 0100644, 0120777}},
+		{1034, typeInt32, []int32{1700000000, 1700000000, 1700000000}},
+		{1036, typeStringArray, []string{"", "", "greeting.txt"}},
+		{1039, typeStringArray, []string{"root", "root", "root"}},
+		{1040, typeStringArray, []string{"root", "root", "root"}},
+		{1116, typeInt32, []int32{0, 1, 1}},
+		{1117, typeStringArray, []string{"hello", "greeting.txt", "link"}},
+		{1118, typeStringArray, []string{"/usr/share/", "/usr/share/hello/"}},
+		{1124, typeString, "cpio"},
+		{1125, typeString, compressor},
+	})
+
+	var sigEntries []entry
+	switch digest {
+	case "sha1":
+		sum := sha1.Sum(hdr)
+		sigEntries = append(sigEntries, entry{269, typeString, hex.EncodeToString(sum[:])})
+	case "sha256":
+		sum := sha256.Sum256(hdr)
+		sigEntries = append(sigEntries, entry{273, typeString, hex.EncodeToString(sum[:])})
+	}
+	sig := header(sigEntries)
+
+	var out bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	copy(lead[10:], "hello-1.0-1")
+	lead[79] = 5 // signature type: header style
+	out.Write(lead)
+	out.Write(sig)
+	out.Write(make([]byte, (8-len(sig)%8)%8))
+	out.Write(hdr)
+	out.Write(compress(compressor, payload()))
+	if err := os.WriteFile(name, out.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	write("testdata/gzip.rpm", "gzip", "sha256")
+	write("testdata/xz.rpm", "xz", "sha1")
+	write("testdata/zstd.rpm", "zstd", "")
+	write("testdata/none.rpm", "none", "sha256")
+}
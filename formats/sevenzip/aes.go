@@ -0,0 +1,112 @@
+package sevenzip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// newAESReader decrypts a 7zAES stream: AES-256 in CBC mode with a key
+// stretched from the password by iterated SHA-256.
+func (a *Archive) newAESReader(r io.Reader, props []byte) (io.Reader, error) {
+	if a.password == "" {
+		return nil, ErrPasswordRequired
+	}
+	if len(props) < 1 {
+		return nil, fmt.Errorf("7z: missing AES properties")
+	}
+	cycles := int(props[0] & 0x3F)
+	var salt, iv []byte
+	if props[0]&0xC0 != 0 {
+		if len(props) < 2 {
+			return nil, fmt.Errorf("7z: invalid AES properties")
+		}
+		saltSize := int(props[0]>>7&1) + int(props[1]>>4)
+		ivSize := int(props[0]>>6&1) + int(props[1]&0x0F)
+		if len(props) < 2+saltSize+ivSize {
+			return nil, fmt.Errorf("7z: invalid AES properties")
+		}
+		salt = props[2 : 2+saltSize]
+		iv = props[2+saltSize : 2+saltSize+ivSize]
+	}
+	if cycles > 24 && cycles != 0x3F {
+		return nil, fmt.Errorf("7z: AES key derivation cost 2^%d is too high", cycles)
+	}
+
+	cacheKey := fmt.Sprintf("%d:%x", cycles, salt)
+	key, ok := a.keys[cacheKey]
+	if !ok {
+		key = deriveKey(a.password, salt, cycles)
+		a.keys[cacheKey] = key
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var fullIV [aes.BlockSize]byte
+	copy(fullIV[:], iv)
+	return &cbcReader{r: r, mode: cipher.NewCBCDecrypter(block, fullIV[:])}, nil
+}
+
+// deriveKey hashes the salt, the UTF-16LE password and a counter 2^cycles
+// times. The cost 0x3F instead uses the salt and password directly.
+func deriveKey(password string, salt []byte, cycles int) []byte {
+	units := utf16.Encode([]rune(password))
+	pw := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(pw[2*i:], u)
+	}
+	if cycles == 0x3F {
+		key := make([]byte, 32)
+		n := copy(key, salt)
+		copy(key[n:], pw)
+		return key
+	}
+
+	h := sha256.New()
+	round := make([]byte, len(salt)+len(pw)+8)
+	copy(round, salt)
+	copy(round[len(salt):], pw)
+	counter := round[len(salt)+len(pw):]
+	for i := uint64(0); i < 1<<cycles; i++ {
+		binary.LittleEndian.PutUint64(counter, i)
+		h.Write(round)
+	}
+	return h.Sum(nil)
+}
+
+// cbcReader decrypts whole blocks as they are read.
+type cbcReader struct {
+	r    io.Reader
+	mode cipher.BlockMode
+	buf  []byte
+	out  []byte
+	err  error
+}
+
+func (c *cbcReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.buf == nil {
+			c.buf = make([]byte, 32*aes.BlockSize)
+		}
+		n, err := io.ReadFull(c.r, c.buf)
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			err = io.EOF
+		}
+		if n%aes.BlockSize != 0 {
+			return 0, fmt.Errorf("7z: encrypted data is not a whole number of blocks")
+		}
+		c.mode.CryptBlocks(c.buf[:n], c.buf[:n])
+		c.out, c.err = c.buf[:n], err
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
@@ -0,0 +1,244 @@
+package sevenzip
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"futile/compress/bcj"
+	"futile/compress/lzma"
+	"io"
+)
+
+// Coder method IDs.
+const (
+	methodCopy    = "\x00"
+	methodDelta   = "\x03"
+	methodLZMA    = "\x03\x01\x01"
+	methodBCJ     = "\x03\x03\x01\x03"
+	methodBCJ2    = "\x03\x03\x01\x1b"
+	methodPPC     = "\x03\x03\x02\x05"
+	methodIA64    = "\x03\x03\x04\x01"
+	methodARM     = "\x03\x03\x05\x01"
+	methodARMT    = "\x03\x03\x07\x01"
+	methodSPARC   = "\x03\x03\x08\x05"
+	methodARM64   = "\x0a"
+	methodRISCV   = "\x0b"
+	methodPPMd    = "\x03\x04\x01"
+	methodDeflate = "\x04\x01\x08"
+	methodBZip2   = "\x04\x02\x02"
+	methodAES     = "\x06\xf1\x07\x01"
+	methodLZMA2   = "\x21"
+)
+
+// methodName describes a coder method for error messages.
+func methodName(id string) string {
+	switch id {
+	case methodCopy:
+		return "Copy"
+	case methodDelta:
+		return "Delta"
+	case methodLZMA:
+		return "LZMA"
+	case methodBCJ:
+		return "BCJ"
+	case methodBCJ2:
+		return "BCJ2"
+	case methodPPC:
+		return "PPC"
+	case methodIA64:
+		return "IA64"
+	case methodARM:
+		return "ARM"
+	case methodARMT:
+		return "ARMT"
+	case methodSPARC:
+		return "SPARC"
+	case methodARM64:
+		return "ARM64"
+	case methodRISCV:
+		return "RISCV"
+	case methodPPMd:
+		return "PPMd"
+	case methodDeflate:
+		return "Deflate"
+	case methodBZip2:
+		return "BZip2"
+	case methodAES:
+		return "7zAES"
+	case methodLZMA2:
+		return "LZMA2"
+	}
+	return fmt.Sprintf("%x", id)
+}
+
+// unpackSize is the size of the folder's final output.
+func (f *folder) unpackSize() uint64 {
+	for i := len(f.unpackSizes) - 1; i >= 0; i-- {
+		if f.bindPairForOut(i) < 0 {
+			return f.unpackSizes[i]
+		}
+	}
+	return 0
+}
+
+func (f *folder) bindPairForIn(in int) int {
+	for i, bp := range f.bindPairs {
+		if bp.in == in {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *folder) bindPairForOut(out int) int {
+	for i, bp := range f.bindPairs {
+		if bp.out == out {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *folder) encrypted() bool {
+	for _, c := range f.coders {
+		if string(c.method) == methodAES {
+			return true
+		}
+	}
+	return false
+}
+
+// folderReader returns the decoded output of a folder.
+func (a *Archive) folderReader(si *streamsInfo, index int) (io.Reader, error) {
+	f := si.folders[index]
+	if f.firstPack+len(f.packed) > len(si.packSizes) {
+		return nil, errCorruptHeader
+	}
+	offset := int64(signatureHeaderSize + si.packPos)
+	for _, size := range si.packSizes[:f.firstPack] {
+		offset += int64(size)
+	}
+	packs := make([]io.Reader, len(f.packed))
+	for i := range packs {
+		size := int64(si.packSizes[f.firstPack+i])
+		packs[i] = bufio.NewReader(io.NewSectionReader(a.r, offset, size))
+		offset += size
+	}
+
+	for out := range f.unpackSizes {
+		if f.bindPairForOut(out) < 0 {
+			return a.coderReader(f, out, packs, make(map[int]bool))
+		}
+	}
+	return nil, errCorruptHeader
+}
+
+// coderReader builds the reader of a coder's output, wiring its inputs to
+// pack streams or to the outputs of other coders. Every coder has a single
+// output, so output indexes are coder indexes.
+func (a *Archive) coderReader(f *folder, index int, packs []io.Reader, seen map[int]bool) (io.Reader, error) {
+	if index >= len(f.coders) || seen[index] {
+		return nil, errCorruptHeader
+	}
+	seen[index] = true
+	c := f.coders[index]
+
+	firstIn := 0
+	for _, other := range f.coders[:index] {
+		firstIn += other.numIn
+	}
+	inputs := make([]io.Reader, c.numIn)
+	for i := range inputs {
+		in := firstIn + i
+		if bp := f.bindPairForIn(in); bp >= 0 {
+			r, err := a.coderReader(f, f.bindPairs[bp].out, packs, seen)
+			if err != nil {
+				return nil, err
+			}
+			inputs[i] = r
+			continue
+		}
+		for p, packed := range f.packed {
+			if packed == in {
+				inputs[i] = packs[p]
+			}
+		}
+		if inputs[i] == nil {
+			return nil, errCorruptHeader
+		}
+	}
+
+	size := f.unpackSizes[index]
+	r, err := a.newDecoder(c, inputs, size)
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(r, int64(size)), nil
+}
+
+// newDecoder returns the decoder of one coder method.
+func (a *Archive) newDecoder(c coder, inputs []io.Reader, size uint64) (io.Reader, error) {
+	method := string(c.method)
+	if method != methodBCJ2 && len(inputs) != 1 {
+		return nil, fmt.Errorf("7z: %s coder with %d inputs", methodName(method), len(inputs))
+	}
+	switch method {
+	case methodCopy:
+		return inputs[0], nil
+	case methodLZMA:
+		return lzma.NewReaderFromHeader(inputs[0], c.properties, int64(size))
+	case methodLZMA2:
+		if len(c.properties) < 1 {
+			return nil, fmt.Errorf("7z: missing LZMA2 properties")
+		}
+		dictSize, err := lzma.DictSizeFromByte(c.properties[0])
+		if err != nil {
+			return nil, err
+		}
+		// No need for a window larger than the output
+		return lzma.NewReader2(inputs[0], uint32(min(uint64(dictSize), max(size, 1)))), nil
+	case methodDeflate:
+		return flate.NewReader(inputs[0]), nil
+	case methodBZip2:
+		return bzip2.NewReader(inputs[0]), nil
+	case methodBCJ:
+		return bcj.NewReader(inputs[0], bcj.X86, 0), nil
+	case methodPPC:
+		return bcj.NewReader(inputs[0], bcj.PowerPC, 0), nil
+	case methodIA64:
+		return bcj.NewReader(inputs[0], bcj.IA64, 0), nil
+	case methodARM:
+		return bcj.NewReader(inputs[0], bcj.ARM, 0), nil
+	case methodARMT:
+		return bcj.NewReader(inputs[0], bcj.ARMThumb, 0), nil
+	case methodSPARC:
+		return bcj.NewReader(inputs[0], bcj.SPARC, 0), nil
+	case methodARM64, methodRISCV:
+		arch := bcj.ARM64
+		if method == methodRISCV {
+			arch = bcj.RISCV
+		}
+		// The properties optionally hold the position conversion started at
+		var start uint32
+		if len(c.properties) == 4 {
+			start = binary.LittleEndian.Uint32(c.properties)
+		}
+		return bcj.NewReader(inputs[0], arch, start), nil
+	case methodBCJ2:
+		if len(inputs) != 4 {
+			return nil, fmt.Errorf("7z: BCJ2 coder with %d inputs", len(inputs))
+		}
+		return newBCJ2Reader(inputs, int64(size))
+	case methodDelta:
+		distance := 1
+		if len(c.properties) > 0 {
+			distance = int(c.properties[0]) + 1
+		}
+		return newDeltaReader(inputs[0], distance), nil
+	case methodAES:
+		return a.newAESReader(inputs[0], c.properties)
+	}
+	return nil, fmt.Errorf("7z: %s compression is not supported", methodName(method))
+}
@@ -0,0 +1,163 @@
+package sevenzip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errCorruptBCJ2 = errors.New("7z: corrupt BCJ2 data")
+
+// bcj2Reader reverses the BCJ2 filter, which moves CALL and JMP targets
+// into separate streams and marks converted instructions with a range coder.
+type bcj2Reader struct {
+	main, call, jump *bufio.Reader
+	rc               *bufio.Reader
+
+	rng, code uint32
+	probs     [2 + 256]uint16
+
+	size     int64 // output still expected
+	outPos   uint32
+	prevByte byte
+	pending  []byte // decoded bytes not yet read
+	buf      [4]byte
+}
+
+func newBCJ2Reader(inputs []io.Reader, size int64) (*bcj2Reader, error) {
+	b := &bcj2Reader{
+		main: bufio.NewReader(inputs[0]),
+		call: bufio.NewReader(inputs[1]),
+		jump: bufio.NewReader(inputs[2]),
+		rc:   bufio.NewReader(inputs[3]),
+		rng:  0xFFFFFFFF,
+		size: size,
+	}
+	for i := range b.probs {
+		b.probs[i] = 1024
+	}
+	for i := 0; i < 5; i++ {
+		c, err := b.rc.ReadByte()
+		if err != nil {
+			return nil, errCorruptBCJ2
+		}
+		b.code = b.code<<8 | uint32(c)
+	}
+	return b, nil
+}
+
+func isJump(b0, b1 byte) bool {
+	return b1&0xFE == 0xE8 || (b0 == 0x0F && b1&0xF0 == 0x80)
+}
+
+// decodeBit decodes one flag with the adaptive probability p.
+func (b *bcj2Reader) decodeBit(p *uint16) (bool, error) {
+	bound := (b.rng >> 11) * uint32(*p)
+	var bit bool
+	if b.code < bound {
+		b.rng = bound
+		*p += (2048 - *p) >> 5
+	} else {
+		b.rng -= bound
+		b.code -= bound
+		*p -= *p >> 5
+		bit = true
+	}
+	if b.rng < 1<<24 {
+		c, err := b.rc.ReadByte()
+		if err != nil {
+			return false, errCorruptBCJ2
+		}
+		b.rng <<= 8
+		b.code = b.code<<8 | uint32(c)
+	}
+	return bit, nil
+}
+
+func (b *bcj2Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(b.pending) > 0 {
+			c := copy(p[n:], b.pending)
+			b.pending = b.pending[c:]
+			n += c
+			continue
+		}
+		if b.size <= 0 {
+			break
+		}
+		c, err := b.main.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = errCorruptBCJ2
+			}
+			return n, err
+		}
+		p[n] = c
+		n++
+		b.size--
+		b.outPos++
+		if !isJump(b.prevByte, c) || b.size == 0 {
+			b.prevByte = c
+			continue
+		}
+
+		prob := &b.probs[257]
+		if c == 0xE8 {
+			prob = &b.probs[b.prevByte]
+		} else if c == 0xE9 {
+			prob = &b.probs[256]
+		}
+		converted, err := b.decodeBit(prob)
+		if err != nil {
+			return n, err
+		}
+		if !converted {
+			b.prevByte = c
+			continue
+		}
+		src := b.jump
+		if c == 0xE8 {
+			src = b.call
+		}
+		if _, err := io.ReadFull(src, b.buf[:]); err != nil {
+			return n, errCorruptBCJ2
+		}
+		dest := binary.BigEndian.Uint32(b.buf[:]) - (b.outPos + 4)
+		var out [4]byte
+		binary.LittleEndian.PutUint32(out[:], dest)
+		k := int(min(b.size, 4))
+		b.pending = append(b.pending[:0], out[:k]...)
+		b.size -= int64(k)
+		b.outPos += uint32(k)
+		b.prevByte = byte(dest >> 24)
+	}
+	if n == 0 && b.size <= 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// deltaReader reverses the delta filter, which stores each byte as the
+// difference from the byte distance positions earlier.
+type deltaReader struct {
+	r        io.Reader
+	history  [256]byte
+	distance int
+	pos      int
+}
+
+func newDeltaReader(r io.Reader, distance int) *deltaReader {
+	return &deltaReader{r: r, distance: distance}
+}
+
+func (d *deltaReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] += d.history[(d.pos-d.distance)&0xFF]
+		d.history[d.pos&0xFF] = p[i]
+		d.pos++
+	}
+	return n, err
+}
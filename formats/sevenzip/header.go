@@ -0,0 +1,724 @@
+package sevenzip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// Property IDs of the header.
+const (
+	idEnd                   = 0x00
+	idHeader                = 0x01
+	idArchiveProperties     = 0x02
+	idAdditionalStreamsInfo = 0x03
+	idMainStreamsInfo       = 0x04
+	idFilesInfo             = 0x05
+	idPackInfo              = 0x06
+	idUnpackInfo            = 0x07
+	idSubStreamsInfo        = 0x08
+	idSize                  = 0x09
+	idCRC                   = 0x0A
+	idFolder                = 0x0B
+	idCodersUnpackSize      = 0x0C
+	idNumUnpackStream       = 0x0D
+	idEmptyStream           = 0x0E
+	idEmptyFile             = 0x0F
+	idAnti                  = 0x10
+	idName                  = 0x11
+	idCTime                 = 0x12
+	idATime                 = 0x13
+	idMTime                 = 0x14
+	idWinAttributes         = 0x15
+	idComment               = 0x16
+	idEncodedHeader         = 0x17
+	idStartPos              = 0x18
+	idDummy                 = 0x19
+)
+
+// maxEntries bounds counts read from the header, guarding against
+// allocations driven by corrupt archives.
+const maxEntries = 1 << 24
+
+var errCorruptHeader = errors.New("7z: corrupt header")
+
+// headerReader decodes the primitive types of the header.
+type headerReader struct {
+	r *bufio.Reader
+}
+
+func (h *headerReader) byte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == io.EOF {
+		err = errCorruptHeader
+	}
+	return b, err
+}
+
+// number reads a 7z variable length integer: the leading one bits of the
+// first byte count the little endian bytes that follow.
+func (h *headerReader) number() (uint64, error) {
+	first, err := h.byte()
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	mask := byte(0x80)
+	for i := 0; i < 8; i++ {
+		if first&mask == 0 {
+			high := uint64(first) & (uint64(mask) - 1)
+			return value | high<<(8*i), nil
+		}
+		b, err := h.byte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b) << (8 * i)
+		mask >>= 1
+	}
+	return value, nil
+}
+
+// count reads a number used to size a table.
+func (h *headerReader) count() (int, error) {
+	n, err := h.number()
+	if err != nil {
+		return 0, err
+	}
+	if n > maxEntries {
+		return 0, errCorruptHeader
+	}
+	return int(n), nil
+}
+
+func (h *headerReader) uint32() (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(h.r, b[:]); err != nil {
+		return 0, errCorruptHeader
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func (h *headerReader) uint64() (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(h.r, b[:]); err != nil {
+		return 0, errCorruptHeader
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func (h *headerReader) bytes(n uint64) ([]byte, error) {
+	if n > maxEntries {
+		return nil, errCorruptHeader
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(h.r, b); err != nil {
+		return nil, errCorruptHeader
+	}
+	return b, nil
+}
+
+// bits reads a bit vector, most significant bit first.
+func (h *headerReader) bits(n int) ([]bool, error) {
+	v := make([]bool, n)
+	var b byte
+	for i := 0; i < n; i++ {
+		if i%8 == 0 {
+			var err error
+			if b, err = h.byte(); err != nil {
+				return nil, err
+			}
+		}
+		v[i] = b&(0x80>>(i%8)) != 0
+	}
+	return v, nil
+}
+
+// definedBits reads a vector that may be replaced by an all-defined flag.
+func (h *headerReader) definedBits(n int) ([]bool, error) {
+	all, err := h.byte()
+	if err != nil {
+		return nil, err
+	}
+	if all == 0 {
+		return h.bits(n)
+	}
+	v := make([]bool, n)
+	for i := range v {
+		v[i] = true
+	}
+	return v, nil
+}
+
+// digests reads optional CRC32 values.
+func (h *headerReader) digests(n int) ([]bool, []uint32, error) {
+	defined, err := h.definedBits(n)
+	if err != nil {
+		return nil, nil, err
+	}
+	crcs := make([]uint32, n)
+	for i := range crcs {
+		if defined[i] {
+			if crcs[i], err = h.uint32(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return defined, crcs, nil
+}
+
+// expect consumes a property ID that must be present.
+func (h *headerReader) expect(id byte) error {
+	b, err := h.byte()
+	if err != nil {
+		return err
+	}
+	if b != id {
+		return fmt.Errorf("7z: unexpected property %#x, expected %#x", b, id)
+	}
+	return nil
+}
+
+// skipProperty skips the data of a property that is not used.
+func (h *headerReader) skipProperty() error {
+	n, err := h.number()
+	if err != nil {
+		return err
+	}
+	_, err = h.r.Discard(int(min(n, maxEntries)))
+	return err
+}
+
+// skipArchiveProperties skips the archive properties, which nothing uses.
+func (h *headerReader) skipArchiveProperties() error {
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return err
+		}
+		if id == idEnd {
+			return nil
+		}
+		if err := h.skipProperty(); err != nil {
+			return err
+		}
+	}
+}
+
+// coder is one decoding step of a folder.
+type coder struct {
+	method     []byte
+	numIn      int
+	numOut     int
+	properties []byte
+}
+
+type bindPair struct {
+	in, out int
+}
+
+// folder is a graph of coders producing one stream, which solid archives
+// split into several files.
+type folder struct {
+	coders      []coder
+	bindPairs   []bindPair
+	packed      []int    // coder input streams fed by pack streams
+	unpackSizes []uint64 // one per coder output stream
+	hasCRC      bool
+	crc         uint32
+
+	firstPack int // index of the folder's first pack stream
+}
+
+// streamsInfo describes pack streams, folders and the files within them.
+type streamsInfo struct {
+	packPos   uint64
+	packSizes []uint64
+	folders   []*folder
+
+	// Substreams, in folder order
+	numStreams []int // per folder
+	sizes      []uint64
+	hasCRC     []bool
+	crcs       []uint32
+}
+
+func (h *headerReader) streamsInfo() (*streamsInfo, error) {
+	si := &streamsInfo{}
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case idEnd:
+			if si.numStreams == nil {
+				// Without substream info every folder holds one file
+				for _, f := range si.folders {
+					si.numStreams = append(si.numStreams, 1)
+					si.sizes = append(si.sizes, f.unpackSize())
+					si.hasCRC = append(si.hasCRC, f.hasCRC)
+					si.crcs = append(si.crcs, f.crc)
+				}
+			}
+			return si, nil
+		case idPackInfo:
+			if err := h.packInfo(si); err != nil {
+				return nil, err
+			}
+		case idUnpackInfo:
+			if err := h.unpackInfo(si); err != nil {
+				return nil, err
+			}
+		case idSubStreamsInfo:
+			if err := h.subStreamsInfo(si); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("7z: unexpected property %#x in streams info", id)
+		}
+	}
+}
+
+func (h *headerReader) packInfo(si *streamsInfo) error {
+	var err error
+	if si.packPos, err = h.number(); err != nil {
+		return err
+	}
+	n, err := h.count()
+	if err != nil {
+		return err
+	}
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case idEnd:
+			if len(si.packSizes) != n {
+				return errCorruptHeader
+			}
+			return nil
+		case idSize:
+			si.packSizes = make([]uint64, n)
+			for i := range si.packSizes {
+				if si.packSizes[i], err = h.number(); err != nil {
+					return err
+				}
+			}
+		case idCRC:
+			if _, _, err := h.digests(n); err != nil {
+				return err
+			}
+		default:
+			if err := h.skipProperty(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (h *headerReader) unpackInfo(si *streamsInfo) error {
+	if err := h.expect(idFolder); err != nil {
+		return err
+	}
+	n, err := h.count()
+	if err != nil {
+		return err
+	}
+	if external, err := h.byte(); err != nil || external != 0 {
+		return errCorruptHeader
+	}
+	pack := 0
+	for i := 0; i < n; i++ {
+		f, err := h.folder()
+		if err != nil {
+			return err
+		}
+		f.firstPack = pack
+		pack += len(f.packed)
+		si.folders = append(si.folders, f)
+	}
+
+	if err := h.expect(idCodersUnpackSize); err != nil {
+		return err
+	}
+	for _, f := range si.folders {
+		outs := 0
+		for _, c := range f.coders {
+			outs += c.numOut
+		}
+		f.unpackSizes = make([]uint64, outs)
+		for i := range f.unpackSizes {
+			if f.unpackSizes[i], err = h.number(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case idEnd:
+			return nil
+		case idCRC:
+			defined, crcs, err := h.digests(n)
+			if err != nil {
+				return err
+			}
+			for i, f := range si.folders {
+				f.hasCRC, f.crc = defined[i], crcs[i]
+			}
+		default:
+			if err := h.skipProperty(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (h *headerReader) folder() (*folder, error) {
+	n, err := h.count()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 || n > 64 {
+		return nil, errCorruptHeader
+	}
+	f := &folder{}
+	totalIn, totalOut := 0, 0
+	for i := 0; i < n; i++ {
+		flags, err := h.byte()
+		if err != nil {
+			return nil, err
+		}
+		if flags&0x80 != 0 {
+			return nil, fmt.Errorf("7z: alternative coder methods are not supported")
+		}
+		c := coder{numIn: 1, numOut: 1}
+		if c.method, err = h.bytes(uint64(flags & 0x0F)); err != nil {
+			return nil, err
+		}
+		if flags&0x10 != 0 {
+			if c.numIn, err = h.count(); err != nil {
+				return nil, err
+			}
+			if c.numOut, err = h.count(); err != nil {
+				return nil, err
+			}
+			if c.numIn > 64 || c.numOut != 1 {
+				return nil, fmt.Errorf("7z: coders with %d outputs are not supported", c.numOut)
+			}
+		}
+		if flags&0x20 != 0 {
+			size, err := h.number()
+			if err != nil {
+				return nil, err
+			}
+			if c.properties, err = h.bytes(size); err != nil {
+				return nil, err
+			}
+		}
+		totalIn += c.numIn
+		totalOut += c.numOut
+		f.coders = append(f.coders, c)
+	}
+
+	for i := 0; i < totalOut-1; i++ {
+		in, err := h.count()
+		if err != nil {
+			return nil, err
+		}
+		out, err := h.count()
+		if err != nil {
+			return nil, err
+		}
+		if in >= totalIn || out >= totalOut {
+			return nil, errCorruptHeader
+		}
+		f.bindPairs = append(f.bindPairs, bindPair{in, out})
+	}
+
+	numPacked := totalIn - len(f.bindPairs)
+	if numPacked < 1 {
+		return nil, errCorruptHeader
+	}
+	if numPacked == 1 {
+		for i := 0; i < totalIn; i++ {
+			if f.bindPairForIn(i) < 0 {
+				f.packed = append(f.packed, i)
+				break
+			}
+		}
+	} else {
+		for i := 0; i < numPacked; i++ {
+			in, err := h.count()
+			if err != nil {
+				return nil, err
+			}
+			if in >= totalIn {
+				return nil, errCorruptHeader
+			}
+			f.packed = append(f.packed, in)
+		}
+	}
+	if len(f.packed) != numPacked {
+		return nil, errCorruptHeader
+	}
+	return f, nil
+}
+
+func (h *headerReader) subStreamsInfo(si *streamsInfo) error {
+	// A repeated property replaces the streams of the first
+	si.sizes, si.hasCRC, si.crcs = nil, nil, nil
+	si.numStreams = make([]int, len(si.folders))
+	for i := range si.numStreams {
+		si.numStreams[i] = 1
+	}
+	id, err := h.byte()
+	if err != nil {
+		return err
+	}
+	if id == idNumUnpackStream {
+		total := 0
+		for i := range si.numStreams {
+			if si.numStreams[i], err = h.count(); err != nil {
+				return err
+			}
+			// Empty streams take no header bytes, so bound them all
+			if total += si.numStreams[i]; total > maxEntries {
+				return errCorruptHeader
+			}
+		}
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+
+	// Sizes are stored for all but the last file of a folder, which takes
+	// the rest
+	hasSizes := id == idSize
+	for i, f := range si.folders {
+		n := si.numStreams[i]
+		if n == 0 {
+			continue
+		}
+		var sum uint64
+		for j := 0; j < n-1; j++ {
+			size := uint64(0)
+			if hasSizes {
+				if size, err = h.number(); err != nil {
+					return err
+				}
+			}
+			sum += size
+			si.sizes = append(si.sizes, size)
+		}
+		total := f.unpackSize()
+		if sum > total {
+			return errCorruptHeader
+		}
+		si.sizes = append(si.sizes, total-sum)
+	}
+	if hasSizes {
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+
+	// Digests are given for streams whose folder CRC does not cover them
+	var unknown int
+	for i, f := range si.folders {
+		if si.numStreams[i] != 1 || !f.hasCRC {
+			unknown += si.numStreams[i]
+		}
+	}
+	for id != idEnd {
+		if id == idCRC {
+			defined, crcs, err := h.digests(unknown)
+			if err != nil {
+				return err
+			}
+			si.hasCRC, si.crcs = nil, nil
+			k := 0
+			for i, f := range si.folders {
+				if si.numStreams[i] == 1 && f.hasCRC {
+					si.hasCRC = append(si.hasCRC, true)
+					si.crcs = append(si.crcs, f.crc)
+					continue
+				}
+				for j := 0; j < si.numStreams[i]; j++ {
+					si.hasCRC = append(si.hasCRC, defined[k])
+					si.crcs = append(si.crcs, crcs[k])
+					k++
+				}
+			}
+		} else if err := h.skipProperty(); err != nil {
+			return err
+		}
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+	if si.hasCRC == nil {
+		for i, f := range si.folders {
+			for j := 0; j < si.numStreams[i]; j++ {
+				if si.numStreams[i] == 1 && f.hasCRC {
+					si.hasCRC = append(si.hasCRC, true)
+					si.crcs = append(si.crcs, f.crc)
+				} else {
+					si.hasCRC = append(si.hasCRC, false)
+					si.crcs = append(si.crcs, 0)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fileEntry holds the per-file properties of the header.
+type fileEntry struct {
+	name       string
+	hasStream  bool
+	isDir      bool
+	isAnti     bool
+	mtime      uint64
+	hasMTime   bool
+	attributes uint32
+	hasAttrib  bool
+}
+
+func (h *headerReader) filesInfo() ([]fileEntry, error) {
+	n, err := h.count()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]fileEntry, n)
+	for i := range files {
+		files[i].hasStream = true
+	}
+	var emptyStreams int
+	var emptyFile, anti []bool
+
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return nil, err
+		}
+		if id == idEnd {
+			break
+		}
+		size, err := h.number()
+		if err != nil {
+			return nil, err
+		}
+		data, err := h.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+		p := &headerReader{r: bufio.NewReader(bytes.NewReader(data))}
+
+		switch id {
+		case idEmptyStream:
+			empty, err := p.bits(n)
+			if err != nil {
+				return nil, err
+			}
+			emptyStreams = 0
+			for i, e := range empty {
+				files[i].hasStream = !e
+				if e {
+					emptyStreams++
+				}
+			}
+			emptyFile = make([]bool, emptyStreams)
+			anti = make([]bool, emptyStreams)
+		case idEmptyFile:
+			if emptyFile, err = p.bits(emptyStreams); err != nil {
+				return nil, err
+			}
+		case idAnti:
+			if anti, err = p.bits(emptyStreams); err != nil {
+				return nil, err
+			}
+		case idName:
+			if err := p.names(files); err != nil {
+				return nil, err
+			}
+		case idMTime:
+			defined, err := p.definedBits(n)
+			if err != nil {
+				return nil, err
+			}
+			if external, err := p.byte(); err != nil || external != 0 {
+				return nil, errCorruptHeader
+			}
+			for i := range files {
+				if defined[i] {
+					if files[i].mtime, err = p.uint64(); err != nil {
+						return nil, err
+					}
+					files[i].hasMTime = true
+				}
+			}
+		case idWinAttributes:
+			defined, err := p.definedBits(n)
+			if err != nil {
+				return nil, err
+			}
+			if external, err := p.byte(); err != nil || external != 0 {
+				return nil, errCorruptHeader
+			}
+			for i := range files {
+				if defined[i] {
+					if files[i].attributes, err = p.uint32(); err != nil {
+						return nil, err
+					}
+					files[i].hasAttrib = true
+				}
+			}
+		}
+	}
+
+	k := 0
+	for i := range files {
+		if files[i].hasStream {
+			continue
+		}
+		files[i].isDir = k < len(emptyFile) && !emptyFile[k]
+		files[i].isAnti = k < len(anti) && anti[k]
+		k++
+	}
+	return files, nil
+}
+
+// names reads the UTF-16LE, NUL terminated file names.
+func (h *headerReader) names(files []fileEntry) error {
+	if external, err := h.byte(); err != nil || external != 0 {
+		return errCorruptHeader
+	}
+	for i := range files {
+		var units []uint16
+		for {
+			var b [2]byte
+			if _, err := io.ReadFull(h.r, b[:]); err != nil {
+				return errCorruptHeader
+			}
+			u := binary.LittleEndian.Uint16(b[:])
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		files[i].name = string(utf16.Decode(units))
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+// Package sevenzip reads 7z archives.
+package sevenzip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+var signature = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+
+// signatureHeaderSize is the length of the fixed header; pack positions
+// are relative to its end.
+const signatureHeaderSize = 32
+
+// Windows file attributes.
+const (
+	attrReadOnly  = 0x01
+	attrDirectory = 0x10
+	// attrUnixExtension marks attributes whose high 16 bits hold st_mode.
+	attrUnixExtension = 0x8000
+)
+
+var (
+	// ErrNotSevenZip is returned for files without the 7z signature.
+	ErrNotSevenZip = errors.New("not a 7z archive")
+	// ErrPasswordRequired is returned when encrypted content is read
+	// without a password.
+	ErrPasswordRequired = errors.New("7z: archive is encrypted; a password is required")
+	// ErrWrongPassword is returned when encrypted content does not decrypt.
+	ErrWrongPassword = errors.New("7z: wrong password or corrupt data")
+)
+
+// File is a file, directory or symbolic link in an archive. The content of
+// a symbolic link is its target.
+type File struct {
+	Name    string // slash separated
+	Size    int64
+	ModTime time.Time
+	Mode    fs.FileMode
+
+	// Anti items mark files deleted by an update archive
+	Anti bool
+
+	folder int // -1 for files without content
+	hasCRC bool
+	crc    uint32
+}
+
+// Archive is a 7z archive opened for reading.
+type Archive struct {
+	Files []*File
+
+	r        io.ReaderAt
+	password string
+	keys     map[string][]byte // derived AES keys by salt and cost
+	main     *streamsInfo
+}
+
+// Size returns the size of the archive in r according to its start header,
+// which is more than the size of r when r is truncated or a volume is missing.
+func Size(r io.ReaderAt) (int64, error) {
+	var start [signatureHeaderSize]byte
+	if _, err := r.ReadAt(start[:], 0); err != nil {
+		if err == io.EOF {
+			return 0, ErrNotSevenZip
+		}
+		return 0, err
+	}
+	if !bytes.Equal(start[:6], signature) {
+		return 0, ErrNotSevenZip
+	}
+	offset := binary.LittleEndian.Uint64(start[12:])
+	size := binary.LittleEndian.Uint64(start[20:])
+	if offset > 1<<62 || size > 1<<62 {
+		return 0, errCorruptHeader
+	}
+	return signatureHeaderSize + int64(offset) + int64(size), nil
+}
+
+// Open reads the header of a 7z archive. The password is needed for
+// archives with encrypted headers, and when encrypted content is read.
+func Open(r io.ReaderAt, password string) (*Archive, error) {
+	var start [signatureHeaderSize]byte
+	if _, err := r.ReadAt(start[:], 0); err != nil {
+		if err == io.EOF {
+			return nil, ErrNotSevenZip
+		}
+		return nil, err
+	}
+	if !bytes.Equal(start[:6], signature) {
+		return nil, ErrNotSevenZip
+	}
+	if start[6] != 0 {
+		return nil, fmt.Errorf("7z: unsupported format version %d.%d", start[6], start[7])
+	}
+	if crc32.ChecksumIEEE(start[12:]) != binary.LittleEndian.Uint32(start[8:]) {
+		return nil, fmt.Errorf("7z: start header checksum mismatch")
+	}
+	offset := binary.LittleEndian.Uint64(start[12:])
+	size := binary.LittleEndian.Uint64(start[20:])
+	sum := binary.LittleEndian.Uint32(start[28:])
+
+	a := &Archive{r: r, password: password, keys: make(map[string][]byte)}
+	if size == 0 {
+		return a, nil
+	}
+	if size > maxEntries*16 || offset > 1<<62 {
+		return nil, errCorruptHeader
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, signatureHeaderSize+int64(offset)); err != nil {
+		return nil, fmt.Errorf("7z: failed to read header: %w", err)
+	}
+	if crc32.ChecksumIEEE(buf) != sum {
+		return nil, fmt.Errorf("7z: header checksum mismatch")
+	}
+
+	// Headers may be packed, and even encrypted, like file content
+	for {
+		h := &headerReader{r: bufio.NewReader(bytes.NewReader(buf))}
+		id, err := h.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case idHeader:
+			if err := a.readHeader(h); err != nil {
+				return nil, err
+			}
+			return a, nil
+		case idEncodedHeader:
+			si, err := h.streamsInfo()
+			if err != nil {
+				return nil, err
+			}
+			if buf, err = a.decodeAll(si); err != nil {
+				return nil, fmt.Errorf("7z: failed to decode header: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("7z: unexpected property %#x at start of header", id)
+		}
+	}
+}
+
+// decodeAll decodes every folder of a streams info, as used by packed headers.
+func (a *Archive) decodeAll(si *streamsInfo) ([]byte, error) {
+	var out bytes.Buffer
+	for i, f := range si.folders {
+		r, err := a.folderReader(si, i)
+		if err != nil {
+			return nil, err
+		}
+		size := f.unpackSize()
+		if size > maxEntries*16 {
+			return nil, errCorruptHeader
+		}
+		start := out.Len()
+		if _, err := io.CopyN(&out, r, int64(size)); err != nil {
+			return nil, a.decodeError(f, err)
+		}
+		if f.hasCRC && crc32.ChecksumIEEE(out.Bytes()[start:]) != f.crc {
+			return nil, a.decodeError(f, fmt.Errorf("7z: checksum mismatch"))
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func (a *Archive) readHeader(h *headerReader) error {
+	var entries []fileEntry
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case idEnd:
+			return a.buildFiles(entries)
+		case idArchiveProperties:
+			if err := h.skipArchiveProperties(); err != nil {
+				return err
+			}
+		case idAdditionalStreamsInfo:
+			if _, err := h.streamsInfo(); err != nil {
+				return err
+			}
+		case idMainStreamsInfo:
+			if a.main, err = h.streamsInfo(); err != nil {
+				return err
+			}
+		case idFilesInfo:
+			if entries, err = h.filesInfo(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("7z: unexpected property %#x in header", id)
+		}
+	}
+}
+
+// buildFiles matches the file entries with the streams that hold them.
+func (a *Archive) buildFiles(entries []fileEntry) error {
+	var streamFolder []int
+	if a.main != nil {
+		for i, n := range a.main.numStreams {
+			for j := 0; j < n; j++ {
+				streamFolder = append(streamFolder, i)
+			}
+		}
+	}
+
+	stream := 0
+	for _, e := range entries {
+		// 7-Zip on Windows separates names with backslashes
+		name := strings.ReplaceAll(e.name, "\\", "/")
+		f := &File{Name: name, folder: -1, Anti: e.isAnti}
+		if e.hasMTime {
+			f.ModTime = filetime(e.mtime)
+		}
+		f.Mode = 0644
+		if e.isDir {
+			f.Mode = fs.ModeDir | 0755
+		}
+		if e.hasAttrib {
+			f.Mode = fileMode(e.attributes, e.isDir)
+		}
+		if e.hasStream {
+			if stream >= len(streamFolder) {
+				return fmt.Errorf("7z: more files than streams")
+			}
+			f.folder = streamFolder[stream]
+			f.Size = int64(a.main.sizes[stream])
+			f.hasCRC, f.crc = a.main.hasCRC[stream], a.main.crcs[stream]
+			stream++
+		}
+		a.Files = append(a.Files, f)
+	}
+	if stream != len(streamFolder) {
+		return fmt.Errorf("7z: %d streams have no file", len(streamFolder)-stream)
+	}
+	return nil
+}
+
+// filetime converts a Windows FILETIME, in 100ns units since 1601.
+func filetime(ft uint64) time.Time {
+	const epochDelta = 116444736000000000
+	t := int64(ft) - epochDelta
+	return time.Unix(t/1e7, t%1e7*100)
+}
+
+// fileMode derives permissions from Windows attributes, or from the Unix
+// mode that p7zip and libarchive store in their high bits.
+func fileMode(attrib uint32, isDir bool) fs.FileMode {
+	if attrib&attrUnixExtension != 0 {
+		unix := attrib >> 16
+		mode := fs.FileMode(unix & 0777)
+		switch unix & 0170000 {
+		case 0040000:
+			mode |= fs.ModeDir
+		case 0120000:
+			mode |= fs.ModeSymlink
+		}
+		if unix&04000 != 0 {
+			mode |= fs.ModeSetuid
+		}
+		if unix&02000 != 0 {
+			mode |= fs.ModeSetgid
+		}
+		if unix&01000 != 0 {
+			mode |= fs.ModeSticky
+		}
+		if isDir {
+			mode |= fs.ModeDir
+		}
+		return mode
+	}
+	mode := fs.FileMode(0644)
+	if isDir || attrib&attrDirectory != 0 {
+		mode = fs.ModeDir | 0755
+	}
+	if attrib&attrReadOnly != 0 {
+		mode &^= 0222
+	}
+	return mode
+}
+
+// Encrypted reports whether any content is encrypted.
+func (a *Archive) Encrypted() bool {
+	if a.main == nil {
+		return false
+	}
+	for _, f := range a.main.folders {
+		if f.encrypted() {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk calls fn for every file in archive order with a reader of its
+// content. Solid folders are decompressed once from start to end.
+func (a *Archive) Walk(fn func(f *File, r io.Reader) error) error {
+	current := -1
+	var fr io.Reader
+	for _, f := range a.Files {
+		if f.folder < 0 {
+			if err := fn(f, bytes.NewReader(nil)); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.folder != current {
+			var err error
+			if fr, err = a.folderReader(a.main, f.folder); err != nil {
+				return err
+			}
+			current = f.folder
+		}
+
+		cr := &checkedReader{r: io.LimitReader(fr, f.Size), n: f.Size, hash: crc32.NewIEEE()}
+		if err := fn(f, cr); err != nil {
+			if cr.err != nil {
+				return a.decodeError(a.main.folders[f.folder], err)
+			}
+			return err
+		}
+		if _, err := io.Copy(io.Discard, cr); err != nil {
+			return a.decodeError(a.main.folders[f.folder], err)
+		}
+		if cr.n > 0 {
+			return a.decodeError(a.main.folders[f.folder], fmt.Errorf("7z: %s is truncated", f.Name))
+		}
+		if f.hasCRC && cr.hash.Sum32() != f.crc {
+			return a.decodeError(a.main.folders[f.folder], fmt.Errorf("7z: checksum mismatch in %s", f.Name))
+		}
+	}
+	return nil
+}
+
+// decodeError reports failures in encrypted folders as a likely wrong
+// password, since that is how one shows up.
+func (a *Archive) decodeError(f *folder, err error) error {
+	if f.encrypted() && !errors.Is(err, ErrPasswordRequired) {
+		return fmt.Errorf("%w: %v", ErrWrongPassword, err)
+	}
+	return err
+}
+
+// checkedReader hashes what is read through it and remembers read errors,
+// which tell decoding failures apart from those of the caller.
+type checkedReader struct {
+	r    io.Reader
+	n    int64
+	hash hash.Hash32
+	err  error
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	c.hash.Write(p[:n])
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,402 @@
+package sevenzip
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+type member struct {
+	Name string
+	Mode fs.FileMode
+	Data string
+}
+
+func readArchive(data []byte, password string) ([]member, error) {
+	a, err := Open(bytes.NewReader(data), password)
+	if err != nil {
+		return nil, err
+	}
+	var members []member
+	err = a.Walk(func(f *File, r io.Reader) error {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		members = append(members, member{f.Name, f.Mode, string(content)})
+		return nil
+	})
+	return members, err
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// lines returns the output of
+// "seq -f 'line %g of the text, which repeats with small changes' 1 n".
+func lines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "line %d of the text, which repeats with small changes\n", i)
+	}
+	return b.String()
+}
+
+// The fixtures were made with bsdtar 3.7, which packs the header and puts
+// all files in one solid folder, from a tree holding a directory, a file,
+// an empty file, a symlink, a file of odd size and text.txt, the output of
+// lines(300):
+//
+//	bsdtar -cnf <compression>.7z --format=7zip --options 7zip:compression=<compression> dir dir/hello.txt empty link odd.txt text.txt
+func TestReadFixtures(t *testing.T) {
+	want := []member{
+		{"dir/hello.txt", 0644, "hello\n"},
+		{"link", fs.ModeSymlink | 0777, "dir/hello.txt"},
+		{"odd.txt", 0644, "odd"},
+		{"text.txt", 0644, lines(300)},
+		{"empty", 0644, ""},
+		{"dir", fs.ModeDir | 0755, ""},
+	}
+	for _, compression := range []string{"store", "deflate", "bzip2", "lzma1", "lzma2"} {
+		t.Run(compression, func(t *testing.T) {
+			data := readFixture(t, compression+".7z")
+			a, err := Open(bytes.NewReader(data), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size, err := Size(bytes.NewReader(data)); err != nil || size != int64(len(data)) {
+				t.Errorf("Size returned %d, %v, want %d", size, err, len(data))
+			}
+			mtime := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+			for _, f := range a.Files {
+				if !f.ModTime.Equal(mtime) {
+					t.Errorf("%s: got time %v, want %v", f.Name, f.ModTime, mtime)
+				}
+			}
+			got, err := readArchive(data, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	if _, err := readArchive(readFixture(t, "ppmd.7z"), ""); err == nil || !strings.Contains(err.Error(), "PPMd compression is not supported") {
+		t.Errorf("PPMd archive returned %v", err)
+	}
+}
+
+// number encodes a 7z variable length integer.
+func number(v uint64) []byte {
+	switch {
+	case v < 0x80:
+		return []byte{byte(v)}
+	case v < 0x4000:
+		return []byte{0x80 | byte(v>>8), byte(v)}
+	}
+	b := []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint64(b[1:], v)
+	return b
+}
+
+type testCoder struct {
+	method string
+	numIn  int
+	props  []byte
+}
+
+// testFolder is a folder for buildArchive. The pack streams feed the coder
+// inputs listed in packed, in order.
+type testFolder struct {
+	coders      []testCoder
+	bindPairs   [][2]int // in, out
+	packed      []int
+	unpackSizes []uint64
+}
+
+// buildArchive returns an archive holding a single file, whose content the
+// folder decodes from the pack streams.
+func buildArchive(packs [][]byte, f testFolder, content string) []byte {
+	var h bytes.Buffer
+	h.Write([]byte{idHeader, idMainStreamsInfo, idPackInfo, 0})
+	h.Write(number(uint64(len(packs))))
+	h.WriteByte(idSize)
+	for _, p := range packs {
+		h.Write(number(uint64(len(p))))
+	}
+	h.Write([]byte{idEnd, idUnpackInfo, idFolder, 1, 0})
+	h.Write(number(uint64(len(f.coders))))
+	for _, c := range f.coders {
+		flags := byte(len(c.method))
+		if c.numIn > 1 {
+			flags |= 0x10
+		}
+		if c.props != nil {
+			flags |= 0x20
+		}
+		h.WriteByte(flags)
+		h.WriteString(c.method)
+		if c.numIn > 1 {
+			h.Write([]byte{byte(c.numIn), 1})
+		}
+		if c.props != nil {
+			h.Write(number(uint64(len(c.props))))
+			h.Write(c.props)
+		}
+	}
+	for _, bp := range f.bindPairs {
+		h.Write([]byte{byte(bp[0]), byte(bp[1])})
+	}
+	if len(f.packed) > 1 {
+		for _, in := range f.packed {
+			h.WriteByte(byte(in))
+		}
+	}
+	h.WriteByte(idCodersUnpackSize)
+	for _, size := range f.unpackSizes {
+		h.Write(number(size))
+	}
+	h.Write([]byte{idCRC, 1})
+	binary.Write(&h, binary.LittleEndian, crc32.ChecksumIEEE([]byte(content)))
+	h.Write([]byte{idEnd, idEnd})
+
+	name := utf16.Encode([]rune("file\x00"))
+	h.Write([]byte{idFilesInfo, 1, idName})
+	h.Write(number(uint64(1 + 2*len(name))))
+	h.WriteByte(0)
+	binary.Write(&h, binary.LittleEndian, name)
+	h.Write([]byte{idEnd, idEnd})
+
+	return withStartHeader(bytes.Join(packs, nil), h.Bytes())
+}
+
+// withStartHeader returns an archive of the pack streams and the header.
+func withStartHeader(packed, header []byte) []byte {
+	start := make([]byte, signatureHeaderSize)
+	copy(start, signature)
+	start[7] = 4
+	binary.LittleEndian.PutUint64(start[12:], uint64(len(packed)))
+	binary.LittleEndian.PutUint64(start[20:], uint64(len(header)))
+	binary.LittleEndian.PutUint32(start[28:], crc32.ChecksumIEEE(header))
+	binary.LittleEndian.PutUint32(start[8:], crc32.ChecksumIEEE(start[12:]))
+	return append(append(start, packed...), header...)
+}
+
+// copyArchive stores content with the Copy method.
+func copyArchive(content string) []byte {
+	return buildArchive([][]byte{[]byte(content)}, testFolder{
+		coders:      []testCoder{{method: methodCopy}},
+		unpackSizes: []uint64{uint64(len(content))},
+	}, content)
+}
+
+// aesArchive encrypts content with 7zAES; the Copy coder stands in for a
+// compressor.
+func aesArchive(t *testing.T, content, password string) []byte {
+	salt := []byte("saltsalt")
+	iv := []byte("initial vector!!")
+	const cycles = 4
+	block, err := aes.NewCipher(deriveKey(password, salt, cycles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded := []byte(content)
+	padded = append(padded, make([]byte, (aes.BlockSize-len(padded)%aes.BlockSize)%aes.BlockSize)...)
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	props := append([]byte{0xc0 | cycles, 0x7f}, salt...)
+	props = append(props, iv...)
+	return buildArchive([][]byte{encrypted}, testFolder{
+		coders:      []testCoder{{method: methodCopy}, {method: methodAES, props: props}},
+		bindPairs:   [][2]int{{0, 1}},
+		unpackSizes: []uint64{uint64(len(content)), uint64(len(padded))},
+	}, content)
+}
+
+func TestCoders(t *testing.T) {
+	tests := map[string]struct {
+		packs   [][]byte
+		folder  testFolder
+		content string
+	}{
+		"delta": {
+			[][]byte{{'a', 'b', 1, 1, 1, 1}},
+			testFolder{coders: []testCoder{{method: methodDelta, props: []byte{1}}}, unpackSizes: []uint64{6}},
+			"abbccd",
+		},
+		// Made by xz with --x86, which stores the call target 0x100 as an
+		// absolute address
+		"x86": {
+			[][]byte{[]byte("\x90\xe8\x06\x01\x00\x00abcdefgh")},
+			testFolder{coders: []testCoder{{method: methodBCJ}}, unpackSizes: []uint64{14}},
+			"\x90\xe8\x00\x01\x00\x00abcdefgh",
+		},
+		// The range coder stream decodes a single set bit, converting the
+		// call to 0x100 at offset 3
+		"BCJ2": {
+			[][]byte{[]byte("ab\xe8cd"), {0, 0, 1, 0}, nil, {0, 0xff, 0xff, 0xff, 0xff}},
+			testFolder{
+				coders:      []testCoder{{method: methodBCJ2, numIn: 4}},
+				packed:      []int{0, 1, 2, 3},
+				unpackSizes: []uint64{9},
+			},
+			"ab\xe8\xf9\x00\x00\x00cd",
+		},
+		"ARM64 start offset": {
+			[][]byte{[]byte("plain text has no branches")},
+			testFolder{coders: []testCoder{{method: methodARM64, props: []byte{0, 0x10, 0, 0}}}, unpackSizes: []uint64{26}},
+			"plain text has no branches",
+		},
+	}
+	for name, tt := range tests {
+		got, err := readArchive(buildArchive(tt.packs, tt.folder, tt.content), "")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if want := []member{{"file", 0644, tt.content}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	content := "a secret that is longer than one block"
+	data := aesArchive(t, content, "pässword")
+
+	a, err := Open(bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Encrypted() {
+		t.Error("archive is not reported as encrypted")
+	}
+	got, err := readArchive(data, "pässword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []member{{"file", 0644, content}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := readArchive(data, ""); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("without a password: got %v, want %v", err, ErrPasswordRequired)
+	}
+	if _, err := readArchive(data, "password"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("with a wrong password: got %v, want %v", err, ErrWrongPassword)
+	}
+}
+
+// substreamsHeader returns a header whose copy folder holds three files,
+// with the given substreams info properties.
+func substreamsHeader(content string, substreams ...[]byte) []byte {
+	h := []byte{idHeader, idMainStreamsInfo, idPackInfo, 0, 1, idSize, byte(len(content)), idEnd,
+		idUnpackInfo, idFolder, 1, 0, 1, 1, 0, idCodersUnpackSize, byte(len(content)), idEnd}
+	for _, s := range substreams {
+		h = append(append(append(h, idSubStreamsInfo), s...), idEnd)
+	}
+	h = append(h, idEnd, idFilesInfo, 3, idEnd, idEnd)
+	return withStartHeader([]byte(content), h)
+}
+
+func TestRepeatedSubstreams(t *testing.T) {
+	// The second property gives more streams than the first, whose sizes
+	// and digests must not be kept
+	data := substreamsHeader("content", []byte{idNumUnpackStream, 1, idCRC, 1, 0, 0, 0, 0},
+		[]byte{idNumUnpackStream, 3, idSize, 3, 3})
+	got, err := readArchive(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []member{{"", 0644, "con"}, {"", 0644, "ten"}, {"", 0644, "t"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestInvalidArchives(t *testing.T) {
+	valid := copyArchive("content")
+	damage := func(i int, b byte) []byte {
+		data := bytes.Clone(valid)
+		data[i] = b
+		return data
+	}
+	badHeader := func(header []byte) []byte { return withStartHeader([]byte("content"), header) }
+	tests := map[string][]byte{
+		"empty":                nil,
+		"bad signature":        damage(0, '8'),
+		"unsupported version":  damage(6, 1),
+		"start header CRC":     damage(8, valid[8]^1),
+		"header beyond end":    valid[:len(valid)-1],
+		"header CRC":           damage(len(valid)-3, 0x7f),
+		"content CRC":          damage(signatureHeaderSize, 'C'),
+		"unknown method":       damage(bytes.Index(valid, []byte{idFolder, 1, 0, 1, 1, 0})+5, 0x42),
+		"unknown property":     badHeader([]byte{0x42}),
+		"truncated header":     badHeader([]byte{idHeader, idMainStreamsInfo, idPackInfo}),
+		"huge count":           badHeader([]byte{idHeader, idFilesInfo, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
+		"more files than data": substreamsHeader("content", []byte{idNumUnpackStream, 2, idSize, 3}),
+		// Two folders of 2^24 empty files each, which need no sizes
+		"too many substreams": badHeader([]byte{idHeader, idMainStreamsInfo,
+			idPackInfo, 0, 2, idSize, 3, 4, idEnd,
+			idUnpackInfo, idFolder, 2, 0, 1, 1, 0, 1, 1, 0, idCodersUnpackSize, 3, 4, idEnd,
+			idSubStreamsInfo, idNumUnpackStream, 0xe1, 0, 0, 0, 0xe1, 0, 0, 0, idEnd, idEnd,
+			idFilesInfo, 3, idEnd, idEnd}),
+	}
+	for name, data := range tests {
+		if _, err := readArchive(data, ""); err == nil {
+			t.Errorf("%s: archive was accepted", name)
+		}
+	}
+}
+
+// TestDamagedArchives checks that truncated and corrupt archives fail with
+// an error rather than a panic. Decoding stops once a folder's output is
+// complete, so damage to the size of a final LZMA2 chunk, for one, goes
+// unnoticed; the checksums then show the content to be intact.
+func TestDamagedArchives(t *testing.T) {
+	archives := map[string][]byte{
+		"lzma2.7z":   readFixture(t, "lzma2.7z"),
+		"bzip2.7z":   readFixture(t, "bzip2.7z"),
+		"deflate.7z": readFixture(t, "deflate.7z"),
+		"encrypted":  aesArchive(t, lines(20), "password"),
+	}
+	for name, data := range archives {
+		want, err := readArchive(data, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			if _, err := readArchive(data[:n], "password"); err == nil {
+				t.Errorf("%s truncated to %d bytes was accepted", name, n)
+			}
+		}
+		for i := 0; i < len(data); i++ {
+			data[i] ^= 0xff
+			if got, err := readArchive(data, "password"); err == nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("%s with byte %d changed: got %q", name, i, got)
+			}
+			data[i] ^= 0xff
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package sfx reads and writes the trailer of self-extracting archives,
+// which are an extractor program followed by an archive and a trailer that
+// says where the archive is and how to extract it.
+package sfx
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// magic ends every trailer.
+var magic = []byte("FUTILSFX")
+
+// footerSize is the size of the fixed part at the very end of the file:
+// the archive offset and size, the settings size, a version and magic.
+const footerSize = 8 + 8 + 4 + 4 + 8
+
+// version is the trailer format version.
+const version = 1
+
+// maxSettingsSize bounds the settings read from a trailer.
+const maxSettingsSize = 1 << 20
+
+// Types lists the archive types the extractor reads, which are the ones a
+// self-extracting archive can hold.
+var Types = map[string]bool{
+	"zip": true,
+	"tar": true, "tar.gz": true, "tar.bz2": true, "tar.xz": true, "tar.zst": true, "tar.lz4": true,
+}
+
+// ErrNotSFX is returned for files without a self-extractor trailer.
+var ErrNotSFX = errors.New("not a self-extracting archive")
+
+// Trailer locates the archive in a self-extracting file.
+type Trailer struct {
+	Offset int64 // of the archive, which is the size of the extractor
+	Size   int64 // of the archive
+
+	Settings
+}
+
+// Settings are chosen when the file is created and used when it runs.
+type Settings struct {
+	Type      string `json:"type"`              // archive type, such as "zip" or "tar.gz"
+	Command   string `json:"command,omitempty"` // run in the target directory after extraction
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+// Encode returns the trailer to append after the archive.
+func (t *Trailer) Encode() ([]byte, error) {
+	settings, err := json.Marshal(t.Settings)
+	if err != nil {
+		return nil, err
+	}
+	b := append(settings, make([]byte, 0, footerSize)...)
+	b = binary.LittleEndian.AppendUint64(b, uint64(t.Offset))
+	b = binary.LittleEndian.AppendUint64(b, uint64(t.Size))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(settings)))
+	b = binary.LittleEndian.AppendUint32(b, version)
+	return append(b, magic...), nil
+}
+
+// Read returns the trailer at the end of r, whose size is size.
+func Read(r io.ReaderAt, size int64) (*Trailer, error) {
+	if size < footerSize {
+		return nil, ErrNotSFX
+	}
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-footerSize); err != nil {
+		return nil, err
+	}
+	if string(footer[footerSize-len(magic):]) != string(magic) {
+		return nil, ErrNotSFX
+	}
+	if v := binary.LittleEndian.Uint32(footer[20:]); v != version {
+		return nil, fmt.Errorf("sfx: unsupported trailer version %d", v)
+	}
+	t := &Trailer{
+		Offset: int64(binary.LittleEndian.Uint64(footer)),
+		Size:   int64(binary.LittleEndian.Uint64(footer[8:])),
+	}
+	settingsSize := int64(binary.LittleEndian.Uint32(footer[16:]))
+	if settingsSize > maxSettingsSize || t.Offset < 0 || t.Size < 0 ||
+		t.Offset+t.Size+settingsSize+footerSize != size {
+		return nil, errors.New("sfx: corrupt trailer")
+	}
+	settings := make([]byte, settingsSize)
+	if _, err := r.ReadAt(settings, size-footerSize-settingsSize); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings, &t.Settings); err != nil {
+		return nil, fmt.Errorf("sfx: corrupt trailer settings: %w", err)
+	}
+	return t, nil
+}
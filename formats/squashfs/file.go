@@ -0,0 +1,128 @@
+package squashfs
+
+import (
+	"fmt"
+	"io"
+)
+
+// Open returns a reader of the contents of a regular file.
+func (f *File) Open() (io.Reader, error) {
+	if !f.Mode.IsRegular() {
+		return nil, fmt.Errorf("squashfs: %s is not a regular file", f.Path)
+	}
+	return &fileReader{f: f, pos: f.blocksStart, remaining: f.Size}, nil
+}
+
+// fileReader decompresses the blocks of a file one at a time, then its
+// tail from a fragment block.
+type fileReader struct {
+	f         *File
+	block     int   // index of the next block
+	pos       int64 // position of the next block in the image
+	buf       []byte
+	remaining int64
+	tailDone  bool
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.remaining == 0 {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill decompresses the next block, or the tail once the blocks are read.
+func (r *fileReader) fill() error {
+	f, img := r.f, r.f.img
+	if r.block < len(f.blockSizes) {
+		want := int(min(r.remaining, int64(img.BlockSize)))
+		data, err := img.dataBlock(r.pos, f.blockSizes[r.block], want)
+		if err != nil {
+			return fmt.Errorf("squashfs: %s: block %d: %w", f.Path, r.block, err)
+		}
+		r.pos += int64(f.blockSizes[r.block] &^ dataUncompressed)
+		r.block++
+		r.buf = data
+		r.remaining -= int64(len(data))
+		return nil
+	}
+	if r.tailDone || f.fragment == noFragment {
+		return fmt.Errorf("squashfs: %s: %w", f.Path, errCorrupt)
+	}
+	r.tailDone = true
+	frag, err := img.fragmentBlock(f.fragment)
+	if err != nil {
+		return fmt.Errorf("squashfs: %s: %w", f.Path, err)
+	}
+	end := int64(f.fragOffset) + r.remaining
+	if end > int64(len(frag)) {
+		return fmt.Errorf("squashfs: %s: %w", f.Path, errCorrupt)
+	}
+	r.buf = frag[f.fragOffset:end]
+	r.remaining = 0
+	return nil
+}
+
+// dataBlock reads the data block at pos, which must decompress to want
+// bytes. A size of zero stands for a sparse block of zeros.
+func (img *Image) dataBlock(pos int64, size uint32, want int) ([]byte, error) {
+	if size == 0 {
+		return make([]byte, want), nil
+	}
+	stored := size&dataUncompressed != 0
+	size &^= dataUncompressed
+	if size > uint32(img.BlockSize) {
+		return nil, errCorrupt
+	}
+	data := make([]byte, size)
+	if _, err := img.r.ReadAt(data, pos); err != nil {
+		return nil, err
+	}
+	if !stored {
+		var err error
+		if data, err = img.decompress(data, img.BlockSize); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) != want {
+		return nil, errCorrupt
+	}
+	return data, nil
+}
+
+// fragmentBlock returns a decompressed fragment block, which holds the tails
+// of several files. The last one read is kept, as files sharing a fragment
+// are usually stored together.
+func (img *Image) fragmentBlock(index uint32) ([]byte, error) {
+	if data, ok := img.fragData[index]; ok {
+		return data, nil
+	}
+	if int(index) >= len(img.fragments) {
+		return nil, errCorrupt
+	}
+	frag := img.fragments[index]
+	size := frag.size &^ dataUncompressed
+	if size > uint32(img.BlockSize) {
+		return nil, errCorrupt
+	}
+	data := make([]byte, size)
+	if _, err := img.r.ReadAt(data, frag.start); err != nil {
+		return nil, err
+	}
+	if frag.size&dataUncompressed == 0 {
+		var err error
+		if data, err = img.decompress(data, img.BlockSize); err != nil {
+			return nil, err
+		}
+	}
+	clear(img.fragData)
+	img.fragData[index] = data
+	return data, nil
+}
@@ -0,0 +1,658 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// Inode types. Extended inodes add fields such as extended attributes.
+const (
+	typeDir = iota + 1
+	typeFile
+	typeSymlink
+	typeBlockDev
+	typeCharDev
+	typeFifo
+	typeSocket
+	typeExtDir
+	typeExtFile
+	typeExtSymlink
+	typeExtBlockDev
+	typeExtCharDev
+	typeExtFifo
+	typeExtSocket
+)
+
+// maxDepth bounds directory nesting, guarding against malformed images.
+const maxDepth = 256
+
+// maxLinkSize bounds symbolic link targets, as PATH_MAX does on Linux.
+const maxLinkSize = 4096
+
+// xattrPrefixes are the namespaces of extended attributes, by type.
+var xattrPrefixes = []string{"user.", "trusted.", "security."}
+
+// File is a file, directory, symbolic link or special file in an image.
+type File struct {
+	Path     string // slash separated, relative to the root
+	Mode     fs.FileMode
+	Size     int64
+	ModTime  time.Time
+	Uid      int
+	Gid      int
+	Linkname string // symbolic link target
+
+	// Device number of character and block special files
+	RDevMajor uint32
+	RDevMinor uint32
+
+	Inode uint32 // shared by hard links
+	Nlink uint32
+
+	// Xattrs are the extended attributes, with their namespace prefix
+	Xattrs []Xattr
+
+	img         *Image
+	blocksStart int64
+	blockSizes  []uint32
+	fragment    uint32
+	fragOffset  uint32
+	xattrIndex  uint32
+}
+
+// Xattr is an extended attribute.
+type Xattr struct {
+	Name  string
+	Value []byte
+}
+
+// Image is a SquashFS image opened for reading.
+type Image struct {
+	Compression string
+	BlockSize   int
+	Inodes      int
+	Fragments   int
+	ModTime     time.Time
+	Size        int64 // bytes used by the image
+
+	r          io.ReaderAt
+	sb         *superblock
+	decompress decompressor
+	ids        []uint32
+	fragments  []fragmentEntry
+
+	xattrStart int64
+	xattrIDs   []xattrID
+
+	metadata map[int64]metadataBlock
+	fragData map[uint32][]byte
+}
+
+type fragmentEntry struct {
+	start int64
+	size  uint32
+}
+
+type xattrID struct {
+	ref   uint64
+	count uint32
+}
+
+type metadataBlock struct {
+	data []byte
+	next int64 // position of the following block
+}
+
+// Open reads the superblock and lookup tables of an image. The image may
+// follow the runtime of an AppImage.
+func Open(r io.ReaderAt) (*Image, error) {
+	offset, err := imageOffset(r)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		r = io.NewSectionReader(r, offset, 1<<62)
+	}
+	b := make([]byte, superblockSize)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, ErrNotSquashFS
+	}
+	sb, err := parseSuperblock(b)
+	if err != nil {
+		return nil, err
+	}
+	decompress, err := newDecompressor(sb.compression)
+	if err != nil {
+		return nil, err
+	}
+	img := &Image{
+		Compression: compressionNames[sb.compression],
+		BlockSize:   int(sb.blockSize),
+		Inodes:      int(sb.inodeCount),
+		Fragments:   int(sb.fragmentCount),
+		ModTime:     time.Unix(int64(sb.modTime), 0),
+		Size:        int64(sb.bytesUsed),
+		r:           r,
+		sb:          sb,
+		decompress:  decompress,
+		metadata:    make(map[int64]metadataBlock),
+		fragData:    make(map[uint32][]byte),
+	}
+
+	ids, err := img.readTable(int64(sb.idTable), int(sb.idCount)*4)
+	if err != nil {
+		return nil, fmt.Errorf("squashfs: failed to read ID table: %w", err)
+	}
+	for i := 0; i < len(ids); i += 4 {
+		img.ids = append(img.ids, binary.LittleEndian.Uint32(ids[i:]))
+	}
+
+	if sb.fragmentTable != noTable && sb.fragmentCount > 0 {
+		frags, err := img.readTable(int64(sb.fragmentTable), int(sb.fragmentCount)*16)
+		if err != nil {
+			return nil, fmt.Errorf("squashfs: failed to read fragment table: %w", err)
+		}
+		for i := 0; i < len(frags); i += 16 {
+			img.fragments = append(img.fragments, fragmentEntry{
+				start: int64(binary.LittleEndian.Uint64(frags[i:])),
+				size:  binary.LittleEndian.Uint32(frags[i+8:]),
+			})
+		}
+	}
+
+	if sb.xattrTable != noTable {
+		if err := img.readXattrTable(); err != nil {
+			return nil, fmt.Errorf("squashfs: failed to read extended attribute table: %w", err)
+		}
+	}
+	return img, nil
+}
+
+// imageOffset finds the superblock: at the start, or after an ELF runtime
+// as in AppImages.
+func imageOffset(r io.ReaderAt) (int64, error) {
+	head := make([]byte, 64)
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if !bytes.HasPrefix(head, []byte("\x7fELF")) {
+		return 0, nil
+	}
+	// The runtime ends with its section header table
+	var end int64
+	switch head[4] {
+	case 1:
+		end = int64(binary.LittleEndian.Uint32(head[0x20:])) +
+			int64(binary.LittleEndian.Uint16(head[0x2E:]))*int64(binary.LittleEndian.Uint16(head[0x30:]))
+	case 2:
+		end = int64(binary.LittleEndian.Uint64(head[0x28:])) +
+			int64(binary.LittleEndian.Uint16(head[0x3A:]))*int64(binary.LittleEndian.Uint16(head[0x3C:]))
+	default:
+		return 0, ErrNotSquashFS
+	}
+	return end, nil
+}
+
+// readTable reads a lookup table of size bytes: metadata blocks whose
+// positions are listed at pos.
+func (img *Image) readTable(pos int64, size int) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	blocks := (size + metadataSize - 1) / metadataSize
+	if int64(blocks)*8 > int64(img.sb.bytesUsed) {
+		return nil, errCorrupt
+	}
+	index := make([]byte, blocks*8)
+	if _, err := img.r.ReadAt(index, pos); err != nil {
+		return nil, err
+	}
+	var data []byte
+	for i := 0; i < blocks; i++ {
+		block, err := img.metadataBlock(int64(binary.LittleEndian.Uint64(index[i*8:])))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, block.data...)
+	}
+	if len(data) < size {
+		return nil, errCorrupt
+	}
+	return data[:size], nil
+}
+
+// metadataBlock reads the metadata block at pos.
+func (img *Image) metadataBlock(pos int64) (metadataBlock, error) {
+	if block, ok := img.metadata[pos]; ok {
+		return block, nil
+	}
+	header := make([]byte, 2)
+	if _, err := img.r.ReadAt(header, pos); err != nil {
+		return metadataBlock{}, err
+	}
+	size := binary.LittleEndian.Uint16(header)
+	stored := size&metadataUncompressed != 0
+	size &^= metadataUncompressed
+	if size == 0 || size > metadataSize {
+		return metadataBlock{}, errCorrupt
+	}
+	data := make([]byte, size)
+	if _, err := img.r.ReadAt(data, pos+2); err != nil {
+		return metadataBlock{}, err
+	}
+	if !stored {
+		var err error
+		if data, err = img.decompress(data, metadataSize); err != nil {
+			return metadataBlock{}, fmt.Errorf("squashfs: metadata block at %d: %w", pos, err)
+		}
+	}
+	block := metadataBlock{data: data, next: pos + 2 + int64(size)}
+	img.metadata[pos] = block
+	return block, nil
+}
+
+// metadataReader reads metadata that continues across blocks.
+type metadataReader struct {
+	img  *Image
+	data []byte
+	next int64
+}
+
+// newMetadataReader starts at offset in the block at pos.
+func (img *Image) newMetadataReader(pos int64, offset int) (*metadataReader, error) {
+	block, err := img.metadataBlock(pos)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(block.data) {
+		return nil, errCorrupt
+	}
+	return &metadataReader{img: img, data: block.data[offset:], next: block.next}, nil
+}
+
+// newReference starts at a reference: a block position relative to base in
+// the high 48 bits, and an offset in the block in the low 16.
+func (img *Image) newReference(base int64, ref uint64) (*metadataReader, error) {
+	return img.newMetadataReader(base+int64(ref>>16), int(ref&0xFFFF))
+}
+
+func (m *metadataReader) read(n int) ([]byte, error) {
+	if n <= len(m.data) {
+		b := m.data[:n]
+		m.data = m.data[n:]
+		return b, nil
+	}
+	// Sizes come from the image, so memory grows only with what is read
+	b := make([]byte, 0, min(n, metadataSize))
+	for len(b) < n {
+		if len(m.data) == 0 {
+			block, err := m.img.metadataBlock(m.next)
+			if err != nil {
+				return nil, err
+			}
+			m.data, m.next = block.data, block.next
+		}
+		k := min(n-len(b), len(m.data))
+		b = append(b, m.data[:k]...)
+		m.data = m.data[k:]
+	}
+	return b, nil
+}
+
+func (m *metadataReader) uint16() (uint16, error) {
+	b, err := m.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (m *metadataReader) uint32() (uint32, error) {
+	b, err := m.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (m *metadataReader) uint64() (uint64, error) {
+	b, err := m.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// inode is a parsed inode with the location of a directory's entries.
+type inode struct {
+	*File
+	dirStart  uint32
+	dirOffset uint16
+	dirSize   uint32 // of the listing, three more than its entries
+}
+
+// readInode parses the inode at ref in the inode table.
+func (img *Image) readInode(ref uint64) (*inode, error) {
+	m, err := img.newReference(int64(img.sb.inodeTable), ref)
+	if err != nil {
+		return nil, err
+	}
+	h, err := m.read(16)
+	if err != nil {
+		return nil, err
+	}
+	typ := binary.LittleEndian.Uint16(h)
+	uid, gid := int(binary.LittleEndian.Uint16(h[4:])), int(binary.LittleEndian.Uint16(h[6:]))
+	if uid >= len(img.ids) || gid >= len(img.ids) {
+		return nil, errCorrupt
+	}
+	f := &File{
+		Mode:       permissions(binary.LittleEndian.Uint16(h[2:])),
+		Uid:        int(img.ids[uid]),
+		Gid:        int(img.ids[gid]),
+		ModTime:    time.Unix(int64(binary.LittleEndian.Uint32(h[8:])), 0),
+		Inode:      binary.LittleEndian.Uint32(h[12:]),
+		Nlink:      1,
+		img:        img,
+		fragment:   noFragment,
+		xattrIndex: noXattrs,
+	}
+	ino := &inode{File: f}
+
+	// Fields in order of the on-disk layout of each type
+	var fields []any
+	var blocksStart32, fileSize32, dirStart, dirSize32 uint32
+	var blocksStart64, fileSize64, sparse uint64
+	var dirSize16, indexCount uint16
+	var parent, device, targetSize uint32
+	switch typ {
+	case typeDir:
+		f.Mode |= fs.ModeDir
+		fields = []any{&dirStart, &f.Nlink, &dirSize16, &ino.dirOffset, &parent}
+	case typeExtDir:
+		f.Mode |= fs.ModeDir
+		fields = []any{&f.Nlink, &dirSize32, &dirStart, &parent, &indexCount, &ino.dirOffset, &f.xattrIndex}
+	case typeFile:
+		fields = []any{&blocksStart32, &f.fragment, &f.fragOffset, &fileSize32}
+	case typeExtFile:
+		fields = []any{&blocksStart64, &fileSize64, &sparse, &f.Nlink, &f.fragment, &f.fragOffset, &f.xattrIndex}
+	case typeSymlink, typeExtSymlink:
+		f.Mode |= fs.ModeSymlink
+		fields = []any{&f.Nlink, &targetSize}
+	case typeBlockDev, typeExtBlockDev:
+		f.Mode |= fs.ModeDevice
+		fields = []any{&f.Nlink, &device}
+	case typeCharDev, typeExtCharDev:
+		f.Mode |= fs.ModeDevice | fs.ModeCharDevice
+		fields = []any{&f.Nlink, &device}
+	case typeFifo, typeExtFifo:
+		f.Mode |= fs.ModeNamedPipe
+		fields = []any{&f.Nlink}
+	case typeSocket, typeExtSocket:
+		f.Mode |= fs.ModeSocket
+		fields = []any{&f.Nlink}
+	default:
+		return nil, fmt.Errorf("squashfs: unknown inode type %d", typ)
+	}
+	switch typ {
+	case typeExtBlockDev, typeExtCharDev, typeExtFifo, typeExtSocket:
+		fields = append(fields, &f.xattrIndex)
+	}
+	for _, field := range fields {
+		var err error
+		switch v := field.(type) {
+		case *uint16:
+			*v, err = m.uint16()
+		case *uint32:
+			*v, err = m.uint32()
+		case *uint64:
+			*v, err = m.uint64()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch typ {
+	case typeDir:
+		ino.dirStart, ino.dirSize = dirStart, uint32(dirSize16)
+	case typeExtDir:
+		ino.dirStart, ino.dirSize = dirStart, dirSize32
+	case typeFile:
+		f.blocksStart, f.Size = int64(blocksStart32), int64(fileSize32)
+	case typeExtFile:
+		f.blocksStart, f.Size = int64(blocksStart64), int64(fileSize64)
+	case typeSymlink, typeExtSymlink:
+		if targetSize > maxLinkSize {
+			return nil, errCorrupt
+		}
+		target, err := m.read(int(targetSize))
+		if err != nil {
+			return nil, err
+		}
+		f.Linkname, f.Size = string(target), int64(targetSize)
+		if typ == typeExtSymlink {
+			if f.xattrIndex, err = m.uint32(); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		// Devices use the Linux encoding of device numbers
+		f.RDevMajor = device >> 8 & 0xFFF
+		f.RDevMinor = device&0xFF | device>>12&0xFFF00
+	}
+
+	if f.Mode.IsRegular() {
+		if f.Size < 0 {
+			return nil, errCorrupt
+		}
+		blocks := f.Size / int64(img.BlockSize)
+		if f.fragment == noFragment && f.Size%int64(img.BlockSize) != 0 {
+			blocks++
+		}
+		if blocks > 1<<32 {
+			return nil, errCorrupt
+		}
+		sizes, err := m.read(int(blocks) * 4)
+		if err != nil {
+			return nil, err
+		}
+		f.blockSizes = make([]uint32, blocks)
+		for i := range f.blockSizes {
+			f.blockSizes[i] = binary.LittleEndian.Uint32(sizes[i*4:])
+		}
+	}
+	if f.xattrIndex != noXattrs {
+		if f.Xattrs, err = img.readXattrs(f.xattrIndex); err != nil {
+			return nil, err
+		}
+	}
+	return ino, nil
+}
+
+// permissions converts the permission bits of an inode.
+func permissions(m uint16) fs.FileMode {
+	mode := fs.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// Walk calls fn for every file in the image, parents before their contents.
+// The root directory is passed first, with an empty path.
+func (img *Image) Walk(fn func(f *File) error) error {
+	root, err := img.readInode(img.sb.rootInode)
+	if err != nil {
+		return fmt.Errorf("squashfs: failed to read root directory: %w", err)
+	}
+	if !root.Mode.IsDir() {
+		return fmt.Errorf("squashfs: root is not a directory")
+	}
+	if err := fn(root.File); err != nil {
+		return err
+	}
+	return img.walkDir(root, 0, map[uint32]bool{root.Inode: true}, fn)
+}
+
+func (img *Image) walkDir(dir *inode, depth int, visited map[uint32]bool, fn func(*File) error) error {
+	if depth > maxDepth {
+		return fmt.Errorf("squashfs: directories nested too deeply")
+	}
+	if dir.dirSize <= 3 {
+		return nil
+	}
+	m, err := img.newMetadataReader(int64(img.sb.dirTable)+int64(dir.dirStart), int(dir.dirOffset))
+	if err != nil {
+		return err
+	}
+	for remaining := int64(dir.dirSize) - 3; remaining > 0; {
+		// A header, then entries whose inodes share a metadata block
+		h, err := m.read(12)
+		if err != nil {
+			return err
+		}
+		count := int(binary.LittleEndian.Uint32(h)) + 1
+		start := binary.LittleEndian.Uint32(h[4:])
+		remaining -= 12
+		if count > 256 {
+			return errCorrupt
+		}
+		for i := 0; i < count; i++ {
+			e, err := m.read(8)
+			if err != nil {
+				return err
+			}
+			nameSize := int(binary.LittleEndian.Uint16(e[6:])) + 1
+			name, err := m.read(nameSize)
+			if err != nil {
+				return err
+			}
+			remaining -= int64(8 + nameSize)
+			if string(name) == "." || string(name) == ".." || bytes.ContainsAny(name, "/\x00") {
+				return fmt.Errorf("squashfs: invalid name %q", name)
+			}
+
+			ref := uint64(start)<<16 | uint64(binary.LittleEndian.Uint16(e))
+			child, err := img.readInode(ref)
+			if err != nil {
+				return err
+			}
+			child.Path = path.Join(dir.Path, string(name))
+			if err := fn(child.File); err != nil {
+				return err
+			}
+			if child.Mode.IsDir() {
+				if visited[child.Inode] {
+					return fmt.Errorf("squashfs: directory loop at %s", child.Path)
+				}
+				visited[child.Inode] = true
+				if err := img.walkDir(child, depth+1, visited, fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readXattrTable reads the table that locates the extended attributes of
+// each inode that has any.
+func (img *Image) readXattrTable() error {
+	header := make([]byte, 16)
+	if _, err := img.r.ReadAt(header, int64(img.sb.xattrTable)); err != nil {
+		return err
+	}
+	img.xattrStart = int64(binary.LittleEndian.Uint64(header))
+	count := binary.LittleEndian.Uint32(header[8:])
+	if count > 1<<24 {
+		return errCorrupt
+	}
+	table, err := img.readTable(int64(img.sb.xattrTable)+16, int(count)*16)
+	if err != nil {
+		return err
+	}
+	img.xattrIDs = make([]xattrID, count)
+	for i := range img.xattrIDs {
+		img.xattrIDs[i] = xattrID{
+			ref:   binary.LittleEndian.Uint64(table[i*16:]),
+			count: binary.LittleEndian.Uint32(table[i*16+8:]),
+		}
+	}
+	return nil
+}
+
+// readXattrs reads the extended attributes at an index of the table.
+func (img *Image) readXattrs(index uint32) ([]Xattr, error) {
+	if int(index) >= len(img.xattrIDs) {
+		return nil, errCorrupt
+	}
+	id := img.xattrIDs[index]
+	m, err := img.newReference(img.xattrStart, id.ref)
+	if err != nil {
+		return nil, err
+	}
+	var xattrs []Xattr
+	for i := uint32(0); i < id.count; i++ {
+		typ, err := m.uint16()
+		if err != nil {
+			return nil, err
+		}
+		nameSize, err := m.uint16()
+		if err != nil {
+			return nil, err
+		}
+		name, err := m.read(int(nameSize))
+		if err != nil {
+			return nil, err
+		}
+		prefix := int(typ & 0xFF)
+		if prefix >= len(xattrPrefixes) {
+			return nil, fmt.Errorf("squashfs: unknown extended attribute type %d", typ)
+		}
+		value, err := readXattrValue(m)
+		if err != nil {
+			return nil, err
+		}
+		if typ&0x100 != 0 {
+			// The value is stored once elsewhere, and referenced here
+			if len(value) != 8 {
+				return nil, errCorrupt
+			}
+			v, err := img.newReference(img.xattrStart, binary.LittleEndian.Uint64(value))
+			if err != nil {
+				return nil, err
+			}
+			if value, err = readXattrValue(v); err != nil {
+				return nil, err
+			}
+		}
+		xattrs = append(xattrs, Xattr{
+			Name:  xattrPrefixes[prefix] + string(name),
+			Value: append([]byte(nil), value...),
+		})
+	}
+	return xattrs, nil
+}
+
+func readXattrValue(m *metadataReader) ([]byte, error) {
+	size, err := m.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if size > 1<<16 {
+		return nil, errCorrupt
+	}
+	return m.read(int(size))
+}
@@ -0,0 +1,171 @@
+// Package squashfs reads SquashFS 4.0 images, the compressed read-only file
+// systems of snap packages, AppImages and firmware, without mounting them.
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"futile/compress/lz4"
+	"futile/compress/lzma"
+	"futile/compress/xz"
+	"futile/compress/zstd"
+	"io"
+)
+
+const (
+	magic          = 0x73717368 // "hsqs"
+	superblockSize = 96
+
+	// metadataSize is the uncompressed size of metadata blocks, which hold
+	// the inodes, directories and lookup tables.
+	metadataSize = 8192
+
+	// metadataUncompressed marks metadata blocks stored as they are, in
+	// their 16-bit size header.
+	metadataUncompressed = 0x8000
+	// dataUncompressed marks data blocks and fragments stored as they are,
+	// in their 32-bit size.
+	dataUncompressed = 1 << 24
+
+	noTable    = ^uint64(0) // position of a table the image does not have
+	noFragment = 0xFFFFFFFF
+	noXattrs   = 0xFFFFFFFF
+)
+
+// Compression IDs.
+const (
+	compressionGzip = 1
+	compressionLZMA = 2
+	compressionLZO  = 3
+	compressionXZ   = 4
+	compressionLZ4  = 5
+	compressionZstd = 6
+)
+
+var compressionNames = map[uint16]string{
+	compressionGzip: "gzip",
+	compressionLZMA: "lzma",
+	compressionLZO:  "lzo",
+	compressionXZ:   "xz",
+	compressionLZ4:  "lz4",
+	compressionZstd: "zstd",
+}
+
+// ErrNotSquashFS is returned for data without a SquashFS superblock.
+var ErrNotSquashFS = errors.New("not a SquashFS image")
+
+var errCorrupt = errors.New("squashfs: corrupt image")
+
+// superblock is the header at the start of an image. Table positions are
+// relative to it.
+type superblock struct {
+	inodeCount    uint32
+	modTime       uint32
+	blockSize     uint32
+	fragmentCount uint32
+	compression   uint16
+	idCount       uint16
+	rootInode     uint64
+	bytesUsed     uint64
+	idTable       uint64
+	xattrTable    uint64
+	inodeTable    uint64
+	dirTable      uint64
+	fragmentTable uint64
+}
+
+func parseSuperblock(b []byte) (*superblock, error) {
+	if len(b) < superblockSize || binary.LittleEndian.Uint32(b) != magic {
+		return nil, ErrNotSquashFS
+	}
+	if major, minor := binary.LittleEndian.Uint16(b[28:]), binary.LittleEndian.Uint16(b[30:]); major != 4 {
+		return nil, fmt.Errorf("squashfs: unsupported version %d.%d", major, minor)
+	}
+	sb := &superblock{
+		inodeCount:    binary.LittleEndian.Uint32(b[4:]),
+		modTime:       binary.LittleEndian.Uint32(b[8:]),
+		blockSize:     binary.LittleEndian.Uint32(b[12:]),
+		fragmentCount: binary.LittleEndian.Uint32(b[16:]),
+		compression:   binary.LittleEndian.Uint16(b[20:]),
+		idCount:       binary.LittleEndian.Uint16(b[26:]),
+		rootInode:     binary.LittleEndian.Uint64(b[32:]),
+		bytesUsed:     binary.LittleEndian.Uint64(b[40:]),
+		idTable:       binary.LittleEndian.Uint64(b[48:]),
+		xattrTable:    binary.LittleEndian.Uint64(b[56:]),
+		inodeTable:    binary.LittleEndian.Uint64(b[64:]),
+		dirTable:      binary.LittleEndian.Uint64(b[72:]),
+		fragmentTable: binary.LittleEndian.Uint64(b[80:]),
+	}
+	blockLog := binary.LittleEndian.Uint16(b[22:])
+	if sb.blockSize < 4096 || sb.blockSize > 1<<20 || blockLog > 20 || sb.blockSize != 1<<blockLog {
+		return nil, fmt.Errorf("squashfs: invalid block size %d", sb.blockSize)
+	}
+	return sb, nil
+}
+
+// decompressor decompresses a block into at most maxSize bytes.
+type decompressor func(src []byte, maxSize int) ([]byte, error)
+
+func newDecompressor(id uint16) (decompressor, error) {
+	switch id {
+	case compressionGzip:
+		return func(src []byte, maxSize int) ([]byte, error) {
+			r, err := zlib.NewReader(bytes.NewReader(src))
+			if err != nil {
+				return nil, err
+			}
+			return readBlock(r, maxSize)
+		}, nil
+	case compressionLZMA:
+		// LZMA blocks carry the header of .lzma files: properties, the
+		// dictionary size and the uncompressed size
+		return func(src []byte, maxSize int) ([]byte, error) {
+			if len(src) < 13 {
+				return nil, errCorrupt
+			}
+			size := int64(binary.LittleEndian.Uint64(src[5:]))
+			r, err := lzma.NewReaderFromHeader(bytes.NewReader(src[13:]), src[:5], size)
+			if err != nil {
+				return nil, err
+			}
+			return readBlock(r, maxSize)
+		}, nil
+	case compressionXZ:
+		return func(src []byte, maxSize int) ([]byte, error) {
+			r, err := xz.NewReader(bytes.NewReader(src))
+			if err != nil {
+				return nil, err
+			}
+			return readBlock(r, maxSize)
+		}, nil
+	case compressionLZ4:
+		return lz4.DecodeBlock, nil
+	case compressionZstd:
+		return func(src []byte, maxSize int) ([]byte, error) {
+			r, err := zstd.NewReader(bytes.NewReader(src))
+			if err != nil {
+				return nil, err
+			}
+			return readBlock(r, maxSize)
+		}, nil
+	}
+	if name, ok := compressionNames[id]; ok {
+		return nil, fmt.Errorf("squashfs: %s compression is not supported", name)
+	}
+	return nil, fmt.Errorf("squashfs: unknown compression %d", id)
+}
+
+// readBlock reads a decompressed block, which may not exceed maxSize bytes.
+func readBlock(r io.Reader, maxSize int) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSize {
+		return nil, errCorrupt
+	}
+	return b, nil
+}
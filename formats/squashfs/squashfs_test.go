@@ -0,0 +1,312 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type member struct {
+	Mode     fs.FileMode
+	Linkname string
+	Data     string
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readImage reads every file of an image, and the contents of the regular
+// ones.
+func readImage(data []byte) (map[string]member, error) {
+	img, err := Open(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]member)
+	err = img.Walk(func(f *File) error {
+		m := member{Mode: f.Mode, Linkname: f.Linkname}
+		if f.Mode.IsRegular() {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			content, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			m.Data = string(content)
+		}
+		members[f.Path] = m
+		return nil
+	})
+	return members, err
+}
+
+// wantMembers is the tree of the fixtures.
+func wantMembers() map[string]member {
+	var big strings.Builder
+	for i := 0; big.Len() < 10000; i++ {
+		fmt.Fprintf(&big, "line %d\n", i)
+	}
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	want := map[string]member{
+		"":                 {fs.ModeDir | 0755, "", ""},
+		"dir":              {fs.ModeDir | 0755, "", ""},
+		"dir/hello.txt":    {0644, "", "hello\n"},
+		"dir/hard.txt":     {0644, "", "hello\n"},
+		"dir/big.txt":      {0644, "", big.String()},
+		"dir/tail.txt":     {0644, "", big.String()[:3000]},
+		"dir/nofrag.txt":   {0644, "", big.String()[:9000]},
+		"dir/random.bin":   {0644, "", string(random)},
+		"dir/sparse.bin":   {0644, "", strings.Repeat("\x00", 8192) + "end\n"},
+		"dir/labelled.txt": {0644, "", "labelled\n"},
+		"dir/run.sh":       {fs.ModeSetuid | 0755, "", "#!/bin/sh\necho hi\n"},
+		"many":             {fs.ModeDir | 0755, "", ""},
+		"tmp":              {fs.ModeDir | fs.ModeSticky | 0777, "", ""},
+		"link":             {fs.ModeSymlink | 0777, "dir/hello.txt", ""},
+		"null":             {fs.ModeDevice | fs.ModeCharDevice | 0666, "", ""},
+		"loop0":            {fs.ModeDevice | 0660, "", ""},
+		"fifo":             {fs.ModeNamedPipe | 0600, "", ""},
+		"socket":           {fs.ModeSocket | 0600, "", ""},
+	}
+	for i := 0; i < 600; i++ {
+		want[fmt.Sprintf("many/file-%03d.txt", i)] = member{0644, "", ""}
+	}
+	return want
+}
+
+// The fixtures were written by testdata/gen.go, as there is no mksquashfs
+// to make them. They hold files of several blocks, sparse blocks, blocks
+// stored uncompressed and tails in two fragment blocks, and a directory
+// whose entries and inodes span several metadata blocks.
+func TestReadFixtures(t *testing.T) {
+	want := wantMembers()
+	for _, compression := range []string{"gzip", "xz", "lz4", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			data := readFixture(t, compression+".sqfs")
+			img, err := Open(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Compression != compression || img.BlockSize != 4096 || img.Inodes != 617 ||
+				img.Fragments != 2 || img.Size != int64(len(data)) || !img.ModTime.Equal(time.Unix(1700000000, 0)) {
+				t.Errorf("got image %+v", img)
+			}
+
+			files := make(map[string]*File)
+			if err := img.Walk(func(f *File) error { files[f.Path] = f; return nil }); err != nil {
+				t.Fatal(err)
+			}
+			hello, hard := files["dir/hello.txt"], files["dir/hard.txt"]
+			if hello.Inode != hard.Inode || hello.Nlink != 2 || hello.Uid != 1000 || hello.Gid != 1000 {
+				t.Errorf("got hard links %+v and %+v", hello, hard)
+			}
+			if f := files["dir"]; f.Nlink != 2 || f.Uid != 0 {
+				t.Errorf("got directory %+v", f)
+			}
+			if f := files[""]; f.Nlink != 5 {
+				t.Errorf("got root links %d, want 5", f.Nlink)
+			}
+			if f := files["null"]; f.RDevMajor != 1 || f.RDevMinor != 3 {
+				t.Errorf("got device %d,%d, want 1,3", f.RDevMajor, f.RDevMinor)
+			}
+			if f := files["loop0"]; f.RDevMajor != 7 || f.RDevMinor != 0 {
+				t.Errorf("got device %d,%d, want 7,0", f.RDevMajor, f.RDevMinor)
+			}
+			shared := Xattr{"trusted.shared", []byte("shared value")}
+			xattrs := map[string][]Xattr{
+				"dir":              {{"user.comment", []byte("value of user.comment")}, shared},
+				"dir/labelled.txt": {{"security.selinux", []byte("value of security.selinux")}, shared},
+			}
+			for path, f := range files {
+				if !reflect.DeepEqual(f.Xattrs, xattrs[path]) {
+					t.Errorf("%s: got extended attributes %q, want %q", path, f.Xattrs, xattrs[path])
+				}
+				if !f.ModTime.Equal(time.Unix(1700000000, 0)) {
+					t.Errorf("%s: got time %v", path, f.ModTime)
+				}
+			}
+
+			got, err := readImage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				for path := range want {
+					if !reflect.DeepEqual(got[path], want[path]) {
+						t.Errorf("%s: got %+v, want %+v", path, got[path], want[path])
+					}
+				}
+				t.Errorf("got %d files, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestAppImage(t *testing.T) {
+	// An ELF64 runtime whose section header table ends at byte 100
+	elf := make([]byte, 100)
+	copy(elf, "\x7fELF\x02\x01\x01")
+	binary.LittleEndian.PutUint64(elf[0x28:], 36)
+	binary.LittleEndian.PutUint16(elf[0x3A:], 64)
+	binary.LittleEndian.PutUint16(elf[0x3C:], 1)
+
+	got, err := readImage(append(elf, readFixture(t, "gzip.sqfs")...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := wantMembers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d files, want %d", len(got), len(want))
+	}
+}
+
+// image builds an uncompressed image whose root directory has one entry,
+// named name, for an inode of type typ with the given fields after its
+// common header. A directory entry lists the root directory's entries.
+func image(typ uint16, name string, fields ...any) []byte {
+	le := binary.LittleEndian
+	var buf bytes.Buffer
+	for _, f := range append([]any{typ, uint16(0644), uint16(0), uint16(0), uint32(0), uint32(1)}, fields...) {
+		binary.Write(&buf, le, f)
+	}
+	child := buf.Bytes()
+	basic := typ
+	if typ >= typeExtDir {
+		basic -= typeExtDir - typeDir
+	}
+	listing := le.AppendUint32(nil, 0)
+	listing = le.AppendUint32(listing, 0)
+	listing = le.AppendUint32(listing, 1)
+	listing = append(listing, 0, 0, 0, 0)
+	listing = le.AppendUint16(listing, basic)
+	listing = le.AppendUint16(listing, uint16(len(name)-1))
+	listing = append(listing, name...)
+
+	root := []byte{typeDir, 0, 0xed, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0}
+	root = le.AppendUint32(root, 0)
+	root = le.AppendUint32(root, 2)
+	root = le.AppendUint16(root, uint16(len(listing)+3))
+	root = le.AppendUint16(root, 0)
+	root = le.AppendUint32(root, 3)
+
+	metadata := func(b []byte) []byte {
+		return append(le.AppendUint16(nil, uint16(len(b))|metadataUncompressed), b...)
+	}
+	out := make([]byte, superblockSize)
+	inodeTable := len(out)
+	out = append(out, metadata(append(child, root...))...)
+	dirTable := len(out)
+	out = append(out, metadata(listing)...)
+	ids := len(out)
+	out = append(out, metadata([]byte{0, 0, 0, 0})...)
+	idTable := len(out)
+	out = le.AppendUint64(out, uint64(ids))
+
+	sb := []any{uint32(magic), uint32(2), uint32(0), uint32(4096), uint32(0), uint16(compressionGzip),
+		uint16(12), uint16(0), uint16(1), uint16(4), uint16(0), uint64(len(child)), uint64(len(out)),
+		uint64(idTable), noTable, uint64(inodeTable), uint64(dirTable), noTable, noTable}
+	buf.Reset()
+	for _, f := range sb {
+		binary.Write(&buf, le, f)
+	}
+	copy(out, buf.Bytes())
+	return out
+}
+
+func TestImageBuilder(t *testing.T) {
+	got, err := readImage(image(typeFile, "file", uint32(0), uint32(noFragment), uint32(0), uint32(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]member{"": {fs.ModeDir | 0755, "", ""}, "file": {0644, "", ""}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestInvalidImages(t *testing.T) {
+	valid := image(typeFile, "file", uint32(0), uint32(noFragment), uint32(0), uint32(0))
+	change := func(i int, b ...byte) []byte {
+		data := bytes.Clone(valid)
+		copy(data[i:], b)
+		return data
+	}
+	file := func(size uint32, fragment uint32, blocks ...uint32) []byte {
+		fields := []any{uint32(1 << 30), fragment, uint32(0), size}
+		for _, b := range blocks {
+			fields = append(fields, b)
+		}
+		return image(typeFile, "file", fields...)
+	}
+	var long [5000]byte
+
+	tests := map[string][]byte{
+		"empty":                 nil,
+		"short superblock":      valid[:50],
+		"bad magic":             change(0, 'x'),
+		"version 3":             change(28, 3),
+		"block size":            change(12, 0, 0x20),
+		"block log":             change(22, 11),
+		"LZO compression":       change(20, compressionLZO),
+		"unknown compression":   change(20, 9),
+		"ID table beyond image": change(48, 0, 0, 0, 0, 1),
+		"root beyond image":     change(32, 0, 0, 0, 0, 0, 1),
+		"metadata size":         change(superblockSize, 0, 0x90),
+		"unknown inode type":    image(15, "x"),
+		"uid index":             change(superblockSize+2+4, 1),
+		"link too long":         image(typeSymlink, "link", uint32(1), uint32(len(long)), long),
+		"link cut short":        image(typeSymlink, "link", uint32(1), uint32(100), [10]byte{}),
+		"negative size": image(typeExtFile, "file", uint64(0), uint64(1<<63), uint64(0), uint32(1),
+			uint32(noFragment), uint32(0), uint32(noXattrs)),
+		"too many blocks":  file(0xffffffff, noFragment),
+		"missing fragment": file(10, 0),
+		"block too large":  file(4096, noFragment, 8192),
+		"block beyond":     file(4096, noFragment, 100),
+		"missing xattrs": image(typeExtFile, "file", uint64(0), uint64(0), uint64(0), uint32(1),
+			uint32(noFragment), uint32(0), uint32(0)),
+		"dot dot":        image(typeFile, "..", uint32(0), uint32(noFragment), uint32(0), uint32(0)),
+		"slash":          image(typeFile, "a/b", uint32(0), uint32(noFragment), uint32(0), uint32(0)),
+		"directory loop": image(typeDir, "loop", uint32(0), uint32(2), uint16(4+12+8+4+3), uint16(0), uint32(2)),
+	}
+	for name, data := range tests {
+		if _, err := readImage(data); err == nil {
+			t.Errorf("%s: image was accepted", name)
+		}
+	}
+}
+
+// TestDamagedImages checks that truncated and corrupt images fail with an
+// error rather than a panic. The lookup tables end the images, so a cut
+// always loses part of them.
+func TestDamagedImages(t *testing.T) {
+	for _, compression := range []string{"gzip", "xz", "lz4", "zstd"} {
+		data := readFixture(t, compression+".sqfs")
+		// Cuts within a block fail alike, so a stride finds every kind
+		for n := 0; n < len(data); n += 1 + n/100 {
+			if _, err := readImage(data[:n]); err == nil {
+				t.Errorf("%s truncated to %d bytes was accepted", compression, n)
+			}
+		}
+		for i := 0; i < len(data); i += 1 + i/100 {
+			data[i] ^= 0xff
+			_, _ = readImage(data)
+			data[i] ^= 0xff
+		}
+	}
+}
@@ -0,0 +1,574 @@
+//go:build ignore
+
+// This program writes the SquashFS fixtures of the squashfs package tests.
+// There is no mksquashfs in the test environment, so the images are laid
+// out by hand, as mksquashfs lays them out: the superblock, the data and
+// fragment blocks, the inode and directory tables, then the fragment, ID
+// and extended attribute lookup tables. Run it from the package directory:
+//
+//	go run testdata/gen.go
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"futile/compress/xz"
+	"futile/compress/zstd"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	blockSize = 4096
+	mtime     = 1700000000
+	noIndex   = 0xffffffff
+)
+
+// Inode types; directory entries use the basic ones.
+const (
+	typeDir = iota + 1
+	typeFile
+	typeSymlink
+	typeBlockDev
+	typeCharDev
+	typeFifo
+	typeSocket
+	typeExtDir
+	typeExtFile
+)
+
+var le = binary.LittleEndian
+
+type node struct {
+	typ      int
+	mode     uint16
+	uid      uint16 // index in the ID table
+	data     string // file content or link target
+	noFrag   bool   // keep the tail in a block of its own
+	rdev     uint32
+	xattr    uint32
+	children []entry
+
+	// Set while writing
+	number      uint32
+	nlink       uint32
+	hasData     bool
+	hasInode    bool
+	ref         uint64
+	listing     uint64 // reference of a directory's entries
+	blocksStart uint64
+	blockSizes  []uint32
+	sparse      uint64
+	fragment    uint32
+	fragOffset  uint32
+}
+
+type entry struct {
+	name string
+	n    *node
+}
+
+func dir(typ int, children ...entry) *node {
+	return &node{typ: typ, mode: 0755, xattr: noIndex, children: children}
+}
+
+func file(data string) *node {
+	return &node{typ: typeFile, mode: 0644, data: data, xattr: noIndex}
+}
+
+// tree is the content of every image.
+func tree() *node {
+	var big strings.Builder
+	for i := 0; big.Len() < 10000; i++ {
+		fmt.Fprintf(&big, "line %d\n", i)
+	}
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	// Only extended inodes count links
+	hello := file("hello\n")
+	hello.typ, hello.uid = typeExtFile, 1
+	sparse := file(strings.Repeat("\x00", 2*blockSize) + "end\n")
+	sparse.typ = typeExtFile
+	labelled := file("labelled\n")
+	labelled.typ, labelled.xattr = typeExtFile, 1
+	script := file("#!/bin/sh\necho hi\n")
+	script.mode = 04755
+	noFrag := file(big.String()[:9000])
+	noFrag.noFrag = true
+
+	var many []entry
+	for i := 0; i < 600; i++ {
+		many = append(many, entry{fmt.Sprintf("file-%03d.txt", i), file("")})
+	}
+	sub := dir(typeExtDir,
+		entry{"hello.txt", hello},
+		entry{"hard.txt", hello},
+		entry{"big.txt", file(big.String())},
+		entry{"tail.txt", file(big.String()[:3000])},
+		entry{"nofrag.txt", noFrag},
+		entry{"random.bin", file(string(random))},
+		entry{"sparse.bin", sparse},
+		entry{"labelled.txt", labelled},
+		entry{"run.sh", script},
+	)
+	sub.xattr = 0
+	sticky := dir(typeDir)
+	sticky.mode = 01777
+	return dir(typeDir,
+		entry{"dir", sub},
+		entry{"many", dir(typeDir, many...)},
+		entry{"tmp", sticky},
+		entry{"link", &node{typ: typeSymlink, mode: 0777, data: "dir/hello.txt", xattr: noIndex}},
+		entry{"null", &node{typ: typeCharDev, mode: 0666, rdev: 1<<8 | 3, xattr: noIndex}},
+		entry{"loop0", &node{typ: typeBlockDev, mode: 0660, rdev: 7<<8 | 0, xattr: noIndex}},
+		entry{"fifo", &node{typ: typeFifo, mode: 0600, xattr: noIndex}},
+		entry{"socket", &node{typ: typeSocket, mode: 0600, xattr: noIndex}},
+	)
+}
+
+type compressor struct {
+	id       uint16
+	compress func([]byte) []byte
+}
+
+func stream(newWriter func(io.Writer) (io.WriteCloser, error)) func([]byte) []byte {
+	return func(b []byte) []byte {
+		var buf bytes.Buffer
+		w, err := newWriter(&buf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w.Write(b)
+		if err := w.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+}
+
+// lz4Block compresses a raw LZ4 block greedily. Matches end at least five
+// bytes before the end and start at least twelve before it, as the format
+// requires.
+func lz4Block(src []byte) []byte {
+	var out []byte
+	length := func(n int) {
+		for ; n >= 255; n -= 255 {
+			out = append(out, 255)
+		}
+		out = append(out, byte(n))
+	}
+	sequence := func(literals []byte, offset, match int) {
+		token := byte(min(len(literals), 15)) << 4
+		if offset > 0 {
+			token |= byte(min(match-4, 15))
+		}
+		out = append(out, token)
+		if len(literals) >= 15 {
+			length(len(literals) - 15)
+		}
+		out = append(out, literals...)
+		if offset > 0 {
+			out = le.AppendUint16(out, uint16(offset))
+			if match-4 >= 15 {
+				length(match - 4 - 15)
+			}
+		}
+	}
+	last := make(map[uint32]int)
+	anchor := 0
+	for i := 0; i+12 <= len(src); {
+		key := le.Uint32(src[i:])
+		j, ok := last[key]
+		last[key] = i
+		if !ok || i-j > 65535 {
+			i++
+			continue
+		}
+		n := 4
+		for i+n < len(src)-5 && src[j+n] == src[i+n] {
+			n++
+		}
+		sequence(src[anchor:i], i-j, n)
+		i += n
+		anchor = i
+	}
+	sequence(src[anchor:], 0, 0)
+	return out
+}
+
+var compressors = map[string]compressor{
+	"gzip": {1, stream(func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriterLevel(w, zlib.BestCompression) })},
+	"xz":   {4, stream(func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) })},
+	"lz4":  {5, lz4Block},
+	"zstd": {6, stream(func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) })},
+}
+
+// metaWriter writes metadata blocks, which are compressed unless that does
+// not make them smaller.
+type metaWriter struct {
+	c   compressor
+	out []byte
+	buf []byte
+}
+
+// ref returns the position of the next byte: the block position in the
+// high 48 bits and the offset in the block in the low 16.
+func (w *metaWriter) ref() uint64 {
+	return uint64(len(w.out))<<16 | uint64(len(w.buf))
+}
+
+func (w *metaWriter) write(b []byte) {
+	for len(b) > 0 {
+		n := min(len(b), 8192-len(w.buf))
+		w.buf = append(w.buf, b[:n]...)
+		b = b[n:]
+		if len(w.buf) == 8192 {
+			w.flush()
+		}
+	}
+}
+
+func (w *metaWriter) flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	data := w.c.compress(w.buf)
+	size := uint16(len(data))
+	if len(data) >= len(w.buf) {
+		data, size = w.buf, uint16(len(w.buf))|0x8000
+	}
+	w.out = le.AppendUint16(w.out, size)
+	w.out = append(w.out, data...)
+	w.buf = nil
+}
+
+type image struct {
+	c          compressor
+	out        []byte
+	fragBuf    []byte
+	fragments  []byte // fragment table entries
+	fragCount  uint32
+	inodes     metaWriter
+	dirs       metaWriter
+	inodeCount uint32
+}
+
+// dataBlock writes a block of file data, which is left out when sparse.
+func (img *image) dataBlock(b []byte) uint32 {
+	if bytes.Count(b, []byte{0}) == len(b) && len(b) == blockSize {
+		return 0
+	}
+	data := img.c.compress(b)
+	size := uint32(len(data))
+	if len(data) >= len(b) {
+		data, size = b, uint32(len(b))|1<<24
+	}
+	img.out = append(img.out, data...)
+	return size
+}
+
+func (img *image) flushFragment() {
+	if len(img.fragBuf) == 0 {
+		return
+	}
+	start := uint64(len(img.out))
+	size := img.dataBlock(img.fragBuf)
+	img.fragments = le.AppendUint64(img.fragments, start)
+	img.fragments = le.AppendUint32(img.fragments, size)
+	img.fragments = le.AppendUint32(img.fragments, 0)
+	img.fragCount++
+	img.fragBuf = nil
+}
+
+// number assigns inode numbers, children first, and counts links.
+func (img *image) number(n *node) {
+	n.nlink++
+	if n.number != 0 {
+		return
+	}
+	for _, e := range n.children {
+		img.number(e.n)
+		if isDir(e.n) {
+			n.nlink++ // its ".."
+		}
+	}
+	img.inodeCount++
+	n.number = img.inodeCount
+	if isDir(n) {
+		n.nlink++ // its "."
+	}
+}
+
+func isDir(n *node) bool {
+	return n.typ == typeDir || n.typ == typeExtDir
+}
+
+// writeData writes the blocks of every file, with tails in fragments.
+func (img *image) writeData(n *node) {
+	if n.typ != typeFile && n.typ != typeExtFile {
+		for _, e := range n.children {
+			img.writeData(e.n)
+		}
+		return
+	}
+	if n.hasData {
+		return // a hard link written already
+	}
+	n.hasData = true
+	n.fragment = noIndex
+	n.blocksStart = uint64(len(img.out))
+	data := []byte(n.data)
+	for len(data) >= blockSize || n.noFrag && len(data) > 0 {
+		k := min(len(data), blockSize)
+		size := img.dataBlock(data[:k])
+		if size == 0 {
+			n.sparse += blockSize
+		}
+		n.blockSizes = append(n.blockSizes, size)
+		data = data[k:]
+	}
+	if len(data) > 0 {
+		if len(img.fragBuf)+len(data) > blockSize {
+			img.flushFragment()
+		}
+		n.fragment, n.fragOffset = img.fragCount, uint32(len(img.fragBuf))
+		img.fragBuf = append(img.fragBuf, data...)
+	}
+}
+
+// writeInodes writes the inodes and directory listings, children before
+// their directories, so that every reference is known when written.
+func (img *image) writeInodes(n, parent *node) {
+	if n.hasInode {
+		return
+	}
+	n.hasInode = true
+	for _, e := range n.children {
+		img.writeInodes(e.n, n)
+	}
+
+	var listing []byte
+	if isDir(n) {
+		entries := append([]entry(nil), n.children...)
+		sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+		n.listing = img.dirs.ref()
+		for i := 0; i < len(entries); {
+			// Entries under one header share the block of their inodes
+			start, base := entries[i].n.ref>>16, entries[i].n.number
+			j := i
+			for j < len(entries) && j-i < 256 && entries[j].n.ref>>16 == start {
+				j++
+			}
+			listing = le.AppendUint32(listing, uint32(j-i-1))
+			listing = le.AppendUint32(listing, uint32(start))
+			listing = le.AppendUint32(listing, base)
+			for _, e := range entries[i:j] {
+				listing = le.AppendUint16(listing, uint16(e.n.ref))
+				listing = le.AppendUint16(listing, uint16(int16(e.n.number-base)))
+				listing = le.AppendUint16(listing, uint16(basicType(e.n.typ)))
+				listing = le.AppendUint16(listing, uint16(len(e.name)-1))
+				listing = append(listing, e.name...)
+			}
+			i = j
+		}
+		img.dirs.write(listing)
+	}
+
+	parentNumber := img.inodeCount + 1
+	if parent != nil {
+		parentNumber = parent.number
+	}
+	n.ref = img.inodes.ref()
+	var b []byte
+	b = le.AppendUint16(b, uint16(n.typ))
+	b = le.AppendUint16(b, n.mode)
+	b = le.AppendUint16(b, n.uid)
+	b = le.AppendUint16(b, n.uid)
+	b = le.AppendUint32(b, mtime)
+	b = le.AppendUint32(b, n.number)
+	switch n.typ {
+	case typeDir:
+		b = le.AppendUint32(b, uint32(n.listing>>16))
+		b = le.AppendUint32(b, n.nlink)
+		b = le.AppendUint16(b, uint16(len(listing)+3))
+		b = le.AppendUint16(b, uint16(n.listing))
+		b = le.AppendUint32(b, parentNumber)
+	case typeExtDir:
+		b = le.AppendUint32(b, n.nlink)
+		b = le.AppendUint32(b, uint32(len(listing)+3))
+		b = le.AppendUint32(b, uint32(n.listing>>16))
+		b = le.AppendUint32(b, parentNumber)
+		b = le.AppendUint16(b, 0)
+		b = le.AppendUint16(b, uint16(n.listing))
+		b = le.AppendUint32(b, n.xattr)
+	case typeFile:
+		b = le.AppendUint32(b, uint32(n.blocksStart))
+		b = le.AppendUint32(b, n.fragment)
+		b = le.AppendUint32(b, n.fragOffset)
+		b = le.AppendUint32(b, uint32(len(n.data)))
+	case typeExtFile:
+		b = le.AppendUint64(b, n.blocksStart)
+		b = le.AppendUint64(b, uint64(len(n.data)))
+		b = le.AppendUint64(b, n.sparse)
+		b = le.AppendUint32(b, n.nlink)
+		b = le.AppendUint32(b, n.fragment)
+		b = le.AppendUint32(b, n.fragOffset)
+		b = le.AppendUint32(b, n.xattr)
+	case typeSymlink:
+		b = le.AppendUint32(b, n.nlink)
+		b = le.AppendUint32(b, uint32(len(n.data)))
+		b = append(b, n.data...)
+	case typeBlockDev, typeCharDev:
+		b = le.AppendUint32(b, n.nlink)
+		b = le.AppendUint32(b, n.rdev)
+	case typeFifo, typeSocket:
+		b = le.AppendUint32(b, n.nlink)
+	}
+	for _, size := range n.blockSizes {
+		b = le.AppendUint32(b, size)
+	}
+	img.inodes.write(b)
+}
+
+func basicType(typ int) int {
+	switch typ {
+	case typeExtDir:
+		return typeDir
+	case typeExtFile:
+		return typeFile
+	}
+	return typ
+}
+
+// table writes a lookup table: its metadata blocks, then their positions,
+// where the table starts.
+func (img *image) table(data []byte) uint64 {
+	w := metaWriter{c: img.c}
+	var starts []int
+	for len(data) > 0 {
+		starts = append(starts, len(w.out))
+		k := min(len(data), 8192)
+		w.write(data[:k])
+		w.flush()
+		data = data[k:]
+	}
+	base := uint64(len(img.out))
+	img.out = append(img.out, w.out...)
+	pos := uint64(len(img.out))
+	for _, s := range starts {
+		img.out = le.AppendUint64(img.out, base+uint64(s))
+	}
+	return pos
+}
+
+// xattrs writes the extended attributes: a shared value referenced by two
+// attributes, then the attributes of each of the two sets.
+func (img *image) xattrs() uint64 {
+	kv := metaWriter{c: img.c}
+	size := 0 // of the attributes of a set
+	key := func(typ uint16, name string) {
+		b := le.AppendUint16(nil, typ)
+		b = le.AppendUint16(b, uint16(len(name)))
+		b = append(b, name...)
+		kv.write(b)
+		size += len(b)
+	}
+	value := func(v []byte) {
+		b := append(le.AppendUint32(nil, uint32(len(v))), v...)
+		kv.write(b)
+		size += len(b)
+	}
+	shared := kv.ref()
+	value([]byte("shared value"))
+
+	var ids []byte
+	for _, set := range [][]string{{"user.comment", "trusted.shared"}, {"security.selinux", "trusted.shared"}} {
+		ref := kv.ref()
+		size = 0
+		for _, name := range set {
+			prefix, rest, _ := strings.Cut(name, ".")
+			typ := map[string]uint16{"user": 0, "trusted": 1, "security": 2}[prefix]
+			if name == "trusted.shared" {
+				key(typ|0x100, rest)
+				value(le.AppendUint64(nil, shared))
+				continue
+			}
+			key(typ, rest)
+			value([]byte("value of " + name))
+		}
+		ids = le.AppendUint64(ids, ref)
+		ids = le.AppendUint32(ids, uint32(len(set)))
+		ids = le.AppendUint32(ids, uint32(size))
+	}
+	kv.flush()
+	kvStart := uint64(len(img.out))
+	img.out = append(img.out, kv.out...)
+
+	w := metaWriter{c: img.c}
+	w.write(ids)
+	w.flush()
+	idStart := uint64(len(img.out))
+	img.out = append(img.out, w.out...)
+	pos := uint64(len(img.out))
+	img.out = le.AppendUint64(img.out, kvStart)
+	img.out = le.AppendUint32(img.out, 2)
+	img.out = le.AppendUint32(img.out, 0)
+	img.out = le.AppendUint64(img.out, idStart)
+	return pos
+}
+
+func write(c compressor) []byte {
+	img := &image{c: c, out: make([]byte, 96), inodes: metaWriter{c: c}, dirs: metaWriter{c: c}}
+	root := tree()
+	img.number(root)
+	img.writeData(root)
+	img.flushFragment()
+	img.writeInodes(root, nil)
+	img.inodes.flush()
+	img.dirs.flush()
+
+	inodeTable := uint64(len(img.out))
+	img.out = append(img.out, img.inodes.out...)
+	dirTable := uint64(len(img.out))
+	img.out = append(img.out, img.dirs.out...)
+	fragTable := img.table(img.fragments)
+	idTable := img.table(le.AppendUint32(le.AppendUint32(nil, 0), 1000))
+	xattrTable := img.xattrs()
+
+	sb := le.AppendUint32(nil, 0x73717368)
+	sb = le.AppendUint32(sb, img.inodeCount)
+	sb = le.AppendUint32(sb, mtime)
+	sb = le.AppendUint32(sb, blockSize)
+	sb = le.AppendUint32(sb, img.fragCount)
+	sb = le.AppendUint16(sb, c.id)
+	sb = le.AppendUint16(sb, 12)
+	sb = le.AppendUint16(sb, 0)
+	sb = le.AppendUint16(sb, 2)
+	sb = le.AppendUint16(sb, 4)
+	sb = le.AppendUint16(sb, 0)
+	sb = le.AppendUint64(sb, root.ref)
+	sb = le.AppendUint64(sb, uint64(len(img.out)))
+	sb = le.AppendUint64(sb, idTable)
+	sb = le.AppendUint64(sb, xattrTable)
+	sb = le.AppendUint64(sb, inodeTable)
+	sb = le.AppendUint64(sb, dirTable)
+	sb = le.AppendUint64(sb, fragTable)
+	sb = le.AppendUint64(sb, ^uint64(0))
+	copy(img.out, sb)
+	return img.out
+}
+
+func main() {
+	for name, c := range compressors {
+		if err := os.WriteFile("testdata/"+name+".sqfs", write(c), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
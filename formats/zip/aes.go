@@ -0,0 +1,356 @@
+package zip
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Encryption chooses how entries are encrypted.
+type Encryption int
+
+const (
+	// EncryptNone leaves entries unencrypted.
+	EncryptNone Encryption = iota
+	// AES128, AES192 and AES256 use WinZip AES encryption with keys of
+	// that many bits.
+	AES128
+	AES192
+	AES256
+	// ZipCrypto is the traditional PKWARE encryption, which is easily
+	// broken and only meant for tools that know nothing else.
+	ZipCrypto
+)
+
+// ParseEncryption parses aes128, aes192 or aes256. ZipCrypto is not parsed,
+// so that it is only ever chosen on purpose.
+func ParseEncryption(s string) (Encryption, error) {
+	switch s {
+	case "aes128":
+		return AES128, nil
+	case "aes192":
+		return AES192, nil
+	case "", "aes256":
+		return AES256, nil
+	}
+	return 0, fmt.Errorf("unknown ZIP encryption %q; use aes256, aes192 or aes128", s)
+}
+
+const (
+	// methodAES marks WinZip AES entries, whose actual method is in the
+	// AES extra field.
+	methodAES            uint16 = 99
+	aesExtraID                  = 0x9901
+	versionAES                  = 51
+	flagEncrypted               = 0x0001
+	flagStrongEncryption        = 0x0040
+
+	aesIterations = 1000
+	aesVerifySize = 2
+	aesMACSize    = 10
+
+	// aesAE2Threshold is the size below which entries are written as AE-2,
+	// without a CRC, as WinZip does: the CRC of a short file gives too
+	// much of it away.
+	aesAE2Threshold = 20
+)
+
+var (
+	// ErrPassword is returned when an entry is encrypted and the password
+	// is missing or wrong.
+	ErrPassword = errors.New("zip: wrong password")
+	// ErrAuthentication is returned when encrypted content fails its
+	// authentication code, because it was damaged or tampered with.
+	ErrAuthentication = errors.New("zip: authentication failed; the encrypted data is damaged")
+)
+
+// AESExtra is the WinZip AES extra field of an encrypted entry.
+type AESExtra struct {
+	Version  int    // 1 for AE-1, 2 for AE-2, which has no CRC
+	Strength int    // 1, 2 or 3 for 128, 192 or 256 bit keys
+	Method   uint16 // compression method of the content
+}
+
+// ParseAESExtra finds the WinZip AES field in the extra fields of an entry.
+func ParseAESExtra(extra []byte) (AESExtra, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == aesExtraID && size >= 7 && bytes.Equal(extra[6:8], []byte("AE")) {
+			return AESExtra{
+				Version:  int(binary.LittleEndian.Uint16(extra[4:])),
+				Strength: int(extra[8]),
+				Method:   binary.LittleEndian.Uint16(extra[9:]),
+			}, true
+		}
+		extra = extra[4+size:]
+	}
+	return AESExtra{}, false
+}
+
+// IsAES reports whether an entry with this method is WinZip AES encrypted.
+func IsAES(method uint16) bool {
+	return method == methodAES
+}
+
+// IsStrongEncryption reports whether an entry with these flags uses PKWARE
+// strong encryption, which is not supported.
+func IsStrongEncryption(flags uint16) bool {
+	return flags&flagStrongEncryption != 0
+}
+
+// keySize returns the AES key size in bytes of a strength, or 0.
+func keySize(strength int) int {
+	switch strength {
+	case 1:
+		return 16
+	case 2:
+		return 24
+	case 3:
+		return 32
+	}
+	return 0
+}
+
+// appendAESExtra appends the WinZip AES extra field.
+func appendAESExtra(b []byte, x AESExtra) []byte {
+	b = binary.LittleEndian.AppendUint16(b, aesExtraID)
+	b = binary.LittleEndian.AppendUint16(b, 7)
+	b = binary.LittleEndian.AppendUint16(b, uint16(x.Version))
+	b = append(b, 'A', 'E', byte(x.Strength))
+	return binary.LittleEndian.AppendUint16(b, x.Method)
+}
+
+// deriveKeys derives the encryption key, the authentication key and the
+// password verifier from the password and salt.
+func deriveKeys(password string, salt []byte, size int) (key, macKey, verify []byte) {
+	dk := pbkdf2SHA1([]byte(password), salt, aesIterations, 2*size+aesVerifySize)
+	return dk[:size], dk[size : 2*size], dk[2*size:]
+}
+
+// pbkdf2SHA1 implements PBKDF2 from RFC 8018 with HMAC-SHA1.
+func pbkdf2SHA1(password, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var dk []byte
+	var counter [4]byte
+	for block := uint32(1); len(dk) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			subtle.XORBytes(t, t, u)
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:size]
+}
+
+// ctrStream is AES in counter mode as WinZip uses it: a little-endian
+// counter starting at one.
+type ctrStream struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newCTR(key []byte) (*ctrStream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ctrStream{block: block, used: aes.BlockSize}, nil
+}
+
+func (c *ctrStream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// aesWriter encrypts content and authenticates the encrypted result.
+type aesWriter struct {
+	w   io.Writer
+	ctr *ctrStream
+	mac hash.Hash
+	buf []byte
+}
+
+// newAESWriter writes the salt and password verifier to w, and returns a
+// writer that encrypts onto w.
+func newAESWriter(w io.Writer, password string, strength int) (*aesWriter, error) {
+	size := keySize(strength)
+	salt := make([]byte, size/2)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, macKey, verify := deriveKeys(password, salt, size)
+	ctr, err := newCTR(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(salt, verify...)); err != nil {
+		return nil, err
+	}
+	return &aesWriter{w: w, ctr: ctr, mac: hmac.New(sha1.New, macKey)}, nil
+}
+
+func (a *aesWriter) Write(p []byte) (int, error) {
+	if cap(a.buf) < len(p) {
+		a.buf = make([]byte, len(p))
+	}
+	buf := a.buf[:len(p)]
+	a.ctr.XORKeyStream(buf, p)
+	a.mac.Write(buf)
+	n, err := a.w.Write(buf)
+	if n < len(p) && err == nil {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// Close writes the authentication code.
+func (a *aesWriter) Close() error {
+	_, err := a.w.Write(a.mac.Sum(nil)[:aesMACSize])
+	return err
+}
+
+// NewAESReader returns the content of a WinZip AES entry from its raw
+// data of size bytes, as returned by archive/zip's File.OpenRaw. The
+// content is decrypted and decompressed, then checked against the
+// authentication code and, for AE-1 entries, the CRC once fully read.
+func NewAESReader(raw io.Reader, size int64, x AESExtra, crc uint32, password string) (io.ReadCloser, error) {
+	keyLen := keySize(x.Strength)
+	if keyLen == 0 {
+		return nil, fmt.Errorf("zip: unknown AES strength %d", x.Strength)
+	}
+	saltLen := keyLen / 2
+	dataSize := size - int64(saltLen+aesVerifySize+aesMACSize)
+	if dataSize < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	head := make([]byte, saltLen+aesVerifySize)
+	if _, err := io.ReadFull(raw, head); err != nil {
+		return nil, err
+	}
+	key, macKey, verify := deriveKeys(password, head[:saltLen], keyLen)
+	if !bytes.Equal(verify, head[saltLen:]) {
+		return nil, ErrPassword
+	}
+	ctr, err := newCTR(key)
+	if err != nil {
+		return nil, err
+	}
+	d := &aesReader{
+		r:   io.LimitReader(raw, dataSize),
+		raw: raw,
+		ctr: ctr,
+		mac: hmac.New(sha1.New, macKey),
+	}
+	return newContentReader(d, x.Method, crc, x.Version == 1)
+}
+
+// aesReader decrypts content and checks its authentication code at the end.
+type aesReader struct {
+	r   io.Reader // the encrypted data
+	raw io.Reader // followed by the authentication code
+	ctr *ctrStream
+	mac hash.Hash
+	err error
+}
+
+func (a *aesReader) Read(p []byte) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	n, err := a.r.Read(p)
+	a.mac.Write(p[:n])
+	a.ctr.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF {
+		code := make([]byte, aesMACSize)
+		if _, err := io.ReadFull(a.raw, code); err != nil {
+			a.err = err
+			return n, err
+		}
+		if !hmac.Equal(code, a.mac.Sum(nil)[:aesMACSize]) {
+			a.err = ErrAuthentication
+			return n, a.err
+		}
+	}
+	return n, err
+}
+
+// newContentReader decompresses decrypted data d, and checks the CRC of
+// the content when checkCRC is set.
+func newContentReader(d io.Reader, method uint16, crc uint32, checkCRC bool) (io.ReadCloser, error) {
+	var content io.ReadCloser
+	switch method {
+	case Store:
+		content = io.NopCloser(d)
+	case Deflate:
+		content = flate.NewReader(d)
+	default:
+		return nil, fmt.Errorf("zip: unsupported compression method %d", method)
+	}
+	return &checkReader{r: content, d: d, crc: crc32.NewIEEE(), want: crc, checkCRC: checkCRC}, nil
+}
+
+// checkReader reads decompressed content, making sure the decrypted data
+// was read to its end so that it is authenticated, and checks the CRC.
+type checkReader struct {
+	r        io.ReadCloser
+	d        io.Reader
+	crc      hash.Hash32
+	want     uint32
+	checkCRC bool
+}
+
+func (c *checkReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	if err == io.EOF {
+		// Compressed streams end before their data may have been read to EOF
+		if _, err := io.Copy(io.Discard, c.d); err != nil {
+			return n, err
+		}
+		if c.checkCRC && c.crc.Sum32() != c.want {
+			return n, errors.New("zip: checksum error")
+		}
+	}
+	return n, err
+}
+
+func (c *checkReader) Close() error {
+	return c.r.Close()
+}
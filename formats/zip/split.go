@@ -0,0 +1,265 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoEnd is returned for data without an end of central directory record.
+var ErrNoEnd = errors.New("zip: end of central directory not found")
+
+// maxEndSearch bounds the search for the end record, which may be followed
+// by a comment of up to 64 KiB.
+const maxEndSearch = 22 + uint16max
+
+// end holds the fields of the end of central directory records.
+type end struct {
+	disk      uint32 // of the end record, which is the last one
+	startDisk uint32 // of the central directory
+	records   uint64
+	size      uint64
+	start     uint64 // offset of the central directory in its disk
+}
+
+// Disks returns the number of volumes of the archive whose last volume is
+// r. It is one for archives that are not split.
+func Disks(r io.ReaderAt, size int64) (int, error) {
+	e, err := readEnd(r, 0, size)
+	if err != nil {
+		return 0, err
+	}
+	return int(e.disk) + 1, nil
+}
+
+// JoinSplit presents the volumes of a split archive, concatenated in r and
+// beginning at the offsets in starts, as an archive on a single disk that
+// archive/zip can read. Its central directory is rewritten with offsets from
+// the start of the first volume and appended after the last.
+func JoinSplit(r io.ReaderAt, starts []int64, size int64) (io.ReaderAt, int64, error) {
+	last := starts[len(starts)-1]
+	e, err := readEnd(r, last, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	if int(e.disk)+1 != len(starts) {
+		return nil, 0, fmt.Errorf("zip: archive has %d volumes, %d given", e.disk+1, len(starts))
+	}
+	if int(e.startDisk) >= len(starts) || e.size > uint64(size) {
+		return nil, 0, errCorruptDirectory
+	}
+	cdStart := starts[e.startDisk] + int64(e.start)
+	dir := make([]byte, e.size)
+	if _, err := r.ReadAt(dir, cdStart); err != nil {
+		return nil, 0, fmt.Errorf("zip: failed to read central directory: %w", err)
+	}
+
+	var out []byte
+	for i := uint64(0); i < e.records; i++ {
+		var n int
+		out, n, err = rebaseHeader(out, dir, starts)
+		if err != nil {
+			return nil, 0, err
+		}
+		dir = dir[n:]
+	}
+
+	// A fresh end record, with Zip64 records when the sizes need them
+	records, dirSize, dirStart := e.records, uint64(len(out)), uint64(size)
+	if records >= uint16max || dirSize >= uint32max || dirStart >= uint32max {
+		zip64End := dirStart + dirSize
+		out = binary.LittleEndian.AppendUint32(out, zip64EndSignature)
+		out = binary.LittleEndian.AppendUint64(out, 44)
+		out = binary.LittleEndian.AppendUint16(out, creatorUnix|versionZip64)
+		out = binary.LittleEndian.AppendUint16(out, versionZip64)
+		out = binary.LittleEndian.AppendUint32(out, 0)
+		out = binary.LittleEndian.AppendUint32(out, 0)
+		out = binary.LittleEndian.AppendUint64(out, records)
+		out = binary.LittleEndian.AppendUint64(out, records)
+		out = binary.LittleEndian.AppendUint64(out, dirSize)
+		out = binary.LittleEndian.AppendUint64(out, dirStart)
+		out = binary.LittleEndian.AppendUint32(out, zip64LocatorSignature)
+		out = binary.LittleEndian.AppendUint32(out, 0)
+		out = binary.LittleEndian.AppendUint64(out, zip64End)
+		out = binary.LittleEndian.AppendUint32(out, 1)
+	}
+	out = binary.LittleEndian.AppendUint32(out, endSignature)
+	out = binary.LittleEndian.AppendUint16(out, 0)
+	out = binary.LittleEndian.AppendUint16(out, 0)
+	out = binary.LittleEndian.AppendUint16(out, uint16(min(records, uint16max)))
+	out = binary.LittleEndian.AppendUint16(out, uint16(min(records, uint16max)))
+	out = binary.LittleEndian.AppendUint32(out, uint32(min(dirSize, uint32max)))
+	out = binary.LittleEndian.AppendUint32(out, uint32(min(dirStart, uint32max)))
+	out = binary.LittleEndian.AppendUint16(out, 0)
+
+	joined := &concatReader{
+		parts:  []io.ReaderAt{io.NewSectionReader(r, 0, size), bytes.NewReader(out)},
+		starts: []int64{0, size},
+		size:   size + int64(len(out)),
+	}
+	return joined, joined.size, nil
+}
+
+var errCorruptDirectory = errors.New("zip: corrupt central directory")
+
+// rebaseHeader appends the central directory record at the start of dir
+// to out, moved to disk zero with its local header offset made absolute.
+// It returns the length of the record in dir.
+func rebaseHeader(out, dir []byte, starts []int64) ([]byte, int, error) {
+	if len(dir) < 46 || binary.LittleEndian.Uint32(dir) != centralHeaderSignature {
+		return nil, 0, errCorruptDirectory
+	}
+	nameLen := int(binary.LittleEndian.Uint16(dir[28:]))
+	extraLen := int(binary.LittleEndian.Uint16(dir[30:]))
+	commentLen := int(binary.LittleEndian.Uint16(dir[32:]))
+	n := 46 + nameLen + extraLen + commentLen
+	if len(dir) < n {
+		return nil, 0, errCorruptDirectory
+	}
+	compressedSize := uint64(binary.LittleEndian.Uint32(dir[20:]))
+	size := uint64(binary.LittleEndian.Uint32(dir[24:]))
+	disk := uint64(binary.LittleEndian.Uint16(dir[34:]))
+	offset := uint64(binary.LittleEndian.Uint32(dir[42:]))
+
+	// Fields at their maximum continue in the Zip64 extra, in this order;
+	// other extras are kept
+	var extra []byte
+	for rest := dir[46+nameLen : 46+nameLen+extraLen]; len(rest) >= 4; {
+		id := binary.LittleEndian.Uint16(rest)
+		l := int(binary.LittleEndian.Uint16(rest[2:]))
+		if len(rest) < 4+l {
+			break
+		}
+		field := rest[4 : 4+l]
+		if id != zip64ExtraID {
+			extra = append(extra, rest[:4+l]...)
+		} else {
+			for _, v := range []*uint64{&size, &compressedSize, &offset} {
+				if *v == uint32max && len(field) >= 8 {
+					*v = binary.LittleEndian.Uint64(field)
+					field = field[8:]
+				}
+			}
+			if disk == uint16max && len(field) >= 4 {
+				disk = uint64(binary.LittleEndian.Uint32(field))
+			}
+		}
+		rest = rest[4+l:]
+	}
+	if disk >= uint64(len(starts)) {
+		return nil, 0, fmt.Errorf("zip: entry on volume %d of %d", disk+1, len(starts))
+	}
+	offset += uint64(starts[disk])
+
+	var zip64 []byte
+	for _, v := range []*uint64{&size, &compressedSize, &offset} {
+		if *v >= uint32max {
+			zip64 = binary.LittleEndian.AppendUint64(zip64, *v)
+			*v = uint32max
+		}
+	}
+	if zip64 != nil {
+		z := binary.LittleEndian.AppendUint16(nil, zip64ExtraID)
+		z = binary.LittleEndian.AppendUint16(z, uint16(len(zip64)))
+		extra = append(append(z, zip64...), extra...)
+	}
+	if len(extra) > uint16max {
+		return nil, 0, errCorruptDirectory
+	}
+
+	header := append([]byte(nil), dir[:46]...)
+	binary.LittleEndian.PutUint32(header[20:], uint32(compressedSize))
+	binary.LittleEndian.PutUint32(header[24:], uint32(size))
+	binary.LittleEndian.PutUint16(header[30:], uint16(len(extra)))
+	binary.LittleEndian.PutUint16(header[34:], 0)
+	binary.LittleEndian.PutUint32(header[42:], uint32(offset))
+	out = append(out, header...)
+	out = append(out, dir[46:46+nameLen]...)
+	out = append(out, extra...)
+	out = append(out, dir[46+nameLen+extraLen:n]...)
+	return out, n, nil
+}
+
+// readEnd finds the end records in the part of r from base to size.
+func readEnd(r io.ReaderAt, base, size int64) (*end, error) {
+	tailStart := max(base, size-maxEndSearch)
+	tail := make([]byte, size-tailStart)
+	if _, err := r.ReadAt(tail, tailStart); err != nil && err != io.EOF {
+		return nil, err
+	}
+	i := len(tail) - 22
+	for ; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == endSignature &&
+			i+22+int(binary.LittleEndian.Uint16(tail[i+20:])) <= len(tail) {
+			break
+		}
+	}
+	if i < 0 {
+		return nil, ErrNoEnd
+	}
+	b := tail[i:]
+	e := &end{
+		disk:      uint32(binary.LittleEndian.Uint16(b[4:])),
+		startDisk: uint32(binary.LittleEndian.Uint16(b[6:])),
+		records:   uint64(binary.LittleEndian.Uint16(b[10:])),
+		size:      uint64(binary.LittleEndian.Uint32(b[12:])),
+		start:     uint64(binary.LittleEndian.Uint32(b[16:])),
+	}
+	endOffset := tailStart + int64(i)
+
+	// A Zip64 locator right before points to the Zip64 end record
+	if endOffset-20 < base {
+		return e, nil
+	}
+	loc := make([]byte, 20)
+	if _, err := r.ReadAt(loc, endOffset-20); err != nil || binary.LittleEndian.Uint32(loc) != zip64LocatorSignature {
+		return e, nil
+	}
+	// The Zip64 end record is on the same, last, disk
+	zip64Offset := base + int64(binary.LittleEndian.Uint64(loc[8:]))
+	b = make([]byte, 56)
+	if _, err := r.ReadAt(b, zip64Offset); err != nil || binary.LittleEndian.Uint32(b) != zip64EndSignature {
+		return nil, errCorruptDirectory
+	}
+	e.disk = binary.LittleEndian.Uint32(b[16:])
+	e.startDisk = binary.LittleEndian.Uint32(b[20:])
+	e.records = binary.LittleEndian.Uint64(b[32:])
+	e.size = binary.LittleEndian.Uint64(b[40:])
+	e.start = binary.LittleEndian.Uint64(b[48:])
+	return e, nil
+}
+
+// concatReader reads parts as if they were one.
+type concatReader struct {
+	parts  []io.ReaderAt
+	starts []int64
+	size   int64
+}
+
+func (c *concatReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("zip: negative offset")
+	}
+	n := 0
+	for i, part := range c.parts {
+		end := c.size
+		if i+1 < len(c.starts) {
+			end = c.starts[i+1]
+		}
+		pos := off + int64(n)
+		if n == len(p) || pos >= end {
+			continue
+		}
+		m, err := part.ReadAt(p[n:n+int(min(int64(len(p)-n), end-pos))], pos-c.starts[i])
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,670 @@
+// Package zip writes ZIP archives with control over Zip64 records, both to
+// files and to outputs that cannot seek, and split archives across volumes.
+// Entries may be encrypted with WinZip AES or, for old tools, ZipCrypto,
+// both of which it also decrypts.
+package zip
+
+import (
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Compression methods.
+const (
+	Store   uint16 = 0
+	Deflate uint16 = 8
+)
+
+// Zip64Mode chooses when Zip64 records are written.
+type Zip64Mode int
+
+const (
+	// Zip64Auto writes Zip64 records where sizes, offsets or counts need them.
+	Zip64Auto Zip64Mode = iota
+	// Zip64Always writes Zip64 records for every entry and the archive end.
+	Zip64Always
+	// Zip64Never fails rather than write Zip64 records, for old readers.
+	Zip64Never
+)
+
+// ParseZip64Mode parses auto, always or never.
+func ParseZip64Mode(s string) (Zip64Mode, error) {
+	switch s {
+	case "", "auto":
+		return Zip64Auto, nil
+	case "always":
+		return Zip64Always, nil
+	case "never":
+		return Zip64Never, nil
+	}
+	return 0, fmt.Errorf("unknown Zip64 mode %q; use auto, always or never", s)
+}
+
+const (
+	localHeaderSignature     = 0x04034b50
+	dataDescriptorSignature  = 0x08074b50
+	centralHeaderSignature   = 0x02014b50
+	endSignature             = 0x06054b50
+	zip64EndSignature        = 0x06064b50
+	zip64LocatorSignature    = 0x07064b50
+	zip64ExtraID             = 0x0001
+	extendedTimestampExtraID = 0x5455
+
+	uint16max = 0xFFFF
+	uint32max = 0xFFFFFFFF
+
+	flagDataDescriptor = 0x0008
+	flagUTF8           = 0x0800
+
+	versionDefault = 20
+	versionZip64   = 45
+	creatorUnix    = 3 << 8
+
+	// zip64Margin covers deflate output outgrowing its input, so that
+	// entries close to 4 GiB are given Zip64 records before writing.
+	zip64Margin = 1 << 24
+)
+
+// ErrZip64Required is returned in Zip64Never mode for archives that cannot
+// be written without Zip64 records.
+var ErrZip64Required = errors.New("zip: archive needs Zip64 records, which the Zip64 mode forbids")
+
+// FileHeader describes an entry to write.
+type FileHeader struct {
+	Name     string // slash separated; directories end with a slash
+	Method   uint16
+	Modified time.Time
+	Mode     fs.FileMode
+
+	// Size is the expected uncompressed size, or -1 when unknown. Entries
+	// that may reach 4 GiB get Zip64 local records, which cannot be added
+	// once their data is written.
+	Size int64
+}
+
+// Splitter is an output divided into volumes of limited size. Archives
+// written to a Splitter are split archives, whose records name the volume
+// ("disk") they are on.
+type Splitter interface {
+	io.Writer
+	// Reserve moves on to the next volume unless n more bytes fit in the
+	// current one.
+	Reserve(n int64) error
+	// Position returns the volume the next byte goes to, from zero, and its
+	// offset in that volume.
+	Position() (int, int64)
+}
+
+// WriterOptions are the settings of a Writer.
+type WriterOptions struct {
+	Zip64 Zip64Mode
+	Level int // deflate level, or -1 for the default
+
+	// Encryption encrypts every entry but directories and symbolic links,
+	// whose targets other readers expect in the clear, with Password.
+	Encryption Encryption
+	Password   string
+}
+
+// Writer writes a ZIP archive.
+type Writer struct {
+	w       io.Writer
+	seeker  io.WriteSeeker // nil when the output cannot seek
+	split   Splitter       // nil unless writing a split archive
+	start   int64          // output position of the archive start
+	offset  int64          // bytes written
+	opts    WriterOptions
+	dir     []*entry
+	current *entryWriter
+	closed  bool
+}
+
+type entry struct {
+	FileHeader
+	flags          uint16
+	crc            uint32
+	compressedSize uint64
+	size           uint64
+	disk           uint32
+	offset         uint64 // in its disk
+	zip64          bool   // Zip64 local records
+	aes            *AESExtra
+	extra          []byte
+}
+
+// method returns the method recorded in headers, which is that of AES for
+// encrypted entries.
+func (e *entry) method() uint16 {
+	if e.aes != nil {
+		return methodAES
+	}
+	return e.Method
+}
+
+// version returns the version needed to extract the entry.
+func (e *entry) version(zip64 bool) uint16 {
+	switch {
+	case e.aes != nil:
+		return versionAES
+	case zip64:
+		return versionZip64
+	}
+	return versionDefault
+}
+
+// NewWriter returns a Writer on w. Outputs that can seek get sizes patched
+// into local headers; others get data descriptors. A Splitter gets a split
+// archive.
+func NewWriter(w io.Writer, opts WriterOptions) *Writer {
+	zw := &Writer{w: w, opts: opts}
+	if s, ok := w.(Splitter); ok {
+		zw.split = s
+	} else if s, ok := w.(io.WriteSeeker); ok {
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			zw.seeker, zw.start = s, pos
+		}
+	}
+	return zw
+}
+
+// Create adds an entry and returns a writer for its content, valid until
+// the next call to Create or Close.
+func (w *Writer) Create(fh *FileHeader) (io.Writer, error) {
+	if w.closed {
+		return nil, errors.New("zip: writer is closed")
+	}
+	if err := w.closeEntry(); err != nil {
+		return nil, err
+	}
+	if len(fh.Name) > uint16max {
+		return nil, fmt.Errorf("zip: name of %.40s... is too long", fh.Name)
+	}
+	if w.opts.Encryption != EncryptNone && w.opts.Password == "" {
+		return nil, errors.New("zip: encryption needs a password")
+	}
+
+	if err := w.startSplit(); err != nil {
+		return nil, err
+	}
+
+	e := &entry{FileHeader: *fh}
+	if e.Mode.IsDir() {
+		e.Method = Store
+	}
+	if !isASCII(e.Name) && utf8.ValidString(e.Name) {
+		e.flags |= flagUTF8
+	}
+	if w.seeker == nil {
+		e.flags |= flagDataDescriptor
+	}
+	switch w.opts.Zip64 {
+	case Zip64Always:
+		e.zip64 = true
+	case Zip64Auto:
+		e.zip64 = e.Size < 0 || e.Size >= uint32max-zip64Margin
+	case Zip64Never:
+		if e.Size >= uint32max {
+			return nil, fmt.Errorf("%w: %s is larger than 4 GiB", ErrZip64Required, e.Name)
+		}
+	}
+	if !e.Modified.IsZero() {
+		// Extended timestamp: flags, then the modification time
+		e.extra = binary.LittleEndian.AppendUint16(e.extra, extendedTimestampExtraID)
+		e.extra = binary.LittleEndian.AppendUint16(e.extra, 5)
+		e.extra = append(e.extra, 1)
+		e.extra = binary.LittleEndian.AppendUint32(e.extra, uint32(e.Modified.Unix()))
+	}
+	switch {
+	case w.opts.Encryption == EncryptNone || !e.Mode.IsRegular():
+	case w.opts.Encryption == ZipCrypto:
+		// The password check byte comes from the modification time, since
+		// the CRC is not known yet, which needs a data descriptor
+		e.flags |= flagEncrypted | flagDataDescriptor
+	default:
+		// Strengths number the key sizes as Encryption does
+		e.aes = &AESExtra{Version: 1, Strength: int(w.opts.Encryption), Method: e.Method}
+		if e.Size >= 0 && e.Size < aesAE2Threshold {
+			e.aes.Version = 2
+		}
+		e.flags |= flagEncrypted
+		e.extra = appendAESExtra(e.extra, *e.aes)
+	}
+
+	if err := w.writeLocalHeader(e); err != nil {
+		return nil, err
+	}
+	ew := &entryWriter{zw: w, e: e, crc: crc32.NewIEEE()}
+	ew.compressed.w = w
+	var out io.Writer = &ew.compressed
+	switch {
+	case e.aes != nil:
+		aw, err := newAESWriter(&ew.compressed, w.opts.Password, e.aes.Strength)
+		if err != nil {
+			return nil, err
+		}
+		ew.aes, out = aw, aw
+	case e.flags&flagEncrypted != 0:
+		zw, err := newZipCryptoWriter(&ew.compressed, w.opts.Password, byte(dosTime(e.Modified)>>8))
+		if err != nil {
+			return nil, err
+		}
+		out = zw
+	}
+	switch e.Method {
+	case Store:
+		ew.out = out
+	case Deflate:
+		level := w.opts.Level
+		if level < 0 {
+			level = flate.DefaultCompression
+		}
+		fw, err := flate.NewWriter(out, level)
+		if err != nil {
+			return nil, err
+		}
+		ew.out, ew.flate = fw, fw
+	default:
+		return nil, fmt.Errorf("zip: unsupported compression method %d", e.Method)
+	}
+	w.current = ew
+	w.dir = append(w.dir, e)
+	return ew, nil
+}
+
+func (w *Writer) writeLocalHeader(e *entry) error {
+	extra := e.extra
+	if e.zip64 {
+		// Sizes follow once known: in the data descriptor, or patched here
+		z := binary.LittleEndian.AppendUint16(nil, zip64ExtraID)
+		z = binary.LittleEndian.AppendUint16(z, 16)
+		z = append(z, make([]byte, 16)...)
+		extra = append(z, extra...)
+	}
+	b := make([]byte, 0, 30+len(e.Name)+len(extra))
+	b = binary.LittleEndian.AppendUint32(b, localHeaderSignature)
+	b = binary.LittleEndian.AppendUint16(b, e.version(e.zip64))
+	b = binary.LittleEndian.AppendUint16(b, e.flags)
+	b = binary.LittleEndian.AppendUint16(b, e.method())
+	b = appendDOSTime(b, e.Modified)
+	b = binary.LittleEndian.AppendUint32(b, 0) // CRC, patched or in the descriptor
+	if e.zip64 {
+		b = binary.LittleEndian.AppendUint32(b, uint32max)
+		b = binary.LittleEndian.AppendUint32(b, uint32max)
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(e.Name)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(extra)))
+	b = append(b, e.Name...)
+	b = append(b, extra...)
+
+	disk, offset, err := w.reserve(len(b))
+	if err != nil {
+		return err
+	}
+	e.disk, e.offset = disk, uint64(offset)
+	if w.opts.Zip64 == Zip64Never && e.offset >= uint32max {
+		return fmt.Errorf("%w: %s starts beyond 4 GiB", ErrZip64Required, e.Name)
+	}
+	return w.write(b)
+}
+
+// startSplit begins split archives with the signature of data descriptors.
+func (w *Writer) startSplit() error {
+	if w.split == nil || w.offset != 0 {
+		return nil
+	}
+	return w.write(binary.LittleEndian.AppendUint32(nil, dataDescriptorSignature))
+}
+
+// reserve keeps a record of n bytes from being split across volumes, and
+// returns the volume and offset it will be written at.
+func (w *Writer) reserve(n int) (uint32, int64, error) {
+	if w.split == nil {
+		return 0, w.offset, nil
+	}
+	if err := w.split.Reserve(int64(n)); err != nil {
+		return 0, 0, err
+	}
+	disk, offset := w.split.Position()
+	if disk >= uint16max {
+		return 0, 0, errors.New("zip: too many volumes; use a larger volume size")
+	}
+	return uint32(disk), offset, nil
+}
+
+// closeEntry finishes the current entry, recording its sizes.
+func (w *Writer) closeEntry() error {
+	ew := w.current
+	if ew == nil {
+		return nil
+	}
+	w.current = nil
+	if ew.flate != nil {
+		if err := ew.flate.Close(); err != nil {
+			return err
+		}
+	}
+	if ew.aes != nil {
+		if err := ew.aes.Close(); err != nil {
+			return err
+		}
+	}
+	e := ew.e
+	e.crc = ew.crc.Sum32()
+	if e.aes != nil && e.aes.Version == 2 {
+		// AE-2 entries rely on the authentication code instead
+		e.crc = 0
+	}
+	e.size = uint64(ew.size)
+	e.compressedSize = uint64(ew.compressed.n)
+	if ew.compressed.err != nil {
+		return ew.compressed.err
+	}
+	if !e.zip64 && (e.size >= uint32max || e.compressedSize >= uint32max) {
+		if w.opts.Zip64 == Zip64Never {
+			return fmt.Errorf("%w: %s is larger than 4 GiB", ErrZip64Required, e.Name)
+		}
+		return fmt.Errorf("zip: %s grew past 4 GiB while being written; use Zip64 mode always", e.Name)
+	}
+
+	if e.flags&flagDataDescriptor != 0 {
+		b := binary.LittleEndian.AppendUint32(nil, dataDescriptorSignature)
+		b = binary.LittleEndian.AppendUint32(b, e.crc)
+		if e.zip64 {
+			b = binary.LittleEndian.AppendUint64(b, e.compressedSize)
+			b = binary.LittleEndian.AppendUint64(b, e.size)
+		} else {
+			b = binary.LittleEndian.AppendUint32(b, uint32(e.compressedSize))
+			b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+		}
+		if _, _, err := w.reserve(len(b)); err != nil {
+			return err
+		}
+		return w.write(b)
+	}
+	return w.patchLocalHeader(e)
+}
+
+// patchLocalHeader fills in the CRC and sizes of a local header.
+func (w *Writer) patchLocalHeader(e *entry) error {
+	var fields []byte
+	fields = binary.LittleEndian.AppendUint32(fields, e.crc)
+	if !e.zip64 {
+		fields = binary.LittleEndian.AppendUint32(fields, uint32(e.compressedSize))
+		fields = binary.LittleEndian.AppendUint32(fields, uint32(e.size))
+	}
+	if err := w.writeAt(fields, int64(e.offset)+14); err != nil {
+		return err
+	}
+	if e.zip64 {
+		sizes := binary.LittleEndian.AppendUint64(nil, e.size)
+		sizes = binary.LittleEndian.AppendUint64(sizes, e.compressedSize)
+		if err := w.writeAt(sizes, int64(e.offset)+30+int64(len(e.Name))+4); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeAt(b []byte, off int64) error {
+	if _, err := w.seeker.Seek(w.start+off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.seeker.Write(b); err != nil {
+		return err
+	}
+	_, err := w.seeker.Seek(w.start+w.offset, io.SeekStart)
+	return err
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+// Close finishes the last entry and writes the central directory. It does
+// not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.closeEntry(); err != nil {
+		return err
+	}
+	if err := w.startSplit(); err != nil {
+		return err
+	}
+
+	startOffset := w.offset
+	var startDisk, lastDisk uint32
+	var start int64
+	var diskRecords uint64 // central directory records on the last disk
+	anyZip64 := w.opts.Zip64 == Zip64Always
+	for i, e := range w.dir {
+		zip64 := e.zip64 || e.size >= uint32max || e.compressedSize >= uint32max || e.offset >= uint32max
+		if zip64 {
+			if w.opts.Zip64 == Zip64Never {
+				return fmt.Errorf("%w: %s starts beyond 4 GiB", ErrZip64Required, e.Name)
+			}
+			anyZip64 = true
+		}
+		header := w.centralHeader(e, zip64)
+		disk, offset, err := w.reserve(len(header))
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			startDisk, start = disk, offset
+		}
+		if disk != lastDisk {
+			lastDisk, diskRecords = disk, 0
+		}
+		diskRecords++
+		if err := w.write(header); err != nil {
+			return err
+		}
+	}
+	size := w.offset - startOffset
+	records := uint64(len(w.dir))
+	if len(w.dir) == 0 {
+		startDisk, start, _ = w.reserve(0)
+	}
+
+	// The end records go together on the last disk
+	var b []byte
+	const endSize, zip64EndSize = 22, 56 + 20
+	needZip64 := anyZip64 || records >= uint16max || size >= uint32max || start >= uint32max
+	reserved := endSize
+	if needZip64 {
+		reserved += zip64EndSize
+	}
+	disk, end, err := w.reserve(reserved)
+	if err != nil {
+		return err
+	}
+	if disk != lastDisk {
+		diskRecords = 0
+	}
+
+	if needZip64 {
+		if w.opts.Zip64 == Zip64Never {
+			return fmt.Errorf("%w: %d entries, central directory at offset %d", ErrZip64Required, records, start)
+		}
+		b = binary.LittleEndian.AppendUint32(b, zip64EndSignature)
+		b = binary.LittleEndian.AppendUint64(b, 44) // size of the rest of the record
+		b = binary.LittleEndian.AppendUint16(b, creatorUnix|versionZip64)
+		b = binary.LittleEndian.AppendUint16(b, versionZip64)
+		b = binary.LittleEndian.AppendUint32(b, disk)
+		b = binary.LittleEndian.AppendUint32(b, startDisk)
+		b = binary.LittleEndian.AppendUint64(b, diskRecords)
+		b = binary.LittleEndian.AppendUint64(b, records)
+		b = binary.LittleEndian.AppendUint64(b, uint64(size))
+		b = binary.LittleEndian.AppendUint64(b, uint64(start))
+
+		b = binary.LittleEndian.AppendUint32(b, zip64LocatorSignature)
+		b = binary.LittleEndian.AppendUint32(b, disk) // disk of the Zip64 end record
+		b = binary.LittleEndian.AppendUint64(b, uint64(end))
+		b = binary.LittleEndian.AppendUint32(b, disk+1) // total disks
+		if w.opts.Zip64 == Zip64Always {
+			diskRecords, records, size, start = uint16max, uint16max, uint32max, uint32max
+		}
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, endSignature)
+	b = binary.LittleEndian.AppendUint16(b, uint16(disk))
+	b = binary.LittleEndian.AppendUint16(b, uint16(startDisk))
+	b = binary.LittleEndian.AppendUint16(b, uint16(min(diskRecords, uint16max)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(min(records, uint16max)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(min(size, uint32max)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(min(start, uint32max)))
+	b = binary.LittleEndian.AppendUint16(b, 0) // comment length
+	return w.write(b)
+}
+
+// centralHeader encodes the central directory record of e.
+func (w *Writer) centralHeader(e *entry, zip64 bool) []byte {
+	extra := e.extra
+	compressedSize, size, offset := uint32(e.compressedSize), uint32(e.size), uint32(e.offset)
+	if zip64 {
+		// Once present, the Zip64 extra holds every field
+		z := binary.LittleEndian.AppendUint16(nil, zip64ExtraID)
+		z = binary.LittleEndian.AppendUint16(z, 24)
+		z = binary.LittleEndian.AppendUint64(z, e.size)
+		z = binary.LittleEndian.AppendUint64(z, e.compressedSize)
+		z = binary.LittleEndian.AppendUint64(z, e.offset)
+		extra = append(z, extra...)
+		compressedSize, size, offset = uint32max, uint32max, uint32max
+	}
+
+	b := make([]byte, 0, 46+len(e.Name)+len(extra))
+	b = binary.LittleEndian.AppendUint32(b, centralHeaderSignature)
+	b = binary.LittleEndian.AppendUint16(b, creatorUnix|max(e.version(zip64), versionZip64))
+	b = binary.LittleEndian.AppendUint16(b, e.version(zip64))
+	b = binary.LittleEndian.AppendUint16(b, e.flags)
+	b = binary.LittleEndian.AppendUint16(b, e.method())
+	b = appendDOSTime(b, e.Modified)
+	b = binary.LittleEndian.AppendUint32(b, e.crc)
+	b = binary.LittleEndian.AppendUint32(b, compressedSize)
+	b = binary.LittleEndian.AppendUint32(b, size)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(e.Name)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(extra)))
+	b = binary.LittleEndian.AppendUint16(b, 0) // comment length
+	b = binary.LittleEndian.AppendUint16(b, uint16(e.disk))
+	b = binary.LittleEndian.AppendUint16(b, 0) // internal attributes
+	b = binary.LittleEndian.AppendUint32(b, externalAttributes(e.Mode))
+	b = binary.LittleEndian.AppendUint32(b, offset)
+	b = append(b, e.Name...)
+	return append(b, extra...)
+}
+
+// entryWriter compresses and counts the content of an entry.
+type entryWriter struct {
+	zw         *Writer
+	e          *entry
+	out        io.Writer
+	flate      *flate.Writer
+	aes        *aesWriter
+	crc        hash.Hash32
+	size       int64
+	compressed countWriter
+}
+
+func (ew *entryWriter) Write(p []byte) (int, error) {
+	if ew.zw.current != ew {
+		return 0, errors.New("zip: write to closed entry")
+	}
+	ew.crc.Write(p)
+	ew.size += int64(len(p))
+	return ew.out.Write(p)
+}
+
+// countWriter counts the compressed bytes written to the archive.
+type countWriter struct {
+	w   *Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	start := c.w.offset
+	c.err = c.w.write(p)
+	n := int(c.w.offset - start)
+	c.n += int64(n)
+	return n, c.err
+}
+
+// externalAttributes stores a Unix mode in the high 16 bits, and the MS-DOS
+// directory and read-only bits in the low ones.
+func externalAttributes(mode fs.FileMode) uint32 {
+	unix := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		unix |= 0040000
+	case mode&fs.ModeSymlink != 0:
+		unix |= 0120000
+	default:
+		unix |= 0100000
+	}
+	if mode&fs.ModeSetuid != 0 {
+		unix |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		unix |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		unix |= 01000
+	}
+	attr := unix << 16
+	if mode.IsDir() {
+		attr |= 0x10
+	}
+	if mode&0200 == 0 {
+		attr |= 0x01
+	}
+	return attr
+}
+
+// appendDOSTime appends an MS-DOS time and date, which cover 1980 to 2107.
+func appendDOSTime(b []byte, t time.Time) []byte {
+	t = dosRange(t)
+	dosDate := uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+	b = binary.LittleEndian.AppendUint16(b, dosTime(t))
+	return binary.LittleEndian.AppendUint16(b, dosDate)
+}
+
+// dosTime returns the MS-DOS time of day of t.
+func dosTime(t time.Time) uint16 {
+	t = dosRange(t)
+	return uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()>>1)
+}
+
+// dosRange clamps t to the MS-DOS date range.
+func dosRange(t time.Time) time.Time {
+	if t.IsZero() || t.Year() < 1980 {
+		return time.Date(1980, 1, 1, 0, 0, 0, 0, time.Local)
+	}
+	if t.Year() > 2107 {
+		return time.Date(2107, 12, 31, 23, 59, 58, 0, time.Local)
+	}
+	return t
+}
+
+func isASCII(s string) bool {
+	return !strings.ContainsFunc(s, func(r rune) bool { return r >= utf8.RuneSelf })
+}
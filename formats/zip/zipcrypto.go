@@ -0,0 +1,114 @@
+package zip
+
+import (
+	"crypto/rand"
+	"hash/crc32"
+	"io"
+)
+
+// zipCryptoHeaderSize is the size of the encryption header that starts the
+// data of ZipCrypto entries.
+const zipCryptoHeaderSize = 12
+
+// zipCryptoKeys is the state of the traditional PKWARE stream cipher.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	k := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+	return k
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ k[0]>>8
+	k[1] = (k[1]+k[0]&0xFF)*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ k[2]>>8
+}
+
+func (k *zipCryptoKeys) stream() byte {
+	t := k[2] | 2
+	return byte(t * (t ^ 1) >> 8)
+}
+
+func (k *zipCryptoKeys) encrypt(dst, src []byte) {
+	for i, b := range src {
+		dst[i] = b ^ k.stream()
+		k.update(b)
+	}
+}
+
+func (k *zipCryptoKeys) decrypt(dst, src []byte) {
+	for i, b := range src {
+		dst[i] = b ^ k.stream()
+		k.update(dst[i])
+	}
+}
+
+// zipCryptoWriter encrypts content with ZipCrypto.
+type zipCryptoWriter struct {
+	w    io.Writer
+	keys *zipCryptoKeys
+	buf  []byte
+}
+
+// newZipCryptoWriter writes the encryption header to w, ending with check,
+// and returns a writer that encrypts onto w.
+func newZipCryptoWriter(w io.Writer, password string, check byte) (*zipCryptoWriter, error) {
+	header := make([]byte, zipCryptoHeaderSize)
+	if _, err := rand.Read(header[:zipCryptoHeaderSize-1]); err != nil {
+		return nil, err
+	}
+	header[zipCryptoHeaderSize-1] = check
+	zw := &zipCryptoWriter{w: w, keys: newZipCryptoKeys(password)}
+	if _, err := zw.Write(header); err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
+
+func (z *zipCryptoWriter) Write(p []byte) (int, error) {
+	if cap(z.buf) < len(p) {
+		z.buf = make([]byte, len(p))
+	}
+	buf := z.buf[:len(p)]
+	z.keys.encrypt(buf, p)
+	n, err := z.w.Write(buf)
+	if n < len(p) && err == nil {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// NewZipCryptoReader returns the content of a ZipCrypto entry from its raw
+// data, as returned by archive/zip's File.OpenRaw. The password is checked
+// against check, the last byte of the encryption header: the high byte of
+// the CRC, or of the MS-DOS modification time for entries with a data
+// descriptor. With one byte to check, a wrong password passes one time in
+// 256, which the CRC checked once the content is fully read then catches.
+func NewZipCryptoReader(raw io.Reader, method uint16, crc uint32, check byte, password string) (io.ReadCloser, error) {
+	header := make([]byte, zipCryptoHeaderSize)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+	keys := newZipCryptoKeys(password)
+	keys.decrypt(header, header)
+	if header[zipCryptoHeaderSize-1] != check {
+		return nil, ErrPassword
+	}
+	d := &zipCryptoReader{r: raw, keys: keys}
+	return newContentReader(d, method, crc, true)
+}
+
+// zipCryptoReader decrypts content.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.keys.decrypt(p[:n], p[:n])
+	return n, err
+}
@@ -4,8 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"futile/archive"
+	"futile/utils"
 	"log"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // Print help message
@@ -13,10 +16,53 @@ func printUsage() {
 	fmt.Println(`Usage: futile [options]
 
 Options:
-  -o, --operation      Operation to perform ('create' or 'extract')
+  -o, --operation      Operation to perform ('create', 'extract', 'list', 'info', 'train' or
+                       'flatten', which merges the layers of a container image tarball)
   -i, --input          Archive input (for extraction or creation)
   -d, --destination    Destination directory or path for extraction or archive creation
   -p, --password       Password for password-protected archives
+  -l, --level          Compression level for compressed formats (default: format default)
+      --threads        Compression threads for .zst archives (default: 1)
+      --long           Long-distance matching with a 128 MiB window for .zst archives
+      --dict           Zstandard dictionary to compress or decompress with
+      --dict-size      Size of the dictionary built by 'train' (default: 112640)
+      --block-size     LZ4 block size: 64K, 256K, 1M or 4M (default: 4M)
+      --block-checksum Add a checksum to every LZ4 block
+      --no-content-checksum
+                       Omit the LZ4 checksum of the whole content
+      --dependent-blocks
+                       Let LZ4 blocks reference earlier blocks for better compression
+      --tar-format     Flavor of tar archives: ustar, pax or gnu (default: pax)
+      --cpio-format    Header format for .cpio archives: newc, crc or odc (default: newc)
+      --ar-format      Long name style for .a archives: gnu or bsd (default: gnu);
+                       only gnu archives get a symbol index, run ranlib on bsd ones
+      --deterministic  Zero timestamps and owners in .a archives and .deb packages
+      --control        Control file for .deb packages (default: DEBIAN/control in the input)
+      --deb-compression
+                       Compression of .deb tarballs: gz, xz, zst or none (default: xz)
+      --volume-label   Volume label of .iso images (default: CDROM)
+      --rar-format     RAR format version of .rar archives: 4 or 5 (default: 5)
+      --recovery-record
+                       Add a recovery record of this percentage to .rar archives
+      --volume-size    Split .zip, .7z, .rar and tar archives into volumes of this size, e.g. 4G
+      --lock           Lock .rar archives against further changes
+      --zip64          Zip64 records in .zip archives: auto, always or never (default: auto)
+      --zip-encryption AES key size of password-protected .zip archives: aes256, aes192
+                       or aes128 (default: aes256)
+      --legacy-zipcrypto
+                       Encrypt .zip archives with the weak ZipCrypto instead of AES, for
+                       tools that cannot read anything else
+      --main-class     Main-Class of .jar, .war and .ear archives, e.g. com.example.Main
+      --class-path     Class-Path of Java archives: space-separated relative URLs
+      --manifest-attr  Further manifest attribute of Java archives as Name=Value; repeatable
+      --sfx            Create a self-extracting Linux executable: a small extractor followed
+                       by an archive, which is a .zip unless the destination names a tar
+                       type, e.g. tools.tar.xz
+      --sfx-command    Command the self-extracting archive runs in its target directory
+      --sfx-stub       Extractor to use instead of the built-in linux/amd64 one, e.g. a
+                       GOARCH=arm64 build of futile's cmd/sfx
+      --platform       Image to flatten from a multi-platform index, e.g. linux/arm64/v8
+                       (default: linux on this machine's architecture)
   -h, --help           Show help message
   -v, --version        Show version information`)
 }
@@ -28,9 +74,38 @@ func printVersion() {
 
 func main() {
 	// Declare flags
-	operation := flag.String("o", "", "Operation: 'create' or 'extract'")
+	operation := flag.String("o", "", "Operation: 'create', 'extract', 'list', 'info', 'train' or 'flatten'")
 	destination := flag.String("d", "", "Destination directory or path for extraction or archive creation")
 	password := flag.String("p", "", "Password for password-protected archives")
+	level := flag.Int("l", archive.DefaultLevel, "Compression level for compressed formats")
+	threads := flag.Int("threads", 1, "Compression threads for .zst archives")
+	long := flag.Bool("long", false, "Long-distance matching for .zst archives")
+	dict := flag.String("dict", "", "Zstandard dictionary file")
+	dictSize := flag.Int("dict-size", archive.DefaultDictSize, "Size of the dictionary built by 'train'")
+	blockSize := flag.String("block-size", "", "LZ4 block size: 64K, 256K, 1M or 4M")
+	blockChecksum := flag.Bool("block-checksum", false, "Add a checksum to every LZ4 block")
+	noContentChecksum := flag.Bool("no-content-checksum", false, "Omit the LZ4 content checksum")
+	dependentBlocks := flag.Bool("dependent-blocks", false, "Let LZ4 blocks reference earlier blocks")
+	tarFormat := flag.String("tar-format", "", "Flavor of tar archives: ustar, pax or gnu")
+	cpioFormat := flag.String("cpio-format", "newc", "Header format for .cpio archives: newc, crc or odc")
+	arFormat := flag.String("ar-format", "gnu", "Long name style for .a archives: gnu or bsd")
+	deterministic := flag.Bool("deterministic", false, "Zero timestamps and owners for reproducible archives")
+	control := flag.String("control", "", "Control file for .deb packages")
+	debCompression := flag.String("deb-compression", "xz", "Compression of .deb tarballs: gz, xz, zst or none")
+	volumeLabel := flag.String("volume-label", "", "Volume label of .iso images")
+	rarFormat := flag.Int("rar-format", 0, "RAR format version of .rar archives: 4 or 5")
+	recovery := flag.String("recovery-record", "", "Recovery record percentage of .rar archives")
+	volumeSize := flag.String("volume-size", "", "Split .zip, .7z, .rar and tar archives into volumes of this size")
+	lock := flag.Bool("lock", false, "Lock .rar archives against further changes")
+	zip64 := flag.String("zip64", "auto", "Zip64 records in .zip archives: auto, always or never")
+	zipEncryption := flag.String("zip-encryption", "aes256", "AES key size of password-protected .zip archives")
+	legacyZipCrypto := flag.Bool("legacy-zipcrypto", false, "Encrypt .zip archives with the weak ZipCrypto instead of AES")
+	mainClass := flag.String("main-class", "", "Main-Class of Java archives")
+	classPath := flag.String("class-path", "", "Class-Path of Java archives")
+	sfx := flag.Bool("sfx", false, "Create a self-extracting Linux executable")
+	sfxCommand := flag.String("sfx-command", "", "Command the self-extracting archive runs once extracted")
+	sfxStub := flag.String("sfx-stub", "", "Extractor to use instead of the built-in linux/amd64 one")
+	platform := flag.String("platform", "", "Platform of the image to flatten, e.g. linux/arm64")
 	help := flag.Bool("h", false, "Show help message")
 	version := flag.Bool("v", false, "Show version information")
 
@@ -41,6 +116,13 @@ func main() {
 		return nil
 	})
 
+	// Manifest attributes may be given more than once
+	var manifestAttrs []string
+	flag.Func("manifest-attr", "Manifest attribute of Java archives as Name=Value", func(s string) error {
+		manifestAttrs = append(manifestAttrs, s)
+		return nil
+	})
+
 	// Parse the command line arguments
 	flag.Parse()
 
@@ -59,16 +141,17 @@ func main() {
 		log.Fatal("Operation (-o) is required")
 	}
 
-	// Ensure either create or extract operation is chosen
-	if *operation != "extract" && *operation != "create" {
-		log.Fatal("Invalid operation. Use 'extract' or 'create'.")
+	// Ensure a known operation is chosen
+	if *operation != "extract" && *operation != "create" && *operation != "list" && *operation != "info" &&
+		*operation != "train" && *operation != "flatten" {
+		log.Fatal("Invalid operation. Use 'extract', 'create', 'list', 'info', 'train' or 'flatten'.")
 	}
 
 	// Validate flags based on the selected operation
-	if *operation == "create" {
-		// For 'create' operation, ensure destination (-d) and input files (-i) are provided
+	if *operation == "create" || *operation == "train" || *operation == "flatten" {
+		// For 'create', 'train' and 'flatten' operations, ensure destination (-d) and input files (-i) are provided
 		if *destination == "" || len(inputFiles) == 0 {
-			log.Fatal("Both destination (-d) and at least one input file (-i) are required for creating an archive")
+			log.Fatalf("Both destination (-d) and at least one input file (-i) are required for '%s'", *operation)
 		}
 	} else if *operation == "extract" {
 		// For 'extract' operation, ensure archive input (-i) is provided
@@ -83,6 +166,85 @@ func main() {
 			// Set destination to the same directory as the archive
 			*destination = dir
 		}
+	} else if *operation == "list" || *operation == "info" {
+		// For 'list' and 'info' operations, ensure archive input (-i) is provided
+		if len(inputFiles) == 0 {
+			log.Fatalf("Archive input (-i) is required for '%s'", *operation)
+		}
+	}
+
+	if (*sfxCommand != "" || *sfxStub != "") && !*sfx {
+		log.Fatal("--sfx-command and --sfx-stub only apply with --sfx")
+	}
+	if *legacyZipCrypto && *password == "" {
+		log.Fatal("--legacy-zipcrypto needs a password (-p)")
+	}
+
+	// Parse sizes given with unit suffixes
+	var blockBytes int64
+	if *blockSize != "" {
+		var err error
+		if blockBytes, err = utils.ParseSize(*blockSize); err != nil {
+			log.Fatalf("Invalid --block-size: %v", err)
+		}
+	}
+
+	var volumeBytes int64
+	if *volumeSize != "" {
+		var err error
+		if volumeBytes, err = utils.ParseSize(*volumeSize); err != nil || volumeBytes == 0 {
+			log.Fatalf("Invalid --volume-size: %q", *volumeSize)
+		}
+	}
+	var recoveryPercent int
+	if *recovery != "" {
+		var err error
+		if recoveryPercent, err = strconv.Atoi(strings.TrimSuffix(*recovery, "%")); err != nil || recoveryPercent <= 0 {
+			log.Fatalf("Invalid --recovery-record: %q", *recovery)
+		}
+	}
+
+	// Collect the options shared by both operations
+	opts := archive.Options{
+		Password: *password,
+		Level:    *level,
+		Threads:  *threads,
+		Long:     *long,
+		Dict:     *dict,
+
+		BlockSize:         int(blockBytes),
+		BlockChecksum:     *blockChecksum,
+		NoContentChecksum: *noContentChecksum,
+		DependentBlocks:   *dependentBlocks,
+
+		TarFormat:     *tarFormat,
+		CpioFormat:    *cpioFormat,
+		ArFormat:      *arFormat,
+		Deterministic: *deterministic,
+
+		Control:        *control,
+		DebCompression: *debCompression,
+
+		VolumeLabel: *volumeLabel,
+
+		RarFormat:  *rarFormat,
+		Recovery:   recoveryPercent,
+		VolumeSize: volumeBytes,
+		Lock:       *lock,
+
+		Zip64:         *zip64,
+		ZipEncryption: *zipEncryption,
+		ZipCrypto:     *legacyZipCrypto,
+
+		MainClass:     *mainClass,
+		ClassPath:     *classPath,
+		ManifestAttrs: manifestAttrs,
+
+		SFX:        *sfx,
+		SFXCommand: *sfxCommand,
+		SFXStub:    *sfxStub,
+
+		Platform: *platform,
 	}
 
 	// Handle the operation based on user input
@@ -90,10 +252,22 @@ func main() {
 	switch *operation {
 	case "extract":
 		// Handle extraction with password
-		err = archive.HandleExtract(inputFiles[0], *destination, *password)
+		err = archive.HandleExtract(inputFiles[0], *destination, opts)
 	case "create":
 		// Handle creation with password
-		err = archive.HandleCreate(inputFiles, *destination, *password)
+		err = archive.HandleCreate(inputFiles, *destination, opts)
+	case "list":
+		// Print the members of the archive
+		err = archive.HandleList(inputFiles[0], opts)
+	case "info":
+		// Print the package metadata
+		err = archive.HandleInfo(inputFiles[0], opts)
+	case "train":
+		// Build a Zstandard dictionary from the sample inputs
+		err = archive.HandleTrain(inputFiles, *destination, *dictSize)
+	case "flatten":
+		// Merge the layers of a container image into one file system
+		err = archive.HandleFlatten(inputFiles[0], *destination, opts)
 	}
 
 	// If there was an error, log and exit
@@ -101,5 +275,8 @@ func main() {
 		log.Fatalf("Error: %v", err)
 	}
 
-	fmt.Println("Operation completed successfully")
+	// Keep listings free of status messages
+	if *operation != "list" && *operation != "info" {
+		fmt.Println("Operation completed successfully")
+	}
 }
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SafeJoin joins an archive member name onto dest. Leading slashes are
+// dropped, and names are rejected that would resolve outside dest, either as
+// written or through a symlink that an earlier member left in one of their
+// parent directories. The last element is not followed: extractors replace
+// whatever is there with MakeDir or CreateFile.
+func SafeJoin(dest, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash("/" + strings.TrimLeft(name, "/")))
+	if clean == string(filepath.Separator) {
+		return dest, nil
+	}
+	target := filepath.Join(dest, clean)
+	rel, err := filepath.Rel(dest, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	if err := checkParents(dest, rel); err != nil {
+		return "", fmt.Errorf("illegal path in archive: %s: %w", name, err)
+	}
+	return target, nil
+}
+
+// SafeLinkTarget joins the target of a hard link onto dest like SafeJoin,
+// also rejecting a target that is itself a symlink leading outside dest.
+func SafeLinkTarget(dest, name string) (string, error) {
+	target, err := SafeJoin(dest, name)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := checkSymlink(dest, target); err != nil {
+			return "", fmt.Errorf("illegal link target in archive: %s: %w", name, err)
+		}
+	}
+	return target, nil
+}
+
+// checkParents makes sure that the parent directories of rel, a path
+// relative to dest, stay inside dest where they already exist. Missing ones
+// are created as real directories.
+func checkParents(dest, rel string) error {
+	parent := filepath.Dir(rel)
+	if parent == "." {
+		return nil
+	}
+	dir := dest
+	for _, part := range strings.Split(parent, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if err := checkSymlink(dest, dir); err != nil {
+			return err
+		}
+		if dir, err = filepath.EvalSymlinks(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSymlink reports an error unless the symlink at path resolves inside dest.
+func checkSymlink(dest, path string) error {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("%s is a broken symlink", path)
+	}
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s links outside the destination", path)
+	}
+	return nil
+}
+
+// MakeDir creates the directory of an archive member, replacing a file or
+// symlink that an earlier member left at path so that nothing is written
+// through it.
+func MakeDir(path string) error {
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", path, err)
+		}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", path, err)
+	}
+	return nil
+}
+
+// CreateFile creates the file of an archive member for writing. An existing
+// file or symlink at path is replaced rather than written through.
+func CreateFile(path string, perm os.FileMode) (*os.File, error) {
+	_ = os.Remove(path)
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+}
+
+// SetModeAndTime applies the mode of an archive member to an extracted file,
+// with the setuid, setgid and sticky bits that OpenFile leaves out and
+// without the umask, and its modification time unless that is zero.
+func SetModeAndTime(path string, mode os.FileMode, modTime time.Time) error {
+	if err := os.Chmod(path, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", path, err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			return fmt.Errorf("failed to set modification time of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// DirMetadata collects the modes and modification times of extracted
+// directories, which are applied once their contents are written: writing
+// a file would change the time, and a read-only mode would prevent it.
+type DirMetadata struct {
+	dirs []dirMetadata
+}
+
+type dirMetadata struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// Add records the metadata of the directory at path.
+func (d *DirMetadata) Add(path string, mode os.FileMode, modTime time.Time) {
+	d.dirs = append(d.dirs, dirMetadata{path, mode, modTime})
+}
+
+// Apply sets the recorded metadata, deepest directories first since archives
+// list parents before their contents. Directories that a later member
+// replaced are skipped rather than followed.
+func (d *DirMetadata) Apply() error {
+	for i := len(d.dirs) - 1; i >= 0; i-- {
+		dir := d.dirs[i]
+		if info, err := os.Lstat(dir.path); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := SetModeAndTime(dir.path, dir.mode, dir.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// XattrErrors counts the extended attributes that could not be set on
+// extracted files, keeping the first failure to report.
+type XattrErrors struct {
+	count int
+	first error
+}
+
+// Set sets the extended attribute name of the archive member extracted to
+// path, counting a failure rather than returning it.
+func (e *XattrErrors) Set(path, member, name string, value []byte) {
+	if err := Setxattr(path, name, value); err != nil {
+		if e.count == 0 {
+			e.first = fmt.Errorf("%s on %s: %w", name, member, err)
+		}
+		e.count++
+	}
+}
+
+// Report warns about the extended attributes that could not be set. This is
+// not fatal, since namespaces other than user. usually need root.
+func (e *XattrErrors) Report() {
+	if e.count > 0 {
+		fmt.Printf("Warning: could not set %d extended attributes, the first being %v\n", e.count, e.first)
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import "io/fs"
+
+// FileID identifies a file on disk for hard link detection and records the
+// ownership and device numbers archive formats store.
+type FileID struct {
+	Dev, Ino, Nlink uint64
+	Uid, Gid        int
+	Rdev            uint64
+}
+
+// Major and Minor split a Linux device number.
+func Major(dev uint64) uint32 {
+	return uint32((dev>>8)&0xfff | (dev>>32)&^0xfff)
+}
+
+func Minor(dev uint64) uint32 {
+	return uint32(dev&0xff | (dev>>12)&^0xff)
+}
+
+// MakeDev combines a major and minor number into a Linux device number.
+func MakeDev(major, minor uint32) uint64 {
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+}
+
+// UnixMode converts the type and permissions of a special file for Mknod.
+func UnixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m&fs.ModeCharDevice != 0:
+		mode |= 0020000
+	case m&fs.ModeDevice != 0:
+		mode |= 0060000
+	case m&fs.ModeNamedPipe != 0:
+		mode |= 0010000
+	case m&fs.ModeSocket != 0:
+		mode |= 0140000
+	}
+	return mode
+}
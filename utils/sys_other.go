@@ -0,0 +1,19 @@
+//go:build !unix
+
+package utils
+
+import (
+	"fmt"
+	"os"
+)
+
+// FileIDs returns the device, inode, link count, owner and special device
+// number of a file, when the platform provides them.
+func FileIDs(info os.FileInfo) (ids FileID, ok bool) {
+	return FileID{}, false
+}
+
+// Mknod creates a device node, FIFO or socket with the given Unix mode.
+func Mknod(path string, mode uint32, major, minor uint32) error {
+	return fmt.Errorf("device nodes are not supported on this platform")
+}
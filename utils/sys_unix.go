@@ -0,0 +1,30 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileIDs returns the device, inode, link count, owner and special device
+// number of a file, when the platform provides them.
+func FileIDs(info os.FileInfo) (ids FileID, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, false
+	}
+	return FileID{
+		Dev:   uint64(st.Dev),
+		Ino:   uint64(st.Ino),
+		Nlink: uint64(st.Nlink),
+		Uid:   int(st.Uid),
+		Gid:   int(st.Gid),
+		Rdev:  uint64(st.Rdev),
+	}, true
+}
+
+// Mknod creates a device node, FIFO or socket with the given Unix mode.
+func Mknod(path string, mode uint32, major, minor uint32) error {
+	return syscall.Mknod(path, mode, int(MakeDev(major, minor)))
+}
@@ -1,24 +1,179 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
-// DetermineArchiveType determines the type of archive based on the file extension
+// compoundExtensions lists multi-part and shorthand extensions, checked before the
+// last extension so that names like "release.tar.gz" are not mistaken for plain ".gz".
+var compoundExtensions = []struct {
+	suffix      string
+	archiveType string
+}{
+	{".tar.gz", "tar.gz"},
+	{".tgz", "tar.gz"},
+	{".tar.bz2", "tar.bz2"},
+	{".tbz2", "tar.bz2"},
+	{".tbz", "tar.bz2"},
+	{".tar.xz", "tar.xz"},
+	{".txz", "tar.xz"},
+	{".tar.zst", "tar.zst"},
+	{".tzst", "tar.zst"},
+	{".tar.lz4", "tar.lz4"},
+}
+
+// splitExtension matches the extensions of the volumes before the last of
+// split ZIP archives (".z01") and old style RAR archives (".r00").
+var splitExtension = regexp.MustCompile(`^\.([zr])\d{2,}$`)
+
+// DetermineArchiveType determines the type of archive based on the file extension.
+// Volumes of split archives, such as "backup.tar.001" or "photos.z01", have the
+// type of the archive they belong to.
 func DetermineArchiveType(filePath string) (string, error) {
-	ext := strings.ToLower(filepath.Ext(filePath))
+	if base, _, ok := SplitVolumeName(filePath); ok {
+		filePath = base
+	}
+	name := strings.ToLower(filepath.Base(filePath))
+	if m := splitExtension.FindStringSubmatch(filepath.Ext(name)); m != nil {
+		if m[1] == "z" {
+			return "zip", nil
+		}
+		return "rar", nil
+	}
+	for _, compound := range compoundExtensions {
+		if strings.HasSuffix(name, compound.suffix) {
+			return compound.archiveType, nil
+		}
+	}
+
+	ext := filepath.Ext(name)
 	switch ext {
 	case ".zip":
 		return "zip", nil
+	case ".jar", ".war", ".ear":
+		return "jar", nil
 	case ".tar":
 		return "tar", nil
 	case ".rar":
 		return "rar", nil
 	case ".7z":
 		return "7z", nil
+	case ".gz":
+		return "gz", nil
+	case ".bz2":
+		return "bz2", nil
+	case ".xz":
+		return "xz", nil
+	case ".zst":
+		return "zst", nil
+	case ".lz4":
+		return "lz4", nil
+	case ".cpio":
+		return "cpio", nil
+	case ".a", ".ar":
+		return "ar", nil
+	case ".deb":
+		return "deb", nil
+	case ".rpm":
+		return "rpm", nil
+	case ".iso":
+		return "iso", nil
+	case ".cab":
+		return "cab", nil
+	case ".squashfs", ".sqfs", ".snap", ".appimage":
+		return "squashfs", nil
 	default:
 		return "", fmt.Errorf("unsupported or unknown archive type")
 	}
 }
+
+// DecompressedName returns the base name of a compressed file without its
+// compression extension, or with ".out" appended if it lacks that extension.
+func DecompressedName(filePath, ext string) string {
+	base := filepath.Base(filePath)
+	if len(base) > len(ext) && strings.EqualFold(base[len(base)-len(ext):], ext) {
+		return base[:len(base)-len(ext)]
+	}
+	return base + ".out"
+}
+
+// DecompressedPath returns where a single-file decompressor writes its
+// output. An existing directory, or a path ending in a separator, receives
+// the file under name; any other dest names the output file itself.
+func DecompressedPath(dest, name string) (string, error) {
+	info, err := os.Stat(dest)
+	if err == nil && info.IsDir() || strings.HasSuffix(dest, "/") || strings.HasSuffix(dest, string(filepath.Separator)) {
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return "", fmt.Errorf("failed to create destination directory: %w", err)
+		}
+		return filepath.Join(dest, name), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory of %s: %w", dest, err)
+	}
+	return dest, nil
+}
+
+// CreateDecompressed creates the output file of a single-file decompressor.
+// It refuses to replace an existing file, which also keeps the output from
+// truncating src while it is being read.
+func CreateDecompressed(src, destPath string) (*os.File, error) {
+	if srcInfo, err := os.Stat(src); err == nil {
+		if destInfo, err := os.Stat(destPath); err == nil && os.SameFile(srcInfo, destInfo) {
+			return nil, fmt.Errorf("output file %s is the input file", destPath)
+		}
+	}
+	out, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("output file %s already exists", destPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file %s: %w", destPath, err)
+	}
+	return out, nil
+}
+
+// ParseSize parses a byte count with an optional binary unit suffix, such as
+// "64K", "4M" or "1G".
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// CopyModTime gives dest the modification time of src, as single-file
+// compressors do for formats that cannot store it themselves.
+func CopyModTime(src, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
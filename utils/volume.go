@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// numberedVolume matches the volumes of archives split into "name.001",
+// "name.002" and so on.
+var numberedVolume = regexp.MustCompile(`^(.+)\.(\d{3,})$`)
+
+// SplitVolumeName returns the archive name of a numbered volume, such as
+// "backup.tar.gz" for "backup.tar.gz.002", and its number.
+func SplitVolumeName(filePath string) (string, int, bool) {
+	m := numberedVolume.FindStringSubmatch(filePath)
+	if m == nil {
+		return filePath, 0, false
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil || n == 0 {
+		return filePath, 0, false
+	}
+	return m[1], n, true
+}
+
+// NumberedVolume names volume n, from one, of a numbered series.
+func NumberedVolume(base string, n int) string {
+	return fmt.Sprintf("%s.%03d", base, n)
+}
+
+// Volumes returns every volume of the numbered series that filePath belongs
+// to, in order, or just filePath when it is not a numbered volume. Gaps in
+// the series are reported by the name of the first missing volume; a
+// missing last volume can only be noticed by the archive format.
+func Volumes(filePath string) ([]string, error) {
+	base, _, ok := SplitVolumeName(filePath)
+	if !ok {
+		return []string{filePath}, nil
+	}
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the volumes of %s: %w", base, err)
+	}
+	prefix := filepath.Base(base) + "."
+	var numbers []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if b, n, ok := SplitVolumeName(name); ok && b+"." == prefix {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	paths := make([]string, 0, len(numbers))
+	for i, n := range numbers {
+		if n != i+1 {
+			return nil, fmt.Errorf("volume %s is missing", NumberedVolume(base, i+1))
+		}
+		paths = append(paths, NumberedVolume(base, n))
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("volume %s is missing", NumberedVolume(base, 1))
+	}
+	return paths, nil
+}
+
+// VolumeReader reads a series of volumes as one file.
+type VolumeReader struct {
+	files  []*os.File
+	starts []int64 // offset of each volume
+	size   int64
+}
+
+// OpenVolumes opens the volumes at paths, in order.
+func OpenVolumes(paths []string) (*VolumeReader, error) {
+	v := &VolumeReader{}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			_ = v.Close()
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			_ = v.Close()
+			return nil, err
+		}
+		v.files = append(v.files, f)
+		v.starts = append(v.starts, v.size)
+		v.size += info.Size()
+	}
+	return v, nil
+}
+
+// Size returns the combined size of the volumes.
+func (v *VolumeReader) Size() int64 {
+	return v.size
+}
+
+// Starts returns the offset at which each volume begins.
+func (v *VolumeReader) Starts() []int64 {
+	return v.starts
+}
+
+// ReadAt reads from the volumes as if they were concatenated.
+func (v *VolumeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= v.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(v.starts), func(i int) bool { return v.starts[i] > off }) - 1
+	n := 0
+	for ; i >= 0 && i < len(v.files) && n < len(p); i++ {
+		end := v.size
+		if i+1 < len(v.starts) {
+			end = v.starts[i+1]
+		}
+		want := min(int64(len(p)-n), end-off)
+		m, err := v.files[i].ReadAt(p[n:n+int(want)], off-v.starts[i])
+		n += m
+		off += int64(m)
+		if err != nil && !(err == io.EOF && int64(m) == want) {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close closes every volume.
+func (v *VolumeReader) Close() error {
+	var err error
+	for _, f := range v.files {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	v.files = nil
+	return err
+}
+
+// VolumeWriter writes a series of volumes of at most size bytes each,
+// creating them as needed.
+type VolumeWriter struct {
+	size  int64
+	name  func(n int) string // names volume n, from one
+	paths []string
+	f     *os.File
+	n     int64 // bytes in the current volume
+}
+
+// NewVolumeWriter returns a VolumeWriter that names its volumes with name.
+func NewVolumeWriter(size int64, name func(n int) string) *VolumeWriter {
+	return &VolumeWriter{size: size, name: name}
+}
+
+// Write writes p, moving on to new volumes as the current one fills up.
+func (w *VolumeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.f == nil || w.n >= w.size {
+			if err := w.next(); err != nil {
+				return written, err
+			}
+		}
+		chunk := p[:min(int64(len(p)), w.size-w.n)]
+		m, err := w.f.Write(chunk)
+		written += m
+		w.n += int64(m)
+		if err != nil {
+			return written, err
+		}
+		p = p[m:]
+	}
+	return written, nil
+}
+
+// Reserve moves on to a new volume unless n more bytes fit in the current
+// one, for records that must not be split. Records larger than a whole
+// volume are split regardless.
+func (w *VolumeWriter) Reserve(n int64) error {
+	if w.f != nil && w.n > 0 && w.n+n > w.size && n <= w.size {
+		return w.next()
+	}
+	return nil
+}
+
+// Position returns the volume the next byte goes to, from zero, and its
+// offset in that volume.
+func (w *VolumeWriter) Position() (int, int64) {
+	if w.f == nil || w.n >= w.size {
+		return len(w.paths), 0
+	}
+	return len(w.paths) - 1, w.n
+}
+
+// Paths returns the names of the volumes written so far.
+func (w *VolumeWriter) Paths() []string {
+	return w.paths
+}
+
+func (w *VolumeWriter) next() error {
+	if err := w.closeCurrent(); err != nil {
+		return err
+	}
+	path := w.name(len(w.paths) + 1)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w.f, w.n = f, 0
+	w.paths = append(w.paths, path)
+	return nil
+}
+
+func (w *VolumeWriter) closeCurrent() error {
+	if w.f == nil {
+		return nil
+	}
+	f := w.f
+	w.f = nil
+	return f.Close()
+}
+
+// Close closes the last volume, creating it if nothing was written, and
+// removes volumes left over from an earlier and longer series of the same
+// name, which would otherwise be read as part of this one.
+func (w *VolumeWriter) Close() error {
+	if len(w.paths) == 0 {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	if err := w.closeCurrent(); err != nil {
+		return err
+	}
+	for n := len(w.paths) + 1; ; n++ {
+		if err := os.Remove(w.name(n)); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+	}
+}
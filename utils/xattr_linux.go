@@ -0,0 +1,61 @@
+//go:build linux
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+)
+
+// Setxattr sets an extended attribute of a file, following symbolic links.
+func Setxattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
+
+// Xattrs returns the extended attributes of a file, following symbolic links.
+func Xattrs(path string) (map[string][]byte, error) {
+	names, err := readXattr(func(b []byte) (int, error) { return syscall.Listxattr(path, b) })
+	if err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	values := make(map[string][]byte)
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := readXattr(func(b []byte) (int, error) { return syscall.Getxattr(path, string(name), b) })
+		if errors.Is(err, syscall.ENODATA) {
+			// Removed since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[string(name)] = value
+	}
+	return values, nil
+}
+
+// readXattr calls get with a buffer large enough for its result, which may
+// grow between asking for the size and reading.
+func readXattr(get func([]byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := get(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		b := make([]byte, size)
+		n, err := get(b)
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
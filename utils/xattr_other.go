@@ -0,0 +1,15 @@
+//go:build !linux
+
+package utils
+
+import "fmt"
+
+// Setxattr sets an extended attribute of a file, following symbolic links.
+func Setxattr(path, name string, value []byte) error {
+	return fmt.Errorf("extended attributes are not supported on this platform")
+}
+
+// Xattrs returns the extended attributes of a file, following symbolic links.
+func Xattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}